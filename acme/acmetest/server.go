@@ -0,0 +1,461 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package acmetest provides an in-process ACME server, to be used in tests
+// of code obtaining certificates with the acme package.
+package acmetest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tsuru/tsuru/acme"
+)
+
+// Server is a fake ACME server. Challenges are validated synchronously when
+// the client asks for them, requesting the challenge path on ValidationURL
+// with the Host header set to the domain being validated, as a router would
+// receive it.
+type Server struct {
+	// URL is the directory URL of the server.
+	URL string
+	// ValidationURL is the base URL used to validate HTTP-01 challenges.
+	// When empty, challenges are validated requesting the domain directly.
+	ValidationURL string
+	// Validity is the validity of issued certificates, defaults to 90 days.
+	Validity time.Duration
+
+	server  *httptest.Server
+	caKey   *ecdsa.PrivateKey
+	caCert  *x509.Certificate
+	mu      sync.Mutex
+	counter int
+	nonces  map[string]bool
+	keys    map[string]*ecdsa.PublicKey
+	orders  map[string]*order
+	authzs  map[string]*authorization
+	certs   map[string][]byte
+	issued  []string
+}
+
+type order struct {
+	Status         string   `json:"status"`
+	Domain         string   `json:"-"`
+	Account        string   `json:"-"`
+	Authorizations []string `json:"authorizations"`
+	Finalize       string   `json:"finalize"`
+	Certificate    string   `json:"certificate,omitempty"`
+}
+
+type challenge struct {
+	Type   string      `json:"type"`
+	URL    string      `json:"url"`
+	Token  string      `json:"token"`
+	Status string      `json:"status"`
+	Error  *acme.Error `json:"error,omitempty"`
+}
+
+type authorization struct {
+	Status     string            `json:"status"`
+	Identifier map[string]string `json:"identifier"`
+	Challenges []challenge       `json:"challenges"`
+	Account    string            `json:"-"`
+}
+
+type jws struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+// NewServer starts a new fake ACME server.
+func NewServer() (*Server, error) {
+	s := &Server{
+		nonces: make(map[string]bool),
+		keys:   make(map[string]*ecdsa.PublicKey),
+		orders: make(map[string]*order),
+		authzs: make(map[string]*authorization),
+		certs:  make(map[string][]byte),
+	}
+	var err error
+	s.caKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "acmetest CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &s.caKey.PublicKey, s.caKey)
+	if err != nil {
+		return nil, err
+	}
+	s.caCert, err = x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	s.server = httptest.NewServer(s)
+	s.URL = s.server.URL + "/directory"
+	return s, nil
+}
+
+// Close stops the server.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Issued returns the domains of the certificates issued by the server.
+func (s *Server) Issued() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.issued...)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Replay-Nonce", s.newNonce())
+	path := r.URL.Path
+	if path == "/directory" {
+		json.NewEncoder(w).Encode(map[string]string{
+			"newNonce":   s.server.URL + "/new-nonce",
+			"newAccount": s.server.URL + "/new-account",
+			"newOrder":   s.server.URL + "/new-order",
+		})
+		return
+	}
+	if path == "/new-nonce" {
+		return
+	}
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	account, payload, err := s.verify(r)
+	if err != nil {
+		s.problem(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case path == "/new-account":
+		w.Header().Set("Location", account)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{"status": "valid"})
+	case path == "/new-order":
+		s.newOrder(w, account, payload)
+	case len(parts) == 2 && parts[0] == "order":
+		s.writeOrder(w, parts[1], account)
+	case len(parts) == 2 && parts[0] == "authz":
+		s.writeAuthz(w, parts[1], account)
+	case len(parts) == 2 && parts[0] == "challenge":
+		s.validateChallenge(w, parts[1], account)
+	case len(parts) == 2 && parts[0] == "finalize":
+		s.finalize(w, parts[1], account, payload)
+	case len(parts) == 2 && parts[0] == "cert":
+		cert, ok := s.certs[parts[1]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(cert)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *Server) nextID() string {
+	s.counter++
+	return fmt.Sprintf("%d", s.counter)
+}
+
+func (s *Server) newNonce() string {
+	nonce := "nonce" + s.nextID()
+	s.nonces[nonce] = true
+	return nonce
+}
+
+func (s *Server) problem(w http.ResponseWriter, code int, kind, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(acme.Error{Type: "urn:ietf:params:acme:error:" + kind, Detail: detail, Status: code})
+}
+
+// verify checks the JWS signature of the request, returning the account URL
+// of the signer and the decoded payload.
+func (s *Server) verify(r *http.Request) (string, []byte, error) {
+	var req jws
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return "", nil, err
+	}
+	protectedData, err := base64.RawURLEncoding.DecodeString(req.Protected)
+	if err != nil {
+		return "", nil, err
+	}
+	var protected struct {
+		Nonce string            `json:"nonce"`
+		URL   string            `json:"url"`
+		JWK   map[string]string `json:"jwk"`
+		Kid   string            `json:"kid"`
+	}
+	err = json.Unmarshal(protectedData, &protected)
+	if err != nil {
+		return "", nil, err
+	}
+	if !s.nonces[protected.Nonce] {
+		return "", nil, fmt.Errorf("invalid nonce %q", protected.Nonce)
+	}
+	delete(s.nonces, protected.Nonce)
+	if protected.URL != s.server.URL+r.URL.Path {
+		return "", nil, fmt.Errorf("invalid url %q", protected.URL)
+	}
+	account := protected.Kid
+	key := s.keys[account]
+	if protected.JWK != nil {
+		key, err = parseJWK(protected.JWK)
+		if err != nil {
+			return "", nil, err
+		}
+		account = s.accountFor(key)
+	}
+	if key == nil {
+		return "", nil, fmt.Errorf("unknown account %q", account)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(req.Signature)
+	if err != nil || len(signature) != 64 {
+		return "", nil, fmt.Errorf("invalid signature")
+	}
+	hash := sha256.Sum256([]byte(req.Protected + "." + req.Payload))
+	rInt := new(big.Int).SetBytes(signature[:32])
+	sInt := new(big.Int).SetBytes(signature[32:])
+	if !ecdsa.Verify(key, hash[:], rInt, sInt) {
+		return "", nil, fmt.Errorf("invalid signature")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(req.Payload)
+	if err != nil {
+		return "", nil, err
+	}
+	return account, payload, nil
+}
+
+func (s *Server) accountFor(key *ecdsa.PublicKey) string {
+	for url, k := range s.keys {
+		if k.X.Cmp(key.X) == 0 && k.Y.Cmp(key.Y) == 0 {
+			return url
+		}
+	}
+	url := s.server.URL + "/account/" + s.nextID()
+	s.keys[url] = key
+	return url
+}
+
+func parseJWK(jwk map[string]string) (*ecdsa.PublicKey, error) {
+	if jwk["kty"] != "EC" || jwk["crv"] != "P-256" {
+		return nil, fmt.Errorf("unsupported key")
+	}
+	x, err := base64.RawURLEncoding.DecodeString(jwk["x"])
+	if err != nil {
+		return nil, err
+	}
+	y, err := base64.RawURLEncoding.DecodeString(jwk["y"])
+	if err != nil {
+		return nil, err
+	}
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+}
+
+func (s *Server) newOrder(w http.ResponseWriter, account string, payload []byte) {
+	var req struct {
+		Identifiers []map[string]string `json:"identifiers"`
+	}
+	err := json.Unmarshal(payload, &req)
+	if err != nil || len(req.Identifiers) != 1 {
+		s.problem(w, http.StatusBadRequest, "malformed", "exactly one identifier is supported")
+		return
+	}
+	domain := req.Identifiers[0]["value"]
+	id := s.nextID()
+	authz := &authorization{
+		Status:     "pending",
+		Identifier: req.Identifiers[0],
+		Account:    account,
+		Challenges: []challenge{{
+			Type:   "http-01",
+			URL:    s.server.URL + "/challenge/" + id,
+			Token:  "token" + id,
+			Status: "pending",
+		}},
+	}
+	s.authzs[id] = authz
+	o := &order{
+		Status:         "pending",
+		Domain:         domain,
+		Account:        account,
+		Authorizations: []string{s.server.URL + "/authz/" + id},
+		Finalize:       s.server.URL + "/finalize/" + id,
+	}
+	s.orders[id] = o
+	w.Header().Set("Location", s.server.URL+"/order/"+id)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(o)
+}
+
+func (s *Server) writeOrder(w http.ResponseWriter, id, account string) {
+	o, ok := s.orders[id]
+	if !ok || o.Account != account {
+		s.problem(w, http.StatusNotFound, "malformed", "order not found")
+		return
+	}
+	json.NewEncoder(w).Encode(o)
+}
+
+func (s *Server) writeAuthz(w http.ResponseWriter, id, account string) {
+	authz, ok := s.authzs[id]
+	if !ok || authz.Account != account {
+		s.problem(w, http.StatusNotFound, "malformed", "authorization not found")
+		return
+	}
+	json.NewEncoder(w).Encode(authz)
+}
+
+func (s *Server) validateChallenge(w http.ResponseWriter, id, account string) {
+	authz, ok := s.authzs[id]
+	if !ok || authz.Account != account {
+		s.problem(w, http.StatusNotFound, "malformed", "challenge not found")
+		return
+	}
+	chal := &authz.Challenges[0]
+	thumbprint, err := acme.Thumbprint(s.keys[account])
+	if err != nil {
+		s.problem(w, http.StatusInternalServerError, "serverInternal", err.Error())
+		return
+	}
+	expected := chal.Token + "." + thumbprint
+	domain := authz.Identifier["value"]
+	got, err := s.fetchChallenge(domain, chal.Token)
+	if err == nil && got != expected {
+		err = fmt.Errorf("invalid response for challenge %q: %q", chal.Token, got)
+	}
+	if err != nil {
+		chal.Status = "invalid"
+		chal.Error = &acme.Error{Type: "urn:ietf:params:acme:error:unauthorized", Detail: err.Error()}
+		authz.Status = "invalid"
+		s.orders[id].Status = "invalid"
+	} else {
+		chal.Status = "valid"
+		authz.Status = "valid"
+		s.orders[id].Status = "ready"
+	}
+	json.NewEncoder(w).Encode(chal)
+}
+
+func (s *Server) fetchChallenge(domain, token string) (string, error) {
+	baseURL := s.ValidationURL
+	if baseURL == "" {
+		baseURL = "http://" + domain
+	}
+	req, err := http.NewRequest("GET", strings.TrimRight(baseURL, "/")+acme.ChallengePath+token, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Host = domain
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer rsp.Body.Close()
+	data, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return "", err
+	}
+	if rsp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code %d fetching challenge %q", rsp.StatusCode, token)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (s *Server) finalize(w http.ResponseWriter, id, account string, payload []byte) {
+	o, ok := s.orders[id]
+	if !ok || o.Account != account {
+		s.problem(w, http.StatusNotFound, "malformed", "order not found")
+		return
+	}
+	if o.Status != "ready" {
+		s.problem(w, http.StatusForbidden, "orderNotReady", "order is not ready")
+		return
+	}
+	var req struct {
+		CSR string `json:"csr"`
+	}
+	err := json.Unmarshal(payload, &req)
+	if err != nil {
+		s.problem(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+	der, err := base64.RawURLEncoding.DecodeString(req.CSR)
+	if err != nil {
+		s.problem(w, http.StatusBadRequest, "badCSR", err.Error())
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err == nil {
+		err = csr.CheckSignature()
+	}
+	if err != nil {
+		s.problem(w, http.StatusBadRequest, "badCSR", err.Error())
+		return
+	}
+	if len(csr.DNSNames) != 1 || csr.DNSNames[0] != o.Domain {
+		s.problem(w, http.StatusBadRequest, "badCSR", "csr names don't match the order")
+		return
+	}
+	validity := s.Validity
+	if validity == 0 {
+		validity = 90 * 24 * time.Hour
+	}
+	serial, _ := new(big.Int).SetString(s.nextID(), 10)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: o.Domain},
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, s.caCert, csr.PublicKey, s.caKey)
+	if err != nil {
+		s.problem(w, http.StatusInternalServerError, "serverInternal", err.Error())
+		return
+	}
+	chain := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.caCert.Raw})...)
+	s.certs[id] = chain
+	s.issued = append(s.issued, o.Domain)
+	o.Status = "valid"
+	o.Certificate = s.server.URL + "/cert/" + id
+	json.NewEncoder(w).Encode(o)
+}
@@ -0,0 +1,536 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package acme implements a minimal client for the ACME protocol, used to
+// obtain certificates from authorities like Let's Encrypt using HTTP-01
+// challenges.
+package acme
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	tsuruNet "github.com/tsuru/tsuru/net"
+)
+
+const (
+	// ChallengePath is the path where HTTP-01 challenge responses must be
+	// served, followed by the challenge token.
+	ChallengePath = "/.well-known/acme-challenge/"
+
+	challengeTypeHTTP01 = "http-01"
+
+	statusValid   = "valid"
+	statusInvalid = "invalid"
+	statusPending = "pending"
+
+	problemBadNonce = "urn:ietf:params:acme:error:badNonce"
+)
+
+// Error is a problem document returned by the ACME server.
+type Error struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("acme: %s: %s", e.Type, e.Detail)
+}
+
+// ChallengeSolver makes the response of an HTTP-01 challenge available at
+// http://<domain>/.well-known/acme-challenge/<token>.
+type ChallengeSolver interface {
+	Present(domain, token, keyAuth string) error
+	CleanUp(domain, token string) error
+}
+
+// Certificate is a certificate issued by the ACME server, along with its
+// private key, both PEM encoded.
+type Certificate struct {
+	Certificate []byte
+	Key         []byte
+	NotAfter    time.Time
+}
+
+type directory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+type identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type order struct {
+	Status         string   `json:"status"`
+	Authorizations []string `json:"authorizations"`
+	Finalize       string   `json:"finalize"`
+	Certificate    string   `json:"certificate"`
+	Error          *Error   `json:"error"`
+}
+
+type challenge struct {
+	Type   string `json:"type"`
+	URL    string `json:"url"`
+	Token  string `json:"token"`
+	Status string `json:"status"`
+	Error  *Error `json:"error"`
+}
+
+type authorization struct {
+	Status     string      `json:"status"`
+	Identifier identifier  `json:"identifier"`
+	Challenges []challenge `json:"challenges"`
+}
+
+// Client is an ACME client bound to an account on the server identified by
+// DirectoryURL.
+type Client struct {
+	DirectoryURL string
+	Key          *ecdsa.PrivateKey
+	AccountURL   string
+	HTTPClient   *http.Client
+	PollInterval time.Duration
+	PollTimeout  time.Duration
+
+	mu     sync.Mutex
+	dir    *directory
+	nonces []string
+}
+
+// NewClient returns a client for the ACME server in directoryURL, using key
+// to sign requests.
+func NewClient(directoryURL string, key *ecdsa.PrivateKey) *Client {
+	return &Client{
+		DirectoryURL: directoryURL,
+		Key:          key,
+		HTTPClient:   tsuruNet.Dial5Full60ClientNoKeepAlive,
+		PollInterval: time.Second,
+		PollTimeout:  2 * time.Minute,
+	}
+}
+
+// GenerateKey generates a new key suitable for account and certificate keys.
+func GenerateKey() (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// EncodeKey returns the PEM encoding of the key.
+func EncodeKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+// DecodeKey parses a PEM encoded key generated by EncodeKey.
+func DecodeKey(data []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("acme: invalid PEM key")
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+// Register creates an account on the ACME server for the client key,
+// agreeing to the terms of service. The returned account URL is also stored
+// in the client.
+func (c *Client) Register(email string) (string, error) {
+	dir, err := c.directory()
+	if err != nil {
+		return "", err
+	}
+	req := map[string]interface{}{"termsOfServiceAgreed": true}
+	if email != "" {
+		req["contact"] = []string{"mailto:" + email}
+	}
+	rsp, err := c.post(dir.NewAccount, req)
+	if err != nil {
+		return "", err
+	}
+	rsp.Body.Close()
+	location := rsp.Header.Get("Location")
+	if location == "" {
+		return "", errors.New("acme: account created without location")
+	}
+	c.AccountURL = location
+	return location, nil
+}
+
+// ObtainCertificate orders a certificate for domain, solving the HTTP-01
+// challenges with solver.
+func (c *Client) ObtainCertificate(domain string, solver ChallengeSolver) (*Certificate, error) {
+	if c.AccountURL == "" {
+		return nil, errors.New("acme: client has no registered account")
+	}
+	dir, err := c.directory()
+	if err != nil {
+		return nil, err
+	}
+	rsp, err := c.post(dir.NewOrder, map[string]interface{}{
+		"identifiers": []identifier{{Type: "dns", Value: domain}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	orderURL := rsp.Header.Get("Location")
+	var o order
+	err = decodeBody(rsp, &o)
+	if err != nil {
+		return nil, err
+	}
+	for _, authzURL := range o.Authorizations {
+		err = c.authorize(authzURL, solver)
+		if err != nil {
+			return nil, err
+		}
+	}
+	key, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domain},
+		DNSNames: []string{domain},
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+	rsp, err = c.post(o.Finalize, map[string]string{"csr": encode(csr)})
+	if err != nil {
+		return nil, err
+	}
+	err = decodeBody(rsp, &o)
+	if err != nil {
+		return nil, err
+	}
+	err = c.poll(func() (bool, error) {
+		if o.Status == statusValid {
+			return true, nil
+		}
+		if o.Status == statusInvalid {
+			if o.Error != nil {
+				return false, o.Error
+			}
+			return false, errors.Errorf("acme: order for %q is invalid", domain)
+		}
+		rsp, err = c.post(orderURL, nil)
+		if err != nil {
+			return false, err
+		}
+		return false, decodeBody(rsp, &o)
+	})
+	if err != nil {
+		return nil, err
+	}
+	rsp, err = c.post(o.Certificate, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	chain, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(chain)
+	if block == nil {
+		return nil, errors.New("acme: invalid certificate returned by the server")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := EncodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Certificate{Certificate: chain, Key: keyPEM, NotAfter: cert.NotAfter}, nil
+}
+
+func (c *Client) authorize(authzURL string, solver ChallengeSolver) error {
+	var authz authorization
+	rsp, err := c.post(authzURL, nil)
+	if err != nil {
+		return err
+	}
+	err = decodeBody(rsp, &authz)
+	if err != nil {
+		return err
+	}
+	if authz.Status == statusValid {
+		return nil
+	}
+	var chal *challenge
+	for i := range authz.Challenges {
+		if authz.Challenges[i].Type == challengeTypeHTTP01 {
+			chal = &authz.Challenges[i]
+			break
+		}
+	}
+	if chal == nil {
+		return errors.Errorf("acme: no %s challenge offered for %q", challengeTypeHTTP01, authz.Identifier.Value)
+	}
+	domain := authz.Identifier.Value
+	keyAuth, err := c.keyAuthorization(chal.Token)
+	if err != nil {
+		return err
+	}
+	err = solver.Present(domain, chal.Token, keyAuth)
+	if err != nil {
+		return err
+	}
+	defer solver.CleanUp(domain, chal.Token)
+	rsp, err = c.post(chal.URL, struct{}{})
+	if err != nil {
+		return err
+	}
+	rsp.Body.Close()
+	return c.poll(func() (bool, error) {
+		rsp, err := c.post(authzURL, nil)
+		if err != nil {
+			return false, err
+		}
+		err = decodeBody(rsp, &authz)
+		if err != nil {
+			return false, err
+		}
+		switch authz.Status {
+		case statusValid:
+			return true, nil
+		case statusPending:
+			return false, nil
+		}
+		for _, ch := range authz.Challenges {
+			if ch.Type == challengeTypeHTTP01 && ch.Error != nil {
+				return false, ch.Error
+			}
+		}
+		return false, errors.Errorf("acme: authorization for %q is %s", domain, authz.Status)
+	})
+}
+
+func (c *Client) poll(fn func() (bool, error)) error {
+	timeout := time.After(c.PollTimeout)
+	for {
+		done, err := fn()
+		if done || err != nil {
+			return err
+		}
+		select {
+		case <-timeout:
+			return errors.Errorf("acme: timeout after %v waiting for the server", c.PollTimeout)
+		case <-time.After(c.PollInterval):
+		}
+	}
+}
+
+func (c *Client) keyAuthorization(token string) (string, error) {
+	thumbprint, err := Thumbprint(&c.Key.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	return token + "." + thumbprint, nil
+}
+
+func (c *Client) directory() (*directory, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dir != nil {
+		return c.dir, nil
+	}
+	rsp, err := c.HTTPClient.Get(c.DirectoryURL)
+	if err != nil {
+		return nil, err
+	}
+	var dir directory
+	err = decodeBody(rsp, &dir)
+	if err != nil {
+		return nil, err
+	}
+	c.dir = &dir
+	return c.dir, nil
+}
+
+func (c *Client) nonce() (string, error) {
+	c.mu.Lock()
+	if n := len(c.nonces); n > 0 {
+		nonce := c.nonces[n-1]
+		c.nonces = c.nonces[:n-1]
+		c.mu.Unlock()
+		return nonce, nil
+	}
+	c.mu.Unlock()
+	dir, err := c.directory()
+	if err != nil {
+		return "", err
+	}
+	rsp, err := c.HTTPClient.Head(dir.NewNonce)
+	if err != nil {
+		return "", err
+	}
+	rsp.Body.Close()
+	nonce := rsp.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		return "", errors.New("acme: server did not return a nonce")
+	}
+	return nonce, nil
+}
+
+func (c *Client) saveNonce(rsp *http.Response) {
+	if nonce := rsp.Header.Get("Replay-Nonce"); nonce != "" {
+		c.mu.Lock()
+		c.nonces = append(c.nonces, nonce)
+		c.mu.Unlock()
+	}
+}
+
+// post sends a signed request to url. A nil payload sends a POST-as-GET
+// request.
+func (c *Client) post(url string, payload interface{}) (*http.Response, error) {
+	var data []byte
+	if payload != nil {
+		var err error
+		data, err = json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var rsp *http.Response
+	for retry := 0; retry < 2; retry++ {
+		nonce, err := c.nonce()
+		if err != nil {
+			return nil, err
+		}
+		body, err := c.sign(url, nonce, data)
+		if err != nil {
+			return nil, err
+		}
+		rsp, err = c.HTTPClient.Post(url, "application/jose+json", bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		c.saveNonce(rsp)
+		if rsp.StatusCode < http.StatusBadRequest {
+			return rsp, nil
+		}
+		acmeErr := responseError(rsp)
+		if acmeErr.Type != problemBadNonce {
+			return nil, acmeErr
+		}
+	}
+	return nil, responseError(rsp)
+}
+
+func (c *Client) sign(url, nonce string, payload []byte) ([]byte, error) {
+	protected := map[string]interface{}{
+		"alg":   "ES256",
+		"nonce": nonce,
+		"url":   url,
+	}
+	if c.AccountURL == "" {
+		protected["jwk"] = JWK(&c.Key.PublicKey)
+	} else {
+		protected["kid"] = c.AccountURL
+	}
+	protectedData, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+	protected64 := encode(protectedData)
+	payload64 := encode(payload)
+	hash := sha256.Sum256([]byte(protected64 + "." + payload64))
+	r, s, err := ecdsa.Sign(rand.Reader, c.Key, hash[:])
+	if err != nil {
+		return nil, err
+	}
+	size := (c.Key.Curve.Params().BitSize + 7) / 8
+	signature := make([]byte, 2*size)
+	copyPadded(signature[:size], r)
+	copyPadded(signature[size:], s)
+	return json.Marshal(map[string]string{
+		"protected": protected64,
+		"payload":   payload64,
+		"signature": encode(signature),
+	})
+}
+
+// JWK returns the JSON Web Key representation of the public key.
+func JWK(pub *ecdsa.PublicKey) map[string]string {
+	size := (pub.Curve.Params().BitSize + 7) / 8
+	x := make([]byte, size)
+	y := make([]byte, size)
+	copyPadded(x, pub.X)
+	copyPadded(y, pub.Y)
+	return map[string]string{
+		"crv": pub.Curve.Params().Name,
+		"kty": "EC",
+		"x":   encode(x),
+		"y":   encode(y),
+	}
+}
+
+// Thumbprint returns the JWK thumbprint of the public key, as defined in RFC
+// 7638.
+func Thumbprint(pub crypto.PublicKey) (string, error) {
+	ecKey, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return "", errors.New("acme: unsupported key type")
+	}
+	jwk := JWK(ecKey)
+	data := fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q,"y":%q}`, jwk["crv"], jwk["kty"], jwk["x"], jwk["y"])
+	sum := sha256.Sum256([]byte(data))
+	return encode(sum[:]), nil
+}
+
+func copyPadded(dst []byte, n *big.Int) {
+	b := n.Bytes()
+	copy(dst[len(dst)-len(b):], b)
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeBody(rsp *http.Response, v interface{}) error {
+	defer rsp.Body.Close()
+	if rsp.StatusCode >= http.StatusBadRequest {
+		return responseError(rsp)
+	}
+	err := json.NewDecoder(rsp.Body).Decode(v)
+	if err != nil {
+		return errors.Wrapf(err, "acme: unable to decode response from %s", rsp.Request.URL)
+	}
+	return nil
+}
+
+func responseError(rsp *http.Response) *Error {
+	defer rsp.Body.Close()
+	data, _ := ioutil.ReadAll(rsp.Body)
+	var acmeErr Error
+	if json.Unmarshal(data, &acmeErr) != nil || acmeErr.Type == "" {
+		acmeErr = Error{Detail: string(data)}
+	}
+	acmeErr.Status = rsp.StatusCode
+	return &acmeErr
+}
@@ -0,0 +1,153 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package acme_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tsuru/tsuru/acme"
+	"github.com/tsuru/tsuru/acme/acmetest"
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type S struct {
+	server     *acmetest.Server
+	challenges *httptest.Server
+	solver     *fakeSolver
+}
+
+var _ = check.Suite(&S{})
+
+type fakeSolver struct {
+	sync.Mutex
+	responses map[string]string
+	cleaned   []string
+}
+
+func (s *fakeSolver) Present(domain, token, keyAuth string) error {
+	s.Lock()
+	defer s.Unlock()
+	s.responses[domain+token] = keyAuth
+	return nil
+}
+
+func (s *fakeSolver) CleanUp(domain, token string) error {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.responses, domain+token)
+	s.cleaned = append(s.cleaned, token)
+	return nil
+}
+
+func (s *fakeSolver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	token := strings.TrimPrefix(r.URL.Path, acme.ChallengePath)
+	keyAuth, ok := s.responses[r.Host+token]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Write([]byte(keyAuth))
+}
+
+func (s *S) SetUpTest(c *check.C) {
+	var err error
+	s.server, err = acmetest.NewServer()
+	c.Assert(err, check.IsNil)
+	s.solver = &fakeSolver{responses: make(map[string]string)}
+	s.challenges = httptest.NewServer(s.solver)
+	s.server.ValidationURL = s.challenges.URL
+}
+
+func (s *S) TearDownTest(c *check.C) {
+	s.server.Close()
+	s.challenges.Close()
+}
+
+func (s *S) newClient(c *check.C) *acme.Client {
+	key, err := acme.GenerateKey()
+	c.Assert(err, check.IsNil)
+	client := acme.NewClient(s.server.URL, key)
+	client.PollInterval = 10 * time.Millisecond
+	client.PollTimeout = time.Second
+	return client
+}
+
+func (s *S) TestRegister(c *check.C) {
+	client := s.newClient(c)
+	account, err := client.Register("admin@example.com")
+	c.Assert(err, check.IsNil)
+	c.Assert(account, check.Not(check.Equals), "")
+	c.Assert(client.AccountURL, check.Equals, account)
+	other := acme.NewClient(s.server.URL, client.Key)
+	sameAccount, err := other.Register("")
+	c.Assert(err, check.IsNil)
+	c.Assert(sameAccount, check.Equals, account)
+}
+
+func (s *S) TestObtainCertificate(c *check.C) {
+	client := s.newClient(c)
+	_, err := client.Register("admin@example.com")
+	c.Assert(err, check.IsNil)
+	cert, err := client.ObtainCertificate("myapp.example.com", s.solver)
+	c.Assert(err, check.IsNil)
+	block, rest := pem.Decode(cert.Certificate)
+	c.Assert(block, check.NotNil)
+	c.Assert(strings.Contains(string(rest), "CERTIFICATE"), check.Equals, true)
+	x509Cert, err := x509.ParseCertificate(block.Bytes)
+	c.Assert(err, check.IsNil)
+	c.Assert(x509Cert.DNSNames, check.DeepEquals, []string{"myapp.example.com"})
+	c.Assert(cert.NotAfter.Equal(x509Cert.NotAfter), check.Equals, true)
+	key, err := acme.DecodeKey(cert.Key)
+	c.Assert(err, check.IsNil)
+	c.Assert(key.PublicKey.X.Cmp(x509Cert.PublicKey.(*ecdsa.PublicKey).X), check.Equals, 0)
+	c.Assert(s.solver.responses, check.HasLen, 0)
+	c.Assert(s.solver.cleaned, check.HasLen, 1)
+	c.Assert(s.server.Issued(), check.DeepEquals, []string{"myapp.example.com"})
+}
+
+func (s *S) TestObtainCertificateChallengeFailure(c *check.C) {
+	client := s.newClient(c)
+	_, err := client.Register("")
+	c.Assert(err, check.IsNil)
+	cert, err := client.ObtainCertificate("myapp.example.com", noopSolver{})
+	c.Assert(err, check.ErrorMatches, `acme: urn:ietf:params:acme:error:unauthorized: unexpected status code 404 .*`)
+	c.Assert(cert, check.IsNil)
+	c.Assert(s.server.Issued(), check.HasLen, 0)
+}
+
+func (s *S) TestObtainCertificateWithoutAccount(c *check.C) {
+	client := s.newClient(c)
+	_, err := client.ObtainCertificate("myapp.example.com", s.solver)
+	c.Assert(err, check.ErrorMatches, "acme: client has no registered account")
+}
+
+func (s *S) TestEncodeDecodeKey(c *check.C) {
+	key, err := acme.GenerateKey()
+	c.Assert(err, check.IsNil)
+	data, err := acme.EncodeKey(key)
+	c.Assert(err, check.IsNil)
+	decoded, err := acme.DecodeKey(data)
+	c.Assert(err, check.IsNil)
+	c.Assert(decoded.D.Cmp(key.D), check.Equals, 0)
+	_, err = acme.DecodeKey([]byte("invalid"))
+	c.Assert(err, check.ErrorMatches, "acme: invalid PEM key")
+}
+
+type noopSolver struct{}
+
+func (noopSolver) Present(domain, token, keyAuth string) error { return nil }
+func (noopSolver) CleanUp(domain, token string) error          { return nil }
@@ -0,0 +1,194 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/permission"
+)
+
+// accessTokenUser returns the email of the user whose access tokens are
+// handled by the request, which defaults to the user of the token.
+func accessTokenUser(r *http.Request, t auth.Token) string {
+	email := r.URL.Query().Get("user")
+	if email == "" {
+		email = t.GetUserName()
+	}
+	return email
+}
+
+// title: list access tokens
+// path: /users/access-tokens
+// method: GET
+// produce: application/json
+// responses:
+//   200: OK
+//   204: No content
+//   401: Unauthorized
+func listAccessTokens(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	email := accessTokenUser(r, t)
+	allowed := permission.Check(t, permission.PermUserReadAccessTokens,
+		permission.Context(permission.CtxUser, email),
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	tokens, err := auth.ListAccessTokens(email)
+	if err != nil {
+		return err
+	}
+	if len(tokens) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(tokens)
+}
+
+// title: create access token
+// path: /users/access-tokens
+// method: POST
+// consume: application/x-www-form-urlencoded
+// produce: application/json
+// responses:
+//   201: Access token created
+//   400: Invalid data
+//   401: Unauthorized
+//   404: User not found
+//   409: Access token already exists
+func createAccessToken(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	email := accessTokenUser(r, t)
+	allowed := permission.Check(t, permission.PermUserUpdateAccessTokenCreate,
+		permission.Context(permission.CtxUser, email),
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	token := auth.AccessToken{
+		Name:            r.FormValue("name"),
+		UserEmail:       email,
+		Description:     r.FormValue("description"),
+		PermissionNames: r.Form["permission"],
+	}
+	if expires := r.FormValue("expires"); expires != "" {
+		duration, parseErr := time.ParseDuration(expires)
+		if parseErr != nil || duration <= 0 {
+			return &errors.HTTP{Code: http.StatusBadRequest, Message: fmt.Sprintf("invalid expiration %q", expires)}
+		}
+		token.ExpiresAt = time.Now().UTC().Add(duration)
+	}
+	// Access tokens can't outlive the access token used to create them.
+	if parent, ok := t.(*auth.AccessToken); ok && !parent.ExpiresAt.IsZero() {
+		if token.ExpiresAt.IsZero() || token.ExpiresAt.After(parent.ExpiresAt) {
+			token.ExpiresAt = parent.ExpiresAt
+		}
+	}
+	if !canDelegatePermissions(t, token.PermissionNames) {
+		return &errors.HTTP{
+			Code:    http.StatusForbidden,
+			Message: "an access token cannot create access tokens with more permissions than its own",
+		}
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     userTarget(email),
+		Kind:       permission.PermUserUpdateAccessTokenCreate,
+		Owner:      t,
+		CustomData: event.FormToCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermUserReadEvents, permission.Context(permission.CtxUser, email)),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	if _, err = auth.GetUserByEmail(email); err != nil {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	err = auth.CreateAccessToken(&token)
+	if err == auth.ErrAccessTokenAlreadyExists {
+		return &errors.HTTP{Code: http.StatusConflict, Message: err.Error()}
+	}
+	if err != nil {
+		return handleAuthError(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	return json.NewEncoder(w).Encode(token)
+}
+
+// canDelegatePermissions checks whether the given token may create an access
+// token with the given permissions. Access tokens limited to a subset of
+// permissions may only create tokens limited to the same subset, or to a
+// narrower one.
+func canDelegatePermissions(t auth.Token, permNames []string) bool {
+	accessToken, ok := t.(*auth.AccessToken)
+	if !ok || len(accessToken.PermissionNames) == 0 {
+		return true
+	}
+	if len(permNames) == 0 {
+		return false
+	}
+	for _, name := range permNames {
+		scheme, err := permission.SafeGet(name)
+		if err != nil {
+			// Invalid permissions are reported by auth.CreateAccessToken.
+			continue
+		}
+		var found bool
+		for _, tokenPermName := range accessToken.PermissionNames {
+			tokenScheme, err := permission.SafeGet(tokenPermName)
+			if err == nil && tokenScheme.IsParent(scheme) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// title: revoke access token
+// path: /users/access-tokens/{name}
+// method: DELETE
+// responses:
+//   200: Access token revoked
+//   401: Unauthorized
+//   404: Access token not found
+func revokeAccessToken(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	email := accessTokenUser(r, t)
+	name := r.URL.Query().Get(":name")
+	allowed := permission.Check(t, permission.PermUserUpdateAccessTokenRevoke,
+		permission.Context(permission.CtxUser, email),
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     userTarget(email),
+		Kind:       permission.PermUserUpdateAccessTokenRevoke,
+		Owner:      t,
+		CustomData: event.FormToCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermUserReadEvents, permission.Context(permission.CtxUser, email)),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	err = auth.RevokeAccessToken(email, name)
+	if err == auth.ErrAccessTokenNotFound {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	return err
+}
@@ -0,0 +1,284 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *AuthSuite) TestCreateAccessToken(c *check.C) {
+	body := strings.NewReader("name=ci&description=deploys&expires=1h&permission=app.deploy&permission=app.read")
+	request, err := http.NewRequest("POST", "/users/access-tokens", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var token auth.AccessToken
+	err = json.NewDecoder(recorder.Body).Decode(&token)
+	c.Assert(err, check.IsNil)
+	c.Assert(token.Name, check.Equals, "ci")
+	c.Assert(token.Token, check.Not(check.Equals), "")
+	c.Assert(token.UserEmail, check.Equals, s.user.Email)
+	c.Assert(token.Description, check.Equals, "deploys")
+	c.Assert(token.PermissionNames, check.DeepEquals, []string{"app.deploy", "app.read"})
+	c.Assert(token.ExpiresAt.After(time.Now().Add(59*time.Minute)), check.Equals, true)
+	c.Assert(token.ExpiresAt.Before(time.Now().Add(time.Hour)), check.Equals, true)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	count, err := conn.AccessTokens().Find(bson.M{"token": token.Token, "useremail": s.user.Email}).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(count, check.Equals, 1)
+	c.Assert(eventtest.EventDesc{
+		Target: userTarget(s.user.Email),
+		Owner:  s.token.GetUserName(),
+		Kind:   "user.update.access-token.create",
+		StartCustomData: []map[string]interface{}{
+			{"name": "name", "value": "ci"},
+			{"name": "description", "value": "deploys"},
+			{"name": "expires", "value": "1h"},
+			{"name": "permission", "value": []string{"app.deploy", "app.read"}},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *AuthSuite) TestCreateAccessTokenOtherUser(c *check.C) {
+	u := auth.User{Email: "leto@arrakis.com", Password: "123456"}
+	_, err := nativeScheme.Create(&u)
+	c.Assert(err, check.IsNil)
+	body := strings.NewReader("name=ci")
+	request, err := http.NewRequest("POST", "/users/access-tokens?user=leto@arrakis.com", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	err = createAccessToken(recorder, request, s.token)
+	c.Assert(err, check.IsNil)
+	tokens, err := auth.ListAccessTokens(u.Email)
+	c.Assert(err, check.IsNil)
+	c.Assert(tokens, check.HasLen, 1)
+	c.Assert(tokens[0].Name, check.Equals, "ci")
+}
+
+func (s *AuthSuite) TestCreateAccessTokenOtherUserWithoutPermission(c *check.C) {
+	token := userWithPermission(c)
+	body := strings.NewReader("name=ci")
+	request, err := http.NewRequest("POST", "/users/access-tokens?user="+s.user.Email, body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	err = createAccessToken(recorder, request, token)
+	c.Assert(err, check.Equals, permission.ErrUnauthorized)
+}
+
+func (s *AuthSuite) TestCreateAccessTokenInvalidExpiration(c *check.C) {
+	for _, expires := range []string{"1month", "-1h"} {
+		body := strings.NewReader("name=ci&expires=" + expires)
+		request, err := http.NewRequest("POST", "/users/access-tokens", body)
+		c.Assert(err, check.IsNil)
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		recorder := httptest.NewRecorder()
+		err = createAccessToken(recorder, request, s.token)
+		c.Assert(err, check.NotNil)
+		c.Assert(err.(*errors.HTTP).Code, check.Equals, http.StatusBadRequest)
+	}
+}
+
+func (s *AuthSuite) TestCreateAccessTokenInvalidData(c *check.C) {
+	for _, data := range []string{"name=1ci", "name=ci&permission=app.fly"} {
+		request, err := http.NewRequest("POST", "/users/access-tokens", strings.NewReader(data))
+		c.Assert(err, check.IsNil)
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		recorder := httptest.NewRecorder()
+		err = createAccessToken(recorder, request, s.token)
+		c.Assert(err, check.NotNil, check.Commentf(data))
+		c.Assert(err.(*errors.HTTP).Code, check.Equals, http.StatusBadRequest, check.Commentf(data))
+	}
+}
+
+func (s *AuthSuite) TestCreateAccessTokenAlreadyExists(c *check.C) {
+	err := auth.CreateAccessToken(&auth.AccessToken{Name: "ci", UserEmail: s.user.Email})
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("POST", "/users/access-tokens", strings.NewReader("name=ci"))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	err = createAccessToken(recorder, request, s.token)
+	c.Assert(err, check.NotNil)
+	c.Assert(err.(*errors.HTTP).Code, check.Equals, http.StatusConflict)
+}
+
+func (s *AuthSuite) TestCreateAccessTokenWithLimitedAccessToken(c *check.C) {
+	limited := auth.AccessToken{
+		Name:            "limited",
+		UserEmail:       s.user.Email,
+		PermissionNames: []string{"app", "user.update.access-token"},
+	}
+	err := auth.CreateAccessToken(&limited)
+	c.Assert(err, check.IsNil)
+	for _, data := range []string{"name=ci", "name=ci&permission=app.deploy&permission=team"} {
+		request, err := http.NewRequest("POST", "/users/access-tokens", strings.NewReader(data))
+		c.Assert(err, check.IsNil)
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		recorder := httptest.NewRecorder()
+		err = createAccessToken(recorder, request, &limited)
+		c.Assert(err, check.NotNil, check.Commentf(data))
+		c.Assert(err.(*errors.HTTP).Code, check.Equals, http.StatusForbidden, check.Commentf(data))
+	}
+	request, err := http.NewRequest("POST", "/users/access-tokens", strings.NewReader("name=ci&permission=app.deploy"))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	err = createAccessToken(recorder, request, &limited)
+	c.Assert(err, check.IsNil)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+}
+
+func (s *AuthSuite) TestCreateAccessTokenCappedByParentExpiration(c *check.C) {
+	parent := auth.AccessToken{
+		Name:      "parent",
+		UserEmail: s.user.Email,
+		ExpiresAt: time.Now().UTC().Add(time.Hour),
+	}
+	err := auth.CreateAccessToken(&parent)
+	c.Assert(err, check.IsNil)
+	tests := []struct {
+		data    string
+		expires time.Time
+	}{
+		{data: "name=ci1", expires: parent.ExpiresAt},
+		{data: "name=ci2&expires=48h", expires: parent.ExpiresAt},
+		{data: "name=ci3&expires=10m"},
+	}
+	for _, tt := range tests {
+		request, err := http.NewRequest("POST", "/users/access-tokens", strings.NewReader(tt.data))
+		c.Assert(err, check.IsNil)
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		recorder := httptest.NewRecorder()
+		err = createAccessToken(recorder, request, &parent)
+		c.Assert(err, check.IsNil)
+		var token auth.AccessToken
+		err = json.NewDecoder(recorder.Body).Decode(&token)
+		c.Assert(err, check.IsNil)
+		if tt.expires.IsZero() {
+			c.Check(token.ExpiresAt.Before(parent.ExpiresAt), check.Equals, true, check.Commentf(tt.data))
+		} else {
+			c.Check(token.ExpiresAt.Equal(tt.expires), check.Equals, true, check.Commentf(tt.data))
+		}
+	}
+}
+
+func (s *AuthSuite) TestListAccessTokens(c *check.C) {
+	err := auth.CreateAccessToken(&auth.AccessToken{Name: "ci", UserEmail: s.user.Email, Description: "deploys"})
+	c.Assert(err, check.IsNil)
+	err = auth.CreateAccessToken(&auth.AccessToken{Name: "other", UserEmail: "other@tsuru.io"})
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("GET", "/users/access-tokens", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var tokens []auth.AccessToken
+	err = json.NewDecoder(recorder.Body).Decode(&tokens)
+	c.Assert(err, check.IsNil)
+	c.Assert(tokens, check.HasLen, 1)
+	c.Assert(tokens[0].Name, check.Equals, "ci")
+	c.Assert(tokens[0].Description, check.Equals, "deploys")
+	c.Assert(tokens[0].Token, check.Equals, "")
+}
+
+func (s *AuthSuite) TestListAccessTokensNoContent(c *check.C) {
+	request, err := http.NewRequest("GET", "/users/access-tokens", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNoContent)
+}
+
+func (s *AuthSuite) TestRevokeAccessToken(c *check.C) {
+	token := auth.AccessToken{Name: "ci", UserEmail: s.user.Email}
+	err := auth.CreateAccessToken(&token)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("DELETE", "/users/access-tokens/ci", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	tokens, err := auth.ListAccessTokens(s.user.Email)
+	c.Assert(err, check.IsNil)
+	c.Assert(tokens, check.HasLen, 0)
+	c.Assert(eventtest.EventDesc{
+		Target: userTarget(s.user.Email),
+		Owner:  s.token.GetUserName(),
+		Kind:   "user.update.access-token.revoke",
+		StartCustomData: []map[string]interface{}{
+			{"name": ":name", "value": "ci"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *AuthSuite) TestRevokeAccessTokenNotFound(c *check.C) {
+	request, err := http.NewRequest("DELETE", "/users/access-tokens/ci", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *AuthSuite) TestAuthenticateWithAccessToken(c *check.C) {
+	token := auth.AccessToken{Name: "ci", UserEmail: s.user.Email, PermissionNames: []string{"user.read.access-tokens"}}
+	err := auth.CreateAccessToken(&token)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("GET", "/users/access-tokens", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+token.Token)
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	request, err = http.NewRequest("DELETE", "/users/access-tokens/ci", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+token.Token)
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *AuthSuite) TestAuthenticateWithExpiredAccessToken(c *check.C) {
+	token := auth.AccessToken{Name: "ci", UserEmail: s.user.Email, ExpiresAt: time.Now().Add(-time.Minute)}
+	err := auth.CreateAccessToken(&token)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("GET", "/users/access-tokens", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+token.Token)
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusUnauthorized)
+}
@@ -0,0 +1,32 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/errors"
+)
+
+// title: acme challenge
+// path: /.well-known/acme-challenge/{token}
+// method: GET
+// produce: text/plain
+// responses:
+//   200: OK
+//   404: Not found
+func acmeChallenge(w http.ResponseWriter, r *http.Request) error {
+	keyAuth, err := app.ACMEKeyAuthorization(r.URL.Query().Get(":token"))
+	if err == app.ErrACMEChallengeNotFound {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	_, err = w.Write([]byte(keyAuth))
+	return err
+}
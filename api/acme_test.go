@@ -0,0 +1,36 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) TestACMEChallenge(c *check.C) {
+	err := s.conn.ACMEChallenges().Insert(bson.M{"_id": "mytoken", "domain": "myapp.io", "keyauth": "mytoken.thumb"})
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/.well-known/acme-challenge/mytoken", nil)
+	c.Assert(err, check.IsNil)
+	request.Host = "myapp.io"
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "text/plain")
+	c.Assert(recorder.Body.String(), check.Equals, "mytoken.thumb")
+}
+
+func (s *S) TestACMEChallengeNotFound(c *check.C) {
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/.well-known/acme-challenge/unknown", nil)
+	c.Assert(err, check.IsNil)
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
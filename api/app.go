@@ -10,7 +10,9 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ajg/form"
@@ -27,6 +29,7 @@ import (
 	"github.com/tsuru/tsuru/provision"
 	"github.com/tsuru/tsuru/quota"
 	"github.com/tsuru/tsuru/repository"
+	"github.com/tsuru/tsuru/router"
 	"github.com/tsuru/tsuru/router/rebuild"
 	"github.com/tsuru/tsuru/service"
 	"gopkg.in/mgo.v2/bson"
@@ -227,6 +230,7 @@ type inputApp struct {
 	Description string
 	Pool        string
 	RouterOpts  map[string]string
+	Routers     []router.AppRouter
 }
 
 // title: app create
@@ -258,6 +262,7 @@ func createApp(w http.ResponseWriter, r *http.Request, t auth.Token) (err error)
 		Description: ia.Description,
 		Pool:        ia.Pool,
 		RouterOpts:  ia.RouterOpts,
+		Routers:     ia.Routers,
 	}
 	if a.TeamOwner == "" {
 		a.TeamOwner, err = permission.TeamForPermission(t, permission.PermAppCreate)
@@ -400,9 +405,15 @@ func updateApp(w http.ResponseWriter, r *http.Request, t auth.Token) (err error)
 			return permission.ErrUnauthorized
 		}
 	}
+	// Plan changes restart the app units, they are recorded with their own
+	// kind so they can be easily told apart from other updates.
+	kind := permission.PermAppUpdate
+	if updateData.Plan.Name != "" {
+		kind = permission.PermAppUpdatePlan
+	}
 	evt, err := event.New(&event.Opts{
 		Target:     appTarget(appName),
-		Kind:       permission.PermAppUpdate,
+		Kind:       kind,
 		Owner:      t,
 		CustomData: event.FormToCustomData(r.Form),
 		Allowed:    event.Allowed(permission.PermAppReadEvents, contextsForApp(&a)...),
@@ -419,6 +430,9 @@ func updateApp(w http.ResponseWriter, r *http.Request, t auth.Token) (err error)
 	if err == app.ErrPlanNotFound {
 		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
 	}
+	if e, ok := err.(*errors.ValidationError); ok {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: e.Message}
+	}
 	return err
 }
 
@@ -1038,6 +1052,246 @@ func unsetCName(w http.ResponseWriter, r *http.Request, t auth.Token) (err error
 	return err
 }
 
+// title: routes weights list
+// path: /apps/{app}/routes/weights
+// method: GET
+// produce: application/json
+// responses:
+//   200: OK
+//   204: No content
+//   401: Unauthorized
+//   404: App not found
+func routesWeightsList(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	a, err := getAppFromContext(r.URL.Query().Get(":app"), r)
+	if err != nil {
+		return err
+	}
+	allowed := permission.Check(t, permission.PermAppRead,
+		contextsForApp(&a)...,
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	weights, err := a.CNamesWeights()
+	if err != nil {
+		return err
+	}
+	if len(weights) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(weights)
+}
+
+// title: set routes weights
+// path: /apps/{app}/routes/weights
+// method: POST
+// consume: application/x-www-form-urlencoded
+// responses:
+//   200: Ok
+//   400: Invalid data
+//   401: Unauthorized
+//   404: App or cname not found
+func setRoutesWeights(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	err = r.ParseForm()
+	if err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	cname := r.FormValue("cname")
+	if cname == "" {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "You must provide the cname."}
+	}
+	var weights []router.BackendWeight
+	for key, values := range r.Form {
+		if !strings.HasPrefix(key, "weights.") || len(values) == 0 {
+			continue
+		}
+		weight, convErr := strconv.Atoi(values[0])
+		if convErr != nil {
+			return &errors.HTTP{Code: http.StatusBadRequest, Message: fmt.Sprintf("invalid weight for app %q: %s", key[len("weights."):], values[0])}
+		}
+		weights = append(weights, router.BackendWeight{Backend: key[len("weights."):], Weight: weight})
+	}
+	sort.Sort(backendWeightsByName(weights))
+	appName := r.URL.Query().Get(":app")
+	a, err := getAppFromContext(appName, r)
+	if err != nil {
+		return err
+	}
+	allowed := permission.Check(t, permission.PermAppUpdateRoutesWeights,
+		contextsForApp(&a)...,
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	for _, weight := range weights {
+		if weight.Backend == a.Name {
+			continue
+		}
+		other, err := getApp(weight.Backend)
+		if err != nil {
+			return err
+		}
+		allowed = permission.Check(t, permission.PermAppUpdateRoutesWeights,
+			contextsForApp(other)...,
+		)
+		if !allowed {
+			return permission.ErrUnauthorized
+		}
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     appTarget(appName),
+		Kind:       permission.PermAppUpdateRoutesWeights,
+		Owner:      t,
+		CustomData: event.FormToCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermAppReadEvents, contextsForApp(&a)...),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	err = a.SetCNameWeights(cname, weights)
+	if err == router.ErrCNameNotFound {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	if e, ok := err.(*errors.ValidationError); ok {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: e.Message}
+	}
+	return err
+}
+
+// title: app routers list
+// path: /apps/{app}/routers
+// method: GET
+// produce: application/json
+// responses:
+//   200: OK
+//   401: Unauthorized
+//   404: App not found
+func appRoutersList(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	a, err := getAppFromContext(r.URL.Query().Get(":app"), r)
+	if err != nil {
+		return err
+	}
+	allowed := permission.Check(t, permission.PermAppRead,
+		contextsForApp(&a)...,
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	routers, err := a.GetRoutersWithAddr()
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(routers)
+}
+
+// title: add app router
+// path: /apps/{app}/routers
+// method: POST
+// consume: application/x-www-form-urlencoded
+// responses:
+//   200: Ok
+//   400: Invalid data
+//   401: Unauthorized
+//   404: App not found
+//   409: Router already in app
+func addAppRouter(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	err = r.ParseForm()
+	if err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	var appRouter router.AppRouter
+	dec := form.NewDecoder(nil)
+	dec.IgnoreCase(true)
+	dec.IgnoreUnknownKeys(true)
+	dec.DecodeValues(&appRouter, r.Form)
+	appRouter.Address = ""
+	if appRouter.Name == "" {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "You must provide the router name."}
+	}
+	appName := r.URL.Query().Get(":app")
+	a, err := getAppFromContext(appName, r)
+	if err != nil {
+		return err
+	}
+	allowed := permission.Check(t, permission.PermAppUpdateRouterAdd,
+		contextsForApp(&a)...,
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     appTarget(appName),
+		Kind:       permission.PermAppUpdateRouterAdd,
+		Owner:      t,
+		CustomData: event.FormToCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermAppReadEvents, contextsForApp(&a)...),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	err = a.AddRouter(appRouter)
+	if err == app.ErrRouterAlreadyInApp {
+		return &errors.HTTP{Code: http.StatusConflict, Message: err.Error()}
+	}
+	if e, ok := err.(*errors.ValidationError); ok {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: e.Message}
+	}
+	return err
+}
+
+// title: remove app router
+// path: /apps/{app}/routers/{router}
+// method: DELETE
+// responses:
+//   200: Ok
+//   400: Invalid data
+//   401: Unauthorized
+//   404: App or router not found
+func removeAppRouter(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	appName := r.URL.Query().Get(":app")
+	routerName := r.URL.Query().Get(":router")
+	a, err := getAppFromContext(appName, r)
+	if err != nil {
+		return err
+	}
+	allowed := permission.Check(t, permission.PermAppUpdateRouterRemove,
+		contextsForApp(&a)...,
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     appTarget(appName),
+		Kind:       permission.PermAppUpdateRouterRemove,
+		Owner:      t,
+		CustomData: []map[string]interface{}{{"name": "router", "value": routerName}},
+		Allowed:    event.Allowed(permission.PermAppReadEvents, contextsForApp(&a)...),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	err = a.RemoveRouter(routerName)
+	if err == app.ErrRouterNotInApp {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	if e, ok := err.(*errors.ValidationError); ok {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: e.Message}
+	}
+	return err
+}
+
+type backendWeightsByName []router.BackendWeight
+
+func (w backendWeightsByName) Len() int           { return len(w) }
+func (w backendWeightsByName) Swap(i, j int)      { w[i], w[j] = w[j], w[i] }
+func (w backendWeightsByName) Less(i, j int) bool { return w[i].Backend < w[j].Backend }
+
 // title: app log
 // path: /apps/{app}/log
 // method: GET
@@ -1155,6 +1409,16 @@ func bindServiceInstance(w http.ResponseWriter, r *http.Request, t auth.Token) (
 	appName := r.URL.Query().Get(":app")
 	serviceName := r.URL.Query().Get(":service")
 	noRestart, _ := strconv.ParseBool(r.FormValue("noRestart"))
+	var input struct {
+		Parameters map[string]string
+	}
+	dec := form.NewDecoder(nil)
+	dec.IgnoreCase(true)
+	dec.IgnoreUnknownKeys(true)
+	err = dec.DecodeValues(&input, r.Form)
+	if err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
 	instance, a, err := getServiceInstance(serviceName, instanceName, appName)
 	if err != nil {
 		return err
@@ -1188,7 +1452,7 @@ func bindServiceInstance(w http.ResponseWriter, r *http.Request, t auth.Token) (
 	keepAliveWriter := tsuruIo.NewKeepAliveWriter(w, 30*time.Second, "")
 	defer keepAliveWriter.Stop()
 	writer := &tsuruIo.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(keepAliveWriter)}
-	err = instance.BindApp(a, !noRestart, writer)
+	err = instance.BindApp(a, input.Parameters, !noRestart, writer)
 	if err != nil {
 		return err
 	}
@@ -1204,6 +1468,64 @@ func bindServiceInstance(w http.ResponseWriter, r *http.Request, t auth.Token) (
 	return nil
 }
 
+// title: rebind service instance
+// path: /services/{service}/instances/{instance}/{app}/rebind
+// method: POST
+// consume: application/x-www-form-urlencoded
+// produce: application/x-json-stream
+// responses:
+//   200: Ok
+//   400: Invalid data
+//   401: Unauthorized
+//   404: App not found
+func rebindServiceInstance(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	instanceName, appName, serviceName := r.URL.Query().Get(":instance"), r.URL.Query().Get(":app"),
+		r.URL.Query().Get(":service")
+	noRestart, _ := strconv.ParseBool(r.FormValue("noRestart"))
+	instance, a, err := getServiceInstance(serviceName, instanceName, appName)
+	if err != nil {
+		return err
+	}
+	allowed := permission.Check(t, permission.PermServiceInstanceUpdateBind,
+		append(permission.Contexts(permission.CtxTeam, instance.Teams),
+			permission.Context(permission.CtxServiceInstance, instance.Name),
+		)...,
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	allowed = permission.Check(t, permission.PermAppUpdateBindRebind,
+		contextsForApp(a)...,
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     appTarget(appName),
+		Kind:       permission.PermAppUpdateBindRebind,
+		Owner:      t,
+		CustomData: event.FormToCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermAppReadEvents, contextsForApp(a)...),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	w.Header().Set("Content-Type", "application/x-json-stream")
+	keepAliveWriter := tsuruIo.NewKeepAliveWriter(w, 30*time.Second, "")
+	defer keepAliveWriter.Stop()
+	writer := &tsuruIo.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(keepAliveWriter)}
+	err = instance.RebindApp(a, !noRestart, writer)
+	if err == service.ErrAppNotBound {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(writer, "\nInstance %q is now bound again to the app %q, with new credentials.\n", instanceName, appName)
+	return nil
+}
+
 // title: unbind service instance
 // path: /services/{service}/instances/{instance}/{app}
 // method: DELETE
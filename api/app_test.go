@@ -33,7 +33,9 @@ import (
 	"github.com/tsuru/tsuru/quota"
 	"github.com/tsuru/tsuru/repository"
 	"github.com/tsuru/tsuru/repository/repositorytest"
+	"github.com/tsuru/tsuru/router"
 	"github.com/tsuru/tsuru/router/rebuild"
+	"github.com/tsuru/tsuru/router/routertest"
 	"github.com/tsuru/tsuru/service"
 	"gopkg.in/check.v1"
 	"gopkg.in/mgo.v2/bson"
@@ -1306,6 +1308,15 @@ func (s *S) TestUpdateAppPlanOnly(c *check.C) {
 	c.Assert(err, check.IsNil)
 	c.Assert(app.Plan, check.DeepEquals, plans[0])
 	c.Assert(s.provisioner.Restarts(&a, ""), check.Equals, 1)
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget("someapp"),
+		Owner:  s.token.GetUserName(),
+		Kind:   "app.update.plan",
+		StartCustomData: []map[string]interface{}{
+			{"name": ":appname", "value": a.Name},
+			{"name": "plan", "value": "hiperplan"},
+		},
+	}, eventtest.HasEvent)
 }
 
 func (s *S) TestUpdateAppPlanNotFound(c *check.C) {
@@ -3442,6 +3453,266 @@ func (s *S) TestRemoveCNameHandlerUserWithoutAccessToTheApp(c *check.C) {
 	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
 }
 
+func (s *S) TestAppRoutersList(c *check.C) {
+	a := app.App{Name: "leper", Platform: "zend", TeamOwner: s.team.Name, Routers: []router.AppRouter{
+		{Name: "fake", Opts: map[string]string{"opt1": "val1"}},
+		{Name: "fake-tls"},
+	}}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("GET", "/apps/leper/routers", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var result []router.AppRouter
+	err = json.NewDecoder(recorder.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, []router.AppRouter{
+		{Name: "fake", Opts: map[string]string{"opt1": "val1"}, Address: "leper.fakerouter.com"},
+		{Name: "fake-tls", Address: "leper.fakerouter.com"},
+	})
+}
+
+func (s *S) TestAddAppRouter(c *check.C) {
+	a := app.App{Name: "leper", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	b := strings.NewReader("name=fake-tls&opts.opt1=val1")
+	request, err := http.NewRequest("POST", "/apps/leper/routers", b)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(routertest.TLSRouter.HasBackend(a.Name), check.Equals, true)
+	dbApp, err := app.GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Routers, check.HasLen, 2)
+	c.Assert(dbApp.Routers[1], check.DeepEquals, router.AppRouter{Name: "fake-tls", Opts: map[string]string{"opt1": "val1"}})
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget(a.Name),
+		Owner:  s.token.GetUserName(),
+		Kind:   "app.update.router.add",
+		StartCustomData: []map[string]interface{}{
+			{"name": "name", "value": "fake-tls"},
+			{"name": "opts.opt1", "value": "val1"},
+			{"name": ":app", "value": "leper"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestAddAppRouterAlreadyInApp(c *check.C) {
+	a := app.App{Name: "leper", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	b := strings.NewReader("name=fake")
+	request, err := http.NewRequest("POST", "/apps/leper/routers", b)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusConflict)
+}
+
+func (s *S) TestAddAppRouterInvalidRouter(c *check.C) {
+	a := app.App{Name: "leper", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	b := strings.NewReader("name=unknown")
+	request, err := http.NewRequest("POST", "/apps/leper/routers", b)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+}
+
+func (s *S) TestAddAppRouterWithoutPermission(c *check.C) {
+	a := app.App{Name: "leper", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	token := userWithPermission(c)
+	b := strings.NewReader("name=fake-tls")
+	request, err := http.NewRequest("POST", "/apps/leper/routers", b)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+	c.Assert(routertest.TLSRouter.HasBackend(a.Name), check.Equals, false)
+}
+
+func (s *S) TestRemoveAppRouter(c *check.C) {
+	a := app.App{Name: "leper", Platform: "zend", TeamOwner: s.team.Name, Routers: []router.AppRouter{{Name: "fake"}, {Name: "fake-tls"}}}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("DELETE", "/apps/leper/routers/fake-tls", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(routertest.TLSRouter.HasBackend(a.Name), check.Equals, false)
+	dbApp, err := app.GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Routers, check.HasLen, 1)
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget(a.Name),
+		Owner:  s.token.GetUserName(),
+		Kind:   "app.update.router.remove",
+		StartCustomData: []map[string]interface{}{
+			{"name": "router", "value": "fake-tls"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestRemoveAppRouterNotInApp(c *check.C) {
+	a := app.App{Name: "leper", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("DELETE", "/apps/leper/routers/fake-tls", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestRemoveAppRouterLastRouter(c *check.C) {
+	a := app.App{Name: "leper", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("DELETE", "/apps/leper/routers/fake", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(routertest.FakeRouter.HasBackend(a.Name), check.Equals, true)
+}
+
+func (s *S) TestSetRoutesWeights(c *check.C) {
+	a := app.App{Name: "leper", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	other := app.App{Name: "leper-new", Platform: "zend", TeamOwner: s.team.Name}
+	err = app.CreateApp(&other, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.AddCName("leper.secretcompany.com")
+	c.Assert(err, check.IsNil)
+	url := fmt.Sprintf("/apps/%s/routes/weights", a.Name)
+	b := strings.NewReader("cname=leper.secretcompany.com&weights.leper=80&weights.leper-new=20")
+	request, err := http.NewRequest("POST", url, b)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	weights, err := a.CNamesWeights()
+	c.Assert(err, check.IsNil)
+	c.Assert(weights, check.DeepEquals, map[string][]router.BackendWeight{
+		"leper.secretcompany.com": {{Backend: "leper", Weight: 80}, {Backend: "leper-new", Weight: 20}},
+	})
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget(a.Name),
+		Owner:  s.token.GetUserName(),
+		Kind:   "app.update.routes.weights",
+		StartCustomData: []map[string]interface{}{
+			{"name": "cname", "value": "leper.secretcompany.com"},
+			{"name": "weights.leper", "value": "80"},
+			{"name": "weights.leper-new", "value": "20"},
+			{"name": ":app", "value": "leper"},
+		},
+	}, eventtest.HasEvent)
+	request, err = http.NewRequest("GET", url, nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder = httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var result map[string][]router.BackendWeight
+	err = json.NewDecoder(recorder.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, weights)
+}
+
+func (s *S) TestSetRoutesWeightsInvalid(c *check.C) {
+	a := app.App{Name: "leper", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.AddCName("leper.secretcompany.com")
+	c.Assert(err, check.IsNil)
+	url := fmt.Sprintf("/apps/%s/routes/weights", a.Name)
+	b := strings.NewReader("cname=leper.secretcompany.com&weights.leper=80")
+	request, err := http.NewRequest("POST", url, b)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, router.ErrInvalidWeights.Error()+"\n")
+}
+
+func (s *S) TestSetRoutesWeightsWithoutAccessToOtherApp(c *check.C) {
+	a := app.App{Name: "leper", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	other := app.App{Name: "leper-new", Platform: "zend", TeamOwner: s.team.Name}
+	err = app.CreateApp(&other, s.user)
+	c.Assert(err, check.IsNil)
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermAppUpdateRoutesWeights,
+		Context: permission.Context(permission.CtxApp, a.Name),
+	})
+	url := fmt.Sprintf("/apps/%s/routes/weights", a.Name)
+	b := strings.NewReader("cname=leper.secretcompany.com&weights.leper=80&weights.leper-new=20")
+	request, err := http.NewRequest("POST", url, b)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestSetRoutesWeightsCNameNotFound(c *check.C) {
+	a := app.App{Name: "leper", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	url := fmt.Sprintf("/apps/%s/routes/weights", a.Name)
+	b := strings.NewReader("cname=leper.secretcompany.com&weights.leper=100")
+	request, err := http.NewRequest("POST", url, b)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+	c.Assert(recorder.Body.String(), check.Equals, router.ErrCNameNotFound.Error()+"\n")
+}
+
+func (s *S) TestRoutesWeightsListEmpty(c *check.C) {
+	a := app.App{Name: "leper", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("GET", "/apps/leper/routes/weights", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNoContent)
+}
+
 func (s *S) TestAppLogShouldReturnNotFoundWhenAppDoesNotExist(c *check.C) {
 	request, err := http.NewRequest("GET", "/apps/unknown/log/?:app=unknown&lines=10", nil)
 	c.Assert(err, check.IsNil)
@@ -3896,6 +4167,53 @@ func (s *S) TestBindHandler(c *check.C) {
 	}, eventtest.HasEvent)
 }
 
+func (s *S) TestBindHandlerWithParameters(c *check.C) {
+	var params url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		params = r.Form
+		w.Write([]byte(`{"DATABASE_USER":"reader","DATABASE_PASSWORD":"s3cr3t"}`))
+	}))
+	defer ts.Close()
+	srvc := service.Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := srvc.Create()
+	c.Assert(err, check.IsNil)
+	defer s.conn.Services().Remove(bson.M{"_id": "mysql"})
+	instance := service.ServiceInstance{
+		Name:        "my-mysql",
+		ServiceName: "mysql",
+		Teams:       []string{s.team.Name},
+	}
+	err = instance.Create()
+	c.Assert(err, check.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": "my-mysql"})
+	a := app.App{
+		Name:      "painkiller",
+		Platform:  "zend",
+		TeamOwner: s.team.Name,
+		Env:       map[string]bind.EnvVar{},
+	}
+	err = app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	u := fmt.Sprintf("/services/%s/instances/%s/%s", instance.ServiceName, instance.Name, a.Name)
+	b := strings.NewReader("noRestart=false&parameters.role=readonly")
+	request, err := http.NewRequest("PUT", u, b)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(params.Get("parameters.role"), check.Equals, "readonly")
+	err = s.conn.ServiceInstances().Find(bson.M{"name": instance.Name}).One(&instance)
+	c.Assert(err, check.IsNil)
+	c.Assert(instance.Apps, check.DeepEquals, []string{a.Name})
+	c.Assert(instance.BindParameters, check.DeepEquals, map[string]map[string]string{
+		a.Name: {"role": "readonly"},
+	})
+}
+
 func (s *S) TestBindHandlerWithoutEnvsDontRestartTheApp(c *check.C) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`{}`))
@@ -4136,6 +4454,126 @@ func (s *S) TestBindWithManyInstanceNameWithSameNameAndNoRestartFlag(c *check.C)
 	}, eventtest.HasEvent)
 }
 
+func (s *S) TestRebindHandler(c *check.C) {
+	var calls []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		calls = append(calls, r.Method+" "+r.Form.Get("parameters.role"))
+		if r.Method == "POST" {
+			w.Write([]byte(`{"DATABASE_USER":"reader","DATABASE_TOKEN":"t0k3n"}`))
+		}
+	}))
+	defer ts.Close()
+	srvc := service.Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := srvc.Create()
+	c.Assert(err, check.IsNil)
+	defer s.conn.Services().Remove(bson.M{"_id": "mysql"})
+	a := app.App{
+		Name:      "painkiller",
+		Platform:  "zend",
+		TeamOwner: s.team.Name,
+	}
+	err = app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 1, "web", nil)
+	instance := service.ServiceInstance{
+		Name:           "my-mysql",
+		ServiceName:    "mysql",
+		Teams:          []string{s.team.Name},
+		Apps:           []string{a.Name},
+		BindParameters: map[string]map[string]string{a.Name: {"role": "readonly"}},
+	}
+	err = instance.Create()
+	c.Assert(err, check.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": "my-mysql"})
+	err = a.AddInstance(bind.InstanceApp{
+		ServiceName: "mysql",
+		Instance: bind.ServiceInstance{
+			Name: "my-mysql",
+			Envs: map[string]string{"DATABASE_USER": "reader", "DATABASE_PASSWORD": "s3cr3t"},
+		},
+	}, nil)
+	c.Assert(err, check.IsNil)
+	u := fmt.Sprintf("/services/%s/instances/%s/%s/rebind", instance.ServiceName, instance.Name, a.Name)
+	request, err := http.NewRequest("POST", u, strings.NewReader("noRestart=false"))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/x-json-stream")
+	c.Assert(calls, check.DeepEquals, []string{"DELETE ", "POST readonly"})
+	c.Assert(recorder.Body.String(), check.Matches, `(?s).*Instance \\"my-mysql\\" is now bound again to the app \\"painkiller\\", with new credentials.*`)
+	err = s.conn.Apps().Find(bson.M{"name": a.Name}).One(&a)
+	c.Assert(err, check.IsNil)
+	c.Assert(a.Env["DATABASE_TOKEN"], check.DeepEquals, bind.EnvVar{Name: "DATABASE_TOKEN", Value: "t0k3n", InstanceName: instance.Name})
+	_, ok := a.Env["DATABASE_PASSWORD"]
+	c.Assert(ok, check.Equals, false)
+	c.Assert(s.provisioner.Restarts(&a, ""), check.Equals, 1)
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget(a.Name),
+		Owner:  s.token.GetUserName(),
+		Kind:   "app.update.bind.rebind",
+		StartCustomData: []map[string]interface{}{
+			{"name": ":app", "value": a.Name},
+			{"name": ":instance", "value": instance.Name},
+			{"name": ":service", "value": instance.ServiceName},
+			{"name": "noRestart", "value": "false"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestRebindHandlerAppNotBound(c *check.C) {
+	srvc := service.Service{Name: "mysql"}
+	err := srvc.Create()
+	c.Assert(err, check.IsNil)
+	defer s.conn.Services().Remove(bson.M{"_id": "mysql"})
+	instance := service.ServiceInstance{Name: "my-mysql", ServiceName: "mysql", Teams: []string{s.team.Name}}
+	err = instance.Create()
+	c.Assert(err, check.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": "my-mysql"})
+	a := app.App{Name: "painkiller", Platform: "zend", TeamOwner: s.team.Name}
+	err = app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	u := fmt.Sprintf("/services/%s/instances/%s/%s/rebind", instance.ServiceName, instance.Name, a.Name)
+	request, err := http.NewRequest("POST", u, nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, service.ErrAppNotBound.Error()+"\n")
+}
+
+func (s *S) TestRebindHandlerReturns403IfTheUserDoesNotHaveAccessToTheApp(c *check.C) {
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermServiceInstanceUpdateBind,
+		Context: permission.Context(permission.CtxTeam, s.team.Name),
+	}, permission.Permission{
+		Scheme:  permission.PermAppUpdateBindRebind,
+		Context: permission.Context(permission.CtxTeam, "other-team"),
+	})
+	instance := service.ServiceInstance{Name: "my-mysql", ServiceName: "mysql", Teams: []string{s.team.Name}}
+	err := instance.Create()
+	c.Assert(err, check.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": "my-mysql"})
+	a := app.App{Name: "serviceapp", Platform: "zend", TeamOwner: s.team.Name}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	u := fmt.Sprintf("/services/%s/instances/%s/%s/rebind", instance.ServiceName, instance.Name, a.Name)
+	request, err := http.NewRequest("POST", u, nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
 func (s *S) TestUnbindHandler(c *check.C) {
 	s.provisioner.PrepareOutput([]byte("exported"))
 	var called int32
@@ -16,6 +16,7 @@ import (
 	"github.com/tsuru/tsuru/event"
 	"github.com/tsuru/tsuru/log"
 	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/provision"
 	"github.com/tsuru/tsuru/repository"
 )
 
@@ -123,6 +124,9 @@ func login(w http.ResponseWriter, r *http.Request) (err error) {
 		params[key] = r.FormValue(key)
 	}
 	token, err := app.AuthScheme.Login(params)
+	if err == auth.ErrTwoFactorCodeRequired {
+		w.Header().Set(twoFactorHeader, "required")
+	}
 	if err != nil {
 		return handleAuthError(err)
 	}
@@ -316,6 +320,121 @@ func removeTeam(w http.ResponseWriter, r *http.Request, t auth.Token) (err error
 	return nil
 }
 
+// title: team info
+// path: /teams/{name}
+// method: GET
+// produce: application/json
+// responses:
+//   200: Info about the team
+//   401: Unauthorized
+//   404: Not found
+func teamInfo(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	name := r.URL.Query().Get(":name")
+	allowed := permission.Check(t, permission.PermTeamRead,
+		permission.Context(permission.CtxTeam, name),
+	)
+	if !allowed {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: fmt.Sprintf(`Team "%s" not found.`, name)}
+	}
+	team, err := auth.GetTeam(name)
+	if err != nil {
+		if err == auth.ErrTeamNotFound {
+			return &errors.HTTP{Code: http.StatusNotFound, Message: fmt.Sprintf(`Team "%s" not found.`, name)}
+		}
+		return err
+	}
+	members, err := auth.ListTeamMembers(name)
+	if err != nil {
+		return err
+	}
+	apps, err := app.List(&app.Filter{TeamOwner: name})
+	if err != nil {
+		return err
+	}
+	appNames := make([]string, len(apps))
+	for i, a := range apps {
+		appNames[i] = a.Name
+	}
+	pools, err := provision.ListPoolsForTeam(name)
+	if err != nil {
+		return err
+	}
+	poolNames := make([]string, len(pools))
+	for i, p := range pools {
+		poolNames[i] = p.Name
+	}
+	result := map[string]interface{}{
+		"name":          team.Name,
+		"tags":          team.Tags,
+		"contact_email": team.ContactEmail,
+		"cost_center":   team.CostCenter,
+		"members":       members,
+		"apps":          appNames,
+		"pools":         poolNames,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(result)
+}
+
+// title: update team
+// path: /teams/{name}
+// method: PUT
+// consume: application/x-www-form-urlencoded
+// responses:
+//   200: Team updated
+//   400: Invalid data
+//   401: Unauthorized
+//   404: Not found
+//   409: Team already exists
+func updateTeam(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	name := r.URL.Query().Get(":name")
+	allowed := permission.Check(t, permission.PermTeamUpdate,
+		permission.Context(permission.CtxTeam, name),
+	)
+	if !allowed {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: fmt.Sprintf(`Team "%s" not found.`, name)}
+	}
+	opts := auth.UpdateTeamOptions{NewName: r.FormValue("name")}
+	if tags, ok := r.Form["tag"]; ok {
+		opts.Tags = []string{}
+		for _, tag := range tags {
+			if tag != "" {
+				opts.Tags = append(opts.Tags, tag)
+			}
+		}
+	}
+	if _, ok := r.Form["contact_email"]; ok {
+		contactEmail := r.FormValue("contact_email")
+		opts.ContactEmail = &contactEmail
+	}
+	if _, ok := r.Form["cost_center"]; ok {
+		costCenter := r.FormValue("cost_center")
+		opts.CostCenter = &costCenter
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     teamTarget(name),
+		Kind:       permission.PermTeamUpdate,
+		Owner:      t,
+		CustomData: event.FormToCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermTeamReadEvents, permission.Context(permission.CtxTeam, name)),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	err = auth.UpdateTeam(name, opts)
+	switch err {
+	case auth.ErrInvalidTeamName, auth.ErrInvalidTeamContactEmail:
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	case auth.ErrTeamNotFound:
+		return &errors.HTTP{Code: http.StatusNotFound, Message: fmt.Sprintf(`Team "%s" not found.`, name)}
+	case auth.ErrTeamAlreadyExists:
+		return &errors.HTTP{Code: http.StatusConflict, Message: err.Error()}
+	}
+	return err
+}
+
 // title: team list
 // path: /teams
 // method: GET
@@ -583,6 +583,179 @@ Service instances: my_nosql, my_nosql-2`
 	c.Assert(e.Message, check.Equals, expected)
 }
 
+func (s *AuthSuite) TestTeamInfo(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Teams().UpdateId(s.team.Name, bson.M{"$set": bson.M{
+		"tags":         []string{"prod"},
+		"contactemail": "team@tsuru.io",
+		"costcenter":   "cc-1",
+	}})
+	c.Assert(err, check.IsNil)
+	err = conn.Apps().Insert(app.App{Name: "myapp", TeamOwner: s.team.Name, Teams: []string{s.team.Name}})
+	c.Assert(err, check.IsNil)
+	err = conn.Apps().Insert(app.App{Name: "otherapp", TeamOwner: s.team2.Name, Teams: []string{s.team.Name}})
+	c.Assert(err, check.IsNil)
+	err = provision.AddPool(provision.AddPoolOptions{Name: "pool1"})
+	c.Assert(err, check.IsNil)
+	err = provision.AddTeamsToPool("pool1", []string{s.team.Name})
+	c.Assert(err, check.IsNil)
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermTeamRead,
+		Context: permission.Context(permission.CtxTeam, s.team.Name),
+	})
+	request, err := http.NewRequest("GET", "/teams/"+s.team.Name, nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	mux := RunServer(true)
+	mux.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var result map[string]interface{}
+	err = json.Unmarshal(recorder.Body.Bytes(), &result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, map[string]interface{}{
+		"name":          s.team.Name,
+		"tags":          []interface{}{"prod"},
+		"contact_email": "team@tsuru.io",
+		"cost_center":   "cc-1",
+		"members": []interface{}{
+			map[string]interface{}{
+				"email": "majortom@groundcontrol.com",
+				"roles": []interface{}{"majortomteam.read" + s.team.Name},
+			},
+		},
+		"apps":  []interface{}{"myapp"},
+		"pools": []interface{}{"pool1"},
+	})
+}
+
+func (s *AuthSuite) TestTeamInfoWithoutPermission(c *check.C) {
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermTeamRead,
+		Context: permission.Context(permission.CtxTeam, s.team2.Name),
+	})
+	request, err := http.NewRequest("GET", "/teams/"+s.team.Name, nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	mux := RunServer(true)
+	mux.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *AuthSuite) TestTeamInfoNotFound(c *check.C) {
+	request, err := http.NewRequest("GET", "/teams/unknown", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	mux := RunServer(true)
+	mux.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *AuthSuite) TestUpdateTeam(c *check.C) {
+	body := strings.NewReader("tag=prod&tag=&tag=web&contact_email=team@tsuru.io")
+	request, err := http.NewRequest("PUT", "/teams/"+s.team.Name, body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	mux := RunServer(true)
+	mux.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	team, err := auth.GetTeam(s.team.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(team.Tags, check.DeepEquals, []string{"prod", "web"})
+	c.Assert(team.ContactEmail, check.Equals, "team@tsuru.io")
+	c.Assert(team.CostCenter, check.Equals, "")
+	c.Assert(eventtest.EventDesc{
+		Target: teamTarget(s.team.Name),
+		Owner:  s.token.GetUserName(),
+		Kind:   "team.update",
+		StartCustomData: []map[string]interface{}{
+			{"name": ":name", "value": s.team.Name},
+			{"name": "tag", "value": []interface{}{"prod", "", "web"}},
+			{"name": "contact_email", "value": "team@tsuru.io"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *AuthSuite) TestUpdateTeamRename(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Apps().Insert(app.App{Name: "myapp", TeamOwner: s.team.Name, Teams: []string{s.team.Name}})
+	c.Assert(err, check.IsNil)
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermTeamUpdate,
+		Context: permission.Context(permission.CtxTeam, s.team.Name),
+	})
+	body := strings.NewReader("name=newteam")
+	request, err := http.NewRequest("PUT", "/teams/"+s.team.Name, body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	mux := RunServer(true)
+	mux.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	_, err = auth.GetTeam(s.team.Name)
+	c.Assert(err, check.Equals, auth.ErrTeamNotFound)
+	_, err = auth.GetTeam("newteam")
+	c.Assert(err, check.IsNil)
+	a, err := app.GetByName("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(a.TeamOwner, check.Equals, "newteam")
+	c.Assert(a.Teams, check.DeepEquals, []string{"newteam"})
+	c.Assert(permission.Check(token, permission.PermTeamUpdate, permission.Context(permission.CtxTeam, "newteam")), check.Equals, true)
+}
+
+func (s *AuthSuite) TestUpdateTeamRenameAlreadyExists(c *check.C) {
+	body := strings.NewReader("name=" + s.team2.Name)
+	request, err := http.NewRequest("PUT", "/teams/"+s.team.Name, body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	mux := RunServer(true)
+	mux.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusConflict)
+}
+
+func (s *AuthSuite) TestUpdateTeamInvalidContactEmail(c *check.C) {
+	body := strings.NewReader("contact_email=team")
+	request, err := http.NewRequest("PUT", "/teams/"+s.team.Name, body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	mux := RunServer(true)
+	mux.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, auth.ErrInvalidTeamContactEmail.Error()+"\n")
+}
+
+func (s *AuthSuite) TestUpdateTeamWithoutPermission(c *check.C) {
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermTeamRead,
+		Context: permission.Context(permission.CtxTeam, s.team.Name),
+	})
+	body := strings.NewReader("name=newteam")
+	request, err := http.NewRequest("PUT", "/teams/"+s.team.Name, body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	mux := RunServer(true)
+	mux.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+	_, err = auth.GetTeam(s.team.Name)
+	c.Assert(err, check.IsNil)
+}
+
 func (s *AuthSuite) TestListTeamsListsAllTeamsThatTheUserHasAccess(c *check.C) {
 	token := userWithPermission(c, permission.Permission{
 		Scheme:  permission.PermAppCreate,
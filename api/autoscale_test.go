@@ -0,0 +1,155 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestListAutoScaleRules(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	rule := app.AutoScaleRule{Process: "web", Metric: "cpu", Target: 50, MinUnits: 1, MaxUnits: 5, Enabled: true}
+	err = a.SetAutoScaleRule(rule)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("GET", "/apps/myapp/autoscale", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var rules []app.AutoScaleRule
+	err = json.NewDecoder(recorder.Body).Decode(&rules)
+	c.Assert(err, check.IsNil)
+	rule.App = a.Name
+	c.Assert(rules, check.DeepEquals, []app.AutoScaleRule{rule})
+}
+
+func (s *S) TestListAutoScaleRulesEmpty(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("GET", "/apps/myapp/autoscale", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNoContent)
+}
+
+func (s *S) TestSetAutoScaleRule(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	body := strings.NewReader("process=web&metric=cpu&target=60&minunits=2&maxunits=8&enabled=true")
+	request, err := http.NewRequest("POST", "/apps/myapp/autoscale", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	rules, err := a.AutoScaleRules()
+	c.Assert(err, check.IsNil)
+	c.Assert(rules, check.DeepEquals, []app.AutoScaleRule{
+		{App: "myapp", Process: "web", Metric: "cpu", Target: 60, MinUnits: 2, MaxUnits: 8, Enabled: true},
+	})
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget("myapp"),
+		Owner:  s.token.GetUserName(),
+		Kind:   "app.update.autoscale",
+		StartCustomData: []map[string]interface{}{
+			{"name": ":app", "value": "myapp"},
+			{"name": "process", "value": "web"},
+			{"name": "metric", "value": "cpu"},
+			{"name": "target", "value": "60"},
+			{"name": "minunits", "value": "2"},
+			{"name": "maxunits", "value": "8"},
+			{"name": "enabled", "value": "true"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestSetAutoScaleRuleInvalid(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	body := strings.NewReader("process=web&metric=disk&target=60&minunits=2&maxunits=8")
+	request, err := http.NewRequest("POST", "/apps/myapp/autoscale", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Matches, `invalid autoscale metric "disk".*\n`)
+}
+
+func (s *S) TestSetAutoScaleRuleUnauthorized(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermAppRead,
+		Context: permission.Context(permission.CtxApp, a.Name),
+	})
+	body := strings.NewReader("process=web&metric=cpu&target=60&minunits=2&maxunits=8")
+	request, err := http.NewRequest("POST", "/apps/myapp/autoscale", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestRemoveAutoScaleRule(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.SetAutoScaleRule(app.AutoScaleRule{Process: "web", Metric: "cpu", Target: 50, MinUnits: 1, MaxUnits: 5})
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("DELETE", "/apps/myapp/autoscale/web", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	rules, err := a.AutoScaleRules()
+	c.Assert(err, check.IsNil)
+	c.Assert(rules, check.HasLen, 0)
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget("myapp"),
+		Owner:  s.token.GetUserName(),
+		Kind:   "app.update.autoscale",
+		StartCustomData: []map[string]interface{}{
+			{"name": ":app", "value": "myapp"},
+			{"name": ":process", "value": "web"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestRemoveAutoScaleRuleNotFound(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("DELETE", "/apps/myapp/autoscale/web", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+	c.Assert(recorder.Body.String(), check.Equals, app.ErrAutoScaleRuleNotFound.Error()+"\n")
+}
@@ -21,9 +21,31 @@ import (
 	"github.com/tsuru/tsuru/event"
 	"github.com/tsuru/tsuru/io"
 	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/provision"
 	"github.com/tsuru/tsuru/repository"
 )
 
+func deployStrategyFromRequest(r *http.Request) (provision.DeployStrategy, error) {
+	var err error
+	strategy := provision.DeployStrategy{Type: r.FormValue("strategy")}
+	if step := r.FormValue("strategy-step"); step != "" {
+		strategy.StepPercent, err = strconv.Atoi(step)
+		if err != nil {
+			return strategy, &tsuruErrors.HTTP{Code: http.StatusBadRequest, Message: "invalid strategy step: " + err.Error()}
+		}
+	}
+	if pause := r.FormValue("strategy-pause"); pause != "" {
+		strategy.Pause, err = time.ParseDuration(pause)
+		if err != nil {
+			return strategy, &tsuruErrors.HTTP{Code: http.StatusBadRequest, Message: "invalid strategy pause: " + err.Error()}
+		}
+	}
+	if err = strategy.Validate(); err != nil {
+		return strategy, &tsuruErrors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	return strategy, nil
+}
+
 // title: app deploy
 // path: /apps/{appname}/deploy
 // method: POST
@@ -58,6 +80,10 @@ func deploy(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 			Message: "you must specify either the archive-url, a image url or upload a file.",
 		}
 	}
+	strategy, err := deployStrategyFromRequest(r)
+	if err != nil {
+		return err
+	}
 	commit := r.FormValue("commit")
 	w.Header().Set("Content-Type", "text")
 	appName := r.URL.Query().Get(":appname")
@@ -74,14 +100,17 @@ func deploy(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 		}
 	}
 	var userName string
+	var owner event.Owner
 	if t.IsAppToken() {
 		if t.GetAppName() != appName && t.GetAppName() != app.InternalAppName {
 			return &tsuruErrors.HTTP{Code: http.StatusUnauthorized, Message: "invalid app token"}
 		}
 		userName = r.FormValue("user")
+		owner = event.Owner{Type: event.OwnerTypeUser, Name: userName}
 	} else {
 		commit = ""
 		userName = t.GetUserName()
+		owner = event.TokenOwner(t)
 	}
 	instance, err := app.GetByName(appName)
 	if err != nil {
@@ -123,6 +152,7 @@ func deploy(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 		Origin:     origin,
 		Build:      build,
 		Message:    message,
+		Strategy:   strategy,
 	}
 	opts.GetKind()
 	if t.GetAppName() != app.InternalAppName {
@@ -135,7 +165,7 @@ func deploy(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 	evt, err := event.New(&event.Opts{
 		Target:        appTarget(appName),
 		Kind:          permission.PermAppDeploy,
-		RawOwner:      event.Owner{Type: event.OwnerTypeUser, Name: userName},
+		RawOwner:      owner,
 		CustomData:    opts,
 		Allowed:       event.Allowed(permission.PermAppReadEvents, contextsForApp(instance)...),
 		AllowedCancel: event.Allowed(permission.PermAppUpdateEvents, contextsForApp(instance)...),
@@ -241,6 +271,10 @@ func deployRollback(w http.ResponseWriter, r *http.Request, t auth.Token) error
 			}
 		}
 	}
+	strategy, err := deployStrategyFromRequest(r)
+	if err != nil {
+		return err
+	}
 	w.Header().Set("Content-Type", "application/x-json-stream")
 	keepAliveWriter := io.NewKeepAliveWriter(w, 30*time.Second, "")
 	defer keepAliveWriter.Stop()
@@ -252,6 +286,7 @@ func deployRollback(w http.ResponseWriter, r *http.Request, t auth.Token) error
 		User:         t.GetUserName(),
 		Origin:       origin,
 		Rollback:     true,
+		Strategy:     strategy,
 	}
 	opts.GetKind()
 	canRollback := permission.Check(t, permSchemeForDeploy(opts), contextsForApp(instance)...)
@@ -204,6 +204,23 @@ func (s *DeploySuite) TestDeployInvalidOrigin(c *check.C) {
 	c.Assert(recorder.Body.String(), check.Equals, "Invalid deployment origin\n")
 }
 
+func (s *DeploySuite) TestDeployInvalidStrategy(c *check.C) {
+	a := app.App{Name: "otherapp", Platform: "python", TeamOwner: s.team.Name}
+	user, _ := s.token.User()
+	err := app.CreateApp(&a, user)
+	c.Assert(err, check.IsNil)
+	url := fmt.Sprintf("/apps/%s/repository/clone?:appname=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("archive-url=http://something.tar.gz&user=fulano&strategy=canary&strategy-step=0"))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, "canary step percentage must be between 1 and 100\n")
+}
+
 func (s *DeploySuite) TestDeployOriginImage(c *check.C) {
 	user, _ := s.token.User()
 	a := app.App{Name: "otherapp", Platform: "python", TeamOwner: s.team.Name}
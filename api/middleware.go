@@ -36,7 +36,13 @@ func validate(token string, r *http.Request) (auth.Token, error) {
 	if err != nil {
 		t, err = auth.APIAuth(token)
 		if err != nil {
-			return nil, err
+			t, err = auth.AccessTokenAuth(token)
+			if err != nil {
+				t, err = auth.ServiceAccountAuth(token)
+				if err != nil {
+					return nil, err
+				}
+			}
 		}
 	}
 	if t.IsAppToken() {
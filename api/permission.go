@@ -97,6 +97,10 @@ func removeRole(w http.ResponseWriter, r *http.Request, t auth.Token) (err error
 	if err != nil {
 		return err
 	}
+	err = auth.RemoveRoleFromAllServiceAccounts(roleName)
+	if err != nil {
+		return err
+	}
 	err = permission.DestroyRole(roleName)
 	if err == permission.ErrRoleNotFound {
 		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
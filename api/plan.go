@@ -6,13 +6,17 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/tsuru/tsuru/app"
 	"github.com/tsuru/tsuru/auth"
 	"github.com/tsuru/tsuru/errors"
 	"github.com/tsuru/tsuru/event"
+	tsuruIo "github.com/tsuru/tsuru/io"
 	"github.com/tsuru/tsuru/permission"
 	"github.com/tsuru/tsuru/router"
 )
@@ -79,6 +83,114 @@ func addPlan(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 	return err
 }
 
+// title: plan update
+// path: /plans/{name}
+// method: PUT
+// consume: application/x-www-form-urlencoded
+// produce: application/x-json-stream
+// responses:
+//   200: Plan updated
+//   400: Invalid data
+//   401: Unauthorized
+//   404: Plan not found
+func updatePlan(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	allowed := permission.Check(t, permission.PermPlanUpdate)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	planName := r.URL.Query().Get(":planname")
+	plan, err := app.GetPlanByName(planName)
+	if err == app.ErrPlanNotFound {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	if err != nil {
+		return err
+	}
+	if value := r.FormValue("memory"); value != "" {
+		plan.Memory = getSize(value)
+	}
+	if value := r.FormValue("swap"); value != "" {
+		plan.Swap = getSize(value)
+	}
+	if value := r.FormValue("cpushare"); value != "" {
+		plan.CpuShare, err = strconv.Atoi(value)
+		if err != nil {
+			return &errors.HTTP{Code: http.StatusBadRequest, Message: fmt.Sprintf("invalid cpushare %q: must be an integer", value)}
+		}
+	}
+	if value := r.FormValue("default"); value != "" {
+		plan.Default, err = strconv.ParseBool(value)
+		if err != nil {
+			return &errors.HTTP{Code: http.StatusBadRequest, Message: fmt.Sprintf("invalid default %q: must be a boolean", value)}
+		}
+	}
+	if value := r.FormValue("router"); value != "" {
+		plan.Router = value
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     event.Target{Type: event.TargetTypePlan, Value: planName},
+		Kind:       permission.PermPlanUpdate,
+		Owner:      t,
+		CustomData: event.FormToCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermPlanReadEvents),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	err = plan.Update()
+	if _, ok := err.(app.PlanValidationError); ok || err == app.ErrLimitOfMemory || err == app.ErrLimitOfCpuShare {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	if err != nil {
+		return err
+	}
+	keepAliveWriter := tsuruIo.NewKeepAliveWriter(w, 30*time.Second, "")
+	defer keepAliveWriter.Stop()
+	w.Header().Set("Content-Type", "application/x-json-stream")
+	writer := &tsuruIo.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(keepAliveWriter)}
+	return updatePlanApps(planName, t, writer)
+}
+
+// updatePlanApps applies the plan to all apps using it, each app change is
+// recorded in its own app.update.plan event.
+func updatePlanApps(planName string, t auth.Token, w io.Writer) error {
+	apps, err := app.List(&app.Filter{Plan: planName})
+	if err != nil {
+		return err
+	}
+	multiErr := errors.NewMultiError()
+	for i := range apps {
+		a := &apps[i]
+		fmt.Fprintf(w, "---- Applying plan %q to app %q ----\n", planName, a.Name)
+		err = changeAppPlan(a, planName, t, w)
+		if err != nil {
+			fmt.Fprintf(w, "Unable to apply plan to app %q: %s\n", a.Name, err)
+			multiErr.Add(fmt.Errorf("unable to apply plan to app %q: %s", a.Name, err))
+		}
+	}
+	if multiErr.Len() > 0 {
+		return multiErr
+	}
+	return nil
+}
+
+func changeAppPlan(a *app.App, planName string, t auth.Token, w io.Writer) (err error) {
+	evt, err := event.New(&event.Opts{
+		Target:     appTarget(a.Name),
+		Kind:       permission.PermAppUpdatePlan,
+		Owner:      t,
+		CustomData: []map[string]interface{}{{"name": "plan", "value": planName}},
+		Allowed:    event.Allowed(permission.PermAppReadEvents, contextsForApp(a)...),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	return a.ChangePlan(planName, w)
+}
+
 // title: plan list
 // path: /plans
 // method: GET
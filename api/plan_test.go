@@ -252,6 +252,128 @@ func (s *S) TestPlanRemoveInvalid(c *check.C) {
 	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
 }
 
+func (s *S) TestPlanUpdate(c *check.C) {
+	plan := app.Plan{Name: "plan1", Memory: 268435456, Swap: 268435456, CpuShare: 100}
+	err := plan.Save()
+	c.Assert(err, check.IsNil)
+	defer app.PlanRemove(plan.Name)
+	a := app.App{Name: "someapp", Platform: "zend", TeamOwner: s.team.Name, Plan: plan}
+	err = app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	defer s.logConn.Logs(a.Name).DropCollection()
+	recorder := httptest.NewRecorder()
+	body := strings.NewReader("memory=512M&cpushare=200")
+	request, err := http.NewRequest("PUT", "/plans/plan1", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/x-json-stream")
+	expected := app.Plan{Name: "plan1", Memory: 536870912, Swap: 268435456, CpuShare: 200}
+	dbPlan, err := app.GetPlanByName("plan1")
+	c.Assert(err, check.IsNil)
+	c.Assert(*dbPlan, check.DeepEquals, expected)
+	dbApp, err := app.GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Plan, check.DeepEquals, expected)
+	c.Assert(s.provisioner.Restarts(dbApp, ""), check.Equals, 1)
+	c.Assert(eventtest.EventDesc{
+		Target: event.Target{Type: event.TargetTypePlan, Value: "plan1"},
+		Owner:  s.token.GetUserName(),
+		Kind:   "plan.update",
+		StartCustomData: []map[string]interface{}{
+			{"name": ":planname", "value": "plan1"},
+			{"name": "memory", "value": "512M"},
+			{"name": "cpushare", "value": "200"},
+		},
+	}, eventtest.HasEvent)
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget(a.Name),
+		Owner:  s.token.GetUserName(),
+		Kind:   "app.update.plan",
+		StartCustomData: []map[string]interface{}{
+			{"name": "plan", "value": "plan1"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestPlanUpdateInvalid(c *check.C) {
+	plan := app.Plan{Name: "plan1", Memory: 268435456, Swap: 268435456, CpuShare: 100}
+	err := plan.Save()
+	c.Assert(err, check.IsNil)
+	defer app.PlanRemove(plan.Name)
+	recorder := httptest.NewRecorder()
+	body := strings.NewReader("cpushare=1")
+	request, err := http.NewRequest("PUT", "/plans/plan1", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, app.ErrLimitOfCpuShare.Error()+"\n")
+}
+
+func (s *S) TestPlanUpdateInvalidValues(c *check.C) {
+	plan := app.Plan{Name: "plan1", Memory: 268435456, Swap: 268435456, CpuShare: 100}
+	err := plan.Save()
+	c.Assert(err, check.IsNil)
+	defer app.PlanRemove(plan.Name)
+	tests := []struct {
+		body     string
+		expected string
+	}{
+		{body: "cpushare=abc", expected: `invalid cpushare "abc": must be an integer`},
+		{body: "default=maybe", expected: `invalid default "maybe": must be a boolean`},
+	}
+	for _, tt := range tests {
+		recorder := httptest.NewRecorder()
+		request, err := http.NewRequest("PUT", "/plans/plan1", strings.NewReader(tt.body))
+		c.Assert(err, check.IsNil)
+		request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		m := RunServer(true)
+		m.ServeHTTP(recorder, request)
+		c.Check(recorder.Code, check.Equals, http.StatusBadRequest)
+		c.Check(recorder.Body.String(), check.Equals, tt.expected+"\n")
+	}
+	dbPlan, err := app.GetPlanByName(plan.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbPlan.CpuShare, check.Equals, 100)
+	c.Assert(dbPlan.Default, check.Equals, false)
+}
+
+func (s *S) TestPlanUpdateNotFound(c *check.C) {
+	recorder := httptest.NewRecorder()
+	body := strings.NewReader("cpushare=100")
+	request, err := http.NewRequest("PUT", "/plans/plan999", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestPlanUpdateNoPermission(c *check.C) {
+	token := userWithPermission(c)
+	plan := app.Plan{Name: "plan1", Memory: 268435456, Swap: 268435456, CpuShare: 100}
+	err := plan.Save()
+	c.Assert(err, check.IsNil)
+	defer app.PlanRemove(plan.Name)
+	recorder := httptest.NewRecorder()
+	body := strings.NewReader("cpushare=200")
+	request, err := http.NewRequest("PUT", "/plans/plan1", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
 func (s *S) TestRoutersListNoContent(c *check.C) {
 	err := config.Unset("routers")
 	c.Assert(err, check.IsNil)
@@ -0,0 +1,64 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/permission"
+)
+
+// title: routers drift
+// path: /routers/drift
+// method: GET
+// produce: application/json
+// responses:
+//   200: OK
+//   401: Unauthorized
+func routersDrift(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	if !permission.Check(t, permission.PermRouterReadDrift) {
+		return permission.ErrUnauthorized
+	}
+	report, err := app.CheckRoutes(false)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(report)
+}
+
+// title: routers drift fix
+// path: /routers/drift/fix
+// method: POST
+// produce: application/json
+// responses:
+//   200: OK
+//   401: Unauthorized
+func routersDriftFix(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	if !permission.Check(t, permission.PermRouterUpdateRoutes) {
+		return permission.ErrUnauthorized
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     event.Target{Type: event.TargetTypeRouter},
+		Kind:       permission.PermRouterUpdateRoutes,
+		Owner:      t,
+		CustomData: event.FormToCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermRouterReadDrift),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	report, err := app.CheckRoutes(true)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,91 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/router/rebuild"
+	"github.com/tsuru/tsuru/router/routertest"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestRoutersDrift(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = routertest.FakeRouter.AddRoute(a.Name, &url.URL{Scheme: "http", Host: "10.1.1.1:1234"})
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("GET", "/routers/drift", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var report app.RoutesReport
+	err = json.Unmarshal(recorder.Body.Bytes(), &report)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Routers, check.DeepEquals, []app.RouterDrift{
+		{Router: "fake", Apps: []rebuild.RoutesDrift{
+			{App: "myapp", StaleRoutes: []string{"http://10.1.1.1:1234"}},
+		}},
+	})
+	c.Assert(report.Enqueued, check.IsNil)
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, "http://10.1.1.1:1234"), check.Equals, true)
+}
+
+func (s *S) TestRoutersDriftUnauthorized(c *check.C) {
+	token := userWithPermission(c)
+	request, err := http.NewRequest("GET", "/routers/drift", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestRoutersDriftFix(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = routertest.FakeRouter.AddRoute(a.Name, &url.URL{Scheme: "http", Host: "10.1.1.1:1234"})
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("POST", "/routers/drift/fix", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var report app.RoutesReport
+	err = json.Unmarshal(recorder.Body.Bytes(), &report)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Enqueued, check.DeepEquals, []string{"myapp"})
+	c.Assert(eventtest.EventDesc{
+		Target: event.Target{Type: event.TargetTypeRouter},
+		Owner:  s.token.GetUserName(),
+		Kind:   "router.update.routes",
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestRoutersDriftFixUnauthorized(c *check.C) {
+	token := userWithPermission(c)
+	request, err := http.NewRequest("POST", "/routers/drift/fix", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
@@ -21,16 +21,19 @@ import (
 	"github.com/tsuru/tsuru/api/shutdown"
 	"github.com/tsuru/tsuru/app"
 	"github.com/tsuru/tsuru/auth"
+	_ "github.com/tsuru/tsuru/auth/ldap"
 	_ "github.com/tsuru/tsuru/auth/native"
 	_ "github.com/tsuru/tsuru/auth/oauth"
 	_ "github.com/tsuru/tsuru/auth/saml"
 	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/event/webhook"
 	"github.com/tsuru/tsuru/hc"
 	"github.com/tsuru/tsuru/healer"
 	"github.com/tsuru/tsuru/log"
 	"github.com/tsuru/tsuru/provision"
 	"github.com/tsuru/tsuru/router"
 	"github.com/tsuru/tsuru/router/rebuild"
+	"github.com/tsuru/tsuru/service"
 	"golang.org/x/net/websocket"
 	"gopkg.in/tylerb/graceful.v1"
 )
@@ -94,6 +97,7 @@ func RunServer(dry bool) http.Handler {
 		m.Add("1.0", "Get", "/", Handler(index))
 	}
 	m.Add("1.0", "Get", "/info", Handler(info))
+	m.Add("1.0", "Get", "/.well-known/acme-challenge/{token}", Handler(acmeChallenge))
 
 	m.Add("1.0", "Get", "/services/instances", AuthorizationRequiredHandler(serviceInstances))
 	m.Add("1.0", "Get", "/services/{service}/instances/{instance}", AuthorizationRequiredHandler(serviceInstance))
@@ -102,6 +106,7 @@ func RunServer(dry bool) http.Handler {
 	m.Add("1.0", "Put", "/services/{service}/instances/{instance}", AuthorizationRequiredHandler(updateServiceInstance))
 	m.Add("1.0", "Put", "/services/{service}/instances/{instance}/{app}", AuthorizationRequiredHandler(bindServiceInstance))
 	m.Add("1.0", "Delete", "/services/{service}/instances/{instance}/{app}", AuthorizationRequiredHandler(unbindServiceInstance))
+	m.Add("1.0", "Post", "/services/{service}/instances/{instance}/{app}/rebind", AuthorizationRequiredHandler(rebindServiceInstance))
 	m.Add("1.0", "Get", "/services/{service}/instances/{instance}/status", AuthorizationRequiredHandler(serviceInstanceStatus))
 	m.Add("1.0", "Put", "/services/{service}/instances/permission/{instance}/{team}", AuthorizationRequiredHandler(serviceInstanceGrantTeam))
 	m.Add("1.0", "Delete", "/services/{service}/instances/permission/{instance}/{team}", AuthorizationRequiredHandler(serviceInstanceRevokeTeam))
@@ -124,6 +129,11 @@ func RunServer(dry bool) http.Handler {
 	m.Add("1.0", "Get", "/apps/{app}", AuthorizationRequiredHandler(appInfo))
 	m.Add("1.0", "Post", "/apps/{app}/cname", AuthorizationRequiredHandler(setCName))
 	m.Add("1.0", "Delete", "/apps/{app}/cname", AuthorizationRequiredHandler(unsetCName))
+	m.Add("1.0", "Get", "/apps/{app}/routes/weights", AuthorizationRequiredHandler(routesWeightsList))
+	m.Add("1.0", "Post", "/apps/{app}/routes/weights", AuthorizationRequiredHandler(setRoutesWeights))
+	m.Add("1.0", "Get", "/apps/{app}/routers", AuthorizationRequiredHandler(appRoutersList))
+	m.Add("1.0", "Post", "/apps/{app}/routers", AuthorizationRequiredHandler(addAppRouter))
+	m.Add("1.0", "Delete", "/apps/{app}/routers/{router}", AuthorizationRequiredHandler(removeAppRouter))
 	runHandler := AuthorizationRequiredHandler(runCommand)
 	m.Add("1.0", "Post", "/apps/{app}/run", runHandler)
 	m.Add("1.0", "Post", "/apps/{app}/restart", AuthorizationRequiredHandler(restart))
@@ -142,6 +152,9 @@ func RunServer(dry bool) http.Handler {
 	m.Add("1.0", "Delete", "/apps/{app}/lock", forceDeleteLockHandler)
 	m.Add("1.0", "Put", "/apps/{app}/units", AuthorizationRequiredHandler(addUnits))
 	m.Add("1.0", "Delete", "/apps/{app}/units", AuthorizationRequiredHandler(removeUnits))
+	m.Add("1.0", "Get", "/apps/{app}/autoscale", AuthorizationRequiredHandler(listAutoScaleRules))
+	m.Add("1.0", "Post", "/apps/{app}/autoscale", AuthorizationRequiredHandler(setAutoScaleRule))
+	m.Add("1.0", "Delete", "/apps/{app}/autoscale/{process}", AuthorizationRequiredHandler(removeAutoScaleRule))
 	registerUnitHandler := AuthorizationRequiredHandler(registerUnit)
 	m.Add("1.0", "Post", "/apps/{app}/units/register", registerUnitHandler)
 	setUnitStatusHandler := AuthorizationRequiredHandler(setUnitStatus)
@@ -165,6 +178,12 @@ func RunServer(dry bool) http.Handler {
 
 	m.Add("1.1", "Get", "/events", AuthorizationRequiredHandler(eventList))
 	m.Add("1.1", "Get", "/events/kinds", AuthorizationRequiredHandler(kindList))
+	m.Add("1.1", "Get", "/events/webhooks", AuthorizationRequiredHandler(webhookList))
+	m.Add("1.1", "Post", "/events/webhooks", AuthorizationRequiredHandler(webhookCreate))
+	m.Add("1.1", "Get", "/events/webhooks/{name}", AuthorizationRequiredHandler(webhookInfo))
+	m.Add("1.1", "Put", "/events/webhooks/{name}", AuthorizationRequiredHandler(webhookUpdate))
+	m.Add("1.1", "Delete", "/events/webhooks/{name}", AuthorizationRequiredHandler(webhookDelete))
+	m.Add("1.1", "Get", "/events/webhooks/{name}/deliveries", AuthorizationRequiredHandler(webhookDeliveries))
 	m.Add("1.1", "Get", "/events/{uuid}", AuthorizationRequiredHandler(eventInfo))
 	m.Add("1.1", "Post", "/events/{uuid}/cancel", AuthorizationRequiredHandler(eventCancel))
 
@@ -196,6 +215,9 @@ func RunServer(dry bool) http.Handler {
 
 	m.Add("1.0", "Post", "/users/{email}/password", Handler(resetPassword))
 	m.Add("1.0", "Post", "/users/{email}/tokens", Handler(login))
+	m.Add("1.0", "Post", "/users/{email}/two-factor", Handler(startTwoFactorEnrollment))
+	m.Add("1.0", "Post", "/users/{email}/two-factor/confirm", Handler(confirmTwoFactorEnrollment))
+	m.Add("1.0", "Post", "/users/{email}/two-factor/disable", Handler(disableTwoFactor))
 	m.Add("1.0", "Get", "/users/{email}/quota", AuthorizationRequiredHandler(getUserQuota))
 	m.Add("1.0", "Put", "/users/{email}/quota", AuthorizationRequiredHandler(changeUserQuota))
 	m.Add("1.0", "Delete", "/users/tokens", AuthorizationRequiredHandler(logout))
@@ -206,12 +228,21 @@ func RunServer(dry bool) http.Handler {
 	m.Add("1.0", "Delete", "/users/keys/{key}", AuthorizationRequiredHandler(removeKeyFromUser))
 	m.Add("1.0", "Get", "/users/api-key", AuthorizationRequiredHandler(showAPIToken))
 	m.Add("1.0", "Post", "/users/api-key", AuthorizationRequiredHandler(regenerateAPIToken))
+	m.Add("1.0", "Get", "/users/access-tokens", AuthorizationRequiredHandler(listAccessTokens))
+	m.Add("1.0", "Post", "/users/access-tokens", AuthorizationRequiredHandler(createAccessToken))
+	m.Add("1.0", "Delete", "/users/access-tokens/{name}", AuthorizationRequiredHandler(revokeAccessToken))
 
 	m.Add("1.0", "Get", "/logs", websocket.Handler(addLogs))
 
 	m.Add("1.0", "Get", "/teams", AuthorizationRequiredHandler(teamList))
 	m.Add("1.0", "Post", "/teams", AuthorizationRequiredHandler(createTeam))
+	m.Add("1.0", "Get", "/teams/{name}", AuthorizationRequiredHandler(teamInfo))
+	m.Add("1.0", "Put", "/teams/{name}", AuthorizationRequiredHandler(updateTeam))
 	m.Add("1.0", "Delete", "/teams/{name}", AuthorizationRequiredHandler(removeTeam))
+	m.Add("1.0", "Get", "/teams/{team}/service-accounts", AuthorizationRequiredHandler(listServiceAccounts))
+	m.Add("1.0", "Post", "/teams/{team}/service-accounts", AuthorizationRequiredHandler(createServiceAccount))
+	m.Add("1.0", "Delete", "/teams/{team}/service-accounts/{name}", AuthorizationRequiredHandler(removeServiceAccount))
+	m.Add("1.0", "Post", "/teams/{team}/service-accounts/{name}/token", AuthorizationRequiredHandler(regenerateServiceAccountToken))
 
 	m.Add("1.0", "Post", "/swap", AuthorizationRequiredHandler(swap))
 
@@ -227,8 +258,11 @@ func RunServer(dry bool) http.Handler {
 
 	m.Add("1.0", "Get", "/plans", AuthorizationRequiredHandler(listPlans))
 	m.Add("1.0", "Post", "/plans", AuthorizationRequiredHandler(addPlan))
+	m.Add("1.0", "Put", "/plans/{planname}", AuthorizationRequiredHandler(updatePlan))
 	m.Add("1.0", "Delete", "/plans/{planname}", AuthorizationRequiredHandler(removePlan))
 	m.Add("1.0", "Get", "/plans/routers", AuthorizationRequiredHandler(listRouters))
+	m.Add("1.0", "Get", "/routers/drift", AuthorizationRequiredHandler(routersDrift))
+	m.Add("1.0", "Post", "/routers/drift/fix", AuthorizationRequiredHandler(routersDriftFix))
 
 	m.Add("1.0", "Get", "/pools", AuthorizationRequiredHandler(poolList))
 	m.Add("1.0", "Post", "/pools", AuthorizationRequiredHandler(addPoolHandler))
@@ -245,6 +279,8 @@ func RunServer(dry bool) http.Handler {
 	m.Add("1.0", "Delete", "/roles/{name}/permissions/{permission}", AuthorizationRequiredHandler(removePermissions))
 	m.Add("1.0", "Post", "/roles/{name}/user", AuthorizationRequiredHandler(assignRole))
 	m.Add("1.0", "Delete", "/roles/{name}/user/{email}", AuthorizationRequiredHandler(dissociateRole))
+	m.Add("1.0", "Post", "/roles/{name}/service-account", AuthorizationRequiredHandler(assignRoleToServiceAccount))
+	m.Add("1.0", "Delete", "/roles/{name}/service-account/{account}", AuthorizationRequiredHandler(dissociateRoleFromServiceAccount))
 	m.Add("1.0", "Get", "/role/default", AuthorizationRequiredHandler(listDefaultRoles))
 	m.Add("1.0", "Post", "/role/default", AuthorizationRequiredHandler(addDefaultRole))
 	m.Add("1.0", "Delete", "/role/default", AuthorizationRequiredHandler(removeDefaultRole))
@@ -428,6 +464,10 @@ func startServer(handler http.Handler) {
 	if err != nil {
 		fatal(err)
 	}
+	err = service.RegisterTask()
+	if err != nil {
+		fatal(err)
+	}
 	scheme, err := getAuthScheme()
 	if err != nil {
 		fmt.Printf("Warning: configuration didn't declare auth:scheme, using default scheme.\n")
@@ -445,6 +485,11 @@ func startServer(handler http.Handler) {
 	if err != nil {
 		fatal(err)
 	}
+	app.InitializeAutoScale()
+	app.InitializeACME()
+	service.InitializeProvisionChecks()
+	app.InitializeRoutesCheck()
+	webhook.Initialize()
 	fmt.Println("Checking components status:")
 	results := hc.Check()
 	for _, result := range results {
@@ -0,0 +1,272 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/permission"
+)
+
+// teamServiceAccount returns the service account with the given name, only if
+// it's owned by the given team.
+func teamServiceAccount(teamName, name string) (*auth.ServiceAccount, error) {
+	sa, err := auth.GetServiceAccount(name)
+	if err == nil && sa.Team != teamName {
+		err = auth.ErrServiceAccountNotFound
+	}
+	if err == auth.ErrServiceAccountNotFound {
+		return nil, &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	return sa, err
+}
+
+// title: list service accounts
+// path: /teams/{team}/service-accounts
+// method: GET
+// produce: application/json
+// responses:
+//   200: OK
+//   204: No content
+//   401: Unauthorized
+func listServiceAccounts(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	teamName := r.URL.Query().Get(":team")
+	allowed := permission.Check(t, permission.PermTeamReadServiceAccounts,
+		permission.Context(permission.CtxTeam, teamName),
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	accounts, err := auth.ListServiceAccounts([]string{teamName})
+	if err != nil {
+		return err
+	}
+	if len(accounts) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(accounts)
+}
+
+// title: create service account
+// path: /teams/{team}/service-accounts
+// method: POST
+// consume: application/x-www-form-urlencoded
+// produce: application/json
+// responses:
+//   201: Service account created
+//   400: Invalid data
+//   401: Unauthorized
+//   404: Team not found
+//   409: Service account already exists
+func createServiceAccount(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	teamName := r.URL.Query().Get(":team")
+	allowed := permission.Check(t, permission.PermTeamUpdateServiceAccountCreate,
+		permission.Context(permission.CtxTeam, teamName),
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     teamTarget(teamName),
+		Kind:       permission.PermTeamUpdateServiceAccountCreate,
+		Owner:      t,
+		CustomData: event.FormToCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermTeamReadEvents, permission.Context(permission.CtxTeam, teamName)),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	sa := auth.ServiceAccount{
+		Name:        r.FormValue("name"),
+		Team:        teamName,
+		Description: r.FormValue("description"),
+		CreatedBy:   t.GetUserName(),
+	}
+	err = auth.CreateServiceAccount(&sa)
+	switch err {
+	case auth.ErrTeamNotFound:
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	case auth.ErrServiceAccountAlreadyExists:
+		return &errors.HTTP{Code: http.StatusConflict, Message: err.Error()}
+	}
+	if err != nil {
+		return handleAuthError(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	return json.NewEncoder(w).Encode(sa)
+}
+
+// title: remove service account
+// path: /teams/{team}/service-accounts/{name}
+// method: DELETE
+// responses:
+//   200: Service account removed
+//   401: Unauthorized
+//   404: Service account not found
+func removeServiceAccount(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	teamName := r.URL.Query().Get(":team")
+	name := r.URL.Query().Get(":name")
+	allowed := permission.Check(t, permission.PermTeamUpdateServiceAccountRemove,
+		permission.Context(permission.CtxTeam, teamName),
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     teamTarget(teamName),
+		Kind:       permission.PermTeamUpdateServiceAccountRemove,
+		Owner:      t,
+		CustomData: event.FormToCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermTeamReadEvents, permission.Context(permission.CtxTeam, teamName)),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	sa, err := teamServiceAccount(teamName, name)
+	if err != nil {
+		return err
+	}
+	err = auth.RemoveServiceAccount(sa.Name)
+	if err == auth.ErrServiceAccountNotFound {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	return err
+}
+
+// title: regenerate service account token
+// path: /teams/{team}/service-accounts/{name}/token
+// method: POST
+// produce: application/json
+// responses:
+//   200: OK
+//   401: Unauthorized
+//   404: Service account not found
+func regenerateServiceAccountToken(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	teamName := r.URL.Query().Get(":team")
+	name := r.URL.Query().Get(":name")
+	allowed := permission.Check(t, permission.PermTeamUpdateServiceAccountToken,
+		permission.Context(permission.CtxTeam, teamName),
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     teamTarget(teamName),
+		Kind:       permission.PermTeamUpdateServiceAccountToken,
+		Owner:      t,
+		CustomData: event.FormToCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermTeamReadEvents, permission.Context(permission.CtxTeam, teamName)),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	sa, err := teamServiceAccount(teamName, name)
+	if err != nil {
+		return err
+	}
+	_, err = sa.RegenerateToken()
+	if err == auth.ErrServiceAccountNotFound {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(sa)
+}
+
+// title: assign role to service account
+// path: /roles/{name}/service-account
+// method: POST
+// consume: application/x-www-form-urlencoded
+// responses:
+//   200: Ok
+//   400: Invalid data
+//   401: Unauthorized
+//   404: Role or service account not found
+func assignRoleToServiceAccount(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	if !permission.Check(t, permission.PermRoleUpdateAssign) {
+		return permission.ErrUnauthorized
+	}
+	roleName := r.URL.Query().Get(":name")
+	evt, err := event.New(&event.Opts{
+		Target:     event.Target{Type: event.TargetTypeRole, Value: roleName},
+		Kind:       permission.PermRoleUpdateAssign,
+		Owner:      t,
+		CustomData: event.FormToCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermRoleReadEvents),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	contextValue := r.FormValue("context")
+	sa, err := auth.GetServiceAccount(r.FormValue("account"))
+	if err == auth.ErrServiceAccountNotFound {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	if err != nil {
+		return err
+	}
+	err = canUseRole(t, roleName, contextValue)
+	if err != nil {
+		return err
+	}
+	return sa.AddRole(roleName, contextValue)
+}
+
+// title: dissociate role from service account
+// path: /roles/{name}/service-account/{account}
+// method: DELETE
+// responses:
+//   200: Ok
+//   400: Invalid data
+//   401: Unauthorized
+//   404: Role or service account not found
+func dissociateRoleFromServiceAccount(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	if !permission.Check(t, permission.PermRoleUpdateDissociate) {
+		return permission.ErrUnauthorized
+	}
+	roleName := r.URL.Query().Get(":name")
+	evt, err := event.New(&event.Opts{
+		Target:     event.Target{Type: event.TargetTypeRole, Value: roleName},
+		Kind:       permission.PermRoleUpdateDissociate,
+		Owner:      t,
+		CustomData: event.FormToCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermRoleReadEvents),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	contextValue := r.URL.Query().Get("context")
+	sa, err := auth.GetServiceAccount(r.URL.Query().Get(":account"))
+	if err == auth.ErrServiceAccountNotFound {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	if err != nil {
+		return err
+	}
+	err = canUseRole(t, roleName, contextValue)
+	if err != nil {
+		return err
+	}
+	return sa.RemoveRole(roleName, contextValue)
+}
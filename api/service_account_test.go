@@ -0,0 +1,293 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/check.v1"
+)
+
+func (s *AuthSuite) TestCreateServiceAccount(c *check.C) {
+	body := strings.NewReader("name=ci&description=deploys")
+	request, err := http.NewRequest("POST", "/teams/tsuruteam/service-accounts", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var sa auth.ServiceAccount
+	err = json.NewDecoder(recorder.Body).Decode(&sa)
+	c.Assert(err, check.IsNil)
+	c.Assert(sa.Name, check.Equals, "ci")
+	c.Assert(sa.Team, check.Equals, "tsuruteam")
+	c.Assert(sa.Description, check.Equals, "deploys")
+	c.Assert(sa.CreatedBy, check.Equals, s.user.Email)
+	c.Assert(sa.Token, check.Not(check.Equals), "")
+	_, err = auth.ServiceAccountAuth("bearer " + sa.Token)
+	c.Assert(err, check.IsNil)
+	c.Assert(eventtest.EventDesc{
+		Target: teamTarget("tsuruteam"),
+		Owner:  s.token.GetUserName(),
+		Kind:   "team.update.service-account.create",
+		StartCustomData: []map[string]interface{}{
+			{"name": "name", "value": "ci"},
+			{"name": "description", "value": "deploys"},
+			{"name": ":team", "value": "tsuruteam"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *AuthSuite) TestCreateServiceAccountTeamNotFound(c *check.C) {
+	body := strings.NewReader("name=ci")
+	request, err := http.NewRequest("POST", "/teams/unknown/service-accounts", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *AuthSuite) TestCreateServiceAccountInvalidName(c *check.C) {
+	body := strings.NewReader("name=ci@tsuru.io")
+	request, err := http.NewRequest("POST", "/teams/tsuruteam/service-accounts", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, auth.ErrInvalidServiceAccountName.Error()+"\n")
+}
+
+func (s *AuthSuite) TestCreateServiceAccountDuplicated(c *check.C) {
+	err := auth.CreateServiceAccount(&auth.ServiceAccount{Name: "ci", Team: s.team.Name})
+	c.Assert(err, check.IsNil)
+	body := strings.NewReader("name=ci")
+	request, err := http.NewRequest("POST", "/teams/tsuruteam/service-accounts", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusConflict)
+}
+
+func (s *AuthSuite) TestCreateServiceAccountWithoutPermission(c *check.C) {
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermTeamUpdateServiceAccountCreate,
+		Context: permission.Context(permission.CtxTeam, "otherteam"),
+	})
+	body := strings.NewReader("name=ci")
+	request, err := http.NewRequest("POST", "/teams/tsuruteam/service-accounts", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *AuthSuite) TestListServiceAccounts(c *check.C) {
+	err := auth.CreateServiceAccount(&auth.ServiceAccount{Name: "ci", Team: s.team.Name})
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("GET", "/teams/tsuruteam/service-accounts", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var accounts []auth.ServiceAccount
+	err = json.NewDecoder(recorder.Body).Decode(&accounts)
+	c.Assert(err, check.IsNil)
+	c.Assert(accounts, check.HasLen, 1)
+	c.Assert(accounts[0].Name, check.Equals, "ci")
+	c.Assert(accounts[0].Token, check.Equals, "")
+}
+
+func (s *AuthSuite) TestListServiceAccountsEmpty(c *check.C) {
+	request, err := http.NewRequest("GET", "/teams/tsuruteam/service-accounts", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNoContent)
+}
+
+func (s *AuthSuite) TestRemoveServiceAccount(c *check.C) {
+	err := auth.CreateServiceAccount(&auth.ServiceAccount{Name: "ci", Team: s.team.Name})
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("DELETE", "/teams/tsuruteam/service-accounts/ci", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	_, err = auth.GetServiceAccount("ci")
+	c.Assert(err, check.Equals, auth.ErrServiceAccountNotFound)
+	c.Assert(eventtest.EventDesc{
+		Target: teamTarget("tsuruteam"),
+		Owner:  s.token.GetUserName(),
+		Kind:   "team.update.service-account.remove",
+		StartCustomData: []map[string]interface{}{
+			{"name": ":team", "value": "tsuruteam"},
+			{"name": ":name", "value": "ci"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *AuthSuite) TestRemoveServiceAccountFromOtherTeam(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Teams().Insert(auth.Team{Name: "otherteam"})
+	c.Assert(err, check.IsNil)
+	err = auth.CreateServiceAccount(&auth.ServiceAccount{Name: "ci", Team: "otherteam"})
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("DELETE", "/teams/tsuruteam/service-accounts/ci", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+	_, err = auth.GetServiceAccount("ci")
+	c.Assert(err, check.IsNil)
+}
+
+func (s *AuthSuite) TestRegenerateServiceAccountToken(c *check.C) {
+	sa := auth.ServiceAccount{Name: "ci", Team: s.team.Name}
+	err := auth.CreateServiceAccount(&sa)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("POST", "/teams/tsuruteam/service-accounts/ci/token", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var result auth.ServiceAccount
+	err = json.NewDecoder(recorder.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Token, check.Not(check.Equals), "")
+	c.Assert(result.Token, check.Not(check.Equals), sa.Token)
+	_, err = auth.ServiceAccountAuth("bearer " + sa.Token)
+	c.Assert(err, check.Equals, auth.ErrInvalidToken)
+	_, err = auth.ServiceAccountAuth("bearer " + result.Token)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *AuthSuite) TestAssignRoleToServiceAccount(c *check.C) {
+	_, err := permission.NewRole("deployer", "team", "")
+	c.Assert(err, check.IsNil)
+	sa := auth.ServiceAccount{Name: "ci", Team: s.team.Name}
+	err = auth.CreateServiceAccount(&sa)
+	c.Assert(err, check.IsNil)
+	body := strings.NewReader("account=ci&context=tsuruteam")
+	request, err := http.NewRequest("POST", "/roles/deployer/service-account", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	dbAccount, err := auth.GetServiceAccount("ci")
+	c.Assert(err, check.IsNil)
+	c.Assert(dbAccount.Roles, check.DeepEquals, []auth.RoleInstance{{Name: "deployer", ContextValue: "tsuruteam"}})
+	c.Assert(eventtest.EventDesc{
+		Target: event.Target{Type: event.TargetTypeRole, Value: "deployer"},
+		Owner:  s.token.GetUserName(),
+		Kind:   "role.update.assign",
+		StartCustomData: []map[string]interface{}{
+			{"name": "account", "value": "ci"},
+			{"name": "context", "value": "tsuruteam"},
+			{"name": ":name", "value": "deployer"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *AuthSuite) TestAssignRoleToServiceAccountNotFound(c *check.C) {
+	_, err := permission.NewRole("deployer", "team", "")
+	c.Assert(err, check.IsNil)
+	body := strings.NewReader("account=ci&context=tsuruteam")
+	request, err := http.NewRequest("POST", "/roles/deployer/service-account", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *AuthSuite) TestDissociateRoleFromServiceAccount(c *check.C) {
+	_, err := permission.NewRole("deployer", "team", "")
+	c.Assert(err, check.IsNil)
+	sa := auth.ServiceAccount{Name: "ci", Team: s.team.Name}
+	err = auth.CreateServiceAccount(&sa)
+	c.Assert(err, check.IsNil)
+	err = sa.AddRole("deployer", "tsuruteam")
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("DELETE", "/roles/deployer/service-account/ci?context=tsuruteam", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	dbAccount, err := auth.GetServiceAccount("ci")
+	c.Assert(err, check.IsNil)
+	c.Assert(dbAccount.Roles, check.HasLen, 0)
+}
+
+func (s *AuthSuite) TestServiceAccountTokenOwnsEvents(c *check.C) {
+	role, err := permission.NewRole("team-admin", "team", "")
+	c.Assert(err, check.IsNil)
+	err = role.AddPermissions("team.update.service-account")
+	c.Assert(err, check.IsNil)
+	sa := auth.ServiceAccount{Name: "ci", Team: s.team.Name}
+	err = auth.CreateServiceAccount(&sa)
+	c.Assert(err, check.IsNil)
+	token := sa.Token
+	err = sa.AddRole("team-admin", "tsuruteam")
+	c.Assert(err, check.IsNil)
+	body := strings.NewReader("name=deployer")
+	request, err := http.NewRequest("POST", "/teams/tsuruteam/service-accounts", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+token)
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	created, err := auth.GetServiceAccount("deployer")
+	c.Assert(err, check.IsNil)
+	c.Assert(created.CreatedBy, check.Equals, "ci")
+	evts, err := event.List(&event.Filter{KindName: "team.update.service-account.create"})
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 1)
+	c.Assert(evts[0].Owner, check.Equals, event.Owner{Type: event.OwnerTypeServiceAccount, Name: "ci"})
+}
@@ -10,13 +10,17 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"reflect"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/ajg/form"
 	"github.com/pkg/errors"
 	"github.com/tsuru/config"
 	"github.com/tsuru/tsuru/api/context"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/app/bind"
 	"github.com/tsuru/tsuru/auth"
 	tsuruErrors "github.com/tsuru/tsuru/errors"
 	"github.com/tsuru/tsuru/event"
@@ -118,10 +122,25 @@ func createServiceInstance(w http.ResponseWriter, r *http.Request, t auth.Token)
 	return err
 }
 
+type inputServiceInstanceUpdate struct {
+	Description string
+	Plan        string
+	Parameters  map[string]string
+}
+
+func serviceInstanceUpdateData(si *service.ServiceInstance) map[string]interface{} {
+	return map[string]interface{}{
+		"description": si.Description,
+		"plan":        si.PlanName,
+		"parameters":  si.Parameters,
+	}
+}
+
 // title: service instance update
 // path: /services/{service}/instances/{instance}
 // method: PUT
 // consume: application/x-www-form-urlencoded
+// produce: application/x-json-stream
 // responses:
 //   200: Service instance updated
 //   400: Invalid data
@@ -130,37 +149,100 @@ func createServiceInstance(w http.ResponseWriter, r *http.Request, t auth.Token)
 func updateServiceInstance(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 	serviceName := r.URL.Query().Get(":service")
 	instanceName := r.URL.Query().Get(":instance")
-	description := r.FormValue("description")
-	if description == "" {
+	r.ParseForm()
+	var input inputServiceInstanceUpdate
+	dec := form.NewDecoder(nil)
+	dec.IgnoreCase(true)
+	dec.IgnoreUnknownKeys(true)
+	err = dec.DecodeValues(&input, r.Form)
+	if err != nil {
+		return &tsuruErrors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	if input.Description == "" && input.Plan == "" && len(input.Parameters) == 0 {
 		return &tsuruErrors.HTTP{
 			Code:    http.StatusBadRequest,
-			Message: "Invalid value for description",
+			Message: "Neither the description, plan nor parameters were set",
 		}
 	}
 	si, err := getServiceInstanceOrError(serviceName, instanceName)
 	if err != nil {
 		return err
 	}
-	allowed := permission.Check(t, permission.PermServiceInstanceUpdateDescription,
-		contextsForServiceInstance(si, serviceName)...,
-	)
-	if !allowed {
-		return permission.ErrUnauthorized
+	var perms []*permission.PermissionScheme
+	if input.Description != "" {
+		perms = append(perms, permission.PermServiceInstanceUpdateDescription)
+	}
+	if input.Plan != "" {
+		perms = append(perms, permission.PermServiceInstanceUpdatePlan)
+	}
+	if len(input.Parameters) > 0 {
+		perms = append(perms, permission.PermServiceInstanceUpdateParameters)
+	}
+	for _, perm := range perms {
+		allowed := permission.Check(t, perm, contextsForServiceInstance(si, serviceName)...)
+		if !allowed {
+			return permission.ErrUnauthorized
+		}
 	}
+	before := serviceInstanceUpdateData(si)
 	evt, err := event.New(&event.Opts{
-		Target:     serviceInstanceTarget(serviceName, instanceName),
-		Kind:       permission.PermServiceInstanceUpdateDescription,
-		Owner:      t,
-		CustomData: event.FormToCustomData(r.Form),
+		Target: serviceInstanceTarget(serviceName, instanceName),
+		Kind:   permission.PermServiceInstanceUpdate,
+		Owner:  t,
+		CustomData: map[string]interface{}{
+			"before": before,
+			"after": map[string]interface{}{
+				"description": input.Description,
+				"plan":        input.Plan,
+				"parameters":  input.Parameters,
+			},
+		},
 		Allowed: event.Allowed(permission.PermServiceInstanceReadEvents,
 			contextsForServiceInstance(si, serviceName)...),
 	})
 	if err != nil {
 		return err
 	}
-	defer func() { evt.Done(err) }()
-	si.Description = description
-	return service.UpdateService(si)
+	defer func() {
+		evt.DoneCustomData(err, map[string]interface{}{
+			"before": before,
+			"after":  serviceInstanceUpdateData(si),
+		})
+	}()
+	w.Header().Set("Content-Type", "application/x-json-stream")
+	keepAliveWriter := tsuruIo.NewKeepAliveWriter(w, 30*time.Second, "")
+	defer keepAliveWriter.Stop()
+	writer := &tsuruIo.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(keepAliveWriter)}
+	requestIDHeader, _ := config.GetString("request-id-header")
+	requestID := context.GetRequestID(r, requestIDHeader)
+	oldPlan, oldParameters := si.PlanName, si.Parameters
+	err = si.Update(service.ServiceInstance{
+		Description: input.Description,
+		PlanName:    input.Plan,
+		Parameters:  input.Parameters,
+	}, requestID)
+	if err == service.ErrInstanceNotFoundInAPI {
+		return &tsuruErrors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	if err != nil {
+		return err
+	}
+	if len(si.Apps) == 0 || (si.PlanName == oldPlan && reflect.DeepEqual(si.Parameters, oldParameters)) {
+		return nil
+	}
+	apps := make([]bind.App, 0, len(si.Apps))
+	for _, appName := range si.Apps {
+		a, err := app.GetByName(appName)
+		if err != nil {
+			return err
+		}
+		apps = append(apps, a)
+	}
+	updated, err := si.RebindApps(apps, writer)
+	for _, appName := range updated {
+		fmt.Fprintf(writer, "Environment variables of the app %q updated.\n", appName)
+	}
+	return err
 }
 
 // title: remove service instance
@@ -369,6 +451,8 @@ type serviceInstanceInfo struct {
 	PlanName        string
 	PlanDescription string
 	CustomInfo      map[string]string
+	State           string
+	StateMessage    string
 }
 
 // title: service instance info
@@ -410,6 +494,8 @@ func serviceInstance(w http.ResponseWriter, r *http.Request, t auth.Token) error
 		PlanName:        plan.Name,
 		PlanDescription: plan.Description,
 		CustomInfo:      info,
+		State:           serviceInstance.GetState(),
+		StateMessage:    serviceInstance.StateMessage,
 	}
 	w.Header().Set("Content-Type", "application/json")
 	return json.NewEncoder(w).Encode(sInfo)
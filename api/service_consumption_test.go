@@ -18,6 +18,7 @@ import (
 	"github.com/tsuru/config"
 	"github.com/tsuru/tsuru/api/context"
 	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/app/bind"
 	"github.com/tsuru/tsuru/auth"
 	"github.com/tsuru/tsuru/db"
 	"github.com/tsuru/tsuru/db/dbtest"
@@ -491,13 +492,121 @@ func (s *ConsumptionSuite) TestUpdateServiceHandlerServiceInstanceWithDescriptio
 	c.Assert(eventtest.EventDesc{
 		Target: serviceInstanceTarget("mysql", "brainSQL"),
 		Owner:  token.GetUserName(),
-		Kind:   "service-instance.update.description",
-		StartCustomData: []map[string]interface{}{
-			{"name": "description", "value": "changed"},
+		Kind:   "service-instance.update",
+		StartCustomData: map[string]interface{}{
+			"before.description": "desc",
+			"after.description":  "changed",
+		},
+		EndCustomData: map[string]interface{}{
+			"before.description": "desc",
+			"after.description":  "changed",
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *ConsumptionSuite) TestUpdateServiceHandlerServiceInstancePlanAndParameters(c *check.C) {
+	var updateForm url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "PUT" && r.URL.Path == "/resources/brainSQL":
+			r.ParseForm()
+			updateForm = r.Form
+		case r.Method == "POST" && r.URL.Path == "/resources/brainSQL/bind-app":
+			w.Write([]byte(`{"DATABASE_HOST":"newhost"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	se := service.Service{
+		Name:     "mysql",
+		Teams:    []string{s.team.Name},
+		Endpoint: map[string]string{"production": ts.URL},
+	}
+	err := se.Create()
+	c.Assert(err, check.IsNil)
+	defer s.conn.Services().Remove(bson.M{"_id": se.Name})
+	p := app.Platform{Name: "zend"}
+	s.conn.Platforms().Insert(p)
+	s.pool = "test1"
+	err = provision.AddPool(provision.AddPoolOptions{Name: "test1", Default: true})
+	c.Assert(err, check.IsNil)
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err = app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.SetEnvs(bind.SetEnvApp{Envs: []bind.EnvVar{
+		{Name: "DATABASE_HOST", Value: "oldhost", InstanceName: "brainSQL"},
+		{Name: "DATABASE_PORT", Value: "3306", InstanceName: "brainSQL"},
+	}}, nil)
+	c.Assert(err, check.IsNil)
+	si := service.ServiceInstance{
+		Name:        "brainSQL",
+		ServiceName: "mysql",
+		PlanName:    "small",
+		Apps:        []string{a.Name},
+		Teams:       []string{s.team.Name},
+		TeamOwner:   s.team.Name,
+	}
+	err = si.Create()
+	c.Assert(err, check.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": si.Name})
+	params := map[string]string{
+		"plan":            "big",
+		"parameters.size": "10",
+	}
+	recorder, request := makeRequestToUpdateInstanceHandler(params, "mysql", "brainSQL", s.token.GetValue(), c)
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Matches, `(?s).*Environment variables of the app \\"myapp\\" updated.*`)
+	c.Assert(updateForm.Get("plan"), check.Equals, "big")
+	c.Assert(updateForm.Get("parameters.size"), check.Equals, "10")
+	instance, err := service.GetServiceInstance("mysql", "brainSQL")
+	c.Assert(err, check.IsNil)
+	c.Assert(instance.PlanName, check.Equals, "big")
+	c.Assert(instance.Parameters, check.DeepEquals, map[string]string{"size": "10"})
+	dbApp, err := app.GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Env["DATABASE_HOST"].Value, check.Equals, "newhost")
+	_, ok := dbApp.Env["DATABASE_PORT"]
+	c.Assert(ok, check.Equals, false)
+	c.Assert(eventtest.EventDesc{
+		Target: serviceInstanceTarget("mysql", "brainSQL"),
+		Owner:  s.token.GetUserName(),
+		Kind:   "service-instance.update",
+		StartCustomData: map[string]interface{}{
+			"before.plan":           "small",
+			"after.plan":            "big",
+			"after.parameters.size": "10",
+		},
+		EndCustomData: map[string]interface{}{
+			"before.plan":           "small",
+			"after.plan":            "big",
+			"after.parameters.size": "10",
 		},
 	}, eventtest.HasEvent)
 }
 
+func (s *ConsumptionSuite) TestUpdateServiceHandlerServiceInstancePlanWithoutPermission(c *check.C) {
+	se := service.Service{Name: "mysql", Teams: []string{s.team.Name}}
+	err := se.Create()
+	c.Assert(err, check.IsNil)
+	defer s.conn.Services().Remove(bson.M{"_id": se.Name})
+	si := service.ServiceInstance{Name: "brainSQL", ServiceName: "mysql", Teams: []string{s.team.Name}}
+	err = si.Create()
+	c.Assert(err, check.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": si.Name})
+	token := customUserWithPermission(c, "myuser", permission.Permission{
+		Scheme:  permission.PermServiceInstanceUpdateDescription,
+		Context: permission.Context(permission.CtxServiceInstance, serviceIntancePermName(se.Name, si.Name)),
+	})
+	params := map[string]string{"description": "changed", "plan": "big"}
+	recorder, request := makeRequestToUpdateInstanceHandler(params, "mysql", "brainSQL", token.GetValue(), c)
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
 func (s *ConsumptionSuite) TestUpdateServiceHandlerServiceInstanceNoDescription(c *check.C) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`{"DATABASE_HOST":"localhost"}`))
@@ -603,14 +712,14 @@ func (s *ConsumptionSuite) TestUpdateServiceHandlerInvalidDescription(c *check.C
 	m := RunServer(true)
 	m.ServeHTTP(recorder, request)
 	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
-	c.Assert(recorder.Body.String(), check.Equals, "Invalid value for description\n")
+	c.Assert(recorder.Body.String(), check.Equals, "Neither the description, plan nor parameters were set\n")
 	params = map[string]string{
 		"desc": "desc",
 	}
 	recorder, request = makeRequestToUpdateInstanceHandler(params, "mysql", "brainSQL", s.token.GetValue(), c)
 	m.ServeHTTP(recorder, request)
 	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
-	c.Assert(recorder.Body.String(), check.Equals, "Invalid value for description\n")
+	c.Assert(recorder.Body.String(), check.Equals, "Neither the description, plan nor parameters were set\n")
 }
 
 func makeRequestToRemoveInstanceHandler(service, instance string, c *check.C) (*httptest.ResponseRecorder, *http.Request) {
@@ -1295,6 +1404,7 @@ func (s *ConsumptionSuite) TestServiceInstanceInfoHandler(c *check.C) {
 		PlanName:        "small",
 		PlanDescription: "not space left for you",
 		Description:     si.Description,
+		State:           "ready",
 	}
 	c.Assert(instances, check.DeepEquals, expected)
 }
@@ -1332,6 +1442,7 @@ func (s *ConsumptionSuite) TestServiceInstanceInfoHandlerNoPlanAndNoCustomInfo(c
 		PlanName:        "",
 		PlanDescription: "",
 		Description:     si.Description,
+		State:           "ready",
 	}
 	c.Assert(instances, check.DeepEquals, expected)
 }
@@ -30,6 +30,9 @@ func serviceValidate(s service.Service) error {
 	if endpoint, ok := s.Endpoint["production"]; !ok || endpoint == "" {
 		return &errors.HTTP{Code: http.StatusBadRequest, Message: "Service production endpoint is required"}
 	}
+	if !s.ValidType() {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: service.ErrInvalidServiceType.Error()}
+	}
 	return nil
 }
 
@@ -88,6 +91,7 @@ func serviceCreate(w http.ResponseWriter, r *http.Request, t auth.Token) (err er
 		Username: r.FormValue("username"),
 		Endpoint: map[string]string{"production": r.FormValue("endpoint")},
 		Password: r.FormValue("password"),
+		Type:     r.FormValue("type"),
 	}
 	team := r.FormValue("team")
 	if team == "" {
@@ -154,6 +158,7 @@ func serviceUpdate(w http.ResponseWriter, r *http.Request, t auth.Token) (err er
 		Endpoint: map[string]string{"production": r.FormValue("endpoint")},
 		Password: r.FormValue("password"),
 		Name:     r.URL.Query().Get(":name"),
+		Type:     r.FormValue("type"),
 	}
 	err = serviceValidate(d)
 	if err != nil {
@@ -184,6 +189,9 @@ func serviceUpdate(w http.ResponseWriter, r *http.Request, t auth.Token) (err er
 	s.Endpoint = d.Endpoint
 	s.Password = d.Password
 	s.Username = d.Username
+	if d.Type != "" {
+		s.Type = d.Type
+	}
 	return s.Update()
 }
 
@@ -154,6 +154,38 @@ func (s *ProvisionSuite) TestServiceCreate(c *check.C) {
 	}, eventtest.HasEvent)
 }
 
+func (s *ProvisionSuite) TestServiceCreateOSB(c *check.C) {
+	v := url.Values{}
+	v.Set("id", "some_service")
+	v.Set("username", "test")
+	v.Set("password", "xxxx")
+	v.Set("team", "tsuruteam")
+	v.Set("endpoint", "broker.com")
+	v.Set("type", "osb")
+	recorder, request := s.makeRequest("POST", "/services", v.Encode(), c)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	s.m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	var rService service.Service
+	err := s.conn.Services().Find(bson.M{"_id": "some_service"}).One(&rService)
+	c.Assert(err, check.IsNil)
+	c.Assert(rService.Type, check.Equals, service.ServiceTypeOSB)
+}
+
+func (s *ProvisionSuite) TestServiceCreateInvalidType(c *check.C) {
+	v := url.Values{}
+	v.Set("id", "some_service")
+	v.Set("password", "xxxx")
+	v.Set("team", "tsuruteam")
+	v.Set("endpoint", "broker.com")
+	v.Set("type", "soap")
+	recorder, request := s.makeRequest("POST", "/services", v.Encode(), c)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	s.m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, "Invalid service type, must be tsuru or osb.\n")
+}
+
 func (s *ProvisionSuite) TestServiceCreateNameExists(c *check.C) {
 	recorder, request := s.makeRequestToCreateHandler(c)
 	s.m.ServeHTTP(recorder, request)
@@ -0,0 +1,136 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/permission"
+)
+
+// twoFactorHeader is set in login responses when the user must provide a
+// two-factor authentication code.
+const twoFactorHeader = "Tsuru-Two-Factor"
+
+// twoFactorRequest returns the scheme and the user of two-factor requests.
+// These requests aren't authenticated with a token, as users mandated to use
+// two-factor authentication must enroll before logging in, they're
+// authenticated by the scheme using the password of the user, which is checked
+// before any event is created for the user.
+func twoFactorRequest(r *http.Request) (auth.TwoFactorScheme, *auth.User, error) {
+	scheme, ok := app.AuthScheme.(auth.TwoFactorScheme)
+	if !ok {
+		return nil, nil, &errors.HTTP{Code: http.StatusBadRequest, Message: nonManagedSchemeMsg}
+	}
+	u, err := auth.GetUserByEmail(r.URL.Query().Get(":email"))
+	if err != nil {
+		return nil, nil, handleAuthError(err)
+	}
+	err = scheme.CheckPassword(u, r.FormValue("password"))
+	if err != nil {
+		return nil, nil, handleAuthError(err)
+	}
+	return scheme, u, nil
+}
+
+func twoFactorEvent(email string) (*event.Event, error) {
+	return event.New(&event.Opts{
+		Target:   userTarget(email),
+		Kind:     permission.PermUserUpdateTwoFactor,
+		RawOwner: event.Owner{Type: event.OwnerTypeUser, Name: email},
+		Allowed:  event.Allowed(permission.PermUserReadEvents, permission.Context(permission.CtxUser, email)),
+	})
+}
+
+// title: start two-factor enrollment
+// path: /users/{email}/two-factor
+// method: POST
+// consume: application/x-www-form-urlencoded
+// produce: application/json
+// responses:
+//   201: Enrollment started
+//   400: Invalid data
+//   401: Unauthorized
+//   404: User not found
+//   409: Two-factor authentication already enabled
+func startTwoFactorEnrollment(w http.ResponseWriter, r *http.Request) (err error) {
+	scheme, u, err := twoFactorRequest(r)
+	if err != nil {
+		return err
+	}
+	evt, err := twoFactorEvent(u.Email)
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	enrollment, err := scheme.StartTwoFactorEnrollment(u, r.FormValue("password"))
+	if err != nil {
+		return handleAuthError(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	return json.NewEncoder(w).Encode(enrollment)
+}
+
+// title: confirm two-factor enrollment
+// path: /users/{email}/two-factor/confirm
+// method: POST
+// consume: application/x-www-form-urlencoded
+// produce: application/json
+// responses:
+//   200: Two-factor authentication enabled
+//   400: Invalid data
+//   401: Unauthorized
+//   404: User not found
+//   409: Two-factor authentication already enabled
+func confirmTwoFactorEnrollment(w http.ResponseWriter, r *http.Request) (err error) {
+	scheme, u, err := twoFactorRequest(r)
+	if err != nil {
+		return err
+	}
+	evt, err := twoFactorEvent(u.Email)
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	codes, err := scheme.ConfirmTwoFactorEnrollment(u, r.FormValue("password"), r.FormValue("otp"))
+	if err != nil {
+		return handleAuthError(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": codes})
+}
+
+// title: disable two-factor authentication
+// path: /users/{email}/two-factor/disable
+// method: POST
+// consume: application/x-www-form-urlencoded
+// responses:
+//   200: Two-factor authentication disabled
+//   400: Invalid data
+//   401: Unauthorized
+//   403: Two-factor authentication is mandatory
+//   404: User not found
+func disableTwoFactor(w http.ResponseWriter, r *http.Request) (err error) {
+	scheme, u, err := twoFactorRequest(r)
+	if err != nil {
+		return err
+	}
+	evt, err := twoFactorEvent(u.Email)
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	err = scheme.DisableTwoFactor(u, r.FormValue("password"), r.FormValue("otp"))
+	if err != nil {
+		return handleAuthError(err)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"gopkg.in/check.v1"
+)
+
+// totpNow returns the code of the given secret for the current time.
+func totpNow(c *check.C, secret string) string {
+	key, err := base32.StdEncoding.DecodeString(secret)
+	c.Assert(err, check.IsNil)
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(time.Now().Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+func (s *AuthSuite) postTwoFactor(path, body string) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest("POST", path, strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func (s *AuthSuite) enrollTwoFactor(c *check.C, email string) []string {
+	recorder := s.postTwoFactor("/users/"+email+"/two-factor", "password=123456")
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	var enrollment auth.TwoFactorEnrollment
+	err := json.NewDecoder(recorder.Body).Decode(&enrollment)
+	c.Assert(err, check.IsNil)
+	recorder = s.postTwoFactor("/users/"+email+"/two-factor/confirm", "password=123456&otp="+totpNow(c, enrollment.Secret))
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var result map[string][]string
+	err = json.NewDecoder(recorder.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	return result["recovery_codes"]
+}
+
+func (s *AuthSuite) TestStartTwoFactorEnrollment(c *check.C) {
+	u := auth.User{Email: "nobody@globo.com", Password: "123456"}
+	_, err := nativeScheme.Create(&u)
+	c.Assert(err, check.IsNil)
+	recorder := s.postTwoFactor("/users/nobody@globo.com/two-factor", "password=123456")
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var enrollment auth.TwoFactorEnrollment
+	err = json.NewDecoder(recorder.Body).Decode(&enrollment)
+	c.Assert(err, check.IsNil)
+	c.Assert(enrollment.Secret, check.Not(check.Equals), "")
+	c.Assert(enrollment.URI, check.Matches, "otpauth://totp/.*secret="+enrollment.Secret+".*")
+	c.Assert(eventtest.EventDesc{
+		Target: userTarget(u.Email),
+		Owner:  u.Email,
+		Kind:   "user.update.two-factor",
+	}, eventtest.HasEvent)
+}
+
+func (s *AuthSuite) TestStartTwoFactorEnrollmentWrongPassword(c *check.C) {
+	u := auth.User{Email: "nobody@globo.com", Password: "123456"}
+	_, err := nativeScheme.Create(&u)
+	c.Assert(err, check.IsNil)
+	recorder := s.postTwoFactor("/users/nobody@globo.com/two-factor", "password=1234567")
+	c.Assert(recorder.Code, check.Equals, http.StatusUnauthorized)
+	recorder = s.postTwoFactor("/users/nobody@globo.com/two-factor/disable", "password=1234567&otp=123456")
+	c.Assert(recorder.Code, check.Equals, http.StatusUnauthorized)
+	evts, err := event.List(&event.Filter{Target: userTarget(u.Email)})
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 0)
+}
+
+func (s *AuthSuite) TestStartTwoFactorEnrollmentUserNotFound(c *check.C) {
+	recorder := s.postTwoFactor("/users/unknown@globo.com/two-factor", "password=123456")
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *AuthSuite) TestConfirmTwoFactorEnrollmentInvalidCode(c *check.C) {
+	u := auth.User{Email: "nobody@globo.com", Password: "123456"}
+	_, err := nativeScheme.Create(&u)
+	c.Assert(err, check.IsNil)
+	recorder := s.postTwoFactor("/users/nobody@globo.com/two-factor", "password=123456")
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	recorder = s.postTwoFactor("/users/nobody@globo.com/two-factor/confirm", "password=123456&otp=abc")
+	c.Assert(recorder.Code, check.Equals, http.StatusUnauthorized)
+}
+
+func (s *AuthSuite) TestLoginWithTwoFactor(c *check.C) {
+	u := auth.User{Email: "nobody@globo.com", Password: "123456"}
+	_, err := nativeScheme.Create(&u)
+	c.Assert(err, check.IsNil)
+	codes := s.enrollTwoFactor(c, u.Email)
+	c.Assert(codes, check.Not(check.HasLen), 0)
+	recorder := s.postTwoFactor("/users/nobody@globo.com/tokens", "password=123456")
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Header().Get("Tsuru-Two-Factor"), check.Equals, "required")
+	c.Assert(recorder.Body.String(), check.Equals, auth.ErrTwoFactorCodeRequired.Error()+"\n")
+	recorder = s.postTwoFactor("/users/nobody@globo.com/tokens", "password=123456&otp="+codes[0])
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var result map[string]string
+	err = json.NewDecoder(recorder.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result["token"], check.Not(check.Equals), "")
+}
+
+func (s *AuthSuite) TestDisableTwoFactor(c *check.C) {
+	u := auth.User{Email: "nobody@globo.com", Password: "123456"}
+	_, err := nativeScheme.Create(&u)
+	c.Assert(err, check.IsNil)
+	codes := s.enrollTwoFactor(c, u.Email)
+	recorder := s.postTwoFactor("/users/nobody@globo.com/two-factor/disable", "password=123456&otp="+codes[1])
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	recorder = s.postTwoFactor("/users/nobody@globo.com/tokens", "password=123456")
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+}
+
+func (s *AuthSuite) TestDisableTwoFactorNotEnabled(c *check.C) {
+	u := auth.User{Email: "nobody@globo.com", Password: "123456"}
+	_, err := nativeScheme.Create(&u)
+	c.Assert(err, check.IsNil)
+	recorder := s.postTwoFactor("/users/nobody@globo.com/two-factor/disable", "password=123456&otp=123456")
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+}
@@ -0,0 +1,267 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/ajg/form"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/event/webhook"
+	"github.com/tsuru/tsuru/permission"
+)
+
+func webhookTarget(name string) event.Target {
+	return event.Target{Type: event.TargetTypeWebhook, Value: name}
+}
+
+func decodeWebhook(r *http.Request) (webhook.Webhook, error) {
+	var w webhook.Webhook
+	err := r.ParseForm()
+	if err != nil {
+		return w, &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	dec := form.NewDecoder(nil)
+	dec.IgnoreCase(true)
+	dec.IgnoreUnknownKeys(true)
+	err = dec.DecodeValues(&w, r.Form)
+	if err != nil {
+		return w, &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	return w, nil
+}
+
+func getWebhook(name string) (*webhook.Webhook, error) {
+	w, err := webhook.Find(name)
+	if err == webhook.ErrWebhookNotFound {
+		return nil, &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	return w, err
+}
+
+func webhookError(err error) error {
+	switch e := err.(type) {
+	case *errors.ValidationError:
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: e.Message}
+	}
+	switch err {
+	case webhook.ErrWebhookNotFound:
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	case webhook.ErrWebhookAlreadyExists:
+		return &errors.HTTP{Code: http.StatusConflict, Message: err.Error()}
+	}
+	return err
+}
+
+// title: webhook list
+// path: /events/webhooks
+// method: GET
+// produce: application/json
+// responses:
+//   200: OK
+//   204: No content
+//   401: Unauthorized
+func webhookList(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	teams := []string{}
+	contexts := permission.ContextsForPermission(t, permission.PermWebhookRead)
+	for _, c := range contexts {
+		if c.CtxType == permission.CtxGlobal {
+			teams = nil
+			break
+		}
+		if c.CtxType != permission.CtxTeam {
+			continue
+		}
+		teams = append(teams, c.Value)
+	}
+	hooks, err := webhook.List(teams)
+	if err != nil {
+		return err
+	}
+	if len(hooks) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(hooks)
+}
+
+// title: webhook info
+// path: /events/webhooks/{name}
+// method: GET
+// produce: application/json
+// responses:
+//   200: OK
+//   401: Unauthorized
+//   404: Webhook not found
+func webhookInfo(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	hook, err := getWebhook(r.URL.Query().Get(":name"))
+	if err != nil {
+		return err
+	}
+	allowed := permission.Check(t, permission.PermWebhookRead, permission.Context(permission.CtxTeam, hook.TeamOwner))
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(hook)
+}
+
+// title: webhook create
+// path: /events/webhooks
+// method: POST
+// consume: application/x-www-form-urlencoded
+// responses:
+//   201: Webhook created
+//   400: Invalid data
+//   401: Unauthorized
+//   409: Webhook already exists
+func webhookCreate(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	hook, err := decodeWebhook(r)
+	if err != nil {
+		return err
+	}
+	teamCtx := permission.Context(permission.CtxTeam, hook.TeamOwner)
+	allowed := permission.Check(t, permission.PermWebhookCreate, teamCtx)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	delete(r.Form, "secret")
+	evt, err := event.New(&event.Opts{
+		Target:     webhookTarget(hook.Name),
+		Kind:       permission.PermWebhookCreate,
+		Owner:      t,
+		CustomData: event.FormToCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermWebhookReadEvents, teamCtx),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	err = webhook.Create(hook)
+	if err != nil {
+		return webhookError(err)
+	}
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+// title: webhook update
+// path: /events/webhooks/{name}
+// method: PUT
+// consume: application/x-www-form-urlencoded
+// responses:
+//   200: Webhook updated
+//   400: Invalid data
+//   401: Unauthorized
+//   404: Webhook not found
+func webhookUpdate(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	hook, err := decodeWebhook(r)
+	if err != nil {
+		return err
+	}
+	hook.Name = r.URL.Query().Get(":name")
+	dbHook, err := getWebhook(hook.Name)
+	if err != nil {
+		return err
+	}
+	teamCtx := permission.Context(permission.CtxTeam, dbHook.TeamOwner)
+	allowed := permission.Check(t, permission.PermWebhookUpdate, teamCtx)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	if hook.TeamOwner == "" {
+		hook.TeamOwner = dbHook.TeamOwner
+	} else if hook.TeamOwner != dbHook.TeamOwner {
+		allowed = permission.Check(t, permission.PermWebhookCreate, permission.Context(permission.CtxTeam, hook.TeamOwner))
+		if !allowed {
+			return permission.ErrUnauthorized
+		}
+	}
+	if hook.Secret == "" {
+		hook.Secret = dbHook.Secret
+	}
+	delete(r.Form, "secret")
+	evt, err := event.New(&event.Opts{
+		Target:     webhookTarget(hook.Name),
+		Kind:       permission.PermWebhookUpdate,
+		Owner:      t,
+		CustomData: event.FormToCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermWebhookReadEvents, teamCtx),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	return webhookError(webhook.Update(hook))
+}
+
+// title: webhook delete
+// path: /events/webhooks/{name}
+// method: DELETE
+// responses:
+//   200: Webhook deleted
+//   401: Unauthorized
+//   404: Webhook not found
+func webhookDelete(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	name := r.URL.Query().Get(":name")
+	hook, err := getWebhook(name)
+	if err != nil {
+		return err
+	}
+	teamCtx := permission.Context(permission.CtxTeam, hook.TeamOwner)
+	allowed := permission.Check(t, permission.PermWebhookDelete, teamCtx)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     webhookTarget(name),
+		Kind:       permission.PermWebhookDelete,
+		Owner:      t,
+		CustomData: event.FormToCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermWebhookReadEvents, teamCtx),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	return webhookError(webhook.Delete(name))
+}
+
+// title: webhook deliveries
+// path: /events/webhooks/{name}/deliveries
+// method: GET
+// produce: application/json
+// responses:
+//   200: OK
+//   204: No content
+//   401: Unauthorized
+//   404: Webhook not found
+func webhookDeliveries(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	hook, err := getWebhook(r.URL.Query().Get(":name"))
+	if err != nil {
+		return err
+	}
+	allowed := permission.Check(t, permission.PermWebhookRead, permission.Context(permission.CtxTeam, hook.TeamOwner))
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	deliveries, err := webhook.ListDeliveries(hook.Name, limit)
+	if err != nil {
+		return err
+	}
+	if len(deliveries) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(deliveries)
+}
@@ -0,0 +1,240 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/event/webhook"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestWebhookList(c *check.C) {
+	err := webhook.Create(webhook.Webhook{Name: "hook1", TeamOwner: s.team.Name, URL: "http://example.com"})
+	c.Assert(err, check.IsNil)
+	err = webhook.Create(webhook.Webhook{Name: "hook2", TeamOwner: "otherteam", URL: "http://example.com"})
+	c.Assert(err, check.IsNil)
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermWebhookRead,
+		Context: permission.Context(permission.CtxTeam, s.team.Name),
+	})
+	request, err := http.NewRequest("GET", "/events/webhooks", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var hooks []webhook.Webhook
+	err = json.NewDecoder(recorder.Body).Decode(&hooks)
+	c.Assert(err, check.IsNil)
+	c.Assert(hooks, check.DeepEquals, []webhook.Webhook{
+		{Name: "hook1", TeamOwner: s.team.Name, URL: "http://example.com", Method: "POST"},
+	})
+}
+
+func (s *S) TestWebhookListEmpty(c *check.C) {
+	request, err := http.NewRequest("GET", "/events/webhooks", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNoContent)
+}
+
+func (s *S) TestWebhookInfo(c *check.C) {
+	err := webhook.Create(webhook.Webhook{Name: "hook1", TeamOwner: s.team.Name, URL: "http://example.com", Secret: "abc"})
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("GET", "/events/webhooks/hook1", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Not(check.Matches), `(?s).*abc.*`)
+	var hook webhook.Webhook
+	err = json.NewDecoder(recorder.Body).Decode(&hook)
+	c.Assert(err, check.IsNil)
+	c.Assert(hook.Name, check.Equals, "hook1")
+}
+
+func (s *S) TestWebhookInfoNotFound(c *check.C) {
+	request, err := http.NewRequest("GET", "/events/webhooks/hook1", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestWebhookCreate(c *check.C) {
+	body := strings.NewReader("name=hook1&teamowner=" + s.team.Name + "&url=http://example.com&eventfilter.kindname=app.deploy&eventfilter.erroronly=true&headers.X-Token.0=abc&secret=s3cr3t")
+	request, err := http.NewRequest("POST", "/events/webhooks", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	hook, err := webhook.Find("hook1")
+	c.Assert(err, check.IsNil)
+	c.Assert(*hook, check.DeepEquals, webhook.Webhook{
+		Name:        "hook1",
+		TeamOwner:   s.team.Name,
+		URL:         "http://example.com",
+		Method:      "POST",
+		EventFilter: webhook.Filter{KindName: "app.deploy", ErrorOnly: true},
+		Headers:     http.Header{"X-Token": []string{"abc"}},
+		Secret:      "s3cr3t",
+	})
+	c.Assert(eventtest.EventDesc{
+		Target: webhookTarget("hook1"),
+		Owner:  s.token.GetUserName(),
+		Kind:   "webhook.create",
+		StartCustomData: []map[string]interface{}{
+			{"name": "name", "value": "hook1"},
+			{"name": "teamowner", "value": s.team.Name},
+			{"name": "url", "value": "http://example.com"},
+			{"name": "eventfilter.kindname", "value": "app.deploy"},
+			{"name": "eventfilter.erroronly", "value": "true"},
+			{"name": "headers.X-Token.0", "value": "abc"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestWebhookCreateInvalid(c *check.C) {
+	body := strings.NewReader("name=hook1&teamowner=" + s.team.Name + "&url=example.com")
+	request, err := http.NewRequest("POST", "/events/webhooks", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, "invalid webhook url \"example.com\"\n")
+}
+
+func (s *S) TestWebhookCreateAlreadyExists(c *check.C) {
+	err := webhook.Create(webhook.Webhook{Name: "hook1", TeamOwner: s.team.Name, URL: "http://example.com"})
+	c.Assert(err, check.IsNil)
+	body := strings.NewReader("name=hook1&teamowner=" + s.team.Name + "&url=http://example.com")
+	request, err := http.NewRequest("POST", "/events/webhooks", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusConflict)
+}
+
+func (s *S) TestWebhookCreateUnauthorized(c *check.C) {
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermWebhookCreate,
+		Context: permission.Context(permission.CtxTeam, "otherteam"),
+	})
+	body := strings.NewReader("name=hook1&teamowner=" + s.team.Name + "&url=http://example.com")
+	request, err := http.NewRequest("POST", "/events/webhooks", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestWebhookUpdate(c *check.C) {
+	err := webhook.Create(webhook.Webhook{Name: "hook1", TeamOwner: s.team.Name, URL: "http://example.com", Secret: "abc"})
+	c.Assert(err, check.IsNil)
+	body := strings.NewReader("url=http://example.com/other&method=PUT")
+	request, err := http.NewRequest("PUT", "/events/webhooks/hook1", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	hook, err := webhook.Find("hook1")
+	c.Assert(err, check.IsNil)
+	c.Assert(*hook, check.DeepEquals, webhook.Webhook{
+		Name:      "hook1",
+		TeamOwner: s.team.Name,
+		URL:       "http://example.com/other",
+		Method:    "PUT",
+		Secret:    "abc",
+	})
+	c.Assert(eventtest.EventDesc{
+		Target: webhookTarget("hook1"),
+		Owner:  s.token.GetUserName(),
+		Kind:   "webhook.update",
+		StartCustomData: []map[string]interface{}{
+			{"name": "url", "value": "http://example.com/other"},
+			{"name": "method", "value": "PUT"},
+			{"name": ":name", "value": "hook1"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestWebhookUpdateNotFound(c *check.C) {
+	body := strings.NewReader("url=http://example.com/other")
+	request, err := http.NewRequest("PUT", "/events/webhooks/hook1", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestWebhookDelete(c *check.C) {
+	err := webhook.Create(webhook.Webhook{Name: "hook1", TeamOwner: s.team.Name, URL: "http://example.com"})
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("DELETE", "/events/webhooks/hook1", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	_, err = webhook.Find("hook1")
+	c.Assert(err, check.Equals, webhook.ErrWebhookNotFound)
+	c.Assert(eventtest.EventDesc{
+		Target: webhookTarget("hook1"),
+		Owner:  s.token.GetUserName(),
+		Kind:   "webhook.delete",
+		StartCustomData: []map[string]interface{}{
+			{"name": ":name", "value": "hook1"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestWebhookDeleteUnauthorized(c *check.C) {
+	err := webhook.Create(webhook.Webhook{Name: "hook1", TeamOwner: s.team.Name, URL: "http://example.com"})
+	c.Assert(err, check.IsNil)
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermWebhookRead,
+		Context: permission.Context(permission.CtxTeam, s.team.Name),
+	})
+	request, err := http.NewRequest("DELETE", "/events/webhooks/hook1", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestWebhookDeliveriesEmpty(c *check.C) {
+	err := webhook.Create(webhook.Webhook{Name: "hook1", TeamOwner: s.team.Name, URL: "http://example.com"})
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("GET", "/events/webhooks/hook1/deliveries", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNoContent)
+}
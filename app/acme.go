@@ -0,0 +1,420 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/acme"
+	"github.com/tsuru/tsuru/api/shutdown"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/router"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const appACMEEventKind = "app-acme-certificate"
+
+var ErrACMEChallengeNotFound = errors.New("acme challenge not found")
+
+// Failed issuances are retried with an exponential backoff, starting at
+// acmeRetryMinDelay and doubling on each failure up to acmeRetryMaxDelay.
+var (
+	acmeRetryMinDelay = 10 * time.Minute
+	acmeRetryMaxDelay = 24 * time.Hour
+)
+
+// ACMECertificate tracks the certificate obtained through ACME for a cname
+// of an app. Entries without a certificate are pending issuance. Failures
+// counts the consecutive failed issuances, no new attempt is made before
+// NextAttempt.
+type ACMECertificate struct {
+	CName       string `bson:"_id"`
+	App         string
+	Certificate string
+	Key         string
+	NotAfter    time.Time
+	Error       string
+	Failures    int
+	NextAttempt time.Time
+}
+
+type acmeChallenge struct {
+	Token   string `bson:"_id"`
+	Domain  string
+	KeyAuth string
+}
+
+type acmeAccount struct {
+	DirectoryURL string `bson:"_id"`
+	Key          string
+	URL          string
+}
+
+func acmeEnabled() bool {
+	directoryURL, _ := config.GetString("acme:directory-url")
+	return directoryURL != ""
+}
+
+// scheduleACMECertificates marks the cnames as pending certificate issuance,
+// the certificates are obtained in background by the ACME worker. Apps whose
+// router can't handle certificates or challenges are ignored, as are wildcard
+// cnames, which can't be validated using HTTP challenges.
+func scheduleACMECertificates(app *App, cnames []string) error {
+	r, err := app.Router()
+	if err != nil {
+		return err
+	}
+	if _, ok := r.(router.TLSRouter); !ok {
+		return nil
+	}
+	if _, ok := r.(router.ACMEChallengeRouter); !ok {
+		return nil
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	for _, cname := range cnames {
+		if strings.HasPrefix(cname, "*.") {
+			continue
+		}
+		_, err = conn.ACMECertificates().UpsertId(cname, bson.M{"$set": bson.M{"app": app.Name}})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func removeACMECertificates(query bson.M) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.ACMECertificates().RemoveAll(query)
+	return err
+}
+
+// ACMECertificates returns the certificates obtained, or pending, through
+// ACME for the cnames of the app.
+func (app *App) ACMECertificates() ([]ACMECertificate, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var certs []ACMECertificate
+	err = conn.ACMECertificates().Find(bson.M{"app": app.Name}).Sort("_id").All(&certs)
+	if err != nil {
+		return nil, err
+	}
+	return certs, nil
+}
+
+// ACMEKeyAuthorization returns the response to the ACME HTTP-01 challenge
+// identified by token.
+func ACMEKeyAuthorization(token string) (string, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	var challenge acmeChallenge
+	err = conn.ACMEChallenges().FindId(token).One(&challenge)
+	if err == mgo.ErrNotFound {
+		return "", ErrACMEChallengeNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return challenge.KeyAuth, nil
+}
+
+// acmeSolver answers HTTP-01 challenges from the tsuru API, routing the
+// challenge path of the cname to it while the challenge is being validated.
+type acmeSolver struct {
+	router  router.ACMEChallengeRouter
+	address *url.URL
+}
+
+func (s *acmeSolver) Present(domain, token, keyAuth string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.ACMEChallenges().UpsertId(token, acmeChallenge{Token: token, Domain: domain, KeyAuth: keyAuth})
+	if err != nil {
+		return err
+	}
+	return s.router.AddACMEChallengeRoute(domain, s.address)
+}
+
+func (s *acmeSolver) CleanUp(domain, token string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.ACMEChallenges().RemoveId(token)
+	if err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	return s.router.RemoveACMEChallengeRoute(domain)
+}
+
+// acmeClient returns a client for the configured ACME directory, registering
+// a new account the first time it's used.
+func acmeClient() (*acme.Client, error) {
+	directoryURL, err := config.GetString("acme:directory-url")
+	if err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var account acmeAccount
+	err = conn.ACMEAccounts().FindId(directoryURL).One(&account)
+	if err == nil {
+		key, keyErr := acme.DecodeKey([]byte(account.Key))
+		if keyErr != nil {
+			return nil, keyErr
+		}
+		client := acme.NewClient(directoryURL, key)
+		client.AccountURL = account.URL
+		return client, nil
+	}
+	if err != mgo.ErrNotFound {
+		return nil, err
+	}
+	key, err := acme.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	client := acme.NewClient(directoryURL, key)
+	email, _ := config.GetString("acme:email")
+	account.URL, err = client.Register(email)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to register acme account")
+	}
+	keyData, err := acme.EncodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	account.DirectoryURL = directoryURL
+	account.Key = string(keyData)
+	err = conn.ACMEAccounts().Insert(account)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func acmeChallengeAddress() (*url.URL, error) {
+	addr, _ := config.GetString("acme:challenge-url")
+	if addr == "" {
+		var err error
+		addr, err = config.GetString("host")
+		if err != nil {
+			return nil, errors.New("acme:challenge-url or host must be set to answer acme challenges")
+		}
+	}
+	return url.Parse(addr)
+}
+
+// issueACMECertificate obtains a certificate for the cname of the entry and
+// adds it to the router of the app. Every issuance is recorded in an event
+// targeting the app.
+func issueACMECertificate(client *acme.Client, entry *ACMECertificate) (err error) {
+	a, err := GetByName(entry.App)
+	if err != nil {
+		return err
+	}
+	evt, err := event.NewInternal(&event.Opts{
+		Target:       event.Target{Type: event.TargetTypeApp, Value: a.Name},
+		InternalKind: appACMEEventKind,
+		CustomData:   bson.M{"cname": entry.CName, "renewal": entry.Certificate != ""},
+		DisableLock:  true,
+		Allowed: event.Allowed(permission.PermAppReadEvents, append(permission.Contexts(permission.CtxTeam, a.Teams),
+			permission.Context(permission.CtxApp, a.Name),
+			permission.Context(permission.CtxPool, a.Pool),
+		)...),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		evt.Done(err)
+		update := bson.M{"$set": bson.M{"error": "", "failures": 0}, "$unset": bson.M{"nextattempt": ""}}
+		if err != nil {
+			failures := entry.Failures + 1
+			update = bson.M{"$set": bson.M{
+				"error":       err.Error(),
+				"failures":    failures,
+				"nextattempt": time.Now().Add(acmeRetryDelay(failures)),
+			}}
+		}
+		conn, connErr := db.Conn()
+		if connErr != nil {
+			return
+		}
+		defer conn.Close()
+		conn.ACMECertificates().UpdateId(entry.CName, update)
+	}()
+	r, err := a.Router()
+	if err != nil {
+		return err
+	}
+	tlsRouter, ok := r.(router.TLSRouter)
+	if !ok {
+		return errors.New("router does not support tls")
+	}
+	challengeRouter, ok := r.(router.ACMEChallengeRouter)
+	if !ok {
+		return errors.New("router does not support acme challenges")
+	}
+	address, err := acmeChallengeAddress()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(evt, "Obtaining certificate for %q\n", entry.CName)
+	cert, err := client.ObtainCertificate(entry.CName, &acmeSolver{router: challengeRouter, address: address})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(evt, "Adding certificate valid until %s to the router\n", cert.NotAfter.Format(time.RFC3339))
+	err = tlsRouter.AddCertificate(entry.CName, string(cert.Certificate), string(cert.Key))
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.ACMECertificates().UpdateId(entry.CName, bson.M{"$set": bson.M{
+		"certificate": string(cert.Certificate),
+		"key":         string(cert.Key),
+		"notafter":    cert.NotAfter,
+	}})
+}
+
+func acmeRetryDelay(failures int) time.Duration {
+	delay := acmeRetryMinDelay
+	for i := 1; i < failures && delay < acmeRetryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > acmeRetryMaxDelay {
+		delay = acmeRetryMaxDelay
+	}
+	return delay
+}
+
+type acmeManager struct {
+	runInterval time.Duration
+	renewBefore time.Duration
+	done        chan bool
+}
+
+// InitializeACME starts the background worker responsible for obtaining and
+// renewing certificates for app cnames, if an ACME directory is configured.
+func InitializeACME() {
+	if !acmeEnabled() {
+		return
+	}
+	runInterval, _ := config.GetInt("acme:run-interval")
+	if runInterval <= 0 {
+		runInterval = 300
+	}
+	renewBefore, _ := config.GetInt("acme:renew-before")
+	if renewBefore <= 0 {
+		renewBefore = 30
+	}
+	manager := &acmeManager{
+		runInterval: time.Duration(runInterval) * time.Second,
+		renewBefore: time.Duration(renewBefore) * 24 * time.Hour,
+		done:        make(chan bool),
+	}
+	shutdown.Register(manager)
+	go manager.Run()
+}
+
+func (m *acmeManager) Run() {
+	for {
+		err := m.runOnce()
+		if err != nil {
+			log.Errorf("[acme] %s", err)
+		}
+		select {
+		case <-m.done:
+			return
+		case <-time.After(m.runInterval):
+		}
+	}
+}
+
+func (m *acmeManager) Shutdown() {
+	m.done <- true
+}
+
+func (m *acmeManager) String() string {
+	return "acme certificates manager"
+}
+
+func (m *acmeManager) runOnce() (retErr error) {
+	defer func() {
+		if r := recover(); r != nil {
+			retErr = errors.Errorf("recovered panic, we can never stop! panic: %v", r)
+		}
+	}()
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	var entries []ACMECertificate
+	now := time.Now()
+	query := bson.M{"$and": []bson.M{
+		{"$or": []bson.M{
+			{"notafter": bson.M{"$exists": false}},
+			{"notafter": bson.M{"$lt": now.Add(m.renewBefore)}},
+		}},
+		{"$or": []bson.M{
+			{"nextattempt": bson.M{"$exists": false}},
+			{"nextattempt": bson.M{"$lte": now}},
+		}},
+	}}
+	err = conn.ACMECertificates().Find(query).Sort("_id").All(&entries)
+	conn.Close()
+	if err != nil {
+		return errors.Wrap(err, "error listing certificates")
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	client, err := acmeClient()
+	if err != nil {
+		return err
+	}
+	for i := range entries {
+		err = issueACMECertificate(client, &entries[i])
+		if err != nil {
+			log.Errorf("[acme] error obtaining certificate for %q of app %q: %s", entries[i].CName, entries[i].App, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/acme"
+	"github.com/tsuru/tsuru/acme/acmetest"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/router/routertest"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// acmeChallengeHandler serves challenges the way the tsuru API does, only
+// answering requests for cnames routed to it by the router.
+func acmeChallengeHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := routertest.TLSRouter.ACMEChallenges[r.Host]; !ok {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	keyAuth, err := ACMEKeyAuthorization(strings.TrimPrefix(r.URL.Path, acme.ChallengePath))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Write([]byte(keyAuth))
+}
+
+func (s *S) setUpACME(c *check.C) (*acmetest.Server, func()) {
+	acmeServer, err := acmetest.NewServer()
+	c.Assert(err, check.IsNil)
+	challengeServer := httptest.NewServer(http.HandlerFunc(acmeChallengeHandler))
+	acmeServer.ValidationURL = challengeServer.URL
+	config.Set("acme:directory-url", acmeServer.URL)
+	config.Set("acme:challenge-url", "http://tsuru.example.com:8080")
+	err = s.conn.Plans().Insert(Plan{Name: "tls", Router: "fake-tls"})
+	c.Assert(err, check.IsNil)
+	return acmeServer, func() {
+		config.Unset("acme:directory-url")
+		config.Unset("acme:challenge-url")
+		acmeServer.Close()
+		challengeServer.Close()
+	}
+}
+
+func (s *S) TestAddCNameSchedulesACMECertificates(c *check.C) {
+	_, cleanup := s.setUpACME(c)
+	defer cleanup()
+	a := App{Name: "myapp", TeamOwner: s.team.Name, Plan: Plan{Name: "tls", Router: "fake-tls"}}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.AddCName("myapp.io", "*.myapp.io")
+	c.Assert(err, check.IsNil)
+	certs, err := a.ACMECertificates()
+	c.Assert(err, check.IsNil)
+	c.Assert(certs, check.DeepEquals, []ACMECertificate{{CName: "myapp.io", App: "myapp"}})
+	err = a.RemoveCName("myapp.io")
+	c.Assert(err, check.IsNil)
+	certs, err = a.ACMECertificates()
+	c.Assert(err, check.IsNil)
+	c.Assert(certs, check.HasLen, 0)
+}
+
+func (s *S) TestAddCNameACMEDisabled(c *check.C) {
+	err := s.conn.Plans().Insert(Plan{Name: "tls", Router: "fake-tls"})
+	c.Assert(err, check.IsNil)
+	a := App{Name: "myapp", TeamOwner: s.team.Name, Plan: Plan{Name: "tls", Router: "fake-tls"}}
+	err = CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.AddCName("myapp.io")
+	c.Assert(err, check.IsNil)
+	certs, err := a.ACMECertificates()
+	c.Assert(err, check.IsNil)
+	c.Assert(certs, check.HasLen, 0)
+}
+
+func (s *S) TestAddCNameACMERouterWithoutTLS(c *check.C) {
+	_, cleanup := s.setUpACME(c)
+	defer cleanup()
+	a := App{Name: "myapp", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.AddCName("myapp.io")
+	c.Assert(err, check.IsNil)
+	certs, err := a.ACMECertificates()
+	c.Assert(err, check.IsNil)
+	c.Assert(certs, check.HasLen, 0)
+}
+
+func (s *S) TestACMEManagerIssuesCertificates(c *check.C) {
+	acmeServer, cleanup := s.setUpACME(c)
+	defer cleanup()
+	a := App{Name: "myapp", TeamOwner: s.team.Name, Plan: Plan{Name: "tls", Router: "fake-tls"}}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.AddCName("myapp.io")
+	c.Assert(err, check.IsNil)
+	manager := &acmeManager{renewBefore: 30 * 24 * time.Hour}
+	err = manager.runOnce()
+	c.Assert(err, check.IsNil)
+	c.Assert(acmeServer.Issued(), check.DeepEquals, []string{"myapp.io"})
+	certs, err := a.ACMECertificates()
+	c.Assert(err, check.IsNil)
+	c.Assert(certs, check.HasLen, 1)
+	c.Assert(certs[0].Error, check.Equals, "")
+	c.Assert(routertest.TLSRouter.Certs["myapp.io"], check.Equals, certs[0].Certificate)
+	c.Assert(routertest.TLSRouter.Keys["myapp.io"], check.Equals, certs[0].Key)
+	c.Assert(routertest.TLSRouter.ACMEChallenges["myapp.io"], check.IsNil)
+	block, _ := pem.Decode([]byte(certs[0].Certificate))
+	x509Cert, err := x509.ParseCertificate(block.Bytes)
+	c.Assert(err, check.IsNil)
+	c.Assert(x509Cert.DNSNames, check.DeepEquals, []string{"myapp.io"})
+	c.Assert(certs[0].NotAfter.Unix(), check.Equals, x509Cert.NotAfter.Unix())
+	evts, err := event.List(&event.Filter{KindName: appACMEEventKind})
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 1)
+	c.Assert(evts[0].Target, check.DeepEquals, event.Target{Type: event.TargetTypeApp, Value: a.Name})
+	c.Assert(evts[0].Error, check.Equals, "")
+	err = manager.runOnce()
+	c.Assert(err, check.IsNil)
+	c.Assert(acmeServer.Issued(), check.HasLen, 1)
+}
+
+func (s *S) TestACMEManagerRenewsExpiringCertificates(c *check.C) {
+	acmeServer, cleanup := s.setUpACME(c)
+	defer cleanup()
+	acmeServer.Validity = 10 * 24 * time.Hour
+	a := App{Name: "myapp", TeamOwner: s.team.Name, Plan: Plan{Name: "tls", Router: "fake-tls"}}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.AddCName("myapp.io")
+	c.Assert(err, check.IsNil)
+	manager := &acmeManager{renewBefore: 30 * 24 * time.Hour}
+	err = manager.runOnce()
+	c.Assert(err, check.IsNil)
+	err = manager.runOnce()
+	c.Assert(err, check.IsNil)
+	c.Assert(acmeServer.Issued(), check.DeepEquals, []string{"myapp.io", "myapp.io"})
+	evts, err := event.List(&event.Filter{KindName: appACMEEventKind})
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 2)
+	var accounts int
+	accounts, err = s.conn.ACMEAccounts().Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(accounts, check.Equals, 1)
+}
+
+func (s *S) TestACMEManagerChallengeFailure(c *check.C) {
+	acmeServer, cleanup := s.setUpACME(c)
+	defer cleanup()
+	validationURL := acmeServer.ValidationURL
+	acmeServer.ValidationURL = "http://127.0.0.1:1"
+	a := App{Name: "myapp", TeamOwner: s.team.Name, Plan: Plan{Name: "tls", Router: "fake-tls"}}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.AddCName("myapp.io")
+	c.Assert(err, check.IsNil)
+	manager := &acmeManager{renewBefore: 30 * 24 * time.Hour}
+	err = manager.runOnce()
+	c.Assert(err, check.IsNil)
+	c.Assert(acmeServer.Issued(), check.HasLen, 0)
+	certs, err := a.ACMECertificates()
+	c.Assert(err, check.IsNil)
+	c.Assert(certs, check.HasLen, 1)
+	c.Assert(certs[0].Certificate, check.Equals, "")
+	c.Assert(certs[0].Error, check.Matches, "acme: .*unauthorized.*")
+	c.Assert(routertest.TLSRouter.ACMEChallenges["myapp.io"], check.IsNil)
+	evts, err := event.List(&event.Filter{KindName: appACMEEventKind})
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 1)
+	c.Assert(evts[0].Error, check.Matches, "acme: .*unauthorized.*")
+	c.Assert(certs[0].Failures, check.Equals, 1)
+	c.Assert(certs[0].NextAttempt.After(time.Now().Add(acmeRetryMinDelay-time.Minute)), check.Equals, true)
+	err = manager.runOnce()
+	c.Assert(err, check.IsNil)
+	evts, err = event.List(&event.Filter{KindName: appACMEEventKind})
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 1)
+	err = s.conn.ACMECertificates().UpdateId("myapp.io", bson.M{"$set": bson.M{"nextattempt": time.Now().Add(-time.Second)}})
+	c.Assert(err, check.IsNil)
+	err = manager.runOnce()
+	c.Assert(err, check.IsNil)
+	evts, err = event.List(&event.Filter{KindName: appACMEEventKind})
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 2)
+	certs, err = a.ACMECertificates()
+	c.Assert(err, check.IsNil)
+	c.Assert(certs[0].Failures, check.Equals, 2)
+	c.Assert(certs[0].NextAttempt.After(time.Now().Add(2*acmeRetryMinDelay-time.Minute)), check.Equals, true)
+	acmeServer.ValidationURL = validationURL
+	err = s.conn.ACMECertificates().UpdateId("myapp.io", bson.M{"$set": bson.M{"nextattempt": time.Now().Add(-time.Second)}})
+	c.Assert(err, check.IsNil)
+	err = manager.runOnce()
+	c.Assert(err, check.IsNil)
+	c.Assert(acmeServer.Issued(), check.DeepEquals, []string{"myapp.io"})
+	certs, err = a.ACMECertificates()
+	c.Assert(err, check.IsNil)
+	c.Assert(certs[0].Error, check.Equals, "")
+	c.Assert(certs[0].Failures, check.Equals, 0)
+	c.Assert(certs[0].NextAttempt.IsZero(), check.Equals, true)
+}
+
+func (s *S) TestACMERetryDelay(c *check.C) {
+	c.Assert(acmeRetryDelay(1), check.Equals, acmeRetryMinDelay)
+	c.Assert(acmeRetryDelay(2), check.Equals, 2*acmeRetryMinDelay)
+	c.Assert(acmeRetryDelay(4), check.Equals, 8*acmeRetryMinDelay)
+	c.Assert(acmeRetryDelay(100), check.Equals, acmeRetryMaxDelay)
+}
+
+func (s *S) TestACMEKeyAuthorizationNotFound(c *check.C) {
+	_, err := ACMEKeyAuthorization("unknown")
+	c.Assert(err, check.Equals, ErrACMEChallengeNotFound)
+}
@@ -32,7 +32,8 @@ var (
 
 // reserveUserApp reserves the app for the user, only if the user has a quota
 // of apps. If the user does not have a quota, meaning that it's unlimited,
-// reserveUserApp.Forward just return nil.
+// reserveUserApp.Forward just return nil. Service accounts don't count against
+// any quota.
 var reserveUserApp = action.Action{
 	Name: "reserve-user-app",
 	Forward: func(ctx action.FWContext) (action.Result, error) {
@@ -52,6 +53,9 @@ var reserveUserApp = action.Action{
 		default:
 			return nil, errors.New("Third parameter must be auth.User or *auth.User.")
 		}
+		if user.IsServiceAccount() {
+			return map[string]string{"app": app.Name}, nil
+		}
 		usr, err := auth.GetUserByEmail(user.Email)
 		if err != nil {
 			return nil, err
@@ -63,6 +67,9 @@ var reserveUserApp = action.Action{
 	},
 	Backward: func(ctx action.BWContext) {
 		m := ctx.FWResult.(map[string]string)
+		if m["user"] == "" {
+			return
+		}
 		if user, err := auth.GetUserByEmail(m["user"]); err == nil {
 			auth.ReleaseApp(user)
 		}
@@ -219,30 +226,47 @@ var addRouterBackend = action.Action{
 		default:
 			return nil, errors.New("First parameter must be *App.")
 		}
-		r, err := app.Router()
+		routers, err := app.GetRouters()
 		if err != nil {
 			return nil, err
 		}
-		if optsRouter, ok := r.(router.OptsRouter); ok {
-			err = optsRouter.AddBackendOpts(app.GetName(), app.GetRouterOpts())
-		} else {
-			err = r.AddBackend(app.GetName())
+		for i, appRouter := range routers {
+			var r router.Router
+			r, err = router.Get(appRouter.Name)
+			if err == nil {
+				err = addBackend(r, app.GetName(), appRouter.Opts)
+			}
+			if err != nil {
+				removeRouterBackends(app, routers[:i])
+				return nil, err
+			}
 		}
-		return app, err
+		return app, nil
 	},
 	Backward: func(ctx action.BWContext) {
 		app := ctx.FWResult.(*App)
-		r, err := app.Router()
+		routers, err := app.GetRouters()
 		if err != nil {
-			log.Errorf("[add-router-backend rollback] unable to get app router: %s", err)
+			log.Errorf("[add-router-backend rollback] unable to get app routers: %s", err)
 			return
 		}
+		removeRouterBackends(app, routers)
+	},
+	MinParams: 1,
+}
+
+func removeRouterBackends(app *App, routers []router.AppRouter) {
+	for _, appRouter := range routers {
+		r, err := router.Get(appRouter.Name)
+		if err != nil {
+			log.Errorf("[add-router-backend rollback] unable to get app router: %s", err)
+			continue
+		}
 		err = r.RemoveBackend(app.GetName())
 		if err != nil {
 			log.Errorf("[add-router-backend rollback] unable to remove router backend: %s", err)
 		}
-	},
-	MinParams: 1,
+	}
 }
 
 var provisionApp = action.Action{
@@ -418,7 +442,7 @@ var moveRouterUnits = action.Action{
 			return nil, err
 		}
 		result := changePlanPipelineResult{oldPlan: oldPlan, app: app, oldIp: app.Ip}
-		if newRouter != oldRouter {
+		if len(app.Routers) == 0 && newRouter != oldRouter {
 			_, err = rebuild.RebuildRoutes(app)
 			if err != nil {
 				return nil, err
@@ -391,6 +391,21 @@ func (s *S) TestReserveUserAppForwardAppNotPointer(c *check.C) {
 	c.Assert(err, check.IsNil)
 }
 
+func (s *S) TestReserveUserAppForwardServiceAccount(c *check.C) {
+	sa := auth.ServiceAccount{Name: "ci", Team: s.team.Name}
+	user, err := sa.User()
+	c.Assert(err, check.IsNil)
+	app := App{
+		Name:     "clap",
+		Platform: "django",
+	}
+	expected := map[string]string{"app": app.Name}
+	previous, err := reserveUserApp.Forward(action.FWContext{Params: []interface{}{&app, user}})
+	c.Assert(err, check.IsNil)
+	c.Assert(previous, check.DeepEquals, expected)
+	reserveUserApp.Backward(action.BWContext{Params: []interface{}{&app, user}, FWResult: previous})
+}
+
 func (s *S) TestReserveUserAppForwardInvalidApp(c *check.C) {
 	user := auth.User{Email: "clap@yes.com"}
 	previous, err := reserveUserApp.Forward(action.FWContext{Params: []interface{}{"something", user}})
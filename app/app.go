@@ -13,6 +13,7 @@ import (
 	"io/ioutil"
 	"net/url"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 
@@ -41,10 +42,12 @@ var AuthScheme auth.Scheme
 var (
 	nameRegexp = regexp.MustCompile(`^[a-z][a-z0-9-]{0,62}$`)
 
-	ErrAlreadyHaveAccess = errors.New("team already have access to this app")
-	ErrNoAccess          = errors.New("team does not have access to this app")
-	ErrCannotOrphanApp   = errors.New("cannot revoke access from this team, as it's the unique team with access to the app")
-	ErrDisabledPlatform  = errors.New("Disabled Platform, only admin users can create applications with the platform")
+	ErrAlreadyHaveAccess  = errors.New("team already have access to this app")
+	ErrNoAccess           = errors.New("team does not have access to this app")
+	ErrCannotOrphanApp    = errors.New("cannot revoke access from this team, as it's the unique team with access to the app")
+	ErrDisabledPlatform   = errors.New("Disabled Platform, only admin users can create applications with the platform")
+	ErrRouterAlreadyInApp = errors.New("app already uses this router")
+	ErrRouterNotInApp     = errors.New("app does not use this router")
 )
 
 const (
@@ -125,6 +128,7 @@ type App struct {
 	Pool           string
 	Description    string
 	RouterOpts     map[string]string
+	Routers        []router.AppRouter
 
 	quota.Quota
 	provisioner provision.Provisioner
@@ -157,6 +161,9 @@ func (app *App) Units() ([]provision.Unit, error) {
 }
 
 func (app *App) GetRouterOpts() map[string]string {
+	if len(app.Routers) > 0 {
+		return app.Routers[0].Opts
+	}
 	return app.RouterOpts
 }
 
@@ -174,6 +181,12 @@ func (app *App) MarshalJSON() ([]byte, error) {
 	result["units"] = units
 	result["repository"] = repo.ReadWriteURL
 	result["ip"] = app.Ip
+	routers, err := app.GetRoutersWithAddr()
+	if err != nil {
+		log.Errorf("unable to get the addresses of app %q in its routers: %s", app.Name, err)
+		routers, _ = app.GetRouters()
+	}
+	result["routers"] = routers
 	result["cname"] = app.CName
 	result["owner"] = app.Owner
 	result["pool"] = app.Pool
@@ -276,7 +289,7 @@ func CreateApp(app *App, user *auth.User) error {
 	if app.Plan.Name == "" {
 		plan, err = DefaultPlan()
 	} else {
-		plan, err = findPlanByName(app.Plan.Name)
+		plan, err = GetPlanByName(app.Plan.Name)
 	}
 	if err != nil {
 		return err
@@ -296,6 +309,10 @@ func CreateApp(app *App, user *auth.User) error {
 	if err != nil {
 		return err
 	}
+	err = app.validateRouters()
+	if err != nil {
+		return err
+	}
 	actions := []*action.Action{
 		&reserveUserApp,
 		&insertApp,
@@ -335,19 +352,7 @@ func (app *App) Update(updateData App, w io.Writer) error {
 	}
 	defer conn.Close()
 	if planName != "" {
-		plan, err := findPlanByName(planName)
-		if err != nil {
-			return err
-		}
-		var oldPlan Plan
-		oldPlan, app.Plan = app.Plan, *plan
-		actions := []*action.Action{
-			&moveRouterUnits,
-			&saveApp,
-			&restartApp,
-			&removeOldBackend,
-		}
-		err = action.NewPipeline(actions...).Execute(app, &oldPlan, w)
+		err = app.ChangePlan(planName, w)
 		if err != nil {
 			return err
 		}
@@ -367,6 +372,35 @@ func (app *App) Update(updateData App, w io.Writer) error {
 	return conn.Apps().Update(bson.M{"name": app.Name}, app)
 }
 
+// ChangePlan changes the plan of the app and restarts its units, so that the
+// provisioner applies the resource limits of the new plan. The pool and quota
+// of the app are checked before any change is made.
+func (app *App) ChangePlan(planName string, w io.Writer) error {
+	plan, err := GetPlanByName(planName)
+	if err != nil {
+		return err
+	}
+	if app.Pool != "" {
+		_, err = app.getPoolForApp(app.Pool)
+		if err != nil {
+			return err
+		}
+	}
+	err = app.checkUnitsQuota()
+	if err != nil {
+		return err
+	}
+	var oldPlan Plan
+	oldPlan, app.Plan = app.Plan, *plan
+	actions := []*action.Action{
+		&moveRouterUnits,
+		&saveApp,
+		&restartApp,
+		&removeOldBackend,
+	}
+	return action.NewPipeline(actions...).Execute(app, &oldPlan, w)
+}
+
 // unbind takes all service instances that are bound to the app, and unbind
 // them. This method is used by Destroy (before destroying the app, it unbinds
 // all service instances). Refer to Destroy docs for more details.
@@ -430,13 +464,19 @@ func Delete(app *App, w io.Writer) error {
 	if err != nil {
 		logErr("Unable to destroy app in provisioner", err)
 	}
-	r, err := app.Router()
-	if err == nil {
-		err = r.RemoveBackend(app.Name)
-	}
+	routers, err := app.GetRouters()
 	if err != nil {
 		logErr("Failed to remove router backend", err)
 	}
+	for _, appRouter := range routers {
+		r, err := router.Get(appRouter.Name)
+		if err == nil {
+			err = r.RemoveBackend(app.Name)
+		}
+		if err != nil {
+			logErr(fmt.Sprintf("Failed to remove router backend from %q", appRouter.Name), err)
+		}
+	}
 	err = router.Remove(app.Name)
 	if err != nil {
 		logErr("Failed to remove router backend from database", err)
@@ -454,12 +494,15 @@ func Delete(app *App, w io.Writer) error {
 	if err != nil {
 		logErr("Unable to remove app token in destroy", err)
 	}
-	owner, err := auth.GetUserByEmail(app.Owner)
-	if err == nil {
-		err = auth.ReleaseApp(owner)
-	}
-	if err != nil {
-		logErr("Unable to release app quota", err)
+	if _, err = auth.GetServiceAccount(app.Owner); err != nil {
+		var owner *auth.User
+		owner, err = auth.GetUserByEmail(app.Owner)
+		if err == nil {
+			err = auth.ReleaseApp(owner)
+		}
+		if err != nil {
+			logErr("Unable to release app quota", err)
+		}
 	}
 	logConn, err := db.LogConn()
 	if err == nil {
@@ -469,6 +512,14 @@ func Delete(app *App, w io.Writer) error {
 	if err != nil {
 		logErr("Unable to remove logs collection", err)
 	}
+	err = removeAutoScaleRules(appName)
+	if err != nil {
+		logErr("Unable to remove autoscale rules", err)
+	}
+	err = removeACMECertificates(bson.M{"app": appName})
+	if err != nil {
+		logErr("Unable to remove acme certificates", err)
+	}
 	conn, err := db.Conn()
 	if err == nil {
 		defer conn.Close()
@@ -876,6 +927,21 @@ func (app *App) validate() error {
 	return nil
 }
 
+func (app *App) validateRouters() error {
+	names := make(map[string]bool, len(app.Routers))
+	for _, appRouter := range app.Routers {
+		if names[appRouter.Name] {
+			return &tsuruErrors.ValidationError{Message: fmt.Sprintf("router %q used more than once", appRouter.Name)}
+		}
+		names[appRouter.Name] = true
+		_, err := router.Get(appRouter.Name)
+		if err != nil {
+			return &tsuruErrors.ValidationError{Message: err.Error()}
+		}
+	}
+	return nil
+}
+
 // InstanceEnv returns a map of environment variables that belongs to the given
 // service instance (identified by the name only).
 func (app *App) InstanceEnv(name string) map[string]bind.EnvVar {
@@ -1246,6 +1312,12 @@ func (app *App) AddCName(cnames ...string) error {
 	}
 	err := action.NewPipeline(actions...).Execute(app, cnames)
 	rebuild.RoutesRebuildOrEnqueue(app.Name)
+	if err == nil && acmeEnabled() {
+		acmeErr := scheduleACMECertificates(app, cnames)
+		if acmeErr != nil {
+			log.Errorf("unable to schedule acme certificates for app %q: %s", app.Name, acmeErr)
+		}
+	}
 	return err
 }
 
@@ -1258,9 +1330,83 @@ func (app *App) RemoveCName(cnames ...string) error {
 	}
 	err := action.NewPipeline(actions...).Execute(app, cnames)
 	rebuild.RoutesRebuildOrEnqueue(app.Name)
+	if err == nil {
+		acmeErr := removeACMECertificates(bson.M{"_id": bson.M{"$in": cnames}})
+		if acmeErr != nil {
+			log.Errorf("unable to remove acme certificates for app %q: %s", app.Name, acmeErr)
+		}
+	}
 	return err
 }
 
+// SetCNameWeights splits the traffic sent to one of the cnames of the app
+// among the app and other apps using the same router, according to weights
+// given in percent. Empty weights send all the traffic back to the app.
+func (app *App) SetCNameWeights(cname string, weights []router.BackendWeight) error {
+	hasCName := false
+	for _, c := range app.CName {
+		if c == cname {
+			hasCName = true
+			break
+		}
+	}
+	if !hasCName {
+		return router.ErrCNameNotFound
+	}
+	if len(weights) > 0 {
+		err := router.ValidateWeights(weights)
+		if err != nil {
+			return &tsuruErrors.ValidationError{Message: err.Error()}
+		}
+	}
+	routerName, err := app.GetRouter()
+	if err != nil {
+		return err
+	}
+	for _, w := range weights {
+		if w.Backend == app.Name {
+			continue
+		}
+		other, err := GetByName(w.Backend)
+		if err == ErrAppNotFound {
+			return &tsuruErrors.ValidationError{Message: fmt.Sprintf("app %q not found", w.Backend)}
+		}
+		if err != nil {
+			return err
+		}
+		otherRouter, err := other.GetRouter()
+		if err != nil {
+			return err
+		}
+		if otherRouter != routerName {
+			msg := fmt.Sprintf("app %q must use router %q to receive traffic from app %q", other.Name, routerName, app.Name)
+			return &tsuruErrors.ValidationError{Message: msg}
+		}
+	}
+	r, err := router.Get(routerName)
+	if err != nil {
+		return err
+	}
+	weightedRouter, ok := r.(router.WeightedRouter)
+	if !ok {
+		return &tsuruErrors.ValidationError{Message: "router does not support weighted routes"}
+	}
+	return weightedRouter.SetCNameWeights(app.Name, cname, weights)
+}
+
+// CNamesWeights returns the weights of the weighted cnames of the app.
+func (app *App) CNamesWeights() (map[string][]router.BackendWeight, error) {
+	r, err := app.Router()
+	if err != nil {
+		return nil, err
+	}
+	weightedRouter, ok := r.(router.WeightedRouter)
+	if !ok {
+		return nil, nil
+	}
+	return weightedRouter.CNamesWeights(app.Name)
+}
+
 func (app *App) parsedTsuruServices() map[string][]bind.ServiceInstance {
 	var tsuruServices map[string][]bind.ServiceInstance
 	if servicesEnv, ok := app.Env[TsuruServicesEnvVar]; ok {
@@ -1274,12 +1420,32 @@ func (app *App) parsedTsuruServices() map[string][]bind.ServiceInstance {
 //func (app *App) AddInstance(serviceName string, instance bind.ServiceInstance, shouldRestart bool, writer io.Writer) error {
 func (app *App) AddInstance(instanceApp bind.InstanceApp, writer io.Writer) error {
 	tsuruServices := app.parsedTsuruServices()
+	// Adding an instance already in the app swaps its environment variables,
+	// the ones not set anymore being removed without restarting the app.
+	var staleEnvs []string
+	for _, si := range tsuruServices[instanceApp.ServiceName] {
+		if si.Name != instanceApp.Instance.Name {
+			continue
+		}
+		for name := range si.Envs {
+			if _, ok := instanceApp.Instance.Envs[name]; !ok {
+				staleEnvs = append(staleEnvs, name)
+			}
+		}
+	}
 	serviceInstances := appendOrUpdateServiceInstance(tsuruServices[instanceApp.ServiceName], instanceApp.Instance)
 	tsuruServices[instanceApp.ServiceName] = serviceInstances
 	servicesJson, err := json.Marshal(tsuruServices)
 	if err != nil {
 		return err
 	}
+	if len(staleEnvs) > 0 {
+		sort.Strings(staleEnvs)
+		err = app.unsetEnvsToApp(bind.UnsetEnvApp{VariableNames: staleEnvs}, writer)
+		if err != nil {
+			return err
+		}
+	}
 	if len(instanceApp.Instance.Envs) == 0 {
 		return nil
 	}
@@ -1485,6 +1651,7 @@ type Filter struct {
 	UserOwner   string
 	Pool        string
 	Pools       []string
+	Plan        string
 	Statuses    []string
 	Locked      bool
 	Extra       map[string][]string
@@ -1529,6 +1696,9 @@ func (f *Filter) Query() bson.M {
 	if f.Pool != "" {
 		query["pool"] = f.Pool
 	}
+	if f.Plan != "" {
+		query["plan._id"] = f.Plan
+	}
 	if f.Locked {
 		query["lock.locked"] = true
 	}
@@ -1584,6 +1754,11 @@ func List(filter *Filter) ([]App, error) {
 
 // Swap calls the Router.Swap and updates the app.CName in the database.
 func Swap(app1, app2 *App, cnameOnly bool) error {
+	for _, a := range []*App{app1, app2} {
+		if len(a.Routers) > 1 {
+			return &tsuruErrors.ValidationError{Message: fmt.Sprintf("app %q has more than one router and cannot be swapped", a.Name)}
+		}
+	}
 	r1, err := app1.Router()
 	if err != nil {
 		return err
@@ -1666,10 +1841,163 @@ func (app *App) RegisterUnit(unitId string, customData map[string]interface{}) e
 	return prov.RegisterUnit(app, unitId, customData)
 }
 
+// GetRouter returns the name of the main router of the app. The address of
+// the app in this router is the app address and cnames, certificates and
+// weights are set in it.
 func (app *App) GetRouter() (string, error) {
+	if len(app.Routers) > 0 {
+		return app.Routers[0].Name, nil
+	}
 	return app.Plan.getRouter()
 }
 
+// GetRouters returns every router used by the app, starting with the main
+// one. Apps that never had routers added use the router of their plan.
+func (app *App) GetRouters() ([]router.AppRouter, error) {
+	if len(app.Routers) > 0 {
+		routers := make([]router.AppRouter, len(app.Routers))
+		copy(routers, app.Routers)
+		return routers, nil
+	}
+	routerName, err := app.Plan.getRouter()
+	if err != nil {
+		return nil, err
+	}
+	return []router.AppRouter{{Name: routerName, Opts: app.RouterOpts}}, nil
+}
+
+// GetRoutersWithAddr returns the routers of the app along with the address
+// of the app in each one of them.
+func (app *App) GetRoutersWithAddr() ([]router.AppRouter, error) {
+	routers, err := app.GetRouters()
+	if err != nil {
+		return nil, err
+	}
+	for i := range routers {
+		r, err := router.Get(routers[i].Name)
+		if err != nil {
+			return nil, err
+		}
+		routers[i].Address, err = r.Addr(app.Name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return routers, nil
+}
+
+// AddRouter adds a new router to the app, creating the app backend in it.
+// The routes of the app units are added to the router in a routes rebuild.
+func (app *App) AddRouter(appRouter router.AppRouter) error {
+	routers, err := app.GetRouters()
+	if err != nil {
+		return err
+	}
+	for _, existing := range routers {
+		if existing.Name == appRouter.Name {
+			return ErrRouterAlreadyInApp
+		}
+	}
+	err = app.validateRoutersChange()
+	if err != nil {
+		return err
+	}
+	r, err := router.Get(appRouter.Name)
+	if err != nil {
+		return &tsuruErrors.ValidationError{Message: err.Error()}
+	}
+	err = addBackend(r, app.Name, appRouter.Opts)
+	if err != nil && err != router.ErrBackendExists {
+		return err
+	}
+	routers = append(routers, appRouter)
+	err = app.saveRouters(routers)
+	if err != nil {
+		if rmErr := r.RemoveBackend(app.Name); rmErr != nil {
+			log.Errorf("[add-router] unable to remove backend of %q from %q: %s", app.Name, appRouter.Name, rmErr)
+		}
+		return err
+	}
+	rebuild.RoutesRebuildOrEnqueue(app.Name)
+	return nil
+}
+
+// RemoveRouter removes one of the routers of the app, along with the app
+// backend in it. The last router of an app cannot be removed. When the main
+// router is removed, the next one becomes the main router of the app.
+func (app *App) RemoveRouter(name string) error {
+	routers, err := app.GetRouters()
+	if err != nil {
+		return err
+	}
+	idx := -1
+	for i, appRouter := range routers {
+		if appRouter.Name == name {
+			idx = i
+			break
+		}
+	}
+	if idx == -1 {
+		return ErrRouterNotInApp
+	}
+	if len(routers) == 1 {
+		return &tsuruErrors.ValidationError{Message: "app must have at least one router"}
+	}
+	err = app.validateRoutersChange()
+	if err != nil {
+		return err
+	}
+	r, err := router.Get(name)
+	if err != nil {
+		return err
+	}
+	err = r.RemoveBackend(app.Name)
+	if err != nil && err != router.ErrBackendNotFound {
+		return err
+	}
+	routers = append(routers[:idx], routers[idx+1:]...)
+	err = app.saveRouters(routers)
+	if err != nil {
+		return err
+	}
+	if idx == 0 {
+		rebuild.RoutesRebuildOrEnqueue(app.Name)
+	}
+	return nil
+}
+
+func (app *App) validateRoutersChange() error {
+	isSwapped, swappedWith, err := router.IsSwapped(app.Name)
+	if err != nil && err != router.ErrBackendNotFound {
+		return err
+	}
+	if isSwapped {
+		return &tsuruErrors.ValidationError{Message: fmt.Sprintf("app is swapped with %q, cannot change its routers", swappedWith)}
+	}
+	return nil
+}
+
+func (app *App) saveRouters(routers []router.AppRouter) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$set": bson.M{"routers": routers}})
+	if err != nil {
+		return err
+	}
+	app.Routers = routers
+	return nil
+}
+
+func addBackend(r router.Router, name string, opts map[string]string) error {
+	if optsRouter, ok := r.(router.OptsRouter); ok {
+		return optsRouter.AddBackendOpts(name, opts)
+	}
+	return r.AddBackend(name)
+}
+
 func (app *App) MetricEnvs() (map[string]string, error) {
 	prov, err := app.getProvisioner()
 	if err != nil {
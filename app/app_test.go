@@ -1478,6 +1478,186 @@ func (s *S) TestAddCNameCallsRouterSetCName(c *check.C) {
 	c.Assert(hasCName, check.Equals, true)
 }
 
+func (s *S) TestSetCNameWeights(c *check.C) {
+	a := App{Name: "ktulu", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	other := App{Name: "ktulu-new", TeamOwner: s.team.Name}
+	err = CreateApp(&other, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.AddCName("ktulu.mycompany.com")
+	c.Assert(err, check.IsNil)
+	weights := []router.BackendWeight{{Backend: "ktulu", Weight: 90}, {Backend: "ktulu-new", Weight: 10}}
+	err = a.SetCNameWeights("ktulu.mycompany.com", weights)
+	c.Assert(err, check.IsNil)
+	result, err := a.CNamesWeights()
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, map[string][]router.BackendWeight{"ktulu.mycompany.com": weights})
+	err = a.SetCNameWeights("ktulu.mycompany.com", nil)
+	c.Assert(err, check.IsNil)
+	result, err = a.CNamesWeights()
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.HasLen, 0)
+}
+
+func (s *S) TestGetRouters(c *check.C) {
+	a := App{Name: "ktulu", Plan: Plan{Router: "fake-hc"}, RouterOpts: map[string]string{"opt1": "val1"}}
+	routers, err := a.GetRouters()
+	c.Assert(err, check.IsNil)
+	c.Assert(routers, check.DeepEquals, []router.AppRouter{{Name: "fake-hc", Opts: map[string]string{"opt1": "val1"}}})
+	a.Routers = []router.AppRouter{{Name: "fake"}, {Name: "fake-tls"}}
+	routers, err = a.GetRouters()
+	c.Assert(err, check.IsNil)
+	c.Assert(routers, check.DeepEquals, a.Routers)
+	routerName, err := a.GetRouter()
+	c.Assert(err, check.IsNil)
+	c.Assert(routerName, check.Equals, "fake")
+}
+
+func (s *S) TestCreateAppWithRouters(c *check.C) {
+	a := App{
+		Name:      "ktulu",
+		TeamOwner: s.team.Name,
+		Routers: []router.AppRouter{
+			{Name: "fake", Opts: map[string]string{"opt1": "val1"}},
+			{Name: "fake-hc", Opts: map[string]string{"opt2": "val2"}},
+		},
+	}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	c.Assert(routertest.FakeRouter.HasBackend(a.Name), check.Equals, true)
+	c.Assert(routertest.HCRouter.HasBackend(a.Name), check.Equals, true)
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Routers, check.DeepEquals, a.Routers)
+	routers, err := dbApp.GetRoutersWithAddr()
+	c.Assert(err, check.IsNil)
+	c.Assert(routers, check.DeepEquals, []router.AppRouter{
+		{Name: "fake", Opts: map[string]string{"opt1": "val1"}, Address: "ktulu.fakerouter.com"},
+		{Name: "fake-hc", Opts: map[string]string{"opt2": "val2"}, Address: "ktulu.fakerouter.com"},
+	})
+}
+
+func (s *S) TestCreateAppWithInvalidRouters(c *check.C) {
+	a := App{Name: "ktulu", TeamOwner: s.team.Name, Routers: []router.AppRouter{{Name: "fake"}, {Name: "fake"}}}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.FitsTypeOf, &errors.ValidationError{})
+	a.Routers = []router.AppRouter{{Name: "fake"}, {Name: "unknown"}}
+	err = CreateApp(&a, s.user)
+	c.Assert(err, check.FitsTypeOf, &errors.ValidationError{})
+	c.Assert(routertest.FakeRouter.HasBackend(a.Name), check.Equals, false)
+}
+
+func (s *S) TestAddRouter(c *check.C) {
+	a := App{Name: "ktulu", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = s.provisioner.AddUnits(&a, 2, "web", nil)
+	c.Assert(err, check.IsNil)
+	err = a.AddRouter(router.AppRouter{Name: "fake-hc", Opts: map[string]string{"opt1": "val1"}})
+	c.Assert(err, check.IsNil)
+	c.Assert(routertest.HCRouter.HasBackend(a.Name), check.Equals, true)
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	for _, u := range units {
+		c.Assert(routertest.HCRouter.HasRoute(a.Name, u.Address.String()), check.Equals, true)
+		c.Assert(routertest.FakeRouter.HasRoute(a.Name, u.Address.String()), check.Equals, true)
+	}
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Routers, check.HasLen, 2)
+	c.Assert(dbApp.Routers[0].Name, check.Equals, "fake")
+	c.Assert(dbApp.Routers[1], check.DeepEquals, router.AppRouter{Name: "fake-hc", Opts: map[string]string{"opt1": "val1"}})
+	err = a.AddRouter(router.AppRouter{Name: "fake-hc"})
+	c.Assert(err, check.Equals, ErrRouterAlreadyInApp)
+	err = a.AddRouter(router.AppRouter{Name: "unknown"})
+	c.Assert(err, check.FitsTypeOf, &errors.ValidationError{})
+}
+
+func (s *S) TestRemoveRouter(c *check.C) {
+	a := App{Name: "ktulu", TeamOwner: s.team.Name, Routers: []router.AppRouter{{Name: "fake"}, {Name: "fake-hc"}}}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.RemoveRouter("fake-tls")
+	c.Assert(err, check.Equals, ErrRouterNotInApp)
+	err = a.RemoveRouter("fake-hc")
+	c.Assert(err, check.IsNil)
+	c.Assert(routertest.HCRouter.HasBackend(a.Name), check.Equals, false)
+	c.Assert(routertest.FakeRouter.HasBackend(a.Name), check.Equals, true)
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Routers, check.HasLen, 1)
+	c.Assert(dbApp.Routers[0].Name, check.Equals, "fake")
+	err = a.RemoveRouter("fake")
+	c.Assert(err, check.FitsTypeOf, &errors.ValidationError{})
+}
+
+func (s *S) TestRemoveMainRouter(c *check.C) {
+	a := App{Name: "ktulu", TeamOwner: s.team.Name, Routers: []router.AppRouter{{Name: "fake"}, {Name: "fake-hc"}}}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.AddCName("ktulu.mycompany.com")
+	c.Assert(err, check.IsNil)
+	err = a.RemoveRouter("fake")
+	c.Assert(err, check.IsNil)
+	c.Assert(routertest.FakeRouter.HasBackend(a.Name), check.Equals, false)
+	routerName, err := a.GetRouter()
+	c.Assert(err, check.IsNil)
+	c.Assert(routerName, check.Equals, "fake-hc")
+	c.Assert(routertest.HCRouter.HasCName("ktulu.mycompany.com"), check.Equals, true)
+}
+
+func (s *S) TestDeleteWithRouters(c *check.C) {
+	a := App{Name: "ktulu", TeamOwner: s.team.Name, Routers: []router.AppRouter{{Name: "fake"}, {Name: "fake-hc"}}}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = Delete(&a, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(routertest.FakeRouter.HasBackend(a.Name), check.Equals, false)
+	c.Assert(routertest.HCRouter.HasBackend(a.Name), check.Equals, false)
+}
+
+func (s *S) TestSwapWithMultipleRouters(c *check.C) {
+	app1 := App{Name: "ktulu", TeamOwner: s.team.Name, Routers: []router.AppRouter{{Name: "fake"}, {Name: "fake-hc"}}}
+	err := CreateApp(&app1, s.user)
+	c.Assert(err, check.IsNil)
+	app2 := App{Name: "ktulu-new", TeamOwner: s.team.Name}
+	err = CreateApp(&app2, s.user)
+	c.Assert(err, check.IsNil)
+	err = Swap(&app1, &app2, false)
+	c.Assert(err, check.FitsTypeOf, &errors.ValidationError{})
+}
+
+func (s *S) TestSetCNameWeightsInvalid(c *check.C) {
+	tlsPlan := Plan{Name: "tls", Router: "fake-tls"}
+	err := s.conn.Plans().Insert(tlsPlan)
+	c.Assert(err, check.IsNil)
+	a := App{Name: "ktulu", TeamOwner: s.team.Name}
+	err = CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	other := App{Name: "ktulu-tls", TeamOwner: s.team.Name, Plan: tlsPlan}
+	err = CreateApp(&other, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.AddCName("ktulu.mycompany.com")
+	c.Assert(err, check.IsNil)
+	tests := []struct {
+		cname   string
+		weights []router.BackendWeight
+		err     string
+	}{
+		{cname: "ktulu.mycompany.com", weights: []router.BackendWeight{{Backend: "ktulu", Weight: 90}}, err: router.ErrInvalidWeights.Error()},
+		{cname: "ktulu.mycompany.com", weights: []router.BackendWeight{{Backend: "ktulu", Weight: 90}, {Backend: "unknown", Weight: 10}}, err: `app "unknown" not found`},
+		{cname: "ktulu.mycompany.com", weights: []router.BackendWeight{{Backend: "ktulu", Weight: 90}, {Backend: "ktulu-tls", Weight: 10}}, err: `app "ktulu-tls" must use router "fake" to receive traffic from app "ktulu"`},
+	}
+	for _, tt := range tests {
+		err = a.SetCNameWeights(tt.cname, tt.weights)
+		c.Check(err, check.ErrorMatches, tt.err)
+		c.Check(err, check.FitsTypeOf, &errors.ValidationError{})
+	}
+	err = a.SetCNameWeights("other.mycompany.com", nil)
+	c.Assert(err, check.Equals, router.ErrCNameNotFound)
+}
+
 func (s *S) TestAddCnameRollbackWithDuplicatedCName(c *check.C) {
 	a := App{Name: "ktulu", TeamOwner: s.team.Name}
 	err := CreateApp(&a, s.user)
@@ -1704,6 +1884,65 @@ func (s *S) TestAddInstanceDuplicated(c *check.C) {
 	})
 }
 
+func (s *S) TestAddInstanceSwapsEnvs(c *check.C) {
+	a := &App{Name: "sith", Quota: quota.Quota{Limit: 10}, TeamOwner: s.team.Name}
+	err := CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.AddUnits(1, "web", nil)
+	c.Assert(err, check.IsNil)
+	instance := bind.ServiceInstance{
+		Name: "myinstance",
+		Envs: map[string]string{
+			"DATABASE_USER":     "root",
+			"DATABASE_PASSWORD": "s3cr3t",
+		},
+	}
+	err = a.AddInstance(
+		bind.InstanceApp{
+			ServiceName:   "myservice",
+			Instance:      instance,
+			ShouldRestart: false,
+		}, nil)
+	c.Assert(err, check.IsNil)
+	instance = bind.ServiceInstance{
+		Name: "myinstance",
+		Envs: map[string]string{
+			"DATABASE_USER":  "readonly",
+			"DATABASE_TOKEN": "t0k3n",
+		},
+	}
+	err = a.AddInstance(
+		bind.InstanceApp{
+			ServiceName:   "myservice",
+			Instance:      instance,
+			ShouldRestart: true,
+		}, nil)
+	c.Assert(err, check.IsNil)
+	a, err = GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	expected := map[string][]bind.ServiceInstance{"myservice": {instance}}
+	var got map[string][]bind.ServiceInstance
+	err = json.Unmarshal([]byte(a.Env[TsuruServicesEnvVar].Value), &got)
+	c.Assert(err, check.IsNil)
+	c.Assert(got, check.DeepEquals, expected)
+	delete(a.Env, TsuruServicesEnvVar)
+	c.Assert(a.Env, check.DeepEquals, map[string]bind.EnvVar{
+		"DATABASE_USER": {
+			Name:         "DATABASE_USER",
+			Value:        "readonly",
+			Public:       false,
+			InstanceName: "myinstance",
+		},
+		"DATABASE_TOKEN": {
+			Name:         "DATABASE_TOKEN",
+			Value:        "t0k3n",
+			Public:       false,
+			InstanceName: "myinstance",
+		},
+	})
+	c.Assert(s.provisioner.Restarts(a, ""), check.Equals, 1)
+}
+
 func (s *S) TestAddInstanceWithUnits(c *check.C) {
 	a := &App{Name: "dark", Quota: quota.Quota{Limit: 10}, TeamOwner: s.team.Name}
 	err := CreateApp(a, s.user)
@@ -2474,6 +2713,9 @@ func (s *S) TestAppMarshalJSON(c *check.C) {
 		"description": "description",
 		"teamowner":   "myteam",
 		"lock":        s.zeroLock,
+		"routers": []interface{}{
+			map[string]interface{}{"name": "fake", "opts": nil, "address": ""},
+		},
 		"plan": map[string]interface{}{
 			"name":     "myplan",
 			"memory":   float64(64),
@@ -2517,6 +2759,9 @@ func (s *S) TestAppMarshalJSONWithoutRepository(c *check.C) {
 		"description": "description",
 		"teamowner":   "myteam",
 		"lock":        s.zeroLock,
+		"routers": []interface{}{
+			map[string]interface{}{"name": "fake", "opts": nil, "address": ""},
+		},
 		"plan": map[string]interface{}{
 			"name":     "myplan",
 			"memory":   float64(64),
@@ -2982,6 +3227,27 @@ func (s *S) TestListFilteringByPool(c *check.C) {
 	c.Assert(apps[0].GetPool(), check.Equals, a2.Pool)
 }
 
+func (s *S) TestListFilteringByPlan(c *check.C) {
+	a := App{
+		Name:  "testapp",
+		Teams: []string{s.team.Name},
+		Plan:  Plan{Name: "plan1"},
+	}
+	a2 := App{
+		Name:  "othertestapp",
+		Teams: []string{s.team.Name},
+		Plan:  Plan{Name: "plan2"},
+	}
+	err := s.conn.Apps().Insert(&a)
+	c.Assert(err, check.IsNil)
+	err = s.conn.Apps().Insert(&a2)
+	c.Assert(err, check.IsNil)
+	apps, err := List(&Filter{Plan: "plan2"})
+	c.Assert(err, check.IsNil)
+	c.Assert(apps, check.HasLen, 1)
+	c.Assert(apps[0].GetName(), check.Equals, a2.Name)
+}
+
 func (s *S) TestListFilteringByPools(c *check.C) {
 	opts := provision.AddPoolOptions{Name: "test2", Default: false}
 	err := provision.AddPool(opts)
@@ -4015,6 +4281,41 @@ func (s *S) TestUpdatePlanNoRouteChange(c *check.C) {
 	c.Assert(routesStr, check.DeepEquals, expected)
 }
 
+func (s *S) TestChangePlanQuotaExceeded(c *check.C) {
+	plan := Plan{Name: "something", Router: "fake", CpuShare: 100, Memory: 268435456}
+	err := s.conn.Plans().Insert(plan)
+	c.Assert(err, check.IsNil)
+	a := App{Name: "my-test-app", Plan: Plan{Router: "fake", Memory: 536870912, CpuShare: 50}, TeamOwner: s.team.Name, Quota: quota.Unlimited}
+	err = CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.AddUnits(3, "web", nil)
+	c.Assert(err, check.IsNil)
+	err = s.conn.Apps().Update(bson.M{"name": a.Name}, bson.M{"$set": bson.M{"quota.limit": 2, "quota.inuse": 2}})
+	c.Assert(err, check.IsNil)
+	err = a.ChangePlan("something", new(bytes.Buffer))
+	c.Assert(err, check.DeepEquals, &quota.QuotaExceededError{Requested: 3, Available: 2})
+	c.Assert(a.Plan.Name, check.Equals, s.defaultPlan.Name)
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Plan.Name, check.Equals, s.defaultPlan.Name)
+	c.Assert(s.provisioner.Restarts(dbApp, ""), check.Equals, 0)
+}
+
+func (s *S) TestChangePlanPoolNotAllowed(c *check.C) {
+	plan := Plan{Name: "something", Router: "fake", CpuShare: 100, Memory: 268435456}
+	err := s.conn.Plans().Insert(plan)
+	c.Assert(err, check.IsNil)
+	err = provision.AddPool(provision.AddPoolOptions{Name: "closed"})
+	c.Assert(err, check.IsNil)
+	a := App{Name: "my-test-app", Plan: Plan{Router: "fake", Memory: 536870912, CpuShare: 50}, TeamOwner: s.team.Name}
+	err = CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	a.Pool = "closed"
+	err = a.ChangePlan("something", new(bytes.Buffer))
+	c.Assert(err, check.ErrorMatches, `App team owner ".*" has no access to pool "closed"`)
+	c.Assert(s.provisioner.Restarts(&a, ""), check.Equals, 0)
+}
+
 func (s *S) TestUpdatePlanNotFound(c *check.C) {
 	var app App
 	updateData := App{Name: "my-test-app", Plan: Plan{Name: "some-unknown-plan"}}
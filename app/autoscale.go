@@ -0,0 +1,337 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/api/shutdown"
+	"github.com/tsuru/tsuru/db"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/provision"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const appAutoScaleEventKind = "app-autoscale"
+
+var (
+	ErrAutoScaleRuleNotFound = errors.New("autoscale rule not found")
+
+	// autoScaleTolerance is the relative distance from the target value
+	// within which no scaling happens, avoiding flapping around the target.
+	autoScaleTolerance = 0.1
+)
+
+// AutoScaleRule describes how the units of a process of an app are scaled.
+// The number of units is kept between MinUnits and MaxUnits so that the
+// average value of Metric across the units stays close to Target.
+type AutoScaleRule struct {
+	App      string
+	Process  string
+	MinUnits uint
+	MaxUnits uint
+	Metric   string
+	Target   float64
+	Enabled  bool
+}
+
+func (r *AutoScaleRule) validate() error {
+	switch r.Metric {
+	case provision.UnitMetricCPU, provision.UnitMetricMemory, provision.UnitMetricRequests:
+	default:
+		msg := fmt.Sprintf("invalid autoscale metric %q, valid metrics are: %s, %s and %s", r.Metric,
+			provision.UnitMetricCPU, provision.UnitMetricMemory, provision.UnitMetricRequests)
+		return &tsuruErrors.ValidationError{Message: msg}
+	}
+	if r.Process == "" {
+		return &tsuruErrors.ValidationError{Message: "autoscale process is required"}
+	}
+	if r.Target <= 0 {
+		return &tsuruErrors.ValidationError{Message: "autoscale target must be greater than 0"}
+	}
+	if r.MinUnits == 0 {
+		return &tsuruErrors.ValidationError{Message: "autoscale min units must be greater than 0"}
+	}
+	if r.MaxUnits < r.MinUnits {
+		return &tsuruErrors.ValidationError{Message: "autoscale max units must be greater than or equal to min units"}
+	}
+	return nil
+}
+
+// desiredUnits returns the number of units needed to bring the average metric
+// value of current units to the rule target, clamped to the rule limits.
+func (r *AutoScaleRule) desiredUnits(current int, value float64) int {
+	desired := current
+	ratio := value / r.Target
+	if math.Abs(ratio-1) > autoScaleTolerance {
+		desired = int(math.Ceil(float64(current) * ratio))
+	}
+	if desired < int(r.MinUnits) {
+		desired = int(r.MinUnits)
+	}
+	if desired > int(r.MaxUnits) {
+		desired = int(r.MaxUnits)
+	}
+	return desired
+}
+
+// SetAutoScaleRule creates or updates the autoscale rule of one of the
+// processes of the app.
+func (app *App) SetAutoScaleRule(rule AutoScaleRule) error {
+	rule.App = app.Name
+	err := rule.validate()
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.AppAutoScaleRules().Upsert(bson.M{"app": rule.App, "process": rule.Process}, rule)
+	return err
+}
+
+// AutoScaleRules returns the autoscale rules of the app.
+func (app *App) AutoScaleRules() ([]AutoScaleRule, error) {
+	return listAutoScaleRules(bson.M{"app": app.Name})
+}
+
+// RemoveAutoScaleRule removes the autoscale rule of the given process.
+func (app *App) RemoveAutoScaleRule(process string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.AppAutoScaleRules().Remove(bson.M{"app": app.Name, "process": process})
+	if err == mgo.ErrNotFound {
+		return ErrAutoScaleRuleNotFound
+	}
+	return err
+}
+
+func removeAutoScaleRules(appName string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.AppAutoScaleRules().RemoveAll(bson.M{"app": appName})
+	return err
+}
+
+func listAutoScaleRules(query bson.M) ([]AutoScaleRule, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var rules []AutoScaleRule
+	err = conn.AppAutoScaleRules().Find(query).Sort("app", "process").All(&rules)
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+type autoScaleResult struct {
+	Units    int
+	Value    float64
+	ToAdd    uint
+	ToRemove uint
+	Reason   string
+}
+
+type autoScaleEvtCustomData struct {
+	Rule   AutoScaleRule
+	Result autoScaleResult
+}
+
+type unitAutoScaler struct {
+	runInterval time.Duration
+	done        chan bool
+}
+
+// InitializeAutoScale starts the background worker responsible for applying
+// the units autoscale rules, if enabled in the config file.
+func InitializeAutoScale() {
+	enabled, _ := config.GetBool("app-auto-scale:enabled")
+	if !enabled {
+		return
+	}
+	runInterval, _ := config.GetInt("app-auto-scale:run-interval")
+	if runInterval <= 0 {
+		runInterval = 60
+	}
+	scaler := &unitAutoScaler{
+		runInterval: time.Duration(runInterval) * time.Second,
+		done:        make(chan bool),
+	}
+	shutdown.Register(scaler)
+	go scaler.Run()
+}
+
+func (s *unitAutoScaler) Run() {
+	for {
+		err := s.runOnce()
+		if err != nil {
+			log.Errorf("[app autoscale] %s", err)
+		}
+		select {
+		case <-s.done:
+			return
+		case <-time.After(s.runInterval):
+		}
+	}
+}
+
+func (s *unitAutoScaler) Shutdown() {
+	s.done <- true
+}
+
+func (s *unitAutoScaler) String() string {
+	return "app units auto scale"
+}
+
+func (s *unitAutoScaler) runOnce() (retErr error) {
+	defer func() {
+		if r := recover(); r != nil {
+			retErr = errors.Errorf("recovered panic, we can never stop! panic: %v", r)
+		}
+	}()
+	rules, err := listAutoScaleRules(bson.M{"enabled": true})
+	if err != nil {
+		return errors.Wrap(err, "error listing rules")
+	}
+	for _, rule := range rules {
+		a, err := GetByName(rule.App)
+		if err != nil {
+			log.Errorf("[app autoscale] unable to get app %q: %s", rule.App, err)
+			continue
+		}
+		err = autoScaleProcess(a, rule)
+		if err != nil {
+			log.Errorf("[app autoscale] error scaling process %q of app %q: %s", rule.Process, rule.App, err)
+		}
+	}
+	return nil
+}
+
+// autoScaleProcess applies the rule to the units of the app process. Every
+// scaling decision is recorded in an event targeting the app, events where
+// no action is taken are aborted.
+func autoScaleProcess(a *App, rule AutoScaleRule) (err error) {
+	evt, err := event.NewInternal(&event.Opts{
+		Target:       event.Target{Type: event.TargetTypeApp, Value: a.Name},
+		InternalKind: appAutoScaleEventKind,
+		CustomData:   rule,
+		Allowed: event.Allowed(permission.PermAppReadEvents, append(permission.Contexts(permission.CtxTeam, a.Teams),
+			permission.Context(permission.CtxApp, a.Name),
+			permission.Context(permission.CtxPool, a.Pool),
+		)...),
+	})
+	if err != nil {
+		if _, ok := err.(event.ErrEventLocked); ok {
+			log.Debugf("[app autoscale] skipping locked app %q", a.Name)
+			return nil
+		}
+		return err
+	}
+	var result autoScaleResult
+	defer func() {
+		if err == nil && result.ToAdd == 0 && result.ToRemove == 0 {
+			evt.Abort()
+			return
+		}
+		evt.DoneCustomData(err, autoScaleEvtCustomData{Rule: rule, Result: result})
+	}()
+	units, err := a.Units()
+	if err != nil {
+		return err
+	}
+	for _, u := range units {
+		if u.ProcessName == rule.Process {
+			result.Units++
+		}
+	}
+	if result.Units == 0 {
+		return nil
+	}
+	result.Value, err = averageUnitsMetric(a, rule)
+	if err != nil {
+		return err
+	}
+	desired := rule.desiredUnits(result.Units, result.Value)
+	if desired > result.Units {
+		toAdd := desired - result.Units
+		result.Reason = fmt.Sprintf("%s %.2f above target %.2f", rule.Metric, result.Value, rule.Target)
+		if result.Units < int(rule.MinUnits) {
+			result.Reason = fmt.Sprintf("units below minimum of %d", rule.MinUnits)
+		}
+		if !a.Quota.Unlimited() {
+			available := a.Quota.Limit - a.Quota.InUse
+			if available <= 0 {
+				log.Debugf("[app autoscale] app %q has no quota available for new units", a.Name)
+				return nil
+			}
+			if toAdd > available {
+				toAdd = available
+				result.Reason += ", limited by app quota"
+			}
+		}
+		result.ToAdd = uint(toAdd)
+		fmt.Fprintf(evt, "Adding %d units to process %q: %s\n", result.ToAdd, rule.Process, result.Reason)
+		return a.AddUnits(result.ToAdd, rule.Process, evt)
+	}
+	if desired < result.Units {
+		result.ToRemove = uint(result.Units - desired)
+		result.Reason = fmt.Sprintf("%s %.2f below target %.2f", rule.Metric, result.Value, rule.Target)
+		if result.Units > int(rule.MaxUnits) {
+			result.Reason = fmt.Sprintf("units above maximum of %d", rule.MaxUnits)
+		}
+		fmt.Fprintf(evt, "Removing %d units from process %q: %s\n", result.ToRemove, rule.Process, result.Reason)
+		return a.RemoveUnits(result.ToRemove, rule.Process, evt)
+	}
+	return nil
+}
+
+func averageUnitsMetric(a *App, rule AutoScaleRule) (float64, error) {
+	prov, err := a.getProvisioner()
+	if err != nil {
+		return 0, err
+	}
+	metricsProv, ok := prov.(provision.UnitMetricsProvisioner)
+	if !ok {
+		return 0, provision.ProvisionerNotSupported{Prov: prov, Action: "units metrics"}
+	}
+	metrics, err := metricsProv.UnitsMetrics(a)
+	if err != nil {
+		return 0, err
+	}
+	var sum float64
+	var count int
+	for _, m := range metrics {
+		if m.ProcessName != rule.Process {
+			continue
+		}
+		if value, ok := m.Values[rule.Metric]; ok {
+			sum += value
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, errors.Errorf("metric %q not reported for units of process %q", rule.Metric, rule.Process)
+	}
+	return sum / float64(count), nil
+}
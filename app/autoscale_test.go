@@ -0,0 +1,216 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/quota"
+	"github.com/tsuru/tsuru/router/routertest"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestAutoScaleRuleValidate(c *check.C) {
+	tests := []struct {
+		rule AutoScaleRule
+		err  string
+	}{
+		{rule: AutoScaleRule{Process: "web", Metric: "cpu", Target: 50, MinUnits: 1, MaxUnits: 5}},
+		{rule: AutoScaleRule{Process: "web", Metric: "memory", Target: 100, MinUnits: 2, MaxUnits: 2}},
+		{rule: AutoScaleRule{Process: "web", Metric: "rps", Target: 100, MinUnits: 2, MaxUnits: 2}},
+		{rule: AutoScaleRule{Process: "web", Metric: "disk", Target: 50, MinUnits: 1, MaxUnits: 5}, err: `invalid autoscale metric "disk".*`},
+		{rule: AutoScaleRule{Metric: "cpu", Target: 50, MinUnits: 1, MaxUnits: 5}, err: "autoscale process is required"},
+		{rule: AutoScaleRule{Process: "web", Metric: "memory", MinUnits: 1, MaxUnits: 5}, err: "autoscale target must be greater than 0"},
+		{rule: AutoScaleRule{Process: "web", Metric: "cpu", Target: 50, MaxUnits: 5}, err: "autoscale min units must be greater than 0"},
+		{rule: AutoScaleRule{Process: "web", Metric: "cpu", Target: 50, MinUnits: 3, MaxUnits: 2}, err: "autoscale max units must be greater than or equal to min units"},
+	}
+	for _, tt := range tests {
+		err := tt.rule.validate()
+		if tt.err == "" {
+			c.Check(err, check.IsNil)
+		} else {
+			c.Check(err, check.ErrorMatches, tt.err)
+			c.Check(err, check.FitsTypeOf, &errors.ValidationError{})
+		}
+	}
+}
+
+func (s *S) TestAutoScaleRuleDesiredUnits(c *check.C) {
+	rule := AutoScaleRule{Target: 50, MinUnits: 2, MaxUnits: 10}
+	c.Assert(rule.desiredUnits(4, 50), check.Equals, 4)
+	c.Assert(rule.desiredUnits(4, 54), check.Equals, 4)
+	c.Assert(rule.desiredUnits(4, 100), check.Equals, 8)
+	c.Assert(rule.desiredUnits(4, 25), check.Equals, 2)
+	c.Assert(rule.desiredUnits(4, 500), check.Equals, 10)
+	c.Assert(rule.desiredUnits(4, 1), check.Equals, 2)
+	c.Assert(rule.desiredUnits(1, 50), check.Equals, 2)
+}
+
+func (s *S) TestSetAutoScaleRule(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	rule := AutoScaleRule{Process: "web", Metric: "cpu", Target: 50, MinUnits: 1, MaxUnits: 5, Enabled: true}
+	err = a.SetAutoScaleRule(rule)
+	c.Assert(err, check.IsNil)
+	rule.Target = 70
+	err = a.SetAutoScaleRule(rule)
+	c.Assert(err, check.IsNil)
+	err = a.SetAutoScaleRule(AutoScaleRule{Process: "worker", Metric: "memory", Target: 80, MinUnits: 1, MaxUnits: 2})
+	c.Assert(err, check.IsNil)
+	rules, err := a.AutoScaleRules()
+	c.Assert(err, check.IsNil)
+	c.Assert(rules, check.DeepEquals, []AutoScaleRule{
+		{App: "myapp", Process: "web", Metric: "cpu", Target: 70, MinUnits: 1, MaxUnits: 5, Enabled: true},
+		{App: "myapp", Process: "worker", Metric: "memory", Target: 80, MinUnits: 1, MaxUnits: 2},
+	})
+}
+
+func (s *S) TestSetAutoScaleRuleInvalid(c *check.C) {
+	a := App{Name: "myapp"}
+	err := a.SetAutoScaleRule(AutoScaleRule{Process: "web", Metric: "cpu"})
+	c.Assert(err, check.ErrorMatches, "autoscale target must be greater than 0")
+}
+
+func (s *S) TestRemoveAutoScaleRule(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.SetAutoScaleRule(AutoScaleRule{Process: "web", Metric: "cpu", Target: 50, MinUnits: 1, MaxUnits: 5})
+	c.Assert(err, check.IsNil)
+	err = a.RemoveAutoScaleRule("web")
+	c.Assert(err, check.IsNil)
+	rules, err := a.AutoScaleRules()
+	c.Assert(err, check.IsNil)
+	c.Assert(rules, check.HasLen, 0)
+	err = a.RemoveAutoScaleRule("web")
+	c.Assert(err, check.Equals, ErrAutoScaleRuleNotFound)
+}
+
+func (s *S) TestAutoScaleProcessAddUnits(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name, Quota: quota.Unlimited}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.AddUnits(2, "web", nil)
+	c.Assert(err, check.IsNil)
+	s.provisioner.PrepareUnitsMetric(&a, provision.UnitMetricCPU, 90)
+	rule := AutoScaleRule{App: a.Name, Process: "web", Metric: "cpu", Target: 60, MinUnits: 1, MaxUnits: 10, Enabled: true}
+	err = autoScaleProcess(&a, rule)
+	c.Assert(err, check.IsNil)
+	c.Assert(s.provisioner.GetUnits(&a), check.HasLen, 3)
+	evts, err := event.List(&event.Filter{KindName: appAutoScaleEventKind})
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 1)
+	c.Assert(evts[0].Target, check.DeepEquals, event.Target{Type: event.TargetTypeApp, Value: a.Name})
+	var data autoScaleEvtCustomData
+	err = evts[0].EndData(&data)
+	c.Assert(err, check.IsNil)
+	c.Assert(data.Result, check.DeepEquals, autoScaleResult{Units: 2, Value: 90, ToAdd: 1, Reason: "cpu 90.00 above target 60.00"})
+}
+
+func (s *S) TestAutoScaleProcessRemoveUnits(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name, Quota: quota.Unlimited}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.AddUnits(4, "web", nil)
+	c.Assert(err, check.IsNil)
+	err = a.AddUnits(1, "worker", nil)
+	c.Assert(err, check.IsNil)
+	s.provisioner.PrepareUnitsMetric(&a, provision.UnitMetricMemory, 10)
+	rule := AutoScaleRule{App: a.Name, Process: "web", Metric: "memory", Target: 40, MinUnits: 2, MaxUnits: 10, Enabled: true}
+	err = autoScaleProcess(&a, rule)
+	c.Assert(err, check.IsNil)
+	units := s.provisioner.GetUnits(&a)
+	c.Assert(units, check.HasLen, 3)
+	evts, err := event.List(&event.Filter{KindName: appAutoScaleEventKind})
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 1)
+	var data autoScaleEvtCustomData
+	err = evts[0].EndData(&data)
+	c.Assert(err, check.IsNil)
+	c.Assert(data.Result.ToRemove, check.Equals, uint(2))
+}
+
+func (s *S) TestAutoScaleProcessRequestsPerSecond(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name, Quota: quota.Unlimited}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.AddUnits(2, "web", nil)
+	c.Assert(err, check.IsNil)
+	units := s.provisioner.GetUnits(&a)
+	c.Assert(units, check.HasLen, 2)
+	routertest.FakeRouter.SetRoutesRequests(a.Name, map[string]float64{
+		units[0].Address.Host: 300,
+		units[1].Address.Host: 100,
+	})
+	rule := AutoScaleRule{App: a.Name, Process: "web", Metric: "rps", Target: 100, MinUnits: 1, MaxUnits: 10, Enabled: true}
+	err = autoScaleProcess(&a, rule)
+	c.Assert(err, check.IsNil)
+	c.Assert(s.provisioner.GetUnits(&a), check.HasLen, 4)
+	evts, err := event.List(&event.Filter{KindName: appAutoScaleEventKind})
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 1)
+	var data autoScaleEvtCustomData
+	err = evts[0].EndData(&data)
+	c.Assert(err, check.IsNil)
+	c.Assert(data.Result, check.DeepEquals, autoScaleResult{Units: 2, Value: 200, ToAdd: 2, Reason: "rps 200.00 above target 100.00"})
+}
+
+func (s *S) TestAutoScaleProcessRespectsQuota(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = ChangeQuota(&a, 3)
+	c.Assert(err, check.IsNil)
+	err = a.AddUnits(2, "web", nil)
+	c.Assert(err, check.IsNil)
+	s.provisioner.PrepareUnitsMetric(&a, provision.UnitMetricCPU, 200)
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	rule := AutoScaleRule{App: a.Name, Process: "web", Metric: "cpu", Target: 50, MinUnits: 1, MaxUnits: 10, Enabled: true}
+	err = autoScaleProcess(dbApp, rule)
+	c.Assert(err, check.IsNil)
+	c.Assert(s.provisioner.GetUnits(&a), check.HasLen, 3)
+	evts, err := event.List(&event.Filter{KindName: appAutoScaleEventKind})
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 1)
+	var data autoScaleEvtCustomData
+	err = evts[0].EndData(&data)
+	c.Assert(err, check.IsNil)
+	c.Assert(data.Result.ToAdd, check.Equals, uint(1))
+	c.Assert(data.Result.Reason, check.Equals, "cpu 200.00 above target 50.00, limited by app quota")
+}
+
+func (s *S) TestAutoScaleProcessNoAction(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name, Quota: quota.Unlimited}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.AddUnits(2, "web", nil)
+	c.Assert(err, check.IsNil)
+	s.provisioner.PrepareUnitsMetric(&a, provision.UnitMetricCPU, 52)
+	rule := AutoScaleRule{App: a.Name, Process: "web", Metric: "cpu", Target: 50, MinUnits: 1, MaxUnits: 10, Enabled: true}
+	err = autoScaleProcess(&a, rule)
+	c.Assert(err, check.IsNil)
+	c.Assert(s.provisioner.GetUnits(&a), check.HasLen, 2)
+	evts, err := event.List(&event.Filter{KindName: appAutoScaleEventKind})
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 0)
+}
+
+func (s *S) TestAutoScaleProcessMetricNotReported(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name, Quota: quota.Unlimited}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.AddUnits(2, "web", nil)
+	c.Assert(err, check.IsNil)
+	rule := AutoScaleRule{App: a.Name, Process: "web", Metric: "memory", Target: 50, MinUnits: 1, MaxUnits: 10, Enabled: true}
+	err = autoScaleProcess(&a, rule)
+	c.Assert(err, check.ErrorMatches, `metric "memory" not reported for units of process "web"`)
+	evts, err := event.List(&event.Filter{KindName: appAutoScaleEventKind})
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 1)
+	c.Assert(evts[0].Error, check.Equals, `metric "memory" not reported for units of process "web"`)
+}
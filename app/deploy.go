@@ -168,6 +168,7 @@ type DeployOptions struct {
 	Event        *event.Event `bson:"-"`
 	Kind         DeployKind
 	Message      string
+	Strategy     provision.DeployStrategy
 }
 
 func (o *DeployOptions) GetOrigin() string {
@@ -209,6 +210,9 @@ func Deploy(opts DeployOptions) (string, error) {
 	if opts.Event == nil {
 		return "", errors.Errorf("missing event in deploy opts")
 	}
+	if err := opts.Strategy.Validate(); err != nil {
+		return "", err
+	}
 	if opts.Rollback && !regexp.MustCompile(":v[0-9]+$").MatchString(opts.Image) {
 		validImages, err := findValidImages(*opts.App)
 		if err == nil {
@@ -233,6 +237,12 @@ func Deploy(opts DeployOptions) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if opts.GetKind() != DeployRollback {
+		err = verifyDeploy(&opts, imageId)
+		if err != nil {
+			return "", err
+		}
+	}
 	err = incrementDeploy(opts.App)
 	if err != nil {
 		log.Errorf("WARNING: couldn't increment deploy count, deploy opts: %#v", opts)
@@ -9,6 +9,8 @@ import (
 	"encoding/json"
 	"errors"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"time"
 
@@ -276,6 +278,179 @@ func (s *S) TestDeployApp(c *check.C) {
 	c.Assert(logs, check.Equals, "Image deploy called")
 }
 
+func (s *S) TestDeployAppVerificationRollback(c *check.C) {
+	oldInterval := verificationDefaultInterval
+	verificationDefaultInterval = time.Millisecond
+	defer func() { verificationDefaultInterval = oldInterval }()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, check.Equals, "/status")
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	a := App{
+		Name:      "some-app",
+		Plan:      Plan{Router: "fake"},
+		Platform:  "django",
+		Teams:     []string{s.team.Name},
+		TeamOwner: s.team.Name,
+	}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	srvURL, err := url.Parse(srv.URL)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnit(&a, provision.Unit{ID: "u1", AppName: a.Name, ProcessName: "web", Address: srvURL})
+	err = image.SaveImageCustomData("myimage", map[string]interface{}{
+		"healthcheck": map[string]interface{}{
+			"path": "/hc",
+			"verification": map[string]interface{}{
+				"path":       "/status",
+				"duration":   60,
+				"error_rate": 10,
+			},
+		},
+	})
+	c.Assert(err, check.IsNil)
+	for _, img := range []string{"oldimage", "myimage"} {
+		err = image.AppendAppImageName(a.Name, img)
+		c.Assert(err, check.IsNil)
+	}
+	writer := &bytes.Buffer{}
+	evt, err := event.New(&event.Opts{
+		Target:   event.Target{Type: "app", Value: a.Name},
+		Kind:     permission.PermAppDeploy,
+		RawOwner: event.Owner{Type: event.OwnerTypeUser, Name: s.user.Email},
+		Allowed:  event.Allowed(permission.PermApp),
+	})
+	c.Assert(err, check.IsNil)
+	_, err = Deploy(DeployOptions{
+		App:          &a,
+		Image:        "myimage",
+		OutputStream: writer,
+		Event:        evt,
+	})
+	c.Assert(err, check.ErrorMatches, `deploy verification failed with 100.00% error rate, app rolled back to image "oldimage"`)
+	c.Assert(writer.String(), check.Matches, `(?s)Image deploy called.*Probe to .* failed: unexpected status code: 500.*Rollback deploy called`)
+	children, err := event.List(&event.Filter{ParentID: evt.UniqueID.Hex()})
+	c.Assert(err, check.IsNil)
+	c.Assert(children, check.HasLen, 1)
+	c.Assert(children[0].Kind, check.Equals, event.Kind{Type: event.KindTypeInternal, Name: "deploy-verification"})
+	c.Assert(children[0].Error, check.Matches, "deploy verification failed.*")
+	var endData map[string]string
+	err = children[0].EndData(&endData)
+	c.Assert(err, check.IsNil)
+	c.Assert(endData, check.DeepEquals, map[string]string{"image": "oldimage", "result": "rolled-back"})
+}
+
+func (s *S) TestDeployAppVerificationPassed(c *check.C) {
+	oldInterval := verificationDefaultInterval
+	verificationDefaultInterval = time.Millisecond
+	defer func() { verificationDefaultInterval = oldInterval }()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, check.Equals, "/hc")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	a := App{
+		Name:      "some-app",
+		Plan:      Plan{Router: "fake"},
+		Platform:  "django",
+		Teams:     []string{s.team.Name},
+		TeamOwner: s.team.Name,
+	}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	srvURL, err := url.Parse(srv.URL)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnit(&a, provision.Unit{ID: "u1", AppName: a.Name, ProcessName: "web", Address: srvURL})
+	err = image.SaveImageCustomData("myimage", map[string]interface{}{
+		"healthcheck": map[string]interface{}{
+			"path": "/hc",
+			"verification": map[string]interface{}{
+				"duration":   1,
+				"error_rate": 10,
+			},
+		},
+	})
+	c.Assert(err, check.IsNil)
+	for _, img := range []string{"oldimage", "myimage"} {
+		err = image.AppendAppImageName(a.Name, img)
+		c.Assert(err, check.IsNil)
+	}
+	writer := &bytes.Buffer{}
+	evt, err := event.New(&event.Opts{
+		Target:   event.Target{Type: "app", Value: a.Name},
+		Kind:     permission.PermAppDeploy,
+		RawOwner: event.Owner{Type: event.OwnerTypeUser, Name: s.user.Email},
+		Allowed:  event.Allowed(permission.PermApp),
+	})
+	c.Assert(err, check.IsNil)
+	_, err = Deploy(DeployOptions{
+		App:          &a,
+		Image:        "myimage",
+		OutputStream: writer,
+		Event:        evt,
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(writer.String(), check.Matches, `(?s).*Deploy verified, 0 of \d+ probes failed.*`)
+	children, err := event.List(&event.Filter{ParentID: evt.UniqueID.Hex()})
+	c.Assert(err, check.IsNil)
+	c.Assert(children, check.HasLen, 1)
+	c.Assert(children[0].Error, check.Equals, "")
+}
+
+func (s *S) TestDeployAppVerificationNotEnoughProbes(c *check.C) {
+	oldInterval := verificationDefaultInterval
+	verificationDefaultInterval = time.Millisecond
+	defer func() { verificationDefaultInterval = oldInterval }()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	a := App{
+		Name:      "some-app",
+		Plan:      Plan{Router: "fake"},
+		Platform:  "django",
+		Teams:     []string{s.team.Name},
+		TeamOwner: s.team.Name,
+	}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	srvURL, err := url.Parse(srv.URL)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnit(&a, provision.Unit{ID: "u1", AppName: a.Name, ProcessName: "web", Address: srvURL})
+	err = image.SaveImageCustomData("myimage", map[string]interface{}{
+		"healthcheck": map[string]interface{}{
+			"path": "/hc",
+			"verification": map[string]interface{}{
+				"duration":   1,
+				"min_probes": 1000000,
+			},
+		},
+	})
+	c.Assert(err, check.IsNil)
+	for _, img := range []string{"oldimage", "myimage"} {
+		err = image.AppendAppImageName(a.Name, img)
+		c.Assert(err, check.IsNil)
+	}
+	writer := &bytes.Buffer{}
+	evt, err := event.New(&event.Opts{
+		Target:   event.Target{Type: "app", Value: a.Name},
+		Kind:     permission.PermAppDeploy,
+		RawOwner: event.Owner{Type: event.OwnerTypeUser, Name: s.user.Email},
+		Allowed:  event.Allowed(permission.PermApp),
+	})
+	c.Assert(err, check.IsNil)
+	_, err = Deploy(DeployOptions{
+		App:          &a,
+		Image:        "myimage",
+		OutputStream: writer,
+		Event:        evt,
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(writer.String(), check.Matches, `(?s).*Deploy not verified, only \d+ of 1000000 required probes were made.*`)
+	c.Assert(writer.String(), check.Not(check.Matches), `(?s).*Rollback deploy called.*`)
+}
+
 func (s *S) TestDeployAppWithUpdatePlatform(c *check.C) {
 	a := App{
 		Name:           "some-app",
@@ -39,7 +39,7 @@ var (
 	ErrLimitOfMemory        = errors.New("The minimum allowed memory is 4MB")
 )
 
-func (plan *Plan) Save() error {
+func (plan *Plan) validate() error {
 	if plan.Name == "" {
 		return PlanValidationError{"name"}
 	}
@@ -55,6 +55,14 @@ func (plan *Plan) Save() error {
 			return PlanValidationError{fmt.Sprintf("router error: %v", err)}
 		}
 	}
+	return nil
+}
+
+func (plan *Plan) Save() error {
+	err := plan.validate()
+	if err != nil {
+		return err
+	}
 	conn, err := db.Conn()
 	if err != nil {
 		return err
@@ -73,6 +81,32 @@ func (plan *Plan) Save() error {
 	return err
 }
 
+// Update replaces the values of an existing plan. Apps keep a copy of their
+// plan, so the new values are only applied to an app after calling
+// ChangePlan on it.
+func (plan *Plan) Update() error {
+	err := plan.validate()
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if plan.Default {
+		_, err = conn.Plans().UpdateAll(bson.M{"default": true, "_id": bson.M{"$ne": plan.Name}}, bson.M{"$unset": bson.M{"default": false}})
+		if err != nil {
+			return err
+		}
+	}
+	err = conn.Plans().UpdateId(plan.Name, plan)
+	if err == mgo.ErrNotFound {
+		return ErrPlanNotFound
+	}
+	return err
+}
+
 func (plan *Plan) getRouter() (string, error) {
 	if plan.Router != "" {
 		return plan.Router, nil
@@ -91,7 +125,7 @@ func PlansList() ([]Plan, error) {
 	return plans, err
 }
 
-func findPlanByName(name string) (*Plan, error) {
+func GetPlanByName(name string) (*Plan, error) {
 	conn, err := db.Conn()
 	if err != nil {
 		return nil, err
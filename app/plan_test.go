@@ -123,6 +123,47 @@ func (s *S) TestPlanAddAsDefault(c *check.C) {
 
 }
 
+func (s *S) TestPlanUpdate(c *check.C) {
+	p := Plan{Name: "plan1", Memory: 4194304, Swap: 1024, CpuShare: 100}
+	err := s.conn.Plans().Insert(p)
+	c.Assert(err, check.IsNil)
+	defer s.conn.Plans().RemoveId(p.Name)
+	p.Memory = 8388608
+	p.CpuShare = 200
+	p.Router = "fake"
+	err = p.Update()
+	c.Assert(err, check.IsNil)
+	var plan Plan
+	err = s.conn.Plans().FindId(p.Name).One(&plan)
+	c.Assert(err, check.IsNil)
+	c.Assert(plan, check.DeepEquals, p)
+}
+
+func (s *S) TestPlanUpdateAsDefault(c *check.C) {
+	p := Plan{Name: "plan1", Memory: 4194304, Swap: 1024, CpuShare: 100}
+	err := s.conn.Plans().Insert(p)
+	c.Assert(err, check.IsNil)
+	defer s.conn.Plans().RemoveId(p.Name)
+	p.Default = true
+	err = p.Update()
+	c.Assert(err, check.IsNil)
+	plan, err := DefaultPlan()
+	c.Assert(err, check.IsNil)
+	c.Assert(*plan, check.DeepEquals, p)
+}
+
+func (s *S) TestPlanUpdateNotFound(c *check.C) {
+	p := Plan{Name: "plan1", Memory: 4194304, Swap: 1024, CpuShare: 100}
+	err := p.Update()
+	c.Assert(err, check.Equals, ErrPlanNotFound)
+}
+
+func (s *S) TestPlanUpdateInvalid(c *check.C) {
+	p := Plan{Name: "plan1", Memory: 4194304, Swap: 1024, CpuShare: 1}
+	err := p.Update()
+	c.Assert(err, check.Equals, ErrLimitOfCpuShare)
+}
+
 type planList []Plan
 
 func (l planList) Len() int           { return len(l) }
@@ -199,7 +240,7 @@ func (s *S) TestDefaultPlanWithoutDefault(c *check.C) {
 	c.Assert(*p, check.DeepEquals, expected)
 }
 
-func (s *S) TestFindPlanByName(c *check.C) {
+func (s *S) TestGetPlanByName(c *check.C) {
 	p := Plan{
 		Name:     "plan1",
 		Memory:   9223372036854775807,
@@ -209,7 +250,7 @@ func (s *S) TestFindPlanByName(c *check.C) {
 	err := p.Save()
 	c.Assert(err, check.IsNil)
 	defer s.conn.Plans().RemoveId(p.Name)
-	dbPlan, err := findPlanByName(p.Name)
+	dbPlan, err := GetPlanByName(p.Name)
 	c.Assert(err, check.IsNil)
 	c.Assert(*dbPlan, check.DeepEquals, p)
 }
@@ -80,6 +80,35 @@ func releaseUnits(app *App, quantity int) error {
 	return err
 }
 
+// checkUnitsQuota checks that the units of the app fit in its quota, using
+// the greatest of the units reserved in the quota and the units reported by
+// the provisioner. Changing the plan recreates every unit of the app, which
+// must not leave the app running more units than its quota allows.
+func (app *App) checkUnitsQuota() error {
+	dbApp, err := GetByName(app.Name)
+	if err != nil {
+		return err
+	}
+	if dbApp.Quota.Unlimited() {
+		return nil
+	}
+	units, err := dbApp.Units()
+	if err != nil {
+		return err
+	}
+	inUse := dbApp.Quota.InUse
+	if len(units) > inUse {
+		inUse = len(units)
+	}
+	if inUse > dbApp.Quota.Limit {
+		return &quota.QuotaExceededError{
+			Requested: uint(inUse),
+			Available: uint(dbApp.Quota.Limit),
+		}
+	}
+	return nil
+}
+
 func checkAppUsage(name string, quantity int) (*App, error) {
 	app, err := GetByName(name)
 	if err != nil {
@@ -0,0 +1,188 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/api/shutdown"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/router"
+	"github.com/tsuru/tsuru/router/rebuild"
+)
+
+const routesCheckEventKind = "routes-check"
+
+// RouterDrift lists the apps of a router whose routes differ from the
+// expected ones.
+type RouterDrift struct {
+	Router string
+	Apps   []rebuild.RoutesDrift
+	Errors map[string]string
+}
+
+// RoutesReport is the result of checking the routes of every app against
+// their routers.
+type RoutesReport struct {
+	Routers []RouterDrift
+	// OrphanBackends are the backends kept by routers for apps that no
+	// longer exist.
+	OrphanBackends []string
+	// Enqueued are the apps with rebuild tasks enqueued to fix their routes.
+	Enqueued []string
+}
+
+// Empty returns whether no inconsistency was found.
+func (r *RoutesReport) Empty() bool {
+	return len(r.Routers) == 0 && len(r.OrphanBackends) == 0
+}
+
+// CheckRoutes walks every app comparing its routes and cnames with the ones
+// in each one of its routers. When fix is true, a routes rebuild task is
+// enqueued for each app with differences.
+func CheckRoutes(fix bool) (*RoutesReport, error) {
+	apps, err := List(nil)
+	if err != nil {
+		return nil, err
+	}
+	var report RoutesReport
+	byRouter := map[string]*RouterDrift{}
+	appNames := make(map[string]bool, len(apps))
+	for i := range apps {
+		a := &apps[i]
+		appNames[a.Name] = true
+		routers, err := a.GetRouters()
+		if err != nil {
+			return nil, err
+		}
+		var hasDrift bool
+		for _, appRouter := range routers {
+			drift, checkErr := rebuild.CheckRoutes(a, appRouter.Name)
+			if checkErr == nil && drift.Empty() {
+				continue
+			}
+			hasDrift = true
+			routerDrift := byRouter[appRouter.Name]
+			if routerDrift == nil {
+				routerDrift = &RouterDrift{Router: appRouter.Name}
+				byRouter[appRouter.Name] = routerDrift
+			}
+			if checkErr != nil {
+				if routerDrift.Errors == nil {
+					routerDrift.Errors = map[string]string{}
+				}
+				routerDrift.Errors[a.Name] = checkErr.Error()
+				continue
+			}
+			routerDrift.Apps = append(routerDrift.Apps, *drift)
+		}
+		if fix && hasDrift {
+			err = rebuild.EnqueueRoutesRebuild(a.Name)
+			if err != nil {
+				return nil, errors.Wrapf(err, "unable to enqueue routes rebuild for %q", a.Name)
+			}
+			report.Enqueued = append(report.Enqueued, a.Name)
+		}
+	}
+	routerNames := make([]string, 0, len(byRouter))
+	for name := range byRouter {
+		routerNames = append(routerNames, name)
+	}
+	sort.Strings(routerNames)
+	for _, name := range routerNames {
+		report.Routers = append(report.Routers, *byRouter[name])
+	}
+	stored, err := router.StoredAppNames()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range stored {
+		if !appNames[name] {
+			report.OrphanBackends = append(report.OrphanBackends, name)
+		}
+	}
+	return &report, nil
+}
+
+type routesChecker struct {
+	runInterval time.Duration
+	autoFix     bool
+	done        chan bool
+}
+
+// InitializeRoutesCheck starts the background worker responsible for
+// periodically checking the routes of every app, if enabled in the config
+// file. Each run is recorded as an event holding the report.
+func InitializeRoutesCheck() {
+	enabled, _ := config.GetBool("routes-check:enabled")
+	if !enabled {
+		return
+	}
+	runInterval, _ := config.GetInt("routes-check:run-interval")
+	if runInterval <= 0 {
+		runInterval = 3600
+	}
+	autoFix, _ := config.GetBool("routes-check:auto-fix")
+	checker := &routesChecker{
+		runInterval: time.Duration(runInterval) * time.Second,
+		autoFix:     autoFix,
+		done:        make(chan bool),
+	}
+	shutdown.Register(checker)
+	go checker.Run()
+}
+
+func (c *routesChecker) Run() {
+	for {
+		err := c.runOnce()
+		if err != nil {
+			log.Errorf("[routes check] %s", err)
+		}
+		select {
+		case <-c.done:
+			return
+		case <-time.After(c.runInterval):
+		}
+	}
+}
+
+func (c *routesChecker) Shutdown() {
+	c.done <- true
+}
+
+func (c *routesChecker) String() string {
+	return "routes consistency checker"
+}
+
+func (c *routesChecker) runOnce() (retErr error) {
+	defer func() {
+		if r := recover(); r != nil {
+			retErr = errors.Errorf("recovered panic, we can never stop! panic: %v", r)
+		}
+	}()
+	evt, err := event.NewInternal(&event.Opts{
+		Target:       event.Target{Type: event.TargetTypeRouter},
+		InternalKind: routesCheckEventKind,
+		CustomData:   map[string]bool{"fix": c.autoFix},
+		Allowed:      event.Allowed(permission.PermRouterReadDrift),
+	})
+	if err != nil {
+		return err
+	}
+	report, err := CheckRoutes(c.autoFix)
+	if err != nil {
+		evt.Done(err)
+		return err
+	}
+	if !report.Empty() {
+		log.Errorf("[routes check] inconsistencies found in %d routers, %d orphan backends", len(report.Routers), len(report.OrphanBackends))
+	}
+	return evt.DoneCustomData(nil, report)
+}
@@ -0,0 +1,83 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/queue"
+	"github.com/tsuru/tsuru/router"
+	"github.com/tsuru/tsuru/router/rebuild"
+	"github.com/tsuru/tsuru/router/routertest"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestCheckRoutes(c *check.C) {
+	a1 := App{Name: "app1", TeamOwner: s.team.Name}
+	err := CreateApp(&a1, s.user)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a1, 2, "web", nil)
+	a2 := App{Name: "app2", TeamOwner: s.team.Name}
+	err = CreateApp(&a2, s.user)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a2, 1, "web", nil)
+	err = routertest.FakeRouter.AddRoute(a1.Name, &url.URL{Scheme: "http", Host: "10.1.1.1:1234"})
+	c.Assert(err, check.IsNil)
+	err = router.Store("ghost", "ghost", "fake")
+	c.Assert(err, check.IsNil)
+	report, err := CheckRoutes(false)
+	c.Assert(err, check.IsNil)
+	c.Assert(report, check.DeepEquals, &RoutesReport{
+		Routers: []RouterDrift{
+			{Router: "fake", Apps: []rebuild.RoutesDrift{
+				{App: "app1", StaleRoutes: []string{"http://10.1.1.1:1234"}},
+			}},
+		},
+		OrphanBackends: []string{"ghost"},
+	})
+	c.Assert(report.Empty(), check.Equals, false)
+	c.Assert(routertest.FakeRouter.HasRoute(a1.Name, "http://10.1.1.1:1234"), check.Equals, true)
+}
+
+func (s *S) TestCheckRoutesFix(c *check.C) {
+	a := App{Name: "app1", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 1, "web", nil)
+	err = routertest.FakeRouter.AddRoute(a.Name, &url.URL{Scheme: "http", Host: "10.1.1.1:1234"})
+	c.Assert(err, check.IsNil)
+	report, err := CheckRoutes(true)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Enqueued, check.DeepEquals, []string{"app1"})
+	err = queue.TestingWaitQueueTasks(1, 10*time.Second)
+	c.Assert(err, check.IsNil)
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, "http://10.1.1.1:1234"), check.Equals, false)
+	report, err = CheckRoutes(false)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Empty(), check.Equals, true)
+}
+
+func (s *S) TestRoutesCheckerRunOnce(c *check.C) {
+	a := App{Name: "app1", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = routertest.FakeRouter.AddRoute(a.Name, &url.URL{Scheme: "http", Host: "10.1.1.1:1234"})
+	c.Assert(err, check.IsNil)
+	checker := &routesChecker{}
+	err = checker.runOnce()
+	c.Assert(err, check.IsNil)
+	evts, err := event.List(&event.Filter{KindName: routesCheckEventKind})
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 1)
+	c.Assert(evts[0].Target, check.DeepEquals, event.Target{Type: event.TargetTypeRouter})
+	var report RoutesReport
+	err = evts[0].EndData(&report)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Routers, check.HasLen, 1)
+	c.Assert(report.Routers[0].Apps[0].StaleRoutes, check.DeepEquals, []string{"http://10.1.1.1:1234"})
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, "http://10.1.1.1:1234"), check.Equals, true)
+}
@@ -0,0 +1,178 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/tsuru/app/image"
+	"github.com/tsuru/tsuru/event"
+	tsuruNet "github.com/tsuru/tsuru/net"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/router/rebuild"
+)
+
+const deployVerificationEventKind = "deploy-verification"
+
+var (
+	verificationDefaultInterval  = 5 * time.Second
+	verificationDefaultErrorRate = 10.0
+	verificationDefaultMinProbes = 10
+)
+
+// previousValidImage returns the image deployed before imageID, or an empty
+// string if there's no valid image to rollback to.
+func previousValidImage(appName, imageID string) (string, error) {
+	imgs, err := image.ListValidAppImages(appName)
+	if err != nil {
+		return "", err
+	}
+	for i := len(imgs) - 1; i > 0; i-- {
+		if imgs[i] == imageID {
+			return imgs[i-1], nil
+		}
+	}
+	return "", nil
+}
+
+func probeAddress(addr url.URL, hc provision.TsuruYamlHealthcheck, path string) error {
+	method := hc.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	addr.Path = path
+	req, err := http.NewRequest(method, addr.String(), nil)
+	if err != nil {
+		return err
+	}
+	rsp, err := tsuruNet.Dial5Full60ClientNoKeepAlive.Do(req)
+	if err != nil {
+		return err
+	}
+	rsp.Body.Close()
+	if hc.Status != 0 {
+		if rsp.StatusCode != hc.Status {
+			return errors.Errorf("wrong status code, expected %d, got: %d", hc.Status, rsp.StatusCode)
+		}
+		return nil
+	}
+	if rsp.StatusCode >= http.StatusBadRequest {
+		return errors.Errorf("unexpected status code: %d", rsp.StatusCode)
+	}
+	return nil
+}
+
+// verifyDeploy probes the routable addresses of the app during the
+// verification window configured in the tsuru.yaml of the deployed image. If
+// the error rate crosses the configured threshold the app is rolled back to
+// the previous valid image. The outcome is recorded in a child event of the
+// deploy event.
+func verifyDeploy(opts *DeployOptions, imageID string) (err error) {
+	yamlData, err := image.GetImageTsuruYamlData(imageID)
+	if err != nil {
+		return err
+	}
+	hc := yamlData.Healthcheck
+	verification := hc.Verification
+	if verification.Duration <= 0 {
+		return nil
+	}
+	previousImage, err := previousValidImage(opts.App.Name, imageID)
+	if err != nil {
+		return err
+	}
+	if previousImage == "" {
+		fmt.Fprintln(opts.Event, "\n---- Skipping deploy verification, there is no previous image to rollback to ----")
+		return nil
+	}
+	evt, err := event.NewInternal(&event.Opts{
+		Target:       event.Target{Type: event.TargetTypeApp, Value: opts.App.Name},
+		InternalKind: deployVerificationEventKind,
+		Parent:       opts.Event,
+		CustomData:   verification,
+		Allowed: event.Allowed(permission.PermAppReadEvents, append(permission.Contexts(permission.CtxTeam, opts.App.Teams),
+			permission.Context(permission.CtxApp, opts.App.Name),
+			permission.Context(permission.CtxPool, opts.App.Pool),
+		)...),
+	})
+	if err != nil {
+		return err
+	}
+	evt.SetLogWriter(opts.Event)
+	endData := map[string]string{"image": imageID, "result": "passed"}
+	defer func() { evt.DoneCustomData(err, endData) }()
+	path := verification.Path
+	if path == "" {
+		path = hc.Path
+	}
+	interval := time.Duration(verification.Interval) * time.Second
+	if interval <= 0 {
+		interval = verificationDefaultInterval
+	}
+	errorRateThreshold := verification.ErrorRate
+	if errorRateThreshold <= 0 {
+		errorRateThreshold = verificationDefaultErrorRate
+	}
+	minProbes := verification.MinProbes
+	if minProbes <= 0 {
+		minProbes = verificationDefaultMinProbes
+	}
+	duration := time.Duration(verification.Duration) * time.Second
+	fmt.Fprintf(evt, "\n---- Verifying deploy during %s (path: %q, error rate threshold: %.2f%%, minimum probes: %d) ----\n", duration, path, errorRateThreshold, minProbes)
+	var failed, total int
+	var errorRate float64
+	deadline := time.Now().Add(duration)
+	for {
+		addrs, errAddrs := opts.App.RoutableAddresses()
+		if errAddrs != nil {
+			fmt.Fprintf(evt, " ---> Unable to get app addresses: %s\n", errAddrs)
+		}
+		for _, addr := range addrs {
+			total++
+			if errProbe := probeAddress(addr, hc, path); errProbe != nil {
+				failed++
+				fmt.Fprintf(evt, " ---> Probe to %s failed: %s\n", addr.Host, errProbe)
+			}
+		}
+		if total > 0 {
+			errorRate = float64(failed) * 100 / float64(total)
+		}
+		if total >= minProbes && errorRate > errorRateThreshold {
+			break
+		}
+		if time.Now().After(deadline) {
+			if total < minProbes {
+				fmt.Fprintf(evt, " ---> Deploy not verified, only %d of %d required probes were made, %d failed\n", total, minProbes, failed)
+				return nil
+			}
+			fmt.Fprintf(evt, " ---> Deploy verified, %d of %d probes failed\n", failed, total)
+			return nil
+		}
+		time.Sleep(interval)
+	}
+	fmt.Fprintf(evt, "\n---- Error rate %.2f%% above threshold, rolling back to image %q ----\n", errorRate, previousImage)
+	endData["result"] = "rolled-back"
+	prov, err := opts.App.getProvisioner()
+	if err != nil {
+		return err
+	}
+	deployer, ok := prov.(provision.RollbackableDeployer)
+	if !ok {
+		return provision.ProvisionerNotSupported{Prov: prov, Action: "rollback"}
+	}
+	_, err = deployer.Rollback(opts.App, previousImage, evt)
+	if err != nil {
+		endData["result"] = "failed"
+		return errors.Wrapf(err, "unable to rollback to image %q after failed deploy verification", previousImage)
+	}
+	rebuild.RoutesRebuildOrEnqueue(opts.App.Name)
+	endData["image"] = previousImage
+	return errors.Errorf("deploy verification failed with %.2f%% error rate, app rolled back to image %q", errorRate, previousImage)
+}
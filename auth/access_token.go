@@ -0,0 +1,189 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/tsuru/db"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var (
+	ErrInvalidAccessTokenName   = &tsuruErrors.ValidationError{Message: "invalid access token name"}
+	ErrAccessTokenAlreadyExists = errors.New("access token already exists")
+	ErrAccessTokenNotFound      = errors.New("access token not found")
+
+	accessTokenNameRegexp = regexp.MustCompile(`^[a-zA-Z][-_.\w]*$`)
+)
+
+// AccessToken is a named token created by an user, usually to be used by
+// scripts and CI pipelines. It may expire and may be limited to a subset of
+// the permissions of the user.
+type AccessToken struct {
+	Name        string    `json:"name"`
+	Token       string    `json:"token,omitempty"`
+	UserEmail   string    `json:"email"`
+	Description string    `json:"description"`
+	Creation    time.Time `json:"creation"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	LastAccess  time.Time `json:"last_access"`
+	// PermissionNames limits the token to these permissions, in the contexts
+	// they are granted to the user. An empty list means all the permissions
+	// of the user.
+	PermissionNames []string `json:"permissions" bson:"permissions"`
+}
+
+func (t *AccessToken) GetValue() string {
+	return t.Token
+}
+
+func (t *AccessToken) User() (*User, error) {
+	return GetUserByEmail(t.UserEmail)
+}
+
+func (t *AccessToken) IsAppToken() bool {
+	return false
+}
+
+func (t *AccessToken) GetUserName() string {
+	return t.UserEmail
+}
+
+func (t *AccessToken) GetAppName() string {
+	return ""
+}
+
+// Expired returns whether the token has an expiration date which is already
+// past.
+func (t *AccessToken) Expired() bool {
+	return !t.ExpiresAt.IsZero() && !time.Now().Before(t.ExpiresAt)
+}
+
+// Permissions returns the intersection between the permissions of the user
+// and the permissions of the token.
+func (t *AccessToken) Permissions() ([]permission.Permission, error) {
+	userPerms, err := BaseTokenPermission(t)
+	if err != nil || len(t.PermissionNames) == 0 {
+		return userPerms, err
+	}
+	var perms []permission.Permission
+	for _, name := range t.PermissionNames {
+		scheme, err := permission.SafeGet(name)
+		if err != nil {
+			// The permission may no longer exist in tsuru, it's just
+			// ignored.
+			continue
+		}
+		for _, userPerm := range userPerms {
+			if userPerm.Scheme.IsParent(scheme) {
+				perms = append(perms, permission.Permission{Scheme: scheme, Context: userPerm.Context})
+			} else if scheme.IsParent(userPerm.Scheme) {
+				perms = append(perms, userPerm)
+			}
+		}
+	}
+	return perms, nil
+}
+
+// CreateAccessToken generates the value of the given token and stores it.
+// Name and UserEmail must be filled.
+func CreateAccessToken(t *AccessToken) error {
+	if !accessTokenNameRegexp.MatchString(t.Name) {
+		return ErrInvalidAccessTokenName
+	}
+	for _, name := range t.PermissionNames {
+		if _, err := permission.SafeGet(name); err != nil {
+			return &tsuruErrors.ValidationError{Message: fmt.Sprintf("permission named %q not found", name)}
+		}
+	}
+	value, err := newTokenValue(t.UserEmail)
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	t.Token = value
+	t.Creation = time.Now().UTC()
+	t.LastAccess = time.Time{}
+	err = conn.AccessTokens().Insert(t)
+	if mgo.IsDup(err) {
+		return ErrAccessTokenAlreadyExists
+	}
+	return err
+}
+
+// ListAccessTokens returns the access tokens of the given user, without
+// their values.
+func ListAccessTokens(userEmail string) ([]AccessToken, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var tokens []AccessToken
+	err = conn.AccessTokens().Find(bson.M{"useremail": userEmail}).Select(bson.M{"token": 0}).Sort("name").All(&tokens)
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// RevokeAccessToken removes the access token with the given name from the
+// user.
+func RevokeAccessToken(userEmail, name string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.AccessTokens().Remove(bson.M{"useremail": userEmail, "name": name})
+	if err == mgo.ErrNotFound {
+		return ErrAccessTokenNotFound
+	}
+	return err
+}
+
+func getAccessToken(header string) (*AccessToken, error) {
+	token, err := ParseToken(header)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var t AccessToken
+	err = conn.AccessTokens().Find(bson.M{"token": token}).One(&t)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	if t.Expired() {
+		return nil, ErrInvalidToken
+	}
+	t.LastAccess = time.Now().UTC()
+	err = conn.AccessTokens().Update(bson.M{"token": token}, bson.M{"$set": bson.M{"lastaccess": t.LastAccess}})
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func AccessTokenAuth(token string) (*AccessToken, error) {
+	return getAccessToken(token)
+}
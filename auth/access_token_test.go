@@ -0,0 +1,164 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"time"
+
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) TestCreateAccessToken(c *check.C) {
+	expires := time.Now().Add(time.Hour).UTC().Truncate(time.Millisecond)
+	token := AccessToken{
+		Name:            "ci",
+		UserEmail:       s.user.Email,
+		Description:     "deploys from ci",
+		ExpiresAt:       expires,
+		PermissionNames: []string{"app.deploy"},
+	}
+	err := CreateAccessToken(&token)
+	c.Assert(err, check.IsNil)
+	c.Assert(token.Token, check.Not(check.Equals), "")
+	c.Assert(token.Creation.IsZero(), check.Equals, false)
+	var dbToken AccessToken
+	err = s.conn.AccessTokens().Find(bson.M{"name": "ci"}).One(&dbToken)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbToken.Token, check.Equals, token.Token)
+	c.Assert(dbToken.UserEmail, check.Equals, s.user.Email)
+	c.Assert(dbToken.Description, check.Equals, "deploys from ci")
+	c.Assert(dbToken.ExpiresAt.Equal(expires), check.Equals, true)
+	c.Assert(dbToken.PermissionNames, check.DeepEquals, []string{"app.deploy"})
+}
+
+func (s *S) TestCreateAccessTokenDuplicated(c *check.C) {
+	err := CreateAccessToken(&AccessToken{Name: "ci", UserEmail: s.user.Email})
+	c.Assert(err, check.IsNil)
+	err = CreateAccessToken(&AccessToken{Name: "ci", UserEmail: s.user.Email})
+	c.Assert(err, check.Equals, ErrAccessTokenAlreadyExists)
+	err = CreateAccessToken(&AccessToken{Name: "ci", UserEmail: "other@tsuru.io"})
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestCreateAccessTokenInvalidName(c *check.C) {
+	for _, name := range []string{"", "1ci", "ci token", "ci/token"} {
+		err := CreateAccessToken(&AccessToken{Name: name, UserEmail: s.user.Email})
+		c.Assert(err, check.Equals, ErrInvalidAccessTokenName, check.Commentf(name))
+	}
+}
+
+func (s *S) TestCreateAccessTokenInvalidPermission(c *check.C) {
+	err := CreateAccessToken(&AccessToken{Name: "ci", UserEmail: s.user.Email, PermissionNames: []string{"app.deploy", "app.fly"}})
+	c.Assert(err, check.FitsTypeOf, &errors.ValidationError{})
+	c.Assert(err, check.ErrorMatches, `permission named "app.fly" not found`)
+}
+
+func (s *S) TestListAccessTokens(c *check.C) {
+	err := CreateAccessToken(&AccessToken{Name: "dev", UserEmail: s.user.Email})
+	c.Assert(err, check.IsNil)
+	err = CreateAccessToken(&AccessToken{Name: "ci", UserEmail: s.user.Email, PermissionNames: []string{"app.deploy"}})
+	c.Assert(err, check.IsNil)
+	err = CreateAccessToken(&AccessToken{Name: "other", UserEmail: "other@tsuru.io"})
+	c.Assert(err, check.IsNil)
+	tokens, err := ListAccessTokens(s.user.Email)
+	c.Assert(err, check.IsNil)
+	c.Assert(tokens, check.HasLen, 2)
+	c.Assert(tokens[0].Name, check.Equals, "ci")
+	c.Assert(tokens[0].Token, check.Equals, "")
+	c.Assert(tokens[0].PermissionNames, check.DeepEquals, []string{"app.deploy"})
+	c.Assert(tokens[1].Name, check.Equals, "dev")
+	c.Assert(tokens[1].Token, check.Equals, "")
+}
+
+func (s *S) TestRevokeAccessToken(c *check.C) {
+	token := AccessToken{Name: "ci", UserEmail: s.user.Email}
+	err := CreateAccessToken(&token)
+	c.Assert(err, check.IsNil)
+	err = RevokeAccessToken(s.user.Email, "ci")
+	c.Assert(err, check.IsNil)
+	_, err = getAccessToken("bearer " + token.Token)
+	c.Assert(err, check.Equals, ErrInvalidToken)
+	err = RevokeAccessToken(s.user.Email, "ci")
+	c.Assert(err, check.Equals, ErrAccessTokenNotFound)
+}
+
+func (s *S) TestGetAccessToken(c *check.C) {
+	token := AccessToken{Name: "ci", UserEmail: s.user.Email}
+	err := CreateAccessToken(&token)
+	c.Assert(err, check.IsNil)
+	t, err := getAccessToken("bearer " + token.Token)
+	c.Assert(err, check.IsNil)
+	c.Assert(t.Name, check.Equals, "ci")
+	c.Assert(t.GetValue(), check.Equals, token.Token)
+	c.Assert(t.GetUserName(), check.Equals, s.user.Email)
+	c.Assert(t.IsAppToken(), check.Equals, false)
+	c.Assert(t.LastAccess.IsZero(), check.Equals, false)
+	var dbToken AccessToken
+	err = s.conn.AccessTokens().Find(bson.M{"name": "ci"}).One(&dbToken)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbToken.LastAccess.IsZero(), check.Equals, false)
+}
+
+func (s *S) TestGetAccessTokenExpired(c *check.C) {
+	token := AccessToken{Name: "ci", UserEmail: s.user.Email, ExpiresAt: time.Now().Add(-time.Minute)}
+	err := CreateAccessToken(&token)
+	c.Assert(err, check.IsNil)
+	t, err := getAccessToken("bearer " + token.Token)
+	c.Assert(t, check.IsNil)
+	c.Assert(err, check.Equals, ErrInvalidToken)
+}
+
+func (s *S) TestGetAccessTokenNotFound(c *check.C) {
+	t, err := getAccessToken("bearer invalid")
+	c.Assert(t, check.IsNil)
+	c.Assert(err, check.Equals, ErrInvalidToken)
+}
+
+func (s *S) TestAccessTokenPermissions(c *check.C) {
+	u := User{Email: "me@tsuru.com", Password: "123"}
+	err := u.Create()
+	c.Assert(err, check.IsNil)
+	r1, err := permission.NewRole("r1", "app", "")
+	c.Assert(err, check.IsNil)
+	err = r1.AddPermissions("app.update", "app.deploy")
+	c.Assert(err, check.IsNil)
+	err = u.AddRole("r1", "myapp")
+	c.Assert(err, check.IsNil)
+	token := AccessToken{UserEmail: u.Email}
+	perms, err := token.Permissions()
+	c.Assert(err, check.IsNil)
+	c.Assert(perms, check.DeepEquals, []permission.Permission{
+		{Scheme: permission.PermUser, Context: permission.Context(permission.CtxUser, u.Email)},
+		{Scheme: permission.PermAppDeploy, Context: permission.Context(permission.CtxApp, "myapp")},
+		{Scheme: permission.PermAppUpdate, Context: permission.Context(permission.CtxApp, "myapp")},
+	})
+	token.PermissionNames = []string{"app.update.env", "app", "team.create", "removed.permission"}
+	perms, err = token.Permissions()
+	c.Assert(err, check.IsNil)
+	c.Assert(perms, check.DeepEquals, []permission.Permission{
+		{Scheme: permission.PermAppUpdateEnv, Context: permission.Context(permission.CtxApp, "myapp")},
+		{Scheme: permission.PermAppDeploy, Context: permission.Context(permission.CtxApp, "myapp")},
+		{Scheme: permission.PermAppUpdate, Context: permission.Context(permission.CtxApp, "myapp")},
+	})
+	c.Assert(permission.Check(&token, permission.PermAppUpdateEnvSet, permission.Context(permission.CtxApp, "myapp")), check.Equals, true)
+	c.Assert(permission.Check(&token, permission.PermAppUpdateEnvSet, permission.Context(permission.CtxApp, "otherapp")), check.Equals, false)
+	c.Assert(permission.Check(&token, permission.PermUserUpdateToken, permission.Context(permission.CtxUser, u.Email)), check.Equals, false)
+}
+
+func (s *S) TestUserDeleteRemovesAccessTokens(c *check.C) {
+	u := User{Email: "me@tsuru.com", Password: "123"}
+	err := u.Create()
+	c.Assert(err, check.IsNil)
+	err = CreateAccessToken(&AccessToken{Name: "ci", UserEmail: u.Email})
+	c.Assert(err, check.IsNil)
+	err = u.Delete()
+	c.Assert(err, check.IsNil)
+	count, err := s.conn.AccessTokens().Find(bson.M{"useremail": u.Email}).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(count, check.Equals, 0)
+}
@@ -0,0 +1,114 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"github.com/pkg/errors"
+	"github.com/tsuru/tsuru/action"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/log"
+	"gopkg.in/mgo.v2"
+)
+
+// The rename team pipeline receives the current name of the team and a
+// pointer to the team, already holding the new name.
+
+func renameTeamParams(params []interface{}) (string, *Team, error) {
+	oldName, ok := params[0].(string)
+	if !ok {
+		return "", nil, errors.New("first parameter must be a string")
+	}
+	team, ok := params[1].(*Team)
+	if !ok {
+		return "", nil, errors.New("second parameter must be *Team")
+	}
+	return oldName, team, nil
+}
+
+var insertRenamedTeam = action.Action{
+	Name: "insert-renamed-team",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		_, team, err := renameTeamParams(ctx.Params)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := db.Conn()
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		err = conn.Teams().Insert(team)
+		if mgo.IsDup(err) {
+			return nil, ErrTeamAlreadyExists
+		}
+		return nil, err
+	},
+	Backward: func(ctx action.BWContext) {
+		team := ctx.Params[1].(*Team)
+		conn, err := db.Conn()
+		if err != nil {
+			log.Errorf("[rename-team] unable to connect to the database: %s", err)
+			return
+		}
+		defer conn.Close()
+		err = conn.Teams().RemoveId(team.Name)
+		if err != nil {
+			log.Errorf("[rename-team] unable to remove team %q: %s", team.Name, err)
+		}
+	},
+	MinParams: 2,
+}
+
+var renameTeamReferencesAction = action.Action{
+	Name: "rename-team-references",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		oldName, team, err := renameTeamParams(ctx.Params)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := db.Conn()
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		return nil, renameTeamReferences(conn, oldName, team.Name)
+	},
+	Backward: func(ctx action.BWContext) {
+		oldName := ctx.Params[0].(string)
+		team := ctx.Params[1].(*Team)
+		conn, err := db.Conn()
+		if err != nil {
+			log.Errorf("[rename-team] unable to connect to the database: %s", err)
+			return
+		}
+		defer conn.Close()
+		err = renameTeamReferences(conn, team.Name, oldName)
+		if err != nil {
+			log.Errorf("[rename-team] unable to restore references to team %q: %s", oldName, err)
+		}
+	},
+	MinParams: 2,
+}
+
+var removeOldTeam = action.Action{
+	Name: "remove-old-team",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		oldName, _, err := renameTeamParams(ctx.Params)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := db.Conn()
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		err = conn.Teams().RemoveId(oldName)
+		if err == mgo.ErrNotFound {
+			return nil, ErrTeamNotFound
+		}
+		return nil, err
+	},
+	MinParams: 2,
+}
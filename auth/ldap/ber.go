@@ -0,0 +1,182 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldap
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	classUniversal   = 0x00
+	classApplication = 0x40
+	classContext     = 0x80
+
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x10
+	tagSet         = 0x11
+
+	maxPacketSize = 16 * 1024 * 1024
+)
+
+// berPacket is an element encoded with the Basic Encoding Rules, the subset
+// of BER used by LDAP: definite lengths and tags lower than 31.
+type berPacket struct {
+	class       byte
+	constructed bool
+	tag         byte
+	value       []byte
+	children    []*berPacket
+}
+
+func newSequence(class, tag byte, children ...*berPacket) *berPacket {
+	return &berPacket{class: class, constructed: true, tag: tag, children: children}
+}
+
+func newString(class, tag byte, value string) *berPacket {
+	return &berPacket{class: class, tag: tag, value: []byte(value)}
+}
+
+func newInteger(class, tag byte, value int64) *berPacket {
+	return &berPacket{class: class, tag: tag, value: encodeInteger(value)}
+}
+
+func newBoolean(value bool) *berPacket {
+	p := &berPacket{class: classUniversal, tag: tagBoolean, value: []byte{0}}
+	if value {
+		p.value[0] = 0xff
+	}
+	return p
+}
+
+func (p *berPacket) is(class, tag byte) bool {
+	return p.class == class && p.tag == tag
+}
+
+func (p *berPacket) str() string {
+	return string(p.value)
+}
+
+func (p *berPacket) integer() int64 {
+	var n int64
+	for i, b := range p.value {
+		if i == 0 && b&0x80 != 0 {
+			n = -1
+		}
+		n = n<<8 | int64(b)
+	}
+	return n
+}
+
+func (p *berPacket) encode() []byte {
+	content := p.value
+	if p.constructed {
+		content = nil
+		for _, child := range p.children {
+			content = append(content, child.encode()...)
+		}
+	}
+	identifier := p.class | p.tag
+	if p.constructed {
+		identifier |= 0x20
+	}
+	data := append([]byte{identifier}, encodeLength(len(content))...)
+	return append(data, content...)
+}
+
+func encodeInteger(value int64) []byte {
+	data := []byte{byte(value)}
+	for value > 127 || value < -128 {
+		value >>= 8
+		data = append([]byte{byte(value)}, data...)
+	}
+	return data
+}
+
+func encodeLength(length int) []byte {
+	if length < 128 {
+		return []byte{byte(length)}
+	}
+	var data []byte
+	for ; length > 0; length >>= 8 {
+		data = append([]byte{byte(length)}, data...)
+	}
+	return append([]byte{0x80 | byte(len(data))}, data...)
+}
+
+// readPacket reads a whole BER element from r, decoding its children when
+// it's constructed.
+func readPacket(r *bufio.Reader) (*berPacket, error) {
+	identifier, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	length, err := readLength(r)
+	if err != nil {
+		return nil, err
+	}
+	content := make([]byte, length)
+	if _, err = io.ReadFull(r, content); err != nil {
+		return nil, err
+	}
+	return decodePacket(identifier, content)
+}
+
+func readLength(r *bufio.Reader) (int, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b&0x80 == 0 {
+		return int(b), nil
+	}
+	size := int(b & 0x7f)
+	if size == 0 || size > 4 {
+		return 0, errors.Errorf("unsupported BER length with %d bytes", size)
+	}
+	var length int
+	for i := 0; i < size; i++ {
+		if b, err = r.ReadByte(); err != nil {
+			return 0, err
+		}
+		length = length<<8 | int(b)
+	}
+	if length > maxPacketSize {
+		return 0, errors.Errorf("BER element too large: %d bytes", length)
+	}
+	return length, nil
+}
+
+func decodePacket(identifier byte, content []byte) (*berPacket, error) {
+	p := &berPacket{
+		class:       identifier & 0xc0,
+		constructed: identifier&0x20 != 0,
+		tag:         identifier & 0x1f,
+	}
+	if p.tag == 0x1f {
+		return nil, errors.New("unsupported BER high tag number")
+	}
+	if !p.constructed {
+		p.value = content
+		return p, nil
+	}
+	r := bufio.NewReader(bytes.NewReader(content))
+	for {
+		if _, err := r.Peek(1); err == io.EOF {
+			return p, nil
+		}
+		child, err := readPacket(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid BER element")
+		}
+		p.children = append(p.children, child)
+	}
+}
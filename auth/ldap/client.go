@@ -0,0 +1,223 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	opBindRequest      = 0
+	opBindResponse     = 1
+	opUnbindRequest    = 2
+	opSearchRequest    = 3
+	opSearchEntry      = 4
+	opSearchDone       = 5
+	opSearchReference  = 19
+	resultSuccess      = 0
+	resultInvalidCreds = 49
+	scopeWholeSubtree  = 2
+	neverDerefAliases  = 0
+	protocolVersion    = 3
+)
+
+// ldapError is an error result returned by the LDAP server.
+type ldapError struct {
+	code    int64
+	message string
+}
+
+func (e *ldapError) Error() string {
+	if e.message == "" {
+		return fmt.Sprintf("ldap result code %d", e.code)
+	}
+	return fmt.Sprintf("ldap result code %d: %s", e.code, e.message)
+}
+
+// entry is an entry returned by a search.
+type entry struct {
+	dn         string
+	attributes map[string][]string
+}
+
+func (e *entry) get(name string) string {
+	for attr, values := range e.attributes {
+		if strings.EqualFold(attr, name) && len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+func (e *entry) getAll(name string) []string {
+	for attr, values := range e.attributes {
+		if strings.EqualFold(attr, name) {
+			return values
+		}
+	}
+	return nil
+}
+
+// conn is a synchronous connection to a LDAPv3 server, supporting simple
+// binds and searches.
+type conn struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	timeout   time.Duration
+	messageID int64
+}
+
+func dial(addr string, useTLS bool, timeout time.Duration) (*conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	var c net.Conn
+	var err error
+	if useTLS {
+		host, _, _ := net.SplitHostPort(addr)
+		c, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: host})
+	} else {
+		c, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to connect to ldap server %q", addr)
+	}
+	return &conn{conn: c, reader: bufio.NewReader(c), timeout: timeout}, nil
+}
+
+func (c *conn) close() error {
+	c.send(&berPacket{class: classApplication, tag: opUnbindRequest})
+	return c.conn.Close()
+}
+
+func (c *conn) send(op *berPacket) (int64, error) {
+	c.messageID++
+	msg := newSequence(classUniversal, tagSequence,
+		newInteger(classUniversal, tagInteger, c.messageID),
+		op,
+	)
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	_, err := c.conn.Write(msg.encode())
+	return c.messageID, err
+}
+
+func (c *conn) receive(messageID int64) (*berPacket, error) {
+	for {
+		msg, err := readPacket(c.reader)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read ldap response")
+		}
+		if !msg.is(classUniversal, tagSequence) || len(msg.children) < 2 {
+			return nil, errors.New("invalid ldap response")
+		}
+		if msg.children[0].integer() == messageID {
+			return msg.children[1], nil
+		}
+	}
+}
+
+func checkResult(op *berPacket) error {
+	if len(op.children) < 3 {
+		return errors.New("invalid ldap result")
+	}
+	code := op.children[0].integer()
+	if code == resultSuccess {
+		return nil
+	}
+	return &ldapError{code: code, message: op.children[2].str()}
+}
+
+// bind authenticates the connection with the given dn and password.
+func (c *conn) bind(dn, password string) error {
+	op := newSequence(classApplication, opBindRequest,
+		newInteger(classUniversal, tagInteger, protocolVersion),
+		newString(classUniversal, tagOctetString, dn),
+		newString(classContext, 0, password),
+	)
+	id, err := c.send(op)
+	if err != nil {
+		return errors.Wrap(err, "unable to send ldap bind request")
+	}
+	resp, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if !resp.is(classApplication, opBindResponse) {
+		return errors.New("invalid ldap bind response")
+	}
+	return checkResult(resp)
+}
+
+// search searches the subtree of baseDN for entries matching filter,
+// returning the given attributes of each entry.
+func (c *conn) search(baseDN, filter string, attributes []string) ([]entry, error) {
+	compiled, err := compileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	attrs := newSequence(classUniversal, tagSequence)
+	for _, attr := range attributes {
+		attrs.children = append(attrs.children, newString(classUniversal, tagOctetString, attr))
+	}
+	op := newSequence(classApplication, opSearchRequest,
+		newString(classUniversal, tagOctetString, baseDN),
+		newInteger(classUniversal, tagEnumerated, scopeWholeSubtree),
+		newInteger(classUniversal, tagEnumerated, neverDerefAliases),
+		newInteger(classUniversal, tagInteger, 0),
+		newInteger(classUniversal, tagInteger, int64(c.timeout/time.Second)),
+		newBoolean(false),
+		compiled,
+		attrs,
+	)
+	id, err := c.send(op)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to send ldap search request")
+	}
+	var entries []entry
+	for {
+		resp, err := c.receive(id)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case resp.is(classApplication, opSearchEntry):
+			e, err := parseEntry(resp)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, e)
+		case resp.is(classApplication, opSearchReference):
+		case resp.is(classApplication, opSearchDone):
+			if err := checkResult(resp); err != nil {
+				return nil, err
+			}
+			return entries, nil
+		default:
+			return nil, errors.New("invalid ldap search response")
+		}
+	}
+}
+
+func parseEntry(p *berPacket) (entry, error) {
+	if len(p.children) < 2 {
+		return entry{}, errors.New("invalid ldap search entry")
+	}
+	e := entry{dn: p.children[0].str(), attributes: map[string][]string{}}
+	for _, attr := range p.children[1].children {
+		if len(attr.children) < 2 {
+			return entry{}, errors.New("invalid ldap search entry attribute")
+		}
+		name := attr.children[0].str()
+		for _, value := range attr.children[1].children {
+			e.attributes[name] = append(e.attributes[name], value.str())
+		}
+	}
+	return e, nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldap
+
+import (
+	"encoding/hex"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	filterAnd      = 0
+	filterOr       = 1
+	filterNot      = 2
+	filterEquality = 3
+	filterPresent  = 7
+)
+
+// escapeFilter escapes a value to be used in a search filter, as described
+// in RFC 4515.
+func escapeFilter(value string) string {
+	var buf []byte
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '\\', '*', '(', ')', 0:
+			buf = append(buf, '\\')
+			buf = append(buf, hex.EncodeToString([]byte{c})...)
+		default:
+			buf = append(buf, c)
+		}
+	}
+	return string(buf)
+}
+
+// compileFilter compiles the string representation of a search filter. Only
+// the and, or, not, equality and presence filters are supported.
+func compileFilter(filter string) (*berPacket, error) {
+	p, rest, err := parseFilter(filter)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid filter %q", filter)
+	}
+	if rest != "" {
+		return nil, errors.Errorf("invalid filter %q: unexpected %q", filter, rest)
+	}
+	return p, nil
+}
+
+func parseFilter(filter string) (*berPacket, string, error) {
+	if !strings.HasPrefix(filter, "(") {
+		return nil, "", errors.New("missing opening parenthesis")
+	}
+	filter = filter[1:]
+	if filter == "" {
+		return nil, "", errors.New("missing closing parenthesis")
+	}
+	switch filter[0] {
+	case '&', '|':
+		tag := byte(filterAnd)
+		if filter[0] == '|' {
+			tag = filterOr
+		}
+		p := newSequence(classContext, tag)
+		rest := filter[1:]
+		for strings.HasPrefix(rest, "(") {
+			var child *berPacket
+			var err error
+			child, rest, err = parseFilter(rest)
+			if err != nil {
+				return nil, "", err
+			}
+			p.children = append(p.children, child)
+		}
+		if len(p.children) == 0 {
+			return nil, "", errors.New("empty filter list")
+		}
+		return closeFilter(p, rest)
+	case '!':
+		child, rest, err := parseFilter(filter[1:])
+		if err != nil {
+			return nil, "", err
+		}
+		return closeFilter(newSequence(classContext, filterNot, child), rest)
+	}
+	end := strings.Index(filter, ")")
+	if end == -1 {
+		return nil, "", errors.New("missing closing parenthesis")
+	}
+	item, rest := filter[:end], filter[end:]
+	parts := strings.SplitN(item, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return nil, "", errors.Errorf("invalid item %q", item)
+	}
+	attr, value := parts[0], parts[1]
+	if strings.ContainsAny(attr, "~<>:") {
+		return nil, "", errors.Errorf("unsupported item %q", item)
+	}
+	if value == "*" {
+		return closeFilter(newString(classContext, filterPresent, attr), rest)
+	}
+	if strings.Contains(value, "*") {
+		return nil, "", errors.Errorf("unsupported substring item %q", item)
+	}
+	value, err := unescapeFilter(value)
+	if err != nil {
+		return nil, "", err
+	}
+	p := newSequence(classContext, filterEquality,
+		newString(classUniversal, tagOctetString, attr),
+		newString(classUniversal, tagOctetString, value),
+	)
+	return closeFilter(p, rest)
+}
+
+func closeFilter(p *berPacket, rest string) (*berPacket, string, error) {
+	if !strings.HasPrefix(rest, ")") {
+		return nil, "", errors.New("missing closing parenthesis")
+	}
+	return p, rest[1:], nil
+}
+
+func unescapeFilter(value string) (string, error) {
+	var buf []byte
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			buf = append(buf, value[i])
+			continue
+		}
+		if i+3 > len(value) {
+			return "", errors.Errorf("invalid escape in %q", value)
+		}
+		b, err := hex.DecodeString(value[i+1 : i+3])
+		if err != nil {
+			return "", errors.Errorf("invalid escape in %q", value)
+		}
+		buf = append(buf, b...)
+		i += 2
+	}
+	return string(buf), nil
+}
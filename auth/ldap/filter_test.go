@@ -0,0 +1,82 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldap
+
+import (
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestEscapeFilter(c *check.C) {
+	c.Assert(escapeFilter("alice"), check.Equals, "alice")
+	c.Assert(escapeFilter(`a*b(c)d\e`), check.Equals, `a\2ab\28c\29d\5ce`)
+	c.Assert(escapeFilter("a\x00b"), check.Equals, `a\00b`)
+}
+
+func (s *S) TestCompileFilter(c *check.C) {
+	entry := fakeEntry{attributes: map[string][]string{
+		"uid":         {"alice"},
+		"objectClass": {"person", "inetOrgPerson"},
+		"cn":          {"a*b"},
+	}}
+	var tests = []struct {
+		filter  string
+		matches bool
+	}{
+		{"(uid=alice)", true},
+		{"(UID=Alice)", true},
+		{"(uid=bob)", false},
+		{"(mail=*)", false},
+		{"(uid=*)", true},
+		{"(cn=a\\2ab)", true},
+		{"(&(objectClass=person)(uid=alice))", true},
+		{"(&(objectClass=person)(uid=bob))", false},
+		{"(|(uid=bob)(uid=alice))", true},
+		{"(!(uid=alice))", false},
+		{"(&(objectClass=inetOrgPerson)(!(uid=bob)))", true},
+	}
+	for _, t := range tests {
+		compiled, err := compileFilter(t.filter)
+		c.Assert(err, check.IsNil, check.Commentf(t.filter))
+		c.Assert(entry.matches(compiled), check.Equals, t.matches, check.Commentf(t.filter))
+	}
+}
+
+func (s *S) TestCompileFilterInvalid(c *check.C) {
+	var tests = []string{
+		"",
+		"uid=alice",
+		"(uid=alice",
+		"(uid=alice))",
+		"(&)",
+		"(uid)",
+		"(=alice)",
+		"(uid=al*ce)",
+		"(uid>=alice)",
+		"(uid=\\zz)",
+		"(uid=\\2)",
+	}
+	for _, filter := range tests {
+		_, err := compileFilter(filter)
+		c.Assert(err, check.NotNil, check.Commentf(filter))
+	}
+}
+
+func (s *S) TestBERRoundTrip(c *check.C) {
+	p := newSequence(classApplication, opSearchRequest,
+		newString(classUniversal, tagOctetString, string(make([]byte, 300))),
+		newInteger(classUniversal, tagInteger, -129),
+		newInteger(classUniversal, tagInteger, 65536),
+		newBoolean(true),
+	)
+	data := p.encode()
+	decoded, err := decodePacket(data[0], data[4:])
+	c.Assert(err, check.IsNil)
+	c.Assert(decoded.is(classApplication, opSearchRequest), check.Equals, true)
+	c.Assert(decoded.children, check.HasLen, 4)
+	c.Assert(decoded.children[0].value, check.HasLen, 300)
+	c.Assert(decoded.children[1].integer(), check.Equals, int64(-129))
+	c.Assert(decoded.children[2].integer(), check.Equals, int64(65536))
+	c.Assert(decoded.children[3].value, check.DeepEquals, []byte{0xff})
+}
@@ -0,0 +1,327 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package ldap implements an authentication scheme backed by a LDAP server,
+// such as OpenLDAP or Active Directory.
+//
+// Users are authenticated by binding to the server with their own
+// credentials, being created in tsuru on their first login. The LDAP groups
+// of a user may be mapped to roles, in the context of a tsuru team.
+package ldap
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/auth/native"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/validation"
+)
+
+const defaultTimeout = 10 * time.Second
+
+var (
+	ErrMissingUsernameError = &tsuruErrors.ValidationError{Message: "you must provide a username to login"}
+	ErrMissingPasswordError = &tsuruErrors.ValidationError{Message: "you must provide a password to login"}
+	ErrInvalidEmail         = &tsuruErrors.ValidationError{Message: "invalid email"}
+
+	errInvalidCredentials = auth.AuthenticationFailure{Message: "Authentication failed, wrong username or password."}
+)
+
+type LDAPScheme struct{}
+
+type groupMapping struct {
+	team  string
+	roles []string
+}
+
+type ldapConfig struct {
+	server         string
+	tls            bool
+	timeout        time.Duration
+	bindDN         string
+	bindPassword   string
+	userBaseDN     string
+	userFilter     string
+	emailAttribute string
+	groupBaseDN    string
+	groupFilter    string
+	groupAttribute string
+	groups         map[string]groupMapping
+}
+
+func init() {
+	auth.RegisterScheme("ldap", &LDAPScheme{})
+}
+
+func loadConfig() (*ldapConfig, error) {
+	var conf ldapConfig
+	var err error
+	conf.server, err = config.GetString("auth:ldap:server")
+	if err != nil {
+		return nil, err
+	}
+	conf.userBaseDN, err = config.GetString("auth:ldap:user-base-dn")
+	if err != nil {
+		return nil, err
+	}
+	conf.tls, _ = config.GetBool("auth:ldap:tls")
+	conf.timeout = defaultTimeout
+	if timeout, err := config.GetInt("auth:ldap:timeout"); err == nil && timeout > 0 {
+		conf.timeout = time.Duration(timeout) * time.Second
+	}
+	conf.bindDN, _ = config.GetString("auth:ldap:bind-dn")
+	conf.bindPassword, _ = config.GetString("auth:ldap:bind-password")
+	conf.userFilter = stringWithDefault("auth:ldap:user-filter", "(uid=%s)")
+	conf.emailAttribute = stringWithDefault("auth:ldap:email-attribute", "mail")
+	conf.groupBaseDN = stringWithDefault("auth:ldap:group-base-dn", conf.userBaseDN)
+	conf.groupFilter = stringWithDefault("auth:ldap:group-filter", "(member=%s)")
+	conf.groupAttribute = stringWithDefault("auth:ldap:group-name-attribute", "cn")
+	conf.groups = map[string]groupMapping{}
+	groups, _ := config.Get("auth:ldap:groups")
+	groupsMap, _ := groups.(map[interface{}]interface{})
+	for name := range groupsMap {
+		groupName := fmt.Sprint(name)
+		prefix := "auth:ldap:groups:" + groupName
+		team, _ := config.GetString(prefix + ":team")
+		roles, _ := config.GetList(prefix + ":roles")
+		conf.groups[groupName] = groupMapping{team: team, roles: roles}
+	}
+	return &conf, nil
+}
+
+func stringWithDefault(key, defaultValue string) string {
+	value, err := config.GetString(key)
+	if err != nil || value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+// authenticate looks for the user in the LDAP server and binds with its
+// credentials, returning the email of the user and the name of its groups.
+func (c *ldapConfig) authenticate(username, password string) (string, []string, error) {
+	conn, err := dial(c.server, c.tls, c.timeout)
+	if err != nil {
+		return "", nil, err
+	}
+	defer conn.close()
+	if c.bindDN != "" {
+		err = conn.bind(c.bindDN, c.bindPassword)
+		if err != nil {
+			return "", nil, errors.Wrap(err, "unable to bind to the ldap server")
+		}
+	}
+	filter := fmt.Sprintf(c.userFilter, escapeFilter(username))
+	entries, err := conn.search(c.userBaseDN, filter, []string{c.emailAttribute})
+	if err != nil {
+		return "", nil, errors.Wrap(err, "unable to search user in the ldap server")
+	}
+	if len(entries) == 0 {
+		return "", nil, errInvalidCredentials
+	}
+	if len(entries) > 1 {
+		return "", nil, errors.Errorf("multiple ldap entries found for user %q", username)
+	}
+	userEntry := entries[0]
+	var groups []string
+	if len(c.groups) > 0 {
+		filter = fmt.Sprintf(c.groupFilter, escapeFilter(userEntry.dn))
+		entries, err = conn.search(c.groupBaseDN, filter, []string{c.groupAttribute})
+		if err != nil {
+			return "", nil, errors.Wrap(err, "unable to search groups in the ldap server")
+		}
+		for _, groupEntry := range entries {
+			groups = append(groups, groupEntry.getAll(c.groupAttribute)...)
+		}
+	}
+	err = conn.bind(userEntry.dn, password)
+	if err != nil {
+		if ldapErr, ok := err.(*ldapError); ok && ldapErr.code == resultInvalidCreds {
+			return "", nil, errInvalidCredentials
+		}
+		return "", nil, err
+	}
+	email := userEntry.get(c.emailAttribute)
+	if !validation.ValidateEmail(email) {
+		return "", nil, errors.Errorf("invalid email %q in attribute %q of ldap entry %q", email, c.emailAttribute, userEntry.dn)
+	}
+	return email, groups, nil
+}
+
+type roleInstance struct {
+	name         string
+	contextValue string
+}
+
+// syncRoles adds to the user the roles mapped to its groups and removes the
+// roles mapped to groups it no longer belongs to, roles not mapped to any
+// group are left untouched. Errors are only logged, as a wrong mapping
+// shouldn't prevent users from logging in.
+func (c *ldapConfig) syncRoles(user *auth.User, groups []string) {
+	userGroups := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		userGroups[group] = true
+	}
+	groupNames := make([]string, 0, len(c.groups))
+	for group := range c.groups {
+		groupNames = append(groupNames, group)
+	}
+	sort.Strings(groupNames)
+	var wanted, mapped []roleInstance
+	wantedSet := map[roleInstance]bool{}
+	for _, group := range groupNames {
+		mapping := c.groups[group]
+		member := userGroups[group]
+		for _, roleName := range mapping.roles {
+			role, err := permission.FindRole(roleName)
+			if err != nil {
+				if member {
+					log.Errorf("[ldap] unable to find role %q mapped to group %q: %s", roleName, group, err)
+				}
+				continue
+			}
+			var contextValue string
+			switch role.ContextType {
+			case permission.CtxGlobal:
+			case permission.CtxTeam:
+				contextValue = mapping.team
+			default:
+				if member {
+					log.Errorf("[ldap] role %q mapped to group %q must have the global or team context", roleName, group)
+				}
+				continue
+			}
+			instance := roleInstance{name: roleName, contextValue: contextValue}
+			if !member {
+				mapped = append(mapped, instance)
+				continue
+			}
+			if role.ContextType == permission.CtxTeam {
+				if _, err = auth.GetTeam(mapping.team); err != nil {
+					log.Errorf("[ldap] unable to find team %q mapped to group %q: %s", mapping.team, group, err)
+					continue
+				}
+			}
+			if !wantedSet[instance] {
+				wantedSet[instance] = true
+				wanted = append(wanted, instance)
+			}
+		}
+	}
+	for _, r := range mapped {
+		if wantedSet[r] || !hasRole(user, r.name, r.contextValue) {
+			continue
+		}
+		err := user.RemoveRole(r.name, r.contextValue)
+		if err != nil {
+			log.Errorf("[ldap] unable to remove role %q from user %q: %s", r.name, user.Email, err)
+		}
+	}
+	for _, r := range wanted {
+		if hasRole(user, r.name, r.contextValue) {
+			continue
+		}
+		err := user.AddRole(r.name, r.contextValue)
+		if err != nil {
+			log.Errorf("[ldap] unable to add role %q to user %q: %s", r.name, user.Email, err)
+		}
+	}
+}
+
+func hasRole(user *auth.User, roleName, contextValue string) bool {
+	for _, r := range user.Roles {
+		if r.Name == roleName && r.ContextValue == contextValue {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *LDAPScheme) Login(params map[string]string) (auth.Token, error) {
+	username := params["email"]
+	if username == "" {
+		return nil, ErrMissingUsernameError
+	}
+	// An empty password would be an unauthenticated bind, accepted by most
+	// servers.
+	password := params["password"]
+	if password == "" {
+		return nil, ErrMissingPasswordError
+	}
+	conf, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	email, groups, err := conf.authenticate(username, password)
+	if err != nil {
+		return nil, err
+	}
+	user, err := auth.GetUserByEmail(email)
+	if err != nil {
+		if err != auth.ErrUserNotFound {
+			return nil, err
+		}
+		user = &auth.User{Email: email}
+		err = user.Create()
+		if err != nil {
+			return nil, err
+		}
+	}
+	conf.syncRoles(user, groups)
+	return createToken(user)
+}
+
+func (s *LDAPScheme) AppLogin(appName string) (auth.Token, error) {
+	nativeScheme := native.NativeScheme{}
+	return nativeScheme.AppLogin(appName)
+}
+
+func (s *LDAPScheme) AppLogout(token string) error {
+	return s.Logout(token)
+}
+
+func (s *LDAPScheme) Logout(token string) error {
+	return deleteToken(token)
+}
+
+func (s *LDAPScheme) Auth(token string) (auth.Token, error) {
+	return getToken(token)
+}
+
+func (s *LDAPScheme) Name() string {
+	return "ldap"
+}
+
+func (s *LDAPScheme) Info() (auth.SchemeInfo, error) {
+	return nil, nil
+}
+
+// Create creates the user in tsuru only, it doesn't touch the LDAP server.
+// Users created this way must also exist in the LDAP server to login.
+func (s *LDAPScheme) Create(user *auth.User) (*auth.User, error) {
+	if !validation.ValidateEmail(user.Email) {
+		return nil, ErrInvalidEmail
+	}
+	user.Password = ""
+	err := user.Create()
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *LDAPScheme) Remove(u *auth.User) error {
+	err := deleteAllTokens(u.Email)
+	if err != nil {
+		return err
+	}
+	return u.Delete()
+}
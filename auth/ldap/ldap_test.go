@@ -0,0 +1,240 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldap
+
+import (
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/repository/repositorytest"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) TestLDAPLogin(c *check.C) {
+	scheme := LDAPScheme{}
+	token, err := scheme.Login(map[string]string{"email": "alice", "password": "4l1c3"})
+	c.Assert(err, check.IsNil)
+	c.Assert(token.GetValue(), check.Not(check.Equals), "")
+	c.Assert(token.GetUserName(), check.Equals, "alice@tsuru.io")
+	c.Assert(token.IsAppToken(), check.Equals, false)
+	u, err := token.User()
+	c.Assert(err, check.IsNil)
+	c.Assert(u.Email, check.Equals, "alice@tsuru.io")
+	c.Assert(u.Password, check.Equals, "")
+	c.Assert(repositorytest.Users(), check.DeepEquals, []string{"alice@tsuru.io"})
+	c.Assert(s.server.boundDNs(), check.DeepEquals, []string{
+		"cn=tsuru,ou=services,dc=tsuru,dc=io",
+		"uid=alice,ou=people,dc=tsuru,dc=io",
+	})
+	dbToken, err := getToken("bearer " + token.GetValue())
+	c.Assert(err, check.IsNil)
+	c.Assert(dbToken.UserEmail, check.Equals, "alice@tsuru.io")
+}
+
+func (s *S) TestLDAPLoginExistingUser(c *check.C) {
+	user := auth.User{Email: "alice@tsuru.io"}
+	err := user.Create()
+	c.Assert(err, check.IsNil)
+	scheme := LDAPScheme{}
+	token, err := scheme.Login(map[string]string{"email": "alice", "password": "4l1c3"})
+	c.Assert(err, check.IsNil)
+	c.Assert(token.GetUserName(), check.Equals, "alice@tsuru.io")
+	count, err := s.conn.Users().Find(bson.M{"email": "alice@tsuru.io"}).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(count, check.Equals, 1)
+}
+
+func (s *S) TestLDAPLoginAnonymousSearch(c *check.C) {
+	config.Unset("auth:ldap:bind-dn")
+	defer config.Set("auth:ldap:bind-dn", "cn=tsuru,ou=services,dc=tsuru,dc=io")
+	scheme := LDAPScheme{}
+	_, err := scheme.Login(map[string]string{"email": "bob", "password": "b0b"})
+	c.Assert(err, check.IsNil)
+	c.Assert(s.server.boundDNs(), check.DeepEquals, []string{"uid=bob,ou=people,dc=tsuru,dc=io"})
+}
+
+func (s *S) TestLDAPLoginWrongPassword(c *check.C) {
+	scheme := LDAPScheme{}
+	_, err := scheme.Login(map[string]string{"email": "alice", "password": "wrong"})
+	c.Assert(err, check.Equals, errInvalidCredentials)
+	_, err = auth.GetUserByEmail("alice@tsuru.io")
+	c.Assert(err, check.Equals, auth.ErrUserNotFound)
+}
+
+func (s *S) TestLDAPLoginUserNotFound(c *check.C) {
+	scheme := LDAPScheme{}
+	_, err := scheme.Login(map[string]string{"email": "carol", "password": "c4r0l"})
+	c.Assert(err, check.Equals, errInvalidCredentials)
+}
+
+func (s *S) TestLDAPLoginEscapesUsername(c *check.C) {
+	scheme := LDAPScheme{}
+	_, err := scheme.Login(map[string]string{"email": "*", "password": "4l1c3"})
+	c.Assert(err, check.Equals, errInvalidCredentials)
+	_, err = scheme.Login(map[string]string{"email": "alice)(uid=*", "password": "4l1c3"})
+	c.Assert(err, check.Equals, errInvalidCredentials)
+}
+
+func (s *S) TestLDAPLoginMissingParams(c *check.C) {
+	scheme := LDAPScheme{}
+	_, err := scheme.Login(map[string]string{"password": "4l1c3"})
+	c.Assert(err, check.Equals, ErrMissingUsernameError)
+	_, err = scheme.Login(map[string]string{"email": "alice"})
+	c.Assert(err, check.Equals, ErrMissingPasswordError)
+	_, err = scheme.Login(map[string]string{"email": "alice", "password": ""})
+	c.Assert(err, check.Equals, ErrMissingPasswordError)
+}
+
+func (s *S) TestLDAPLoginServerDown(c *check.C) {
+	config.Set("auth:ldap:server", "127.0.0.1:1")
+	defer config.Set("auth:ldap:server", s.server.addr())
+	scheme := LDAPScheme{}
+	_, err := scheme.Login(map[string]string{"email": "alice", "password": "4l1c3"})
+	c.Assert(err, check.ErrorMatches, `unable to connect to ldap server "127.0.0.1:1".*`)
+}
+
+func (s *S) TestLDAPLoginGroupRoles(c *check.C) {
+	_, err := permission.NewRole("team-member", "team", "")
+	c.Assert(err, check.IsNil)
+	_, err = permission.NewRole("app-reader", "global", "")
+	c.Assert(err, check.IsNil)
+	_, err = permission.NewRole("pool-admin", "pool", "")
+	c.Assert(err, check.IsNil)
+	err = s.conn.Teams().Insert(auth.Team{Name: "devs"})
+	c.Assert(err, check.IsNil)
+	config.Set("auth:ldap:groups:developers:team", "devs")
+	config.Set("auth:ldap:groups:developers:roles", []interface{}{"team-member", "app-reader", "pool-admin", "unknown"})
+	config.Set("auth:ldap:groups:admins:team", "admins")
+	config.Set("auth:ldap:groups:admins:roles", []interface{}{"team-member"})
+	scheme := LDAPScheme{}
+	token, err := scheme.Login(map[string]string{"email": "alice", "password": "4l1c3"})
+	c.Assert(err, check.IsNil)
+	u, err := token.User()
+	c.Assert(err, check.IsNil)
+	c.Assert(u.Roles, check.DeepEquals, []auth.RoleInstance{
+		{Name: "team-member", ContextValue: "devs"},
+		{Name: "app-reader", ContextValue: ""},
+	})
+	_, err = scheme.Login(map[string]string{"email": "alice", "password": "4l1c3"})
+	c.Assert(err, check.IsNil)
+	err = u.Reload()
+	c.Assert(err, check.IsNil)
+	c.Assert(u.Roles, check.HasLen, 2)
+	token, err = scheme.Login(map[string]string{"email": "bob", "password": "b0b"})
+	c.Assert(err, check.IsNil)
+	u, err = token.User()
+	c.Assert(err, check.IsNil)
+	c.Assert(u.Roles, check.HasLen, 0)
+}
+
+func (s *S) TestLDAPLoginRemovesRolesOfOldGroups(c *check.C) {
+	_, err := permission.NewRole("team-member", "team", "")
+	c.Assert(err, check.IsNil)
+	_, err = permission.NewRole("app-reader", "global", "")
+	c.Assert(err, check.IsNil)
+	_, err = permission.NewRole("custom", "global", "")
+	c.Assert(err, check.IsNil)
+	err = s.conn.Teams().Insert(auth.Team{Name: "devs"}, auth.Team{Name: "admins"})
+	c.Assert(err, check.IsNil)
+	config.Set("auth:ldap:groups:developers:team", "devs")
+	config.Set("auth:ldap:groups:developers:roles", []interface{}{"team-member"})
+	config.Set("auth:ldap:groups:admins:team", "admins")
+	config.Set("auth:ldap:groups:admins:roles", []interface{}{"team-member", "app-reader"})
+	user := auth.User{Email: "alice@tsuru.io"}
+	err = user.Create()
+	c.Assert(err, check.IsNil)
+	err = user.AddRole("team-member", "admins")
+	c.Assert(err, check.IsNil)
+	err = user.AddRole("app-reader", "")
+	c.Assert(err, check.IsNil)
+	err = user.AddRole("custom", "")
+	c.Assert(err, check.IsNil)
+	err = user.AddRole("team-member", "other")
+	c.Assert(err, check.IsNil)
+	scheme := LDAPScheme{}
+	_, err = scheme.Login(map[string]string{"email": "alice", "password": "4l1c3"})
+	c.Assert(err, check.IsNil)
+	err = user.Reload()
+	c.Assert(err, check.IsNil)
+	c.Assert(user.Roles, check.DeepEquals, []auth.RoleInstance{
+		{Name: "custom", ContextValue: ""},
+		{Name: "team-member", ContextValue: "other"},
+		{Name: "team-member", ContextValue: "devs"},
+	})
+}
+
+func (s *S) TestLDAPLogout(c *check.C) {
+	scheme := LDAPScheme{}
+	token, err := scheme.Login(map[string]string{"email": "alice", "password": "4l1c3"})
+	c.Assert(err, check.IsNil)
+	err = scheme.Logout(token.GetValue())
+	c.Assert(err, check.IsNil)
+	_, err = scheme.Auth("bearer " + token.GetValue())
+	c.Assert(err, check.Equals, auth.ErrInvalidToken)
+}
+
+func (s *S) TestLDAPAuth(c *check.C) {
+	scheme := LDAPScheme{}
+	token, err := scheme.Login(map[string]string{"email": "alice", "password": "4l1c3"})
+	c.Assert(err, check.IsNil)
+	authToken, err := scheme.Auth("bearer " + token.GetValue())
+	c.Assert(err, check.IsNil)
+	c.Assert(authToken.GetUserName(), check.Equals, "alice@tsuru.io")
+}
+
+func (s *S) TestLDAPAuthWithAppToken(c *check.C) {
+	scheme := LDAPScheme{}
+	appToken, err := scheme.AppLogin("myApp")
+	c.Assert(err, check.IsNil)
+	token, err := scheme.Auth("bearer " + appToken.GetValue())
+	c.Assert(err, check.IsNil)
+	c.Assert(token.IsAppToken(), check.Equals, true)
+	c.Assert(token.GetAppName(), check.Equals, "myApp")
+}
+
+func (s *S) TestLDAPName(c *check.C) {
+	scheme := LDAPScheme{}
+	c.Assert(scheme.Name(), check.Equals, "ldap")
+}
+
+func (s *S) TestLDAPInfo(c *check.C) {
+	scheme := LDAPScheme{}
+	info, err := scheme.Info()
+	c.Assert(err, check.IsNil)
+	c.Assert(info, check.IsNil)
+}
+
+func (s *S) TestLDAPCreate(c *check.C) {
+	scheme := LDAPScheme{}
+	user := auth.User{Email: "carol@tsuru.io", Password: "something"}
+	_, err := scheme.Create(&user)
+	c.Assert(err, check.IsNil)
+	dbUser, err := auth.GetUserByEmail(user.Email)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbUser.Password, check.Equals, "")
+	c.Assert(repositorytest.Users(), check.DeepEquals, []string{user.Email})
+}
+
+func (s *S) TestLDAPCreateInvalidEmail(c *check.C) {
+	scheme := LDAPScheme{}
+	_, err := scheme.Create(&auth.User{Email: "carol"})
+	c.Assert(err, check.Equals, ErrInvalidEmail)
+}
+
+func (s *S) TestLDAPRemove(c *check.C) {
+	scheme := LDAPScheme{}
+	token, err := scheme.Login(map[string]string{"email": "alice", "password": "4l1c3"})
+	c.Assert(err, check.IsNil)
+	u, err := token.User()
+	c.Assert(err, check.IsNil)
+	err = scheme.Remove(u)
+	c.Assert(err, check.IsNil)
+	count, err := s.conn.Tokens().Find(bson.M{"useremail": u.Email}).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(count, check.Equals, 0)
+	_, err = auth.GetUserByEmail(u.Email)
+	c.Assert(err, check.Equals, auth.ErrUserNotFound)
+}
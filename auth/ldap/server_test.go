@@ -0,0 +1,191 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldap
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+)
+
+// fakeEntry is an entry of the fake LDAP server.
+type fakeEntry struct {
+	dn         string
+	password   string
+	attributes map[string][]string
+}
+
+// fakeServer is an in-process stand-in for a LDAP server, understanding
+// simple binds and searches.
+type fakeServer struct {
+	listener net.Listener
+	entries  []fakeEntry
+
+	mu    sync.Mutex
+	binds []string
+}
+
+func newFakeServer(entries ...fakeEntry) (*fakeServer, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &fakeServer{listener: l, entries: entries}
+	go s.serve()
+	return s, nil
+}
+
+func (s *fakeServer) addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *fakeServer) stop() {
+	s.listener.Close()
+}
+
+// boundDNs returns the DNs of the successful binds, in order.
+func (s *fakeServer) boundDNs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.binds...)
+}
+
+func (s *fakeServer) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.binds = nil
+}
+
+func (s *fakeServer) serve() {
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(c)
+	}
+}
+
+func (s *fakeServer) handle(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	for {
+		msg, err := readPacket(r)
+		if err != nil || len(msg.children) < 2 {
+			return
+		}
+		id, op := msg.children[0].integer(), msg.children[1]
+		var responses []*berPacket
+		switch {
+		case op.is(classApplication, opBindRequest):
+			responses = append(responses, s.bind(op))
+		case op.is(classApplication, opSearchRequest):
+			responses = s.search(op)
+		default:
+			return
+		}
+		for _, resp := range responses {
+			msg := newSequence(classUniversal, tagSequence, newInteger(classUniversal, tagInteger, id), resp)
+			if _, err := c.Write(msg.encode()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func result(op byte, code int64, message string) *berPacket {
+	return newSequence(classApplication, op,
+		newInteger(classUniversal, tagEnumerated, code),
+		newString(classUniversal, tagOctetString, ""),
+		newString(classUniversal, tagOctetString, message),
+	)
+}
+
+func (s *fakeServer) bind(op *berPacket) *berPacket {
+	dn, password := op.children[1].str(), op.children[2].str()
+	if dn != "" {
+		var ok bool
+		for _, e := range s.entries {
+			if strings.EqualFold(e.dn, dn) && e.password != "" && e.password == password {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return result(opBindResponse, resultInvalidCreds, "invalid credentials")
+		}
+	}
+	s.mu.Lock()
+	s.binds = append(s.binds, dn)
+	s.mu.Unlock()
+	return result(opBindResponse, resultSuccess, "")
+}
+
+func (s *fakeServer) search(op *berPacket) []*berPacket {
+	baseDN, filter, attrs := strings.ToLower(op.children[0].str()), op.children[6], op.children[7]
+	var responses []*berPacket
+	for _, e := range s.entries {
+		if !strings.HasSuffix(strings.ToLower(e.dn), baseDN) || !e.matches(filter) {
+			continue
+		}
+		attributes := newSequence(classUniversal, tagSequence)
+		for _, attr := range attrs.children {
+			values := newSequence(classUniversal, tagSet)
+			for _, v := range e.attributes[attr.str()] {
+				values.children = append(values.children, newString(classUniversal, tagOctetString, v))
+			}
+			attributes.children = append(attributes.children, newSequence(classUniversal, tagSequence,
+				newString(classUniversal, tagOctetString, attr.str()),
+				values,
+			))
+		}
+		responses = append(responses, newSequence(classApplication, opSearchEntry,
+			newString(classUniversal, tagOctetString, e.dn),
+			attributes,
+		))
+	}
+	return append(responses, result(opSearchDone, resultSuccess, ""))
+}
+
+func (e *fakeEntry) matches(filter *berPacket) bool {
+	switch filter.tag {
+	case filterAnd:
+		for _, child := range filter.children {
+			if !e.matches(child) {
+				return false
+			}
+		}
+		return true
+	case filterOr:
+		for _, child := range filter.children {
+			if e.matches(child) {
+				return true
+			}
+		}
+		return false
+	case filterNot:
+		return !e.matches(filter.children[0])
+	case filterEquality:
+		for _, v := range e.values(filter.children[0].str()) {
+			if strings.EqualFold(v, filter.children[1].str()) {
+				return true
+			}
+		}
+		return false
+	case filterPresent:
+		return len(e.values(filter.str())) > 0
+	}
+	return false
+}
+
+func (e *fakeEntry) values(attr string) []string {
+	for name, values := range e.attributes {
+		if strings.EqualFold(name, attr) {
+			return values
+		}
+	}
+	return nil
+}
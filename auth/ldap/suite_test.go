@@ -0,0 +1,92 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldap
+
+import (
+	"testing"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/db/dbtest"
+	"github.com/tsuru/tsuru/repository/repositorytest"
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type S struct {
+	conn   *db.Storage
+	server *fakeServer
+}
+
+var _ = check.Suite(&S{})
+
+func (s *S) SetUpSuite(c *check.C) {
+	config.Set("database:url", "127.0.0.1:27017")
+	config.Set("database:name", "tsuru_auth_ldap_test")
+	config.Set("auth:ldap:user-base-dn", "ou=people,dc=tsuru,dc=io")
+	config.Set("auth:ldap:group-base-dn", "ou=groups,dc=tsuru,dc=io")
+	config.Set("auth:ldap:bind-dn", "cn=tsuru,ou=services,dc=tsuru,dc=io")
+	config.Set("auth:ldap:bind-password", "s3rv1c3")
+	var err error
+	s.server, err = newFakeServer(
+		fakeEntry{
+			dn:       "cn=tsuru,ou=services,dc=tsuru,dc=io",
+			password: "s3rv1c3",
+		},
+		fakeEntry{
+			dn:       "uid=alice,ou=people,dc=tsuru,dc=io",
+			password: "4l1c3",
+			attributes: map[string][]string{
+				"uid":  {"alice"},
+				"mail": {"alice@tsuru.io"},
+			},
+		},
+		fakeEntry{
+			dn:       "uid=bob,ou=people,dc=tsuru,dc=io",
+			password: "b0b",
+			attributes: map[string][]string{
+				"uid":  {"bob"},
+				"mail": {"bob@tsuru.io"},
+			},
+		},
+		fakeEntry{
+			dn: "cn=developers,ou=groups,dc=tsuru,dc=io",
+			attributes: map[string][]string{
+				"cn":     {"developers"},
+				"member": {"uid=alice,ou=people,dc=tsuru,dc=io"},
+			},
+		},
+		fakeEntry{
+			dn: "cn=admins,ou=groups,dc=tsuru,dc=io",
+			attributes: map[string][]string{
+				"cn":     {"admins"},
+				"member": {"uid=bob,ou=people,dc=tsuru,dc=io"},
+			},
+		},
+	)
+	c.Assert(err, check.IsNil)
+	config.Set("auth:ldap:server", s.server.addr())
+}
+
+func (s *S) SetUpTest(c *check.C) {
+	var err error
+	s.conn, err = db.Conn()
+	c.Assert(err, check.IsNil)
+	s.server.reset()
+	repositorytest.Reset()
+}
+
+func (s *S) TearDownTest(c *check.C) {
+	config.Unset("auth:ldap:groups")
+	err := dbtest.ClearAllCollections(s.conn.Users().Database)
+	c.Assert(err, check.IsNil)
+	s.conn.Close()
+}
+
+func (s *S) TearDownSuite(c *check.C) {
+	s.server.stop()
+	config.Unset("auth:ldap")
+}
@@ -39,7 +39,7 @@ func (s NativeScheme) Login(params map[string]string) (auth.Token, error) {
 	if err != nil {
 		return nil, err
 	}
-	token, err := createToken(user, password)
+	token, err := createToken(user, password, params["otp"])
 	if err != nil {
 		return nil, err
 	}
@@ -139,6 +139,10 @@ func (s NativeScheme) Remove(u *auth.User) error {
 	if err != nil {
 		return err
 	}
+	err = removeTwoFactorAuth(u.Email)
+	if err != nil {
+		return err
+	}
 	return u.Delete()
 }
 
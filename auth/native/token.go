@@ -169,13 +169,18 @@ func checkPassword(passwordHash string, password string) error {
 	return auth.AuthenticationFailure{Message: "Authentication failed, wrong password."}
 }
 
-func createToken(u *auth.User, password string) (*Token, error) {
+// createToken creates a new session token for the user, after checking the
+// password and, when required, the two-factor authentication code.
+func createToken(u *auth.User, password, code string) (*Token, error) {
 	if u.Email == "" {
 		return nil, errors.New("User does not have an email")
 	}
 	if err := checkPassword(u.Password, password); err != nil {
 		return nil, err
 	}
+	if err := checkSecondFactor(u, code); err != nil {
+		return nil, err
+	}
 	conn, err := db.Conn()
 	if err != nil {
 		return nil, err
@@ -0,0 +1,98 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package native
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	totpPeriod         = 30
+	totpDigits         = 6
+	totpSecretSize     = 20
+	totpSkew           = 1
+	recoveryCodesCount = 10
+)
+
+// generateTOTPSecret returns a random secret, encoded in base32 as expected
+// by authenticator applications.
+func generateTOTPSecret() (string, error) {
+	var secret [totpSecretSize]byte
+	if _, err := rand.Read(secret[:]); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.EncodeToString(secret[:]), nil
+}
+
+// totpURI returns the otpauth URI of the given secret, understood by most
+// authenticator applications.
+func totpURI(issuer, email, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	label := url.QueryEscape(issuer + ":" + email)
+	return "otpauth://totp/" + strings.Replace(label, "+", "%20", -1) + "?" + v.Encode()
+}
+
+// totpCode computes the HOTP value (RFC 4226) of the given key and counter,
+// which is the TOTP value (RFC 6238) when the counter is derived from time.
+func totpCode(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// validateTOTP checks the code against the secret at the given time,
+// accepting codes from the adjacent periods to cope with clock drift. It
+// returns the counter matching the code, which is used to prevent replays.
+func validateTOTP(secret, code string, now time.Time) (int64, bool) {
+	key, err := base32.StdEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for counter := current - totpSkew; counter <= current+totpSkew; counter++ {
+		if hmac.Equal([]byte(totpCode(key, counter)), []byte(code)) {
+			return counter, true
+		}
+	}
+	return 0, false
+}
+
+// generateRecoveryCodes returns a list of random single use codes, formatted
+// as two groups of five characters.
+func generateRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodesCount)
+	for i := range codes {
+		var data [7]byte
+		if _, err := rand.Read(data[:]); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(data[:]))
+		codes[i] = code[:5] + "-" + code[5:10]
+	}
+	return codes, nil
+}
+
+// hashRecoveryCode returns the value stored for the given recovery code. As
+// recovery codes are random, a plain hash is enough.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(code)))
+}
@@ -0,0 +1,90 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package native
+
+import (
+	"encoding/base32"
+	"net/url"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestTOTPCode(c *check.C) {
+	// Test vectors from RFC 6238, truncated to six digits.
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		unix     int64
+		expected string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		c.Check(totpCode(key, tt.unix/totpPeriod), check.Equals, tt.expected, check.Commentf("%d", tt.unix))
+	}
+}
+
+func (s *S) TestValidateTOTP(c *check.C) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111109, 0)
+	counter, ok := validateTOTP(secret, "081804", now)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(counter, check.Equals, int64(1111111109/totpPeriod))
+	_, ok = validateTOTP(secret, "081804", now.Add(totpPeriod*time.Second))
+	c.Assert(ok, check.Equals, true)
+	_, ok = validateTOTP(secret, "081804", now.Add(-totpPeriod*time.Second))
+	c.Assert(ok, check.Equals, true)
+	_, ok = validateTOTP(secret, "081804", now.Add(3*totpPeriod*time.Second))
+	c.Assert(ok, check.Equals, false)
+	_, ok = validateTOTP(secret, "081805", now)
+	c.Assert(ok, check.Equals, false)
+	_, ok = validateTOTP(secret, "", now)
+	c.Assert(ok, check.Equals, false)
+	_, ok = validateTOTP("not base32!", "081804", now)
+	c.Assert(ok, check.Equals, false)
+}
+
+func (s *S) TestGenerateTOTPSecret(c *check.C) {
+	secret, err := generateTOTPSecret()
+	c.Assert(err, check.IsNil)
+	key, err := base32.StdEncoding.DecodeString(secret)
+	c.Assert(err, check.IsNil)
+	c.Assert(key, check.HasLen, totpSecretSize)
+	other, err := generateTOTPSecret()
+	c.Assert(err, check.IsNil)
+	c.Assert(other, check.Not(check.Equals), secret)
+}
+
+func (s *S) TestTOTPURI(c *check.C) {
+	uri := totpURI("tsuru", "me@tsuru.io", "ABCDEF")
+	c.Assert(uri, check.Equals, "otpauth://totp/tsuru%3Ame%40tsuru.io?issuer=tsuru&secret=ABCDEF")
+	parsed, err := url.Parse(uri)
+	c.Assert(err, check.IsNil)
+	c.Assert(parsed.Query().Get("secret"), check.Equals, "ABCDEF")
+}
+
+func (s *S) TestGenerateRecoveryCodes(c *check.C) {
+	codes, err := generateRecoveryCodes()
+	c.Assert(err, check.IsNil)
+	c.Assert(codes, check.HasLen, recoveryCodesCount)
+	seen := map[string]bool{}
+	for _, code := range codes {
+		c.Assert(code, check.Matches, `[a-z2-7]{5}-[a-z2-7]{5}`)
+		c.Assert(seen[code], check.Equals, false)
+		seen[code] = true
+	}
+}
+
+func (s *S) TestHashRecoveryCode(c *check.C) {
+	hash := hashRecoveryCode("abcde-fghij")
+	c.Assert(hash, check.HasLen, 64)
+	c.Assert(hashRecoveryCode("ABCDEFGHIJ"), check.Equals, hash)
+	c.Assert(hashRecoveryCode(" abcde-fghij "), check.Equals, hash)
+	c.Assert(hashRecoveryCode("abcde-fghik"), check.Not(check.Equals), hash)
+}
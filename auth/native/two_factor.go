@@ -0,0 +1,230 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package native
+
+import (
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/errors"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var (
+	ErrTwoFactorAlreadyEnabled = &errors.ConflictError{Message: "two-factor authentication is already enabled"}
+	ErrTwoFactorNotEnabled     = &errors.ValidationError{Message: "two-factor authentication is not enabled"}
+	ErrTwoFactorNotStarted     = &errors.ValidationError{Message: "two-factor enrollment not started"}
+	ErrTwoFactorMandatory      = &errors.NotAuthorizedError{Message: "two-factor authentication is mandatory for this user"}
+	ErrInvalidTwoFactorCode    = auth.AuthenticationFailure{Message: "Authentication failed, invalid two-factor code."}
+)
+
+type twoFactorAuth struct {
+	UserEmail     string `bson:"_id"`
+	Secret        string
+	Enabled       bool
+	RecoveryCodes []string
+	LastCounter   int64
+	Creation      time.Time
+}
+
+func getTwoFactorAuth(email string) (*twoFactorAuth, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var tfa twoFactorAuth
+	err = conn.TwoFactorAuth().FindId(email).One(&tfa)
+	if err == mgo.ErrNotFound {
+		return nil, ErrTwoFactorNotEnabled
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tfa, nil
+}
+
+func removeTwoFactorAuth(email string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.TwoFactorAuth().RemoveId(email)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// check validates the given code, which may be either a code generated by
+// the authenticator application or one of the recovery codes. Both are only
+// accepted once.
+func (tfa *twoFactorAuth) check(code string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if counter, ok := validateTOTP(tfa.Secret, code, time.Now()); ok {
+		err = conn.TwoFactorAuth().Update(
+			bson.M{"_id": tfa.UserEmail, "lastcounter": bson.M{"$lt": counter}},
+			bson.M{"$set": bson.M{"lastcounter": counter}},
+		)
+	} else {
+		hash := hashRecoveryCode(code)
+		err = conn.TwoFactorAuth().Update(
+			bson.M{"_id": tfa.UserEmail, "recoverycodes": hash},
+			bson.M{"$pull": bson.M{"recoverycodes": hash}},
+		)
+	}
+	if err == mgo.ErrNotFound {
+		return ErrInvalidTwoFactorCode
+	}
+	return err
+}
+
+// checkSecondFactor is the extra step of the login, performed after checking
+// the password of the user. Users that haven't enrolled may only log in if the
+// admin policy doesn't mandate two-factor authentication for them.
+func checkSecondFactor(u *auth.User, code string) error {
+	tfa, err := getTwoFactorAuth(u.Email)
+	if err == nil && tfa.Enabled {
+		if code == "" {
+			return auth.ErrTwoFactorCodeRequired
+		}
+		return tfa.check(code)
+	}
+	if err != nil && err != ErrTwoFactorNotEnabled {
+		return err
+	}
+	required, err := auth.TwoFactorRequired(u)
+	if err != nil {
+		return err
+	}
+	if required {
+		return auth.ErrTwoFactorEnrollmentRequired
+	}
+	return nil
+}
+
+func (s NativeScheme) CheckPassword(u *auth.User, password string) error {
+	return checkPassword(u.Password, password)
+}
+
+func (s NativeScheme) StartTwoFactorEnrollment(u *auth.User, password string) (*auth.TwoFactorEnrollment, error) {
+	if err := checkPassword(u.Password, password); err != nil {
+		return nil, err
+	}
+	tfa, err := getTwoFactorAuth(u.Email)
+	if err == nil && tfa.Enabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	if err != nil && err != ErrTwoFactorNotEnabled {
+		return nil, err
+	}
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_, err = conn.TwoFactorAuth().UpsertId(u.Email, twoFactorAuth{
+		UserEmail: u.Email,
+		Secret:    secret,
+		Creation:  time.Now().UTC(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	issuer, _ := config.GetString("auth:two-factor:issuer")
+	if issuer == "" {
+		issuer = "tsuru"
+	}
+	return &auth.TwoFactorEnrollment{
+		Secret: secret,
+		URI:    totpURI(issuer, u.Email, secret),
+	}, nil
+}
+
+// ConfirmTwoFactorEnrollment enables two-factor authentication for the user,
+// given a code generated from the secret of the pending enrollment. The
+// recovery codes are returned in plain text only once.
+func (s NativeScheme) ConfirmTwoFactorEnrollment(u *auth.User, password, code string) ([]string, error) {
+	if err := checkPassword(u.Password, password); err != nil {
+		return nil, err
+	}
+	tfa, err := getTwoFactorAuth(u.Email)
+	if err == ErrTwoFactorNotEnabled {
+		return nil, ErrTwoFactorNotStarted
+	}
+	if err != nil {
+		return nil, err
+	}
+	if tfa.Enabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	counter, ok := validateTOTP(tfa.Secret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, c := range codes {
+		hashes[i] = hashRecoveryCode(c)
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	err = conn.TwoFactorAuth().Update(bson.M{"_id": u.Email, "enabled": false}, bson.M{
+		"$set": bson.M{"enabled": true, "recoverycodes": hashes, "lastcounter": counter},
+	})
+	if err == mgo.ErrNotFound {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTwoFactor disables two-factor authentication for the user, given
+// either a code generated by the authenticator application or a recovery
+// code. Users that are mandated to use two-factor authentication can't
+// disable it.
+func (s NativeScheme) DisableTwoFactor(u *auth.User, password, code string) error {
+	if err := checkPassword(u.Password, password); err != nil {
+		return err
+	}
+	tfa, err := getTwoFactorAuth(u.Email)
+	if err != nil {
+		return err
+	}
+	if !tfa.Enabled {
+		return ErrTwoFactorNotEnabled
+	}
+	required, err := auth.TwoFactorRequired(u)
+	if err != nil {
+		return err
+	}
+	if required {
+		return ErrTwoFactorMandatory
+	}
+	if err = tfa.check(code); err != nil {
+		return err
+	}
+	return removeTwoFactorAuth(u.Email)
+}
@@ -0,0 +1,214 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package native
+
+import (
+	"encoding/base32"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/check.v1"
+)
+
+// codeAt returns the code of the given secret in the period at the given
+// offset from the current one.
+func codeAt(c *check.C, secret string, offset int64) string {
+	key, err := base32.StdEncoding.DecodeString(secret)
+	c.Assert(err, check.IsNil)
+	return totpCode(key, time.Now().Unix()/totpPeriod+offset)
+}
+
+func (s *S) enrollTwoFactor(c *check.C) (string, []string) {
+	enrollment, err := nativeScheme.StartTwoFactorEnrollment(s.user, "123456")
+	c.Assert(err, check.IsNil)
+	codes, err := nativeScheme.ConfirmTwoFactorEnrollment(s.user, "123456", codeAt(c, enrollment.Secret, -1))
+	c.Assert(err, check.IsNil)
+	return enrollment.Secret, codes
+}
+
+func (s *S) TestNativeSchemeIsTwoFactorScheme(c *check.C) {
+	var scheme auth.Scheme = nativeScheme
+	_, ok := scheme.(auth.TwoFactorScheme)
+	c.Assert(ok, check.Equals, true)
+}
+
+func (s *S) TestCheckPassword(c *check.C) {
+	err := nativeScheme.CheckPassword(s.user, "123456")
+	c.Assert(err, check.IsNil)
+	err = nativeScheme.CheckPassword(s.user, "1234567")
+	c.Assert(err, check.FitsTypeOf, auth.AuthenticationFailure{})
+}
+
+func (s *S) TestStartTwoFactorEnrollment(c *check.C) {
+	enrollment, err := nativeScheme.StartTwoFactorEnrollment(s.user, "123456")
+	c.Assert(err, check.IsNil)
+	c.Assert(enrollment.Secret, check.Not(check.Equals), "")
+	c.Assert(enrollment.URI, check.Equals, totpURI("tsuru", s.user.Email, enrollment.Secret))
+	tfa, err := getTwoFactorAuth(s.user.Email)
+	c.Assert(err, check.IsNil)
+	c.Assert(tfa.Secret, check.Equals, enrollment.Secret)
+	c.Assert(tfa.Enabled, check.Equals, false)
+	_, err = nativeScheme.Login(map[string]string{"email": s.user.Email, "password": "123456"})
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestStartTwoFactorEnrollmentIssuer(c *check.C) {
+	config.Set("auth:two-factor:issuer", "mytsuru")
+	defer config.Unset("auth:two-factor:issuer")
+	enrollment, err := nativeScheme.StartTwoFactorEnrollment(s.user, "123456")
+	c.Assert(err, check.IsNil)
+	c.Assert(enrollment.URI, check.Equals, totpURI("mytsuru", s.user.Email, enrollment.Secret))
+}
+
+func (s *S) TestStartTwoFactorEnrollmentWrongPassword(c *check.C) {
+	_, err := nativeScheme.StartTwoFactorEnrollment(s.user, "1234567")
+	c.Assert(err, check.FitsTypeOf, auth.AuthenticationFailure{})
+	_, err = getTwoFactorAuth(s.user.Email)
+	c.Assert(err, check.Equals, ErrTwoFactorNotEnabled)
+}
+
+func (s *S) TestStartTwoFactorEnrollmentAlreadyEnabled(c *check.C) {
+	s.enrollTwoFactor(c)
+	_, err := nativeScheme.StartTwoFactorEnrollment(s.user, "123456")
+	c.Assert(err, check.Equals, ErrTwoFactorAlreadyEnabled)
+}
+
+func (s *S) TestConfirmTwoFactorEnrollment(c *check.C) {
+	secret, codes := s.enrollTwoFactor(c)
+	c.Assert(codes, check.HasLen, recoveryCodesCount)
+	tfa, err := getTwoFactorAuth(s.user.Email)
+	c.Assert(err, check.IsNil)
+	c.Assert(tfa.Secret, check.Equals, secret)
+	c.Assert(tfa.Enabled, check.Equals, true)
+	c.Assert(tfa.RecoveryCodes, check.HasLen, recoveryCodesCount)
+	c.Assert(tfa.RecoveryCodes[0], check.Equals, hashRecoveryCode(codes[0]))
+}
+
+func (s *S) TestConfirmTwoFactorEnrollmentInvalidCode(c *check.C) {
+	enrollment, err := nativeScheme.StartTwoFactorEnrollment(s.user, "123456")
+	c.Assert(err, check.IsNil)
+	_, err = nativeScheme.ConfirmTwoFactorEnrollment(s.user, "123456", codeAt(c, enrollment.Secret, 5))
+	c.Assert(err, check.Equals, ErrInvalidTwoFactorCode)
+	tfa, err := getTwoFactorAuth(s.user.Email)
+	c.Assert(err, check.IsNil)
+	c.Assert(tfa.Enabled, check.Equals, false)
+}
+
+func (s *S) TestConfirmTwoFactorEnrollmentNotStarted(c *check.C) {
+	_, err := nativeScheme.ConfirmTwoFactorEnrollment(s.user, "123456", "123456")
+	c.Assert(err, check.Equals, ErrTwoFactorNotStarted)
+}
+
+func (s *S) TestLoginWithTwoFactor(c *check.C) {
+	secret, _ := s.enrollTwoFactor(c)
+	_, err := nativeScheme.Login(map[string]string{"email": s.user.Email, "password": "123456"})
+	c.Assert(err, check.Equals, auth.ErrTwoFactorCodeRequired)
+	_, err = nativeScheme.Login(map[string]string{"email": s.user.Email, "password": "123456", "otp": codeAt(c, secret, 5)})
+	c.Assert(err, check.Equals, ErrInvalidTwoFactorCode)
+	token, err := nativeScheme.Login(map[string]string{"email": s.user.Email, "password": "123456", "otp": codeAt(c, secret, 0)})
+	c.Assert(err, check.IsNil)
+	c.Assert(token.GetUserName(), check.Equals, s.user.Email)
+}
+
+func (s *S) TestLoginWithTwoFactorRejectsReplayedCodes(c *check.C) {
+	secret, _ := s.enrollTwoFactor(c)
+	code := codeAt(c, secret, 0)
+	_, err := nativeScheme.Login(map[string]string{"email": s.user.Email, "password": "123456", "otp": code})
+	c.Assert(err, check.IsNil)
+	_, err = nativeScheme.Login(map[string]string{"email": s.user.Email, "password": "123456", "otp": code})
+	c.Assert(err, check.Equals, ErrInvalidTwoFactorCode)
+	_, err = nativeScheme.Login(map[string]string{"email": s.user.Email, "password": "123456", "otp": codeAt(c, secret, -1)})
+	c.Assert(err, check.Equals, ErrInvalidTwoFactorCode)
+}
+
+func (s *S) TestLoginWithTwoFactorRecoveryCode(c *check.C) {
+	_, codes := s.enrollTwoFactor(c)
+	_, err := nativeScheme.Login(map[string]string{"email": s.user.Email, "password": "123456", "otp": codes[3]})
+	c.Assert(err, check.IsNil)
+	_, err = nativeScheme.Login(map[string]string{"email": s.user.Email, "password": "123456", "otp": codes[3]})
+	c.Assert(err, check.Equals, ErrInvalidTwoFactorCode)
+	tfa, err := getTwoFactorAuth(s.user.Email)
+	c.Assert(err, check.IsNil)
+	c.Assert(tfa.RecoveryCodes, check.HasLen, recoveryCodesCount-1)
+}
+
+func (s *S) TestLoginWithTwoFactorChecksPasswordFirst(c *check.C) {
+	s.enrollTwoFactor(c)
+	_, err := nativeScheme.Login(map[string]string{"email": s.user.Email, "password": "1234567"})
+	c.Assert(err, check.FitsTypeOf, auth.AuthenticationFailure{})
+	c.Assert(err, check.Not(check.Equals), ErrInvalidTwoFactorCode)
+}
+
+func (s *S) TestLoginTwoFactorMandatory(c *check.C) {
+	config.Set("auth:two-factor:required-permissions", []interface{}{"node"})
+	defer config.Unset("auth:two-factor:required-permissions")
+	role, err := permission.NewRole("node-admin", "pool", "")
+	c.Assert(err, check.IsNil)
+	err = role.AddPermissions("node.create")
+	c.Assert(err, check.IsNil)
+	err = s.user.AddRole("node-admin", "mypool")
+	c.Assert(err, check.IsNil)
+	_, err = nativeScheme.Login(map[string]string{"email": s.user.Email, "password": "123456"})
+	c.Assert(err, check.Equals, auth.ErrTwoFactorEnrollmentRequired)
+	secret, _ := s.enrollTwoFactor(c)
+	_, err = nativeScheme.Login(map[string]string{"email": s.user.Email, "password": "123456", "otp": codeAt(c, secret, 0)})
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestDisableTwoFactor(c *check.C) {
+	secret, _ := s.enrollTwoFactor(c)
+	err := nativeScheme.DisableTwoFactor(s.user, "123456", codeAt(c, secret, 0))
+	c.Assert(err, check.IsNil)
+	_, err = getTwoFactorAuth(s.user.Email)
+	c.Assert(err, check.Equals, ErrTwoFactorNotEnabled)
+	_, err = nativeScheme.Login(map[string]string{"email": s.user.Email, "password": "123456"})
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestDisableTwoFactorWithRecoveryCode(c *check.C) {
+	_, codes := s.enrollTwoFactor(c)
+	err := nativeScheme.DisableTwoFactor(s.user, "123456", codes[0])
+	c.Assert(err, check.IsNil)
+	_, err = getTwoFactorAuth(s.user.Email)
+	c.Assert(err, check.Equals, ErrTwoFactorNotEnabled)
+}
+
+func (s *S) TestDisableTwoFactorInvalidCode(c *check.C) {
+	secret, _ := s.enrollTwoFactor(c)
+	err := nativeScheme.DisableTwoFactor(s.user, "123456", codeAt(c, secret, 5))
+	c.Assert(err, check.Equals, ErrInvalidTwoFactorCode)
+	tfa, err := getTwoFactorAuth(s.user.Email)
+	c.Assert(err, check.IsNil)
+	c.Assert(tfa.Enabled, check.Equals, true)
+}
+
+func (s *S) TestDisableTwoFactorNotEnabled(c *check.C) {
+	err := nativeScheme.DisableTwoFactor(s.user, "123456", "123456")
+	c.Assert(err, check.Equals, ErrTwoFactorNotEnabled)
+}
+
+func (s *S) TestDisableTwoFactorMandatory(c *check.C) {
+	secret, _ := s.enrollTwoFactor(c)
+	config.Set("auth:two-factor:required-permissions", []interface{}{"app.admin"})
+	defer config.Unset("auth:two-factor:required-permissions")
+	role, err := permission.NewRole("app-owner", "app", "")
+	c.Assert(err, check.IsNil)
+	err = role.AddPermissions("app")
+	c.Assert(err, check.IsNil)
+	err = s.user.AddRole("app-owner", "myapp")
+	c.Assert(err, check.IsNil)
+	err = nativeScheme.DisableTwoFactor(s.user, "123456", codeAt(c, secret, 0))
+	c.Assert(err, check.Equals, ErrTwoFactorMandatory)
+}
+
+func (s *S) TestRemoveUserRemovesTwoFactor(c *check.C) {
+	s.enrollTwoFactor(c)
+	err := nativeScheme.Remove(s.user)
+	c.Assert(err, check.IsNil)
+	_, err = getTwoFactorAuth(s.user.Email)
+	c.Assert(err, check.Equals, ErrTwoFactorNotEnabled)
+}
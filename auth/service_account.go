@@ -0,0 +1,277 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/tsuru/db"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/quota"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var (
+	ErrInvalidServiceAccountName   = &tsuruErrors.ValidationError{Message: "invalid service account name"}
+	ErrServiceAccountAlreadyExists = errors.New("service account already exists")
+	ErrServiceAccountNotFound      = errors.New("service account not found")
+
+	// Names of service accounts can't contain "@", so they never clash with
+	// the email of users.
+	serviceAccountNameRegexp = regexp.MustCompile(`^[a-zA-Z][-_.\w]*$`)
+)
+
+// ServiceAccount is a non-human account owned by a team, used by automation
+// such as CI pipelines. Service accounts have their own roles and token, and
+// are also the token itself, being used to authenticate requests.
+type ServiceAccount struct {
+	Name        string         `bson:"_id" json:"name"`
+	Team        string         `json:"team"`
+	Description string         `json:"description"`
+	Token       string         `json:"token,omitempty" bson:",omitempty"`
+	Roles       []RoleInstance `json:"roles" bson:",omitempty"`
+	CreatedBy   string         `json:"created_by"`
+	Creation    time.Time      `json:"creation"`
+}
+
+func (sa *ServiceAccount) GetValue() string {
+	return sa.Token
+}
+
+// User returns a user representing the service account, which is not stored
+// in the database and has no quota limits.
+func (sa *ServiceAccount) User() (*User, error) {
+	return &User{
+		Email:          sa.Name,
+		Quota:          quota.Unlimited,
+		Roles:          sa.Roles,
+		serviceAccount: true,
+	}, nil
+}
+
+func (sa *ServiceAccount) IsAppToken() bool {
+	return false
+}
+
+func (sa *ServiceAccount) GetUserName() string {
+	return sa.Name
+}
+
+func (sa *ServiceAccount) GetAppName() string {
+	return ""
+}
+
+// Permissions returns the permissions granted by the roles of the service
+// account.
+func (sa *ServiceAccount) Permissions() ([]permission.Permission, error) {
+	return permissionsForRoles(sa.Roles)
+}
+
+// CreateServiceAccount stores the given service account, generating its
+// token. Name and Team must be filled.
+func CreateServiceAccount(sa *ServiceAccount) error {
+	if !serviceAccountNameRegexp.MatchString(sa.Name) {
+		return ErrInvalidServiceAccountName
+	}
+	_, err := GetTeam(sa.Team)
+	if err != nil {
+		return err
+	}
+	sa.Token, err = newTokenValue(sa.Team + "/" + sa.Name)
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	sa.Roles = nil
+	sa.Creation = time.Now().UTC()
+	err = conn.ServiceAccounts().Insert(sa)
+	if mgo.IsDup(err) {
+		return ErrServiceAccountAlreadyExists
+	}
+	return err
+}
+
+// GetServiceAccount returns the service account with the given name, without
+// its token.
+func GetServiceAccount(name string) (*ServiceAccount, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var sa ServiceAccount
+	err = conn.ServiceAccounts().FindId(name).Select(bson.M{"token": 0}).One(&sa)
+	if err == mgo.ErrNotFound {
+		return nil, ErrServiceAccountNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sa, nil
+}
+
+// ListServiceAccounts returns the service accounts owned by the given teams,
+// without their tokens.
+func ListServiceAccounts(teams []string) ([]ServiceAccount, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var accounts []ServiceAccount
+	query := bson.M{"team": bson.M{"$in": teams}}
+	err = conn.ServiceAccounts().Find(query).Select(bson.M{"token": 0}).Sort("_id").All(&accounts)
+	if err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+// RemoveServiceAccount removes the service account, invalidating its token.
+func RemoveServiceAccount(name string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.ServiceAccounts().RemoveId(name)
+	if err == mgo.ErrNotFound {
+		return ErrServiceAccountNotFound
+	}
+	return err
+}
+
+func removeTeamServiceAccounts(teamName string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.ServiceAccounts().RemoveAll(bson.M{"team": teamName})
+	return err
+}
+
+// RegenerateToken replaces the token of the service account, invalidating
+// the previous one.
+func (sa *ServiceAccount) RegenerateToken() (string, error) {
+	token, err := newTokenValue(sa.Team + "/" + sa.Name)
+	if err != nil {
+		return "", err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	err = conn.ServiceAccounts().UpdateId(sa.Name, bson.M{"$set": bson.M{"token": token}})
+	if err == mgo.ErrNotFound {
+		return "", ErrServiceAccountNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	sa.Token = token
+	return token, nil
+}
+
+func (sa *ServiceAccount) AddRole(roleName string, contextValue string) error {
+	_, err := permission.FindRole(roleName)
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.ServiceAccounts().UpdateId(sa.Name, bson.M{
+		"$addToSet": bson.M{
+			"roles": bson.D([]bson.DocElem{
+				{Name: "name", Value: roleName},
+				{Name: "contextvalue", Value: contextValue},
+			}),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return sa.reload()
+}
+
+func (sa *ServiceAccount) RemoveRole(roleName string, contextValue string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.ServiceAccounts().UpdateId(sa.Name, bson.M{
+		"$pull": bson.M{
+			"roles": bson.D([]bson.DocElem{
+				{Name: "name", Value: roleName},
+				{Name: "contextvalue", Value: contextValue},
+			}),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return sa.reload()
+}
+
+func (sa *ServiceAccount) reload() error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.ServiceAccounts().FindId(sa.Name).Select(bson.M{"token": 0}).One(sa)
+}
+
+func RemoveRoleFromAllServiceAccounts(roleName string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.ServiceAccounts().UpdateAll(bson.M{"roles.name": roleName}, bson.M{
+		"$pull": bson.M{
+			"roles": bson.M{"name": roleName},
+		},
+	})
+	return err
+}
+
+func getServiceAccountToken(header string) (*ServiceAccount, error) {
+	token, err := ParseToken(header)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var sa ServiceAccount
+	err = conn.ServiceAccounts().Find(bson.M{"token": token}).One(&sa)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	return &sa, nil
+}
+
+func ServiceAccountAuth(token string) (*ServiceAccount, error) {
+	return getServiceAccountToken(token)
+}
@@ -0,0 +1,168 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/quota"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestCreateServiceAccount(c *check.C) {
+	sa := ServiceAccount{Name: "ci", Team: s.team.Name, Description: "deploys from ci", CreatedBy: s.user.Email}
+	err := CreateServiceAccount(&sa)
+	c.Assert(err, check.IsNil)
+	c.Assert(sa.Token, check.Not(check.Equals), "")
+	c.Assert(sa.Creation.IsZero(), check.Equals, false)
+	var dbAccount ServiceAccount
+	err = s.conn.ServiceAccounts().FindId("ci").One(&dbAccount)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbAccount.Token, check.Equals, sa.Token)
+	c.Assert(dbAccount.Team, check.Equals, s.team.Name)
+	c.Assert(dbAccount.Description, check.Equals, "deploys from ci")
+	c.Assert(dbAccount.CreatedBy, check.Equals, s.user.Email)
+}
+
+func (s *S) TestCreateServiceAccountInvalidName(c *check.C) {
+	for _, name := range []string{"", "1ci", "ci bot", "ci@tsuru.io"} {
+		err := CreateServiceAccount(&ServiceAccount{Name: name, Team: s.team.Name})
+		c.Assert(err, check.Equals, ErrInvalidServiceAccountName, check.Commentf(name))
+	}
+}
+
+func (s *S) TestCreateServiceAccountTeamNotFound(c *check.C) {
+	err := CreateServiceAccount(&ServiceAccount{Name: "ci", Team: "unknown"})
+	c.Assert(err, check.Equals, ErrTeamNotFound)
+}
+
+func (s *S) TestCreateServiceAccountDuplicated(c *check.C) {
+	err := CreateServiceAccount(&ServiceAccount{Name: "ci", Team: s.team.Name})
+	c.Assert(err, check.IsNil)
+	err = CreateServiceAccount(&ServiceAccount{Name: "ci", Team: s.team.Name})
+	c.Assert(err, check.Equals, ErrServiceAccountAlreadyExists)
+}
+
+func (s *S) TestGetServiceAccount(c *check.C) {
+	err := CreateServiceAccount(&ServiceAccount{Name: "ci", Team: s.team.Name})
+	c.Assert(err, check.IsNil)
+	sa, err := GetServiceAccount("ci")
+	c.Assert(err, check.IsNil)
+	c.Assert(sa.Name, check.Equals, "ci")
+	c.Assert(sa.Team, check.Equals, s.team.Name)
+	c.Assert(sa.Token, check.Equals, "")
+	_, err = GetServiceAccount("unknown")
+	c.Assert(err, check.Equals, ErrServiceAccountNotFound)
+}
+
+func (s *S) TestListServiceAccounts(c *check.C) {
+	err := s.conn.Teams().Insert(Team{Name: "atreides"})
+	c.Assert(err, check.IsNil)
+	err = CreateServiceAccount(&ServiceAccount{Name: "deployer", Team: s.team.Name})
+	c.Assert(err, check.IsNil)
+	err = CreateServiceAccount(&ServiceAccount{Name: "ci", Team: s.team.Name})
+	c.Assert(err, check.IsNil)
+	err = CreateServiceAccount(&ServiceAccount{Name: "other", Team: "atreides"})
+	c.Assert(err, check.IsNil)
+	accounts, err := ListServiceAccounts([]string{s.team.Name})
+	c.Assert(err, check.IsNil)
+	c.Assert(accounts, check.HasLen, 2)
+	c.Assert(accounts[0].Name, check.Equals, "ci")
+	c.Assert(accounts[0].Token, check.Equals, "")
+	c.Assert(accounts[1].Name, check.Equals, "deployer")
+	c.Assert(accounts[1].Token, check.Equals, "")
+}
+
+func (s *S) TestRemoveServiceAccount(c *check.C) {
+	sa := ServiceAccount{Name: "ci", Team: s.team.Name}
+	err := CreateServiceAccount(&sa)
+	c.Assert(err, check.IsNil)
+	err = RemoveServiceAccount("ci")
+	c.Assert(err, check.IsNil)
+	_, err = ServiceAccountAuth("bearer " + sa.Token)
+	c.Assert(err, check.Equals, ErrInvalidToken)
+	err = RemoveServiceAccount("ci")
+	c.Assert(err, check.Equals, ErrServiceAccountNotFound)
+}
+
+func (s *S) TestRemoveTeamRemovesServiceAccounts(c *check.C) {
+	err := s.conn.Teams().Insert(Team{Name: "atreides"})
+	c.Assert(err, check.IsNil)
+	err = CreateServiceAccount(&ServiceAccount{Name: "ci", Team: "atreides"})
+	c.Assert(err, check.IsNil)
+	err = RemoveTeam("atreides")
+	c.Assert(err, check.IsNil)
+	_, err = GetServiceAccount("ci")
+	c.Assert(err, check.Equals, ErrServiceAccountNotFound)
+}
+
+func (s *S) TestServiceAccountAuth(c *check.C) {
+	sa := ServiceAccount{Name: "ci", Team: s.team.Name}
+	err := CreateServiceAccount(&sa)
+	c.Assert(err, check.IsNil)
+	t, err := ServiceAccountAuth("bearer " + sa.Token)
+	c.Assert(err, check.IsNil)
+	c.Assert(t.GetValue(), check.Equals, sa.Token)
+	c.Assert(t.GetUserName(), check.Equals, "ci")
+	c.Assert(t.IsAppToken(), check.Equals, false)
+	u, err := t.User()
+	c.Assert(err, check.IsNil)
+	c.Assert(u.Email, check.Equals, "ci")
+	c.Assert(u.Quota, check.Equals, quota.Unlimited)
+	c.Assert(u.IsServiceAccount(), check.Equals, true)
+	_, err = ServiceAccountAuth("bearer invalid")
+	c.Assert(err, check.Equals, ErrInvalidToken)
+}
+
+func (s *S) TestServiceAccountRegenerateToken(c *check.C) {
+	sa := ServiceAccount{Name: "ci", Team: s.team.Name}
+	err := CreateServiceAccount(&sa)
+	c.Assert(err, check.IsNil)
+	oldToken := sa.Token
+	newToken, err := sa.RegenerateToken()
+	c.Assert(err, check.IsNil)
+	c.Assert(newToken, check.Not(check.Equals), oldToken)
+	c.Assert(sa.Token, check.Equals, newToken)
+	_, err = ServiceAccountAuth("bearer " + oldToken)
+	c.Assert(err, check.Equals, ErrInvalidToken)
+	t, err := ServiceAccountAuth("bearer " + newToken)
+	c.Assert(err, check.IsNil)
+	c.Assert(t.Name, check.Equals, "ci")
+}
+
+func (s *S) TestServiceAccountRolesAndPermissions(c *check.C) {
+	r1, err := permission.NewRole("r1", "app", "")
+	c.Assert(err, check.IsNil)
+	err = r1.AddPermissions("app.deploy")
+	c.Assert(err, check.IsNil)
+	sa := ServiceAccount{Name: "ci", Team: s.team.Name}
+	err = CreateServiceAccount(&sa)
+	c.Assert(err, check.IsNil)
+	err = sa.AddRole("r1", "myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(sa.Roles, check.DeepEquals, []RoleInstance{{Name: "r1", ContextValue: "myapp"}})
+	perms, err := sa.Permissions()
+	c.Assert(err, check.IsNil)
+	c.Assert(perms, check.DeepEquals, []permission.Permission{
+		{Scheme: permission.PermAppDeploy, Context: permission.Context(permission.CtxApp, "myapp")},
+	})
+	err = sa.RemoveRole("r1", "myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(sa.Roles, check.HasLen, 0)
+	err = sa.AddRole("r1", "myapp")
+	c.Assert(err, check.IsNil)
+	err = RemoveRoleFromAllServiceAccounts("r1")
+	c.Assert(err, check.IsNil)
+	dbAccount, err := GetServiceAccount("ci")
+	c.Assert(err, check.IsNil)
+	c.Assert(dbAccount.Roles, check.HasLen, 0)
+}
+
+func (s *S) TestServiceAccountAddRoleNotFound(c *check.C) {
+	sa := ServiceAccount{Name: "ci", Team: s.team.Name}
+	err := CreateServiceAccount(&sa)
+	c.Assert(err, check.IsNil)
+	err = sa.AddRole("unknown", "myapp")
+	c.Assert(err, check.Equals, permission.ErrRoleNotFound)
+}
@@ -10,17 +10,21 @@ import (
 	"strings"
 
 	"github.com/pkg/errors"
+	"github.com/tsuru/tsuru/action"
 	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/db/storage"
 	"github.com/tsuru/tsuru/log"
 	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/validation"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
 var (
-	ErrInvalidTeamName   = errors.New("invalid team name")
-	ErrTeamAlreadyExists = errors.New("team already exists")
-	ErrTeamNotFound      = errors.New("team not found")
+	ErrInvalidTeamName         = errors.New("invalid team name")
+	ErrInvalidTeamContactEmail = errors.New("invalid team contact email")
+	ErrTeamAlreadyExists       = errors.New("team already exists")
+	ErrTeamNotFound            = errors.New("team not found")
 
 	teamNameRegexp = regexp.MustCompile(`^[a-zA-Z][-@_.+\w]+$`)
 )
@@ -38,9 +42,28 @@ func (e *ErrTeamStillUsed) Error() string {
 }
 
 // Team represents a real world team, a team has one creating user and a name.
+// Teams may also have some metadata, used to organize and contact them.
 type Team struct {
 	Name         string `bson:"_id" json:"name"`
 	CreatingUser string
+	Tags         []string `json:"tags"`
+	ContactEmail string   `json:"contact_email"`
+	CostCenter   string   `json:"cost_center"`
+}
+
+// TeamMember is a user with roles in the context of a team.
+type TeamMember struct {
+	Email string   `json:"email"`
+	Roles []string `json:"roles"`
+}
+
+// UpdateTeamOptions holds the changes applied by UpdateTeam. Nil fields are
+// left unchanged.
+type UpdateTeamOptions struct {
+	NewName      string
+	Tags         []string
+	ContactEmail *string
+	CostCenter   *string
 }
 
 // AllowedApps returns the apps that the team has access.
@@ -151,9 +174,36 @@ func RemoveTeam(teamName string) error {
 	if err == mgo.ErrNotFound {
 		return ErrTeamNotFound
 	}
+	err = removeTeamServiceAccounts(teamName)
+	if err != nil {
+		log.Errorf("unable to remove service accounts of team %q: %s", teamName, err)
+	}
+	err = removeTeamWebhooks(conn, teamName)
+	if err != nil {
+		log.Errorf("unable to remove webhooks of team %q: %s", teamName, err)
+	}
 	return nil
 }
 
+// removeTeamWebhooks removes the event webhooks owned by the team, along with
+// their delivery logs.
+func removeTeamWebhooks(conn *db.Storage, teamName string) error {
+	var hooks []string
+	err := conn.Webhooks().Find(bson.M{"teamowner": teamName}).Distinct("_id", &hooks)
+	if err != nil {
+		return err
+	}
+	if len(hooks) == 0 {
+		return nil
+	}
+	_, err = conn.WebhookDeliveries().RemoveAll(bson.M{"webhook": bson.M{"$in": hooks}})
+	if err != nil {
+		return err
+	}
+	_, err = conn.Webhooks().RemoveAll(bson.M{"_id": bson.M{"$in": hooks}})
+	return err
+}
+
 func ListTeams() ([]Team, error) {
 	conn, err := db.Conn()
 	if err != nil {
@@ -167,3 +217,174 @@ func ListTeams() ([]Team, error) {
 	}
 	return teams, nil
 }
+
+// UpdateTeam updates the metadata of the team and, when opts.NewName is set,
+// renames the team. Renaming cascades through every reference to the team:
+// apps, services, service instances, pools, service accounts, webhooks and the
+// contexts of team roles, and is rolled back if any step fails.
+func UpdateTeam(name string, opts UpdateTeamOptions) error {
+	team, err := GetTeam(name)
+	if err != nil {
+		return err
+	}
+	if opts.Tags != nil {
+		team.Tags = opts.Tags
+	}
+	if opts.ContactEmail != nil {
+		if *opts.ContactEmail != "" && !validation.ValidateEmail(*opts.ContactEmail) {
+			return ErrInvalidTeamContactEmail
+		}
+		team.ContactEmail = *opts.ContactEmail
+	}
+	if opts.CostCenter != nil {
+		team.CostCenter = *opts.CostCenter
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	newName := strings.TrimSpace(opts.NewName)
+	if newName == "" || newName == name {
+		err = conn.Teams().UpdateId(name, team)
+		if err == mgo.ErrNotFound {
+			return ErrTeamNotFound
+		}
+		return err
+	}
+	if !isTeamNameValid(newName) {
+		return ErrInvalidTeamName
+	}
+	team.Name = newName
+	actions := []*action.Action{&insertRenamedTeam, &renameTeamReferencesAction, &removeOldTeam}
+	return action.NewPipeline(actions...).Execute(name, team)
+}
+
+func renameTeamReferences(conn *db.Storage, oldName, newName string) error {
+	listUpdates := []struct {
+		coll  *storage.Collection
+		field string
+	}{
+		{conn.Apps(), "teams"},
+		{conn.Services(), "teams"},
+		{conn.Services(), "owner_teams"},
+		{conn.ServiceInstances(), "teams"},
+		{conn.Pools(), "teams"},
+	}
+	for _, u := range listUpdates {
+		_, err := u.coll.UpdateAll(
+			bson.M{u.field: oldName},
+			bson.M{"$set": bson.M{u.field + ".$": newName}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	fieldUpdates := []struct {
+		coll  *storage.Collection
+		field string
+	}{
+		{conn.Apps(), "teamowner"},
+		{conn.ServiceInstances(), "teamowner"},
+		{conn.ServiceAccounts(), "team"},
+		{conn.Webhooks(), "teamowner"},
+	}
+	for _, u := range fieldUpdates {
+		_, err := u.coll.UpdateAll(
+			bson.M{u.field: oldName},
+			bson.M{"$set": bson.M{u.field: newName}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	roleNames, err := teamRoleNames()
+	if err != nil {
+		return err
+	}
+	for _, coll := range []*storage.Collection{conn.Users(), conn.ServiceAccounts()} {
+		err = renameRoleContexts(coll, roleNames, oldName, newName)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func teamRoleNames() ([]string, error) {
+	roles, err := permission.ListRoles()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, r := range roles {
+		if r.ContextType == permission.CtxTeam {
+			names = append(names, r.Name)
+		}
+	}
+	return names, nil
+}
+
+// renameRoleContexts replaces the context value of team roles in the given
+// collection, which must hold documents with a roles field, like users and
+// service accounts.
+func renameRoleContexts(coll *storage.Collection, roleNames []string, oldName, newName string) error {
+	query := bson.M{"roles": bson.M{"$elemMatch": bson.M{
+		"name":         bson.M{"$in": roleNames},
+		"contextvalue": oldName,
+	}}}
+	var docs []struct {
+		ID    interface{} `bson:"_id"`
+		Roles []RoleInstance
+	}
+	err := coll.Find(query).Select(bson.M{"roles": 1}).All(&docs)
+	if err != nil {
+		return err
+	}
+	teamRoles := make(map[string]bool, len(roleNames))
+	for _, name := range roleNames {
+		teamRoles[name] = true
+	}
+	for _, doc := range docs {
+		for i, r := range doc.Roles {
+			if teamRoles[r.Name] && r.ContextValue == oldName {
+				doc.Roles[i].ContextValue = newName
+			}
+		}
+		err = coll.UpdateId(doc.ID, bson.M{"$set": bson.M{"roles": doc.Roles}})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListTeamMembers returns the users with roles in the context of the team,
+// along with the names of these roles.
+func ListTeamMembers(teamName string) ([]TeamMember, error) {
+	roleNames, err := teamRoleNames()
+	if err != nil {
+		return nil, err
+	}
+	teamRoles := make(map[string]bool, len(roleNames))
+	for _, name := range roleNames {
+		teamRoles[name] = true
+	}
+	users, err := listUsers(bson.M{"roles": bson.M{"$elemMatch": bson.M{
+		"name":         bson.M{"$in": roleNames},
+		"contextvalue": teamName,
+	}}})
+	if err != nil {
+		return nil, err
+	}
+	members := make([]TeamMember, len(users))
+	for i, u := range users {
+		members[i].Email = u.Email
+		for _, r := range u.Roles {
+			if teamRoles[r.Name] && r.ContextValue == teamName {
+				members[i].Roles = append(members[i].Roles, r.Name)
+			}
+		}
+	}
+	return members, nil
+}
@@ -5,8 +5,11 @@
 package auth
 
 import (
+	"errors"
 	"sort"
 
+	"github.com/tsuru/tsuru/action"
+	"github.com/tsuru/tsuru/permission"
 	"gopkg.in/check.v1"
 	"gopkg.in/mgo.v2/bson"
 )
@@ -116,6 +119,29 @@ func (s *S) TestRemoveTeam(c *check.C) {
 	c.Assert(t, check.IsNil)
 }
 
+func (s *S) TestRemoveTeamRemovesWebhooks(c *check.C) {
+	team := Team{Name: "atreides"}
+	err := s.conn.Teams().Insert(team)
+	c.Assert(err, check.IsNil)
+	err = s.conn.Webhooks().Insert(bson.M{"_id": "hook1", "teamowner": "atreides"})
+	c.Assert(err, check.IsNil)
+	err = s.conn.Webhooks().Insert(bson.M{"_id": "hook2", "teamowner": "harkonnen"})
+	c.Assert(err, check.IsNil)
+	err = s.conn.WebhookDeliveries().Insert(bson.M{"webhook": "hook1"}, bson.M{"webhook": "hook2"})
+	c.Assert(err, check.IsNil)
+	err = RemoveTeam(team.Name)
+	c.Assert(err, check.IsNil)
+	n, err := s.conn.Webhooks().Find(nil).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 1)
+	n, err = s.conn.WebhookDeliveries().Find(bson.M{"webhook": "hook1"}).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 0)
+	n, err = s.conn.WebhookDeliveries().Find(bson.M{"webhook": "hook2"}).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 1)
+}
+
 func (s *S) TestRemoveTeamWithApps(c *check.C) {
 	team := Team{Name: "atreides"}
 	err := s.conn.Teams().Insert(team)
@@ -148,3 +174,178 @@ func (s *S) TestListTeams(c *check.C) {
 	sort.Strings(names)
 	c.Assert(names, check.DeepEquals, []string{"cobrateam", "corrino", "fenring"})
 }
+
+func (s *S) TestUpdateTeamMetadata(c *check.C) {
+	err := s.conn.Teams().Insert(Team{Name: "atreides", CostCenter: "arrakis"})
+	c.Assert(err, check.IsNil)
+	contactEmail := "leto@atreides.com"
+	err = UpdateTeam("atreides", UpdateTeamOptions{
+		Tags:         []string{"great", "house"},
+		ContactEmail: &contactEmail,
+	})
+	c.Assert(err, check.IsNil)
+	team, err := GetTeam("atreides")
+	c.Assert(err, check.IsNil)
+	c.Assert(team, check.DeepEquals, &Team{
+		Name:         "atreides",
+		Tags:         []string{"great", "house"},
+		ContactEmail: "leto@atreides.com",
+		CostCenter:   "arrakis",
+	})
+}
+
+func (s *S) TestUpdateTeamInvalidContactEmail(c *check.C) {
+	err := s.conn.Teams().Insert(Team{Name: "atreides"})
+	c.Assert(err, check.IsNil)
+	contactEmail := "leto"
+	err = UpdateTeam("atreides", UpdateTeamOptions{ContactEmail: &contactEmail})
+	c.Assert(err, check.Equals, ErrInvalidTeamContactEmail)
+}
+
+func (s *S) TestUpdateTeamNotFound(c *check.C) {
+	err := UpdateTeam("unknown", UpdateTeamOptions{NewName: "other"})
+	c.Assert(err, check.Equals, ErrTeamNotFound)
+}
+
+func (s *S) TestUpdateTeamRenameInvalidName(c *check.C) {
+	err := s.conn.Teams().Insert(Team{Name: "atreides"})
+	c.Assert(err, check.IsNil)
+	err = UpdateTeam("atreides", UpdateTeamOptions{NewName: "1atreides"})
+	c.Assert(err, check.Equals, ErrInvalidTeamName)
+}
+
+func (s *S) TestUpdateTeamRenameDuplicate(c *check.C) {
+	err := s.conn.Teams().Insert(Team{Name: "atreides"})
+	c.Assert(err, check.IsNil)
+	err = UpdateTeam("atreides", UpdateTeamOptions{NewName: s.team.Name})
+	c.Assert(err, check.Equals, ErrTeamAlreadyExists)
+	_, err = GetTeam("atreides")
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestUpdateTeamRename(c *check.C) {
+	err := s.conn.Teams().Insert(Team{Name: "atreides", CreatingUser: "leto@atreides.com", Tags: []string{"house"}})
+	c.Assert(err, check.IsNil)
+	err = s.conn.Apps().Insert(bson.M{"name": "leto", "teams": []string{"corrino", "atreides"}, "teamowner": "atreides"})
+	c.Assert(err, check.IsNil)
+	err = s.conn.Services().Insert(bson.M{"_id": "spice", "teams": []string{"atreides"}, "owner_teams": []string{"atreides"}})
+	c.Assert(err, check.IsNil)
+	err = s.conn.ServiceInstances().Insert(bson.M{"name": "melange", "teams": []string{"atreides"}, "teamowner": "atreides"})
+	c.Assert(err, check.IsNil)
+	err = s.conn.Pools().Insert(bson.M{"_id": "caladan", "teams": []string{"atreides", "fenring"}})
+	c.Assert(err, check.IsNil)
+	err = s.conn.Webhooks().Insert(bson.M{"_id": "hook1", "teamowner": "atreides"})
+	c.Assert(err, check.IsNil)
+	teamRole, err := permission.NewRole("team-member", "team", "")
+	c.Assert(err, check.IsNil)
+	_, err = permission.NewRole("app-member", "app", "")
+	c.Assert(err, check.IsNil)
+	u := User{Email: "paul@atreides.com", Password: "123456"}
+	err = u.Create()
+	c.Assert(err, check.IsNil)
+	err = u.AddRole(teamRole.Name, "atreides")
+	c.Assert(err, check.IsNil)
+	err = u.AddRole(teamRole.Name, "fenring")
+	c.Assert(err, check.IsNil)
+	err = u.AddRole("app-member", "atreides")
+	c.Assert(err, check.IsNil)
+	sa := &ServiceAccount{Name: "ornithopter", Team: "atreides"}
+	err = CreateServiceAccount(sa)
+	c.Assert(err, check.IsNil)
+	err = sa.AddRole(teamRole.Name, "atreides")
+	c.Assert(err, check.IsNil)
+	err = UpdateTeam("atreides", UpdateTeamOptions{NewName: "muaddib"})
+	c.Assert(err, check.IsNil)
+	_, err = GetTeam("atreides")
+	c.Assert(err, check.Equals, ErrTeamNotFound)
+	team, err := GetTeam("muaddib")
+	c.Assert(err, check.IsNil)
+	c.Assert(team, check.DeepEquals, &Team{Name: "muaddib", CreatingUser: "leto@atreides.com", Tags: []string{"house"}})
+	var result bson.M
+	err = s.conn.Apps().Find(bson.M{"name": "leto"}).One(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result["teams"], check.DeepEquals, []interface{}{"corrino", "muaddib"})
+	c.Assert(result["teamowner"], check.Equals, "muaddib")
+	err = s.conn.Services().FindId("spice").One(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result["teams"], check.DeepEquals, []interface{}{"muaddib"})
+	c.Assert(result["owner_teams"], check.DeepEquals, []interface{}{"muaddib"})
+	err = s.conn.ServiceInstances().Find(bson.M{"name": "melange"}).One(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result["teams"], check.DeepEquals, []interface{}{"muaddib"})
+	c.Assert(result["teamowner"], check.Equals, "muaddib")
+	err = s.conn.Pools().FindId("caladan").One(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result["teams"], check.DeepEquals, []interface{}{"muaddib", "fenring"})
+	err = s.conn.Webhooks().FindId("hook1").One(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result["teamowner"], check.Equals, "muaddib")
+	dbUser, err := GetUserByEmail(u.Email)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbUser.Roles, check.DeepEquals, []RoleInstance{
+		{Name: "team-member", ContextValue: "muaddib"},
+		{Name: "team-member", ContextValue: "fenring"},
+		{Name: "app-member", ContextValue: "atreides"},
+	})
+	sa, err = GetServiceAccount("ornithopter")
+	c.Assert(err, check.IsNil)
+	c.Assert(sa.Team, check.Equals, "muaddib")
+	c.Assert(sa.Roles, check.DeepEquals, []RoleInstance{{Name: "team-member", ContextValue: "muaddib"}})
+}
+
+func (s *S) TestRenameTeamPipelineRollback(c *check.C) {
+	err := s.conn.Teams().Insert(Team{Name: "atreides"})
+	c.Assert(err, check.IsNil)
+	err = s.conn.Apps().Insert(bson.M{"name": "leto", "teams": []string{"atreides"}, "teamowner": "atreides"})
+	c.Assert(err, check.IsNil)
+	err = s.conn.Webhooks().Insert(bson.M{"_id": "hook1", "teamowner": "atreides"})
+	c.Assert(err, check.IsNil)
+	failure := action.Action{
+		Forward: func(ctx action.FWContext) (action.Result, error) {
+			return nil, errors.New("failed")
+		},
+	}
+	actions := []*action.Action{&insertRenamedTeam, &renameTeamReferencesAction, &failure}
+	err = action.NewPipeline(actions...).Execute("atreides", &Team{Name: "muaddib"})
+	c.Assert(err, check.ErrorMatches, "failed")
+	_, err = GetTeam("muaddib")
+	c.Assert(err, check.Equals, ErrTeamNotFound)
+	_, err = GetTeam("atreides")
+	c.Assert(err, check.IsNil)
+	var result bson.M
+	err = s.conn.Apps().Find(bson.M{"name": "leto"}).One(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result["teams"], check.DeepEquals, []interface{}{"atreides"})
+	c.Assert(result["teamowner"], check.Equals, "atreides")
+	err = s.conn.Webhooks().FindId("hook1").One(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result["teamowner"], check.Equals, "atreides")
+}
+
+func (s *S) TestListTeamMembers(c *check.C) {
+	teamRole, err := permission.NewRole("team-member", "team", "")
+	c.Assert(err, check.IsNil)
+	_, err = permission.NewRole("team-admin", "team", "")
+	c.Assert(err, check.IsNil)
+	_, err = permission.NewRole("app-member", "app", "")
+	c.Assert(err, check.IsNil)
+	u1 := User{Email: "paul@atreides.com", Password: "123456"}
+	err = u1.Create()
+	c.Assert(err, check.IsNil)
+	err = u1.AddRole(teamRole.Name, "atreides")
+	c.Assert(err, check.IsNil)
+	err = u1.AddRole("team-admin", "atreides")
+	c.Assert(err, check.IsNil)
+	u2 := User{Email: "feyd@harkonnen.com", Password: "123456"}
+	err = u2.Create()
+	c.Assert(err, check.IsNil)
+	err = u2.AddRole(teamRole.Name, "harkonnen")
+	c.Assert(err, check.IsNil)
+	err = u2.AddRole("app-member", "atreides")
+	c.Assert(err, check.IsNil)
+	members, err := ListTeamMembers("atreides")
+	c.Assert(err, check.IsNil)
+	c.Assert(members, check.DeepEquals, []TeamMember{
+		{Email: "paul@atreides.com", Roles: []string{"team-member", "team-admin"}},
+	})
+}
@@ -0,0 +1,71 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/permission"
+)
+
+var (
+	ErrTwoFactorCodeRequired       = &tsuruErrors.ValidationError{Message: "two-factor authentication code required"}
+	ErrTwoFactorEnrollmentRequired = &tsuruErrors.NotAuthorizedError{Message: "two-factor authentication is mandatory for this user, enroll before logging in"}
+)
+
+// TwoFactorScheme is implemented by schemes supporting a second
+// authentication factor, based on time-based one-time passwords (TOTP).
+//
+// Enrollment happens in two steps: StartTwoFactorEnrollment generates the
+// secret to be added to an authenticator application, and
+// ConfirmTwoFactorEnrollment enables it after checking a code generated by the
+// application, returning a list of single use recovery codes. CheckPassword
+// allows requests to be authenticated before any of these operations starts.
+type TwoFactorScheme interface {
+	Scheme
+	CheckPassword(user *User, password string) error
+	StartTwoFactorEnrollment(user *User, password string) (*TwoFactorEnrollment, error)
+	ConfirmTwoFactorEnrollment(user *User, password, code string) ([]string, error)
+	DisableTwoFactor(user *User, password, code string) error
+}
+
+// TwoFactorEnrollment holds the secret of a pending two-factor enrollment,
+// along with the otpauth URI, usually displayed as a QR code.
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// TwoFactorRequired checks whether the admin policy mandates two-factor
+// authentication for the given user. The policy is defined by the setting
+// auth:two-factor:required-permissions, a list of permissions considered
+// sensitive: users holding any of them, any permission under them or any
+// permission above them, in any context, must enroll.
+func TwoFactorRequired(u *User) (bool, error) {
+	names, err := config.GetList("auth:two-factor:required-permissions")
+	if err != nil || len(names) == 0 {
+		return false, nil
+	}
+	schemes := make([]*permission.PermissionScheme, len(names))
+	for i, name := range names {
+		schemes[i], err = permission.SafeGet(name)
+		if err != nil {
+			return false, errors.Wrapf(err, "invalid permission %q in auth:two-factor:required-permissions", name)
+		}
+	}
+	perms, err := u.Permissions()
+	if err != nil {
+		return false, err
+	}
+	for _, p := range perms {
+		for _, scheme := range schemes {
+			if p.Scheme.IsParent(scheme) || scheme.IsParent(p.Scheme) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,70 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestTwoFactorRequired(c *check.C) {
+	config.Set("auth:two-factor:required-permissions", []interface{}{"node", "app.admin"})
+	defer config.Unset("auth:two-factor:required-permissions")
+	u := User{Email: "me@tsuru.com", Password: "123"}
+	err := u.Create()
+	c.Assert(err, check.IsNil)
+	required, err := TwoFactorRequired(&u)
+	c.Assert(err, check.IsNil)
+	c.Assert(required, check.Equals, false)
+	r1, err := permission.NewRole("r1", "app", "")
+	c.Assert(err, check.IsNil)
+	err = r1.AddPermissions("app.deploy")
+	c.Assert(err, check.IsNil)
+	err = u.AddRole("r1", "myapp")
+	c.Assert(err, check.IsNil)
+	required, err = TwoFactorRequired(&u)
+	c.Assert(err, check.IsNil)
+	c.Assert(required, check.Equals, false)
+	r2, err := permission.NewRole("r2", "app", "")
+	c.Assert(err, check.IsNil)
+	err = r2.AddPermissions("app.admin.quota")
+	c.Assert(err, check.IsNil)
+	err = u.AddRole("r2", "myapp")
+	c.Assert(err, check.IsNil)
+	required, err = TwoFactorRequired(&u)
+	c.Assert(err, check.IsNil)
+	c.Assert(required, check.Equals, true)
+}
+
+func (s *S) TestTwoFactorRequiredParentPermission(c *check.C) {
+	config.Set("auth:two-factor:required-permissions", []interface{}{"node"})
+	defer config.Unset("auth:two-factor:required-permissions")
+	u := User{Email: "me@tsuru.com", Password: "123"}
+	err := u.Create()
+	c.Assert(err, check.IsNil)
+	r1, err := permission.NewRole("r1", "global", "")
+	c.Assert(err, check.IsNil)
+	err = r1.AddPermissions("*")
+	c.Assert(err, check.IsNil)
+	err = u.AddRole("r1", "")
+	c.Assert(err, check.IsNil)
+	required, err := TwoFactorRequired(&u)
+	c.Assert(err, check.IsNil)
+	c.Assert(required, check.Equals, true)
+}
+
+func (s *S) TestTwoFactorRequiredNoPolicy(c *check.C) {
+	required, err := TwoFactorRequired(s.user)
+	c.Assert(err, check.IsNil)
+	c.Assert(required, check.Equals, false)
+}
+
+func (s *S) TestTwoFactorRequiredInvalidPermission(c *check.C) {
+	config.Set("auth:two-factor:required-permissions", []interface{}{"node.fly"})
+	defer config.Unset("auth:two-factor:required-permissions")
+	_, err := TwoFactorRequired(s.user)
+	c.Assert(err, check.ErrorMatches, `invalid permission "node.fly" in auth:two-factor:required-permissions: .*`)
+}
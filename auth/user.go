@@ -40,6 +40,15 @@ type User struct {
 	Password string
 	APIKey   string
 	Roles    []RoleInstance `bson:",omitempty"`
+
+	serviceAccount bool
+}
+
+// IsServiceAccount returns whether the user represents a service account,
+// being returned by ServiceAccount.User. These users are not stored in the
+// database.
+func (u *User) IsServiceAccount() bool {
+	return u.serviceAccount
 }
 
 func listUsers(filter bson.M) ([]User, error) {
@@ -145,6 +154,10 @@ func (u *User) Delete() error {
 	if err != nil {
 		log.Errorf("failed to remove user %q from the database: %s", u.Email, err)
 	}
+	_, err = conn.AccessTokens().RemoveAll(bson.M{"useremail": u.Email})
+	if err != nil {
+		log.Errorf("failed to remove access tokens of user %q from the database: %s", u.Email, err)
+	}
 	err = repository.Manager().RemoveUser(u.Email)
 	if err != nil {
 		log.Errorf("failed to remove user %q from the repository manager: %s", u.Email, err)
@@ -209,17 +222,25 @@ func (u *User) ShowAPIKey() (string, error) {
 }
 
 func (u *User) RegenerateAPIKey() (string, error) {
-	random_byte := make([]byte, 32)
-	_, err := rand.Read(random_byte)
+	apiKey, err := newTokenValue(u.Email)
+	if err != nil {
+		return "", err
+	}
+	u.APIKey = apiKey
+	return u.APIKey, u.Update()
+}
+
+func newTokenValue(seed string) (string, error) {
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
 	if err != nil {
 		return "", err
 	}
 	h := crypto.SHA256.New()
-	h.Write([]byte(u.Email))
-	h.Write(random_byte)
+	h.Write([]byte(seed))
+	h.Write(randomBytes)
 	h.Write([]byte(time.Now().Format(time.RFC3339Nano)))
-	u.APIKey = fmt.Sprintf("%x", h.Sum(nil))
-	return u.APIKey, u.Update()
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
 }
 
 func (u *User) Reload() error {
@@ -235,8 +256,17 @@ func (u *User) Permissions() ([]permission.Permission, error) {
 	permissions := []permission.Permission{
 		{Scheme: permission.PermUser, Context: permission.Context(permission.CtxUser, u.Email)},
 	}
+	rolePermissions, err := permissionsForRoles(u.Roles)
+	if err != nil {
+		return nil, err
+	}
+	return append(permissions, rolePermissions...), nil
+}
+
+func permissionsForRoles(roleInstances []RoleInstance) ([]permission.Permission, error) {
+	var permissions []permission.Permission
 	roles := make(map[string]*permission.Role)
-	for _, roleData := range u.Roles {
+	for _, roleData := range roleInstances {
 		role := roles[roleData.Name]
 		if role == nil {
 			foundRole, err := permission.FindRole(roleData.Name)
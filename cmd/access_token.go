@@ -0,0 +1,185 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tsuru/gnuflag"
+)
+
+// APIAccessToken is a named access token in the tsuru API.
+type APIAccessToken struct {
+	Name        string    `json:"name"`
+	Token       string    `json:"token"`
+	Description string    `json:"description"`
+	Creation    time.Time `json:"creation"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	LastAccess  time.Time `json:"last_access"`
+	Permissions []string  `json:"permissions"`
+}
+
+func formatAccessTokenTime(t time.Time, empty string) string {
+	if t.IsZero() {
+		return empty
+	}
+	return t.Local().Format(time.RFC822)
+}
+
+type accessTokenList struct{}
+
+func (accessTokenList) Info() *Info {
+	return &Info{
+		Name:  "access-token-list",
+		Usage: "access-token-list",
+		Desc:  "Lists the access tokens of the current user.",
+	}
+}
+
+func (accessTokenList) Run(context *Context, client *Client) error {
+	u, err := GetURL("/users/access-tokens")
+	if err != nil {
+		return err
+	}
+	request, _ := http.NewRequest("GET", u, nil)
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		fmt.Fprintln(context.Stdout, "No access tokens available.")
+		return nil
+	}
+	var tokens []APIAccessToken
+	err = json.NewDecoder(resp.Body).Decode(&tokens)
+	if err != nil {
+		return err
+	}
+	table := NewTable()
+	table.Headers = Row{"Name", "Description", "Permissions", "Expires", "Last access"}
+	for _, t := range tokens {
+		permissions := "*"
+		if len(t.Permissions) > 0 {
+			permissions = strings.Join(t.Permissions, "\n")
+		}
+		table.AddRow(Row{
+			t.Name,
+			t.Description,
+			permissions,
+			formatAccessTokenTime(t.ExpiresAt, "never"),
+			formatAccessTokenTime(t.LastAccess, "-"),
+		})
+	}
+	context.Stdout.Write(table.Bytes())
+	return nil
+}
+
+type accessTokenCreate struct {
+	fs          *gnuflag.FlagSet
+	description string
+	expires     time.Duration
+	permissions StringSliceFlag
+}
+
+func (c *accessTokenCreate) Info() *Info {
+	return &Info{
+		Name:  "access-token-create",
+		Usage: "access-token-create <name> [-d/--description description] [-e/--expires duration] [-p/--permission permission]...",
+		Desc: `Creates a new access token for the current user. The value of the token is
+only displayed once, it should be stored in a safe place.
+
+Access tokens are meant to be used by scripts and CI pipelines, by setting the
+TSURU_TOKEN environment variable. They may be limited to some permissions of
+the user, with the [[--permission]] flag, which may be used multiple times.
+
+The [[--expires]] flag defines how long the token is valid, in the format
+accepted by Go's time.ParseDuration, e.g.: 720h. By default the token never
+expires.`,
+		MinArgs: 1,
+		MaxArgs: 1,
+	}
+}
+
+func (c *accessTokenCreate) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("access-token-create", gnuflag.ExitOnError)
+		descriptionMessage := "A description of the access token"
+		c.fs.StringVar(&c.description, "description", "", descriptionMessage)
+		c.fs.StringVar(&c.description, "d", "", descriptionMessage)
+		expiresMessage := "How long the access token is valid"
+		c.fs.DurationVar(&c.expires, "expires", 0, expiresMessage)
+		c.fs.DurationVar(&c.expires, "e", 0, expiresMessage)
+		permissionMessage := "A permission of the access token, may be used multiple times"
+		c.fs.Var(&c.permissions, "permission", permissionMessage)
+		c.fs.Var(&c.permissions, "p", permissionMessage)
+	}
+	return c.fs
+}
+
+func (c *accessTokenCreate) Run(context *Context, client *Client) error {
+	u, err := GetURL("/users/access-tokens")
+	if err != nil {
+		return err
+	}
+	v := url.Values{}
+	v.Set("name", context.Args[0])
+	v.Set("description", c.description)
+	if c.expires > 0 {
+		v.Set("expires", c.expires.String())
+	}
+	for _, p := range c.permissions {
+		v.Add("permission", p)
+	}
+	request, err := http.NewRequest("POST", u, strings.NewReader(v.Encode()))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var token APIAccessToken
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "Access token %q successfully created: %s\n", token.Name, token.Token)
+	return nil
+}
+
+type accessTokenRevoke struct{}
+
+func (accessTokenRevoke) Info() *Info {
+	return &Info{
+		Name:    "access-token-revoke",
+		Usage:   "access-token-revoke <name>",
+		Desc:    "Revokes an access token of the current user.",
+		MinArgs: 1,
+		MaxArgs: 1,
+	}
+}
+
+func (accessTokenRevoke) Run(context *Context, client *Client) error {
+	u, err := GetURL("/users/access-tokens/" + url.QueryEscape(context.Args[0]))
+	if err != nil {
+		return err
+	}
+	request, _ := http.NewRequest("DELETE", u, nil)
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	fmt.Fprintf(context.Stdout, "Access token %q successfully revoked.\n", context.Args[0])
+	return nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"github.com/tsuru/tsuru/cmd/cmdtest"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestAccessTokenListRun(c *check.C) {
+	defer func(l *time.Location) { time.Local = l }(time.Local)
+	time.Local = time.UTC
+	var called bool
+	expected := `+------+-------------+----------------+---------------------+-------------+
+| Name | Description | Permissions    | Expires             | Last access |
++------+-------------+----------------+---------------------+-------------+
+| ci   | deploys     | app.deploy     | 01 Jun 17 10:30 UTC | -           |
+|      |             | app.update.env |                     |             |
+| dev  |             | *              | never               | -           |
++------+-------------+----------------+---------------------+-------------+
+`
+	context := Context{[]string{}, globalManager.stdout, globalManager.stderr, globalManager.stdin}
+	command := accessTokenList{}
+	transport := cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{
+			Message: `[
+	{"name":"ci","description":"deploys","expires_at":"2017-06-01T10:30:00Z","permissions":["app.deploy","app.update.env"]},
+	{"name":"dev","expires_at":"0001-01-01T00:00:00Z"}
+]`,
+			Status: http.StatusOK,
+		},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			return req.Method == "GET" && req.URL.Path == "/1.0/users/access-tokens"
+		},
+	}
+	client := NewClient(&http.Client{Transport: &transport}, nil, globalManager)
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(globalManager.stdout.(*bytes.Buffer).String(), check.Equals, expected)
+	c.Assert(called, check.Equals, true)
+}
+
+func (s *S) TestAccessTokenListRunEmpty(c *check.C) {
+	context := Context{[]string{}, globalManager.stdout, globalManager.stderr, globalManager.stdin}
+	command := accessTokenList{}
+	transport := cmdtest.Transport{Status: http.StatusNoContent}
+	client := NewClient(&http.Client{Transport: &transport}, nil, globalManager)
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(globalManager.stdout.(*bytes.Buffer).String(), check.Equals, "No access tokens available.\n")
+}
+
+func (s *S) TestAccessTokenCreateRun(c *check.C) {
+	var called bool
+	context := Context{[]string{"ci"}, globalManager.stdout, globalManager.stderr, globalManager.stdin}
+	command := accessTokenCreate{}
+	command.Flags().Parse(true, []string{"-d", "deploys", "--expires", "720h", "-p", "app.deploy", "-p", "app.update.env"})
+	transport := cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{
+			Message: `{"name":"ci","token":"abc123"}`,
+			Status:  http.StatusCreated,
+		},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			req.ParseForm()
+			return req.Method == "POST" && req.URL.Path == "/1.0/users/access-tokens" &&
+				req.Header.Get("Content-Type") == "application/x-www-form-urlencoded" &&
+				req.FormValue("name") == "ci" &&
+				req.FormValue("description") == "deploys" &&
+				req.FormValue("expires") == "720h0m0s" &&
+				len(req.Form["permission"]) == 2 &&
+				req.Form["permission"][0] == "app.deploy" &&
+				req.Form["permission"][1] == "app.update.env"
+		},
+	}
+	client := NewClient(&http.Client{Transport: &transport}, nil, globalManager)
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(called, check.Equals, true)
+	c.Assert(globalManager.stdout.(*bytes.Buffer).String(), check.Equals, "Access token \"ci\" successfully created: abc123\n")
+}
+
+func (s *S) TestAccessTokenRevokeRun(c *check.C) {
+	var called bool
+	context := Context{[]string{"ci"}, globalManager.stdout, globalManager.stderr, globalManager.stdin}
+	command := accessTokenRevoke{}
+	transport := cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			return req.Method == "DELETE" && req.URL.Path == "/1.0/users/access-tokens/ci"
+		},
+	}
+	client := NewClient(&http.Client{Transport: &transport}, nil, globalManager)
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(called, check.Equals, true)
+	c.Assert(globalManager.stdout.(*bytes.Buffer).String(), check.Equals, "Access token \"ci\" successfully revoked.\n")
+}
@@ -43,19 +43,17 @@ func nativeLogin(context *Context, client *Client) error {
 		return err
 	}
 	fmt.Fprintln(context.Stdout)
-	u, err := GetURL("/users/" + email + "/tokens")
-	if err != nil {
-		return err
-	}
 	v := url.Values{}
 	v.Set("password", password)
-	b := strings.NewReader(v.Encode())
-	request, err := http.NewRequest("POST", u, b)
-	if err != nil {
-		return err
+	response, err := postUserForm(client, "/users/"+email+"/tokens", v)
+	if err != nil && response != nil && response.Header.Get(twoFactorHeader) == "required" {
+		fmt.Fprint(context.Stdout, "Two-factor code: ")
+		var code string
+		fmt.Fscanf(context.Stdin, "%s\n", &code)
+		fmt.Fprintln(context.Stdout)
+		v.Set("otp", code)
+		response, err = postUserForm(client, "/users/"+email+"/tokens", v)
 	}
-	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	response, err := client.Do(request)
 	if err != nil {
 		return err
 	}
@@ -73,6 +71,21 @@ func nativeLogin(context *Context, client *Client) error {
 	return writeToken(out["token"].(string))
 }
 
+// postUserForm posts the given form to an endpoint that doesn't require the
+// user to be logged in.
+func postUserForm(client *Client, path string, v url.Values) (*http.Response, error) {
+	u, err := GetURL(path)
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest("POST", u, strings.NewReader(v.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return client.Do(request)
+}
+
 func (c *login) getScheme() *loginScheme {
 	if c.scheme == nil {
 		info, err := schemeInfo()
@@ -83,6 +83,51 @@ func (s *S) TestNativeLoginWithoutEmailFromArg(c *check.C) {
 	c.Assert(token, check.Equals, "sometoken")
 }
 
+func (s *S) TestNativeLoginWithTwoFactor(c *check.C) {
+	os.Unsetenv("TSURU_TOKEN")
+	nativeScheme()
+	fsystem = &fstest.RecordingFs{FileContent: "old-token"}
+	defer func() {
+		fsystem = nil
+	}()
+	expected := "Password: \nTwo-factor code: \nSuccessfully logged in!\n"
+	reader := strings.NewReader("chico\n123456\n")
+	context := Context{[]string{"foo@foo.com"}, globalManager.stdout, globalManager.stderr, reader}
+	transport := cmdtest.MultiConditionalTransport{
+		ConditionalTransports: []cmdtest.ConditionalTransport{
+			{
+				Transport: cmdtest.Transport{
+					Message: "two-factor authentication code required",
+					Status:  http.StatusBadRequest,
+					Headers: map[string][]string{"Tsuru-Two-Factor": {"required"}},
+				},
+				CondFunc: func(r *http.Request) bool {
+					return r.FormValue("password") == "chico" && r.FormValue("otp") == ""
+				},
+			},
+			{
+				Transport: cmdtest.Transport{
+					Message: `{"token": "sometoken"}`,
+					Status:  http.StatusOK,
+				},
+				CondFunc: func(r *http.Request) bool {
+					return r.URL.Path == "/1.0/users/foo@foo.com/tokens" &&
+						r.FormValue("password") == "chico" &&
+						r.FormValue("otp") == "123456"
+				},
+			},
+		},
+	}
+	client := NewClient(&http.Client{Transport: &transport}, nil, globalManager)
+	command := login{}
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(globalManager.stdout.(*bytes.Buffer).String(), check.Equals, expected)
+	token, err := ReadToken()
+	c.Assert(err, check.IsNil)
+	c.Assert(token, check.Equals, "sometoken")
+}
+
 func (s *S) TestNativeLoginShouldNotDependOnTsuruTokenFile(c *check.C) {
 	nativeScheme()
 	rfs := &fstest.RecordingFs{}
@@ -79,6 +79,11 @@ func BuildBaseManager(name, version, versionHeader string, lookup Lookup) *Manag
 	m.Register(&targetRemove{})
 	m.Register(&targetSet{})
 	m.Register(userInfo{})
+	m.Register(accessTokenList{})
+	m.Register(&accessTokenCreate{})
+	m.Register(accessTokenRevoke{})
+	m.Register(twoFactorEnable{})
+	m.Register(twoFactorDisable{})
 	m.RegisterTopic("target", fmt.Sprintf(targetTopic, name))
 	return m
 }
@@ -652,6 +652,29 @@ func (s *S) TestUserInfoIsRegisteredByBaseManager(c *check.C) {
 	c.Assert(info, check.FitsTypeOf, userInfo{})
 }
 
+func (s *S) TestAccessTokenCommandsAreRegisteredByBaseManager(c *check.C) {
+	mngr := BuildBaseManager("tsuru", "1.0", "", nil)
+	list, ok := mngr.Commands["access-token-list"]
+	c.Assert(ok, check.Equals, true)
+	c.Assert(list, check.FitsTypeOf, accessTokenList{})
+	create, ok := mngr.Commands["access-token-create"]
+	c.Assert(ok, check.Equals, true)
+	c.Assert(create, check.FitsTypeOf, &accessTokenCreate{})
+	revoke, ok := mngr.Commands["access-token-revoke"]
+	c.Assert(ok, check.Equals, true)
+	c.Assert(revoke, check.FitsTypeOf, accessTokenRevoke{})
+}
+
+func (s *S) TestTwoFactorCommandsAreRegisteredByBaseManager(c *check.C) {
+	mngr := BuildBaseManager("tsuru", "1.0", "", nil)
+	enable, ok := mngr.Commands["two-factor-enable"]
+	c.Assert(ok, check.Equals, true)
+	c.Assert(enable, check.FitsTypeOf, twoFactorEnable{})
+	disable, ok := mngr.Commands["two-factor-disable"]
+	c.Assert(ok, check.Equals, true)
+	c.Assert(disable, check.FitsTypeOf, twoFactorDisable{})
+}
+
 func (s *S) TestInvalidCommandFuzzyMatch01(c *check.C) {
 	mngr := BuildBaseManager("tsuru", "1.0", "", nil)
 	var stdout, stderr bytes.Buffer
@@ -704,6 +727,7 @@ func (s *S) TestInvalidCommandFuzzyMatch03(c *check.C) {
 	expectedOutput := `.*: "list" is not a tsuru command. See "tsuru help".
 
 Did you mean?
+	access-token-list
 	target-list
 `
 	expectedOutput = strings.Replace(expectedOutput, "\n", "\\W", -1)
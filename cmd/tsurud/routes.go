@@ -0,0 +1,88 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tsuru/gnuflag"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/cmd"
+	"github.com/tsuru/tsuru/router/rebuild"
+)
+
+type routesCheckCmd struct {
+	fs  *gnuflag.FlagSet
+	fix bool
+}
+
+func (*routesCheckCmd) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "routes-check",
+		Usage: "routes-check [--fix]",
+		Desc: `Compares the routes and cnames of every app with the ones found in their
+routers, listing the inconsistencies per router and the backends kept for apps
+that no longer exist. With the --fix flag, a routes rebuild task is enqueued for
+each inconsistent app.`,
+	}
+}
+
+func (c *routesCheckCmd) Run(context *cmd.Context, client *cmd.Client) error {
+	report, err := app.CheckRoutes(c.fix)
+	if err != nil {
+		return err
+	}
+	if report.Empty() {
+		fmt.Fprintln(context.Stdout, "No inconsistencies found.")
+		return nil
+	}
+	if len(report.Routers) > 0 {
+		t := cmd.Table{Headers: cmd.Row([]string{"Router", "App", "Inconsistencies"})}
+		for _, r := range report.Routers {
+			for _, d := range r.Apps {
+				t.AddRow(cmd.Row([]string{r.Router, d.App, driftDescription(d)}))
+			}
+			for appName, errMsg := range r.Errors {
+				t.AddRow(cmd.Row([]string{r.Router, appName, "error: " + errMsg}))
+			}
+		}
+		t.LineSeparator = true
+		t.Sort()
+		context.Stdout.Write(t.Bytes())
+	}
+	if len(report.OrphanBackends) > 0 {
+		fmt.Fprintf(context.Stdout, "Backends of removed apps: %s\n", strings.Join(report.OrphanBackends, ", "))
+	}
+	if len(report.Enqueued) > 0 {
+		fmt.Fprintf(context.Stdout, "Routes rebuild enqueued for: %s\n", strings.Join(report.Enqueued, ", "))
+	}
+	return nil
+}
+
+func driftDescription(d rebuild.RoutesDrift) string {
+	if d.MissingBackend {
+		return "missing backend"
+	}
+	var lines []string
+	add := func(label string, values []string) {
+		if len(values) > 0 {
+			lines = append(lines, fmt.Sprintf("%s: %s", label, strings.Join(values, ", ")))
+		}
+	}
+	add("missing routes", d.MissingRoutes)
+	add("stale routes", d.StaleRoutes)
+	add("missing cnames", d.MissingCNames)
+	add("stale cnames", d.StaleCNames)
+	return strings.Join(lines, "\n")
+}
+
+func (c *routesCheckCmd) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("routes-check", gnuflag.ExitOnError)
+		c.fs.BoolVar(&c.fix, "fix", false, "Enqueue a routes rebuild for each inconsistent app")
+	}
+	return c.fs
+}
@@ -0,0 +1,53 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+
+	"github.com/tsuru/tsuru/cmd"
+	"github.com/tsuru/tsuru/router"
+	"github.com/tsuru/tsuru/router/rebuild"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestRoutesCheckCmdInfo(c *check.C) {
+	c.Assert((&routesCheckCmd{}).Info(), check.NotNil)
+}
+
+func (s *S) TestRoutesCheckCmdIsRegistered(c *check.C) {
+	manager := buildManager()
+	command, ok := manager.Commands["routes-check"]
+	c.Assert(ok, check.Equals, true)
+	routesCheck, ok := command.(*tsurudCommand)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(routesCheck.Command, check.FitsTypeOf, &routesCheckCmd{})
+}
+
+func (s *S) TestRoutesCheckCmdRunNoInconsistencies(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	err := (&routesCheckCmd{}).Run(&context, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Equals, "No inconsistencies found.\n")
+}
+
+func (s *S) TestRoutesCheckCmdRunOrphanBackends(c *check.C) {
+	err := router.Store("ghost", "ghost", "fake")
+	c.Assert(err, check.IsNil)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	err = (&routesCheckCmd{}).Run(&context, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Equals, "Backends of removed apps: ghost\n")
+}
+
+func (s *S) TestDriftDescription(c *check.C) {
+	c.Assert(driftDescription(rebuild.RoutesDrift{MissingBackend: true}), check.Equals, "missing backend")
+	c.Assert(driftDescription(rebuild.RoutesDrift{
+		MissingRoutes: []string{"http://10.0.0.1:80", "http://10.0.0.2:80"},
+		StaleCNames:   []string{"my.cname.com"},
+	}), check.Equals, "missing routes: http://10.0.0.1:80, http://10.0.0.2:80\nstale cnames: my.cname.com")
+}
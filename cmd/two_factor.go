@@ -0,0 +1,132 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// twoFactorHeader is set by the tsuru API in login responses when the user
+// must provide a two-factor authentication code.
+const twoFactorHeader = "Tsuru-Two-Factor"
+
+func twoFactorCredentials(context *Context) (string, string, error) {
+	var email string
+	if len(context.Args) > 0 {
+		email = context.Args[0]
+	} else {
+		fmt.Fprint(context.Stdout, "Email: ")
+		fmt.Fscanf(context.Stdin, "%s\n", &email)
+	}
+	fmt.Fprint(context.Stdout, "Password: ")
+	password, err := PasswordFromReader(context.Stdin)
+	if err != nil {
+		return "", "", err
+	}
+	fmt.Fprintln(context.Stdout)
+	return email, password, nil
+}
+
+type twoFactorEnable struct{}
+
+func (twoFactorEnable) Info() *Info {
+	return &Info{
+		Name:  "two-factor-enable",
+		Usage: "two-factor-enable [email]",
+		Desc: `Enables two-factor authentication for a user of the tsuru native
+authentication scheme. It displays a secret that must be added to an
+authenticator application, and asks for a code generated by the application to
+confirm the enrollment.
+
+After that, the user is asked for a code from the authenticator application on
+every login. A list of recovery codes is displayed once, each of them may be
+used once in place of a code.`,
+		MinArgs: 0,
+		MaxArgs: 1,
+	}
+}
+
+func (twoFactorEnable) Run(context *Context, client *Client) error {
+	email, password, err := twoFactorCredentials(context)
+	if err != nil {
+		return err
+	}
+	v := url.Values{}
+	v.Set("password", password)
+	resp, err := postUserForm(client, "/users/"+email+"/two-factor", v)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var enrollment struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&enrollment)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "Add the following secret to your authenticator application: %s\n", enrollment.Secret)
+	fmt.Fprintf(context.Stdout, "Or use the URI: %s\n", enrollment.URI)
+	fmt.Fprint(context.Stdout, "Two-factor code: ")
+	var code string
+	fmt.Fscanf(context.Stdin, "%s\n", &code)
+	fmt.Fprintln(context.Stdout)
+	v.Set("otp", code)
+	resp, err = postUserForm(client, "/users/"+email+"/two-factor/confirm", v)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(context.Stdout, "Two-factor authentication successfully enabled. Store the following recovery codes in a safe place:")
+	for _, code := range result.RecoveryCodes {
+		fmt.Fprintln(context.Stdout, code)
+	}
+	return nil
+}
+
+type twoFactorDisable struct{}
+
+func (twoFactorDisable) Info() *Info {
+	return &Info{
+		Name:  "two-factor-disable",
+		Usage: "two-factor-disable [email]",
+		Desc: `Disables two-factor authentication for a user of the tsuru native
+authentication scheme. It asks for the password and for either a code generated
+by the authenticator application or a recovery code.`,
+		MinArgs: 0,
+		MaxArgs: 1,
+	}
+}
+
+func (twoFactorDisable) Run(context *Context, client *Client) error {
+	email, password, err := twoFactorCredentials(context)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(context.Stdout, "Two-factor or recovery code: ")
+	var code string
+	fmt.Fscanf(context.Stdin, "%s\n", &code)
+	fmt.Fprintln(context.Stdout)
+	v := url.Values{}
+	v.Set("password", password)
+	v.Set("otp", code)
+	resp, err := postUserForm(client, "/users/"+email+"/two-factor/disable", v)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	fmt.Fprintln(context.Stdout, "Two-factor authentication successfully disabled.")
+	return nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"github.com/tsuru/tsuru/cmd/cmdtest"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestTwoFactorEnableRun(c *check.C) {
+	expected := "Password: \n" +
+		"Add the following secret to your authenticator application: ABCDEF\n" +
+		"Or use the URI: otpauth://totp/tsuru:foo@foo.com?secret=ABCDEF\n" +
+		"Two-factor code: \n" +
+		"Two-factor authentication successfully enabled. Store the following recovery codes in a safe place:\n" +
+		"abcde-fghij\n" +
+		"klmno-pqrst\n"
+	reader := strings.NewReader("chico\n123456\n")
+	context := Context{[]string{"foo@foo.com"}, globalManager.stdout, globalManager.stderr, reader}
+	transport := cmdtest.MultiConditionalTransport{
+		ConditionalTransports: []cmdtest.ConditionalTransport{
+			{
+				Transport: cmdtest.Transport{
+					Message: `{"secret":"ABCDEF","uri":"otpauth://totp/tsuru:foo@foo.com?secret=ABCDEF"}`,
+					Status:  http.StatusCreated,
+				},
+				CondFunc: func(r *http.Request) bool {
+					return r.Method == "POST" && r.URL.Path == "/1.0/users/foo@foo.com/two-factor" &&
+						r.FormValue("password") == "chico"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{
+					Message: `{"recovery_codes":["abcde-fghij","klmno-pqrst"]}`,
+					Status:  http.StatusOK,
+				},
+				CondFunc: func(r *http.Request) bool {
+					return r.Method == "POST" && r.URL.Path == "/1.0/users/foo@foo.com/two-factor/confirm" &&
+						r.FormValue("password") == "chico" &&
+						r.FormValue("otp") == "123456"
+				},
+			},
+		},
+	}
+	client := NewClient(&http.Client{Transport: &transport}, nil, globalManager)
+	command := twoFactorEnable{}
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(globalManager.stdout.(*bytes.Buffer).String(), check.Equals, expected)
+}
+
+func (s *S) TestTwoFactorDisableRun(c *check.C) {
+	expected := "Email: Password: \nTwo-factor or recovery code: \nTwo-factor authentication successfully disabled.\n"
+	reader := strings.NewReader("foo@foo.com\nchico\nabcde-fghij\n")
+	context := Context{[]string{}, globalManager.stdout, globalManager.stderr, reader}
+	var called bool
+	transport := cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Status: http.StatusOK},
+		CondFunc: func(r *http.Request) bool {
+			called = true
+			return r.Method == "POST" && r.URL.Path == "/1.0/users/foo@foo.com/two-factor/disable" &&
+				r.FormValue("password") == "chico" &&
+				r.FormValue("otp") == "abcde-fghij"
+		},
+	}
+	client := NewClient(&http.Client{Transport: &transport}, nil, globalManager)
+	command := twoFactorDisable{}
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(called, check.Equals, true)
+	c.Assert(globalManager.stdout.(*bytes.Buffer).String(), check.Equals, expected)
+}
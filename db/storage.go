@@ -135,10 +135,38 @@ func (s *Storage) Tokens() *storage.Collection {
 	return coll
 }
 
+// AccessTokens returns the collection holding the named access tokens of
+// users.
+func (s *Storage) AccessTokens() *storage.Collection {
+	userNameIndex := mgo.Index{Key: []string{"useremail", "name"}, Unique: true}
+	tokenIndex := mgo.Index{Key: []string{"token"}, Unique: true}
+	c := s.Collection("access_tokens")
+	c.EnsureIndex(userNameIndex)
+	c.EnsureIndex(tokenIndex)
+	return c
+}
+
+// ServiceAccounts returns the collection holding the service accounts of
+// teams.
+func (s *Storage) ServiceAccounts() *storage.Collection {
+	teamIndex := mgo.Index{Key: []string{"team"}}
+	tokenIndex := mgo.Index{Key: []string{"token"}, Unique: true, Sparse: true}
+	c := s.Collection("service_accounts")
+	c.EnsureIndex(teamIndex)
+	c.EnsureIndex(tokenIndex)
+	return c
+}
+
 func (s *Storage) PasswordTokens() *storage.Collection {
 	return s.Collection("password_tokens")
 }
 
+// TwoFactorAuth returns the collection holding the two-factor authentication
+// secrets and recovery codes of users.
+func (s *Storage) TwoFactorAuth() *storage.Collection {
+	return s.Collection("two_factor_auth")
+}
+
 func (s *Storage) UserActions() *storage.Collection {
 	return s.Collection("user_actions")
 }
@@ -202,6 +230,15 @@ func (s *LogStorage) LogsCollections() ([]*storage.Collection, error) {
 	return colls, nil
 }
 
+// AppAutoScaleRules returns the collection holding the units autoscale rules
+// of apps.
+func (s *Storage) AppAutoScaleRules() *storage.Collection {
+	appProcessIndex := mgo.Index{Key: []string{"app", "process"}, Unique: true}
+	c := s.Collection("app_autoscale_rules")
+	c.EnsureIndex(appProcessIndex)
+	return c
+}
+
 func (s *Storage) Roles() *storage.Collection {
 	return s.Collection("roles")
 }
@@ -221,6 +258,37 @@ func (s *Storage) Events() *storage.Collection {
 	return c
 }
 
+func (s *Storage) Webhooks() *storage.Collection {
+	teamIndex := mgo.Index{Key: []string{"teamowner"}}
+	c := s.Collection("webhooks")
+	c.EnsureIndex(teamIndex)
+	return c
+}
+
+func (s *Storage) WebhookDeliveries() *storage.Collection {
+	webhookIndex := mgo.Index{Key: []string{"webhook", "-timestamp"}}
+	c := s.Collection("webhook_deliveries")
+	c.EnsureIndex(webhookIndex)
+	return c
+}
+
+func (s *Storage) ACMECertificates() *storage.Collection {
+	appIndex := mgo.Index{Key: []string{"app"}}
+	notAfterIndex := mgo.Index{Key: []string{"notafter"}}
+	c := s.Collection("acme_certificates")
+	c.EnsureIndex(appIndex)
+	c.EnsureIndex(notAfterIndex)
+	return c
+}
+
+func (s *Storage) ACMEChallenges() *storage.Collection {
+	return s.Collection("acme_challenges")
+}
+
+func (s *Storage) ACMEAccounts() *storage.Collection {
+	return s.Collection("acme_accounts")
+}
+
 func (s *Storage) InstallHosts() *storage.Collection {
 	nameIndex := mgo.Index{Key: []string{"name"}, Unique: true}
 	c := s.Collection("install_hosts")
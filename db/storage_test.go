@@ -114,6 +114,43 @@ func (s *S) TestApps(c *check.C) {
 	c.Assert(apps, HasUniqueIndex, []string{"name"})
 }
 
+func (s *S) TestAppAutoScaleRules(c *check.C) {
+	strg, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer strg.Close()
+	rules := strg.AppAutoScaleRules()
+	rulesc := strg.Collection("app_autoscale_rules")
+	c.Assert(rules, check.DeepEquals, rulesc)
+	c.Assert(rules, HasUniqueIndex, []string{"app", "process"})
+}
+
+func (s *S) TestWebhooks(c *check.C) {
+	strg, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer strg.Close()
+	webhooks := strg.Webhooks()
+	webhooksc := strg.Collection("webhooks")
+	c.Assert(webhooks, check.DeepEquals, webhooksc)
+}
+
+func (s *S) TestWebhookDeliveries(c *check.C) {
+	strg, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer strg.Close()
+	deliveries := strg.WebhookDeliveries()
+	deliveriesc := strg.Collection("webhook_deliveries")
+	c.Assert(deliveries, check.DeepEquals, deliveriesc)
+}
+
+func (s *S) TestACMECertificates(c *check.C) {
+	strg, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer strg.Close()
+	certs := strg.ACMECertificates()
+	certsc := strg.Collection("acme_certificates")
+	c.Assert(certs, check.DeepEquals, certsc)
+}
+
 func (s *S) TestPlatforms(c *check.C) {
 	strg, err := Conn()
 	c.Assert(err, check.IsNil)
@@ -34,6 +34,7 @@ var (
 	}
 	throttlingInfo  = map[string]ThrottlingSpec{}
 	errInvalidQuery = errors.New("invalid query")
+	doneHandlers    []func(bson.ObjectId)
 
 	ErrNotCancelable     = errors.New("event is not cancelable")
 	ErrEventNotFound     = errors.New("event not found")
@@ -48,9 +49,10 @@ var (
 	ErrInvalidKind       = ErrValidation("event kind must not be set on internal events")
 	ErrInvalidTargetType = errors.New("invalid event target type")
 
-	OwnerTypeUser     = ownerType("user")
-	OwnerTypeApp      = ownerType("app")
-	OwnerTypeInternal = ownerType("internal")
+	OwnerTypeUser           = ownerType("user")
+	OwnerTypeApp            = ownerType("app")
+	OwnerTypeServiceAccount = ownerType("service-account")
+	OwnerTypeInternal       = ownerType("internal")
 
 	KindTypePermission = kindType("permission")
 	KindTypeInternal   = kindType("internal")
@@ -69,6 +71,8 @@ var (
 	TargetTypePlan            = TargetType("plan")
 	TargetTypeNodeContainer   = TargetType("node-container")
 	TargetTypeInstallHost     = TargetType("install-host")
+	TargetTypeWebhook         = TargetType("webhook")
+	TargetTypeRouter          = TargetType("router")
 )
 
 const (
@@ -139,6 +143,7 @@ func (id eventID) GetBSON() (interface{}, error) {
 type eventData struct {
 	ID              eventID `bson:"_id"`
 	UniqueID        bson.ObjectId
+	ParentID        bson.ObjectId `bson:",omitempty"`
 	StartTime       time.Time
 	EndTime         time.Time `bson:",omitempty"`
 	Target          Target    `bson:",omitempty"`
@@ -191,6 +196,10 @@ func GetTargetType(t string) (TargetType, error) {
 		return TargetTypeTeam, nil
 	case "user":
 		return TargetTypeUser, nil
+	case "webhook":
+		return TargetTypeWebhook, nil
+	case "router":
+		return TargetTypeRouter, nil
 	}
 	return TargetType(""), ErrInvalidTargetType
 }
@@ -205,6 +214,17 @@ type Kind struct {
 	Name string
 }
 
+// TokenOwner returns the owner of events started with the given token.
+func TokenOwner(t auth.Token) Owner {
+	if t.IsAppToken() {
+		return Owner{Type: OwnerTypeApp, Name: t.GetAppName()}
+	}
+	if _, ok := t.(*auth.ServiceAccount); ok {
+		return Owner{Type: OwnerTypeServiceAccount, Name: t.GetUserName()}
+	}
+	return Owner{Type: OwnerTypeUser, Name: t.GetUserName()}
+}
+
 func (o Owner) String() string {
 	return fmt.Sprintf("%s %s", o.Type, o.Name)
 }
@@ -228,6 +248,13 @@ func SetThrottling(spec ThrottlingSpec) {
 	throttlingInfo[key] = spec
 }
 
+// OnDone registers a function to be called with the unique id of every event
+// successfully marked as done. Aborted events are not notified. Handlers are
+// called synchronously when the event is done, so they must not block.
+func OnDone(fn func(bson.ObjectId)) {
+	doneHandlers = append(doneHandlers, fn)
+}
+
 func getThrottling(t *Target, k *Kind) *ThrottlingSpec {
 	key := fmt.Sprintf("%s_%s", t.Type, k.Name)
 	if s, ok := throttlingInfo[key]; ok {
@@ -256,6 +283,9 @@ type Opts struct {
 	Cancelable    bool
 	Allowed       AllowedPermission
 	AllowedCancel AllowedPermission
+	// Parent is the event that originated the new event. Child events
+	// don't lock their target, as it's already locked by the parent.
+	Parent *Event
 }
 
 func Allowed(scheme *permission.PermissionScheme, contexts ...permission.PermissionContext) AllowedPermission {
@@ -317,6 +347,8 @@ type Filter struct {
 	Limit int
 	Skip  int
 	Sort  string
+
+	ParentID string
 }
 
 func (f *Filter) PruneUserValues() {
@@ -390,6 +422,12 @@ func (f *Filter) toQuery() (bson.M, error) {
 	if f.OwnerName != "" {
 		query["owner.name"] = f.OwnerName
 	}
+	if f.ParentID != "" {
+		if !bson.IsObjectIdHex(f.ParentID) {
+			return nil, errInvalidQuery
+		}
+		query["parentid"] = bson.ObjectIdHex(f.ParentID)
+	}
 	var timeParts []bson.M
 	if !f.Since.IsZero() {
 		timeParts = append(timeParts, bson.M{"starttime": bson.M{"$gte": f.Since}})
@@ -637,12 +675,8 @@ func newEvt(opts *Opts) (*Event, error) {
 		} else {
 			o.Type = OwnerTypeInternal
 		}
-	} else if opts.Owner.IsAppToken() {
-		o.Type = OwnerTypeApp
-		o.Name = opts.Owner.GetAppName()
 	} else {
-		o.Type = OwnerTypeUser
-		o.Name = opts.Owner.GetUserName()
+		o = TokenOwner(opts.Owner)
 	}
 	conn, err := db.Conn()
 	if err != nil {
@@ -676,7 +710,11 @@ func newEvt(opts *Opts) (*Event, error) {
 	}
 	uniqID := bson.NewObjectId()
 	var id eventID
-	if opts.DisableLock {
+	var parentID bson.ObjectId
+	if opts.Parent != nil {
+		parentID = opts.Parent.UniqueID
+	}
+	if opts.DisableLock || opts.Parent != nil {
 		id.ObjId = uniqID
 	} else {
 		id.Target = opts.Target
@@ -684,6 +722,7 @@ func newEvt(opts *Opts) (*Event, error) {
 	evt := Event{eventData: eventData{
 		ID:              id,
 		UniqueID:        uniqID,
+		ParentID:        parentID,
 		Target:          opts.Target,
 		StartTime:       now,
 		Kind:            k,
@@ -899,11 +938,19 @@ func (e *Event) done(evtErr error, customData interface{}, abort bool) (err erro
 		e.OtherCustomData = dbEvt.OtherCustomData
 	}
 	if len(e.ID.ObjId) != 0 {
-		return coll.UpdateId(e.ID, e.eventData)
+		err = coll.UpdateId(e.ID, e.eventData)
+	} else {
+		defer coll.RemoveId(e.ID)
+		e.ID = eventID{ObjId: e.UniqueID}
+		err = coll.Insert(e.eventData)
 	}
-	defer coll.RemoveId(e.ID)
-	e.ID = eventID{ObjId: e.UniqueID}
-	return coll.Insert(e.eventData)
+	if err != nil {
+		return err
+	}
+	for _, fn := range doneHandlers {
+		fn(e.UniqueID)
+	}
+	return nil
 }
 
 type lockUpdater struct {
@@ -40,6 +40,7 @@ func (s *S) SetUpTest(c *check.C) {
 	config.Set("database:name", "tsuru_events_tests")
 	config.Set("auth:hash-cost", bcrypt.MinCost)
 	throttlingInfo = map[string]ThrottlingSpec{}
+	doneHandlers = nil
 	conn, err := db.Conn()
 	c.Assert(err, check.IsNil)
 	defer conn.Close()
@@ -99,6 +100,25 @@ func (s *S) TestNewDone(c *check.C) {
 	c.Assert(&evts[0], check.DeepEquals, expected)
 }
 
+func (s *S) TestNewServiceAccountOwner(c *check.C) {
+	sa := &auth.ServiceAccount{Name: "ci", Team: "myteam"}
+	evt, err := New(&Opts{
+		Target:  Target{Type: "app", Value: "myapp"},
+		Kind:    permission.PermAppUpdateEnvSet,
+		Owner:   sa,
+		Allowed: Allowed(permission.PermAppReadEvents),
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(evt.Owner, check.Equals, Owner{Type: OwnerTypeServiceAccount, Name: "ci"})
+	err = evt.Done(nil)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestTokenOwner(c *check.C) {
+	c.Assert(TokenOwner(s.token), check.Equals, Owner{Type: OwnerTypeUser, Name: "me@me.com"})
+	c.Assert(TokenOwner(&auth.ServiceAccount{Name: "ci"}), check.Equals, Owner{Type: OwnerTypeServiceAccount, Name: "ci"})
+}
+
 func (s *S) TestNewCustomDataDone(c *check.C) {
 	customData := struct{ A string }{A: "value"}
 	evt, err := New(&Opts{
@@ -216,6 +236,36 @@ func (s *S) TestNewDoneDisableLock(c *check.C) {
 	c.Assert(&evts[0], check.DeepEquals, expected)
 }
 
+func (s *S) TestNewWithParent(c *check.C) {
+	parent, err := New(&Opts{
+		Target:  Target{Type: "app", Value: "myapp"},
+		Kind:    permission.PermAppDeploy,
+		Owner:   s.token,
+		Allowed: Allowed(permission.PermAppReadEvents),
+	})
+	c.Assert(err, check.IsNil)
+	child, err := NewInternal(&Opts{
+		Target:       Target{Type: "app", Value: "myapp"},
+		InternalKind: "deploy-verification",
+		Parent:       parent,
+		Allowed:      Allowed(permission.PermAppReadEvents),
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(child.ParentID, check.Equals, parent.UniqueID)
+	c.Assert(child.ID, check.Equals, eventID{ObjId: child.UniqueID})
+	err = child.Done(nil)
+	c.Assert(err, check.IsNil)
+	err = parent.Done(nil)
+	c.Assert(err, check.IsNil)
+	evts, err := List(&Filter{ParentID: parent.UniqueID.Hex()})
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 1)
+	c.Assert(evts[0].UniqueID, check.Equals, child.UniqueID)
+	c.Assert(evts[0].ParentID, check.Equals, parent.UniqueID)
+	_, err = List(&Filter{ParentID: "invalid"})
+	c.Assert(err, check.Equals, errInvalidQuery)
+}
+
 func (s *S) TestNewLockExpired(c *check.C) {
 	oldLockExpire := lockExpireTimeout
 	lockExpireTimeout = time.Millisecond
@@ -304,6 +354,33 @@ func (s *S) TestEventAbort(c *check.C) {
 	c.Assert(evts, check.HasLen, 0)
 }
 
+func (s *S) TestEventDoneNotifiesHandlers(c *check.C) {
+	var notified []bson.ObjectId
+	OnDone(func(id bson.ObjectId) {
+		notified = append(notified, id)
+	})
+	evt, err := New(&Opts{
+		Target:  Target{Type: "app", Value: "myapp"},
+		Kind:    permission.PermAppUpdateEnvSet,
+		Owner:   s.token,
+		Allowed: Allowed(permission.PermAppReadEvents),
+	})
+	c.Assert(err, check.IsNil)
+	err = evt.Done(nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(notified, check.DeepEquals, []bson.ObjectId{evt.UniqueID})
+	evt, err = New(&Opts{
+		Target:  Target{Type: "app", Value: "myapp"},
+		Kind:    permission.PermAppUpdateEnvSet,
+		Owner:   s.token,
+		Allowed: Allowed(permission.PermAppReadEvents),
+	})
+	c.Assert(err, check.IsNil)
+	err = evt.Abort()
+	c.Assert(err, check.IsNil)
+	c.Assert(notified, check.HasLen, 1)
+}
+
 func (s *S) TestEventDoneError(c *check.C) {
 	evt, err := New(&Opts{
 		Target:  Target{Type: "app", Value: "myapp"},
@@ -768,6 +845,7 @@ func (s *S) TestGetTargetType(c *check.C) {
 		{"service-instance", TargetTypeServiceInstance, nil},
 		{"team", TargetTypeTeam, nil},
 		{"user", TargetTypeUser, nil},
+		{"webhook", TargetTypeWebhook, nil},
 		{"invalid", "", ErrInvalidTargetType},
 	}
 	for _, t := range tests {
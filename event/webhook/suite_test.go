@@ -0,0 +1,43 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webhook
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/db/dbtest"
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type S struct {
+	conn *db.Storage
+}
+
+var _ = check.Suite(&S{})
+
+func (s *S) SetUpSuite(c *check.C) {
+	config.Set("database:url", "127.0.0.1:27017")
+	config.Set("database:name", "tsuru_event_webhook_tests")
+	config.Set("event-webhooks:allow-private-addresses", true)
+	var err error
+	s.conn, err = db.Conn()
+	c.Assert(err, check.IsNil)
+	retryInterval = time.Millisecond
+}
+
+func (s *S) TearDownSuite(c *check.C) {
+	s.conn.Webhooks().Database.DropDatabase()
+	s.conn.Close()
+}
+
+func (s *S) SetUpTest(c *check.C) {
+	err := dbtest.ClearAllCollections(s.conn.Webhooks().Database)
+	c.Assert(err, check.IsNil)
+}
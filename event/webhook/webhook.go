@@ -0,0 +1,594 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package webhook implements HTTP notifications for tsuru events. Teams
+// register webhooks with a filter and tsuru calls them whenever an event
+// matching the filter, and visible to the team, is done.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/api/shutdown"
+	"github.com/tsuru/tsuru/db"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/log"
+	tsuruNet "github.com/tsuru/tsuru/net"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	SignatureHeader = "X-Tsuru-Signature"
+	EventIDHeader   = "X-Tsuru-Event-Id"
+
+	deliveriesMaxLimit = 100
+	notifyQueueSize    = 1000
+)
+
+var (
+	ErrWebhookNotFound      = errors.New("webhook not found")
+	ErrWebhookAlreadyExists = errors.New("webhook already exists")
+
+	retryInterval = 5 * time.Second
+
+	privateNetworks = parseCIDRs(
+		"0.0.0.0/8",
+		"10.0.0.0/8",
+		"100.64.0.0/10",
+		"127.0.0.0/8",
+		"169.254.0.0/16",
+		"172.16.0.0/12",
+		"192.168.0.0/16",
+		"::1/128",
+		"fc00::/7",
+		"fe80::/10",
+	)
+
+	webhookClient = &http.Client{
+		Transport: &http.Transport{
+			Dial:                dialPublic,
+			TLSHandshakeTimeout: 5 * time.Second,
+			MaxIdleConnsPerHost: -1,
+		},
+		Timeout: time.Minute,
+	}
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, nets[i], _ = net.ParseCIDR(cidr)
+	}
+	return nets
+}
+
+func isPrivateIP(ip net.IP) bool {
+	for _, n := range privateNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowPrivateAddresses returns whether webhooks may call loopback,
+// link-local and private addresses, which is disabled by default so that
+// teams can't reach internal services through webhooks.
+func allowPrivateAddresses() bool {
+	allowed, _ := config.GetBool("event-webhooks:allow-private-addresses")
+	return allowed
+}
+
+// dialPublic connects to addr only if none of the addresses it resolves to
+// are private, the check is done at dial time so that it also applies to
+// redirects and to names resolving to different addresses over time.
+func dialPublic(network, addr string) (net.Conn, error) {
+	if allowPrivateAddresses() {
+		return tsuruNet.Dial5Dialer.Dial(network, addr)
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if isPrivateIP(ip) {
+			return nil, errors.Errorf("webhook address %q resolves to private address %s", host, ip)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, errors.Errorf("no addresses found for %q", host)
+	}
+	return tsuruNet.Dial5Dialer.Dial(network, net.JoinHostPort(ips[0].String(), port))
+}
+
+// Filter selects the events that trigger a webhook. Empty fields match any
+// event.
+type Filter struct {
+	TargetType  string
+	TargetValue string
+	KindName    string
+	ErrorOnly   bool
+}
+
+// Webhook is an HTTP endpoint notified when events matching its filter are
+// done. Only events allowed in the context of the team owner are notified.
+type Webhook struct {
+	Name        string `bson:"_id"`
+	Description string
+	TeamOwner   string
+	EventFilter Filter
+	URL         string
+	Method      string
+	Headers     http.Header
+	// Body is a text/template executed with a copy of the event fields as
+	// data. An empty body sends the event encoded as JSON.
+	Body string
+	// Secret is used to sign the request body with HMAC-SHA256, the
+	// signature is sent in the X-Tsuru-Signature header.
+	Secret string `json:"-"`
+}
+
+// Delivery is the record of a webhook call triggered by an event.
+type Delivery struct {
+	ID         bson.ObjectId `bson:"_id"`
+	Webhook    string
+	EventID    bson.ObjectId
+	Timestamp  time.Time
+	Attempts   int
+	StatusCode int
+	Error      string
+}
+
+func (w *Webhook) validate() error {
+	if w.Name == "" {
+		return &tsuruErrors.ValidationError{Message: "webhook name is required"}
+	}
+	if w.TeamOwner == "" {
+		return &tsuruErrors.ValidationError{Message: "webhook team owner is required"}
+	}
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return &tsuruErrors.ValidationError{Message: fmt.Sprintf("invalid webhook url %q", w.URL)}
+	}
+	if !allowPrivateAddresses() {
+		host := tsuruNet.URLToHost(w.URL)
+		ip := net.ParseIP(host)
+		if host == "localhost" || (ip != nil && isPrivateIP(ip)) {
+			return &tsuruErrors.ValidationError{Message: fmt.Sprintf("webhook url %q points to a private address", w.URL)}
+		}
+	}
+	if w.EventFilter.TargetType != "" {
+		_, err = event.GetTargetType(w.EventFilter.TargetType)
+		if err != nil {
+			return &tsuruErrors.ValidationError{Message: fmt.Sprintf("invalid event target type %q", w.EventFilter.TargetType)}
+		}
+	}
+	if w.Method == "" {
+		w.Method = http.MethodPost
+	}
+	_, err = template.New(w.Name).Parse(w.Body)
+	if err != nil {
+		return &tsuruErrors.ValidationError{Message: fmt.Sprintf("invalid webhook body template: %s", err)}
+	}
+	return nil
+}
+
+// Create stores a new webhook.
+func Create(w Webhook) error {
+	err := w.validate()
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Webhooks().Insert(w)
+	if mgo.IsDup(err) {
+		return ErrWebhookAlreadyExists
+	}
+	return err
+}
+
+// Update replaces an existing webhook.
+func Update(w Webhook) error {
+	err := w.validate()
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Webhooks().UpdateId(w.Name, w)
+	if err == mgo.ErrNotFound {
+		return ErrWebhookNotFound
+	}
+	return err
+}
+
+// Delete removes a webhook and its delivery log.
+func Delete(name string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Webhooks().RemoveId(name)
+	if err == mgo.ErrNotFound {
+		return ErrWebhookNotFound
+	}
+	if err != nil {
+		return err
+	}
+	_, err = conn.WebhookDeliveries().RemoveAll(bson.M{"webhook": name})
+	return err
+}
+
+// Find returns the webhook with the given name.
+func Find(name string) (*Webhook, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var w Webhook
+	err = conn.Webhooks().FindId(name).One(&w)
+	if err == mgo.ErrNotFound {
+		return nil, ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+// List returns the webhooks owned by the given teams. A nil slice of teams
+// returns all webhooks.
+func List(teams []string) ([]Webhook, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var query bson.M
+	if teams != nil {
+		query = bson.M{"teamowner": bson.M{"$in": teams}}
+	}
+	var hooks []Webhook
+	err = conn.Webhooks().Find(query).Sort("_id").All(&hooks)
+	if err != nil {
+		return nil, err
+	}
+	return hooks, nil
+}
+
+// ListDeliveries returns the most recent deliveries of the webhook.
+func ListDeliveries(name string, limit int) ([]Delivery, error) {
+	if limit <= 0 || limit > deliveriesMaxLimit {
+		limit = deliveriesMaxLimit
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var deliveries []Delivery
+	err = conn.WebhookDeliveries().Find(bson.M{"webhook": name}).Sort("-timestamp").Limit(limit).All(&deliveries)
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// matchingWebhooks returns the webhooks whose filter matches the target,
+// kind and error of the event.
+func matchingWebhooks(evt *event.Event) ([]Webhook, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	query := bson.M{
+		"eventfilter.targettype":  bson.M{"$in": []string{"", string(evt.Target.Type)}},
+		"eventfilter.targetvalue": bson.M{"$in": []string{"", evt.Target.Value}},
+		"eventfilter.kindname":    bson.M{"$in": []string{"", evt.Kind.Name}},
+	}
+	if evt.Error == "" {
+		query["eventfilter.erroronly"] = false
+	}
+	var hooks []Webhook
+	err = conn.Webhooks().Find(query).Sort("_id").All(&hooks)
+	if err != nil {
+		return nil, err
+	}
+	return hooks, nil
+}
+
+// allows returns whether the event is visible in the team owner context.
+func (w *Webhook) allows(evt *event.Event) bool {
+	teamCtx := permission.Context(permission.CtxTeam, w.TeamOwner)
+	for _, ctx := range evt.Allowed.Contexts {
+		if ctx == teamCtx {
+			return true
+		}
+	}
+	return false
+}
+
+// templateData holds the event fields available to body templates. Templates
+// are executed with a copy of the event, so that they can't call methods
+// changing the event itself.
+type templateData struct {
+	UniqueID        string
+	ParentID        string
+	StartTime       time.Time
+	EndTime         time.Time
+	Target          event.Target
+	Kind            event.Kind
+	Owner           event.Owner
+	StartCustomData interface{}
+	EndCustomData   interface{}
+	OtherCustomData interface{}
+	Error           string
+	Log             string
+	Cancelable      bool
+}
+
+func newTemplateData(evt *event.Event) templateData {
+	data := templateData{
+		UniqueID:   evt.UniqueID.Hex(),
+		StartTime:  evt.StartTime,
+		EndTime:    evt.EndTime,
+		Target:     evt.Target,
+		Kind:       evt.Kind,
+		Owner:      evt.Owner,
+		Error:      evt.Error,
+		Log:        evt.Log,
+		Cancelable: evt.Cancelable,
+	}
+	if evt.ParentID != "" {
+		data.ParentID = evt.ParentID.Hex()
+	}
+	evt.StartData(&data.StartCustomData)
+	evt.EndData(&data.EndCustomData)
+	evt.OtherData(&data.OtherCustomData)
+	return data
+}
+
+func (w *Webhook) request(evt *event.Event) (*http.Request, error) {
+	var body bytes.Buffer
+	if w.Body == "" {
+		err := json.NewEncoder(&body).Encode(evt)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		tpl, err := template.New(w.Name).Parse(w.Body)
+		if err != nil {
+			return nil, err
+		}
+		err = tpl.Execute(&body, newTemplateData(evt))
+		if err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequest(w.Method, w.URL, bytes.NewReader(body.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range w.Headers {
+		req.Header[k] = v
+	}
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set(EventIDHeader, evt.UniqueID.Hex())
+	if w.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.Secret))
+		mac.Write(body.Bytes())
+		req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	return req, nil
+}
+
+func (w *Webhook) call(evt *event.Event) (int, error) {
+	req, err := w.request(evt)
+	if err != nil {
+		return 0, err
+	}
+	rsp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return rsp.StatusCode, errors.Errorf("unexpected status code: %d", rsp.StatusCode)
+	}
+	return rsp.StatusCode, nil
+}
+
+// delivery is a pending call of a webhook for an event.
+type delivery struct {
+	hook   Webhook
+	evt    *event.Event
+	record Delivery
+}
+
+// task is either an event to be handled or a delivery to be retried.
+type task struct {
+	evtID bson.ObjectId
+	retry *delivery
+}
+
+type notifier struct {
+	ch          chan task
+	wg          sync.WaitGroup
+	once        sync.Once
+	maxAttempts int
+}
+
+// Initialize starts the workers responsible for delivering events to
+// webhooks and subscribes them to the events being done.
+func Initialize() {
+	workers, _ := config.GetInt("event-webhooks:workers")
+	if workers <= 0 {
+		workers = 5
+	}
+	maxAttempts, _ := config.GetInt("event-webhooks:max-attempts")
+	if maxAttempts <= 0 {
+		maxAttempts = 3
+	}
+	n := newNotifier(workers, maxAttempts)
+	shutdown.Register(n)
+	event.OnDone(n.notify)
+}
+
+func newNotifier(workers, maxAttempts int) *notifier {
+	n := &notifier{
+		ch:          make(chan task, notifyQueueSize),
+		maxAttempts: maxAttempts,
+	}
+	for i := 0; i < workers; i++ {
+		n.wg.Add(1)
+		go n.run()
+	}
+	return n
+}
+
+func (n *notifier) notify(evtID bson.ObjectId) {
+	if !n.enqueue(task{evtID: evtID}) {
+		log.Errorf("[webhooks] notification queue full, dropping event %s", evtID.Hex())
+	}
+}
+
+func (n *notifier) enqueue(t task) (queued bool) {
+	defer func() {
+		// Sending on a closed channel after shutdown must not break
+		// whoever is finishing the event.
+		if recover() != nil {
+			queued = false
+		}
+	}()
+	select {
+	case n.ch <- t:
+		return true
+	default:
+		return false
+	}
+}
+
+func (n *notifier) run() {
+	defer n.wg.Done()
+	for t := range n.ch {
+		if t.retry != nil {
+			n.attempt(t.retry)
+			continue
+		}
+		err := n.handleEvent(t.evtID)
+		if err != nil {
+			log.Errorf("[webhooks] error handling event %s: %s", t.evtID.Hex(), err)
+		}
+	}
+}
+
+// handleEvent delivers the event to every webhook matching it.
+func (n *notifier) handleEvent(evtID bson.ObjectId) error {
+	evt, err := event.GetByID(evtID)
+	if err != nil {
+		return err
+	}
+	if evt.Running {
+		return nil
+	}
+	hooks, err := matchingWebhooks(evt)
+	if err != nil {
+		return err
+	}
+	for _, hook := range hooks {
+		if !hook.allows(evt) {
+			continue
+		}
+		n.attempt(&delivery{
+			hook: hook,
+			evt:  evt,
+			record: Delivery{
+				ID:        bson.NewObjectId(),
+				Webhook:   hook.Name,
+				EventID:   evt.UniqueID,
+				Timestamp: time.Now().UTC(),
+			},
+		})
+	}
+	return nil
+}
+
+// attempt calls the webhook once. Failed calls are requeued after an
+// interval growing with the number of attempts, instead of holding the
+// worker, until maxAttempts is reached. The result is recorded in the
+// delivery log once the call succeeds or is given up.
+func (n *notifier) attempt(d *delivery) {
+	d.record.Attempts++
+	var err error
+	d.record.StatusCode, err = d.hook.call(d.evt)
+	if err != nil && d.record.Attempts < n.maxAttempts {
+		time.AfterFunc(time.Duration(d.record.Attempts)*retryInterval, func() {
+			if !n.enqueue(task{retry: d}) {
+				n.finish(d, errors.Wrap(err, "unable to retry delivery"))
+			}
+		})
+		return
+	}
+	n.finish(d, err)
+}
+
+func (n *notifier) finish(d *delivery, err error) {
+	if err != nil {
+		d.record.Error = err.Error()
+		log.Errorf("[webhooks] error delivering event %s to webhook %q: %s", d.evt.UniqueID.Hex(), d.hook.Name, err)
+	}
+	conn, dbErr := db.Conn()
+	if dbErr != nil {
+		log.Errorf("[webhooks] unable to record delivery of event %s to webhook %q: %s", d.evt.UniqueID.Hex(), d.hook.Name, dbErr)
+		return
+	}
+	defer conn.Close()
+	dbErr = conn.WebhookDeliveries().Insert(d.record)
+	if dbErr != nil {
+		log.Errorf("[webhooks] unable to record delivery of event %s to webhook %q: %s", d.evt.UniqueID.Hex(), d.hook.Name, dbErr)
+	}
+}
+
+func (n *notifier) Shutdown() {
+	n.once.Do(func() {
+		close(n.ch)
+	})
+	n.wg.Wait()
+}
+
+func (n *notifier) String() string {
+	return "event webhooks"
+}
@@ -0,0 +1,300 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/tsuru/config"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func newEvent(c *check.C, team string, evtErr error) *event.Event {
+	evt, err := event.NewInternal(&event.Opts{
+		Target:       event.Target{Type: event.TargetTypeApp, Value: "myapp"},
+		InternalKind: "app-test",
+		DisableLock:  true,
+		Allowed: event.Allowed(permission.PermAppReadEvents,
+			permission.Context(permission.CtxTeam, team),
+			permission.Context(permission.CtxApp, "myapp"),
+		),
+	})
+	c.Assert(err, check.IsNil)
+	err = evt.Done(evtErr)
+	c.Assert(err, check.IsNil)
+	return evt
+}
+
+func waitDeliveries(c *check.C, hook string, count int) []Delivery {
+	timeout := time.After(5 * time.Second)
+	for {
+		deliveries, err := ListDeliveries(hook, 0)
+		c.Assert(err, check.IsNil)
+		if len(deliveries) >= count {
+			return deliveries
+		}
+		select {
+		case <-timeout:
+			c.Fatalf("timeout waiting for %d deliveries of %q, got %d", count, hook, len(deliveries))
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func (s *S) TestCreate(c *check.C) {
+	w := Webhook{Name: "hook1", TeamOwner: "myteam", URL: "http://example.com/hook"}
+	err := Create(w)
+	c.Assert(err, check.IsNil)
+	dbHook, err := Find("hook1")
+	c.Assert(err, check.IsNil)
+	w.Method = http.MethodPost
+	c.Assert(*dbHook, check.DeepEquals, w)
+	err = Create(w)
+	c.Assert(err, check.Equals, ErrWebhookAlreadyExists)
+}
+
+func (s *S) TestCreateInvalid(c *check.C) {
+	tests := []struct {
+		hook Webhook
+		err  string
+	}{
+		{hook: Webhook{TeamOwner: "t", URL: "http://a.com"}, err: "webhook name is required"},
+		{hook: Webhook{Name: "h", URL: "http://a.com"}, err: "webhook team owner is required"},
+		{hook: Webhook{Name: "h", TeamOwner: "t", URL: "ftp://a.com"}, err: `invalid webhook url "ftp://a.com"`},
+		{hook: Webhook{Name: "h", TeamOwner: "t", URL: "http://a.com", EventFilter: Filter{TargetType: "xyz"}}, err: `invalid event target type "xyz"`},
+		{hook: Webhook{Name: "h", TeamOwner: "t", URL: "http://a.com", Body: "{{.Kind"}, err: "invalid webhook body template: .*"},
+	}
+	for _, tt := range tests {
+		err := Create(tt.hook)
+		c.Check(err, check.ErrorMatches, tt.err)
+		c.Check(err, check.FitsTypeOf, &tsuruErrors.ValidationError{})
+	}
+}
+
+func (s *S) TestUpdate(c *check.C) {
+	err := Create(Webhook{Name: "hook1", TeamOwner: "myteam", URL: "http://example.com/hook"})
+	c.Assert(err, check.IsNil)
+	err = Update(Webhook{Name: "hook1", TeamOwner: "myteam", URL: "http://example.com/other", Method: http.MethodPut})
+	c.Assert(err, check.IsNil)
+	dbHook, err := Find("hook1")
+	c.Assert(err, check.IsNil)
+	c.Assert(dbHook.URL, check.Equals, "http://example.com/other")
+	c.Assert(dbHook.Method, check.Equals, http.MethodPut)
+	err = Update(Webhook{Name: "hook2", TeamOwner: "myteam", URL: "http://example.com/hook"})
+	c.Assert(err, check.Equals, ErrWebhookNotFound)
+}
+
+func (s *S) TestDelete(c *check.C) {
+	err := Create(Webhook{Name: "hook1", TeamOwner: "myteam", URL: "http://example.com/hook"})
+	c.Assert(err, check.IsNil)
+	err = Delete("hook1")
+	c.Assert(err, check.IsNil)
+	_, err = Find("hook1")
+	c.Assert(err, check.Equals, ErrWebhookNotFound)
+	err = Delete("hook1")
+	c.Assert(err, check.Equals, ErrWebhookNotFound)
+}
+
+func (s *S) TestList(c *check.C) {
+	err := Create(Webhook{Name: "hook1", TeamOwner: "team1", URL: "http://example.com/hook"})
+	c.Assert(err, check.IsNil)
+	err = Create(Webhook{Name: "hook2", TeamOwner: "team2", URL: "http://example.com/hook"})
+	c.Assert(err, check.IsNil)
+	hooks, err := List(nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(hooks, check.HasLen, 2)
+	hooks, err = List([]string{"team2"})
+	c.Assert(err, check.IsNil)
+	c.Assert(hooks, check.HasLen, 1)
+	c.Assert(hooks[0].Name, check.Equals, "hook2")
+}
+
+func (s *S) TestHandleEvent(c *check.C) {
+	var received []*http.Request
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		received = append(received, r)
+		bodies = append(bodies, string(data))
+	}))
+	defer srv.Close()
+	err := Create(Webhook{
+		Name:      "hook1",
+		TeamOwner: "myteam",
+		URL:       srv.URL,
+		Headers:   http.Header{"X-Custom": []string{"abc"}},
+		Body:      "{{.Target.Value}} {{.Kind.Name}}",
+		Secret:    "s3cr3t",
+	})
+	c.Assert(err, check.IsNil)
+	evt := newEvent(c, "myteam", nil)
+	n := newNotifier(0, 3)
+	err = n.handleEvent(evt.UniqueID)
+	c.Assert(err, check.IsNil)
+	c.Assert(received, check.HasLen, 1)
+	c.Assert(bodies[0], check.Equals, "myapp app-test")
+	c.Assert(received[0].Header.Get("X-Custom"), check.Equals, "abc")
+	c.Assert(received[0].Header.Get(EventIDHeader), check.Equals, evt.UniqueID.Hex())
+	mac := hmac.New(sha256.New, []byte("s3cr3t"))
+	mac.Write([]byte(bodies[0]))
+	c.Assert(received[0].Header.Get(SignatureHeader), check.Equals, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	deliveries, err := ListDeliveries("hook1", 0)
+	c.Assert(err, check.IsNil)
+	c.Assert(deliveries, check.HasLen, 1)
+	c.Assert(deliveries[0].EventID, check.Equals, evt.UniqueID)
+	c.Assert(deliveries[0].Attempts, check.Equals, 1)
+	c.Assert(deliveries[0].StatusCode, check.Equals, http.StatusOK)
+	c.Assert(deliveries[0].Error, check.Equals, "")
+}
+
+func (s *S) TestHandleEventNotAllowedForTeam(c *check.C) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer srv.Close()
+	err := Create(Webhook{Name: "hook1", TeamOwner: "otherteam", URL: srv.URL})
+	c.Assert(err, check.IsNil)
+	evt := newEvent(c, "myteam", nil)
+	n := newNotifier(0, 3)
+	err = n.handleEvent(evt.UniqueID)
+	c.Assert(err, check.IsNil)
+	c.Assert(calls, check.Equals, 0)
+}
+
+func (s *S) TestHandleEventFilter(c *check.C) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer srv.Close()
+	err := Create(Webhook{Name: "hook1", TeamOwner: "myteam", URL: srv.URL, EventFilter: Filter{ErrorOnly: true}})
+	c.Assert(err, check.IsNil)
+	err = Create(Webhook{Name: "hook2", TeamOwner: "myteam", URL: srv.URL, EventFilter: Filter{KindName: "other-kind"}})
+	c.Assert(err, check.IsNil)
+	evt := newEvent(c, "myteam", nil)
+	n := newNotifier(0, 3)
+	err = n.handleEvent(evt.UniqueID)
+	c.Assert(err, check.IsNil)
+	c.Assert(calls, check.Equals, 0)
+	evt = newEvent(c, "myteam", errors.New("failed"))
+	err = n.handleEvent(evt.UniqueID)
+	c.Assert(err, check.IsNil)
+	c.Assert(calls, check.Equals, 1)
+}
+
+func (s *S) TestHandleEventRetries(c *check.C) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	err := Create(Webhook{Name: "hook1", TeamOwner: "myteam", URL: srv.URL})
+	c.Assert(err, check.IsNil)
+	n := newNotifier(1, 3)
+	defer n.Shutdown()
+	evt := newEvent(c, "myteam", nil)
+	err = n.handleEvent(evt.UniqueID)
+	c.Assert(err, check.IsNil)
+	deliveries := waitDeliveries(c, "hook1", 1)
+	c.Assert(calls, check.Equals, 3)
+	c.Assert(deliveries, check.HasLen, 1)
+	c.Assert(deliveries[0].Attempts, check.Equals, 3)
+	c.Assert(deliveries[0].StatusCode, check.Equals, http.StatusInternalServerError)
+	c.Assert(deliveries[0].Error, check.Equals, "unexpected status code: 500")
+}
+
+func (s *S) TestHandleEventRetryDoesntBlockWorkers(c *check.C) {
+	retryInterval = time.Minute
+	defer func() { retryInterval = time.Millisecond }()
+	failSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failSrv.Close()
+	okSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer okSrv.Close()
+	err := Create(Webhook{Name: "hook1", TeamOwner: "myteam", URL: failSrv.URL})
+	c.Assert(err, check.IsNil)
+	err = Create(Webhook{Name: "hook2", TeamOwner: "myteam", URL: okSrv.URL})
+	c.Assert(err, check.IsNil)
+	n := newNotifier(1, 3)
+	defer n.Shutdown()
+	n.notify(newEvent(c, "myteam", nil).UniqueID)
+	n.notify(newEvent(c, "myteam", nil).UniqueID)
+	waitDeliveries(c, "hook2", 2)
+	deliveries, err := ListDeliveries("hook1", 0)
+	c.Assert(err, check.IsNil)
+	c.Assert(deliveries, check.HasLen, 0)
+}
+
+func (s *S) TestValidatePrivateAddress(c *check.C) {
+	config.Unset("event-webhooks:allow-private-addresses")
+	defer config.Set("event-webhooks:allow-private-addresses", true)
+	for _, u := range []string{"http://localhost:8080", "http://127.0.0.1", "http://10.1.2.3/hook", "http://169.254.169.254/latest", "http://[::1]:80", "https://192.168.0.1"} {
+		hook := Webhook{Name: "h", TeamOwner: "t", URL: u}
+		err := hook.validate()
+		c.Check(err, check.ErrorMatches, `webhook url ".*" points to a private address`, check.Commentf(u))
+	}
+	hook := Webhook{Name: "h", TeamOwner: "t", URL: "http://8.8.8.8/hook"}
+	c.Assert(hook.validate(), check.IsNil)
+}
+
+func (s *S) TestHandleEventPrivateAddressDial(c *check.C) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer srv.Close()
+	err := Create(Webhook{Name: "hook1", TeamOwner: "myteam", URL: srv.URL})
+	c.Assert(err, check.IsNil)
+	config.Unset("event-webhooks:allow-private-addresses")
+	defer config.Set("event-webhooks:allow-private-addresses", true)
+	evt := newEvent(c, "myteam", nil)
+	n := newNotifier(0, 1)
+	err = n.handleEvent(evt.UniqueID)
+	c.Assert(err, check.IsNil)
+	c.Assert(calls, check.Equals, 0)
+	deliveries, err := ListDeliveries("hook1", 0)
+	c.Assert(err, check.IsNil)
+	c.Assert(deliveries, check.HasLen, 1)
+	c.Assert(deliveries[0].Error, check.Matches, `.*webhook address "127.0.0.1" resolves to private address 127.0.0.1`)
+}
+
+func (s *S) TestHandleEventTemplateCantChangeEvent(c *check.C) {
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(data))
+	}))
+	defer srv.Close()
+	err := Create(Webhook{Name: "hook1", TeamOwner: "myteam", URL: srv.URL, Body: "{{.Abort}}"})
+	c.Assert(err, check.IsNil)
+	err = Create(Webhook{Name: "hook2", TeamOwner: "myteam", URL: srv.URL, Body: "{{.UniqueID}} {{.Kind.Name}}"})
+	c.Assert(err, check.IsNil)
+	evt := newEvent(c, "myteam", nil)
+	n := newNotifier(0, 1)
+	err = n.handleEvent(evt.UniqueID)
+	c.Assert(err, check.IsNil)
+	c.Assert(bodies, check.DeepEquals, []string{evt.UniqueID.Hex() + " app-test"})
+	deliveries, err := ListDeliveries("hook1", 0)
+	c.Assert(err, check.IsNil)
+	c.Assert(deliveries, check.HasLen, 1)
+	c.Assert(deliveries[0].Error, check.Matches, `.*can't evaluate field Abort.*`)
+	evts, err := event.List(&event.Filter{Raw: bson.M{"uniqueid": evt.UniqueID}})
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 1)
+}
@@ -33,7 +33,9 @@ var (
 	PermAppRun                           = PermissionRegistry.get("app.run")                             // [global app team pool]
 	PermAppRunShell                      = PermissionRegistry.get("app.run.shell")                       // [global app team pool]
 	PermAppUpdate                        = PermissionRegistry.get("app.update")                          // [global app team pool]
+	PermAppUpdateAutoscale               = PermissionRegistry.get("app.update.autoscale")                // [global app team pool]
 	PermAppUpdateBind                    = PermissionRegistry.get("app.update.bind")                     // [global app team pool]
+	PermAppUpdateBindRebind              = PermissionRegistry.get("app.update.bind.rebind")              // [global app team pool]
 	PermAppUpdateCertificate             = PermissionRegistry.get("app.update.certificate")              // [global app team pool]
 	PermAppUpdateCertificateSet          = PermissionRegistry.get("app.update.certificate.set")          // [global app team pool]
 	PermAppUpdateCertificateUnset        = PermissionRegistry.get("app.update.certificate.unset")        // [global app team pool]
@@ -51,6 +53,11 @@ var (
 	PermAppUpdatePool                    = PermissionRegistry.get("app.update.pool")                     // [global app team pool]
 	PermAppUpdateRestart                 = PermissionRegistry.get("app.update.restart")                  // [global app team pool]
 	PermAppUpdateRevoke                  = PermissionRegistry.get("app.update.revoke")                   // [global app team pool]
+	PermAppUpdateRouter                  = PermissionRegistry.get("app.update.router")                   // [global app team pool]
+	PermAppUpdateRouterAdd               = PermissionRegistry.get("app.update.router.add")               // [global app team pool]
+	PermAppUpdateRouterRemove            = PermissionRegistry.get("app.update.router.remove")            // [global app team pool]
+	PermAppUpdateRoutes                  = PermissionRegistry.get("app.update.routes")                   // [global app team pool]
+	PermAppUpdateRoutesWeights           = PermissionRegistry.get("app.update.routes.weights")           // [global app team pool]
 	PermAppUpdateSleep                   = PermissionRegistry.get("app.update.sleep")                    // [global app team pool]
 	PermAppUpdateStart                   = PermissionRegistry.get("app.update.start")                    // [global app team pool]
 	PermAppUpdateStop                    = PermissionRegistry.get("app.update.stop")                     // [global app team pool]
@@ -104,6 +111,7 @@ var (
 	PermPlanDelete                       = PermissionRegistry.get("plan.delete")                         // [global]
 	PermPlanRead                         = PermissionRegistry.get("plan.read")                           // [global]
 	PermPlanReadEvents                   = PermissionRegistry.get("plan.read.events")                    // [global]
+	PermPlanUpdate                       = PermissionRegistry.get("plan.update")                         // [global]
 	PermPlatform                         = PermissionRegistry.get("platform")                            // [global]
 	PermPlatformCreate                   = PermissionRegistry.get("platform.create")                     // [global]
 	PermPlatformDelete                   = PermissionRegistry.get("platform.delete")                     // [global]
@@ -134,6 +142,11 @@ var (
 	PermRoleUpdatePermission             = PermissionRegistry.get("role.update.permission")              // [global]
 	PermRoleUpdatePermissionAdd          = PermissionRegistry.get("role.update.permission.add")          // [global]
 	PermRoleUpdatePermissionRemove       = PermissionRegistry.get("role.update.permission.remove")       // [global]
+	PermRouter                           = PermissionRegistry.get("router")                              // [global]
+	PermRouterRead                       = PermissionRegistry.get("router.read")                         // [global]
+	PermRouterReadDrift                  = PermissionRegistry.get("router.read.drift")                   // [global]
+	PermRouterUpdate                     = PermissionRegistry.get("router.update")                       // [global]
+	PermRouterUpdateRoutes               = PermissionRegistry.get("router.update.routes")                // [global]
 	PermService                          = PermissionRegistry.get("service")                             // [global service team]
 	PermServiceInstance                  = PermissionRegistry.get("service-instance")                    // [global service-instance team]
 	PermServiceInstanceCreate            = PermissionRegistry.get("service-instance.create")             // [global team]
@@ -145,6 +158,8 @@ var (
 	PermServiceInstanceUpdateBind        = PermissionRegistry.get("service-instance.update.bind")        // [global service-instance team]
 	PermServiceInstanceUpdateDescription = PermissionRegistry.get("service-instance.update.description") // [global service-instance team]
 	PermServiceInstanceUpdateGrant       = PermissionRegistry.get("service-instance.update.grant")       // [global service-instance team]
+	PermServiceInstanceUpdateParameters  = PermissionRegistry.get("service-instance.update.parameters")  // [global service-instance team]
+	PermServiceInstanceUpdatePlan        = PermissionRegistry.get("service-instance.update.plan")        // [global service-instance team]
 	PermServiceInstanceUpdateProxy       = PermissionRegistry.get("service-instance.update.proxy")       // [global service-instance team]
 	PermServiceInstanceUpdateRevoke      = PermissionRegistry.get("service-instance.update.revoke")      // [global service-instance team]
 	PermServiceInstanceUpdateUnbind      = PermissionRegistry.get("service-instance.update.unbind")      // [global service-instance team]
@@ -164,12 +179,22 @@ var (
 	PermTeamDelete                       = PermissionRegistry.get("team.delete")                         // [global team]
 	PermTeamRead                         = PermissionRegistry.get("team.read")                           // [global team]
 	PermTeamReadEvents                   = PermissionRegistry.get("team.read.events")                    // [global team]
+	PermTeamReadServiceAccounts          = PermissionRegistry.get("team.read.service-accounts")          // [global team]
+	PermTeamUpdate                       = PermissionRegistry.get("team.update")                         // [global team]
+	PermTeamUpdateServiceAccount         = PermissionRegistry.get("team.update.service-account")         // [global team]
+	PermTeamUpdateServiceAccountCreate   = PermissionRegistry.get("team.update.service-account.create")  // [global team]
+	PermTeamUpdateServiceAccountRemove   = PermissionRegistry.get("team.update.service-account.remove")  // [global team]
+	PermTeamUpdateServiceAccountToken    = PermissionRegistry.get("team.update.service-account.token")   // [global team]
 	PermUser                             = PermissionRegistry.get("user")                                // [global user]
 	PermUserCreate                       = PermissionRegistry.get("user.create")                         // [global]
 	PermUserDelete                       = PermissionRegistry.get("user.delete")                         // [global user]
 	PermUserRead                         = PermissionRegistry.get("user.read")                           // [global user]
+	PermUserReadAccessTokens             = PermissionRegistry.get("user.read.access-tokens")             // [global user]
 	PermUserReadEvents                   = PermissionRegistry.get("user.read.events")                    // [global user]
 	PermUserUpdate                       = PermissionRegistry.get("user.update")                         // [global user]
+	PermUserUpdateAccessToken            = PermissionRegistry.get("user.update.access-token")            // [global user]
+	PermUserUpdateAccessTokenCreate      = PermissionRegistry.get("user.update.access-token.create")     // [global user]
+	PermUserUpdateAccessTokenRevoke      = PermissionRegistry.get("user.update.access-token.revoke")     // [global user]
 	PermUserUpdateKey                    = PermissionRegistry.get("user.update.key")                     // [global user]
 	PermUserUpdateKeyAdd                 = PermissionRegistry.get("user.update.key.add")                 // [global user]
 	PermUserUpdateKeyRemove              = PermissionRegistry.get("user.update.key.remove")              // [global user]
@@ -177,4 +202,11 @@ var (
 	PermUserUpdateQuota                  = PermissionRegistry.get("user.update.quota")                   // [global user]
 	PermUserUpdateReset                  = PermissionRegistry.get("user.update.reset")                   // [global user]
 	PermUserUpdateToken                  = PermissionRegistry.get("user.update.token")                   // [global user]
+	PermUserUpdateTwoFactor              = PermissionRegistry.get("user.update.two-factor")              // [global user]
+	PermWebhook                          = PermissionRegistry.get("webhook")                             // [global team]
+	PermWebhookCreate                    = PermissionRegistry.get("webhook.create")                      // [global team]
+	PermWebhookDelete                    = PermissionRegistry.get("webhook.delete")                      // [global team]
+	PermWebhookRead                      = PermissionRegistry.get("webhook.read")                        // [global team]
+	PermWebhookReadEvents                = PermissionRegistry.get("webhook.read.events")                 // [global team]
+	PermWebhookUpdate                    = PermissionRegistry.get("webhook.update")                      // [global team]
 )
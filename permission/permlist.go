@@ -18,6 +18,7 @@ var PermissionRegistry = (&registry{}).addWithCtx(
 	"app.update.unit.remove",
 	"app.update.unit.register",
 	"app.update.unit.status",
+	"app.update.autoscale",
 	"app.update.env.set",
 	"app.update.env.unset",
 	"app.update.restart",
@@ -30,8 +31,12 @@ var PermissionRegistry = (&registry{}).addWithCtx(
 	"app.update.teamowner",
 	"app.update.cname.add",
 	"app.update.cname.remove",
+	"app.update.routes.weights",
+	"app.update.router.add",
+	"app.update.router.remove",
 	"app.update.plan",
 	"app.update.bind",
+	"app.update.bind.rebind",
 	"app.update.events",
 	"app.update.unbind",
 	"app.update.certificate.set",
@@ -89,6 +94,10 @@ var PermissionRegistry = (&registry{}).addWithCtx(
 	"team.create", []contextType{},
 ).add(
 	"team.read.events",
+	"team.read.service-accounts",
+	"team.update.service-account.create",
+	"team.update.service-account.remove",
+	"team.update.service-account.token",
 	"team.delete",
 ).addWithCtx(
 	"user", []contextType{CtxUser},
@@ -97,9 +106,13 @@ var PermissionRegistry = (&registry{}).addWithCtx(
 ).add(
 	"user.delete",
 	"user.read.events",
+	"user.read.access-tokens",
 	"user.update.token",
+	"user.update.access-token.create",
+	"user.update.access-token.revoke",
 	"user.update.quota",
 	"user.update.password",
+	"user.update.two-factor",
 	"user.update.reset",
 	"user.update.key.add",
 	"user.update.key.remove",
@@ -130,6 +143,8 @@ var PermissionRegistry = (&registry{}).addWithCtx(
 	"service-instance.update.grant",
 	"service-instance.update.revoke",
 	"service-instance.update.description",
+	"service-instance.update.plan",
+	"service-instance.update.parameters",
 ).add(
 	"role.create",
 	"role.delete",
@@ -148,6 +163,7 @@ var PermissionRegistry = (&registry{}).addWithCtx(
 ).add(
 	"plan.create",
 	"plan.delete",
+	"plan.update",
 	"plan.read.events",
 ).addWithCtx(
 	"pool", []contextType{CtxPool},
@@ -179,4 +195,17 @@ var PermissionRegistry = (&registry{}).addWithCtx(
 	"nodecontainer.delete",
 ).add(
 	"install.manage",
+).addWithCtx(
+	"webhook", []contextType{CtxTeam},
+).add(
+	"webhook.create",
+	"webhook.read",
+	"webhook.read.events",
+	"webhook.update",
+	"webhook.delete",
+).addWithCtx(
+	"router", []contextType{},
+).add(
+	"router.read.drift",
+	"router.update.routes",
 )
@@ -55,6 +55,7 @@ type changeUnitsPipelineArgs struct {
 	appDestroy  bool
 	exposedPort string
 	event       *event.Event
+	strategy    provision.DeployStrategy
 }
 
 type callbackFunc func(*container.Container, chan *container.Container) error
@@ -443,7 +444,7 @@ var addNewRoutes = action.Action{
 			log.Errorf("[WARNING] cannot get the name of the web process: %s", err)
 		}
 		newContainers := ctx.Previous.([]container.Container)
-		r, err := getRouterForApp(args.app)
+		routers, err := getRoutersForApp(args.app)
 		if err != nil {
 			return nil, err
 		}
@@ -467,10 +468,21 @@ var addNewRoutes = action.Action{
 		if len(routesToAdd) == 0 {
 			return newContainers, nil
 		}
-		err = r.AddRoutes(args.app.GetName(), routesToAdd)
-		if err != nil {
-			r.RemoveRoutes(args.app.GetName(), routesToAdd)
-			return nil, err
+		if args.strategy.ShiftsRoutes() {
+			err = args.strategy.ShiftRoutes(routers, args.app.GetName(), oldWebRoutes(args), routesToAdd, writer, args.event)
+			if err != nil {
+				return nil, err
+			}
+			return newContainers, nil
+		}
+		for i, r := range routers {
+			err = r.AddRoutes(args.app.GetName(), routesToAdd)
+			if err != nil {
+				for _, added := range routers[:i+1] {
+					added.RemoveRoutes(args.app.GetName(), routesToAdd)
+				}
+				return nil, err
+			}
 		}
 		for _, c := range newContainers {
 			if c.Routable {
@@ -482,14 +494,23 @@ var addNewRoutes = action.Action{
 	Backward: func(ctx action.BWContext) {
 		args := ctx.Params[0].(changeUnitsPipelineArgs)
 		newContainers := ctx.FWResult.([]container.Container)
-		r, err := getRouterForApp(args.app)
+		routers, err := getRoutersForApp(args.app)
 		if err != nil {
-			log.Errorf("[add-new-routes:Backward] Error geting router: %s", err)
+			log.Errorf("[add-new-routes:Backward] Error geting routers: %s", err)
 		}
 		w := args.writer
 		if w == nil {
 			w = ioutil.Discard
 		}
+		if args.strategy.ShiftsRoutes() {
+			fmt.Fprintf(w, "\n---- Adding back routes to old units ----\n")
+			for _, r := range routers {
+				err = r.AddRoutes(args.app.GetName(), oldWebRoutes(args))
+				if err != nil {
+					log.Errorf("[add-new-routes:Backward] Error adding back routes to old units: %s", err)
+				}
+			}
+		}
 		fmt.Fprintf(w, "\n---- Removing routes from created units ----\n")
 		var routesToRemove []*url.URL
 		for _, c := range newContainers {
@@ -500,10 +521,12 @@ var addNewRoutes = action.Action{
 		if len(routesToRemove) == 0 {
 			return
 		}
-		err = r.RemoveRoutes(args.app.GetName(), routesToRemove)
-		if err != nil {
-			log.Errorf("[add-new-routes:Backward] Error removing route for [%v]: %s", routesToRemove, err)
-			return
+		for _, r := range routers {
+			err = r.RemoveRoutes(args.app.GetName(), routesToRemove)
+			if err != nil {
+				log.Errorf("[add-new-routes:Backward] Error removing route for [%v]: %s", routesToRemove, err)
+				return
+			}
 		}
 		for _, c := range newContainers {
 			if c.Routable {
@@ -514,6 +537,26 @@ var addNewRoutes = action.Action{
 	OnError: rollbackNotice,
 }
 
+// oldWebRoutes returns the addresses of the web units being replaced by the
+// pipeline.
+func oldWebRoutes(args changeUnitsPipelineArgs) []*url.URL {
+	currentImageName, err := image.AppCurrentImageName(args.app.GetName())
+	if err != nil && err != image.ErrNoImagesAvailable {
+		log.Errorf("[WARNING] cannot get the current image of the app: %s", err)
+	}
+	webProcessName, err := image.GetImageWebProcessName(currentImageName)
+	if err != nil {
+		log.Errorf("[WARNING] cannot get the name of the web process for old routes: %s", err)
+	}
+	var routes []*url.URL
+	for _, c := range args.toRemove {
+		if c.ProcessName == webProcessName && c.ValidAddr() {
+			routes = append(routes, c.Address())
+		}
+	}
+	return routes
+}
+
 var setRouterHealthcheck = action.Action{
 	Name:    "set-router-healthcheck",
 	OnError: rollbackNotice,
@@ -591,7 +634,11 @@ var removeOldRoutes = action.Action{
 				err = nil
 			}()
 		}
-		r, err := getRouterForApp(args.app)
+		if args.strategy.ShiftsRoutes() {
+			// routes were already moved by add-new-routes
+			return
+		}
+		routers, err := getRoutersForApp(args.app)
 		if err != nil {
 			return
 		}
@@ -623,12 +670,16 @@ var removeOldRoutes = action.Action{
 		if len(routesToRemove) == 0 {
 			return
 		}
-		err = r.RemoveRoutes(args.app.GetName(), routesToRemove)
-		if err != nil {
-			if !args.appDestroy {
-				r.AddRoutes(args.app.GetName(), routesToRemove)
+		for i, r := range routers {
+			err = r.RemoveRoutes(args.app.GetName(), routesToRemove)
+			if err != nil {
+				if !args.appDestroy {
+					for _, removed := range routers[:i+1] {
+						removed.AddRoutes(args.app.GetName(), routesToRemove)
+					}
+				}
+				return
 			}
-			return
 		}
 		for _, c := range args.toRemove {
 			if c.Routable {
@@ -639,9 +690,9 @@ var removeOldRoutes = action.Action{
 	},
 	Backward: func(ctx action.BWContext) {
 		args := ctx.Params[0].(changeUnitsPipelineArgs)
-		r, err := getRouterForApp(args.app)
+		routers, err := getRoutersForApp(args.app)
 		if err != nil {
-			log.Errorf("[remove-old-routes:Backward] Error geting router: %s", err)
+			log.Errorf("[remove-old-routes:Backward] Error geting routers: %s", err)
 		}
 		w := args.writer
 		if w == nil {
@@ -657,10 +708,12 @@ var removeOldRoutes = action.Action{
 		if len(routesToAdd) == 0 {
 			return
 		}
-		err = r.AddRoutes(args.app.GetName(), routesToAdd)
-		if err != nil {
-			log.Errorf("[remove-old-routes:Backward] Error adding back route for [%v]: %s", routesToAdd, err)
-			return
+		for _, r := range routers {
+			err = r.AddRoutes(args.app.GetName(), routesToAdd)
+			if err != nil {
+				log.Errorf("[remove-old-routes:Backward] Error adding back route for [%v]: %s", routesToAdd, err)
+				return
+			}
 		}
 		for _, c := range args.toRemove {
 			if c.Routable {
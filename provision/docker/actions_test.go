@@ -5,6 +5,7 @@
 package docker
 
 import (
+	"bytes"
 	"net"
 	"net/http"
 	"net/http/httptest"
@@ -453,6 +454,118 @@ func (s *S) TestAddNewRouteBackward(c *check.C) {
 	c.Assert(hasRoute, check.Equals, false)
 }
 
+func (s *S) TestAddNewRouteForwardCanaryStrategy(c *check.C) {
+	app := provisiontest.NewFakeApp("myapp", "python", 1)
+	imageName := "tsuru/app-" + app.GetName()
+	customData := map[string]interface{}{
+		"processes": map[string]interface{}{
+			"web": "python myapi.py",
+		},
+	}
+	err := image.SaveImageCustomData(imageName, customData)
+	c.Assert(err, check.IsNil)
+	routertest.FakeRouter.AddBackend(app.GetName())
+	defer routertest.FakeRouter.RemoveBackend(app.GetName())
+	old1 := container.Container{ID: "old-1", AppName: app.GetName(), ProcessName: "web", HostAddr: "127.0.0.1", HostPort: "1234"}
+	old2 := container.Container{ID: "old-2", AppName: app.GetName(), ProcessName: "web", HostAddr: "127.0.0.2", HostPort: "1234"}
+	cont1 := container.Container{ID: "ble-1", AppName: app.GetName(), ProcessName: "web", HostAddr: "127.0.0.3", HostPort: "1234"}
+	cont2 := container.Container{ID: "ble-2", AppName: app.GetName(), ProcessName: "web", HostAddr: "127.0.0.4", HostPort: "1234"}
+	err = routertest.FakeRouter.AddRoutes(app.GetName(), []*url.URL{old1.Address(), old2.Address()})
+	c.Assert(err, check.IsNil)
+	var buf bytes.Buffer
+	args := changeUnitsPipelineArgs{
+		app:         app,
+		provisioner: s.p,
+		imageId:     imageName,
+		toRemove:    []container.Container{old1, old2},
+		writer:      &buf,
+		strategy:    provision.DeployStrategy{Type: provision.StrategyCanary, StepPercent: 50},
+	}
+	context := action.FWContext{Previous: []container.Container{cont1, cont2}, Params: []interface{}{args}}
+	r, err := addNewRoutes.Forward(context)
+	c.Assert(err, check.IsNil)
+	containers := r.([]container.Container)
+	c.Assert(containers[0].Routable, check.Equals, true)
+	c.Assert(containers[1].Routable, check.Equals, true)
+	for _, cont := range []container.Container{cont1, cont2} {
+		c.Assert(routertest.FakeRouter.HasRoute(app.GetName(), cont.Address().String()), check.Equals, true)
+	}
+	for _, cont := range []container.Container{old1, old2} {
+		c.Assert(routertest.FakeRouter.HasRoute(app.GetName(), cont.Address().String()), check.Equals, false)
+	}
+	c.Assert(buf.String(), check.Matches, `(?s).*Step 1/2: 50% of traffic on new units \(1 new routes, 1 old routes\).*Step 2/2: 100% of traffic on new units \(2 new routes, 0 old routes\).*`)
+	context = action.FWContext{Previous: containers, Params: []interface{}{args}}
+	_, err = removeOldRoutes.Forward(context)
+	c.Assert(err, check.IsNil)
+	c.Assert(args.toRemove[0].Routable, check.Equals, false)
+	c.Assert(args.toRemove[1].Routable, check.Equals, false)
+}
+
+func (s *S) TestAddNewRouteForwardCanaryStrategyFailureRestoresRoutes(c *check.C) {
+	app := provisiontest.NewFakeApp("myapp", "python", 1)
+	imageName := "tsuru/app-" + app.GetName()
+	customData := map[string]interface{}{
+		"processes": map[string]interface{}{
+			"web": "python myapi.py",
+		},
+	}
+	err := image.SaveImageCustomData(imageName, customData)
+	c.Assert(err, check.IsNil)
+	routertest.FakeRouter.AddBackend(app.GetName())
+	defer routertest.FakeRouter.RemoveBackend(app.GetName())
+	old1 := container.Container{ID: "old-1", AppName: app.GetName(), ProcessName: "web", HostAddr: "127.0.0.1", HostPort: "1234"}
+	old2 := container.Container{ID: "old-2", AppName: app.GetName(), ProcessName: "web", HostAddr: "127.0.0.2", HostPort: "1234"}
+	cont1 := container.Container{ID: "ble-1", AppName: app.GetName(), ProcessName: "web", HostAddr: "127.0.0.3", HostPort: "1234"}
+	cont2 := container.Container{ID: "ble-2", AppName: app.GetName(), ProcessName: "web", HostAddr: "127.0.0.4", HostPort: "1234"}
+	err = routertest.FakeRouter.AddRoutes(app.GetName(), []*url.URL{old1.Address(), old2.Address()})
+	c.Assert(err, check.IsNil)
+	routertest.FakeRouter.FailForIp(cont2.Address().Host)
+	defer routertest.FakeRouter.RemoveFailForIp(cont2.Address().Host)
+	args := changeUnitsPipelineArgs{
+		app:         app,
+		provisioner: s.p,
+		imageId:     imageName,
+		toRemove:    []container.Container{old1, old2},
+		strategy:    provision.DeployStrategy{Type: provision.StrategyCanary, StepPercent: 50},
+	}
+	context := action.FWContext{Previous: []container.Container{cont1, cont2}, Params: []interface{}{args}}
+	_, err = addNewRoutes.Forward(context)
+	c.Assert(err, check.Equals, routertest.ErrForcedFailure)
+	routertest.FakeRouter.RemoveFailForIp(cont2.Address().Host)
+	c.Assert(routertest.FakeRouter.HasRoute(app.GetName(), cont1.Address().String()), check.Equals, false)
+	c.Assert(routertest.FakeRouter.HasRoute(app.GetName(), old1.Address().String()), check.Equals, true)
+	c.Assert(routertest.FakeRouter.HasRoute(app.GetName(), old2.Address().String()), check.Equals, true)
+}
+
+func (s *S) TestAddNewRouteBackwardWithStrategy(c *check.C) {
+	app := provisiontest.NewFakeApp("myapp", "python", 1)
+	imageName := "tsuru/app-" + app.GetName()
+	customData := map[string]interface{}{
+		"processes": map[string]interface{}{
+			"web": "python myapi.py",
+		},
+	}
+	err := image.SaveImageCustomData(imageName, customData)
+	c.Assert(err, check.IsNil)
+	routertest.FakeRouter.AddBackend(app.GetName())
+	defer routertest.FakeRouter.RemoveBackend(app.GetName())
+	old1 := container.Container{ID: "old-1", AppName: app.GetName(), ProcessName: "web", HostAddr: "127.0.0.1", HostPort: "1234"}
+	cont1 := container.Container{ID: "ble-1", AppName: app.GetName(), ProcessName: "web", HostAddr: "127.0.0.3", HostPort: "1234"}
+	err = routertest.FakeRouter.AddRoute(app.GetName(), cont1.Address())
+	c.Assert(err, check.IsNil)
+	cont1.Routable = true
+	args := changeUnitsPipelineArgs{
+		app:         app,
+		provisioner: s.p,
+		toRemove:    []container.Container{old1},
+		strategy:    provision.DeployStrategy{Type: provision.StrategyBlueGreen},
+	}
+	context := action.BWContext{FWResult: []container.Container{cont1}, Params: []interface{}{args}}
+	addNewRoutes.Backward(context)
+	c.Assert(routertest.FakeRouter.HasRoute(app.GetName(), cont1.Address().String()), check.Equals, false)
+	c.Assert(routertest.FakeRouter.HasRoute(app.GetName(), old1.Address().String()), check.Equals, true)
+}
+
 func (s *S) TestSetRouterHealthcheckForward(c *check.C) {
 	app := provisiontest.NewFakeApp("myapp", "python", 1)
 	imageName := "tsuru/app-" + app.GetName()
@@ -79,6 +79,10 @@ func (p *dockerProvisioner) HandleMoveErrors(moveErrors chan error, writer io.Wr
 }
 
 func (p *dockerProvisioner) runReplaceUnitsPipeline(w io.Writer, a provision.App, toAdd map[string]*containersToAdd, toRemoveContainers []container.Container, imageId string, toHosts ...string) ([]container.Container, error) {
+	return p.runReplaceUnitsPipelineWithStrategy(w, a, toAdd, toRemoveContainers, imageId, provision.DeployStrategy{}, toHosts...)
+}
+
+func (p *dockerProvisioner) runReplaceUnitsPipelineWithStrategy(w io.Writer, a provision.App, toAdd map[string]*containersToAdd, toRemoveContainers []container.Container, imageId string, strategy provision.DeployStrategy, toHosts ...string) ([]container.Container, error) {
 	var toHost string
 	if len(toHosts) > 0 {
 		toHost = toHosts[0]
@@ -96,6 +100,7 @@ func (p *dockerProvisioner) runReplaceUnitsPipeline(w io.Writer, a provision.App
 		imageId:     imageId,
 		provisioner: p,
 		event:       evt,
+		strategy:    strategy,
 	}
 	var pipeline *action.Pipeline
 	if p.isDryMode {
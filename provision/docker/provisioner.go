@@ -47,6 +47,7 @@ import (
 	_ "github.com/tsuru/tsuru/router/fusis"
 	_ "github.com/tsuru/tsuru/router/galeb"
 	_ "github.com/tsuru/tsuru/router/hipache"
+	_ "github.com/tsuru/tsuru/router/nginx"
 	_ "github.com/tsuru/tsuru/router/routertest"
 	_ "github.com/tsuru/tsuru/router/vulcand"
 	"github.com/tsuru/tsuru/safe"
@@ -55,7 +56,7 @@ import (
 var (
 	mainDockerProvisioner *dockerProvisioner
 
-	ErrDeployCanceled = errors.New("deploy canceled by user action")
+	ErrDeployCanceled = provision.ErrDeployCanceled
 )
 
 const provisionerName = "docker"
@@ -75,6 +76,21 @@ func getRouterForApp(app provision.App) (router.Router, error) {
 	return router.Get(routerName)
 }
 
+func getRoutersForApp(app provision.App) ([]router.Router, error) {
+	appRouters, err := app.GetRouters()
+	if err != nil {
+		return nil, err
+	}
+	routers := make([]router.Router, len(appRouters))
+	for i, appRouter := range appRouters {
+		routers[i], err = router.Get(appRouter.Name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return routers, nil
+}
+
 type dockerProvisioner struct {
 	cluster        *cluster.Cluster
 	collectionName string
@@ -563,7 +579,8 @@ func (p *dockerProvisioner) deploy(a provision.App, imageId string, evt *event.E
 		if err = setQuota(a, toAdd); err != nil {
 			return err
 		}
-		_, err = p.runReplaceUnitsPipeline(evt, a, toAdd, containers, imageId)
+		strategy := provision.DeployStrategyFromEvent(evt)
+		_, err = p.runReplaceUnitsPipelineWithStrategy(evt, a, toAdd, containers, imageId, strategy)
 	}
 	return err
 }
@@ -1093,25 +1110,104 @@ func (p *dockerProvisioner) Nodes(app provision.App) ([]cluster.Node, error) {
 }
 
 func (p *dockerProvisioner) MetricEnvs(app provision.App) map[string]string {
-	bsContainer, err := nodecontainer.LoadNodeContainer(app.GetPool(), nodecontainer.BsDefaultName)
+	return nodecontainer.BsMetricEnvs(app.GetPool())
+}
+
+// UnitsMetrics returns the CPU and memory usage of each container of the app,
+// read from the docker stats API, along with the requests per second sent to
+// the container when the app router reports them. Containers whose stats
+// cannot be read are left out of the result.
+func (p *dockerProvisioner) UnitsMetrics(app provision.App) ([]provision.UnitMetrics, error) {
+	containers, err := p.listContainersByApp(app.GetName())
 	if err != nil {
-		return map[string]string{}
+		return nil, err
 	}
-	envs := bsContainer.EnvMap()
-	for envName := range envs {
-		if !strings.HasPrefix(envName, "METRICS_") {
-			delete(envs, envName)
+	requests := routesRequests(app)
+	metrics := make([]provision.UnitMetrics, 0, len(containers))
+	for _, c := range containers {
+		stats, err := p.containerStats(&c)
+		if err != nil {
+			log.Errorf("[units metrics] unable to read stats from container %s: %s", c.ShortID(), err)
+			continue
+		}
+		values := statsToMetricValues(stats)
+		if rps, ok := requests[c.Address().Host]; ok {
+			values[provision.UnitMetricRequests] = rps
 		}
+		metrics = append(metrics, provision.UnitMetrics{
+			ID:          c.ID,
+			ProcessName: c.ProcessName,
+			Values:      values,
+		})
+	}
+	return metrics, nil
+}
+
+// routesRequests returns the requests per second sent by the app router to
+// each route of the app, keyed by the host of the route. Routers unable to
+// report requests result in no requests metric.
+func routesRequests(app provision.App) map[string]float64 {
+	r, err := getRouterForApp(app)
+	if err != nil {
+		log.Errorf("[units metrics] unable to get router for app %s: %s", app.GetName(), err)
+		return nil
+	}
+	requestsRouter, ok := r.(router.RequestsRouter)
+	if !ok {
+		return nil
 	}
-	return envs
+	requests, err := requestsRouter.RoutesRequests(app.GetName())
+	if err != nil {
+		log.Errorf("[units metrics] unable to get requests of app %s from router: %s", app.GetName(), err)
+		return nil
+	}
+	return requests
+}
+
+func (p *dockerProvisioner) containerStats(c *container.Container) (*docker.Stats, error) {
+	node, err := p.GetNodeByHost(c.HostAddr)
+	if err != nil {
+		return nil, err
+	}
+	client, err := node.Client()
+	if err != nil {
+		return nil, err
+	}
+	statsCh := make(chan *docker.Stats, 1)
+	err = client.Stats(docker.StatsOptions{
+		ID:      c.ID,
+		Stats:   statsCh,
+		Stream:  false,
+		Timeout: 10 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+	stats := <-statsCh
+	if stats == nil {
+		return nil, errors.New("no stats received")
+	}
+	return stats, nil
+}
+
+func statsToMetricValues(stats *docker.Stats) map[string]float64 {
+	values := map[string]float64{}
+	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(stats.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(stats.CPUStats.SystemCPUUsage) - float64(stats.PreCPUStats.SystemCPUUsage)
+	if cpuDelta >= 0 && systemDelta > 0 {
+		cpus := len(stats.CPUStats.CPUUsage.PercpuUsage)
+		if cpus == 0 {
+			cpus = 1
+		}
+		values[provision.UnitMetricCPU] = cpuDelta / systemDelta * float64(cpus) * 100
+	}
+	if stats.MemoryStats.Limit > 0 {
+		values[provision.UnitMetricMemory] = float64(stats.MemoryStats.Usage) / float64(stats.MemoryStats.Limit) * 100
+	}
+	return values
 }
 
 func (p *dockerProvisioner) LogsEnabled(app provision.App) (bool, string, error) {
-	const (
-		logBackendsEnv      = "LOG_BACKENDS"
-		logDocKeyFormat     = "LOG_%s_DOC"
-		tsuruLogBackendName = "tsuru"
-	)
 	isBS, err := container.LogIsBS(app.GetPool())
 	if err != nil {
 		return false, "", err
@@ -1121,37 +1217,7 @@ func (p *dockerProvisioner) LogsEnabled(app provision.App) (bool, string, error)
 		msg := fmt.Sprintf("Logs not available through tsuru. Enabled log driver is %q.", driver)
 		return false, msg, nil
 	}
-	bsContainer, err := nodecontainer.LoadNodeContainer(app.GetPool(), nodecontainer.BsDefaultName)
-	if err != nil {
-		return false, "", err
-	}
-	envs := bsContainer.EnvMap()
-	enabledBackends := envs[logBackendsEnv]
-	if enabledBackends == "" {
-		return true, "", nil
-	}
-	backendsList := strings.Split(enabledBackends, ",")
-	for i := range backendsList {
-		backendsList[i] = strings.TrimSpace(backendsList[i])
-		if backendsList[i] == tsuruLogBackendName {
-			return true, "", nil
-		}
-	}
-	var docs []string
-	for _, backendName := range backendsList {
-		keyName := fmt.Sprintf(logDocKeyFormat, strings.ToUpper(backendName))
-		backendDoc := envs[keyName]
-		var docLine string
-		if backendDoc == "" {
-			docLine = fmt.Sprintf("* %s", backendName)
-		} else {
-			docLine = fmt.Sprintf("* %s: %s", backendName, backendDoc)
-		}
-		docs = append(docs, docLine)
-	}
-	fullDoc := fmt.Sprintf("Logs not available through tsuru. Enabled log backends are:\n%s",
-		strings.Join(docs, "\n"))
-	return false, fullDoc, nil
+	return nodecontainer.BsLogsEnabled(app.GetPool())
 }
 
 func pluralize(str string, sz int) string {
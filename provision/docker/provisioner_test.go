@@ -2991,6 +2991,24 @@ func (s *S) TestMetricEnvs(c *check.C) {
 	c.Assert(envs, check.DeepEquals, expected)
 }
 
+func (s *S) TestStatsToMetricValues(c *check.C) {
+	var stats docker.Stats
+	stats.CPUStats.CPUUsage.TotalUsage = 300
+	stats.CPUStats.CPUUsage.PercpuUsage = []uint64{150, 150}
+	stats.CPUStats.SystemCPUUsage = 2000
+	stats.PreCPUStats.CPUUsage.TotalUsage = 100
+	stats.PreCPUStats.SystemCPUUsage = 1000
+	stats.MemoryStats.Usage = 256
+	stats.MemoryStats.Limit = 1024
+	values := statsToMetricValues(&stats)
+	c.Assert(values, check.DeepEquals, map[string]float64{
+		provision.UnitMetricCPU:    40,
+		provision.UnitMetricMemory: 25,
+	})
+	values = statsToMetricValues(&docker.Stats{})
+	c.Assert(values, check.DeepEquals, map[string]float64{})
+}
+
 func (s *S) TestAddContainerDefaultProcess(c *check.C) {
 	customData := map[string]interface{}{
 		"processes": map[string]interface{}{
@@ -3803,3 +3821,14 @@ func (s *S) TestRebalanceNodesDry(c *check.C) {
 	c.Assert(err, check.IsNil)
 	c.Assert(containers, check.HasLen, 4)
 }
+
+func (s *S) TestRoutesRequests(c *check.C) {
+	a := provisiontest.NewFakeApp("myapp", "python", 1)
+	err := routertest.FakeRouter.AddBackend(a.GetName())
+	c.Assert(err, check.IsNil)
+	routertest.FakeRouter.SetRoutesRequests(a.GetName(), map[string]float64{"10.0.0.1:1234": 42})
+	c.Assert(routesRequests(a), check.DeepEquals, map[string]float64{"10.0.0.1:1234": 42})
+	err = routertest.FakeRouter.RemoveBackend(a.GetName())
+	c.Assert(err, check.IsNil)
+	c.Assert(routesRequests(a), check.IsNil)
+}
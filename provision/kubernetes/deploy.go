@@ -0,0 +1,130 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kubernetes
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/servicecommon"
+	"k8s.io/kubernetes/pkg/api"
+	k8sErrors "k8s.io/kubernetes/pkg/api/errors"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+)
+
+const (
+	buildContainerName  = "build"
+	commitContainerName = "committer"
+)
+
+// commitCmds returns the commands used by the committer sidecar. It waits for
+// the build container in the same pod to finish, commits its filesystem as
+// destinationImage and pushes the resulting image to the registry.
+func commitCmds(destinationImage string) []string {
+	script := strings.Join([]string{
+		fmt.Sprintf(`while id=$(docker ps -aq -f "label=io.kubernetes.container.name=%s" -f "label=io.kubernetes.pod.name=$(hostname)" -f status=exited) && [ -z "$id" ]; do sleep 1; done`, buildContainerName),
+		`[ "$(docker inspect -f '{{.State.ExitCode}}' $id)" = "0" ] || exit 1`,
+		fmt.Sprintf("docker commit $id %s", destinationImage),
+		servicecommon.PushImageCmd(destinationImage),
+	}, " && ")
+	return []string{"/bin/sh", "-c", script}
+}
+
+// retagCmds returns the commands used to import an user provided image as
+// a tsuru app image.
+func retagCmds(sourceImage, destinationImage string) []string {
+	script := strings.Join([]string{
+		fmt.Sprintf("docker pull %s", sourceImage),
+		fmt.Sprintf("docker tag %s %s", sourceImage, destinationImage),
+		servicecommon.PushImageCmd(destinationImage),
+	}, " && ")
+	return []string{"/bin/sh", "-c", script}
+}
+
+type buildPodOpts struct {
+	app              provision.App
+	sourceImage      string
+	destinationImage string
+	cmds             []string
+}
+
+func sidecarContainer(cmds []string) api.Container {
+	return api.Container{
+		Name:    commitContainerName,
+		Image:   servicecommon.DeployerImage("kubernetes"),
+		Command: cmds,
+		VolumeMounts: []api.VolumeMount{
+			{Name: "dockersock", MountPath: servicecommon.DockerSockPath},
+		},
+	}
+}
+
+func buildPodSpec(opts buildPodOpts) *api.Pod {
+	podLabels := appLabels(opts.app)
+	podLabels[labelIsBuild] = strconv.FormatBool(true)
+	podLabels[labelAppPlatform] = opts.app.GetPlatform()
+	var containers []api.Container
+	if opts.cmds == nil {
+		containers = []api.Container{
+			sidecarContainer(retagCmds(opts.sourceImage, opts.destinationImage)),
+		}
+	} else {
+		containers = []api.Container{
+			{
+				Name:    buildContainerName,
+				Image:   opts.sourceImage,
+				Command: opts.cmds,
+				Env:     appEnvs(opts.app, false),
+			},
+			sidecarContainer(commitCmds(opts.destinationImage)),
+		}
+	}
+	return &api.Pod{
+		ObjectMeta: api.ObjectMeta{
+			Name:      buildPodNameForApp(opts.app),
+			Namespace: tsuruNamespace(),
+			Labels:    podLabels,
+			Annotations: map[string]string{
+				annotationBuildImage: opts.destinationImage,
+			},
+		},
+		Spec: api.PodSpec{
+			RestartPolicy: api.RestartPolicyNever,
+			Volumes: []api.Volume{
+				{
+					Name: "dockersock",
+					VolumeSource: api.VolumeSource{
+						HostPath: &api.HostPathVolumeSource{Path: servicecommon.DockerSockPath},
+					},
+				},
+			},
+			Containers: containers,
+		},
+	}
+}
+
+func runBuildPod(cli client.Interface, opts buildPodOpts, w io.Writer) error {
+	pods := cli.Pods(tsuruNamespace())
+	pod := buildPodSpec(opts)
+	err := pods.Delete(pod.Name, nil)
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		return errors.WithStack(err)
+	}
+	fmt.Fprintf(w, "---- Running build pod %q for image %q ----\n", pod.Name, opts.destinationImage)
+	_, err = pods.Create(pod)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() {
+		if errDel := pods.Delete(pod.Name, nil); errDel != nil && !k8sErrors.IsNotFound(errDel) {
+			fmt.Fprintf(w, "ignored error removing build pod %q: %s\n", pod.Name, errDel)
+		}
+	}()
+	return waitForPod(cli, pod.Name)
+}
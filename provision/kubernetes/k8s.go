@@ -0,0 +1,426 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kubernetes
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app/image"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/dockercommon"
+	"github.com/tsuru/tsuru/provision/servicecommon"
+	"gopkg.in/mgo.v2"
+	"k8s.io/kubernetes/pkg/api"
+	k8sErrors "k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/api/resource"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/client/restclient"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/util/intstr"
+)
+
+type notFoundError struct{ error }
+
+func (e notFoundError) NotFound() bool {
+	return true
+}
+
+var errNoKubernetesNode = notFoundError{errors.New("no kubernetes nodes available")}
+
+const (
+	labelIsTsuru     = "tsuru.io/is-tsuru"
+	labelIsBuild     = "tsuru.io/is-build"
+	labelAppName     = "tsuru.io/app-name"
+	labelAppProcess  = "tsuru.io/app-process"
+	labelAppPlatform = "tsuru.io/app-platform"
+	labelAppPool     = "tsuru.io/app-pool"
+
+	annotationBuildImage      = "tsuru.io/build-image"
+	annotationProcessReplicas = "tsuru.io/app-process-replicas"
+	annotationRestarts        = "tsuru.io/restarts"
+
+	defaultNamespace = "default"
+)
+
+var (
+	podWaitTimeout  = 10 * time.Minute
+	podWaitInterval = time.Second
+)
+
+// clientForConfig is the function used to build a kubernetes client from a
+// rest config. It's a variable so that it can be replaced by a fake
+// implementation in tests.
+var clientForConfig = func(conf *restclient.Config) (client.Interface, error) {
+	return client.New(conf)
+}
+
+func restConfigForAddr(addr string) (*restclient.Config, error) {
+	token, err := config.GetString("kubernetes:token")
+	if err != nil {
+		return nil, err
+	}
+	return &restclient.Config{
+		Host:        addr,
+		Insecure:    true,
+		BearerToken: token,
+	}, nil
+}
+
+func getClusterClient() (client.Interface, error) {
+	coll, err := nodeAddrCollection()
+	if err != nil {
+		return nil, err
+	}
+	defer coll.Close()
+	var addrs NodeAddrs
+	err = coll.FindId(uniqueDocumentID).One(&addrs)
+	if err != nil && err != mgo.ErrNotFound {
+		return nil, errors.WithStack(err)
+	}
+	if len(addrs.Addresses) == 0 {
+		return nil, errors.Wrap(errNoKubernetesNode, "")
+	}
+	restConfig, err := restConfigForAddr(addrs.Addresses[rand.Intn(len(addrs.Addresses))])
+	if err != nil {
+		return nil, err
+	}
+	cli, err := clientForConfig(restConfig)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return cli, nil
+}
+
+func tsuruNamespace() string {
+	ns, _ := config.GetString("kubernetes:namespace")
+	if ns == "" {
+		return defaultNamespace
+	}
+	return ns
+}
+
+func validKubeName(name string) string {
+	return strings.ToLower(strings.Replace(name, "_", "-", -1))
+}
+
+func deploymentNameForApp(a provision.App, process string) string {
+	return validKubeName(fmt.Sprintf("%s-%s", a.GetName(), process))
+}
+
+func serviceNameForApp(a provision.App, process string) string {
+	return deploymentNameForApp(a, process)
+}
+
+func buildPodNameForApp(a provision.App) string {
+	return validKubeName(fmt.Sprintf("%s-build", a.GetName()))
+}
+
+func appLabels(a provision.App) labels.Set {
+	return labels.Set{
+		labelIsTsuru: strconv.FormatBool(true),
+		labelIsBuild: strconv.FormatBool(false),
+		labelAppName: a.GetName(),
+	}
+}
+
+func processLabels(a provision.App, process string) labels.Set {
+	set := appLabels(a)
+	set[labelAppProcess] = process
+	return set
+}
+
+func appSelector(a provision.App) api.ListOptions {
+	return api.ListOptions{LabelSelector: labels.SelectorFromSet(appLabels(a))}
+}
+
+func appEnvs(a provision.App, isWeb bool) []api.EnvVar {
+	var envs []api.EnvVar
+	for _, envData := range a.Envs() {
+		envs = append(envs, api.EnvVar{Name: envData.Name, Value: envData.Value})
+	}
+	host, _ := config.GetString("host")
+	envs = append(envs, api.EnvVar{Name: "TSURU_HOST", Value: host})
+	if isWeb {
+		port := dockercommon.WebProcessDefaultPort()
+		envs = append(envs, []api.EnvVar{
+			{Name: "port", Value: port},
+			{Name: "PORT", Value: port},
+		}...)
+	}
+	return envs
+}
+
+func resourcesForApp(a provision.App) api.ResourceRequirements {
+	limits := api.ResourceList{}
+	if mem := a.GetMemory(); mem > 0 {
+		limits[api.ResourceMemory] = *resource.NewQuantity(mem, resource.BinarySI)
+	}
+	return api.ResourceRequirements{Limits: limits}
+}
+
+func processCmds(a provision.App, process, imgID string) ([]string, error) {
+	processCmd, _, err := dockercommon.ProcessCmdForImage(process, imgID)
+	if err != nil {
+		if _, isInvalid := err.(provision.InvalidProcessError); isInvalid {
+			// Images deployed with ImageDeploy have no registered commands,
+			// the image entrypoint and cmd are used instead.
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(processCmd) == 0 {
+		return nil, nil
+	}
+	cmds, _, err := dockercommon.LeanContainerCmds(process, imgID, a)
+	return cmds, err
+}
+
+func defaultProcessName(imgID string) (string, error) {
+	data, err := image.GetImageCustomData(imgID)
+	if err != nil {
+		return "", err
+	}
+	if len(data.Processes) > 1 {
+		return "", provision.InvalidProcessError{Msg: "no process name specified and more than one declared in Procfile"}
+	}
+	for name := range data.Processes {
+		return name, nil
+	}
+	return "", nil
+}
+
+func deploymentReplicas(dep *extensions.Deployment) int {
+	if dep == nil {
+		return 0
+	}
+	replicas, err := strconv.Atoi(dep.Annotations[annotationProcessReplicas])
+	if err != nil {
+		return int(dep.Spec.Replicas)
+	}
+	return replicas
+}
+
+func deploymentSpecForApp(a provision.App, process, imgID string, isWeb bool, base *extensions.Deployment, pState servicecommon.ProcessState) (*extensions.Deployment, error) {
+	replicas := deploymentReplicas(base)
+	restartCount := 0
+	if base != nil {
+		restartCount, _ = strconv.Atoi(base.Spec.Template.Annotations[annotationRestarts])
+	}
+	if pState.Increment != 0 {
+		replicas += pState.Increment
+		if replicas < 0 {
+			return nil, errors.New("cannot have less than 0 units")
+		}
+	} else if replicas == 0 && pState.Start {
+		replicas = 1
+	}
+	if pState.Restart {
+		restartCount++
+	}
+	realReplicas := replicas
+	if pState.Stop {
+		realReplicas = 0
+	}
+	cmds, err := processCmds(a, process, imgID)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	podLabels := processLabels(a, process)
+	podLabels[labelAppPlatform] = a.GetPlatform()
+	podLabels[labelAppPool] = a.GetPool()
+	container := api.Container{
+		Name:      deploymentNameForApp(a, process),
+		Image:     imgID,
+		Command:   cmds,
+		Env:       appEnvs(a, isWeb),
+		Resources: resourcesForApp(a),
+	}
+	if isWeb {
+		port, _ := strconv.Atoi(dockercommon.WebProcessDefaultPort())
+		container.Ports = []api.ContainerPort{
+			{ContainerPort: int32(port), Protocol: api.ProtocolTCP},
+		}
+	}
+	dep := &extensions.Deployment{
+		ObjectMeta: api.ObjectMeta{
+			Name:      deploymentNameForApp(a, process),
+			Namespace: tsuruNamespace(),
+			Labels:    podLabels,
+			Annotations: map[string]string{
+				annotationProcessReplicas: strconv.Itoa(replicas),
+			},
+		},
+		Spec: extensions.DeploymentSpec{
+			Replicas: int32(realReplicas),
+			Selector: &unversioned.LabelSelector{
+				MatchLabels: processLabels(a, process),
+			},
+			Template: api.PodTemplateSpec{
+				ObjectMeta: api.ObjectMeta{
+					Labels: podLabels,
+					Annotations: map[string]string{
+						annotationRestarts: strconv.Itoa(restartCount),
+					},
+				},
+				Spec: api.PodSpec{
+					Containers:    []api.Container{container},
+					RestartPolicy: api.RestartPolicyAlways,
+				},
+			},
+		},
+	}
+	return dep, nil
+}
+
+func serviceSpecForApp(a provision.App, process string) *api.Service {
+	port, _ := strconv.Atoi(dockercommon.WebProcessDefaultPort())
+	return &api.Service{
+		ObjectMeta: api.ObjectMeta{
+			Name:      serviceNameForApp(a, process),
+			Namespace: tsuruNamespace(),
+			Labels:    processLabels(a, process),
+		},
+		Spec: api.ServiceSpec{
+			Selector: processLabels(a, process),
+			Ports: []api.ServicePort{
+				{
+					Protocol:   api.ProtocolTCP,
+					Port:       int32(port),
+					TargetPort: intstr.FromInt(port),
+				},
+			},
+			Type: api.ServiceTypeNodePort,
+		},
+	}
+}
+
+type serviceManager struct {
+	client client.Interface
+}
+
+func (m *serviceManager) DeployService(a provision.App, process, image string, isWeb bool, state servicecommon.ProcessState) error {
+	return deployProcess(m.client, a, process, image, isWeb, state)
+}
+
+func (m *serviceManager) RemoveService(a provision.App, process string) error {
+	return removeProcess(m.client, a, process)
+}
+
+func deployProcesses(cli client.Interface, a provision.App, newImg string, updateSpec servicecommon.ProcessSpec) error {
+	return servicecommon.RunServicePipeline(&serviceManager{client: cli}, a, newImg, updateSpec)
+}
+
+func deployProcess(cli client.Interface, a provision.App, process, imgID string, isWeb bool, pState servicecommon.ProcessState) error {
+	ns := tsuruNamespace()
+	depName := deploymentNameForApp(a, process)
+	current, err := cli.Extensions().Deployments(ns).Get(depName)
+	if err != nil {
+		if !k8sErrors.IsNotFound(err) {
+			return errors.WithStack(err)
+		}
+		current = nil
+	}
+	dep, err := deploymentSpecForApp(a, process, imgID, isWeb, current, pState)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		_, err = cli.Extensions().Deployments(ns).Create(dep)
+	} else {
+		dep.ResourceVersion = current.ResourceVersion
+		_, err = cli.Extensions().Deployments(ns).Update(dep)
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !isWeb {
+		return nil
+	}
+	srv := serviceSpecForApp(a, process)
+	currentSrv, err := cli.Services(ns).Get(srv.Name)
+	if err == nil {
+		currentSrv.Spec.Selector = srv.Spec.Selector
+		_, err = cli.Services(ns).Update(currentSrv)
+		return errors.WithStack(err)
+	}
+	if !k8sErrors.IsNotFound(err) {
+		return errors.WithStack(err)
+	}
+	_, err = cli.Services(ns).Create(srv)
+	return errors.WithStack(err)
+}
+
+func removeProcess(cli client.Interface, a provision.App, process string) error {
+	ns := tsuruNamespace()
+	name := deploymentNameForApp(a, process)
+	orphan := false
+	err := cli.Extensions().Deployments(ns).Delete(name, &api.DeleteOptions{OrphanDependents: &orphan})
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		return errors.WithStack(err)
+	}
+	err = cli.Services(ns).Delete(serviceNameForApp(a, process))
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func allAppProcesses(appName string) ([]string, error) {
+	var processes []string
+	imgID, err := image.AppCurrentImageName(appName)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	data, err := image.GetImageCustomData(imgID)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for procName := range data.Processes {
+		processes = append(processes, procName)
+	}
+	return processes, nil
+}
+
+func waitForPod(cli client.Interface, podName string) error {
+	timeout := time.After(podWaitTimeout)
+	for {
+		pod, err := cli.Pods(tsuruNamespace()).Get(podName)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		switch pod.Status.Phase {
+		case api.PodSucceeded:
+			return nil
+		case api.PodFailed:
+			return errors.Errorf("invalid pod phase %q for pod %q: %s", pod.Status.Phase, podName, podStatusMsg(pod))
+		}
+		select {
+		case <-timeout:
+			return errors.Errorf("timeout waiting for pod %q to complete", podName)
+		case <-time.After(podWaitInterval):
+		}
+	}
+}
+
+func podStatusMsg(pod *api.Pod) string {
+	var msgs []string
+	for _, contStatus := range pod.Status.ContainerStatuses {
+		if term := contStatus.State.Terminated; term != nil {
+			msgs = append(msgs, fmt.Sprintf("container %q exited with code %d: %s", contStatus.Name, term.ExitCode, term.Message))
+		}
+	}
+	if len(msgs) == 0 {
+		return pod.Status.Message
+	}
+	return strings.Join(msgs, ", ")
+}
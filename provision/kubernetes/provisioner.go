@@ -11,13 +11,15 @@ import (
 	"strings"
 
 	"github.com/pkg/errors"
-	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app/image"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
 	"github.com/tsuru/tsuru/event"
 	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/dockercommon"
+	"github.com/tsuru/tsuru/provision/servicecommon"
 	"gopkg.in/mgo.v2/bson"
 	"k8s.io/kubernetes/pkg/api"
-	"k8s.io/kubernetes/pkg/apis/extensions"
-	"k8s.io/kubernetes/pkg/client/restclient"
+	k8sErrors "k8s.io/kubernetes/pkg/api/errors"
 	client "k8s.io/kubernetes/pkg/client/unversioned"
 )
 
@@ -43,40 +45,268 @@ func (p *kubernetesProvisioner) Provision(provision.App) error {
 	return nil
 }
 
-func (p *kubernetesProvisioner) Destroy(provision.App) error {
-	return errNotImplemented
+func (p *kubernetesProvisioner) Destroy(a provision.App) error {
+	cli, err := getClusterClient()
+	if err != nil {
+		return err
+	}
+	ns := tsuruNamespace()
+	multiErrors := tsuruErrors.NewMultiError()
+	deps, err := cli.Extensions().Deployments(ns).List(appSelector(a))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	orphan := false
+	for _, dep := range deps.Items {
+		err = cli.Extensions().Deployments(ns).Delete(dep.Name, &api.DeleteOptions{OrphanDependents: &orphan})
+		if err != nil && !k8sErrors.IsNotFound(err) {
+			multiErrors.Add(errors.WithStack(err))
+		}
+	}
+	srvs, err := cli.Services(ns).List(appSelector(a))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, srv := range srvs.Items {
+		err = cli.Services(ns).Delete(srv.Name)
+		if err != nil && !k8sErrors.IsNotFound(err) {
+			multiErrors.Add(errors.WithStack(err))
+		}
+	}
+	if multiErrors.Len() > 0 {
+		return multiErrors
+	}
+	return nil
+}
+
+func changeUnits(a provision.App, units int, processName string, w io.Writer) error {
+	if a.GetDeploys() == 0 {
+		return errors.New("units can only be modified after the first deploy")
+	}
+	if units == 0 {
+		return errors.New("cannot change 0 units")
+	}
+	cli, err := getClusterClient()
+	if err != nil {
+		return err
+	}
+	imgID, err := image.AppCurrentImageName(a.GetName())
+	if err != nil {
+		return err
+	}
+	if processName == "" {
+		processName, err = defaultProcessName(imgID)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return deployProcesses(cli, a, imgID, servicecommon.ProcessSpec{processName: servicecommon.ProcessState{Increment: units}})
+}
+
+func (p *kubernetesProvisioner) AddUnits(a provision.App, units uint, processName string, w io.Writer) error {
+	return changeUnits(a, int(units), processName, w)
+}
+
+func (p *kubernetesProvisioner) RemoveUnits(a provision.App, units uint, processName string, w io.Writer) error {
+	return changeUnits(a, -int(units), processName, w)
+}
+
+func changeAppState(a provision.App, process string, state servicecommon.ProcessState) error {
+	cli, err := getClusterClient()
+	if err != nil {
+		return err
+	}
+	var processes []string
+	if process == "" {
+		processes, err = allAppProcesses(a.GetName())
+		if err != nil {
+			return err
+		}
+	} else {
+		processes = []string{process}
+	}
+	imgID, err := image.AppCurrentImageName(a.GetName())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	spec := servicecommon.ProcessSpec{}
+	for _, procName := range processes {
+		spec[procName] = state
+	}
+	return deployProcesses(cli, a, imgID, spec)
+}
+
+func (p *kubernetesProvisioner) Restart(a provision.App, process string, w io.Writer) error {
+	return changeAppState(a, process, servicecommon.ProcessState{Start: true, Restart: true})
+}
+
+func (p *kubernetesProvisioner) Start(a provision.App, process string) error {
+	return changeAppState(a, process, servicecommon.ProcessState{Start: true})
 }
 
-func (p *kubernetesProvisioner) AddUnits(provision.App, uint, string, io.Writer) error {
-	return errNotImplemented
+func (p *kubernetesProvisioner) Stop(a provision.App, process string) error {
+	return changeAppState(a, process, servicecommon.ProcessState{Stop: true})
 }
 
-func (p *kubernetesProvisioner) RemoveUnits(provision.App, uint, string, io.Writer) error {
-	return errNotImplemented
+var stateMap = map[api.PodPhase]provision.Status{
+	api.PodPending:   provision.StatusStarting,
+	api.PodRunning:   provision.StatusStarted,
+	api.PodSucceeded: provision.StatusStopped,
+	api.PodFailed:    provision.StatusError,
+	api.PodUnknown:   provision.StatusError,
 }
 
-func (p *kubernetesProvisioner) Restart(provision.App, string, io.Writer) error {
-	return errNotImplemented
+func podToUnit(pod *api.Pod, a provision.App, nodePort int32) provision.Unit {
+	address := &url.URL{}
+	if nodePort != 0 {
+		address = &url.URL{
+			Scheme: "http",
+			Host:   fmt.Sprintf("%s:%d", pod.Status.HostIP, nodePort),
+		}
+	}
+	return provision.Unit{
+		ID:          pod.Name,
+		Name:        pod.Name,
+		AppName:     a.GetName(),
+		ProcessName: pod.Labels[labelAppProcess],
+		Type:        a.GetPlatform(),
+		Ip:          pod.Status.HostIP,
+		Status:      stateMap[pod.Status.Phase],
+		Address:     address,
+	}
 }
 
-func (p *kubernetesProvisioner) Start(provision.App, string) error {
-	return errNotImplemented
+func podsToUnits(cli client.Interface, pods []api.Pod, a provision.App) ([]provision.Unit, error) {
+	portMap := map[string]int32{}
+	units := []provision.Unit{}
+	for i := range pods {
+		process := pods[i].Labels[labelAppProcess]
+		if _, ok := portMap[process]; !ok {
+			srv, err := cli.Services(tsuruNamespace()).Get(serviceNameForApp(a, process))
+			if err != nil && !k8sErrors.IsNotFound(err) {
+				return nil, errors.WithStack(err)
+			}
+			if err == nil && len(srv.Spec.Ports) > 0 {
+				portMap[process] = srv.Spec.Ports[0].NodePort
+			} else {
+				portMap[process] = 0
+			}
+		}
+		units = append(units, podToUnit(&pods[i], a, portMap[process]))
+	}
+	return units, nil
 }
 
-func (p *kubernetesProvisioner) Stop(provision.App, string) error {
-	return errNotImplemented
+func (p *kubernetesProvisioner) Units(a provision.App) ([]provision.Unit, error) {
+	cli, err := getClusterClient()
+	if err != nil {
+		if errors.Cause(err) == errNoKubernetesNode {
+			return []provision.Unit{}, nil
+		}
+		return nil, err
+	}
+	pods, err := cli.Pods(tsuruNamespace()).List(appSelector(a))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return podsToUnits(cli, pods.Items, a)
 }
 
-func (p *kubernetesProvisioner) Units(app provision.App) ([]provision.Unit, error) {
-	return nil, nil
+func nodeIP(node *api.Node) string {
+	var fallback string
+	for _, addr := range node.Status.Addresses {
+		switch addr.Type {
+		case api.NodeInternalIP:
+			return addr.Address
+		case api.NodeExternalIP, api.NodeLegacyHostIP:
+			fallback = addr.Address
+		}
+	}
+	return fallback
 }
 
-func (p *kubernetesProvisioner) RoutableAddresses(app provision.App) ([]url.URL, error) {
-	return nil, errNotImplemented
+func (p *kubernetesProvisioner) RoutableAddresses(a provision.App) ([]url.URL, error) {
+	cli, err := getClusterClient()
+	if err != nil {
+		return nil, err
+	}
+	imgID, err := image.AppCurrentImageName(a.GetName())
+	if err != nil {
+		if err != image.ErrNoImagesAvailable {
+			return nil, err
+		}
+		return nil, nil
+	}
+	webProcessName, err := image.GetImageWebProcessName(imgID)
+	if err != nil {
+		return nil, err
+	}
+	if webProcessName == "" {
+		return nil, nil
+	}
+	srv, err := cli.Services(tsuruNamespace()).Get(serviceNameForApp(a, webProcessName))
+	if err != nil {
+		if k8sErrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+	var pubPort int32
+	if len(srv.Spec.Ports) > 0 {
+		pubPort = srv.Spec.Ports[0].NodePort
+	}
+	if pubPort == 0 {
+		return nil, nil
+	}
+	nodes, err := cli.Nodes().List(api.ListOptions{})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var addrs []url.URL
+	for i := range nodes.Items {
+		ip := nodeIP(&nodes.Items[i])
+		if ip == "" {
+			continue
+		}
+		addrs = append(addrs, url.URL{
+			Scheme: "http",
+			Host:   fmt.Sprintf("%s:%d", ip, pubPort),
+		})
+	}
+	return addrs, nil
 }
 
 func (p *kubernetesProvisioner) RegisterUnit(a provision.App, unitId string, customData map[string]interface{}) error {
-	return errNotImplemented
+	cli, err := getClusterClient()
+	if err != nil {
+		return err
+	}
+	pod, err := cli.Pods(tsuruNamespace()).Get(unitId)
+	if err != nil {
+		if k8sErrors.IsNotFound(err) {
+			return &provision.UnitNotFoundError{ID: unitId}
+		}
+		return errors.WithStack(err)
+	}
+	units, err := podsToUnits(cli, []api.Pod{*pod}, a)
+	if err != nil {
+		return err
+	}
+	err = a.BindUnit(&units[0])
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if customData == nil {
+		return nil
+	}
+	if pod.Labels[labelIsBuild] != "true" {
+		return nil
+	}
+	buildingImage := pod.Annotations[annotationBuildImage]
+	if buildingImage == "" {
+		return errors.Errorf("invalid build image annotation for build pod: %#v", pod)
+	}
+	return image.SaveImageCustomData(buildingImage, customData)
 }
 
 func (p *kubernetesProvisioner) ListNodes(addressFilter []string) ([]provision.Node, error) {
@@ -120,15 +350,11 @@ func (p *kubernetesProvisioner) AddNode(opts provision.AddNodeOptions) error {
 		return err
 	}
 	defer coll.Close()
-	token, err := config.GetString("kubernetes:token")
+	restConfig, err := restConfigForAddr(opts.Address)
 	if err != nil {
 		return err
 	}
-	_, err = client.New(&restclient.Config{
-		Host:        opts.Address,
-		Insecure:    true,
-		BearerToken: token,
-	})
+	_, err = clientForConfig(restConfig)
 	if err != nil {
 		return err
 	}
@@ -158,51 +384,66 @@ func (p *kubernetesProvisioner) UpdateNode(provision.UpdateNodeOptions) error {
 	return nil
 }
 
-func (p *kubernetesProvisioner) ArchiveDeploy(app provision.App, archiveURL string, evt *event.Event) (imgID string, err error) {
-	return "", errNotImplemented
-}
-
-func (p *kubernetesProvisioner) ImageDeploy(a provision.App, imgID string, evt *event.Event) (string, error) {
-	hosts, err := p.ListNodes(nil)
+func (p *kubernetesProvisioner) ArchiveDeploy(a provision.App, archiveURL string, evt *event.Event) (string, error) {
+	baseImage := image.GetBuildImage(a)
+	buildingImage, err := image.AppNewImageName(a.GetName())
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	cli, err := getClusterClient()
 	if err != nil {
 		return "", err
 	}
-	token, err := config.GetString("kubernetes:token")
+	err = runBuildPod(cli, buildPodOpts{
+		app:              a,
+		sourceImage:      baseImage,
+		destinationImage: buildingImage,
+		cmds:             dockercommon.ArchiveDeployCmds(a, archiveURL),
+	}, evt)
 	if err != nil {
 		return "", err
 	}
-	client, err := client.New(&restclient.Config{
-		Host:        hosts[0].Address(),
-		Insecure:    true,
-		BearerToken: token,
-	})
+	err = deployProcesses(cli, a, buildingImage, nil)
+	if err != nil {
+		return "", err
+	}
+	return buildingImage, nil
+}
+
+func (p *kubernetesProvisioner) ImageDeploy(a provision.App, imgID string, evt *event.Event) (string, error) {
+	cli, err := getClusterClient()
 	if err != nil {
 		return "", err
 	}
 	if !strings.Contains(imgID, ":") {
 		imgID = fmt.Sprintf("%s:latest", imgID)
 	}
-	deployment := extensions.Deployment{
-		ObjectMeta: api.ObjectMeta{
-			Name: a.GetName(),
-		},
-		Spec: extensions.DeploymentSpec{
-			Replicas: 1,
-			Template: api.PodTemplateSpec{
-				ObjectMeta: api.ObjectMeta{
-					Name: a.GetName(),
-					Labels: map[string]string{
-						"name": a.GetName(),
-					},
-				},
-				Spec: api.PodSpec{
-					Containers: []api.Container{
-						{Name: a.GetName(), Image: imgID},
-					},
-				},
-			},
-		},
-	}
-	_, err = client.Deployments("default").Create(&deployment)
-	return "", err
+	newImage, err := image.AppNewImageName(a.GetName())
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	fmt.Fprintln(evt, "---- Pulling image to tsuru ----")
+	err = runBuildPod(cli, buildPodOpts{
+		app:              a,
+		sourceImage:      imgID,
+		destinationImage: newImage,
+	}, evt)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintln(evt, "  ---> Using image entrypoint and cmd as the web process")
+	imageData := image.ImageMetadata{
+		Name:      newImage,
+		Processes: map[string][]string{"web": nil},
+	}
+	err = imageData.Save()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	a.SetUpdatePlatform(true)
+	err = deployProcesses(cli, a, newImage, nil)
+	if err != nil {
+		return "", err
+	}
+	return newImage, nil
 }
@@ -5,11 +5,16 @@
 package kubernetes
 
 import (
+	"net/url"
+	"sort"
+
 	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/app/image"
 	"github.com/tsuru/tsuru/event"
 	"github.com/tsuru/tsuru/permission"
 	"github.com/tsuru/tsuru/provision"
 	"gopkg.in/check.v1"
+	"k8s.io/kubernetes/pkg/api"
 )
 
 func (s *S) TestListNodes(c *check.C) {
@@ -77,6 +82,32 @@ func (s *S) TestRemoveNode(c *check.C) {
 	c.Assert(nodes, check.HasLen, 0)
 }
 
+func (s *S) prepareApp(c *check.C, processes map[string]interface{}) *app.App {
+	err := s.p.AddNode(provision.AddNodeOptions{Address: "https://192.168.99.100:8443"})
+	c.Assert(err, check.IsNil)
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name}
+	err = app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	imgName := "tsuru/app-myapp:v1"
+	err = image.SaveImageCustomData(imgName, map[string]interface{}{"processes": processes})
+	c.Assert(err, check.IsNil)
+	err = deployProcesses(s.client, a, imgName, nil)
+	c.Assert(err, check.IsNil)
+	a.Deploys = 1
+	return a
+}
+
+func (s *S) newDeployEvent(c *check.C, a *app.App) *event.Event {
+	evt, err := event.New(&event.Opts{
+		Target:  event.Target{Type: event.TargetTypeApp, Value: a.GetName()},
+		Kind:    permission.PermAppDeploy,
+		Owner:   s.token,
+		Allowed: event.Allowed(permission.PermAppDeploy),
+	})
+	c.Assert(err, check.IsNil)
+	return evt
+}
+
 func (s *S) TestImageDeploy(c *check.C) {
 	url := "https://192.168.99.100:8443"
 	opts := provision.AddNodeOptions{
@@ -88,24 +119,205 @@ func (s *S) TestImageDeploy(c *check.C) {
 	a := &app.App{Name: "myapp", TeamOwner: s.team.Name}
 	err = app.CreateApp(a, s.user)
 	c.Assert(err, check.IsNil)
-	evt, err := event.New(&event.Opts{
-		Target:  event.Target{Type: event.TargetTypeApp, Value: a.GetName()},
-		Kind:    permission.PermAppDeploy,
-		Owner:   s.token,
-		Allowed: event.Allowed(permission.PermAppDeploy),
+	evt := s.newDeployEvent(c, a)
+	img, err := s.p.ImageDeploy(a, "imageName", evt)
+	c.Assert(err, check.IsNil)
+	c.Assert(img, check.Equals, "tsuru/app-myapp:v1")
+	dep, err := s.client.Extensions().Deployments(defaultNamespace).Get("myapp-web")
+	c.Assert(err, check.IsNil)
+	c.Assert(dep.Spec.Replicas, check.Equals, int32(1))
+	c.Assert(dep.Spec.Template.Spec.Containers[0].Image, check.Equals, "tsuru/app-myapp:v1")
+	c.Assert(dep.Spec.Template.Spec.Containers[0].Command, check.IsNil)
+	srv, err := s.client.Services(defaultNamespace).Get("myapp-web")
+	c.Assert(err, check.IsNil)
+	c.Assert(srv.Spec.Type, check.Equals, api.ServiceTypeNodePort)
+	c.Assert(srv.Spec.Ports[0].NodePort, check.Equals, int32(30000))
+	c.Assert(s.client.pods, check.HasLen, 0)
+	imgs, err := image.ListAppImages(a.GetName())
+	c.Assert(err, check.IsNil)
+	c.Assert(imgs, check.DeepEquals, []string{"tsuru/app-myapp:v1"})
+}
+
+func (s *S) TestImageDeployBuildPodFailure(c *check.C) {
+	err := s.p.AddNode(provision.AddNodeOptions{Address: "https://192.168.99.100:8443"})
+	c.Assert(err, check.IsNil)
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name}
+	err = app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	s.client.podPhase = api.PodFailed
+	_, err = s.p.ImageDeploy(a, "imageName", s.newDeployEvent(c, a))
+	c.Assert(err, check.ErrorMatches, `.*invalid pod phase "Failed" for pod "myapp-build".*`)
+	c.Assert(s.client.deployments, check.HasLen, 0)
+}
+
+func (s *S) TestArchiveDeploy(c *check.C) {
+	err := s.p.AddNode(provision.AddNodeOptions{Address: "https://192.168.99.100:8443"})
+	c.Assert(err, check.IsNil)
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name, Platform: "python"}
+	err = app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	err = image.SaveImageCustomData("tsuru/app-myapp:v1", map[string]interface{}{
+		"processes": map[string]interface{}{"web": "python web.py", "worker": "python worker.py"},
 	})
 	c.Assert(err, check.IsNil)
-	s.p.ImageDeploy(a, "imageName", evt)
+	img, err := s.p.ArchiveDeploy(a, "http://archive.tar.gz", s.newDeployEvent(c, a))
 	c.Assert(err, check.IsNil)
+	c.Assert(img, check.Equals, "tsuru/app-myapp:v1")
+	c.Assert(s.client.deployments, check.HasLen, 2)
+	c.Assert(s.client.deployments["myapp-worker"].Spec.Template.Spec.Containers[0].Command, check.DeepEquals, []string{
+		"/bin/sh", "-lc", "[ -d /home/application/current ] && cd /home/application/current; exec python worker.py",
+	})
+	c.Assert(s.client.services, check.HasLen, 1)
+	_, ok := s.client.services["myapp-web"]
+	c.Assert(ok, check.Equals, true)
 }
 
 func (s *S) TestUnits(c *check.C) {
+	a := s.prepareApp(c, map[string]interface{}{"web": "python web.py", "worker": "python worker.py"})
+	s.client.runDeployments("192.168.99.1")
+	units, err := s.p.Units(a)
+	c.Assert(err, check.IsNil)
+	sort.Sort(unitSlice(units))
+	c.Assert(units, check.DeepEquals, []provision.Unit{
+		{
+			ID:          "myapp-web-0",
+			Name:        "myapp-web-0",
+			AppName:     "myapp",
+			ProcessName: "web",
+			Ip:          "192.168.99.1",
+			Status:      provision.StatusStarted,
+			Address:     &url.URL{Scheme: "http", Host: "192.168.99.1:30000"},
+		},
+		{
+			ID:          "myapp-worker-0",
+			Name:        "myapp-worker-0",
+			AppName:     "myapp",
+			ProcessName: "worker",
+			Ip:          "192.168.99.1",
+			Status:      provision.StatusStarted,
+			Address:     &url.URL{},
+		},
+	})
+}
+
+func (s *S) TestUnitsNoNodes(c *check.C) {
 	a := &app.App{Name: "myapp", TeamOwner: s.team.Name}
 	err := app.CreateApp(a, s.user)
 	c.Assert(err, check.IsNil)
 	units, err := s.p.Units(a)
 	c.Assert(err, check.IsNil)
-	c.Assert(units, check.IsNil)
+	c.Assert(units, check.HasLen, 0)
+}
+
+func (s *S) TestAddUnits(c *check.C) {
+	a := s.prepareApp(c, map[string]interface{}{"web": "python web.py"})
+	err := s.p.AddUnits(a, 2, "", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(s.client.deployments["myapp-web"].Spec.Replicas, check.Equals, int32(3))
+	s.client.runDeployments("192.168.99.1")
+	units, err := s.p.Units(a)
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 3)
+}
+
+func (s *S) TestAddUnitsNoDeploys(c *check.C) {
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name}
+	err := app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	err = s.p.AddUnits(a, 1, "web", nil)
+	c.Assert(err, check.ErrorMatches, "units can only be modified after the first deploy")
+}
+
+func (s *S) TestRemoveUnits(c *check.C) {
+	a := s.prepareApp(c, map[string]interface{}{"web": "python web.py", "worker": "python worker.py"})
+	err := s.p.AddUnits(a, 2, "worker", nil)
+	c.Assert(err, check.IsNil)
+	err = s.p.RemoveUnits(a, 1, "worker", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(s.client.deployments["myapp-worker"].Spec.Replicas, check.Equals, int32(2))
+	c.Assert(s.client.deployments["myapp-web"].Spec.Replicas, check.Equals, int32(1))
+	err = s.p.RemoveUnits(a, 3, "worker", nil)
+	c.Assert(err, check.ErrorMatches, "cannot have less than 0 units")
+}
+
+func (s *S) TestStopStart(c *check.C) {
+	a := s.prepareApp(c, map[string]interface{}{"web": "python web.py"})
+	err := s.p.AddUnits(a, 1, "web", nil)
+	c.Assert(err, check.IsNil)
+	err = s.p.Stop(a, "")
+	c.Assert(err, check.IsNil)
+	dep := s.client.deployments["myapp-web"]
+	c.Assert(dep.Spec.Replicas, check.Equals, int32(0))
+	c.Assert(dep.Annotations[annotationProcessReplicas], check.Equals, "2")
+	err = s.p.Start(a, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(s.client.deployments["myapp-web"].Spec.Replicas, check.Equals, int32(2))
+}
+
+func (s *S) TestRestart(c *check.C) {
+	a := s.prepareApp(c, map[string]interface{}{"web": "python web.py"})
+	err := s.p.Restart(a, "web", nil)
+	c.Assert(err, check.IsNil)
+	dep := s.client.deployments["myapp-web"]
+	c.Assert(dep.Spec.Template.Annotations[annotationRestarts], check.Equals, "1")
+	c.Assert(dep.Spec.Replicas, check.Equals, int32(1))
+}
+
+func (s *S) TestDestroy(c *check.C) {
+	a := s.prepareApp(c, map[string]interface{}{"web": "python web.py", "worker": "python worker.py"})
+	err := s.p.Destroy(a)
+	c.Assert(err, check.IsNil)
+	c.Assert(s.client.deployments, check.HasLen, 0)
+	c.Assert(s.client.services, check.HasLen, 0)
+}
+
+func (s *S) TestRoutableAddresses(c *check.C) {
+	a := s.prepareApp(c, map[string]interface{}{"web": "python web.py"})
+	s.client.nodes = []api.Node{
+		{Status: api.NodeStatus{Addresses: []api.NodeAddress{
+			{Type: api.NodeExternalIP, Address: "200.0.0.1"},
+			{Type: api.NodeInternalIP, Address: "10.0.0.1"},
+		}}},
+		{Status: api.NodeStatus{Addresses: []api.NodeAddress{
+			{Type: api.NodeLegacyHostIP, Address: "10.0.0.2"},
+		}}},
+	}
+	addrs, err := s.p.RoutableAddresses(a)
+	c.Assert(err, check.IsNil)
+	c.Assert(addrs, check.DeepEquals, []url.URL{
+		{Scheme: "http", Host: "10.0.0.1:30000"},
+		{Scheme: "http", Host: "10.0.0.2:30000"},
+	})
+}
+
+func (s *S) TestRoutableAddressesNoWebProcess(c *check.C) {
+	a := s.prepareApp(c, map[string]interface{}{"worker1": "w1", "worker2": "w2"})
+	addrs, err := s.p.RoutableAddresses(a)
+	c.Assert(err, check.IsNil)
+	c.Assert(addrs, check.IsNil)
+}
+
+func (s *S) TestRegisterUnit(c *check.C) {
+	a := s.prepareApp(c, map[string]interface{}{"web": "python web.py"})
+	s.client.runDeployments("192.168.99.1")
+	err := s.p.RegisterUnit(a, "myapp-web-0", nil)
+	c.Assert(err, check.IsNil)
+	err = s.p.RegisterUnit(a, "myapp-web-9", nil)
+	c.Assert(err, check.FitsTypeOf, &provision.UnitNotFoundError{})
+}
+
+func (s *S) TestRegisterUnitBuildPod(c *check.C) {
+	a := s.prepareApp(c, map[string]interface{}{"web": "python web.py"})
+	pod := buildPodSpec(buildPodOpts{app: a, sourceImage: "base", destinationImage: "tsuru/app-myapp:v2", cmds: []string{"deploy"}})
+	_, err := s.client.Pods(defaultNamespace).Create(pod)
+	c.Assert(err, check.IsNil)
+	err = s.p.RegisterUnit(a, pod.Name, map[string]interface{}{
+		"processes": map[string]interface{}{"web": "python web2.py"},
+	})
+	c.Assert(err, check.IsNil)
+	data, err := image.GetImageCustomData("tsuru/app-myapp:v2")
+	c.Assert(err, check.IsNil)
+	c.Assert(data.Processes, check.DeepEquals, map[string][]string{"web": {"python web2.py"}})
 }
 
 func (s *S) TestGetNode(c *check.C) {
@@ -124,3 +336,17 @@ func (s *S) TestGetNode(c *check.C) {
 	c.Assert(err, check.Equals, provision.ErrNodeNotFound)
 	c.Assert(node, check.IsNil)
 }
+
+type unitSlice []provision.Unit
+
+func (s unitSlice) Len() int {
+	return len(s)
+}
+
+func (s unitSlice) Less(i, j int) bool {
+	return s[i].ID < s[j].ID
+}
+
+func (s unitSlice) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
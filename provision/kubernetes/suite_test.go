@@ -5,8 +5,11 @@
 package kubernetes
 
 import (
+	"fmt"
 	"math/rand"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/tsuru/config"
 	"github.com/tsuru/tsuru/app"
@@ -19,14 +22,21 @@ import (
 	"github.com/tsuru/tsuru/router/routertest"
 	"golang.org/x/crypto/bcrypt"
 	"gopkg.in/check.v1"
+	"k8s.io/kubernetes/pkg/api"
+	k8sErrors "k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/client/restclient"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/labels"
 )
 
 type S struct {
-	p     *kubernetesProvisioner
-	conn  *db.Storage
-	user  *auth.User
-	team  *auth.Team
-	token auth.Token
+	p      *kubernetesProvisioner
+	conn   *db.Storage
+	user   *auth.User
+	team   *auth.Team
+	token  auth.Token
+	client *fakeClientset
 }
 
 var _ = check.Suite(&S{})
@@ -70,6 +80,11 @@ func (s *S) SetUpTest(c *check.C) {
 	err = p.Save()
 	c.Assert(err, check.IsNil)
 	s.p = &kubernetesProvisioner{}
+	s.client = newFakeClientset()
+	clientForConfig = func(conf *restclient.Config) (client.Interface, error) {
+		return s.client, nil
+	}
+	podWaitInterval = time.Millisecond
 	s.user = &auth.User{Email: "whiskeyjack@genabackis.com", Password: "123456", Quota: quota.Unlimited}
 	nativeScheme := auth.ManagedScheme(native.NativeScheme{})
 	app.AuthScheme = nativeScheme
@@ -82,3 +97,265 @@ func (s *S) SetUpTest(c *check.C) {
 	s.token, err = nativeScheme.Login(map[string]string{"email": s.user.Email, "password": "123456"})
 	c.Assert(err, check.IsNil)
 }
+
+// fakeClientset is an in-memory implementation of the subset of the
+// kubernetes client used by the provisioner. Calling any other method panics.
+type fakeClientset struct {
+	client.Interface
+	sync.Mutex
+	deployments map[string]*extensions.Deployment
+	services    map[string]*api.Service
+	pods        map[string]*api.Pod
+	nodes       []api.Node
+	nextPort    int32
+	// podPhase is the phase set on newly created pods.
+	podPhase api.PodPhase
+}
+
+func newFakeClientset() *fakeClientset {
+	return &fakeClientset{
+		deployments: map[string]*extensions.Deployment{},
+		services:    map[string]*api.Service{},
+		pods:        map[string]*api.Pod{},
+		nextPort:    30000,
+		podPhase:    api.PodSucceeded,
+	}
+}
+
+func matches(opts api.ListOptions, l map[string]string) bool {
+	return opts.LabelSelector == nil || opts.LabelSelector.Matches(labels.Set(l))
+}
+
+// runDeployments creates pods for every deployment replica, simulating the
+// kubernetes deployment controller.
+func (f *fakeClientset) runDeployments(hostIP string) {
+	f.Lock()
+	defer f.Unlock()
+	for name, pod := range f.pods {
+		if pod.Labels[labelIsBuild] == "false" {
+			delete(f.pods, name)
+		}
+	}
+	for _, dep := range f.deployments {
+		for i := 0; i < int(dep.Spec.Replicas); i++ {
+			podName := fmt.Sprintf("%s-%d", dep.Name, i)
+			f.pods[podName] = &api.Pod{
+				ObjectMeta: api.ObjectMeta{
+					Name:      podName,
+					Namespace: dep.Namespace,
+					Labels:    dep.Spec.Template.Labels,
+				},
+				Spec: dep.Spec.Template.Spec,
+				Status: api.PodStatus{
+					Phase:  api.PodRunning,
+					HostIP: hostIP,
+				},
+			}
+		}
+	}
+}
+
+func (f *fakeClientset) Extensions() client.ExtensionsInterface {
+	return &fakeExtensions{fake: f}
+}
+
+func (f *fakeClientset) Services(namespace string) client.ServiceInterface {
+	return &fakeServices{fake: f}
+}
+
+func (f *fakeClientset) Pods(namespace string) client.PodInterface {
+	return &fakePods{fake: f}
+}
+
+func (f *fakeClientset) Nodes() client.NodeInterface {
+	return &fakeNodes{fake: f}
+}
+
+type fakeExtensions struct {
+	client.ExtensionsInterface
+	fake *fakeClientset
+}
+
+func (e *fakeExtensions) Deployments(namespace string) client.DeploymentInterface {
+	return &fakeDeployments{fake: e.fake}
+}
+
+type fakeDeployments struct {
+	client.DeploymentInterface
+	fake *fakeClientset
+}
+
+func (d *fakeDeployments) List(opts api.ListOptions) (*extensions.DeploymentList, error) {
+	d.fake.Lock()
+	defer d.fake.Unlock()
+	list := &extensions.DeploymentList{}
+	for _, dep := range d.fake.deployments {
+		if matches(opts, dep.Labels) {
+			list.Items = append(list.Items, *dep)
+		}
+	}
+	return list, nil
+}
+
+func (d *fakeDeployments) Get(name string) (*extensions.Deployment, error) {
+	d.fake.Lock()
+	defer d.fake.Unlock()
+	dep, ok := d.fake.deployments[name]
+	if !ok {
+		return nil, k8sErrors.NewNotFound(extensions.Resource("deployments"), name)
+	}
+	copy := *dep
+	return &copy, nil
+}
+
+func (d *fakeDeployments) Create(dep *extensions.Deployment) (*extensions.Deployment, error) {
+	d.fake.Lock()
+	defer d.fake.Unlock()
+	if _, ok := d.fake.deployments[dep.Name]; ok {
+		return nil, k8sErrors.NewAlreadyExists(extensions.Resource("deployments"), dep.Name)
+	}
+	d.fake.deployments[dep.Name] = dep
+	return dep, nil
+}
+
+func (d *fakeDeployments) Update(dep *extensions.Deployment) (*extensions.Deployment, error) {
+	d.fake.Lock()
+	defer d.fake.Unlock()
+	if _, ok := d.fake.deployments[dep.Name]; !ok {
+		return nil, k8sErrors.NewNotFound(extensions.Resource("deployments"), dep.Name)
+	}
+	d.fake.deployments[dep.Name] = dep
+	return dep, nil
+}
+
+func (d *fakeDeployments) Delete(name string, opts *api.DeleteOptions) error {
+	d.fake.Lock()
+	defer d.fake.Unlock()
+	if _, ok := d.fake.deployments[name]; !ok {
+		return k8sErrors.NewNotFound(extensions.Resource("deployments"), name)
+	}
+	delete(d.fake.deployments, name)
+	return nil
+}
+
+type fakeServices struct {
+	client.ServiceInterface
+	fake *fakeClientset
+}
+
+func (s *fakeServices) List(opts api.ListOptions) (*api.ServiceList, error) {
+	s.fake.Lock()
+	defer s.fake.Unlock()
+	list := &api.ServiceList{}
+	for _, srv := range s.fake.services {
+		if matches(opts, srv.Labels) {
+			list.Items = append(list.Items, *srv)
+		}
+	}
+	return list, nil
+}
+
+func (s *fakeServices) Get(name string) (*api.Service, error) {
+	s.fake.Lock()
+	defer s.fake.Unlock()
+	srv, ok := s.fake.services[name]
+	if !ok {
+		return nil, k8sErrors.NewNotFound(api.Resource("services"), name)
+	}
+	copy := *srv
+	return &copy, nil
+}
+
+func (s *fakeServices) Create(srv *api.Service) (*api.Service, error) {
+	s.fake.Lock()
+	defer s.fake.Unlock()
+	if _, ok := s.fake.services[srv.Name]; ok {
+		return nil, k8sErrors.NewAlreadyExists(api.Resource("services"), srv.Name)
+	}
+	for i := range srv.Spec.Ports {
+		srv.Spec.Ports[i].NodePort = s.fake.nextPort
+		s.fake.nextPort++
+	}
+	s.fake.services[srv.Name] = srv
+	return srv, nil
+}
+
+func (s *fakeServices) Update(srv *api.Service) (*api.Service, error) {
+	s.fake.Lock()
+	defer s.fake.Unlock()
+	if _, ok := s.fake.services[srv.Name]; !ok {
+		return nil, k8sErrors.NewNotFound(api.Resource("services"), srv.Name)
+	}
+	s.fake.services[srv.Name] = srv
+	return srv, nil
+}
+
+func (s *fakeServices) Delete(name string) error {
+	s.fake.Lock()
+	defer s.fake.Unlock()
+	if _, ok := s.fake.services[name]; !ok {
+		return k8sErrors.NewNotFound(api.Resource("services"), name)
+	}
+	delete(s.fake.services, name)
+	return nil
+}
+
+type fakePods struct {
+	client.PodInterface
+	fake *fakeClientset
+}
+
+func (p *fakePods) List(opts api.ListOptions) (*api.PodList, error) {
+	p.fake.Lock()
+	defer p.fake.Unlock()
+	list := &api.PodList{}
+	for _, pod := range p.fake.pods {
+		if matches(opts, pod.Labels) {
+			list.Items = append(list.Items, *pod)
+		}
+	}
+	return list, nil
+}
+
+func (p *fakePods) Get(name string) (*api.Pod, error) {
+	p.fake.Lock()
+	defer p.fake.Unlock()
+	pod, ok := p.fake.pods[name]
+	if !ok {
+		return nil, k8sErrors.NewNotFound(api.Resource("pods"), name)
+	}
+	copy := *pod
+	return &copy, nil
+}
+
+func (p *fakePods) Create(pod *api.Pod) (*api.Pod, error) {
+	p.fake.Lock()
+	defer p.fake.Unlock()
+	if _, ok := p.fake.pods[pod.Name]; ok {
+		return nil, k8sErrors.NewAlreadyExists(api.Resource("pods"), pod.Name)
+	}
+	pod.Status.Phase = p.fake.podPhase
+	p.fake.pods[pod.Name] = pod
+	return pod, nil
+}
+
+func (p *fakePods) Delete(name string, opts *api.DeleteOptions) error {
+	p.fake.Lock()
+	defer p.fake.Unlock()
+	if _, ok := p.fake.pods[name]; !ok {
+		return k8sErrors.NewNotFound(api.Resource("pods"), name)
+	}
+	delete(p.fake.pods, name)
+	return nil
+}
+
+type fakeNodes struct {
+	client.NodeInterface
+	fake *fakeClientset
+}
+
+func (n *fakeNodes) List(opts api.ListOptions) (*api.NodeList, error) {
+	n.fake.Lock()
+	defer n.fake.Unlock()
+	return &api.NodeList{Items: n.fake.nodes}, nil
+}
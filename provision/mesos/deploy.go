@@ -0,0 +1,128 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mesos
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gambol99/go-marathon"
+	"github.com/pkg/errors"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/servicecommon"
+)
+
+const buildDoneFile = "/tmp/tsuru-build-done"
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// buildHostname is the hostname of the container running the deploy
+// commands, it's used by RegisterUnit to identify the build.
+func buildHostname(a provision.App) string {
+	return strings.TrimPrefix(buildAppID(a), "/")
+}
+
+// buildScript returns the script run by the builder task. When cmds is
+// empty the source image is imported as destinationImage, otherwise cmds are
+// run in a container using the source image, which is then committed as
+// destinationImage. On success a marker file is created and the task keeps
+// running, so that its health check reports the build as finished.
+func buildScript(a provision.App, sourceImage, destinationImage string, cmds []string) string {
+	var steps []string
+	if len(cmds) == 0 {
+		steps = []string{
+			fmt.Sprintf("docker pull %s", sourceImage),
+			fmt.Sprintf("docker tag %s %s", sourceImage, destinationImage),
+			servicecommon.PushImageCmd(destinationImage),
+		}
+	} else {
+		quotedCmds := make([]string, len(cmds))
+		for i := range cmds {
+			quotedCmds[i] = shellQuote(cmds[i])
+		}
+		name := buildHostname(a)
+		steps = []string{
+			fmt.Sprintf("docker run --name %s --hostname %s %s %s", name, name, sourceImage, strings.Join(quotedCmds, " ")),
+			fmt.Sprintf("docker commit %s %s", name, destinationImage),
+			servicecommon.PushImageCmd(destinationImage),
+		}
+	}
+	script := strings.Join(steps, " && ")
+	script += "; rc=$?"
+	if len(cmds) > 0 {
+		script += fmt.Sprintf("; docker rm -f %s >/dev/null 2>&1", buildHostname(a))
+	}
+	script += fmt.Sprintf("; [ $rc -eq 0 ] || exit $rc; touch %s; exec sleep 86400", buildDoneFile)
+	return script
+}
+
+func buildAppSpec(a provision.App, sourceImage, destinationImage string, cmds []string) *marathon.Application {
+	app := marathon.NewDockerApplication().
+		Name(buildAppID(a)).
+		CPU(defaultCPUs).
+		Memory(defaultMemory).
+		Count(1).
+		Command(buildScript(a, sourceImage, destinationImage, cmds))
+	app.Container.Docker.Container(servicecommon.DeployerImage("mesos"))
+	app.Container.Volume(servicecommon.DockerSockPath, servicecommon.DockerSockPath, "RW")
+	app.AddLabel(labelIsTsuru, strconv.FormatBool(true))
+	app.AddLabel(labelIsBuild, strconv.FormatBool(true))
+	app.AddLabel(labelAppName, a.GetName())
+	app.AddLabel(labelBuildImage, destinationImage)
+	maxFailures := 0
+	app.AddHealthCheck(marathon.HealthCheck{
+		Protocol:               "COMMAND",
+		Command:                &marathon.Command{Value: fmt.Sprintf("test -f %s", buildDoneFile)},
+		MaxConsecutiveFailures: &maxFailures,
+		IntervalSeconds:        2,
+		TimeoutSeconds:         2,
+	})
+	return app
+}
+
+func runBuild(cli marathon.Marathon, a provision.App, sourceImage, destinationImage string, cmds []string, w io.Writer) error {
+	appID := buildAppID(a)
+	_, err := cli.DeleteApplication(appID, true)
+	if err != nil && !isNotFound(err) {
+		return errors.WithStack(err)
+	}
+	fmt.Fprintf(w, "---- Running build task %q for image %q ----\n", appID, destinationImage)
+	_, err = cli.CreateApplication(buildAppSpec(a, sourceImage, destinationImage, cmds))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() {
+		if _, errDel := cli.DeleteApplication(appID, true); errDel != nil && !isNotFound(errDel) {
+			fmt.Fprintf(w, "ignored error removing build task %q: %s\n", appID, errDel)
+		}
+	}()
+	return waitForBuild(cli, appID)
+}
+
+func waitForBuild(cli marathon.Marathon, appID string) error {
+	timeout := time.After(buildWaitTimeout)
+	for {
+		app, err := cli.Application(appID)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if app.LastTaskFailure != nil {
+			return errors.Errorf("build task %q failed: %s", appID, app.LastTaskFailure.Message)
+		}
+		if app.TasksHealthy > 0 {
+			return nil
+		}
+		select {
+		case <-timeout:
+			return errors.Errorf("timeout waiting for build task %q to complete", appID)
+		case <-time.After(buildWaitInterval):
+		}
+	}
+}
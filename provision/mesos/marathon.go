@@ -0,0 +1,289 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mesos
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gambol99/go-marathon"
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app/image"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/dockercommon"
+	"github.com/tsuru/tsuru/provision/servicecommon"
+	"gopkg.in/mgo.v2"
+)
+
+type notFoundError struct{ error }
+
+func (e notFoundError) NotFound() bool {
+	return true
+}
+
+var errNoMarathonNode = notFoundError{errors.New("no marathon nodes available")}
+
+const (
+	labelIsTsuru         = "tsuru.is-tsuru"
+	labelIsBuild         = "tsuru.is-build"
+	labelBuildImage      = "tsuru.build.image"
+	labelAppName         = "tsuru.app.name"
+	labelAppProcess      = "tsuru.app.process"
+	labelAppPlatform     = "tsuru.app.platform"
+	labelProcessReplicas = "tsuru.app.process.replicas"
+
+	defaultCPUs   = 0.1
+	defaultMemory = 64
+)
+
+var (
+	buildWaitTimeout  = 10 * time.Minute
+	buildWaitInterval = time.Second
+)
+
+func getMarathonClient() (marathon.Marathon, error) {
+	coll, err := nodeAddrCollection()
+	if err != nil {
+		return nil, err
+	}
+	defer coll.Close()
+	var addrs NodeAddrs
+	err = coll.FindId(uniqueDocumentID).One(&addrs)
+	if err != nil && err != mgo.ErrNotFound {
+		return nil, errors.WithStack(err)
+	}
+	if len(addrs.Addresses) == 0 {
+		return nil, errors.Wrap(errNoMarathonNode, "")
+	}
+	marathonConfig := marathon.NewDefaultConfig()
+	marathonConfig.URL = strings.Join(addrs.Addresses, ",")
+	marathonConfig.HTTPBasicAuthUser, _ = config.GetString("mesos:marathon:user")
+	marathonConfig.HTTPBasicPassword, _ = config.GetString("mesos:marathon:password")
+	cli, err := marathon.NewClient(marathonConfig)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return cli, nil
+}
+
+func isNotFound(err error) bool {
+	apiErr, ok := errors.Cause(err).(*marathon.APIError)
+	return ok && apiErr.ErrCode == marathon.ErrCodeNotFound
+}
+
+func validMarathonID(id string) string {
+	return strings.ToLower(strings.Replace(id, "_", "-", -1))
+}
+
+func marathonAppID(a provision.App, process string) string {
+	return validMarathonID(fmt.Sprintf("/%s-%s", a.GetName(), process))
+}
+
+func buildAppID(a provision.App) string {
+	return validMarathonID(fmt.Sprintf("/%s-build", a.GetName()))
+}
+
+func labelSelector(name, value string) string {
+	return fmt.Sprintf("%s==%s", name, value)
+}
+
+func listAppProcesses(cli marathon.Marathon, a provision.App, embedTasks bool) ([]marathon.Application, error) {
+	query := url.Values{
+		"label": []string{labelSelector(labelAppName, a.GetName())},
+	}
+	if embedTasks {
+		query.Set("embed", "apps.tasks")
+	}
+	apps, err := cli.Applications(query)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var result []marathon.Application
+	for _, app := range apps.Apps {
+		if app.Labels == nil || (*app.Labels)[labelIsBuild] == "true" {
+			continue
+		}
+		result = append(result, app)
+	}
+	return result, nil
+}
+
+func appResources() (float64, float64) {
+	cpus, err := config.GetFloat("mesos:cpus")
+	if err != nil {
+		cpus = defaultCPUs
+	}
+	return cpus, defaultMemory
+}
+
+func processCmds(a provision.App, process, imgID string) ([]string, error) {
+	processCmd, _, err := dockercommon.ProcessCmdForImage(process, imgID)
+	if err != nil {
+		if _, isInvalid := err.(provision.InvalidProcessError); isInvalid {
+			// Images deployed with ImageDeploy have no registered commands,
+			// the image entrypoint and cmd are used instead.
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(processCmd) == 0 {
+		return nil, nil
+	}
+	cmds, _, err := dockercommon.LeanContainerCmds(process, imgID, a)
+	return cmds, err
+}
+
+func defaultProcessName(imgID string) (string, error) {
+	data, err := image.GetImageCustomData(imgID)
+	if err != nil {
+		return "", err
+	}
+	if len(data.Processes) > 1 {
+		return "", provision.InvalidProcessError{Msg: "no process name specified and more than one declared in Procfile"}
+	}
+	for name := range data.Processes {
+		return name, nil
+	}
+	return "", nil
+}
+
+func appReplicas(app *marathon.Application) int {
+	if app == nil {
+		return 0
+	}
+	if app.Labels != nil {
+		replicas, err := strconv.Atoi((*app.Labels)[labelProcessReplicas])
+		if err == nil {
+			return replicas
+		}
+	}
+	if app.Instances != nil {
+		return *app.Instances
+	}
+	return 0
+}
+
+func marathonAppSpec(a provision.App, process, imgID string, isWeb bool, base *marathon.Application, pState servicecommon.ProcessState) (*marathon.Application, error) {
+	replicas := appReplicas(base)
+	if pState.Increment != 0 {
+		replicas += pState.Increment
+		if replicas < 0 {
+			return nil, errors.New("cannot have less than 0 units")
+		}
+	} else if replicas == 0 && pState.Start {
+		replicas = 1
+	}
+	realReplicas := replicas
+	if pState.Stop {
+		realReplicas = 0
+	}
+	cmds, err := processCmds(a, process, imgID)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	cpus, mem := appResources()
+	if appMem := a.GetMemory(); appMem > 0 {
+		mem = float64(appMem) / (1024 * 1024)
+	}
+	app := marathon.NewDockerApplication().
+		Name(marathonAppID(a, process)).
+		CPU(cpus).
+		Memory(mem).
+		Count(realReplicas)
+	app.Container.Docker.Container(imgID).Bridged()
+	if len(cmds) > 0 {
+		app.AddArgs(cmds...)
+	}
+	for _, envData := range a.Envs() {
+		app.AddEnv(envData.Name, envData.Value)
+	}
+	host, _ := config.GetString("host")
+	app.AddEnv("TSURU_HOST", host)
+	if isWeb {
+		port := dockercommon.WebProcessDefaultPort()
+		portInt, _ := strconv.Atoi(port)
+		app.AddEnv("port", port)
+		app.AddEnv("PORT", port)
+		app.Container.Docker.Expose(portInt)
+	}
+	app.AddLabel(labelIsTsuru, strconv.FormatBool(true))
+	app.AddLabel(labelIsBuild, strconv.FormatBool(false))
+	app.AddLabel(labelAppName, a.GetName())
+	app.AddLabel(labelAppProcess, process)
+	app.AddLabel(labelAppPlatform, a.GetPlatform())
+	app.AddLabel(labelProcessReplicas, strconv.Itoa(replicas))
+	return app, nil
+}
+
+type serviceManager struct {
+	client marathon.Marathon
+}
+
+func (m *serviceManager) DeployService(a provision.App, process, image string, isWeb bool, state servicecommon.ProcessState) error {
+	return deployProcess(m.client, a, process, image, isWeb, state)
+}
+
+func (m *serviceManager) RemoveService(a provision.App, process string) error {
+	return removeProcess(m.client, a, process)
+}
+
+func deployProcesses(cli marathon.Marathon, a provision.App, newImg string, updateSpec servicecommon.ProcessSpec) error {
+	return servicecommon.RunServicePipeline(&serviceManager{client: cli}, a, newImg, updateSpec)
+}
+
+func deployProcess(cli marathon.Marathon, a provision.App, process, imgID string, isWeb bool, pState servicecommon.ProcessState) error {
+	appID := marathonAppID(a, process)
+	current, err := cli.Application(appID)
+	if err != nil {
+		if !isNotFound(err) {
+			return errors.WithStack(err)
+		}
+		current = nil
+	}
+	app, err := marathonAppSpec(a, process, imgID, isWeb, current, pState)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		_, err = cli.CreateApplication(app)
+		return errors.WithStack(err)
+	}
+	_, err = cli.UpdateApplication(app, true)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if pState.Restart {
+		_, err = cli.RestartApplication(appID, true)
+	}
+	return errors.WithStack(err)
+}
+
+func removeProcess(cli marathon.Marathon, a provision.App, process string) error {
+	_, err := cli.DeleteApplication(marathonAppID(a, process), true)
+	if err != nil && !isNotFound(err) {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func allAppProcesses(appName string) ([]string, error) {
+	var processes []string
+	imgID, err := image.AppCurrentImageName(appName)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	data, err := image.GetImageCustomData(imgID)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for procName := range data.Processes {
+		processes = append(processes, procName)
+	}
+	return processes, nil
+}
@@ -7,11 +7,13 @@ package mesos
 import "github.com/tsuru/tsuru/provision"
 
 type mesosNodeWrapper struct {
-	Addresses []string
+	Addresses    []string
+	NodeMetadata map[string]string `bson:"metadata,omitempty"`
+	Disabled     bool              `bson:",omitempty"`
 }
 
 func (n *mesosNodeWrapper) Pool() string {
-	return ""
+	return n.NodeMetadata["pool"]
 }
 
 func (n *mesosNodeWrapper) Address() string {
@@ -19,11 +21,14 @@ func (n *mesosNodeWrapper) Address() string {
 }
 
 func (n *mesosNodeWrapper) Status() string {
+	if n.Disabled {
+		return "disabled"
+	}
 	return ""
 }
 
 func (n *mesosNodeWrapper) Metadata() map[string]string {
-	return nil
+	return n.NodeMetadata
 }
 
 func (n *mesosNodeWrapper) Units() ([]provision.Unit, error) {
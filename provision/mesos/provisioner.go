@@ -12,8 +12,13 @@ import (
 
 	"github.com/gambol99/go-marathon"
 	"github.com/pkg/errors"
+	"github.com/tsuru/tsuru/app/image"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
 	"github.com/tsuru/tsuru/event"
 	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/dockercommon"
+	"github.com/tsuru/tsuru/provision/servicecommon"
+	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -39,40 +44,232 @@ func (p *mesosProvisioner) Provision(provision.App) error {
 	return nil
 }
 
-func (p *mesosProvisioner) Destroy(provision.App) error {
-	return errNotImplemented
+func (p *mesosProvisioner) Destroy(a provision.App) error {
+	cli, err := getMarathonClient()
+	if err != nil {
+		return err
+	}
+	apps, err := listAppProcesses(cli, a, false)
+	if err != nil {
+		return err
+	}
+	multiErrors := tsuruErrors.NewMultiError()
+	for _, app := range apps {
+		_, err = cli.DeleteApplication(app.ID, true)
+		if err != nil && !isNotFound(err) {
+			multiErrors.Add(errors.WithStack(err))
+		}
+	}
+	if multiErrors.Len() > 0 {
+		return multiErrors
+	}
+	return nil
+}
+
+func changeUnits(a provision.App, units int, processName string, w io.Writer) error {
+	if a.GetDeploys() == 0 {
+		return errors.New("units can only be modified after the first deploy")
+	}
+	if units == 0 {
+		return errors.New("cannot change 0 units")
+	}
+	cli, err := getMarathonClient()
+	if err != nil {
+		return err
+	}
+	imgID, err := image.AppCurrentImageName(a.GetName())
+	if err != nil {
+		return err
+	}
+	if processName == "" {
+		processName, err = defaultProcessName(imgID)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return deployProcesses(cli, a, imgID, servicecommon.ProcessSpec{processName: servicecommon.ProcessState{Increment: units}})
+}
+
+func (p *mesosProvisioner) AddUnits(a provision.App, units uint, processName string, w io.Writer) error {
+	return changeUnits(a, int(units), processName, w)
+}
+
+func (p *mesosProvisioner) RemoveUnits(a provision.App, units uint, processName string, w io.Writer) error {
+	return changeUnits(a, -int(units), processName, w)
+}
+
+func changeAppState(a provision.App, process string, state servicecommon.ProcessState) error {
+	cli, err := getMarathonClient()
+	if err != nil {
+		return err
+	}
+	var processes []string
+	if process == "" {
+		processes, err = allAppProcesses(a.GetName())
+		if err != nil {
+			return err
+		}
+	} else {
+		processes = []string{process}
+	}
+	imgID, err := image.AppCurrentImageName(a.GetName())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	spec := servicecommon.ProcessSpec{}
+	for _, procName := range processes {
+		spec[procName] = state
+	}
+	return deployProcesses(cli, a, imgID, spec)
 }
 
-func (p *mesosProvisioner) AddUnits(provision.App, uint, string, io.Writer) error {
-	return errNotImplemented
+func (p *mesosProvisioner) Restart(a provision.App, process string, w io.Writer) error {
+	return changeAppState(a, process, servicecommon.ProcessState{Start: true, Restart: true})
 }
 
-func (p *mesosProvisioner) RemoveUnits(provision.App, uint, string, io.Writer) error {
-	return errNotImplemented
+func (p *mesosProvisioner) Start(a provision.App, process string) error {
+	return changeAppState(a, process, servicecommon.ProcessState{Start: true})
 }
 
-func (p *mesosProvisioner) Restart(provision.App, string, io.Writer) error {
-	return errNotImplemented
+func (p *mesosProvisioner) Stop(a provision.App, process string) error {
+	return changeAppState(a, process, servicecommon.ProcessState{Stop: true})
 }
 
-func (p *mesosProvisioner) Start(provision.App, string) error {
-	return errNotImplemented
+func taskStatus(task *marathon.Task) provision.Status {
+	for _, result := range task.HealthCheckResults {
+		if result != nil && !result.Alive {
+			return provision.StatusError
+		}
+	}
+	if task.StartedAt == "" {
+		return provision.StatusStarting
+	}
+	return provision.StatusStarted
 }
 
-func (p *mesosProvisioner) Stop(provision.App, string) error {
-	return errNotImplemented
+func taskToUnit(task *marathon.Task, app *marathon.Application, a provision.App) provision.Unit {
+	var process string
+	if app.Labels != nil {
+		process = (*app.Labels)[labelAppProcess]
+	}
+	address := &url.URL{}
+	if len(task.Ports) > 0 {
+		address = &url.URL{
+			Scheme: "http",
+			Host:   fmt.Sprintf("%s:%d", task.Host, task.Ports[0]),
+		}
+	}
+	return provision.Unit{
+		ID:          task.ID,
+		Name:        task.ID,
+		AppName:     a.GetName(),
+		ProcessName: process,
+		Type:        a.GetPlatform(),
+		Ip:          task.Host,
+		Status:      taskStatus(task),
+		Address:     address,
+	}
 }
 
-func (p *mesosProvisioner) Units(app provision.App) ([]provision.Unit, error) {
-	return nil, nil
+func appUnits(cli marathon.Marathon, a provision.App) ([]provision.Unit, error) {
+	apps, err := listAppProcesses(cli, a, true)
+	if err != nil {
+		return nil, err
+	}
+	units := []provision.Unit{}
+	for i := range apps {
+		for _, task := range apps[i].Tasks {
+			units = append(units, taskToUnit(task, &apps[i], a))
+		}
+	}
+	return units, nil
 }
 
-func (p *mesosProvisioner) RoutableAddresses(app provision.App) ([]url.URL, error) {
-	return nil, errNotImplemented
+func (p *mesosProvisioner) Units(a provision.App) ([]provision.Unit, error) {
+	cli, err := getMarathonClient()
+	if err != nil {
+		if errors.Cause(err) == errNoMarathonNode {
+			return []provision.Unit{}, nil
+		}
+		return nil, err
+	}
+	return appUnits(cli, a)
+}
+
+func (p *mesosProvisioner) RoutableAddresses(a provision.App) ([]url.URL, error) {
+	cli, err := getMarathonClient()
+	if err != nil {
+		return nil, err
+	}
+	imgID, err := image.AppCurrentImageName(a.GetName())
+	if err != nil {
+		if err != image.ErrNoImagesAvailable {
+			return nil, err
+		}
+		return nil, nil
+	}
+	webProcessName, err := image.GetImageWebProcessName(imgID)
+	if err != nil {
+		return nil, err
+	}
+	if webProcessName == "" {
+		return nil, nil
+	}
+	tasks, err := cli.Tasks(marathonAppID(a, webProcessName))
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+	var addrs []url.URL
+	for _, task := range tasks.Tasks {
+		if len(task.Ports) == 0 {
+			continue
+		}
+		addrs = append(addrs, url.URL{
+			Scheme: "http",
+			Host:   fmt.Sprintf("%s:%d", task.Host, task.Ports[0]),
+		})
+	}
+	return addrs, nil
 }
 
 func (p *mesosProvisioner) RegisterUnit(a provision.App, unitId string, customData map[string]interface{}) error {
-	return errNotImplemented
+	cli, err := getMarathonClient()
+	if err != nil {
+		return err
+	}
+	if unitId == buildHostname(a) {
+		if customData == nil {
+			return nil
+		}
+		buildApp, err := cli.Application(buildAppID(a))
+		if err != nil {
+			if isNotFound(err) {
+				return &provision.UnitNotFoundError{ID: unitId}
+			}
+			return errors.WithStack(err)
+		}
+		var buildingImage string
+		if buildApp.Labels != nil {
+			buildingImage = (*buildApp.Labels)[labelBuildImage]
+		}
+		if buildingImage == "" {
+			return errors.Errorf("invalid build image label for build task: %#v", buildApp)
+		}
+		return image.SaveImageCustomData(buildingImage, customData)
+	}
+	units, err := appUnits(cli, a)
+	if err != nil {
+		return err
+	}
+	for i := range units {
+		if units[i].ID == unitId {
+			return errors.WithStack(a.BindUnit(&units[i]))
+		}
+	}
+	return &provision.UnitNotFoundError{ID: unitId}
 }
 
 func (p *mesosProvisioner) ListNodes(addressFilter []string) ([]provision.Node, error) {
@@ -124,39 +321,123 @@ func (p *mesosProvisioner) AddNode(opts provision.AddNodeOptions) error {
 	return nil
 }
 
+// RemoveNode removes the Marathon node. Marathon itself reschedules the tasks
+// running on mesos agents, so there's nothing to rebalance.
 func (p *mesosProvisioner) RemoveNode(opts provision.RemoveNodeOptions) error {
-	return errNotImplemented
+	coll, err := nodeAddrCollection()
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	err = coll.Remove(bson.M{"_id": uniqueDocumentID, "addresses": opts.Address})
+	if err == mgo.ErrNotFound {
+		return provision.ErrNodeNotFound
+	}
+	return errors.WithStack(err)
 }
 
-func (p *mesosProvisioner) UpdateNode(provision.UpdateNodeOptions) error {
-	return errNotImplemented
+// UpdateNode updates the metadata of the Marathon node, metadata with empty
+// values are removed. Disabled nodes are kept in the node store, with their
+// status reported as disabled.
+func (p *mesosProvisioner) UpdateNode(opts provision.UpdateNodeOptions) error {
+	coll, err := nodeAddrCollection()
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	query := bson.M{"_id": uniqueDocumentID, "addresses": opts.Address}
+	set := bson.M{}
+	unset := bson.M{}
+	for k, v := range opts.Metadata {
+		if v == "" {
+			unset["metadata."+k] = ""
+		} else {
+			set["metadata."+k] = v
+		}
+	}
+	if opts.Disable {
+		set["disabled"] = true
+	}
+	if opts.Enable {
+		unset["disabled"] = ""
+	}
+	update := bson.M{}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	if len(update) == 0 {
+		var n int
+		n, err = coll.Find(query).Count()
+		if err == nil && n == 0 {
+			err = mgo.ErrNotFound
+		}
+	} else {
+		err = coll.Update(query, update)
+	}
+	if err == mgo.ErrNotFound {
+		return provision.ErrNodeNotFound
+	}
+	return errors.WithStack(err)
 }
 
 func (p *mesosProvisioner) NodeForNodeData(nodeData provision.NodeStatusData) (provision.Node, error) {
 	return provision.FindNodeByAddrs(p, nodeData.Addrs)
 }
 
-func (p *mesosProvisioner) ArchiveDeploy(app provision.App, archiveURL string, evt *event.Event) (imgID string, err error) {
-	return "", errNotImplemented
+func (p *mesosProvisioner) ArchiveDeploy(a provision.App, archiveURL string, evt *event.Event) (string, error) {
+	baseImage := image.GetBuildImage(a)
+	buildingImage, err := image.AppNewImageName(a.GetName())
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	cli, err := getMarathonClient()
+	if err != nil {
+		return "", err
+	}
+	err = runBuild(cli, a, baseImage, buildingImage, dockercommon.ArchiveDeployCmds(a, archiveURL), evt)
+	if err != nil {
+		return "", err
+	}
+	err = deployProcesses(cli, a, buildingImage, nil)
+	if err != nil {
+		return "", err
+	}
+	return buildingImage, nil
 }
 
 func (p *mesosProvisioner) ImageDeploy(a provision.App, imgID string, evt *event.Event) (string, error) {
-	hosts, err := p.ListNodes(nil)
+	cli, err := getMarathonClient()
 	if err != nil {
 		return "", err
 	}
-	marathonURL := hosts[0].Address()
-	config := marathon.NewDefaultConfig()
-	config.URL = marathonURL
-	client, err := marathon.NewClient(config)
+	if !strings.Contains(imgID, ":") {
+		imgID = fmt.Sprintf("%s:latest", imgID)
+	}
+	newImage, err := image.AppNewImageName(a.GetName())
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	fmt.Fprintln(evt, "---- Pulling image to tsuru ----")
+	err = runBuild(cli, a, imgID, newImage, nil, evt)
 	if err != nil {
 		return "", err
 	}
-	application := marathon.NewDockerApplication().Name(a.GetName()).CPU(0.1).Memory(64).Count(1)
-	if !strings.Contains(imgID, ":") {
-		imgID = fmt.Sprintf("%s:latest", imgID)
+	fmt.Fprintln(evt, "  ---> Using image entrypoint and cmd as the web process")
+	imageData := image.ImageMetadata{
+		Name:      newImage,
+		Processes: map[string][]string{"web": nil},
+	}
+	err = imageData.Save()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	a.SetUpdatePlatform(true)
+	err = deployProcesses(cli, a, newImage, nil)
+	if err != nil {
+		return "", err
 	}
-	application.Container.Docker.Container(imgID)
-	_, err = client.CreateApplication(application)
-	return "", err
+	return newImage, nil
 }
@@ -5,7 +5,11 @@
 package mesos
 
 import (
+	"net/url"
+	"sort"
+
 	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/app/image"
 	"github.com/tsuru/tsuru/event"
 	"github.com/tsuru/tsuru/permission"
 	"github.com/tsuru/tsuru/provision"
@@ -33,7 +37,7 @@ func (s *S) TestListNodes(c *check.C) {
 	}
 	err := s.p.AddNode(opts)
 	c.Assert(err, check.IsNil)
-	defer s.p.RemoveNode(provision.RemoveNodeOptions{})
+	defer s.p.RemoveNode(provision.RemoveNodeOptions{Address: url})
 	nodes, err := s.p.ListNodes([]string{})
 	c.Assert(err, check.IsNil)
 	c.Assert(nodes, check.HasLen, 1)
@@ -53,7 +57,7 @@ func (s *S) TestListNodesFilteringByAddress(c *check.C) {
 	}
 	err := s.p.AddNode(opts)
 	c.Assert(err, check.IsNil)
-	defer s.p.RemoveNode(provision.RemoveNodeOptions{})
+	defer s.p.RemoveNode(provision.RemoveNodeOptions{Address: url})
 	nodes, err := s.p.ListNodes([]string{"https://192.168.99.101"})
 	c.Assert(err, check.IsNil)
 	c.Assert(nodes, check.HasLen, 0)
@@ -66,7 +70,7 @@ func (s *S) TestGetNode(c *check.C) {
 	}
 	err := s.p.AddNode(opts)
 	c.Assert(err, check.IsNil)
-	defer s.p.RemoveNode(provision.RemoveNodeOptions{})
+	defer s.p.RemoveNode(provision.RemoveNodeOptions{Address: url})
 	node, err := s.p.GetNode(url)
 	c.Assert(err, check.IsNil)
 	c.Assert(node.Address(), check.Equals, url)
@@ -76,17 +80,74 @@ func (s *S) TestGetNode(c *check.C) {
 	c.Assert(node, check.IsNil)
 }
 
-func (s *S) TestImageDeploy(c *check.C) {
+func (s *S) TestRemoveNode(c *check.C) {
 	url := "https://192.168.99.100:8443"
-	opts := provision.AddNodeOptions{
-		Address: url,
-	}
-	err := s.p.AddNode(opts)
+	err := s.p.AddNode(provision.AddNodeOptions{Address: url})
+	c.Assert(err, check.IsNil)
+	err = s.p.RemoveNode(provision.RemoveNodeOptions{Address: "http://doesnotexist.com"})
+	c.Assert(err, check.Equals, provision.ErrNodeNotFound)
+	err = s.p.RemoveNode(provision.RemoveNodeOptions{Address: url})
+	c.Assert(err, check.IsNil)
+	nodes, err := s.p.ListNodes(nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(nodes, check.HasLen, 0)
+	err = s.p.RemoveNode(provision.RemoveNodeOptions{Address: url})
+	c.Assert(err, check.Equals, provision.ErrNodeNotFound)
+}
+
+func (s *S) TestUpdateNode(c *check.C) {
+	url := "https://192.168.99.100:8443"
+	err := s.p.AddNode(provision.AddNodeOptions{Address: url})
+	c.Assert(err, check.IsNil)
+	defer s.p.RemoveNode(provision.RemoveNodeOptions{Address: url})
+	err = s.p.UpdateNode(provision.UpdateNodeOptions{
+		Address:  url,
+		Metadata: map[string]string{"pool": "pool1", "m1": "v1"},
+		Disable:  true,
+	})
+	c.Assert(err, check.IsNil)
+	node, err := s.p.GetNode(url)
+	c.Assert(err, check.IsNil)
+	c.Assert(node.Pool(), check.Equals, "pool1")
+	c.Assert(node.Metadata(), check.DeepEquals, map[string]string{"pool": "pool1", "m1": "v1"})
+	c.Assert(node.Status(), check.Equals, "disabled")
+	err = s.p.UpdateNode(provision.UpdateNodeOptions{
+		Address:  url,
+		Metadata: map[string]string{"m1": ""},
+		Enable:   true,
+	})
+	c.Assert(err, check.IsNil)
+	node, err = s.p.GetNode(url)
+	c.Assert(err, check.IsNil)
+	c.Assert(node.Metadata(), check.DeepEquals, map[string]string{"pool": "pool1"})
+	c.Assert(node.Status(), check.Equals, "")
+}
+
+func (s *S) TestUpdateNodeNotFound(c *check.C) {
+	err := s.p.UpdateNode(provision.UpdateNodeOptions{Address: "http://doesnotexist.com", Metadata: map[string]string{"pool": "pool1"}})
+	c.Assert(err, check.Equals, provision.ErrNodeNotFound)
+	err = s.p.UpdateNode(provision.UpdateNodeOptions{Address: "http://doesnotexist.com"})
+	c.Assert(err, check.Equals, provision.ErrNodeNotFound)
+}
+
+func (s *S) prepareApp(c *check.C, processes map[string]interface{}) *app.App {
+	err := s.p.AddNode(provision.AddNodeOptions{Address: s.marathon.URL})
 	c.Assert(err, check.IsNil)
-	defer s.p.RemoveNode(provision.RemoveNodeOptions{})
 	a := &app.App{Name: "myapp", TeamOwner: s.team.Name}
 	err = app.CreateApp(a, s.user)
 	c.Assert(err, check.IsNil)
+	imgName := "tsuru/app-myapp:v1"
+	err = image.SaveImageCustomData(imgName, map[string]interface{}{"processes": processes})
+	c.Assert(err, check.IsNil)
+	cli, err := getMarathonClient()
+	c.Assert(err, check.IsNil)
+	err = deployProcesses(cli, a, imgName, nil)
+	c.Assert(err, check.IsNil)
+	a.Deploys = 1
+	return a
+}
+
+func (s *S) newDeployEvent(c *check.C, a *app.App) *event.Event {
 	evt, err := event.New(&event.Opts{
 		Target:  event.Target{Type: event.TargetTypeApp, Value: a.GetName()},
 		Kind:    permission.PermAppDeploy,
@@ -94,6 +155,228 @@ func (s *S) TestImageDeploy(c *check.C) {
 		Allowed: event.Allowed(permission.PermAppDeploy),
 	})
 	c.Assert(err, check.IsNil)
-	s.p.ImageDeploy(a, "imageName", evt)
+	return evt
+}
+
+func (s *S) instances(c *check.C, id string) int {
+	app, ok := s.marathon.apps[id]
+	c.Assert(ok, check.Equals, true)
+	return *app.Instances
+}
+
+func (s *S) TestImageDeploy(c *check.C) {
+	err := s.p.AddNode(provision.AddNodeOptions{Address: s.marathon.URL})
+	c.Assert(err, check.IsNil)
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name}
+	err = app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	evt := s.newDeployEvent(c, a)
+	img, err := s.p.ImageDeploy(a, "imageName", evt)
+	c.Assert(err, check.IsNil)
+	c.Assert(img, check.Equals, "tsuru/app-myapp:v1")
+	c.Assert(s.marathon.builds, check.HasLen, 1)
+	c.Assert(*s.marathon.builds[0].Cmd, check.Matches, "docker pull imageName:latest && docker tag imageName:latest tsuru/app-myapp:v1 && .*")
+	c.Assert((*s.marathon.builds[0].Labels)[labelBuildImage], check.Equals, "tsuru/app-myapp:v1")
+	c.Assert(s.marathon.apps, check.HasLen, 1)
+	webApp := s.marathon.apps["/myapp-web"]
+	c.Assert(webApp, check.NotNil)
+	c.Assert(*webApp.Instances, check.Equals, 1)
+	c.Assert(webApp.Container.Docker.Image, check.Equals, "tsuru/app-myapp:v1")
+	c.Assert(webApp.Args, check.IsNil)
+	c.Assert(*webApp.Container.Docker.PortMappings, check.HasLen, 1)
+	imgs, err := image.ListAppImages(a.GetName())
+	c.Assert(err, check.IsNil)
+	c.Assert(imgs, check.DeepEquals, []string{"tsuru/app-myapp:v1"})
+}
+
+func (s *S) TestImageDeployBuildFailure(c *check.C) {
+	err := s.p.AddNode(provision.AddNodeOptions{Address: s.marathon.URL})
+	c.Assert(err, check.IsNil)
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name}
+	err = app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	s.marathon.failBuild = true
+	evt := s.newDeployEvent(c, a)
+	_, err = s.p.ImageDeploy(a, "imageName", evt)
+	c.Assert(err, check.ErrorMatches, `build task "/myapp-build" failed: exit status 1`)
+	c.Assert(s.marathon.apps, check.HasLen, 0)
+	_, err = image.ListAppImages(a.GetName())
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestArchiveDeploy(c *check.C) {
+	err := s.p.AddNode(provision.AddNodeOptions{Address: s.marathon.URL})
+	c.Assert(err, check.IsNil)
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name, Platform: "python"}
+	err = app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	err = image.SaveImageCustomData("tsuru/app-myapp:v1", map[string]interface{}{
+		"processes": map[string]interface{}{"web": "python myapp.py", "worker": "python worker.py"},
+	})
+	c.Assert(err, check.IsNil)
+	evt := s.newDeployEvent(c, a)
+	img, err := s.p.ArchiveDeploy(a, "http://server/myfile.tgz", evt)
+	c.Assert(err, check.IsNil)
+	c.Assert(img, check.Equals, "tsuru/app-myapp:v1")
+	c.Assert(s.marathon.builds, check.HasLen, 1)
+	build := s.marathon.builds[0]
+	c.Assert(build.Container.Docker.Image, check.Equals, "docker:1.11.2")
+	c.Assert(*build.Cmd, check.Matches, `docker run --name myapp-build --hostname myapp-build tsuru/python.*http://server/myfile.tgz.*`)
+	c.Assert(s.marathon.apps, check.HasLen, 2)
+	c.Assert(s.instances(c, "/myapp-web"), check.Equals, 1)
+	c.Assert(s.instances(c, "/myapp-worker"), check.Equals, 1)
+	c.Assert(*s.marathon.apps["/myapp-web"].Container.Docker.PortMappings, check.HasLen, 1)
+	c.Assert(s.marathon.apps["/myapp-worker"].Container.Docker.PortMappings, check.IsNil)
+}
+
+func (s *S) TestUnits(c *check.C) {
+	a := s.prepareApp(c, map[string]interface{}{"web": "python myapp.py", "worker": "python worker.py"})
+	units, err := s.p.Units(a)
+	c.Assert(err, check.IsNil)
+	sort.Sort(unitSlice(units))
+	c.Assert(units, check.DeepEquals, []provision.Unit{
+		{
+			ID:          "myapp-web.0",
+			Name:        "myapp-web.0",
+			AppName:     "myapp",
+			ProcessName: "web",
+			Type:        "",
+			Ip:          "10.0.0.1",
+			Status:      provision.StatusStarted,
+			Address:     &url.URL{Scheme: "http", Host: "10.0.0.1:31000"},
+		},
+		{
+			ID:          "myapp-worker.0",
+			Name:        "myapp-worker.0",
+			AppName:     "myapp",
+			ProcessName: "worker",
+			Type:        "",
+			Ip:          "10.0.0.1",
+			Status:      provision.StatusStarted,
+			Address:     &url.URL{},
+		},
+	})
+}
+
+func (s *S) TestUnitsNoNodes(c *check.C) {
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name}
+	units, err := s.p.Units(a)
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 0)
+}
+
+func (s *S) TestAddUnits(c *check.C) {
+	a := s.prepareApp(c, map[string]interface{}{"web": "python myapp.py", "worker": "python worker.py"})
+	err := s.p.AddUnits(a, 3, "worker", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(s.instances(c, "/myapp-web"), check.Equals, 1)
+	c.Assert(s.instances(c, "/myapp-worker"), check.Equals, 4)
+	units, err := s.p.Units(a)
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 5)
+}
+
+func (s *S) TestAddUnitsNoDeploys(c *check.C) {
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name}
+	err := s.p.AddUnits(a, 1, "web", nil)
+	c.Assert(err, check.ErrorMatches, "units can only be modified after the first deploy")
+}
+
+func (s *S) TestRemoveUnits(c *check.C) {
+	a := s.prepareApp(c, map[string]interface{}{"web": "python myapp.py"})
+	err := s.p.AddUnits(a, 2, "", nil)
+	c.Assert(err, check.IsNil)
+	err = s.p.RemoveUnits(a, 2, "web", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(s.instances(c, "/myapp-web"), check.Equals, 1)
+	err = s.p.RemoveUnits(a, 2, "web", nil)
+	c.Assert(err, check.ErrorMatches, "cannot have less than 0 units")
+}
+
+func (s *S) TestStopStart(c *check.C) {
+	a := s.prepareApp(c, map[string]interface{}{"web": "python myapp.py", "worker": "python worker.py"})
+	err := s.p.AddUnits(a, 1, "web", nil)
+	c.Assert(err, check.IsNil)
+	err = s.p.Stop(a, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(s.instances(c, "/myapp-web"), check.Equals, 0)
+	c.Assert(s.instances(c, "/myapp-worker"), check.Equals, 0)
+	err = s.p.Start(a, "web")
+	c.Assert(err, check.IsNil)
+	c.Assert(s.instances(c, "/myapp-web"), check.Equals, 2)
+	c.Assert(s.instances(c, "/myapp-worker"), check.Equals, 0)
+}
+
+func (s *S) TestRestart(c *check.C) {
+	a := s.prepareApp(c, map[string]interface{}{"web": "python myapp.py", "worker": "python worker.py"})
+	err := s.p.Restart(a, "worker", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(s.marathon.restarts, check.DeepEquals, map[string]int{"/myapp-worker": 1})
+	err = s.p.Restart(a, "", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(s.marathon.restarts, check.DeepEquals, map[string]int{"/myapp-web": 1, "/myapp-worker": 2})
+}
+
+func (s *S) TestDestroy(c *check.C) {
+	a := s.prepareApp(c, map[string]interface{}{"web": "python myapp.py", "worker": "python worker.py"})
+	c.Assert(s.marathon.apps, check.HasLen, 2)
+	err := s.p.Destroy(a)
+	c.Assert(err, check.IsNil)
+	c.Assert(s.marathon.apps, check.HasLen, 0)
+}
+
+func (s *S) TestRoutableAddresses(c *check.C) {
+	a := s.prepareApp(c, map[string]interface{}{"web": "python myapp.py", "worker": "python worker.py"})
+	err := s.p.AddUnits(a, 1, "web", nil)
+	c.Assert(err, check.IsNil)
+	addrs, err := s.p.RoutableAddresses(a)
+	c.Assert(err, check.IsNil)
+	c.Assert(addrs, check.DeepEquals, []url.URL{
+		{Scheme: "http", Host: "10.0.0.1:31000"},
+		{Scheme: "http", Host: "10.0.0.1:31001"},
+	})
+}
+
+func (s *S) TestRoutableAddressesNoWebProcess(c *check.C) {
+	a := s.prepareApp(c, map[string]interface{}{"worker": "python worker.py", "other": "python other.py"})
+	addrs, err := s.p.RoutableAddresses(a)
+	c.Assert(err, check.IsNil)
+	c.Assert(addrs, check.IsNil)
+}
+
+func (s *S) TestRegisterUnit(c *check.C) {
+	a := s.prepareApp(c, map[string]interface{}{"web": "python myapp.py"})
+	err := s.p.RegisterUnit(a, "myapp-web.0", nil)
 	c.Assert(err, check.IsNil)
+	err = s.p.RegisterUnit(a, "myapp-web.9", nil)
+	c.Assert(err, check.FitsTypeOf, &provision.UnitNotFoundError{})
+}
+
+func (s *S) TestRegisterUnitBuild(c *check.C) {
+	a := s.prepareApp(c, map[string]interface{}{"web": "python myapp.py"})
+	cli, err := getMarathonClient()
+	c.Assert(err, check.IsNil)
+	_, err = cli.CreateApplication(buildAppSpec(a, "tsuru/python:latest", "tsuru/app-myapp:v2", []string{"deploy"}))
+	c.Assert(err, check.IsNil)
+	err = s.p.RegisterUnit(a, "myapp-build", map[string]interface{}{
+		"processes": map[string]interface{}{"web": "python web.py", "worker": "python worker.py"},
+	})
+	c.Assert(err, check.IsNil)
+	data, err := image.GetImageCustomData("tsuru/app-myapp:v2")
+	c.Assert(err, check.IsNil)
+	c.Assert(data.Processes, check.DeepEquals, map[string][]string{"web": {"python web.py"}, "worker": {"python worker.py"}})
+}
+
+type unitSlice []provision.Unit
+
+func (s unitSlice) Len() int {
+	return len(s)
+}
+
+func (s unitSlice) Less(i, j int) bool {
+	return s[i].ID < s[j].ID
+}
+
+func (s unitSlice) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
 }
@@ -5,9 +5,17 @@
 package mesos
 
 import (
+	"encoding/json"
+	"fmt"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/gambol99/go-marathon"
 	"github.com/tsuru/config"
 	"github.com/tsuru/tsuru/app"
 	"github.com/tsuru/tsuru/auth"
@@ -22,11 +30,12 @@ import (
 )
 
 type S struct {
-	p     *mesosProvisioner
-	conn  *db.Storage
-	user  *auth.User
-	team  *auth.Team
-	token auth.Token
+	p        *mesosProvisioner
+	marathon *fakeMarathon
+	conn     *db.Storage
+	user     *auth.User
+	team     *auth.Team
+	token    auth.Token
 }
 
 var _ = check.Suite(&S{})
@@ -50,6 +59,10 @@ func (s *S) TearDownSuite(c *check.C) {
 	s.conn.Close()
 }
 
+func (s *S) TearDownTest(c *check.C) {
+	s.marathon.Close()
+}
+
 func (s *S) SetUpTest(c *check.C) {
 	routertest.FakeRouter.Reset()
 	rand.Seed(0)
@@ -70,6 +83,8 @@ func (s *S) SetUpTest(c *check.C) {
 	err = p.Save()
 	c.Assert(err, check.IsNil)
 	s.p = &mesosProvisioner{}
+	s.marathon = newFakeMarathon()
+	buildWaitInterval = time.Millisecond
 	s.user = &auth.User{Email: "whiskeyjack@genabackis.com", Password: "123456", Quota: quota.Unlimited}
 	nativeScheme := auth.ManagedScheme(native.NativeScheme{})
 	app.AuthScheme = nativeScheme
@@ -82,3 +97,153 @@ func (s *S) SetUpTest(c *check.C) {
 	s.token, err = nativeScheme.Login(map[string]string{"email": s.user.Email, "password": "123456"})
 	c.Assert(err, check.IsNil)
 }
+
+// fakeMarathon is an in-memory implementation of the subset of the marathon
+// API used by the provisioner. Each application instance is represented by
+// a running task and build applications become healthy as soon as they are
+// created, unless failBuild is set.
+type fakeMarathon struct {
+	sync.Mutex
+	*httptest.Server
+	apps      map[string]*marathon.Application
+	restarts  map[string]int
+	failBuild bool
+	builds    []*marathon.Application
+}
+
+func newFakeMarathon() *fakeMarathon {
+	f := &fakeMarathon{
+		apps:     map[string]*marathon.Application{},
+		restarts: map[string]int{},
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	return f
+}
+
+func (f *fakeMarathon) tasks(app *marathon.Application) []*marathon.Task {
+	var instances int
+	if app.Instances != nil {
+		instances = *app.Instances
+	}
+	tasks := []*marathon.Task{}
+	for i := 0; i < instances; i++ {
+		task := &marathon.Task{
+			ID:        fmt.Sprintf("%s.%d", strings.TrimPrefix(app.ID, "/"), i),
+			AppID:     app.ID,
+			Host:      "10.0.0.1",
+			StartedAt: "2017-01-01T00:00:00.000Z",
+		}
+		if app.Container != nil && app.Container.Docker != nil && app.Container.Docker.PortMappings != nil && len(*app.Container.Docker.PortMappings) > 0 {
+			task.Ports = []int{31000 + i}
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks
+}
+
+func (f *fakeMarathon) matches(app *marathon.Application, selector string) bool {
+	if selector == "" {
+		return true
+	}
+	parts := strings.SplitN(selector, "==", 2)
+	if len(parts) != 2 || app.Labels == nil {
+		return false
+	}
+	return (*app.Labels)[parts[0]] == parts[1]
+}
+
+func (f *fakeMarathon) writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+func (f *fakeMarathon) notFound(w http.ResponseWriter, id string) {
+	f.writeJSON(w, http.StatusNotFound, map[string]string{"message": fmt.Sprintf("App '%s' does not exist", id)})
+}
+
+func (f *fakeMarathon) deployment(w http.ResponseWriter) {
+	f.writeJSON(w, http.StatusOK, marathon.DeploymentID{DeploymentID: "dep1", Version: "v1"})
+}
+
+func (f *fakeMarathon) handle(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/v2/apps")
+	if path == "" || path == "/" {
+		switch r.Method {
+		case http.MethodGet:
+			apps := []marathon.Application{}
+			for _, app := range f.apps {
+				if !f.matches(app, r.URL.Query().Get("label")) {
+					continue
+				}
+				copied := *app
+				if r.URL.Query().Get("embed") == "apps.tasks" {
+					copied.Tasks = f.tasks(app)
+				}
+				apps = append(apps, copied)
+			}
+			f.writeJSON(w, http.StatusOK, marathon.Applications{Apps: apps})
+		case http.MethodPost:
+			var app marathon.Application
+			json.NewDecoder(r.Body).Decode(&app)
+			f.save(&app)
+			f.writeJSON(w, http.StatusCreated, app)
+		}
+		return
+	}
+	if strings.HasSuffix(path, "/tasks") {
+		id := strings.TrimSuffix(path, "/tasks")
+		app, ok := f.apps[id]
+		if !ok {
+			f.notFound(w, id)
+			return
+		}
+		tasks := marathon.Tasks{Tasks: []marathon.Task{}}
+		for _, task := range f.tasks(app) {
+			tasks.Tasks = append(tasks.Tasks, *task)
+		}
+		f.writeJSON(w, http.StatusOK, tasks)
+		return
+	}
+	if strings.HasSuffix(path, "/restart") {
+		id := strings.TrimSuffix(path, "/restart")
+		if _, ok := f.apps[id]; !ok {
+			f.notFound(w, id)
+			return
+		}
+		f.restarts[id]++
+		f.deployment(w)
+		return
+	}
+	app, ok := f.apps[path]
+	if !ok {
+		f.notFound(w, path)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		f.writeJSON(w, http.StatusOK, map[string]interface{}{"app": app})
+	case http.MethodPut:
+		var updated marathon.Application
+		json.NewDecoder(r.Body).Decode(&updated)
+		f.save(&updated)
+		f.deployment(w)
+	case http.MethodDelete:
+		delete(f.apps, path)
+		f.deployment(w)
+	}
+}
+
+func (f *fakeMarathon) save(app *marathon.Application) {
+	if app.Labels != nil && (*app.Labels)[labelIsBuild] == "true" {
+		if f.failBuild {
+			app.LastTaskFailure = &marathon.LastTaskFailure{Message: "exit status 1"}
+		} else {
+			app.TasksHealthy = 1
+		}
+		f.builds = append(f.builds, app)
+	}
+	f.apps[app.ID] = app
+}
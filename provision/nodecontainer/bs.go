@@ -76,3 +76,61 @@ func InitializeBS() (bool, error) {
 	}
 	return true, conf.Save("", bsNodeContainer)
 }
+
+// BsMetricEnvs returns the metric related environment variables set in the
+// big-sibling node container for the pool.
+func BsMetricEnvs(pool string) map[string]string {
+	bsContainer, err := LoadNodeContainer(pool, BsDefaultName)
+	if err != nil {
+		return map[string]string{}
+	}
+	envs := bsContainer.EnvMap()
+	for envName := range envs {
+		if !strings.HasPrefix(envName, "METRICS_") {
+			delete(envs, envName)
+		}
+	}
+	return envs
+}
+
+// BsLogsEnabled checks whether the big-sibling node container for the pool
+// forwards logs to tsuru. When it doesn't, a message describing the enabled
+// log backends is returned.
+func BsLogsEnabled(pool string) (bool, string, error) {
+	const (
+		logBackendsEnv      = "LOG_BACKENDS"
+		logDocKeyFormat     = "LOG_%s_DOC"
+		tsuruLogBackendName = "tsuru"
+	)
+	bsContainer, err := LoadNodeContainer(pool, BsDefaultName)
+	if err != nil {
+		return false, "", err
+	}
+	envs := bsContainer.EnvMap()
+	enabledBackends := envs[logBackendsEnv]
+	if enabledBackends == "" {
+		return true, "", nil
+	}
+	backendsList := strings.Split(enabledBackends, ",")
+	for i := range backendsList {
+		backendsList[i] = strings.TrimSpace(backendsList[i])
+		if backendsList[i] == tsuruLogBackendName {
+			return true, "", nil
+		}
+	}
+	var docs []string
+	for _, backendName := range backendsList {
+		keyName := fmt.Sprintf(logDocKeyFormat, strings.ToUpper(backendName))
+		backendDoc := envs[keyName]
+		var docLine string
+		if backendDoc == "" {
+			docLine = fmt.Sprintf("* %s", backendName)
+		} else {
+			docLine = fmt.Sprintf("* %s: %s", backendName, backendDoc)
+		}
+		docs = append(docs, docLine)
+	}
+	fullDoc := fmt.Sprintf("Logs not available through tsuru. Enabled log backends are:\n%s",
+		strings.Join(docs, "\n"))
+	return false, fullDoc, nil
+}
@@ -230,6 +230,8 @@ type App interface {
 
 	GetRouter() (string, error)
 
+	GetRouters() ([]router.AppRouter, error)
+
 	GetPool() string
 
 	GetTeamOwner() string
@@ -405,6 +407,29 @@ type UnitStatusProvisioner interface {
 	SetUnitStatus(Unit, Status) error
 }
 
+const (
+	UnitMetricCPU      = "cpu"
+	UnitMetricMemory   = "memory"
+	UnitMetricRequests = "rps"
+)
+
+// UnitMetrics holds the resource usage reported for a unit. CPU and memory
+// are percentages of the resources available to the unit and requests are
+// measured in requests per second, as reported by the app router. Metrics
+// not available in the provisioner are absent from Values.
+type UnitMetrics struct {
+	ID          string
+	ProcessName string
+	Values      map[string]float64
+}
+
+// UnitMetricsProvisioner is a provisioner that reports the resource usage of
+// the units of an application.
+type UnitMetricsProvisioner interface {
+	// UnitsMetrics returns the current usage of each unit of the app.
+	UnitsMetrics(App) ([]UnitMetrics, error)
+}
+
 type AddNodeOptions struct {
 	Address    string
 	Metadata   map[string]string
@@ -673,6 +698,19 @@ type TsuruYamlHealthcheck struct {
 	RouterBody      string
 	UseInRouter     bool `json:"use_in_router" bson:"use_in_router"`
 	AllowedFailures int  `json:"allowed_failures" bson:"allowed_failures"`
+	Verification    TsuruYamlVerification
+}
+
+// TsuruYamlVerification configures the period after a deploy during which
+// the app is probed. Duration and Interval are expressed in seconds, and
+// ErrorRate is the percentage of failed probes that triggers an automatic
+// rollback to the previous image, once at least MinProbes probes were made.
+type TsuruYamlVerification struct {
+	Path      string
+	Duration  int
+	Interval  int
+	ErrorRate float64 `json:"error_rate" bson:"error_rate"`
+	MinProbes int     `json:"min_probes" bson:"min_probes"`
 }
 
 func (hc TsuruYamlHealthcheck) ToRouterHC() router.HealthcheckData {
@@ -183,7 +183,17 @@ func (a *FakeApp) AddInstance(instanceApp bind.InstanceApp, w io.Writer) error {
 	a.instancesLock.Lock()
 	defer a.instancesLock.Unlock()
 	instances := a.instances[instanceApp.ServiceName]
-	instances = append(instances, instanceApp.Instance)
+	found := false
+	for i, inst := range instances {
+		if inst.Name == instanceApp.Instance.Name {
+			instances[i] = instanceApp.Instance
+			found = true
+			break
+		}
+	}
+	if !found {
+		instances = append(instances, instanceApp.Instance)
+	}
 	a.instances[instanceApp.ServiceName] = instances
 	if w != nil {
 		w.Write([]byte("add instance"))
@@ -347,6 +357,10 @@ func (app *FakeApp) GetRouter() (string, error) {
 	return "fake", nil
 }
 
+func (app *FakeApp) GetRouters() ([]router.AppRouter, error) {
+	return []router.AppRouter{{Name: "fake"}}, nil
+}
+
 func (app *FakeApp) GetTeamsName() []string {
 	return app.Teams
 }
@@ -608,6 +622,49 @@ func (p *FakeProvisioner) MetricEnvs(app provision.App) map[string]string {
 	}
 }
 
+// PrepareUnitsMetric sets the value of the given metric reported by
+// UnitsMetrics for all units of the app.
+func (p *FakeProvisioner) PrepareUnitsMetric(app provision.App, metric string, value float64) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	a := p.apps[app.GetName()]
+	if a.metrics == nil {
+		a.metrics = make(map[string]float64)
+	}
+	a.metrics[metric] = value
+	p.apps[app.GetName()] = a
+}
+
+// UnitsMetrics returns the metrics prepared with PrepareUnitsMetric for each
+// unit of the app, along with the requests per second reported by the fake
+// router for the unit address.
+func (p *FakeProvisioner) UnitsMetrics(app provision.App) ([]provision.UnitMetrics, error) {
+	if err := p.getError("UnitsMetrics"); err != nil {
+		return nil, err
+	}
+	requests, _ := routertest.FakeRouter.RoutesRequests(app.GetName())
+	p.mut.RLock()
+	defer p.mut.RUnlock()
+	pApp, ok := p.apps[app.GetName()]
+	if !ok {
+		return nil, errNotProvisioned
+	}
+	metrics := make([]provision.UnitMetrics, len(pApp.units))
+	for i, u := range pApp.units {
+		values := make(map[string]float64, len(pApp.metrics))
+		for k, v := range pApp.metrics {
+			values[k] = v
+		}
+		if u.Address != nil {
+			if rps, ok := requests[u.Address.Host]; ok {
+				values[provision.UnitMetricRequests] = rps
+			}
+		}
+		metrics[i] = provision.UnitMetrics{ID: u.ID, ProcessName: u.ProcessName, Values: values}
+	}
+	return metrics, nil
+}
+
 // Restarts returns the number of restarts for a given app.
 func (p *FakeProvisioner) Restarts(a provision.App, process string) int {
 	p.mut.RLock()
@@ -1491,6 +1548,7 @@ type provisionedApp struct {
 	unitLen     int
 	lastData    map[string]interface{}
 	image       string
+	metrics     map[string]float64
 }
 
 type provisionedPlatform struct {
@@ -0,0 +1,105 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package servicecommon
+
+import (
+	"errors"
+
+	"github.com/tsuru/tsuru/app/image"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/provisiontest"
+	"gopkg.in/check.v1"
+)
+
+type managerCall struct {
+	action  string
+	process string
+	image   string
+	isWeb   bool
+	state   ProcessState
+}
+
+type fakeManager struct {
+	calls     []managerCall
+	failOnImg string
+}
+
+func (m *fakeManager) DeployService(a provision.App, process, image string, isWeb bool, state ProcessState) error {
+	m.calls = append(m.calls, managerCall{action: "deploy", process: process, image: image, isWeb: isWeb, state: state})
+	if image == m.failOnImg && process == "worker" {
+		return errors.New("deploy failed")
+	}
+	return nil
+}
+
+func (m *fakeManager) RemoveService(a provision.App, process string) error {
+	m.calls = append(m.calls, managerCall{action: "remove", process: process})
+	return nil
+}
+
+func (s *S) TestRunServicePipeline(c *check.C) {
+	a := provisiontest.NewFakeApp("myapp", "python", 1)
+	err := image.SaveImageCustomData("app:v1", map[string]interface{}{
+		"processes": map[string]interface{}{"web": "python web.py", "old": "python old.py"},
+	})
+	c.Assert(err, check.IsNil)
+	err = image.AppendAppImageName(a.GetName(), "app:v1")
+	c.Assert(err, check.IsNil)
+	err = image.SaveImageCustomData("app:v2", map[string]interface{}{
+		"processes": map[string]interface{}{"web": "python web.py", "worker": "python worker.py"},
+	})
+	c.Assert(err, check.IsNil)
+	m := &fakeManager{}
+	err = RunServicePipeline(m, a, "app:v2", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(m.calls, check.DeepEquals, []managerCall{
+		{action: "deploy", process: "web", image: "app:v2", isWeb: true, state: ProcessState{Start: true}},
+		{action: "deploy", process: "worker", image: "app:v2", state: ProcessState{Start: true}},
+		{action: "remove", process: "old"},
+	})
+	current, err := image.AppCurrentImageName(a.GetName())
+	c.Assert(err, check.IsNil)
+	c.Assert(current, check.Equals, "app:v2")
+}
+
+func (s *S) TestRunServicePipelineUpdateSpec(c *check.C) {
+	a := provisiontest.NewFakeApp("myapp", "python", 1)
+	err := image.SaveImageCustomData("app:v1", map[string]interface{}{
+		"processes": map[string]interface{}{"web": "python web.py", "worker": "python worker.py"},
+	})
+	c.Assert(err, check.IsNil)
+	m := &fakeManager{}
+	err = RunServicePipeline(m, a, "app:v1", ProcessSpec{"worker": {Increment: 2}})
+	c.Assert(err, check.IsNil)
+	c.Assert(m.calls, check.DeepEquals, []managerCall{
+		{action: "deploy", process: "web", image: "app:v1", isWeb: true},
+		{action: "deploy", process: "worker", image: "app:v1", state: ProcessState{Increment: 2}},
+	})
+}
+
+func (s *S) TestRunServicePipelineRollback(c *check.C) {
+	a := provisiontest.NewFakeApp("myapp", "python", 1)
+	err := image.SaveImageCustomData("app:v1", map[string]interface{}{
+		"processes": map[string]interface{}{"web": "python web.py"},
+	})
+	c.Assert(err, check.IsNil)
+	err = image.AppendAppImageName(a.GetName(), "app:v1")
+	c.Assert(err, check.IsNil)
+	err = image.SaveImageCustomData("app:v2", map[string]interface{}{
+		"processes": map[string]interface{}{"web": "python web.py", "worker": "python worker.py"},
+	})
+	c.Assert(err, check.IsNil)
+	m := &fakeManager{failOnImg: "app:v2"}
+	err = RunServicePipeline(m, a, "app:v2", nil)
+	c.Assert(err, check.ErrorMatches, "deploy failed")
+	c.Assert(m.calls, check.DeepEquals, []managerCall{
+		{action: "deploy", process: "web", image: "app:v2", isWeb: true, state: ProcessState{Start: true}},
+		{action: "deploy", process: "worker", image: "app:v2", state: ProcessState{Start: true}},
+		{action: "deploy", process: "web", image: "app:v1", isWeb: true},
+	})
+	current, err := image.AppCurrentImageName(a.GetName())
+	c.Assert(err, check.IsNil)
+	c.Assert(current, check.Equals, "app:v1")
+}
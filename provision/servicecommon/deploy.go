@@ -0,0 +1,35 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package servicecommon
+
+import (
+	"fmt"
+
+	"github.com/tsuru/config"
+)
+
+const (
+	DockerSockPath       = "/var/run/docker.sock"
+	defaultDeployerImage = "docker:1.11.2"
+)
+
+// DeployerImage returns the image used to build and push app images, read
+// from the <provisioner>:deploy-sidecar-image config.
+func DeployerImage(provisioner string) string {
+	img, _ := config.GetString(provisioner + ":deploy-sidecar-image")
+	if img == "" {
+		return defaultDeployerImage
+	}
+	return img
+}
+
+// PushImageCmd returns the shell command pushing img to the registry, or a
+// no-op command when no registry is configured.
+func PushImageCmd(img string) string {
+	if _, err := config.GetString("docker:registry"); err != nil {
+		return "true"
+	}
+	return fmt.Sprintf("docker push %s", img)
+}
@@ -0,0 +1,368 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provision
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/router"
+)
+
+const (
+	StrategyRolling   = "rolling"
+	StrategyBlueGreen = "blue-green"
+	StrategyCanary    = "canary"
+)
+
+var ErrDeployCanceled = errors.New("deploy canceled by user action")
+
+// DeployStrategy describes how the traffic is moved from the units running
+// the current image to the units running the new image during a deploy.
+//
+// The rolling strategy, which is also the default one, leaves the routing
+// decisions to the provisioner. The blue-green strategy waits for all new
+// units to be ready and moves the traffic at once. The canary strategy moves
+// StepPercent of the traffic at a time, waiting Pause between each step.
+type DeployStrategy struct {
+	Type        string
+	StepPercent int
+	Pause       time.Duration
+}
+
+func (s DeployStrategy) Validate() error {
+	switch s.Type {
+	case "", StrategyRolling, StrategyBlueGreen:
+	case StrategyCanary:
+		if s.StepPercent < 1 || s.StepPercent > 100 {
+			return errors.New("canary step percentage must be between 1 and 100")
+		}
+	default:
+		return errors.Errorf("invalid deploy strategy %q, valid strategies are: %s, %s and %s", s.Type, StrategyRolling, StrategyBlueGreen, StrategyCanary)
+	}
+	if s.Pause < 0 {
+		return errors.New("deploy strategy pause cannot be negative")
+	}
+	return nil
+}
+
+// ShiftsRoutes returns whether the strategy requires the provisioner to move
+// the traffic using ShiftRoutes.
+func (s DeployStrategy) ShiftsRoutes() bool {
+	return s.Type == StrategyBlueGreen || s.Type == StrategyCanary
+}
+
+// Steps returns the cumulative percentage of traffic sent to the new units
+// after each step of the strategy.
+func (s DeployStrategy) Steps() []int {
+	if s.Type != StrategyCanary {
+		return []int{100}
+	}
+	var steps []int
+	for pct := s.StepPercent; pct < 100; pct += s.StepPercent {
+		steps = append(steps, pct)
+	}
+	return append(steps, 100)
+}
+
+// DeployStrategyFromEvent returns the deploy strategy stored in the start
+// data of a deploy event, the default strategy is returned for events not
+// carrying any strategy.
+func DeployStrategyFromEvent(evt *event.Event) DeployStrategy {
+	var data struct {
+		Strategy DeployStrategy
+	}
+	if evt == nil {
+		return data.Strategy
+	}
+	err := evt.StartData(&data)
+	if err != nil {
+		log.Errorf("unable to read deploy strategy from event, using default: %s", err)
+	}
+	return data.Strategy
+}
+
+func checkDeployCanceled(evt *event.Event) error {
+	if evt == nil {
+		return nil
+	}
+	canceled, err := evt.AckCancel()
+	if err != nil {
+		log.Errorf("unable to check if event should be canceled, ignoring: %s", err)
+		return nil
+	}
+	if canceled {
+		return ErrDeployCanceled
+	}
+	return nil
+}
+
+// canaryStepTolerance is the maximum distance, in percentage points, between
+// a canary step and the share of the routes of the new units at that step
+// when the traffic is moved by changing routes.
+const canaryStepTolerance = 1
+
+// canaryBackendSuffix is appended to the app name to form the name of the
+// backend holding the routes of the new units while their traffic is shifted
+// using weights. App names can't contain underscores, so it never clashes
+// with the backend of an app.
+const canaryBackendSuffix = "_canary"
+
+// ShiftRoutes moves the traffic of appName from oldRoutes to newRoutes in
+// each one of the routers following the strategy steps. Each step is logged
+// to w and the event is checked for cancelation before each of them. In case
+// of errors or cancelation the routers are restored to the old routes.
+//
+// When every router supports weights and the app has cnames, canary steps
+// send the exact percentage of the traffic of the cnames to the new units.
+// Otherwise steps are approximated by the number of routes of the new and old
+// units, and step percentages the number of units can't represent are
+// rejected before any route is changed.
+func (s DeployStrategy) ShiftRoutes(routers []router.Router, appName string, oldRoutes, newRoutes []*url.URL, w io.Writer, evt *event.Event) error {
+	if w == nil {
+		w = ioutil.Discard
+	}
+	fmt.Fprintf(w, "\n---- Shifting traffic to new units using %s strategy ----\n", s.Type)
+	steps := s.Steps()
+	if len(steps) > 1 && len(oldRoutes) > 0 && len(newRoutes) > 0 {
+		cnames, err := weightedCNames(routers, appName)
+		if err != nil {
+			return err
+		}
+		if cnames != nil {
+			return s.shiftWeights(routers, cnames, appName, oldRoutes, newRoutes, w, evt)
+		}
+	}
+	plan, err := routeSteps(steps, len(newRoutes), len(oldRoutes))
+	if err != nil {
+		fmt.Fprintf(w, " ---> %s\n", err)
+		return err
+	}
+	return s.shiftRouteCounts(routers, plan, appName, oldRoutes, newRoutes, w, evt)
+}
+
+// routeStep is a canary step expressed as the number of routes of new units
+// added to the routers and the number of routes of old units kept in them.
+type routeStep struct {
+	percent int
+	added   int
+	kept    int
+}
+
+// routeSteps translates the steps to numbers of routes, returning an error
+// if the share of the routes of new units at any step is too far from the
+// step percentage.
+func routeSteps(steps []int, newCount, oldCount int) ([]routeStep, error) {
+	result := make([]routeStep, len(steps))
+	kept := oldCount
+	for i, pct := range steps {
+		added := (newCount*pct + 99) / 100
+		if pct < 100 && added > 0 {
+			// keeps old routes so that added/(added+kept) is the closest
+			// to the step percentage.
+			if k := (added*(100-pct)*2 + pct) / (pct * 2); k < kept {
+				kept = k
+			}
+		} else {
+			kept = 0
+		}
+		result[i] = routeStep{percent: pct, added: added, kept: kept}
+		if oldCount == 0 || added+kept == 0 {
+			continue
+		}
+		share := float64(added*100) / float64(added+kept)
+		if share-float64(pct) > canaryStepTolerance || float64(pct)-share > canaryStepTolerance {
+			return nil, errors.Errorf("canary step of %d%% can't be represented by the routes of %d new and %d old units, it would send %.0f%% of the traffic to new units; use a larger step or a router supporting weights", pct, newCount, oldCount, share)
+		}
+	}
+	return result, nil
+}
+
+func (s DeployStrategy) shiftRouteCounts(routers []router.Router, plan []routeStep, appName string, oldRoutes, newRoutes []*url.URL, w io.Writer, evt *event.Event) (err error) {
+	var added, removed int
+	defer func() {
+		if err == nil {
+			return
+		}
+		fmt.Fprintf(w, " ---> Restoring routes after failed traffic shift: %s\n", err)
+		for _, r := range routers {
+			if errRm := r.RemoveRoutes(appName, newRoutes[:added]); errRm != nil {
+				log.Errorf("[shift-routes] error removing routes from new units of %s: %s", appName, errRm)
+			}
+			if errAdd := r.AddRoutes(appName, oldRoutes[:removed]); errAdd != nil {
+				log.Errorf("[shift-routes] error adding back routes to old units of %s: %s", appName, errAdd)
+			}
+		}
+	}()
+	for i, step := range plan {
+		if i > 0 && s.Pause > 0 {
+			fmt.Fprintf(w, " ---> Waiting %s before next step\n", s.Pause)
+			time.Sleep(s.Pause)
+		}
+		if err = checkDeployCanceled(evt); err != nil {
+			return err
+		}
+		toRemove := len(oldRoutes) - step.kept
+		// counters are updated before changing the routers so routes changed
+		// in only some of them are also restored in case of errors.
+		if step.added > added {
+			prevAdded := added
+			added = step.added
+			for _, r := range routers {
+				err = r.AddRoutes(appName, newRoutes[prevAdded:step.added])
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if toRemove > removed {
+			prevRemoved := removed
+			removed = toRemove
+			for _, r := range routers {
+				err = r.RemoveRoutes(appName, oldRoutes[prevRemoved:toRemove])
+				if err != nil {
+					return err
+				}
+			}
+		}
+		fmt.Fprintf(w, " ---> Step %d/%d: %d%% of traffic on new units (%d new routes, %d old routes)\n", i+1, len(plan), step.percent, added, len(oldRoutes)-removed)
+	}
+	return nil
+}
+
+// weightedCNames returns the cnames of the app without weights in each one
+// of the routers, or nil if any of the routers doesn't support weights or
+// has no such cnames.
+func weightedCNames(routers []router.Router, appName string) (map[router.Router][]string, error) {
+	result := make(map[router.Router][]string, len(routers))
+	for _, r := range routers {
+		weightedRouter, ok := r.(router.WeightedRouter)
+		if !ok {
+			return nil, nil
+		}
+		cnameRouter, ok := r.(router.CNameRouter)
+		if !ok {
+			return nil, nil
+		}
+		cnames, err := cnameRouter.CNames(appName)
+		if err != nil {
+			return nil, err
+		}
+		weights, err := weightedRouter.CNamesWeights(appName)
+		if err != nil {
+			return nil, err
+		}
+		for _, cname := range cnames {
+			if _, ok := weights[cname.Host]; !ok {
+				result[r] = append(result[r], cname.Host)
+			}
+		}
+		if len(result[r]) == 0 {
+			return nil, nil
+		}
+	}
+	return result, nil
+}
+
+// shiftWeights moves the traffic of the cnames of the app using weights. The
+// routes of the new units are added to a separate backend receiving the step
+// percentage of the traffic of the cnames, the routes of the app backend are
+// only replaced in the last step.
+func (s DeployStrategy) shiftWeights(routers []router.Router, cnames map[router.Router][]string, appName string, oldRoutes, newRoutes []*url.URL, w io.Writer, evt *event.Event) (err error) {
+	canaryName := appName + canaryBackendSuffix
+	var added, removed bool
+	defer func() {
+		for _, r := range routers {
+			for _, cname := range cnames[r] {
+				if errWeights := r.(router.WeightedRouter).SetCNameWeights(appName, cname, nil); errWeights != nil {
+					log.Errorf("[shift-routes] error removing weights of cname %s of %s: %s", cname, appName, errWeights)
+				}
+			}
+			if errRm := r.RemoveBackend(canaryName); errRm != nil && errRm != router.ErrBackendNotFound {
+				log.Errorf("[shift-routes] error removing backend %s: %s", canaryName, errRm)
+			}
+		}
+		if err == nil {
+			return
+		}
+		fmt.Fprintf(w, " ---> Restoring routes after failed traffic shift: %s\n", err)
+		for _, r := range routers {
+			if added {
+				if errRm := r.RemoveRoutes(appName, newRoutes); errRm != nil {
+					log.Errorf("[shift-routes] error removing routes from new units of %s: %s", appName, errRm)
+				}
+			}
+			if removed {
+				if errAdd := r.AddRoutes(appName, oldRoutes); errAdd != nil {
+					log.Errorf("[shift-routes] error adding back routes to old units of %s: %s", appName, errAdd)
+				}
+			}
+		}
+	}()
+	for _, r := range routers {
+		err = r.AddBackend(canaryName)
+		if err == router.ErrBackendExists {
+			err = r.RemoveBackend(canaryName)
+			if err == nil {
+				err = r.AddBackend(canaryName)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		err = r.AddRoutes(canaryName, newRoutes)
+		if err != nil {
+			return err
+		}
+	}
+	steps := s.Steps()
+	for i, pct := range steps {
+		if i > 0 && s.Pause > 0 {
+			fmt.Fprintf(w, " ---> Waiting %s before next step\n", s.Pause)
+			time.Sleep(s.Pause)
+		}
+		if err = checkDeployCanceled(evt); err != nil {
+			return err
+		}
+		if pct < 100 {
+			weights := []router.BackendWeight{
+				{Backend: appName, Weight: 100 - pct},
+				{Backend: canaryName, Weight: pct},
+			}
+			for _, r := range routers {
+				for _, cname := range cnames[r] {
+					err = r.(router.WeightedRouter).SetCNameWeights(appName, cname, weights)
+					if err != nil {
+						return err
+					}
+				}
+			}
+			fmt.Fprintf(w, " ---> Step %d/%d: %d%% of cnames traffic on new units\n", i+1, len(steps), pct)
+			continue
+		}
+		added = true
+		for _, r := range routers {
+			err = r.AddRoutes(appName, newRoutes)
+			if err != nil {
+				return err
+			}
+		}
+		removed = true
+		for _, r := range routers {
+			err = r.RemoveRoutes(appName, oldRoutes)
+			if err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(w, " ---> Step %d/%d: %d%% of traffic on new units (%d new routes, %d old routes)\n", i+1, len(steps), pct, len(newRoutes), 0)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provision
+
+import (
+	"bytes"
+	"net/url"
+	"time"
+
+	"github.com/tsuru/tsuru/router"
+	"github.com/tsuru/tsuru/router/routertest"
+	"gopkg.in/check.v1"
+)
+
+func (ProvisionSuite) TestDeployStrategyValidate(c *check.C) {
+	tests := []struct {
+		strategy DeployStrategy
+		err      string
+	}{
+		{strategy: DeployStrategy{}},
+		{strategy: DeployStrategy{Type: StrategyRolling}},
+		{strategy: DeployStrategy{Type: StrategyBlueGreen, Pause: time.Minute}},
+		{strategy: DeployStrategy{Type: StrategyCanary, StepPercent: 10}},
+		{strategy: DeployStrategy{Type: StrategyCanary}, err: "canary step percentage must be between 1 and 100"},
+		{strategy: DeployStrategy{Type: StrategyCanary, StepPercent: 101}, err: "canary step percentage must be between 1 and 100"},
+		{strategy: DeployStrategy{Type: StrategyCanary, StepPercent: 10, Pause: -time.Second}, err: "deploy strategy pause cannot be negative"},
+		{strategy: DeployStrategy{Type: "big-bang"}, err: `invalid deploy strategy "big-bang".*`},
+	}
+	for _, tt := range tests {
+		err := tt.strategy.Validate()
+		if tt.err == "" {
+			c.Check(err, check.IsNil)
+		} else {
+			c.Check(err, check.ErrorMatches, tt.err)
+		}
+	}
+}
+
+func (ProvisionSuite) TestDeployStrategySteps(c *check.C) {
+	c.Assert(DeployStrategy{}.Steps(), check.DeepEquals, []int{100})
+	c.Assert(DeployStrategy{Type: StrategyBlueGreen}.Steps(), check.DeepEquals, []int{100})
+	c.Assert(DeployStrategy{Type: StrategyCanary, StepPercent: 30}.Steps(), check.DeepEquals, []int{30, 60, 90, 100})
+	c.Assert(DeployStrategy{Type: StrategyCanary, StepPercent: 50}.Steps(), check.DeepEquals, []int{50, 100})
+	c.Assert(DeployStrategy{Type: StrategyCanary, StepPercent: 100}.Steps(), check.DeepEquals, []int{100})
+}
+
+func (ProvisionSuite) TestDeployStrategyShiftsRoutes(c *check.C) {
+	c.Assert(DeployStrategy{}.ShiftsRoutes(), check.Equals, false)
+	c.Assert(DeployStrategy{Type: StrategyRolling}.ShiftsRoutes(), check.Equals, false)
+	c.Assert(DeployStrategy{Type: StrategyBlueGreen}.ShiftsRoutes(), check.Equals, true)
+	c.Assert(DeployStrategy{Type: StrategyCanary, StepPercent: 10}.ShiftsRoutes(), check.Equals, true)
+}
+
+func (ProvisionSuite) TestRouteSteps(c *check.C) {
+	steps, err := routeSteps([]int{25, 50, 75, 100}, 4, 4)
+	c.Assert(err, check.IsNil)
+	c.Assert(steps, check.DeepEquals, []routeStep{
+		{percent: 25, added: 1, kept: 3},
+		{percent: 50, added: 2, kept: 2},
+		{percent: 75, added: 3, kept: 1},
+		{percent: 100, added: 4, kept: 0},
+	})
+	steps, err = routeSteps([]int{50, 100}, 1, 1)
+	c.Assert(err, check.IsNil)
+	c.Assert(steps, check.DeepEquals, []routeStep{
+		{percent: 50, added: 1, kept: 1},
+		{percent: 100, added: 1, kept: 0},
+	})
+	steps, err = routeSteps([]int{30, 60, 90, 100}, 3, 0)
+	c.Assert(err, check.IsNil)
+	c.Assert(steps[3], check.DeepEquals, routeStep{percent: 100, added: 3, kept: 0})
+	_, err = routeSteps([]int{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}, 4, 4)
+	c.Assert(err, check.ErrorMatches, `canary step of 10% can't be represented by the routes of 4 new and 4 old units, it would send 20% of the traffic to new units; use a larger step or a router supporting weights`)
+}
+
+func (s *S) TestShiftRoutesRejectsUnrepresentableSteps(c *check.C) {
+	routertest.FakeRouter.Reset()
+	err := routertest.FakeRouter.AddBackend("myapp")
+	c.Assert(err, check.IsNil)
+	oldRoutes := []*url.URL{{Scheme: "http", Host: "10.0.0.1:80"}, {Scheme: "http", Host: "10.0.0.2:80"}}
+	newRoutes := []*url.URL{{Scheme: "http", Host: "10.0.0.3:80"}, {Scheme: "http", Host: "10.0.0.4:80"}}
+	err = routertest.FakeRouter.AddRoutes("myapp", oldRoutes)
+	c.Assert(err, check.IsNil)
+	var buf bytes.Buffer
+	strategy := DeployStrategy{Type: StrategyCanary, StepPercent: 10}
+	err = strategy.ShiftRoutes([]router.Router{&routertest.FakeRouter}, "myapp", oldRoutes, newRoutes, &buf, nil)
+	c.Assert(err, check.ErrorMatches, `canary step of 10% can't be represented .*`)
+	c.Assert(buf.String(), check.Matches, `(?s).*---> canary step of 10% can't be represented .*`)
+	routes, err := routertest.FakeRouter.Routes("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(routes, check.DeepEquals, oldRoutes)
+}
+
+func (s *S) TestShiftRoutesWeightedCNames(c *check.C) {
+	routertest.FakeRouter.Reset()
+	err := routertest.FakeRouter.AddBackend("myapp")
+	c.Assert(err, check.IsNil)
+	err = routertest.FakeRouter.SetCName("myapp.example.com", "myapp")
+	c.Assert(err, check.IsNil)
+	oldRoutes := []*url.URL{{Scheme: "http", Host: "10.0.0.1:80"}, {Scheme: "http", Host: "10.0.0.2:80"}}
+	newRoutes := []*url.URL{{Scheme: "http", Host: "10.0.0.3:80"}, {Scheme: "http", Host: "10.0.0.4:80"}}
+	err = routertest.FakeRouter.AddRoutes("myapp", oldRoutes)
+	c.Assert(err, check.IsNil)
+	var buf bytes.Buffer
+	strategy := DeployStrategy{Type: StrategyCanary, StepPercent: 10}
+	err = strategy.ShiftRoutes([]router.Router{&routertest.FakeRouter}, "myapp", oldRoutes, newRoutes, &buf, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Matches, `(?s).*Step 1/10: 10% of cnames traffic on new units.*Step 9/10: 90% of cnames traffic on new units.*Step 10/10: 100% of traffic on new units \(2 new routes, 0 old routes\).*`)
+	routes, err := routertest.FakeRouter.Routes("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(routes, check.DeepEquals, newRoutes)
+	c.Assert(routertest.FakeRouter.HasBackend("myapp"+canaryBackendSuffix), check.Equals, false)
+	weights, err := routertest.FakeRouter.CNamesWeights("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(weights, check.DeepEquals, map[string][]router.BackendWeight{})
+}
+
+func (ProvisionSuite) TestDeployStrategyFromEventNil(c *check.C) {
+	c.Assert(DeployStrategyFromEvent(nil), check.DeepEquals, DeployStrategy{})
+}
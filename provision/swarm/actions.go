@@ -5,12 +5,18 @@
 package swarm
 
 import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
 	"sort"
 
+	"github.com/docker/docker/api/types/swarm"
 	"github.com/fsouza/go-dockerclient"
 	"github.com/pkg/errors"
 	"github.com/tsuru/tsuru/action"
 	"github.com/tsuru/tsuru/app/image"
+	"github.com/tsuru/tsuru/event"
 	"github.com/tsuru/tsuru/log"
 	"github.com/tsuru/tsuru/provision"
 	"github.com/tsuru/tsuru/set"
@@ -20,6 +26,7 @@ type processState struct {
 	stop      bool
 	start     bool
 	restart   bool
+	sleep     bool
 	increment int
 }
 
@@ -32,13 +39,18 @@ type pipelineArgs struct {
 	newImageSpec     processSpec
 	currentImage     string
 	currentImageSpec processSpec
+	event            *event.Event
+	strategy         provision.DeployStrategy
 }
 
 func rollbackAddedProcesses(args *pipelineArgs, processes []string) {
 	for _, processName := range processes {
 		var err error
 		if count, in := args.currentImageSpec[processName]; in {
-			err = deploy(args.client, args.app, processName, count, args.currentImage)
+			err = rollbackService(args.client, args.app, processName)
+			if err == errNoPreviousSpec {
+				err = deploy(args.client, args.app, processName, count, args.currentImage)
+			}
 		} else {
 			err = removeService(args.client, args.app, processName)
 		}
@@ -61,8 +73,17 @@ var updateServices = &action.Action{
 			toDeployProcesses = append(toDeployProcesses, processName)
 		}
 		sort.Strings(toDeployProcesses)
+		webProcessName, err := image.GetImageWebProcessName(args.newImage)
+		if err != nil {
+			log.Errorf("[WARNING] cannot get the name of the web process: %s", err)
+		}
 		for _, processName := range toDeployProcesses {
-			err = deploy(args.client, args.app, processName, args.newImageSpec[processName], args.newImage)
+			_, isUpdate := args.currentImageSpec[processName]
+			if isUpdate && processName == webProcessName && args.strategy.ShiftsRoutes() {
+				err = deployStaged(args, processName)
+			} else {
+				err = deploy(args.client, args.app, processName, args.newImageSpec[processName], args.newImage)
+			}
 			if err != nil {
 				break
 			}
@@ -81,6 +102,103 @@ var updateServices = &action.Action{
 	},
 }
 
+func urlPointers(urls []url.URL) []*url.URL {
+	result := make([]*url.URL, len(urls))
+	for i := range urls {
+		result[i] = &urls[i]
+	}
+	return result
+}
+
+// deployStaged updates the service of a routable process using a staging
+// service running the new image. The traffic is shifted to the staging
+// service following the deploy strategy, the process service is then updated
+// and the traffic is moved back to it before the staging service is removed.
+func deployStaged(args *pipelineArgs, process string) error {
+	a := args.app
+	routers, err := getRoutersForApp(a)
+	if err != nil {
+		return err
+	}
+	srvName := serviceNameForApp(a, process)
+	srv, err := args.client.InspectService(srvName)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	oldAddrs, err := serviceAddresses(args.client, a, srvName)
+	if err != nil {
+		return err
+	}
+	pState := args.newImageSpec[process]
+	if len(oldAddrs) == 0 {
+		return deploy(args.client, a, process, pState, args.newImage)
+	}
+	w := io.Writer(ioutil.Discard)
+	if args.event != nil {
+		w = args.event
+	}
+	spec, err := serviceSpecForApp(tsuruServiceOpts{
+		app:          a,
+		process:      process,
+		image:        args.newImage,
+		baseSpec:     &srv.Spec,
+		processState: pState,
+		isStaging:    true,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\n---- Starting staging service %q ----\n", spec.Name)
+	stagingSrv, err := args.client.CreateService(docker.CreateServiceOptions{
+		ServiceSpec: *spec,
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer removeServiceAndLog(args.client, stagingSrv.ID)
+	_, err = waitForTasks(args.client, stagingSrv.ID, swarm.TaskStateRunning)
+	if err != nil {
+		return err
+	}
+	stagingAddrs, err := serviceAddresses(args.client, a, spec.Name)
+	if err != nil {
+		return err
+	}
+	newRoutes := urlPointers(stagingAddrs)
+	err = args.strategy.ShiftRoutes(routers, a.GetName(), urlPointers(oldAddrs), newRoutes, w, args.event)
+	if err != nil {
+		return err
+	}
+	err = deploy(args.client, a, process, pState, args.newImage)
+	if err != nil {
+		for _, r := range routers {
+			if errAdd := r.AddRoutes(a.GetName(), urlPointers(oldAddrs)); errAdd != nil {
+				log.Errorf("error adding back routes to service %q: %s", srvName, errAdd)
+			}
+			if errRm := r.RemoveRoutes(a.GetName(), newRoutes); errRm != nil {
+				log.Errorf("error removing routes from staging service %q: %s", spec.Name, errRm)
+			}
+		}
+		return err
+	}
+	fmt.Fprintf(w, "\n---- Moving traffic back to service %q ----\n", srvName)
+	addrs, err := serviceAddresses(args.client, a, srvName)
+	if err != nil {
+		return err
+	}
+	for _, r := range routers {
+		err = r.AddRoutes(a.GetName(), urlPointers(addrs))
+		if err != nil {
+			return err
+		}
+		err = r.RemoveRoutes(a.GetName(), newRoutes)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 var updateImageInDB = &action.Action{
 	Name: "update-image-in-db",
 	Forward: func(ctx action.FWContext) (action.Result, error) {
@@ -89,10 +207,39 @@ var updateImageInDB = &action.Action{
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
+		cleanImageHistory(args.client, args.app.GetName())
 		return ctx.Previous, nil
 	},
 }
 
+// cleanImageHistory removes from the nodes and from the database the app
+// images exceeding the configured image history size, these images are not
+// valid targets for rollbacks anymore.
+func cleanImageHistory(client *docker.Client, appName string) {
+	allImages, err := image.ListAppImages(appName)
+	if err != nil {
+		log.Errorf("Couldn't list images for cleaning: %s", err)
+		return
+	}
+	historySize := image.ImageHistorySize()
+	if len(allImages) <= historySize {
+		return
+	}
+	var toRemove []string
+	for _, imgName := range allImages[:len(allImages)-historySize] {
+		if removeImageFromNodes(client, imgName) {
+			toRemove = append(toRemove, imgName)
+		}
+	}
+	if len(toRemove) == 0 {
+		return
+	}
+	err = image.PullAppImageNames(appName, toRemove)
+	if err != nil {
+		log.Errorf("Ignored error pulling old images from database: %s", err)
+	}
+}
+
 var removeOldServices = &action.Action{
 	Name: "remove-old-services",
 	Forward: func(ctx action.FWContext) (action.Result, error) {
@@ -5,12 +5,15 @@
 package swarm
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 
 	"github.com/docker/docker/api/types/swarm"
 	"github.com/fsouza/go-dockerclient"
 	"github.com/fsouza/go-dockerclient/testing"
+	"github.com/tsuru/config"
 	"github.com/tsuru/tsuru/action"
 	"github.com/tsuru/tsuru/app"
 	"github.com/tsuru/tsuru/app/image"
@@ -357,3 +360,92 @@ func (s *S) TestActionUpdateServicesBackwardNotInCurrent(c *check.C) {
 	_, err = cli.InspectService("myapp-web")
 	c.Assert(err, check.ErrorMatches, "No such service.*")
 }
+
+func (s *S) TestActionUpdateServicesBackwardUsesPreviousSpec(c *check.C) {
+	srv, err := testing.NewServer("127.0.0.1:0", nil, nil)
+	c.Assert(err, check.IsNil)
+	defer srv.Stop()
+	opts := provision.AddNodeOptions{Address: srv.URL()}
+	err = s.p.AddNode(opts)
+	c.Assert(err, check.IsNil)
+	cli, err := chooseDBSwarmNode()
+	c.Assert(err, check.IsNil)
+	a := &app.App{Name: "myapp", Platform: "whitespace", TeamOwner: s.team.Name}
+	err = app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	_, err = cli.CreateService(docker.CreateServiceOptions{
+		ServiceSpec: swarm.ServiceSpec{
+			TaskTemplate: swarm.TaskSpec{
+				ContainerSpec: swarm.ContainerSpec{
+					Command: []string{"new-web"},
+				},
+			},
+			Annotations: swarm.Annotations{
+				Name: "myapp-web",
+			},
+		},
+	})
+	c.Assert(err, check.IsNil)
+	srv.CustomHandler("/services/myapp-web$", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := httptest.NewRecorder()
+		srv.DefaultHandler().ServeHTTP(recorder, r)
+		var service swarm.Service
+		json.Unmarshal(recorder.Body.Bytes(), &service)
+		service.PreviousSpec = &swarm.ServiceSpec{
+			TaskTemplate: swarm.TaskSpec{
+				ContainerSpec: swarm.ContainerSpec{
+					Command: []string{"previous-web"},
+				},
+			},
+			Annotations: swarm.Annotations{
+				Name: "myapp-web",
+			},
+		}
+		json.NewEncoder(w).Encode(service)
+	}))
+	spec := processSpec{"web": processState{}}
+	args := &pipelineArgs{
+		client:           cli,
+		app:              a,
+		currentImage:     "app:v1",
+		newImageSpec:     spec,
+		currentImageSpec: spec,
+	}
+	updateServices.Backward(action.BWContext{
+		FWResult: []string{"web"},
+		Params:   []interface{}{args},
+	})
+	service, err := cli.InspectService("myapp-web")
+	c.Assert(err, check.IsNil)
+	c.Assert(service.Spec.TaskTemplate.ContainerSpec.Command, check.DeepEquals, []string{"previous-web"})
+}
+
+func (s *S) TestActionUpdateImageInDBCleansHistory(c *check.C) {
+	config.Set("docker:image-history-size", 2)
+	defer config.Unset("docker:image-history-size")
+	srv, err := testing.NewServer("127.0.0.1:0", nil, nil)
+	c.Assert(err, check.IsNil)
+	defer srv.Stop()
+	opts := provision.AddNodeOptions{Address: srv.URL()}
+	err = s.p.AddNode(opts)
+	c.Assert(err, check.IsNil)
+	cli, err := chooseDBSwarmNode()
+	c.Assert(err, check.IsNil)
+	a := &app.App{Name: "myapp", Platform: "whitespace", TeamOwner: s.team.Name}
+	err = app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	for _, imgName := range []string{"app:v1", "app:v2", "app:v3"} {
+		err = image.AppendAppImageName(a.GetName(), imgName)
+		c.Assert(err, check.IsNil)
+	}
+	args := &pipelineArgs{
+		client:   cli,
+		app:      a,
+		newImage: "app:v4",
+	}
+	_, err = updateImageInDB.Forward(action.FWContext{Params: []interface{}{args}})
+	c.Assert(err, check.IsNil)
+	imgs, err := image.ListAppImages(a.GetName())
+	c.Assert(err, check.IsNil)
+	c.Assert(imgs, check.DeepEquals, []string{"app:v3", "app:v4"})
+}
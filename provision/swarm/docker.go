@@ -51,6 +51,7 @@ var (
 	labelAppName            = tsuruLabel("tsuru.app.name")
 	labelAppProcess         = tsuruLabel("tsuru.app.process")
 	labelProcessReplicas    = tsuruLabel("tsuru.app.process.replicas")
+	labelProcessAsleep      = tsuruLabel("tsuru.app.process.asleep")
 	labelAppPlatform        = tsuruLabel("tsuru.app.platform")
 	labelRouterName         = tsuruLabel("tsuru.router.name")
 	labelRouterType         = tsuruLabel("tsuru.router.type")
@@ -253,6 +254,95 @@ func waitForTasks(client *docker.Client, serviceID string, wantedState swarm.Tas
 	}
 }
 
+const (
+	// Values introduced in newer docker API versions, not available in the
+	// vendored swarm types.
+	updateFailureActionRollback                    = "rollback"
+	updateStateRollbackStarted   swarm.UpdateState = "rollback_started"
+	updateStateRollbackPaused    swarm.UpdateState = "rollback_paused"
+	updateStateRollbackCompleted swarm.UpdateState = "rollback_completed"
+)
+
+var (
+	waitForServiceUpdateTimeout  = 10 * time.Minute
+	waitForServiceUpdateInterval = time.Second
+)
+
+// waitForServiceUpdate waits until the last update on the service is
+// finished. An error is returned if swarm paused the update or rolled the
+// service back to its previous spec.
+func waitForServiceUpdate(client *docker.Client, serviceID string) error {
+	timeout := time.After(waitForServiceUpdateTimeout)
+	for {
+		srv, err := client.InspectService(serviceID)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		switch srv.UpdateStatus.State {
+		case swarm.UpdateStateUpdating, updateStateRollbackStarted:
+		case swarm.UpdateStatePaused, updateStateRollbackPaused, updateStateRollbackCompleted:
+			return errors.Errorf("update for service %q failed, state: %q, msg: %q", serviceID, srv.UpdateStatus.State, srv.UpdateStatus.Message)
+		default:
+			return nil
+		}
+		select {
+		case <-timeout:
+			return errors.Errorf("timeout waiting for update on service %q to complete", serviceID)
+		case <-time.After(waitForServiceUpdateInterval):
+		}
+	}
+}
+
+var errNoPreviousSpec = errors.New("no previous spec available")
+
+// rollbackService uses the previous spec stored by swarm to undo the last
+// update on the service, the same as a service update rollback.
+func rollbackService(client *docker.Client, a provision.App, process string) error {
+	srv, err := client.InspectService(serviceNameForApp(a, process))
+	if err != nil {
+		if _, isNotFound := err.(*docker.NoSuchService); isNotFound {
+			return errNoPreviousSpec
+		}
+		return errors.WithStack(err)
+	}
+	if srv.PreviousSpec == nil {
+		return errNoPreviousSpec
+	}
+	err = client.UpdateService(srv.ID, docker.UpdateServiceOptions{
+		Version:     srv.Version.Index,
+		ServiceSpec: *srv.PreviousSpec,
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// removeImageFromNodes removes an image from every node in the cluster. It
+// returns true if no node has the image anymore.
+func removeImageFromNodes(client *docker.Client, img string) bool {
+	nodes, err := listValidNodes(client)
+	if err != nil {
+		log.Errorf("Ignored error listing nodes to remove image %q: %+v", img, err)
+		return false
+	}
+	removed := true
+	for _, n := range nodes {
+		nodeClient, err := newClient(n.Spec.Annotations.Labels[labelNodeDockerAddr.String()])
+		if err != nil {
+			log.Errorf("Ignored error creating client to remove image %q: %+v", img, err)
+			removed = false
+			continue
+		}
+		err = nodeClient.RemoveImage(img)
+		if err != nil && err != docker.ErrNoSuchImage {
+			log.Errorf("Ignored error removing old image %q: %s", img, err)
+			removed = false
+		}
+	}
+	return removed
+}
+
 func commitPushBuildImage(client *docker.Client, img, contID string, app provision.App) (string, error) {
 	parts := strings.Split(img, ":")
 	repository := strings.Join(parts[:len(parts)-1], ":")
@@ -302,6 +392,25 @@ func serviceNameForApp(a provision.App, process string) string {
 	return fmt.Sprintf("%s-%s", a.GetName(), process)
 }
 
+func getRoutersForApp(a provision.App) ([]router.Router, error) {
+	appRouters, err := a.GetRouters()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	routers := make([]router.Router, len(appRouters))
+	for i, appRouter := range appRouters {
+		routers[i], err = router.Get(appRouter.Name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return routers, nil
+}
+
+func stagingServiceName(srvName string) string {
+	return fmt.Sprintf("%s-staging", srvName)
+}
+
 func networkNameForApp(a provision.App) string {
 	return fmt.Sprintf("app-%s-overlay", a.GetName())
 }
@@ -314,6 +423,7 @@ type tsuruServiceOpts struct {
 	baseSpec      *swarm.ServiceSpec
 	isDeploy      bool
 	isIsolatedRun bool
+	isStaging     bool
 	processState  processState
 	constraints   []string
 }
@@ -342,6 +452,7 @@ func serviceSpecForApp(opts tsuruServiceOpts) (*swarm.ServiceSpec, error) {
 	var endpointSpec *swarm.EndpointSpec
 	var networks []swarm.NetworkAttachmentConfig
 	var healthConfig *container.HealthConfig
+	var updateConfig *swarm.UpdateConfig
 	port := dockercommon.WebProcessDefaultPort()
 	portInt, _ := strconv.Atoi(port)
 	if !opts.isDeploy && !opts.isIsolatedRun {
@@ -369,6 +480,10 @@ func serviceSpecForApp(opts tsuruServiceOpts) (*swarm.ServiceSpec, error) {
 			return nil, errors.WithStack(err)
 		}
 		healthConfig = toHealthConfig(yamlData.Healthcheck, portInt)
+		updateConfig = &swarm.UpdateConfig{
+			Parallelism:   1,
+			FailureAction: updateFailureActionRollback,
+		}
 	}
 	restartCount := 0
 	replicas := 0
@@ -404,8 +519,11 @@ func serviceSpecForApp(opts tsuruServiceOpts) (*swarm.ServiceSpec, error) {
 		replicas = 1
 		srvName = fmt.Sprintf("%sisolated-run", srvName)
 	}
+	if opts.isStaging {
+		srvName = stagingServiceName(srvName)
+	}
 	uReplicas := uint64(replicas)
-	if opts.processState.stop {
+	if opts.processState.stop || opts.processState.sleep {
 		uReplicas = 0
 	}
 	if opts.processState.restart {
@@ -422,6 +540,7 @@ func serviceSpecForApp(opts tsuruServiceOpts) (*swarm.ServiceSpec, error) {
 		labelRouterName.String():         routerName,
 		labelRouterType.String():         routerType,
 		labelProcessReplicas.String():    strconv.Itoa(replicas),
+		labelProcessAsleep.String():      strconv.FormatBool(opts.processState.sleep),
 		labelServiceRestart.String():     strconv.Itoa(restartCount),
 		labelPoolName.String():           opts.app.GetPool(),
 		labelProvisionerName.String():    "swarm",
@@ -451,6 +570,7 @@ func serviceSpecForApp(opts tsuruServiceOpts) (*swarm.ServiceSpec, error) {
 		},
 		Networks:     networks,
 		EndpointSpec: endpointSpec,
+		UpdateConfig: updateConfig,
 		Annotations: swarm.Annotations{
 			Name:   srvName,
 			Labels: labels,
@@ -461,6 +581,11 @@ func serviceSpecForApp(opts tsuruServiceOpts) (*swarm.ServiceSpec, error) {
 			},
 		},
 	}
+	if memory := opts.app.GetMemory(); memory > 0 {
+		spec.TaskTemplate.Resources = &swarm.ResourceRequirements{
+			Limits: &swarm.Resources{MemoryBytes: memory},
+		}
+	}
 	return &spec, nil
 }
 
@@ -222,6 +222,44 @@ func (s *S) TestListValidNodesNoneValid(c *check.C) {
 	c.Assert(nodes, check.DeepEquals, []swarm.Node{})
 }
 
+func (s *S) TestWaitForServiceUpdate(c *check.C) {
+	srv, err := testing.NewServer("127.0.0.1:0", nil, nil)
+	c.Assert(err, check.IsNil)
+	defer srv.Stop()
+	var calls int
+	srv.CustomHandler("/services/mysrv", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		state := swarm.UpdateStateUpdating
+		if calls > 1 {
+			state = swarm.UpdateStateCompleted
+		}
+		json.NewEncoder(w).Encode(swarm.Service{ID: "mysrv", UpdateStatus: swarm.UpdateStatus{State: state}})
+	}))
+	waitForServiceUpdateInterval = time.Millisecond
+	defer func() { waitForServiceUpdateInterval = time.Second }()
+	cli, err := newClient(srv.URL())
+	c.Assert(err, check.IsNil)
+	err = waitForServiceUpdate(cli, "mysrv")
+	c.Assert(err, check.IsNil)
+	c.Assert(calls, check.Equals, 2)
+}
+
+func (s *S) TestWaitForServiceUpdateRolledBack(c *check.C) {
+	srv, err := testing.NewServer("127.0.0.1:0", nil, nil)
+	c.Assert(err, check.IsNil)
+	defer srv.Stop()
+	srv.CustomHandler("/services/mysrv", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(swarm.Service{ID: "mysrv", UpdateStatus: swarm.UpdateStatus{
+			State:   updateStateRollbackCompleted,
+			Message: "update rolled back due to failure",
+		}})
+	}))
+	cli, err := newClient(srv.URL())
+	c.Assert(err, check.IsNil)
+	err = waitForServiceUpdate(cli, "mysrv")
+	c.Assert(err, check.ErrorMatches, `update for service "mysrv" failed, state: "rollback_completed", msg: "update rolled back due to failure"`)
+}
+
 func (s *S) TestServiceSpecForNodeContainer(c *check.C) {
 	c1 := nodecontainer.NodeContainerConfig{
 		Name: "swarmbs",
@@ -130,7 +130,7 @@ func changeUnits(a provision.App, units int, processName string, w io.Writer) er
 			return errors.WithStack(err)
 		}
 	}
-	return deployProcesses(client, a, imageId, processSpec{processName: processState{increment: units}})
+	return deployProcesses(client, a, imageId, processSpec{processName: processState{increment: units}}, nil)
 }
 
 func (p *swarmProvisioner) AddUnits(a provision.App, units uint, processName string, w io.Writer) error {
@@ -163,7 +163,7 @@ func changeAppState(a provision.App, process string, state processState) error {
 	for _, procName := range processes {
 		spec[procName] = state
 	}
-	return deployProcesses(client, a, imgID, spec)
+	return deployProcesses(client, a, imgID, spec, nil)
 }
 
 func (p *swarmProvisioner) Restart(a provision.App, process string, w io.Writer) error {
@@ -178,6 +178,13 @@ func (p *swarmProvisioner) Stop(a provision.App, process string) error {
 	return changeAppState(a, process, processState{stop: true})
 }
 
+// Sleep scales the services for the app processes down to zero replicas. The
+// replica count for each process is kept in the service labels, so that it's
+// restored by a later Start or AddUnits call.
+func (p *swarmProvisioner) Sleep(a provision.App, process string) error {
+	return changeAppState(a, process, processState{sleep: true})
+}
+
 func allAppProcesses(appName string) ([]string, error) {
 	var processes []string
 	imgID, err := image.AppCurrentImageName(appName)
@@ -302,7 +309,12 @@ func (p *swarmProvisioner) RoutableAddresses(a provision.App) ([]url.URL, error)
 	if webProcessName == "" {
 		return nil, nil
 	}
-	srvName := serviceNameForApp(a, webProcessName)
+	return serviceAddresses(client, a, serviceNameForApp(a, webProcessName))
+}
+
+// serviceAddresses returns the addresses of the published port of the
+// service in each node of the app pool.
+func serviceAddresses(client *docker.Client, a provision.App, srvName string) ([]url.URL, error) {
 	srv, err := client.InspectService(srvName)
 	if err != nil {
 		return nil, err
@@ -391,6 +403,119 @@ func (p *swarmProvisioner) RegisterUnit(a provision.App, unitId string, customDa
 	return image.SaveImageCustomData(buildingImage, customData)
 }
 
+// findTaskByUnit looks for the task running the unit, units may be identified
+// either by the task ID or by the ID or name of the task container.
+func findTaskByUnit(client *docker.Client, unit provision.Unit) (*swarm.Task, error) {
+	filters := map[string][]string{
+		"label": {fmt.Sprintf("%s=true", labelService)},
+	}
+	if unit.AppName != "" {
+		filters["label"] = append(filters["label"], fmt.Sprintf("%s=%s", labelAppName, unit.AppName))
+	}
+	tasks, err := client.ListTasks(docker.ListTasksOptions{Filters: filters})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for i, t := range tasks {
+		if unit.ID != "" && (t.ID == unit.ID || strings.HasPrefix(t.Status.ContainerStatus.ContainerID, unit.ID)) {
+			return &tasks[i], nil
+		}
+		if unit.Name != "" && strings.HasSuffix(unit.Name, "."+t.ID) {
+			return &tasks[i], nil
+		}
+	}
+	return nil, &provision.UnitNotFoundError{ID: unit.ID}
+}
+
+// SetUnitStatus checks that the unit reported by the agent belongs to a task
+// managed by tsuru. The unit status itself is always derived from the task
+// state in swarm, so nothing is stored.
+func (p *swarmProvisioner) SetUnitStatus(unit provision.Unit, status provision.Status) error {
+	client, err := chooseDBSwarmNode()
+	if err != nil {
+		return err
+	}
+	task, err := findTaskByUnit(client, unit)
+	if err != nil {
+		return err
+	}
+	if unit.AppName != "" && task.Spec.ContainerSpec.Labels[labelAppName.String()] != unit.AppName {
+		return errors.New("wrong app name")
+	}
+	return nil
+}
+
+func (p *swarmProvisioner) GetAppFromUnitID(unitID string) (provision.App, error) {
+	client, err := chooseDBSwarmNode()
+	if err != nil {
+		return nil, err
+	}
+	task, err := findTaskByUnit(client, provision.Unit{ID: unitID})
+	if err != nil {
+		return nil, err
+	}
+	a, err := app.GetByName(task.Spec.ContainerSpec.Labels[labelAppName.String()])
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return a, nil
+}
+
+func (p *swarmProvisioner) FilterAppsByUnitStatus(apps []provision.App, status []string) ([]provision.App, error) {
+	if apps == nil {
+		return nil, errors.Errorf("apps must be provided to FilterAppsByUnitStatus")
+	}
+	if status == nil {
+		return make([]provision.App, 0), nil
+	}
+	client, err := chooseDBSwarmNode()
+	if err != nil {
+		if errors.Cause(err) == errNoSwarmNode {
+			return make([]provision.App, 0), nil
+		}
+		return nil, err
+	}
+	tasks, err := client.ListTasks(docker.ListTasksOptions{
+		Filters: map[string][]string{
+			"label": {fmt.Sprintf("%s=true", labelService)},
+		},
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	wantedStatus := make(map[string]struct{}, len(status))
+	for _, s := range status {
+		wantedStatus[s] = struct{}{}
+	}
+	appsWithStatus := map[string]struct{}{}
+	for _, t := range tasks {
+		labels := t.Spec.ContainerSpec.Labels
+		if t.DesiredState == swarm.TaskStateShutdown ||
+			labels[labelServiceDeploy.String()] == "true" ||
+			labels[labelServiceIsolatedRun.String()] == "true" {
+			continue
+		}
+		if _, ok := wantedStatus[stateMap[t.Status.State].String()]; ok {
+			appsWithStatus[labels[labelAppName.String()]] = struct{}{}
+		}
+	}
+	result := make([]provision.App, 0)
+	for _, a := range apps {
+		if _, ok := appsWithStatus[a.GetName()]; ok {
+			result = append(result, a)
+		}
+	}
+	return result, nil
+}
+
+func (p *swarmProvisioner) MetricEnvs(a provision.App) map[string]string {
+	return nodecontainer.BsMetricEnvs(a.GetPool())
+}
+
+func (p *swarmProvisioner) LogsEnabled(a provision.App) (bool, string, error) {
+	return nodecontainer.BsLogsEnabled(a.GetPool())
+}
+
 func (p *swarmProvisioner) ListNodes(addressFilter []string) ([]provision.Node, error) {
 	client, err := chooseDBSwarmNode()
 	if err != nil {
@@ -619,7 +744,7 @@ func (p *swarmProvisioner) ArchiveDeploy(a provision.App, archiveURL string, evt
 	if err != nil {
 		return "", err
 	}
-	err = deployProcesses(client, a, buildingImage, nil)
+	err = deployProcesses(client, a, buildingImage, nil, evt)
 	if err != nil {
 		return "", errors.WithStack(err)
 	}
@@ -659,13 +784,40 @@ func (p *swarmProvisioner) ImageDeploy(a provision.App, imgID string, evt *event
 		return "", err
 	}
 	a.SetUpdatePlatform(true)
-	err = deployProcesses(client, a, newImage, nil)
+	err = deployProcesses(client, a, newImage, nil, evt)
 	if err != nil {
 		return "", err
 	}
 	return newImage, nil
 }
 
+func (p *swarmProvisioner) Rollback(a provision.App, imgID string, evt *event.Event) (string, error) {
+	validImgs, err := image.ListValidAppImages(a.GetName())
+	if err != nil {
+		return "", err
+	}
+	valid := false
+	for _, img := range validImgs {
+		if img == imgID {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return "", errors.Errorf("Image %q not found in app", imgID)
+	}
+	client, err := chooseDBSwarmNode()
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(evt, "---- Rolling back to image %q ----\n", imgID)
+	err = deployProcesses(client, a, imgID, nil, evt)
+	if err != nil {
+		return "", err
+	}
+	return imgID, nil
+}
+
 func (p *swarmProvisioner) UploadDeploy(a provision.App, archiveFile io.ReadCloser, fileSize int64, build bool, evt *event.Event) (string, error) {
 	defer archiveFile.Close()
 	if build {
@@ -737,7 +889,7 @@ func (p *swarmProvisioner) UploadDeploy(a provision.App, archiveFile io.ReadClos
 	if err != nil {
 		return "", err
 	}
-	err = deployProcesses(client, a, buildingImage, nil)
+	err = deployProcesses(client, a, buildingImage, nil, evt)
 	if err != nil {
 		return "", errors.WithStack(err)
 	}
@@ -991,7 +1143,7 @@ func (p *swarmProvisioner) StartupMessage() (string, error) {
 	return out, nil
 }
 
-func deployProcesses(client *docker.Client, a provision.App, newImg string, updateSpec processSpec) error {
+func deployProcesses(client *docker.Client, a provision.App, newImg string, updateSpec processSpec, evt *event.Event) error {
 	curImg, err := image.AppCurrentImageName(a.GetName())
 	if err != nil {
 		return err
@@ -1030,6 +1182,8 @@ func deployProcesses(client *docker.Client, a provision.App, newImg string, upda
 		newImageSpec:     newSpec,
 		currentImage:     curImg,
 		currentImageSpec: currentSpec,
+		event:            evt,
+		strategy:         provision.DeployStrategyFromEvent(evt),
 	})
 }
 
@@ -1080,6 +1234,7 @@ func deploy(client *docker.Client, a provision.App, process string, pState proce
 		if err != nil {
 			return errors.WithStack(err)
 		}
+		return waitForServiceUpdate(client, srv.ID)
 	}
 	return nil
 }
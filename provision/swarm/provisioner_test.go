@@ -31,6 +31,7 @@ import (
 	"github.com/tsuru/tsuru/provision"
 	"github.com/tsuru/tsuru/provision/nodecontainer"
 	"github.com/tsuru/tsuru/provision/provisiontest"
+	"github.com/tsuru/tsuru/router/routertest"
 	"github.com/tsuru/tsuru/safe"
 	"gopkg.in/check.v1"
 )
@@ -457,6 +458,85 @@ func (s *S) TestStopStartSingleProcess(c *check.C) {
 	c.Assert(procs, check.DeepEquals, []string{"web", "worker"})
 }
 
+func (s *S) TestSleepStart(c *check.C) {
+	srv, err := testing.NewServer("127.0.0.1:0", nil, nil)
+	c.Assert(err, check.IsNil)
+	defer srv.Stop()
+	opts := provision.AddNodeOptions{Address: srv.URL()}
+	err = s.p.AddNode(opts)
+	c.Assert(err, check.IsNil)
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name, Deploys: 1}
+	err = app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	imgName := "myapp:v1"
+	err = image.SaveImageCustomData(imgName, map[string]interface{}{
+		"processes": map[string]interface{}{
+			"web":    "python myapp.py",
+			"worker": "python myworker.py",
+		},
+	})
+	c.Assert(err, check.IsNil)
+	err = image.AppendAppImageName(a.GetName(), imgName)
+	c.Assert(err, check.IsNil)
+	err = s.p.AddUnits(a, 3, "web", nil)
+	c.Assert(err, check.IsNil)
+	err = s.p.AddUnits(a, 1, "worker", nil)
+	c.Assert(err, check.IsNil)
+	err = s.p.Sleep(a, "")
+	c.Assert(err, check.IsNil)
+	units, err := s.p.Units(a)
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 0)
+	cli, err := chooseDBSwarmNode()
+	c.Assert(err, check.IsNil)
+	service, err := cli.InspectService("myapp-web")
+	c.Assert(err, check.IsNil)
+	c.Assert(*service.Spec.Mode.Replicated.Replicas, check.Equals, uint64(0))
+	c.Assert(service.Spec.Labels[labelProcessAsleep.String()], check.Equals, "true")
+	c.Assert(service.Spec.Labels[labelProcessReplicas.String()], check.Equals, "3")
+	err = s.p.Start(a, "")
+	c.Assert(err, check.IsNil)
+	units, err = s.p.Units(a)
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 4)
+	service, err = cli.InspectService("myapp-web")
+	c.Assert(err, check.IsNil)
+	c.Assert(service.Spec.Labels[labelProcessAsleep.String()], check.Equals, "false")
+}
+
+func (s *S) TestSleepAddUnits(c *check.C) {
+	srv, err := testing.NewServer("127.0.0.1:0", nil, nil)
+	c.Assert(err, check.IsNil)
+	defer srv.Stop()
+	opts := provision.AddNodeOptions{Address: srv.URL()}
+	err = s.p.AddNode(opts)
+	c.Assert(err, check.IsNil)
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name, Deploys: 1}
+	err = app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	imgName := "myapp:v1"
+	err = image.SaveImageCustomData(imgName, map[string]interface{}{
+		"processes": map[string]interface{}{
+			"web": "python myapp.py",
+		},
+	})
+	c.Assert(err, check.IsNil)
+	err = image.AppendAppImageName(a.GetName(), imgName)
+	c.Assert(err, check.IsNil)
+	err = s.p.AddUnits(a, 2, "web", nil)
+	c.Assert(err, check.IsNil)
+	err = s.p.Sleep(a, "web")
+	c.Assert(err, check.IsNil)
+	units, err := s.p.Units(a)
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 0)
+	err = s.p.AddUnits(a, 1, "web", nil)
+	c.Assert(err, check.IsNil)
+	units, err = s.p.Units(a)
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 3)
+}
+
 func (s *S) TestUnits(c *check.C) {
 	srv, err := testing.NewServer("127.0.0.1:0", nil, nil)
 	c.Assert(err, check.IsNil)
@@ -551,6 +631,147 @@ func (s *S) TestUnitsWithoutSwarmNode(c *check.C) {
 	c.Assert(units, check.HasLen, 0)
 }
 
+func (s *S) TestSetUnitStatus(c *check.C) {
+	srv, err := testing.NewServer("127.0.0.1:0", nil, nil)
+	c.Assert(err, check.IsNil)
+	defer srv.Stop()
+	opts := provision.AddNodeOptions{Address: srv.URL()}
+	err = s.p.AddNode(opts)
+	c.Assert(err, check.IsNil)
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name, Deploys: 1}
+	err = app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	imgName := "myapp:v1"
+	err = image.SaveImageCustomData(imgName, map[string]interface{}{
+		"processes": map[string]interface{}{
+			"web": "python myapp.py",
+		},
+	})
+	c.Assert(err, check.IsNil)
+	err = image.AppendAppImageName(a.GetName(), imgName)
+	c.Assert(err, check.IsNil)
+	err = s.p.AddUnits(a, 1, "web", nil)
+	c.Assert(err, check.IsNil)
+	units, err := s.p.Units(a)
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 1)
+	err = s.p.SetUnitStatus(units[0], provision.StatusStarted)
+	c.Assert(err, check.IsNil)
+	cli, err := chooseDBSwarmNode()
+	c.Assert(err, check.IsNil)
+	tasks, err := cli.ListTasks(docker.ListTasksOptions{})
+	c.Assert(err, check.IsNil)
+	c.Assert(tasks, check.HasLen, 1)
+	err = s.p.SetUnitStatus(provision.Unit{ID: tasks[0].Status.ContainerStatus.ContainerID[:12]}, provision.StatusStarted)
+	c.Assert(err, check.IsNil)
+	err = s.p.SetUnitStatus(provision.Unit{ID: units[0].ID, AppName: "otherapp"}, provision.StatusStarted)
+	c.Assert(err, check.FitsTypeOf, &provision.UnitNotFoundError{})
+	err = s.p.SetUnitStatus(provision.Unit{ID: "notfound"}, provision.StatusStarted)
+	c.Assert(err, check.FitsTypeOf, &provision.UnitNotFoundError{})
+}
+
+func (s *S) TestGetAppFromUnitID(c *check.C) {
+	srv, err := testing.NewServer("127.0.0.1:0", nil, nil)
+	c.Assert(err, check.IsNil)
+	defer srv.Stop()
+	opts := provision.AddNodeOptions{Address: srv.URL()}
+	err = s.p.AddNode(opts)
+	c.Assert(err, check.IsNil)
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name, Deploys: 1}
+	err = app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	imgName := "myapp:v1"
+	err = image.SaveImageCustomData(imgName, map[string]interface{}{
+		"processes": map[string]interface{}{
+			"web": "python myapp.py",
+		},
+	})
+	c.Assert(err, check.IsNil)
+	err = image.AppendAppImageName(a.GetName(), imgName)
+	c.Assert(err, check.IsNil)
+	err = s.p.AddUnits(a, 1, "web", nil)
+	c.Assert(err, check.IsNil)
+	units, err := s.p.Units(a)
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 1)
+	foundApp, err := s.p.GetAppFromUnitID(units[0].ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(foundApp.GetName(), check.Equals, "myapp")
+	_, err = s.p.GetAppFromUnitID("notfound")
+	c.Assert(err, check.FitsTypeOf, &provision.UnitNotFoundError{})
+}
+
+func (s *S) TestFilterAppsByUnitStatus(c *check.C) {
+	srv, err := testing.NewServer("127.0.0.1:0", nil, nil)
+	c.Assert(err, check.IsNil)
+	defer srv.Stop()
+	opts := provision.AddNodeOptions{Address: srv.URL()}
+	err = s.p.AddNode(opts)
+	c.Assert(err, check.IsNil)
+	a1 := &app.App{Name: "myapp", TeamOwner: s.team.Name, Deploys: 1}
+	err = app.CreateApp(a1, s.user)
+	c.Assert(err, check.IsNil)
+	a2 := &app.App{Name: "otherapp", TeamOwner: s.team.Name, Deploys: 1}
+	err = app.CreateApp(a2, s.user)
+	c.Assert(err, check.IsNil)
+	imgName := "myapp:v1"
+	err = image.SaveImageCustomData(imgName, map[string]interface{}{
+		"processes": map[string]interface{}{
+			"web": "python myapp.py",
+		},
+	})
+	c.Assert(err, check.IsNil)
+	err = image.AppendAppImageName(a1.GetName(), imgName)
+	c.Assert(err, check.IsNil)
+	err = s.p.AddUnits(a1, 1, "web", nil)
+	c.Assert(err, check.IsNil)
+	apps, err := s.p.FilterAppsByUnitStatus([]provision.App{a1, a2}, []string{"starting"})
+	c.Assert(err, check.IsNil)
+	c.Assert(apps, check.DeepEquals, []provision.App{a1})
+	apps, err = s.p.FilterAppsByUnitStatus([]provision.App{a1, a2}, []string{"error"})
+	c.Assert(err, check.IsNil)
+	c.Assert(apps, check.HasLen, 0)
+	_, err = s.p.FilterAppsByUnitStatus(nil, []string{"starting"})
+	c.Assert(err, check.ErrorMatches, "apps must be provided to FilterAppsByUnitStatus")
+}
+
+func (s *S) TestMetricEnvs(c *check.C) {
+	err := nodecontainer.AddNewContainer("", &nodecontainer.NodeContainerConfig{
+		Name: nodecontainer.BsDefaultName,
+		Config: docker.Config{
+			Image: "img1",
+			Env: []string{
+				"OTHER_ENV=asd",
+				"METRICS_BACKEND=LOGSTASH",
+			},
+		},
+	})
+	c.Assert(err, check.IsNil)
+	a := &app.App{Name: "myapp", Pool: "mypool"}
+	envs := s.p.MetricEnvs(a)
+	c.Assert(envs, check.DeepEquals, map[string]string{"METRICS_BACKEND": "LOGSTASH"})
+}
+
+func (s *S) TestLogsEnabled(c *check.C) {
+	a := &app.App{Name: "myapp", Pool: "mypool"}
+	enabled, msg, err := s.p.LogsEnabled(a)
+	c.Assert(err, check.IsNil)
+	c.Assert(enabled, check.Equals, true)
+	c.Assert(msg, check.Equals, "")
+	err = nodecontainer.AddNewContainer("mypool", &nodecontainer.NodeContainerConfig{
+		Name: nodecontainer.BsDefaultName,
+		Config: docker.Config{
+			Image: "img1",
+			Env:   []string{"LOG_BACKENDS=abc", "LOG_ABC_DOC=doc"},
+		},
+	})
+	c.Assert(err, check.IsNil)
+	enabled, msg, err = s.p.LogsEnabled(a)
+	c.Assert(err, check.IsNil)
+	c.Assert(enabled, check.Equals, false)
+	c.Assert(msg, check.Equals, "Logs not available through tsuru. Enabled log backends are:\n* abc: doc")
+}
+
 func (s *S) TestRoutableUnits(c *check.C) {
 	srv, err := testing.NewServer("127.0.0.1:0", nil, nil)
 	c.Assert(err, check.IsNil)
@@ -1401,6 +1622,119 @@ func (s *S) TestImageDeploy(c *check.C) {
 	})
 }
 
+func (s *S) TestRollback(c *check.C) {
+	srv, err := testing.NewServer("127.0.0.1:0", nil, nil)
+	c.Assert(err, check.IsNil)
+	defer srv.Stop()
+	opts := provision.AddNodeOptions{Address: srv.URL()}
+	err = s.p.AddNode(opts)
+	c.Assert(err, check.IsNil)
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name, Deploys: 1}
+	err = app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	for _, imgName := range []string{"myapp:v1", "myapp:v2"} {
+		err = image.SaveImageCustomData(imgName, map[string]interface{}{
+			"processes": map[string]interface{}{
+				"web": "python " + imgName,
+			},
+		})
+		c.Assert(err, check.IsNil)
+	}
+	err = image.AppendAppImageName(a.GetName(), "myapp:v1")
+	c.Assert(err, check.IsNil)
+	cli, err := chooseDBSwarmNode()
+	c.Assert(err, check.IsNil)
+	err = deployProcesses(cli, a, "myapp:v2", nil, nil)
+	c.Assert(err, check.IsNil)
+	evt, err := event.New(&event.Opts{
+		Target:  event.Target{Type: event.TargetTypeApp, Value: a.GetName()},
+		Kind:    permission.PermAppDeploy,
+		Owner:   s.token,
+		Allowed: event.Allowed(permission.PermAppDeploy),
+	})
+	c.Assert(err, check.IsNil)
+	imgID, err := s.p.Rollback(a, "myapp:v1", evt)
+	c.Assert(err, check.IsNil)
+	c.Assert(imgID, check.Equals, "myapp:v1")
+	service, err := cli.InspectService("myapp-web")
+	c.Assert(err, check.IsNil)
+	c.Assert(service.Spec.TaskTemplate.ContainerSpec.Image, check.Equals, "myapp:v1")
+	c.Assert(service.Spec.UpdateConfig, check.DeepEquals, &swarm.UpdateConfig{
+		Parallelism:   1,
+		FailureAction: updateFailureActionRollback,
+	})
+	imgs, err := image.ListAppImages(a.GetName())
+	c.Assert(err, check.IsNil)
+	c.Assert(imgs, check.DeepEquals, []string{"myapp:v2", "myapp:v1"})
+}
+
+func (s *S) TestRollbackWithCanaryStrategy(c *check.C) {
+	srv, err := testing.NewServer("127.0.0.1:0", nil, nil)
+	c.Assert(err, check.IsNil)
+	defer srv.Stop()
+	opts := provision.AddNodeOptions{Address: srv.URL(), Metadata: map[string]string{"pool": "bonehunters"}}
+	err = s.p.AddNode(opts)
+	c.Assert(err, check.IsNil)
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name, Deploys: 1}
+	err = app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	for _, imgName := range []string{"myapp:v1", "myapp:v2"} {
+		err = image.SaveImageCustomData(imgName, map[string]interface{}{
+			"processes": map[string]interface{}{
+				"web": "python " + imgName,
+			},
+		})
+		c.Assert(err, check.IsNil)
+	}
+	err = image.AppendAppImageName(a.GetName(), "myapp:v1")
+	c.Assert(err, check.IsNil)
+	cli, err := chooseDBSwarmNode()
+	c.Assert(err, check.IsNil)
+	err = deployProcesses(cli, a, "myapp:v2", nil, nil)
+	c.Assert(err, check.IsNil)
+	oldAddrs, err := s.p.RoutableAddresses(a)
+	c.Assert(err, check.IsNil)
+	c.Assert(oldAddrs, check.HasLen, 1)
+	err = routertest.FakeRouter.AddRoutes(a.GetName(), urlPointers(oldAddrs))
+	c.Assert(err, check.IsNil)
+	evt, err := event.New(&event.Opts{
+		Target:  event.Target{Type: event.TargetTypeApp, Value: a.GetName()},
+		Kind:    permission.PermAppDeploy,
+		Owner:   s.token,
+		Allowed: event.Allowed(permission.PermAppDeploy),
+		CustomData: struct{ Strategy provision.DeployStrategy }{
+			Strategy: provision.DeployStrategy{Type: provision.StrategyCanary, StepPercent: 50},
+		},
+	})
+	c.Assert(err, check.IsNil)
+	var buf bytes.Buffer
+	evt.SetLogWriter(&buf)
+	imgID, err := s.p.Rollback(a, "myapp:v1", evt)
+	c.Assert(err, check.IsNil)
+	c.Assert(imgID, check.Equals, "myapp:v1")
+	service, err := cli.InspectService("myapp-web")
+	c.Assert(err, check.IsNil)
+	c.Assert(service.Spec.TaskTemplate.ContainerSpec.Image, check.Equals, "myapp:v1")
+	_, err = cli.InspectService("myapp-web-staging")
+	c.Assert(err, check.FitsTypeOf, &docker.NoSuchService{})
+	addrs, err := s.p.RoutableAddresses(a)
+	c.Assert(err, check.IsNil)
+	routes, err := routertest.FakeRouter.Routes(a.GetName())
+	c.Assert(err, check.IsNil)
+	c.Assert(routes, check.DeepEquals, urlPointers(addrs))
+	c.Assert(buf.String(), check.Matches, `(?s).*Starting staging service "myapp-web-staging".*Step 1/2: 50% of traffic on new units \(1 new routes, 1 old routes\).*Step 2/2: 100% of traffic on new units \(1 new routes, 0 old routes\).*Moving traffic back to service "myapp-web".*`)
+}
+
+func (s *S) TestRollbackInvalidImage(c *check.C) {
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name, Deploys: 1}
+	err := app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	err = image.AppendAppImageName(a.GetName(), "myapp:v1")
+	c.Assert(err, check.IsNil)
+	_, err = s.p.Rollback(a, "myapp:v9", nil)
+	c.Assert(err, check.ErrorMatches, `Image "myapp:v9" not found in app`)
+}
+
 func (s *S) TestDestroy(c *check.C) {
 	srv, err := testing.NewServer("127.0.0.1:0", nil, nil)
 	c.Assert(err, check.IsNil)
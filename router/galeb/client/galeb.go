@@ -209,6 +209,51 @@ func (c *GalebClient) UpdatePoolProperties(poolName string, properties BackendPo
 	return c.waitStatusOK(poolID)
 }
 
+func (c *GalebClient) UpdateVirtualHostCertificate(virtualHostName, certificate, key string) error {
+	virtualHostID, err := c.findItemByName("virtualhost", virtualHostName)
+	if err != nil {
+		return err
+	}
+	path := strings.TrimPrefix(virtualHostID, c.ApiUrl)
+	var params VirtualHost
+	c.fillDefaultVirtualHostValues(&params)
+	params.Name = virtualHostName
+	params.Properties = &VirtualHostProperties{Certificate: certificate, Key: key}
+	rsp, err := c.doRequest("PATCH", path, params)
+	if err != nil {
+		return err
+	}
+	if rsp.StatusCode != http.StatusNoContent {
+		responseData, _ := ioutil.ReadAll(rsp.Body)
+		rsp.Body.Close()
+		return errors.Errorf("PATCH %s: invalid response code: %d: %s", path, rsp.StatusCode, string(responseData))
+	}
+	return c.waitStatusOK(virtualHostID)
+}
+
+func (c *GalebClient) FindVirtualHost(virtualHostName string) (*VirtualHost, error) {
+	virtualHostID, err := c.findItemByName("virtualhost", virtualHostName)
+	if err != nil {
+		return nil, err
+	}
+	path := strings.TrimPrefix(virtualHostID, c.ApiUrl)
+	rsp, err := c.doRequest("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	responseData, _ := ioutil.ReadAll(rsp.Body)
+	if rsp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("GET %s: invalid response code: %d: %s", path, rsp.StatusCode, string(responseData))
+	}
+	var virtualHost VirtualHost
+	err = json.Unmarshal(responseData, &virtualHost)
+	if err != nil {
+		return nil, errors.Wrapf(err, "GET %s: unable to unmarshal response. data: %s", path, string(responseData))
+	}
+	return &virtualHost, nil
+}
+
 func (c *GalebClient) AddBackend(backend *url.URL, poolName string) (string, error) {
 	var params Target
 	c.fillDefaultTargetValues(&params)
@@ -186,6 +186,21 @@ func (s *S) TestGalebAddVirtualHost(c *check.C) {
 	c.Assert(parsedParams, check.DeepEquals, expected)
 }
 
+func (s *S) TestGalebFindVirtualHost(c *check.C) {
+	s.handler.ConditionalContent["/api/virtualhost/search/findByName?name=myvirtualhost.com"] = []string{
+		"200", fmt.Sprintf(`{"_embedded": {"virtualhost": [{"_links": {"self": {"href": "%s/virtualhost/999"}}}]}}`, s.client.ApiUrl),
+	}
+	s.handler.ConditionalContent["/api/virtualhost/999"] = []string{
+		"200", `{"name": "myvirtualhost.com", "properties": {"certificate": "cert-content"}}`,
+	}
+	vh, err := s.client.FindVirtualHost("myvirtualhost.com")
+	c.Assert(err, check.IsNil)
+	c.Assert(vh, check.DeepEquals, &VirtualHost{
+		commonPostResponse: commonPostResponse{Name: "myvirtualhost.com"},
+		Properties:         &VirtualHostProperties{Certificate: "cert-content"},
+	})
+}
+
 func (s *S) TestGalebAddRuleToID(c *check.C) {
 	s.handler.RspHeader.Set("Location", "http://galeb.somewhere/api/rule/8")
 	s.handler.RspCode = http.StatusCreated
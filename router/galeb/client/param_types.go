@@ -67,8 +67,16 @@ type Rule struct {
 	Properties  RuleProperties `json:"properties,omitempty"`
 }
 
+// VirtualHostProperties fields are always sent so that empty values clear a
+// previously set certificate.
+type VirtualHostProperties struct {
+	Certificate string `json:"certificate"`
+	Key         string `json:"key"`
+}
+
 type VirtualHost struct {
 	commonPostResponse
-	Environment string `json:"environment,omitempty"`
-	Project     string `json:"project,omitempty"`
+	Environment string                 `json:"environment,omitempty"`
+	Project     string                 `json:"project,omitempty"`
+	Properties  *VirtualHostProperties `json:"properties,omitempty"`
 }
@@ -339,3 +339,33 @@ func (r *galebRouter) SetHealthcheck(name string, data router.HealthcheckData) e
 	}
 	return r.client.UpdatePoolProperties(r.poolName(backendName), poolProperties)
 }
+
+func (r *galebRouter) AddCertificate(cname, certificate, key string) error {
+	err := r.client.UpdateVirtualHostCertificate(cname, certificate, key)
+	if _, ok := errors.Cause(err).(galebClient.ErrItemNotFound); ok {
+		return router.ErrCNameNotFound
+	}
+	return err
+}
+
+func (r *galebRouter) RemoveCertificate(cname string) error {
+	err := r.client.UpdateVirtualHostCertificate(cname, "", "")
+	if _, ok := errors.Cause(err).(galebClient.ErrItemNotFound); ok {
+		return router.ErrCNameNotFound
+	}
+	return err
+}
+
+func (r *galebRouter) GetCertificate(cname string) (string, error) {
+	virtualHost, err := r.client.FindVirtualHost(cname)
+	if _, ok := errors.Cause(err).(galebClient.ErrItemNotFound); ok {
+		return "", router.ErrCertificateNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if virtualHost.Properties == nil || virtualHost.Properties.Certificate == "" {
+		return "", router.ErrCertificateNotFound
+	}
+	return virtualHost.Properties.Certificate, nil
+}
@@ -19,6 +19,7 @@ import (
 	"github.com/tsuru/config"
 	"github.com/tsuru/tsuru/db"
 	"github.com/tsuru/tsuru/db/dbtest"
+	"github.com/tsuru/tsuru/router"
 	galebClient "github.com/tsuru/tsuru/router/galeb/client"
 	"github.com/tsuru/tsuru/router/routertest"
 	"gopkg.in/check.v1"
@@ -60,6 +61,7 @@ func NewFakeGalebServer() (*fakeGalebServer, error) {
 	r.HandleFunc("/api/pool/{id}", server.updatePool).Methods("PATCH")
 	r.HandleFunc("/api/rule", server.createRule).Methods("POST")
 	r.HandleFunc("/api/virtualhost", server.createVirtualhost).Methods("POST")
+	r.HandleFunc("/api/virtualhost/{id}", server.updateVirtualhost).Methods("PATCH")
 	r.HandleFunc("/api/{item}/{id}", server.findItem).Methods("GET")
 	r.HandleFunc("/api/{item}/{id}", server.destroyItem).Methods("DELETE")
 	r.HandleFunc("/api/{item}/search/findByName", server.findItemByNameHandler).Methods("GET")
@@ -279,6 +281,31 @@ func (s *fakeGalebServer) createVirtualhost(w http.ResponseWriter, r *http.Reque
 	w.WriteHeader(http.StatusCreated)
 }
 
+func (s *fakeGalebServer) updateVirtualhost(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	var virtualhost struct {
+		Properties map[string]*string `json:"properties"`
+	}
+	json.NewDecoder(r.Body).Decode(&virtualhost)
+	existingVirtualhost, ok := s.virtualhosts[id].(*galebClient.VirtualHost)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if virtualhost.Properties != nil {
+		if existingVirtualhost.Properties == nil {
+			existingVirtualhost.Properties = &galebClient.VirtualHostProperties{}
+		}
+		if value, ok := virtualhost.Properties["certificate"]; ok && value != nil {
+			existingVirtualhost.Properties.Certificate = *value
+		}
+		if value, ok := virtualhost.Properties["key"]; ok && value != nil {
+			existingVirtualhost.Properties.Key = *value
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func init() {
 	suite := &routertest.RouterSuite{
 		SetUpSuiteFunc: func(c *check.C) {
@@ -316,3 +343,98 @@ func init() {
 	}
 	check.Suite(suite)
 }
+
+type S struct {
+	server     *httptest.Server
+	fakeServer *fakeGalebServer
+	router     *galebRouter
+}
+
+var _ = check.Suite(&S{})
+
+func (s *S) SetUpSuite(c *check.C) {
+	config.Set("routers:galeb:username", "myusername")
+	config.Set("routers:galeb:password", "mypassword")
+	config.Set("routers:galeb:domain", "galeb.com")
+	config.Set("routers:galeb:type", "galeb")
+	config.Set("database:url", "127.0.0.1:27017")
+	config.Set("database:name", "router_galeb_tests")
+}
+
+func (s *S) SetUpTest(c *check.C) {
+	var err error
+	s.fakeServer, err = NewFakeGalebServer()
+	c.Assert(err, check.IsNil)
+	s.server = httptest.NewServer(s.fakeServer)
+	config.Set("routers:galeb:api-url", s.server.URL+"/api")
+	gRouter, err := createRouter("galeb", "routers:galeb")
+	c.Assert(err, check.IsNil)
+	s.router = gRouter.(*galebRouter)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	dbtest.ClearAllCollections(conn.Collection("router_galeb_tests").Database)
+}
+
+func (s *S) TearDownTest(c *check.C) {
+	s.server.Close()
+}
+
+func (s *S) virtualHost(name string) *galebClient.VirtualHost {
+	for _, item := range s.fakeServer.findItemByName("virtualhost", name) {
+		return item.(*galebClient.VirtualHost)
+	}
+	return nil
+}
+
+func (s *S) TestAddCertificate(c *check.C) {
+	err := s.router.AddBackend("myapp")
+	c.Assert(err, check.IsNil)
+	err = s.router.SetCName("myapp.io", "myapp")
+	c.Assert(err, check.IsNil)
+	err = s.router.AddCertificate("myapp.io", "cert-content", "key-content")
+	c.Assert(err, check.IsNil)
+	vh := s.virtualHost("myapp.io")
+	c.Assert(vh, check.NotNil)
+	c.Assert(vh.Properties, check.DeepEquals, &galebClient.VirtualHostProperties{
+		Certificate: "cert-content",
+		Key:         "key-content",
+	})
+	cert, err := s.router.GetCertificate("myapp.io")
+	c.Assert(err, check.IsNil)
+	c.Assert(cert, check.Equals, "cert-content")
+}
+
+func (s *S) TestAddCertificateUnknownCName(c *check.C) {
+	err := s.router.AddCertificate("myapp.io", "cert-content", "key-content")
+	c.Assert(err, check.Equals, router.ErrCNameNotFound)
+}
+
+func (s *S) TestRemoveCertificate(c *check.C) {
+	err := s.router.AddBackend("myapp")
+	c.Assert(err, check.IsNil)
+	err = s.router.SetCName("myapp.io", "myapp")
+	c.Assert(err, check.IsNil)
+	err = s.router.AddCertificate("myapp.io", "cert-content", "key-content")
+	c.Assert(err, check.IsNil)
+	err = s.router.RemoveCertificate("myapp.io")
+	c.Assert(err, check.IsNil)
+	c.Assert(s.virtualHost("myapp.io").Properties, check.DeepEquals, &galebClient.VirtualHostProperties{})
+	_, err = s.router.GetCertificate("myapp.io")
+	c.Assert(err, check.Equals, router.ErrCertificateNotFound)
+}
+
+func (s *S) TestRemoveCertificateUnknownCName(c *check.C) {
+	err := s.router.RemoveCertificate("myapp.io")
+	c.Assert(err, check.Equals, router.ErrCNameNotFound)
+}
+
+func (s *S) TestGetCertificateNotFound(c *check.C) {
+	err := s.router.AddBackend("myapp")
+	c.Assert(err, check.IsNil)
+	cert, err := s.router.GetCertificate("myapp.galeb.com")
+	c.Assert(err, check.Equals, router.ErrCertificateNotFound)
+	c.Assert(cert, check.Equals, "")
+	_, err = s.router.GetCertificate("unknown.io")
+	c.Assert(err, check.Equals, router.ErrCertificateNotFound)
+}
@@ -110,7 +110,12 @@ func (r *hipacheRouter) AddBackend(name string) error {
 	return router.Store(name, name, routerType)
 }
 
-func (r *hipacheRouter) RemoveBackend(name string) error {
+func (r *hipacheRouter) RemoveBackend(name string) (err error) {
+	defer func() {
+		if err == nil {
+			err = r.removeWeights(name)
+		}
+	}()
 	backendName, err := router.Retrieve(name)
 	if err != nil {
 		return err
@@ -159,7 +164,12 @@ func (r *hipacheRouter) RemoveBackend(name string) error {
 	return nil
 }
 
-func (r *hipacheRouter) AddRoute(name string, address *url.URL) error {
+func (r *hipacheRouter) AddRoute(name string, address *url.URL) (err error) {
+	defer func() {
+		if err == nil {
+			err = r.updateWeightedCNames(name)
+		}
+	}()
 	backendName, err := router.Retrieve(name)
 	if err != nil {
 		return err
@@ -201,7 +211,12 @@ func (r *hipacheRouter) AddRoute(name string, address *url.URL) error {
 	return nil
 }
 
-func (r *hipacheRouter) AddRoutes(name string, addresses []*url.URL) error {
+func (r *hipacheRouter) AddRoutes(name string, addresses []*url.URL) (err error) {
+	defer func() {
+		if err == nil {
+			err = r.updateWeightedCNames(name)
+		}
+	}()
 	backendName, err := router.Retrieve(name)
 	if err != nil {
 		return err
@@ -273,7 +288,12 @@ func (r *hipacheRouter) addRoutes(name string, addresses []string) error {
 	return nil
 }
 
-func (r *hipacheRouter) RemoveRoute(name string, address *url.URL) error {
+func (r *hipacheRouter) RemoveRoute(name string, address *url.URL) (err error) {
+	defer func() {
+		if err == nil {
+			err = r.updateWeightedCNames(name)
+		}
+	}()
 	backendName, err := router.Retrieve(name)
 	if err != nil {
 		return err
@@ -307,7 +327,12 @@ func (r *hipacheRouter) RemoveRoute(name string, address *url.URL) error {
 	return nil
 }
 
-func (r *hipacheRouter) RemoveRoutes(name string, addresses []*url.URL) error {
+func (r *hipacheRouter) RemoveRoutes(name string, addresses []*url.URL) (err error) {
+	defer func() {
+		if err == nil {
+			err = r.updateWeightedCNames(name)
+		}
+	}()
 	backendName, err := router.Retrieve(name)
 	if err != nil {
 		return err
@@ -484,7 +509,7 @@ func (r *hipacheRouter) UnsetCName(cname, name string) error {
 	if err != nil {
 		return &router.RouterError{Op: "unsetCName", Err: err}
 	}
-	return nil
+	return router.StoreWeights(cname, name, nil)
 }
 
 func (r *hipacheRouter) Addr(name string) (string, error) {
@@ -609,6 +634,123 @@ func (r *hipacheRouter) SetHealthcheck(name string, data router.HealthcheckData)
 	return nil
 }
 
+func (r *hipacheRouter) SetCNameWeights(name, cname string, weights []router.BackendWeight) error {
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return err
+	}
+	cnames, err := r.getCNames(backendName)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, n := range cnames {
+		if n == cname {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return router.ErrCNameNotFound
+	}
+	if len(weights) > 0 {
+		err = router.ValidateWeights(weights)
+		if err != nil {
+			return err
+		}
+	}
+	err = router.StoreWeights(cname, name, weights)
+	if err != nil {
+		return err
+	}
+	return r.writeCNameRoutes(router.WeightsEntry{CName: cname, Backend: name, Weights: weights})
+}
+
+func (r *hipacheRouter) CNamesWeights(name string) (map[string][]router.BackendWeight, error) {
+	entries, err := router.RetrieveWeights(name)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string][]router.BackendWeight, len(entries))
+	for _, e := range entries {
+		result[e.CName] = e.Weights
+	}
+	return result, nil
+}
+
+// writeCNameRoutes replaces the routes of a cname frontend with the routes of
+// its backend or, for weighted cnames, with the routes of every weighted
+// backend repeated according to their weights.
+func (r *hipacheRouter) writeCNameRoutes(entry router.WeightsEntry) error {
+	backendName, err := router.Retrieve(entry.Backend)
+	if err != nil {
+		return err
+	}
+	var routes []*url.URL
+	if len(entry.Weights) == 0 {
+		routes, err = r.Routes(entry.Backend)
+		if err != nil {
+			return err
+		}
+	} else {
+		backendRoutes := make(map[string][]*url.URL, len(entry.Weights))
+		for _, w := range entry.Weights {
+			backendRoutes[w.Backend], err = r.Routes(w.Backend)
+			if err != nil && err != router.ErrBackendNotFound {
+				return err
+			}
+		}
+		routes = router.WeightedRoutes(entry.Weights, backendRoutes)
+	}
+	values := make([]string, 0, len(routes)+1)
+	values = append(values, backendName)
+	for _, route := range routes {
+		values = append(values, route.String())
+	}
+	conn, err := r.connect()
+	if err != nil {
+		return &router.RouterError{Op: "setWeights", Err: err}
+	}
+	frontend := "frontend:" + entry.CName
+	pipe := conn.Pipeline()
+	defer pipe.Close()
+	pipe.Del(frontend)
+	pipe.RPush(frontend, values...)
+	_, err = pipe.Exec()
+	if err != nil {
+		return &router.RouterError{Op: "setWeights", Err: err}
+	}
+	return nil
+}
+
+func (r *hipacheRouter) updateWeightedCNames(name string) error {
+	entries, err := router.WeightsUsingBackend(name)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		err = r.writeCNameRoutes(e)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *hipacheRouter) removeWeights(name string) error {
+	entries, err := router.RetrieveWeights(name)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		err = router.StoreWeights(e.CName, name, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return r.updateWeightedCNames(name)
+}
+
 type planbRouter struct {
 	hipacheRouter
 }
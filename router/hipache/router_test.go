@@ -402,6 +402,115 @@ func (s *S) TestRemoveRouteAlsoRemovesRespectiveCNameRecord(c *check.C) {
 	c.Assert(cnames, check.Equals, int64(0))
 }
 
+func (s *S) TestSetCNameWeights(c *check.C) {
+	r := hipacheRouter{prefix: "hipache"}
+	err := r.AddBackend("app1")
+	c.Assert(err, check.IsNil)
+	defer r.RemoveBackend("app1")
+	err = r.AddBackend("app2")
+	c.Assert(err, check.IsNil)
+	defer r.RemoveBackend("app2")
+	addr1, _ := url.Parse("http://10.10.10.10:8080")
+	err = r.AddRoute("app1", addr1)
+	c.Assert(err, check.IsNil)
+	addr2, _ := url.Parse("http://10.10.10.11:8080")
+	err = r.AddRoute("app2", addr2)
+	c.Assert(err, check.IsNil)
+	err = r.SetCName("mycname.com", "app1")
+	c.Assert(err, check.IsNil)
+	weights := []router.BackendWeight{{Backend: "app1", Weight: 75}, {Backend: "app2", Weight: 25}}
+	err = r.SetCNameWeights("app1", "mycname.com", weights)
+	c.Assert(err, check.IsNil)
+	conn, err := r.connect()
+	c.Assert(err, check.IsNil)
+	routes, err := conn.LRange("frontend:mycname.com", 0, -1).Result()
+	c.Assert(err, check.IsNil)
+	c.Assert(routes, check.DeepEquals, []string{"app1", addr1.String(), addr1.String(), addr1.String(), addr2.String()})
+	routes, err = conn.LRange("frontend:app1.golang.org", 0, -1).Result()
+	c.Assert(err, check.IsNil)
+	c.Assert(routes, check.DeepEquals, []string{"app1", addr1.String()})
+	result, err := r.CNamesWeights("app1")
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, map[string][]router.BackendWeight{"mycname.com": weights})
+	err = r.SetCNameWeights("app1", "mycname.com", nil)
+	c.Assert(err, check.IsNil)
+	routes, err = conn.LRange("frontend:mycname.com", 0, -1).Result()
+	c.Assert(err, check.IsNil)
+	c.Assert(routes, check.DeepEquals, []string{"app1", addr1.String()})
+	result, err = r.CNamesWeights("app1")
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.HasLen, 0)
+}
+
+func (s *S) TestSetCNameWeightsUpdatedOnRouteChanges(c *check.C) {
+	r := hipacheRouter{prefix: "hipache"}
+	err := r.AddBackend("app1")
+	c.Assert(err, check.IsNil)
+	defer r.RemoveBackend("app1")
+	err = r.AddBackend("app2")
+	c.Assert(err, check.IsNil)
+	defer r.RemoveBackend("app2")
+	addr1, _ := url.Parse("http://10.10.10.10:8080")
+	err = r.AddRoute("app1", addr1)
+	c.Assert(err, check.IsNil)
+	err = r.SetCName("mycname.com", "app1")
+	c.Assert(err, check.IsNil)
+	err = r.SetCNameWeights("app1", "mycname.com", []router.BackendWeight{{Backend: "app1", Weight: 50}, {Backend: "app2", Weight: 50}})
+	c.Assert(err, check.IsNil)
+	conn, err := r.connect()
+	c.Assert(err, check.IsNil)
+	routes, err := conn.LRange("frontend:mycname.com", 0, -1).Result()
+	c.Assert(err, check.IsNil)
+	c.Assert(routes, check.DeepEquals, []string{"app1", addr1.String()})
+	addr2, _ := url.Parse("http://10.10.10.11:8080")
+	addr3, _ := url.Parse("http://10.10.10.12:8080")
+	err = r.AddRoutes("app2", []*url.URL{addr2, addr3})
+	c.Assert(err, check.IsNil)
+	routes, err = conn.LRange("frontend:mycname.com", 0, -1).Result()
+	c.Assert(err, check.IsNil)
+	c.Assert(routes, check.DeepEquals, []string{"app1", addr1.String(), addr1.String(), addr2.String(), addr3.String()})
+	addr4, _ := url.Parse("http://10.10.10.13:8080")
+	err = r.AddRoute("app1", addr4)
+	c.Assert(err, check.IsNil)
+	routes, err = conn.LRange("frontend:mycname.com", 0, -1).Result()
+	c.Assert(err, check.IsNil)
+	c.Assert(routes, check.DeepEquals, []string{"app1", addr1.String(), addr4.String(), addr2.String(), addr3.String()})
+	err = r.RemoveRoutes("app2", []*url.URL{addr2, addr3})
+	c.Assert(err, check.IsNil)
+	routes, err = conn.LRange("frontend:mycname.com", 0, -1).Result()
+	c.Assert(err, check.IsNil)
+	c.Assert(routes, check.DeepEquals, []string{"app1", addr1.String(), addr4.String()})
+}
+
+func (s *S) TestSetCNameWeightsInvalid(c *check.C) {
+	r := hipacheRouter{prefix: "hipache"}
+	err := r.AddBackend("app1")
+	c.Assert(err, check.IsNil)
+	defer r.RemoveBackend("app1")
+	err = r.SetCNameWeights("app1", "mycname.com", []router.BackendWeight{{Backend: "app1", Weight: 100}})
+	c.Assert(err, check.Equals, router.ErrCNameNotFound)
+	err = r.SetCName("mycname.com", "app1")
+	c.Assert(err, check.IsNil)
+	err = r.SetCNameWeights("app1", "mycname.com", []router.BackendWeight{{Backend: "app1", Weight: 60}})
+	c.Assert(err, check.Equals, router.ErrInvalidWeights)
+}
+
+func (s *S) TestUnsetCNameRemovesWeights(c *check.C) {
+	r := hipacheRouter{prefix: "hipache"}
+	err := r.AddBackend("app1")
+	c.Assert(err, check.IsNil)
+	defer r.RemoveBackend("app1")
+	err = r.SetCName("mycname.com", "app1")
+	c.Assert(err, check.IsNil)
+	err = r.SetCNameWeights("app1", "mycname.com", []router.BackendWeight{{Backend: "app1", Weight: 100}})
+	c.Assert(err, check.IsNil)
+	err = r.UnsetCName("mycname.com", "app1")
+	c.Assert(err, check.IsNil)
+	result, err := r.CNamesWeights("app1")
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.HasLen, 0)
+}
+
 func (s *S) TestHealthCheck(c *check.C) {
 	router := hipacheRouter{prefix: "hipache"}
 	c.Assert(router.HealthCheck(), check.IsNil)
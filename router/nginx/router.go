@@ -0,0 +1,828 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package nginx provides a router implementation that keeps the state of the
+// routes in MongoDB and renders them as nginx configuration files, one file
+// per backend, reloading nginx after each change.
+//
+// It does not provide any exported type, in order to use the router, you must
+// import this package and get the router instance using the function
+// router.Get.
+//
+// In order to use this router, you need to define the "routers:<name>:type =
+// nginx" in your config and include the generated files, from
+// "routers:<name>:config-dir", in the http block of nginx.conf.
+package nginx
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/acme"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/db/storage"
+	"github.com/tsuru/tsuru/hc"
+	"github.com/tsuru/tsuru/router"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const routerType = "nginx"
+
+// mut serializes changes to the stored routes and the rendering of the
+// configuration files, ensuring the files always reflect the latest state.
+var mut sync.Mutex
+
+func init() {
+	router.Register(routerType, createRouter)
+	hc.AddChecker("Router nginx", router.BuildHealthCheck(routerType))
+}
+
+type nginxRouter struct {
+	routerName        string
+	domain            string
+	configDir         string
+	reloadCommand     string
+	listen            string
+	tlsListen         string
+	activeHealthcheck bool
+}
+
+func createRouter(routerName, configPrefix string) (router.Router, error) {
+	domain, err := config.GetString(configPrefix + ":domain")
+	if err != nil {
+		return nil, err
+	}
+	configDir, err := config.GetString(configPrefix + ":config-dir")
+	if err != nil {
+		return nil, err
+	}
+	reloadCommand, _ := config.GetString(configPrefix + ":reload-command")
+	listen, _ := config.GetString(configPrefix + ":listen")
+	if listen == "" {
+		listen = "80"
+	}
+	tlsListen, _ := config.GetString(configPrefix + ":tls-listen")
+	if tlsListen == "" {
+		tlsListen = "443"
+	}
+	activeHealthcheck, _ := config.GetBool(configPrefix + ":active-healthcheck")
+	return &nginxRouter{
+		routerName:        routerName,
+		domain:            domain,
+		configDir:         configDir,
+		reloadCommand:     reloadCommand,
+		listen:            listen,
+		tlsListen:         tlsListen,
+		activeHealthcheck: activeHealthcheck,
+	}, nil
+}
+
+type acmeChallengeRoute struct {
+	CName   string
+	Address string
+}
+
+type backendEntry struct {
+	Router         string
+	Name           string
+	Routes         []string
+	CNames         []string
+	Healthcheck    router.HealthcheckData
+	ACMEChallenges []acmeChallengeRoute
+}
+
+type certificateEntry struct {
+	Router      string
+	Host        string
+	Certificate string
+	Key         string
+}
+
+func backendsCollection() (*storage.Collection, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	coll := conn.Collection("router_nginx_backends")
+	err = coll.EnsureIndex(mgo.Index{Key: []string{"router", "name"}, Unique: true})
+	if err != nil {
+		coll.Close()
+		return nil, err
+	}
+	err = coll.EnsureIndex(mgo.Index{Key: []string{"router", "cnames"}})
+	if err != nil {
+		coll.Close()
+		return nil, err
+	}
+	return coll, nil
+}
+
+func certificatesCollection() (*storage.Collection, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	coll := conn.Collection("router_nginx_certificates")
+	err = coll.EnsureIndex(mgo.Index{Key: []string{"router", "host"}, Unique: true})
+	if err != nil {
+		coll.Close()
+		return nil, err
+	}
+	return coll, nil
+}
+
+func (r *nginxRouter) address(backendName string) string {
+	return fmt.Sprintf("%s.%s", backendName, r.domain)
+}
+
+func (r *nginxRouter) backendQuery(backendName string) bson.M {
+	return bson.M{"router": r.routerName, "name": backendName}
+}
+
+func (r *nginxRouter) getEntry(backendName string) (*backendEntry, error) {
+	coll, err := backendsCollection()
+	if err != nil {
+		return nil, err
+	}
+	defer coll.Close()
+	var entry backendEntry
+	err = coll.Find(r.backendQuery(backendName)).One(&entry)
+	if err == mgo.ErrNotFound {
+		return nil, router.ErrBackendNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (r *nginxRouter) retrieveEntry(name string) (*backendEntry, error) {
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return nil, err
+	}
+	return r.getEntry(backendName)
+}
+
+// hostOwner returns the backend serving the host, either as its address or as
+// one of its cnames.
+func (r *nginxRouter) hostOwner(host string) (*backendEntry, error) {
+	coll, err := backendsCollection()
+	if err != nil {
+		return nil, err
+	}
+	defer coll.Close()
+	var entry backendEntry
+	conditions := []bson.M{{"cnames": host}}
+	if name, ok := r.backendFromAddress(host); ok {
+		conditions = append(conditions, bson.M{"name": name})
+	}
+	err = coll.Find(bson.M{"router": r.routerName, "$or": conditions}).One(&entry)
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (r *nginxRouter) backendFromAddress(host string) (string, bool) {
+	suffix := "." + r.domain
+	if !strings.HasSuffix(host, suffix) {
+		return "", false
+	}
+	return strings.TrimSuffix(host, suffix), true
+}
+
+func (r *nginxRouter) updateEntry(backendName string, update bson.M) error {
+	coll, err := backendsCollection()
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	err = coll.Update(r.backendQuery(backendName), update)
+	if err == mgo.ErrNotFound {
+		return router.ErrBackendNotFound
+	}
+	return err
+}
+
+func (r *nginxRouter) AddBackend(name string) error {
+	mut.Lock()
+	defer mut.Unlock()
+	coll, err := backendsCollection()
+	if err != nil {
+		return &router.RouterError{Op: "add", Err: err}
+	}
+	defer coll.Close()
+	err = coll.Insert(backendEntry{Router: r.routerName, Name: name})
+	if mgo.IsDup(err) {
+		return router.ErrBackendExists
+	}
+	if err != nil {
+		return &router.RouterError{Op: "add", Err: err}
+	}
+	err = r.apply(name)
+	if err != nil {
+		coll.Remove(r.backendQuery(name))
+		return err
+	}
+	return router.Store(name, name, routerType)
+}
+
+func (r *nginxRouter) RemoveBackend(name string) error {
+	mut.Lock()
+	defer mut.Unlock()
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return err
+	}
+	if backendName != name {
+		return router.ErrBackendSwapped
+	}
+	entry, err := r.getEntry(backendName)
+	if err != nil {
+		return err
+	}
+	coll, err := backendsCollection()
+	if err != nil {
+		return &router.RouterError{Op: "remove", Err: err}
+	}
+	defer coll.Close()
+	err = coll.Remove(r.backendQuery(backendName))
+	if err == mgo.ErrNotFound {
+		return router.ErrBackendNotFound
+	}
+	if err != nil {
+		return &router.RouterError{Op: "remove", Err: err}
+	}
+	for _, host := range append(entry.CNames, r.address(backendName)) {
+		err = r.removeCertificate(host)
+		if err != nil && err != router.ErrCertificateNotFound {
+			return &router.RouterError{Op: "remove", Err: err}
+		}
+	}
+	return r.apply(backendName)
+}
+
+func (r *nginxRouter) AddRoute(name string, address *url.URL) error {
+	mut.Lock()
+	defer mut.Unlock()
+	entry, err := r.retrieveEntry(name)
+	if err != nil {
+		return err
+	}
+	for _, route := range entry.Routes {
+		if route == address.Host {
+			return router.ErrRouteExists
+		}
+	}
+	err = r.updateEntry(entry.Name, bson.M{"$push": bson.M{"routes": address.Host}})
+	if err != nil {
+		return err
+	}
+	return r.apply(entry.Name)
+}
+
+func (r *nginxRouter) AddRoutes(name string, addresses []*url.URL) error {
+	mut.Lock()
+	defer mut.Unlock()
+	entry, err := r.retrieveEntry(name)
+	if err != nil {
+		return err
+	}
+	hosts := make([]string, len(addresses))
+	for i := range addresses {
+		hosts[i] = addresses[i].Host
+	}
+	err = r.updateEntry(entry.Name, bson.M{"$addToSet": bson.M{"routes": bson.M{"$each": hosts}}})
+	if err != nil {
+		return err
+	}
+	return r.apply(entry.Name)
+}
+
+func (r *nginxRouter) RemoveRoute(name string, address *url.URL) error {
+	mut.Lock()
+	defer mut.Unlock()
+	entry, err := r.retrieveEntry(name)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, route := range entry.Routes {
+		if route == address.Host {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return router.ErrRouteNotFound
+	}
+	err = r.updateEntry(entry.Name, bson.M{"$pull": bson.M{"routes": address.Host}})
+	if err != nil {
+		return err
+	}
+	return r.apply(entry.Name)
+}
+
+func (r *nginxRouter) RemoveRoutes(name string, addresses []*url.URL) error {
+	mut.Lock()
+	defer mut.Unlock()
+	entry, err := r.retrieveEntry(name)
+	if err != nil {
+		return err
+	}
+	hosts := make([]string, len(addresses))
+	for i := range addresses {
+		hosts[i] = addresses[i].Host
+	}
+	err = r.updateEntry(entry.Name, bson.M{"$pullAll": bson.M{"routes": hosts}})
+	if err != nil {
+		return err
+	}
+	return r.apply(entry.Name)
+}
+
+func (r *nginxRouter) Routes(name string) ([]*url.URL, error) {
+	entry, err := r.retrieveEntry(name)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*url.URL, len(entry.Routes))
+	for i, route := range entry.Routes {
+		result[i] = &url.URL{Scheme: router.HttpScheme, Host: route}
+	}
+	return result, nil
+}
+
+func (r *nginxRouter) Addr(name string) (string, error) {
+	entry, err := r.retrieveEntry(name)
+	if err != nil {
+		return "", err
+	}
+	return r.address(entry.Name), nil
+}
+
+func (r *nginxRouter) Swap(backend1, backend2 string, cnameOnly bool) error {
+	return router.Swap(r, backend1, backend2, cnameOnly)
+}
+
+func (r *nginxRouter) SetCName(cname, name string) error {
+	mut.Lock()
+	defer mut.Unlock()
+	entry, err := r.retrieveEntry(name)
+	if err != nil {
+		return err
+	}
+	if !router.ValidCName(cname, r.domain) {
+		return router.ErrCNameNotAllowed
+	}
+	if _, err = r.hostOwner(cname); err == nil {
+		return router.ErrCNameExists
+	} else if err != mgo.ErrNotFound {
+		return &router.RouterError{Op: "setCName", Err: err}
+	}
+	err = r.updateEntry(entry.Name, bson.M{"$push": bson.M{"cnames": cname}})
+	if err != nil {
+		return err
+	}
+	return r.apply(entry.Name)
+}
+
+func (r *nginxRouter) UnsetCName(cname, name string) error {
+	mut.Lock()
+	defer mut.Unlock()
+	entry, err := r.retrieveEntry(name)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, c := range entry.CNames {
+		if c == cname {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return router.ErrCNameNotFound
+	}
+	err = r.updateEntry(entry.Name, bson.M{"$pull": bson.M{
+		"cnames":         cname,
+		"acmechallenges": bson.M{"cname": cname},
+	}})
+	if err != nil {
+		return err
+	}
+	return r.apply(entry.Name)
+}
+
+func (r *nginxRouter) CNames(name string) ([]*url.URL, error) {
+	entry, err := r.retrieveEntry(name)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*url.URL, len(entry.CNames))
+	for i, cname := range entry.CNames {
+		result[i] = &url.URL{Host: cname}
+	}
+	return result, nil
+}
+
+// healthcheckPathRegexp only allows URL path characters that can be written
+// unquoted in an nginx directive.
+var healthcheckPathRegexp = regexp.MustCompile(`^/[A-Za-z0-9\-._~%!*+,/:=?@&]*$`)
+
+func validHealthcheckPath(path string) bool {
+	return healthcheckPathRegexp.MatchString(path)
+}
+
+func (r *nginxRouter) SetHealthcheck(name string, data router.HealthcheckData) error {
+	if data.Path != "" && !validHealthcheckPath(data.Path) {
+		return errors.Errorf("invalid healthcheck path %q", data.Path)
+	}
+	mut.Lock()
+	defer mut.Unlock()
+	entry, err := r.retrieveEntry(name)
+	if err != nil {
+		return err
+	}
+	err = r.updateEntry(entry.Name, bson.M{"$set": bson.M{"healthcheck": data}})
+	if err != nil {
+		return err
+	}
+	return r.apply(entry.Name)
+}
+
+func (r *nginxRouter) AddCertificate(cname, certificate, key string) error {
+	mut.Lock()
+	defer mut.Unlock()
+	entry, err := r.hostOwner(cname)
+	if err == mgo.ErrNotFound {
+		return router.ErrCNameNotFound
+	}
+	if err != nil {
+		return &router.RouterError{Op: "addCertificate", Err: err}
+	}
+	coll, err := certificatesCollection()
+	if err != nil {
+		return &router.RouterError{Op: "addCertificate", Err: err}
+	}
+	defer coll.Close()
+	_, err = coll.Upsert(bson.M{"router": r.routerName, "host": cname}, certificateEntry{
+		Router:      r.routerName,
+		Host:        cname,
+		Certificate: certificate,
+		Key:         key,
+	})
+	if err != nil {
+		return &router.RouterError{Op: "addCertificate", Err: err}
+	}
+	err = r.writeCertificate(cname, certificate, key)
+	if err != nil {
+		return &router.RouterError{Op: "addCertificate", Err: err}
+	}
+	return r.apply(entry.Name)
+}
+
+func (r *nginxRouter) RemoveCertificate(cname string) error {
+	mut.Lock()
+	defer mut.Unlock()
+	err := r.removeCertificate(cname)
+	if err != nil {
+		return err
+	}
+	entry, err := r.hostOwner(cname)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return &router.RouterError{Op: "removeCertificate", Err: err}
+	}
+	return r.apply(entry.Name)
+}
+
+func (r *nginxRouter) removeCertificate(host string) error {
+	coll, err := certificatesCollection()
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	err = coll.Remove(bson.M{"router": r.routerName, "host": host})
+	if err == mgo.ErrNotFound {
+		return router.ErrCertificateNotFound
+	}
+	if err != nil {
+		return err
+	}
+	for _, path := range r.certificatePaths(host) {
+		err = os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *nginxRouter) GetCertificate(cname string) (string, error) {
+	coll, err := certificatesCollection()
+	if err != nil {
+		return "", err
+	}
+	defer coll.Close()
+	var entry certificateEntry
+	err = coll.Find(bson.M{"router": r.routerName, "host": cname}).One(&entry)
+	if err == mgo.ErrNotFound {
+		return "", router.ErrCertificateNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return entry.Certificate, nil
+}
+
+func (r *nginxRouter) AddACMEChallengeRoute(cname string, address *url.URL) error {
+	mut.Lock()
+	defer mut.Unlock()
+	entry, err := r.hostOwner(cname)
+	if err == mgo.ErrNotFound {
+		return router.ErrCNameNotFound
+	}
+	if err != nil {
+		return &router.RouterError{Op: "addACMEChallengeRoute", Err: err}
+	}
+	challenges := []acmeChallengeRoute{{CName: cname, Address: address.String()}}
+	for _, c := range entry.ACMEChallenges {
+		if c.CName != cname {
+			challenges = append(challenges, c)
+		}
+	}
+	err = r.updateEntry(entry.Name, bson.M{"$set": bson.M{"acmechallenges": challenges}})
+	if err != nil {
+		return err
+	}
+	return r.apply(entry.Name)
+}
+
+func (r *nginxRouter) RemoveACMEChallengeRoute(cname string) error {
+	mut.Lock()
+	defer mut.Unlock()
+	entry, err := r.hostOwner(cname)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return &router.RouterError{Op: "removeACMEChallengeRoute", Err: err}
+	}
+	err = r.updateEntry(entry.Name, bson.M{"$pull": bson.M{"acmechallenges": bson.M{"cname": cname}}})
+	if err != nil {
+		return err
+	}
+	return r.apply(entry.Name)
+}
+
+func (r *nginxRouter) StartupMessage() (string, error) {
+	return fmt.Sprintf("nginx router %q with configuration files at %q.", r.domain, r.configDir), nil
+}
+
+func (r *nginxRouter) HealthCheck() error {
+	info, err := os.Stat(r.configDir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.Errorf("%q is not a directory", r.configDir)
+	}
+	return nil
+}
+
+func (r *nginxRouter) configPath(backendName string) string {
+	return filepath.Join(r.configDir, backendName+".conf")
+}
+
+func (r *nginxRouter) certificatePaths(host string) [2]string {
+	base := filepath.Join(r.configDir, "certs", host)
+	return [2]string{base + ".crt", base + ".key"}
+}
+
+func (r *nginxRouter) writeCertificate(host, certificate, key string) error {
+	paths := r.certificatePaths(host)
+	err := os.MkdirAll(filepath.Dir(paths[0]), 0700)
+	if err != nil {
+		return err
+	}
+	err = writeFile(paths[0], []byte(certificate), 0644)
+	if err != nil {
+		return err
+	}
+	return writeFile(paths[1], []byte(key), 0600)
+}
+
+// apply renders the configuration file of the backend, removing it when the
+// backend no longer exists, and reloads nginx.
+func (r *nginxRouter) apply(backendName string) error {
+	entry, err := r.getEntry(backendName)
+	if err == router.ErrBackendNotFound {
+		err = os.Remove(r.configPath(backendName))
+		if err != nil && !os.IsNotExist(err) {
+			return &router.RouterError{Op: "apply", Err: err}
+		}
+		return r.reload()
+	}
+	if err != nil {
+		return &router.RouterError{Op: "apply", Err: err}
+	}
+	certs, err := r.certificates(r.hosts(entry))
+	if err != nil {
+		return &router.RouterError{Op: "apply", Err: err}
+	}
+	data, err := r.render(entry, certs)
+	if err != nil {
+		return &router.RouterError{Op: "apply", Err: err}
+	}
+	err = writeFile(r.configPath(backendName), data, 0644)
+	if err != nil {
+		return &router.RouterError{Op: "apply", Err: err}
+	}
+	return r.reload()
+}
+
+func (r *nginxRouter) reload() error {
+	if r.reloadCommand == "" {
+		return nil
+	}
+	out, err := exec.Command("/bin/sh", "-c", r.reloadCommand).CombinedOutput()
+	if err != nil {
+		return &router.RouterError{Op: "reload", Err: errors.Wrapf(err, "error running %q: %s", r.reloadCommand, out)}
+	}
+	return nil
+}
+
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	err := ioutil.WriteFile(tmp, data, perm)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+type serverData struct {
+	Host          string
+	ACMEChallenge string
+	Certificate   string
+	Key           string
+}
+
+type configData struct {
+	Upstream    string
+	Routes      []string
+	Servers     []serverData
+	Listen      string
+	TLSListen   string
+	Healthcheck *router.HealthcheckData
+	BodyRegexp  string
+}
+
+type locationsData struct {
+	Config        configData
+	Server        serverData
+	ChallengePath string
+}
+
+var configTemplate = template.Must(template.New("config").Funcs(template.FuncMap{
+	"locations": func(c configData, s serverData) locationsData {
+		return locationsData{Config: c, Server: s, ChallengePath: acme.ChallengePath}
+	},
+}).Parse(`# Generated by tsuru, do not edit.
+{{- if .Routes}}
+
+upstream {{.Upstream}} {
+{{- range .Routes}}
+    server {{.}};
+{{- end}}
+}
+{{- end}}
+{{- if .Healthcheck}}
+
+match {{.Upstream}}_healthcheck {
+{{- if .Healthcheck.Status}}
+    status {{.Healthcheck.Status}};
+{{- end}}
+{{- if .BodyRegexp}}
+    body ~ "{{.BodyRegexp}}";
+{{- end}}
+}
+{{- end}}
+{{- range .Servers}}
+
+server {
+    listen {{$.Listen}};
+    server_name {{.Host}};
+{{- template "locations" (locations $ .)}}
+}
+{{- if .Certificate}}
+
+server {
+    listen {{$.TLSListen}} ssl;
+    server_name {{.Host}};
+    ssl_certificate {{.Certificate}};
+    ssl_certificate_key {{.Key}};
+{{- template "locations" (locations $ .)}}
+}
+{{- end}}
+{{- end}}
+{{define "locations"}}
+{{- if .Server.ACMEChallenge}}
+    location {{.ChallengePath}} {
+        proxy_pass {{.Server.ACMEChallenge}};
+        proxy_set_header Host $host;
+    }
+{{- end}}
+    location / {
+{{- if .Config.Routes}}
+        proxy_pass http://{{.Config.Upstream}};
+        proxy_set_header Host $host;
+        proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+        proxy_set_header X-Forwarded-Proto $scheme;
+{{- if .Config.Healthcheck}}
+        health_check uri={{.Config.Healthcheck.Path}} match={{.Config.Upstream}}_healthcheck;
+{{- end}}
+{{- else}}
+        return 503;
+{{- end}}
+    }
+{{- end}}
+`))
+
+var bodyQuoteReplacer = regexp.MustCompile(`["\\]`)
+
+func (r *nginxRouter) hosts(entry *backendEntry) []string {
+	return append([]string{r.address(entry.Name)}, entry.CNames...)
+}
+
+// render returns the nginx configuration of a backend, certs being the set
+// of its hosts with certificates.
+func (r *nginxRouter) render(entry *backendEntry, certs map[string]bool) ([]byte, error) {
+	challenges := make(map[string]string, len(entry.ACMEChallenges))
+	for _, c := range entry.ACMEChallenges {
+		challenges[c.CName] = c.Address
+	}
+	data := configData{
+		Upstream:  "tsuru_" + entry.Name,
+		Routes:    entry.Routes,
+		Listen:    r.listen,
+		TLSListen: r.tlsListen,
+	}
+	sort.Strings(data.Routes)
+	if r.activeHealthcheck && validHealthcheckPath(entry.Healthcheck.Path) {
+		data.Healthcheck = &entry.Healthcheck
+		if entry.Healthcheck.Body != "" {
+			data.BodyRegexp = bodyQuoteReplacer.ReplaceAllString(regexp.QuoteMeta(entry.Healthcheck.Body), `\$0`)
+		}
+	}
+	for _, host := range r.hosts(entry) {
+		server := serverData{Host: host, ACMEChallenge: challenges[host]}
+		if certs[host] {
+			paths := r.certificatePaths(host)
+			server.Certificate, server.Key = paths[0], paths[1]
+		}
+		data.Servers = append(data.Servers, server)
+	}
+	var buf bytes.Buffer
+	err := configTemplate.Execute(&buf, data)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (r *nginxRouter) certificates(hosts []string) (map[string]bool, error) {
+	coll, err := certificatesCollection()
+	if err != nil {
+		return nil, err
+	}
+	defer coll.Close()
+	var entries []certificateEntry
+	err = coll.Find(bson.M{"router": r.routerName, "host": bson.M{"$in": hosts}}).All(&entries)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		result[e.Host] = true
+	}
+	return result, nil
+}
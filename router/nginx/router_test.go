@@ -0,0 +1,327 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nginx
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/db/dbtest"
+	"github.com/tsuru/tsuru/router"
+	"github.com/tsuru/tsuru/router/routertest"
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+type S struct {
+	conn      *db.Storage
+	configDir string
+	router    *nginxRouter
+}
+
+var _ = check.Suite(&S{})
+
+func init() {
+	base := &S{}
+	suite := &routertest.RouterSuite{
+		SetUpSuiteFunc:   base.SetUpSuite,
+		TearDownTestFunc: base.TearDownTest,
+	}
+	suite.SetUpTestFunc = func(c *check.C) {
+		config.Set("database:name", "router_generic_nginx_tests")
+		base.SetUpTest(c)
+		suite.Router = base.router
+	}
+	check.Suite(suite)
+}
+
+func (s *S) SetUpSuite(c *check.C) {
+	config.Set("routers:nginx:type", "nginx")
+	config.Set("routers:nginx:domain", "nginx.example.com")
+	config.Set("database:url", "127.0.0.1:27017")
+	config.Set("database:name", "router_nginx_tests")
+}
+
+func (s *S) SetUpTest(c *check.C) {
+	var err error
+	s.conn, err = db.Conn()
+	c.Assert(err, check.IsNil)
+	dbtest.ClearAllCollections(s.conn.Collection("router_nginx_tests").Database)
+	s.configDir = c.MkDir()
+	config.Set("routers:nginx:config-dir", s.configDir)
+	config.Set("routers:nginx:reload-command", fmt.Sprintf("echo reload >> %s/reloads", s.configDir))
+	config.Unset("routers:nginx:active-healthcheck")
+	r, err := router.Get("nginx")
+	c.Assert(err, check.IsNil)
+	s.router = r.(*nginxRouter)
+}
+
+func (s *S) TearDownTest(c *check.C) {
+	s.conn.Close()
+}
+
+func (s *S) readConfig(c *check.C, name string) string {
+	data, err := ioutil.ReadFile(filepath.Join(s.configDir, name+".conf"))
+	c.Assert(err, check.IsNil)
+	return string(data)
+}
+
+func (s *S) reloads(c *check.C) int {
+	data, err := ioutil.ReadFile(filepath.Join(s.configDir, "reloads"))
+	if os.IsNotExist(err) {
+		return 0
+	}
+	c.Assert(err, check.IsNil)
+	return strings.Count(string(data), "reload")
+}
+
+func (s *S) TestAddBackendWritesConfig(c *check.C) {
+	err := s.router.AddBackend("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(s.readConfig(c, "myapp"), check.Equals, `# Generated by tsuru, do not edit.
+
+server {
+    listen 80;
+    server_name myapp.nginx.example.com;
+    location / {
+        return 503;
+    }
+}
+`)
+	c.Assert(s.reloads(c), check.Equals, 1)
+	addr, err := s.router.Addr("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(addr, check.Equals, "myapp.nginx.example.com")
+}
+
+func (s *S) TestAddRoutesWritesUpstream(c *check.C) {
+	err := s.router.AddBackend("myapp")
+	c.Assert(err, check.IsNil)
+	err = s.router.AddRoutes("myapp", []*url.URL{
+		{Scheme: "http", Host: "10.0.0.2:8080"},
+		{Scheme: "http", Host: "10.0.0.1:8080"},
+	})
+	c.Assert(err, check.IsNil)
+	err = s.router.SetCName("myapp.io", "myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(s.readConfig(c, "myapp"), check.Equals, `# Generated by tsuru, do not edit.
+
+upstream tsuru_myapp {
+    server 10.0.0.1:8080;
+    server 10.0.0.2:8080;
+}
+
+server {
+    listen 80;
+    server_name myapp.nginx.example.com;
+    location / {
+        proxy_pass http://tsuru_myapp;
+        proxy_set_header Host $host;
+        proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+        proxy_set_header X-Forwarded-Proto $scheme;
+    }
+}
+
+server {
+    listen 80;
+    server_name myapp.io;
+    location / {
+        proxy_pass http://tsuru_myapp;
+        proxy_set_header Host $host;
+        proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+        proxy_set_header X-Forwarded-Proto $scheme;
+    }
+}
+`)
+	c.Assert(s.reloads(c), check.Equals, 3)
+}
+
+func (s *S) TestRemoveBackendRemovesConfig(c *check.C) {
+	err := s.router.AddBackend("myapp")
+	c.Assert(err, check.IsNil)
+	err = s.router.RemoveBackend("myapp")
+	c.Assert(err, check.IsNil)
+	_, err = os.Stat(filepath.Join(s.configDir, "myapp.conf"))
+	c.Assert(os.IsNotExist(err), check.Equals, true)
+	c.Assert(s.reloads(c), check.Equals, 2)
+}
+
+func (s *S) TestReloadFailure(c *check.C) {
+	config.Set("routers:nginx:reload-command", "echo invalid config; exit 1")
+	r, err := router.Get("nginx")
+	c.Assert(err, check.IsNil)
+	err = r.AddBackend("myapp")
+	c.Assert(err, check.ErrorMatches, `(?s)\[router reload\] error running .*: invalid config.*`)
+	_, err = router.Retrieve("myapp")
+	c.Assert(err, check.Equals, router.ErrBackendNotFound)
+	_, err = s.router.getEntry("myapp")
+	c.Assert(err, check.Equals, router.ErrBackendNotFound)
+}
+
+func (s *S) TestCertificates(c *check.C) {
+	err := s.router.AddBackend("myapp")
+	c.Assert(err, check.IsNil)
+	err = s.router.AddRoute("myapp", &url.URL{Scheme: "http", Host: "10.0.0.1:8080"})
+	c.Assert(err, check.IsNil)
+	err = s.router.SetCName("myapp.io", "myapp")
+	c.Assert(err, check.IsNil)
+	err = s.router.AddCertificate("myapp.io", "mycert", "mykey")
+	c.Assert(err, check.IsNil)
+	cert, err := s.router.GetCertificate("myapp.io")
+	c.Assert(err, check.IsNil)
+	c.Assert(cert, check.Equals, "mycert")
+	data, err := ioutil.ReadFile(filepath.Join(s.configDir, "certs", "myapp.io.crt"))
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Equals, "mycert")
+	data, err = ioutil.ReadFile(filepath.Join(s.configDir, "certs", "myapp.io.key"))
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Equals, "mykey")
+	conf := s.readConfig(c, "myapp")
+	c.Assert(strings.Contains(conf, fmt.Sprintf(`
+server {
+    listen 443 ssl;
+    server_name myapp.io;
+    ssl_certificate %[1]s/certs/myapp.io.crt;
+    ssl_certificate_key %[1]s/certs/myapp.io.key;
+    location / {
+        proxy_pass http://tsuru_myapp;`, s.configDir)), check.Equals, true)
+	err = s.router.RemoveCertificate("myapp.io")
+	c.Assert(err, check.IsNil)
+	_, err = s.router.GetCertificate("myapp.io")
+	c.Assert(err, check.Equals, router.ErrCertificateNotFound)
+	c.Assert(strings.Contains(s.readConfig(c, "myapp"), "ssl"), check.Equals, false)
+	_, err = os.Stat(filepath.Join(s.configDir, "certs", "myapp.io.key"))
+	c.Assert(os.IsNotExist(err), check.Equals, true)
+	err = s.router.RemoveCertificate("myapp.io")
+	c.Assert(err, check.Equals, router.ErrCertificateNotFound)
+}
+
+func (s *S) TestAddCertificateAppAddress(c *check.C) {
+	err := s.router.AddBackend("myapp")
+	c.Assert(err, check.IsNil)
+	err = s.router.AddCertificate("myapp.nginx.example.com", "mycert", "mykey")
+	c.Assert(err, check.IsNil)
+	c.Assert(strings.Contains(s.readConfig(c, "myapp"), "ssl_certificate "+s.configDir+"/certs/myapp.nginx.example.com.crt;"), check.Equals, true)
+}
+
+func (s *S) TestAddCertificateUnknownCName(c *check.C) {
+	err := s.router.AddCertificate("myapp.io", "mycert", "mykey")
+	c.Assert(err, check.Equals, router.ErrCNameNotFound)
+}
+
+func (s *S) TestSetHealthcheck(c *check.C) {
+	config.Set("routers:nginx:active-healthcheck", true)
+	r, err := router.Get("nginx")
+	c.Assert(err, check.IsNil)
+	err = r.AddBackend("myapp")
+	c.Assert(err, check.IsNil)
+	err = r.AddRoute("myapp", &url.URL{Scheme: "http", Host: "10.0.0.1:8080"})
+	c.Assert(err, check.IsNil)
+	err = r.(router.CustomHealthcheckRouter).SetHealthcheck("myapp", router.HealthcheckData{Path: "/hc", Status: 200, Body: "WORKING."})
+	c.Assert(err, check.IsNil)
+	conf := s.readConfig(c, "myapp")
+	c.Assert(strings.Contains(conf, `
+match tsuru_myapp_healthcheck {
+    status 200;
+    body ~ "WORKING\\.";
+}
+`), check.Equals, true)
+	c.Assert(strings.Contains(conf, "health_check uri=/hc match=tsuru_myapp_healthcheck;"), check.Equals, true)
+}
+
+func (s *S) TestSetHealthcheckWithoutActiveHealthcheck(c *check.C) {
+	err := s.router.AddBackend("myapp")
+	c.Assert(err, check.IsNil)
+	err = s.router.AddRoute("myapp", &url.URL{Scheme: "http", Host: "10.0.0.1:8080"})
+	c.Assert(err, check.IsNil)
+	err = s.router.SetHealthcheck("myapp", router.HealthcheckData{Path: "/hc", Status: 200})
+	c.Assert(err, check.IsNil)
+	c.Assert(strings.Contains(s.readConfig(c, "myapp"), "health_check"), check.Equals, false)
+	entry, err := s.router.getEntry("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(entry.Healthcheck, check.DeepEquals, router.HealthcheckData{Path: "/hc", Status: 200})
+}
+
+func (s *S) TestSetHealthcheckInvalidPath(c *check.C) {
+	err := s.router.AddBackend("myapp")
+	c.Assert(err, check.IsNil)
+	paths := []string{"hc", "/hc; return 200", "/hc {", "/hc}", "/h c", "/hc\n", "/$host"}
+	for _, path := range paths {
+		err = s.router.SetHealthcheck("myapp", router.HealthcheckData{Path: path})
+		c.Check(err, check.ErrorMatches, "invalid healthcheck path .*")
+	}
+	entry, err := s.router.getEntry("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(entry.Healthcheck, check.DeepEquals, router.HealthcheckData{})
+	err = s.router.SetHealthcheck("myapp", router.HealthcheckData{Path: "/status/hc?full=1&x=%20"})
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestRenderIgnoresInvalidHealthcheckPath(c *check.C) {
+	s.router.activeHealthcheck = true
+	entry := &backendEntry{
+		Name:        "myapp",
+		Routes:      []string{"10.0.0.1:8080"},
+		Healthcheck: router.HealthcheckData{Path: "/hc; return 200"},
+	}
+	conf, err := s.router.render(entry, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(strings.Contains(string(conf), "health_check"), check.Equals, false)
+}
+
+func (s *S) TestACMEChallengeRoute(c *check.C) {
+	err := s.router.AddBackend("myapp")
+	c.Assert(err, check.IsNil)
+	err = s.router.SetCName("myapp.io", "myapp")
+	c.Assert(err, check.IsNil)
+	err = s.router.AddACMEChallengeRoute("myapp.io", &url.URL{Scheme: "http", Host: "tsuru.example.com:8080"})
+	c.Assert(err, check.IsNil)
+	c.Assert(strings.Contains(s.readConfig(c, "myapp"), `
+    server_name myapp.io;
+    location /.well-known/acme-challenge/ {
+        proxy_pass http://tsuru.example.com:8080;
+        proxy_set_header Host $host;
+    }
+`), check.Equals, true)
+	err = s.router.RemoveACMEChallengeRoute("myapp.io")
+	c.Assert(err, check.IsNil)
+	c.Assert(strings.Contains(s.readConfig(c, "myapp"), "acme-challenge"), check.Equals, false)
+	err = s.router.AddACMEChallengeRoute("other.io", &url.URL{Scheme: "http", Host: "tsuru.example.com:8080"})
+	c.Assert(err, check.Equals, router.ErrCNameNotFound)
+}
+
+func (s *S) TestSwapRewritesConfig(c *check.C) {
+	err := s.router.AddBackend("app1")
+	c.Assert(err, check.IsNil)
+	err = s.router.AddRoute("app1", &url.URL{Scheme: "http", Host: "10.0.0.1:8080"})
+	c.Assert(err, check.IsNil)
+	err = s.router.AddBackend("app2")
+	c.Assert(err, check.IsNil)
+	err = s.router.AddRoute("app2", &url.URL{Scheme: "http", Host: "10.0.0.2:8080"})
+	c.Assert(err, check.IsNil)
+	err = s.router.Swap("app1", "app2", false)
+	c.Assert(err, check.IsNil)
+	conf := s.readConfig(c, "app1")
+	c.Assert(strings.Contains(conf, "server 10.0.0.2:8080;"), check.Equals, true)
+	c.Assert(strings.Contains(conf, "server_name app1.nginx.example.com;"), check.Equals, true)
+	conf = s.readConfig(c, "app2")
+	c.Assert(strings.Contains(conf, "server 10.0.0.1:8080;"), check.Equals, true)
+}
+
+func (s *S) TestHealthCheck(c *check.C) {
+	c.Assert(s.router.HealthCheck(), check.IsNil)
+	r := &nginxRouter{configDir: filepath.Join(s.configDir, "unknown")}
+	c.Assert(r.HealthCheck(), check.NotNil)
+}
@@ -0,0 +1,105 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rebuild
+
+import (
+	"sort"
+
+	"github.com/tsuru/tsuru/router"
+)
+
+// RoutesDrift holds the differences between the routes an app is expected to
+// have and the routes found in its router.
+type RoutesDrift struct {
+	App            string
+	MissingBackend bool
+	MissingRoutes  []string
+	StaleRoutes    []string
+	MissingCNames  []string
+	StaleCNames    []string
+}
+
+// Empty returns whether the router is consistent with the app.
+func (d *RoutesDrift) Empty() bool {
+	return !d.MissingBackend && len(d.MissingRoutes) == 0 && len(d.StaleRoutes) == 0 &&
+		len(d.MissingCNames) == 0 && len(d.StaleCNames) == 0
+}
+
+// CheckRoutes compares the routes of the app in one of its routers with the
+// expected ones, without changing the router. Cnames are only compared in the
+// main router of the app. Use RebuildRoutes to fix the differences.
+func CheckRoutes(app RebuildApp, routerName string) (*RoutesDrift, error) {
+	drift := RoutesDrift{App: app.GetName()}
+	_, err := router.Retrieve(app.GetName())
+	if err == router.ErrBackendNotFound {
+		drift.MissingBackend = true
+		return &drift, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	routers, err := app.GetRouters()
+	if err != nil {
+		return nil, err
+	}
+	main := len(routers) > 0 && routers[0].Name == routerName
+	r, err := router.Get(routerName)
+	if err != nil {
+		return nil, err
+	}
+	routes, err := r.Routes(app.GetName())
+	if err == router.ErrBackendNotFound {
+		drift.MissingBackend = true
+		return &drift, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	addresses, err := app.RoutableAddresses()
+	if err != nil {
+		return nil, err
+	}
+	expected := make(map[string]string, len(addresses))
+	for _, addr := range addresses {
+		expected[addr.Host] = addr.String()
+	}
+	for _, route := range routes {
+		if _, ok := expected[route.Host]; ok {
+			delete(expected, route.Host)
+		} else {
+			drift.StaleRoutes = append(drift.StaleRoutes, route.String())
+		}
+	}
+	for _, addr := range expected {
+		drift.MissingRoutes = append(drift.MissingRoutes, addr)
+	}
+	sort.Strings(drift.MissingRoutes)
+	sort.Strings(drift.StaleRoutes)
+	cnameRouter, ok := r.(router.CNameRouter)
+	if !main || !ok {
+		return &drift, nil
+	}
+	cnames, err := cnameRouter.CNames(app.GetName())
+	if err != nil {
+		return nil, err
+	}
+	expectedCNames := make(map[string]bool)
+	for _, cname := range app.GetCname() {
+		expectedCNames[cname] = true
+	}
+	for _, cname := range cnames {
+		if expectedCNames[cname.Host] {
+			delete(expectedCNames, cname.Host)
+		} else {
+			drift.StaleCNames = append(drift.StaleCNames, cname.Host)
+		}
+	}
+	for cname := range expectedCNames {
+		drift.MissingCNames = append(drift.MissingCNames, cname)
+	}
+	sort.Strings(drift.MissingCNames)
+	sort.Strings(drift.StaleCNames)
+	return &drift, nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rebuild_test
+
+import (
+	"net/url"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/provision/provisiontest"
+	"github.com/tsuru/tsuru/router"
+	"github.com/tsuru/tsuru/router/rebuild"
+	"github.com/tsuru/tsuru/router/routertest"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestCheckRoutes(c *check.C) {
+	a := app.App{Name: "my-test-app", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = provisiontest.ProvisionerInstance.AddUnits(&a, 2, "web", nil)
+	c.Assert(err, check.IsNil)
+	err = a.AddCName("my.cname.com", "other.cname.com")
+	c.Assert(err, check.IsNil)
+	drift, err := rebuild.CheckRoutes(&a, "fake")
+	c.Assert(err, check.IsNil)
+	c.Assert(drift.Empty(), check.Equals, true)
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	routertest.FakeRouter.RemoveRoute(a.Name, units[1].Address)
+	routertest.FakeRouter.AddRoute(a.Name, &url.URL{Scheme: "http", Host: "invalid:1234"})
+	routertest.FakeRouter.UnsetCName("other.cname.com", a.Name)
+	routertest.FakeRouter.SetCName("stale.cname.com", a.Name)
+	drift, err = rebuild.CheckRoutes(&a, "fake")
+	c.Assert(err, check.IsNil)
+	c.Assert(drift, check.DeepEquals, &rebuild.RoutesDrift{
+		App:           a.Name,
+		MissingRoutes: []string{units[1].Address.String()},
+		StaleRoutes:   []string{"http://invalid:1234"},
+		MissingCNames: []string{"other.cname.com"},
+		StaleCNames:   []string{"stale.cname.com"},
+	})
+	c.Assert(drift.Empty(), check.Equals, false)
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, "http://invalid:1234"), check.Equals, true)
+}
+
+func (s *S) TestCheckRoutesMissingBackend(c *check.C) {
+	a := app.App{Name: "my-test-app", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = routertest.FakeRouter.RemoveBackend(a.Name)
+	c.Assert(err, check.IsNil)
+	err = router.Remove(a.Name)
+	c.Assert(err, check.IsNil)
+	drift, err := rebuild.CheckRoutes(&a, "fake")
+	c.Assert(err, check.IsNil)
+	c.Assert(drift, check.DeepEquals, &rebuild.RoutesDrift{App: a.Name, MissingBackend: true})
+}
@@ -16,38 +16,71 @@ type RebuildRoutesResult struct {
 }
 
 type RebuildApp interface {
-	GetRouterOpts() map[string]string
 	GetName() string
 	GetCname() []string
-	Router() (router.Router, error)
+	GetRouters() ([]router.AppRouter, error)
 	RoutableAddresses() ([]url.URL, error)
 	UpdateAddr() error
 	InternalLock(string) (bool, error)
 	Unlock()
 }
 
+// RebuildRoutes ensures every router of the app has the app backend with the
+// routes of its units. Cnames are only set in the main router, which is the
+// first one. The result lists routes added or removed in any of the routers.
 func RebuildRoutes(app RebuildApp) (*RebuildRoutesResult, error) {
-	r, err := app.Router()
+	routers, err := app.GetRouters()
+	if err != nil {
+		return nil, err
+	}
+	var result RebuildRoutesResult
+	added := map[string]bool{}
+	removed := map[string]bool{}
+	for i, appRouter := range routers {
+		routerResult, err := rebuildRoutesInRouter(app, appRouter, i == 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, route := range routerResult.Added {
+			if !added[route] {
+				added[route] = true
+				result.Added = append(result.Added, route)
+			}
+		}
+		for _, route := range routerResult.Removed {
+			if !removed[route] {
+				removed[route] = true
+				result.Removed = append(result.Removed, route)
+			}
+		}
+	}
+	return &result, nil
+}
+
+func rebuildRoutesInRouter(app RebuildApp, appRouter router.AppRouter, main bool) (*RebuildRoutesResult, error) {
+	r, err := router.Get(appRouter.Name)
 	if err != nil {
 		return nil, err
 	}
 	if optsRouter, ok := r.(router.OptsRouter); ok {
-		err = optsRouter.AddBackendOpts(app.GetName(), app.GetRouterOpts())
+		err = optsRouter.AddBackendOpts(app.GetName(), appRouter.Opts)
 	} else {
 		err = r.AddBackend(app.GetName())
 	}
 	if err != nil && err != router.ErrBackendExists {
 		return nil, err
 	}
-	err = app.UpdateAddr()
-	if err != nil {
-		return nil, err
-	}
-	if cnameRouter, ok := r.(router.CNameRouter); ok {
-		for _, cname := range app.GetCname() {
-			err = cnameRouter.SetCName(cname, app.GetName())
-			if err != nil && err != router.ErrCNameExists {
-				return nil, err
+	if main {
+		err = app.UpdateAddr()
+		if err != nil {
+			return nil, err
+		}
+		if cnameRouter, ok := r.(router.CNameRouter); ok {
+			for _, cname := range app.GetCname() {
+				err = cnameRouter.SetCName(cname, app.GetName())
+				if err != nil && err != router.ErrCNameExists {
+					return nil, err
+				}
 			}
 		}
 	}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/tsuru/tsuru/app"
 	"github.com/tsuru/tsuru/provision/provisiontest"
+	"github.com/tsuru/tsuru/router"
 	"github.com/tsuru/tsuru/router/rebuild"
 	"github.com/tsuru/tsuru/router/routertest"
 	"gopkg.in/check.v1"
@@ -190,3 +191,29 @@ func (s *S) TestRebuildRoutesRecreatesCnames(c *check.C) {
 	c.Assert(routertest.FakeRouter.HasRoute(a.Name, units[0].Address.String()), check.Equals, true)
 	c.Assert(routertest.FakeRouter.HasCName("my.cname.com"), check.Equals, true)
 }
+
+func (s *S) TestRebuildRoutesMultipleRouters(c *check.C) {
+	a := app.App{Name: "my-test-app", TeamOwner: s.team.Name, Routers: []router.AppRouter{{Name: "fake"}, {Name: "fake-hc"}}}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.AddCName("my.cname.com")
+	c.Assert(err, check.IsNil)
+	err = provisiontest.ProvisionerInstance.AddUnits(&a, 2, "web", nil)
+	c.Assert(err, check.IsNil)
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	routertest.FakeRouter.RemoveRoute(a.Name, units[1].Address)
+	changes, err := rebuild.RebuildRoutes(&a)
+	c.Assert(err, check.IsNil)
+	sort.Strings(changes.Added)
+	expected := []string{units[0].Address.String(), units[1].Address.String()}
+	sort.Strings(expected)
+	c.Assert(changes.Added, check.DeepEquals, expected)
+	c.Assert(changes.Removed, check.IsNil)
+	for _, u := range units {
+		c.Assert(routertest.FakeRouter.HasRoute(a.Name, u.Address.String()), check.Equals, true)
+		c.Assert(routertest.HCRouter.HasRoute(a.Name, u.Address.String()), check.Equals, true)
+	}
+	c.Assert(routertest.FakeRouter.HasCNameFor(a.Name, "my.cname.com"), check.Equals, true)
+	c.Assert(routertest.HCRouter.HasCName("my.cname.com"), check.Equals, false)
+}
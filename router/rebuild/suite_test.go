@@ -66,6 +66,7 @@ func (s *S) SetUpTest(c *check.C) {
 	})
 	c.Assert(err, check.IsNil)
 	routertest.FakeRouter.Reset()
+	routertest.HCRouter.Reset()
 	provisiontest.ProvisionerInstance.Reset()
 	err = dbtest.ClearAllCollections(s.conn.Apps().Database)
 	c.Assert(err, check.IsNil)
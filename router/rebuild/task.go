@@ -90,16 +90,21 @@ func routesRebuildOrEnqueueOptionalLock(appName string, lock bool) {
 	if runRoutesRebuildOnce(appName, lock) {
 		return
 	}
-	q, err := queue.Queue()
+	err := EnqueueRoutesRebuild(appName)
 	if err != nil {
 		log.Errorf("unable to enqueue rebuild routes task: %s", err)
-		return
+	}
+}
+
+// EnqueueRoutesRebuild enqueues a task rebuilding the routes of the app,
+// without trying to rebuild them right away.
+func EnqueueRoutesRebuild(appName string) error {
+	q, err := queue.Queue()
+	if err != nil {
+		return err
 	}
 	_, err = q.Enqueue(routesRebuildTaskName, monsterqueue.JobParams{
 		"appName": appName,
 	})
-	if err != nil {
-		log.Errorf("unable to enqueue rebuild routes task: %s", err)
-		return
-	}
+	return err
 }
@@ -124,6 +124,31 @@ type TLSRouter interface {
 	GetCertificate(cname string) (string, error)
 }
 
+// ACMEChallengeRouter is a router able to send requests to the ACME HTTP-01
+// challenge path of a cname to an address other than the app's, allowing
+// tsuru to answer the challenges for certificates of the cname.
+type ACMEChallengeRouter interface {
+	AddACMEChallengeRoute(cname string, address *url.URL) error
+	RemoveACMEChallengeRoute(cname string) error
+}
+
+// RequestsRouter is a router able to report the rate of requests it sends to
+// each route of a backend.
+type RequestsRouter interface {
+	// RoutesRequests returns the requests per second sent to each route of
+	// the backend identified by name, keyed by the host of the route.
+	RoutesRequests(name string) (map[string]float64, error)
+}
+
+// AppRouter is one of the routers used by an app, along with the options
+// used when adding the app backend to it. Address is only filled when
+// requested, it's never stored.
+type AppRouter struct {
+	Name    string            `json:"name"`
+	Opts    map[string]string `json:"opts"`
+	Address string            `json:"address" bson:"-"`
+}
+
 type HealthcheckData struct {
 	Path   string
 	Status int
@@ -203,6 +228,26 @@ func Retrieve(appName string) (string, error) {
 	return data.Router, nil
 }
 
+// StoredAppNames returns the names of every app with a backend stored by the
+// routers, including apps that may no longer exist.
+func StoredAppNames() ([]string, error) {
+	coll, err := collection()
+	if err != nil {
+		return nil, err
+	}
+	defer coll.Close()
+	var entries []routerAppEntry
+	err = coll.Find(nil).Select(bson.M{"app": 1}).Sort("app").All(&entries)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		names[i] = e.App
+	}
+	return names, nil
+}
+
 func Remove(appName string) error {
 	coll, err := collection()
 	if err != nil {
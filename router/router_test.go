@@ -118,6 +118,18 @@ func (s *S) TestStoreUpdatesEntry(c *check.C) {
 	c.Assert(err, check.Equals, ErrBackendNotFound)
 }
 
+func (s *S) TestStoredAppNames(c *check.C) {
+	err := Store("appname2", "routername2", "fake")
+	c.Assert(err, check.IsNil)
+	defer Remove("appname2")
+	err = Store("appname", "routername", "fake")
+	c.Assert(err, check.IsNil)
+	defer Remove("appname")
+	names, err := StoredAppNames()
+	c.Assert(err, check.IsNil)
+	c.Assert(names, check.DeepEquals, []string{"appname", "appname2"})
+}
+
 func (s *S) TestRetrieveWithoutKind(c *check.C) {
 	err := Store("appname", "routername", "")
 	c.Assert(err, check.IsNil)
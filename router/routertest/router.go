@@ -19,9 +19,10 @@ var FakeRouter = newFakeRouter()
 var HCRouter = hcRouter{fakeRouter: newFakeRouter()}
 
 var TLSRouter = tlsRouter{
-	fakeRouter: newFakeRouter(),
-	Certs:      make(map[string]string),
-	Keys:       make(map[string]string),
+	fakeRouter:     newFakeRouter(),
+	Certs:          make(map[string]string),
+	Keys:           make(map[string]string),
+	ACMEChallenges: make(map[string]*url.URL),
 }
 
 var ErrForcedFailure = errors.New("Forced failure")
@@ -45,7 +46,7 @@ func createTLSRouter(name, prefix string) (router.Router, error) {
 }
 
 func newFakeRouter() fakeRouter {
-	return fakeRouter{cnames: make(map[string]string), backends: make(map[string][]string), failuresByIp: make(map[string]bool), healthcheck: make(map[string]router.HealthcheckData), mutex: &sync.Mutex{}}
+	return fakeRouter{cnames: make(map[string]string), backends: make(map[string][]string), failuresByIp: make(map[string]bool), healthcheck: make(map[string]router.HealthcheckData), weights: make(map[string][]router.BackendWeight), requests: make(map[string]map[string]float64), mutex: &sync.Mutex{}}
 }
 
 type fakeRouter struct {
@@ -53,6 +54,8 @@ type fakeRouter struct {
 	cnames       map[string]string
 	failuresByIp map[string]bool
 	healthcheck  map[string]router.HealthcheckData
+	weights      map[string][]router.BackendWeight
+	requests     map[string]map[string]float64
 	mutex        *sync.Mutex
 }
 
@@ -320,6 +323,7 @@ func (r *fakeRouter) UnsetCName(cname, name string) error {
 		return router.ErrCNameNotFound
 	}
 	delete(r.cnames, cname)
+	delete(r.weights, cname)
 	return nil
 }
 
@@ -343,6 +347,8 @@ func (r *fakeRouter) Reset() {
 	r.failuresByIp = make(map[string]bool)
 	r.cnames = make(map[string]string)
 	r.healthcheck = make(map[string]router.HealthcheckData)
+	r.weights = make(map[string][]router.BackendWeight)
+	r.requests = make(map[string]map[string]float64)
 }
 
 func (r *fakeRouter) Routes(name string) ([]*url.URL, error) {
@@ -364,6 +370,69 @@ func (r *fakeRouter) Swap(backend1, backend2 string, cnameOnly bool) error {
 	return router.Swap(r, backend1, backend2, cnameOnly)
 }
 
+func (r *fakeRouter) SetCNameWeights(name, cname string, weights []router.BackendWeight) error {
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.cnames[cname] != backendName {
+		return router.ErrCNameNotFound
+	}
+	if len(weights) == 0 {
+		delete(r.weights, cname)
+		return nil
+	}
+	err = router.ValidateWeights(weights)
+	if err != nil {
+		return err
+	}
+	r.weights[cname] = weights
+	return nil
+}
+
+func (r *fakeRouter) CNamesWeights(name string) (map[string][]router.BackendWeight, error) {
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return nil, err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	result := map[string][]router.BackendWeight{}
+	for cname, weights := range r.weights {
+		if r.cnames[cname] == backendName {
+			result[cname] = weights
+		}
+	}
+	return result, nil
+}
+
+// SetRoutesRequests sets the requests per second reported for the routes of
+// the backend, keyed by the host of the route.
+func (r *fakeRouter) SetRoutesRequests(name string, requests map[string]float64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.requests[name] = requests
+}
+
+func (r *fakeRouter) RoutesRequests(name string) (map[string]float64, error) {
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return nil, err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.backends[backendName]; !ok {
+		return nil, router.ErrBackendNotFound
+	}
+	result := map[string]float64{}
+	for route, value := range r.requests[backendName] {
+		result[route] = value
+	}
+	return result, nil
+}
+
 type hcRouter struct {
 	fakeRouter
 	err error
@@ -398,8 +467,9 @@ func (r *fakeRouter) SetHealthcheck(name string, data router.HealthcheckData) er
 
 type tlsRouter struct {
 	fakeRouter
-	Certs map[string]string
-	Keys  map[string]string
+	Certs          map[string]string
+	Keys           map[string]string
+	ACMEChallenges map[string]*url.URL
 }
 
 func (r *tlsRouter) AddCertificate(cname, certificate, key string) error {
@@ -421,3 +491,13 @@ func (r *tlsRouter) GetCertificate(cname string) (string, error) {
 	}
 	return data, nil
 }
+
+func (r *tlsRouter) AddACMEChallengeRoute(cname string, address *url.URL) error {
+	r.ACMEChallenges[cname] = address
+	return nil
+}
+
+func (r *tlsRouter) RemoveACMEChallengeRoute(cname string) error {
+	delete(r.ACMEChallenges, cname)
+	return nil
+}
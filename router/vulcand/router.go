@@ -13,6 +13,7 @@ import (
 	"strings"
 
 	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/acme"
 	"github.com/tsuru/tsuru/hc"
 	"github.com/tsuru/tsuru/router"
 	"github.com/vulcand/route"
@@ -29,9 +30,10 @@ func init() {
 }
 
 type vulcandRouter struct {
-	client *api.Client
-	prefix string
-	domain string
+	client        *api.Client
+	prefix        string
+	domain        string
+	tlsListenAddr string
 }
 
 func createRouter(routerName, configPrefix string) (router.Router, error) {
@@ -43,11 +45,13 @@ func createRouter(routerName, configPrefix string) (router.Router, error) {
 	if err != nil {
 		return nil, err
 	}
+	tlsListenAddr, _ := config.GetString(configPrefix + ":tls-listen-address")
 	client := api.NewClient(vURL, registry.GetRegistry())
 	vRouter := &vulcandRouter{
-		client: client,
-		prefix: configPrefix,
-		domain: domain,
+		client:        client,
+		prefix:        configPrefix,
+		domain:        domain,
+		tlsListenAddr: tlsListenAddr,
 	}
 	return vRouter, nil
 }
@@ -64,6 +68,18 @@ func (r *vulcandRouter) backendName(app string) string {
 	return fmt.Sprintf("tsuru_%s", app)
 }
 
+func (r *vulcandRouter) weightedBackendName(cname string) string {
+	return fmt.Sprintf("tsuru_weighted_%s", cname)
+}
+
+func (r *vulcandRouter) acmeChallengeName(cname string) string {
+	return fmt.Sprintf("tsuru_acme_%s", cname)
+}
+
+func (r *vulcandRouter) tlsListenerName() string {
+	return "tsuru_https"
+}
+
 func (r *vulcandRouter) serverName(address string) string {
 	return fmt.Sprintf("tsuru_%x", md5.Sum([]byte(address)))
 }
@@ -108,7 +124,12 @@ func (r *vulcandRouter) AddBackend(name string) error {
 	return router.Store(name, name, routerName)
 }
 
-func (r *vulcandRouter) RemoveBackend(name string) error {
+func (r *vulcandRouter) RemoveBackend(name string) (err error) {
+	defer func() {
+		if err == nil {
+			err = r.removeWeights(name)
+		}
+	}()
 	usedName, err := router.Retrieve(name)
 	if err != nil {
 		return err
@@ -156,7 +177,12 @@ func (r *vulcandRouter) RemoveBackend(name string) error {
 	return nil
 }
 
-func (r *vulcandRouter) AddRoute(name string, address *url.URL) error {
+func (r *vulcandRouter) AddRoute(name string, address *url.URL) (err error) {
+	defer func() {
+		if err == nil {
+			err = r.updateWeightedCNames(name)
+		}
+	}()
 	usedName, err := router.Retrieve(name)
 	if err != nil {
 		return err
@@ -179,7 +205,12 @@ func (r *vulcandRouter) AddRoute(name string, address *url.URL) error {
 	return nil
 }
 
-func (r *vulcandRouter) AddRoutes(name string, addresses []*url.URL) error {
+func (r *vulcandRouter) AddRoutes(name string, addresses []*url.URL) (err error) {
+	defer func() {
+		if err == nil {
+			err = r.updateWeightedCNames(name)
+		}
+	}()
 	usedName, err := router.Retrieve(name)
 	if err != nil {
 		return err
@@ -201,7 +232,12 @@ func (r *vulcandRouter) AddRoutes(name string, addresses []*url.URL) error {
 	return nil
 }
 
-func (r *vulcandRouter) RemoveRoute(name string, address *url.URL) error {
+func (r *vulcandRouter) RemoveRoute(name string, address *url.URL) (err error) {
+	defer func() {
+		if err == nil {
+			err = r.updateWeightedCNames(name)
+		}
+	}()
 	usedName, err := router.Retrieve(name)
 	if err != nil {
 		return err
@@ -220,7 +256,12 @@ func (r *vulcandRouter) RemoveRoute(name string, address *url.URL) error {
 	return nil
 }
 
-func (r *vulcandRouter) RemoveRoutes(name string, addresses []*url.URL) error {
+func (r *vulcandRouter) RemoveRoutes(name string, addresses []*url.URL) (err error) {
+	defer func() {
+		if err == nil {
+			err = r.updateWeightedCNames(name)
+		}
+	}()
 	usedName, err := router.Retrieve(name)
 	if err != nil {
 		return err
@@ -252,10 +293,18 @@ func (r *vulcandRouter) CNames(name string) ([]*url.URL, error) {
 		return nil, err
 	}
 	address = r.backendName(address)
+	entries, err := router.RetrieveWeights(name)
+	if err != nil {
+		return nil, err
+	}
+	weighted := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		weighted[r.frontendName(e.CName)] = true
+	}
 	urls := []*url.URL{}
 	for _, f := range fes {
 		host := strings.Replace(f.Id, "tsuru_", "", 1)
-		if f.BackendId == backendName && f.Id != address {
+		if (f.BackendId == backendName || weighted[f.Id]) && f.Id != address {
 			urls = append(urls, &url.URL{Host: host})
 		}
 	}
@@ -300,7 +349,13 @@ func (r *vulcandRouter) UnsetCName(cname, _ string) error {
 		}
 		return &router.RouterError{Err: err, Op: "unset-cname"}
 	}
-	return nil
+	err = r.client.DeleteBackend(engine.BackendKey{Id: r.weightedBackendName(cname)})
+	if err != nil {
+		if _, ok := err.(*engine.NotFoundError); !ok {
+			return &router.RouterError{Err: err, Op: "unset-cname"}
+		}
+	}
+	return router.StoreWeights(cname, "", nil)
 }
 
 func (r *vulcandRouter) Addr(name string) (string, error) {
@@ -339,6 +394,274 @@ func (r *vulcandRouter) Routes(name string) ([]*url.URL, error) {
 	return routes, nil
 }
 
+func (r *vulcandRouter) SetCNameWeights(name, cname string, weights []router.BackendWeight) error {
+	usedName, err := router.Retrieve(name)
+	if err != nil {
+		return err
+	}
+	frontend, err := r.client.GetFrontend(engine.FrontendKey{Id: r.frontendName(cname)})
+	if err != nil {
+		if _, ok := err.(*engine.NotFoundError); ok {
+			return router.ErrCNameNotFound
+		}
+		return &router.RouterError{Err: err, Op: "set-weights"}
+	}
+	if frontend.BackendId != r.backendName(usedName) && frontend.BackendId != r.weightedBackendName(cname) {
+		return router.ErrCNameNotFound
+	}
+	if len(weights) > 0 {
+		err = router.ValidateWeights(weights)
+		if err != nil {
+			return err
+		}
+	}
+	err = router.StoreWeights(cname, name, weights)
+	if err != nil {
+		return err
+	}
+	return r.writeCNameRoutes(router.WeightsEntry{CName: cname, Backend: name, Weights: weights})
+}
+
+func (r *vulcandRouter) CNamesWeights(name string) (map[string][]router.BackendWeight, error) {
+	entries, err := router.RetrieveWeights(name)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string][]router.BackendWeight, len(entries))
+	for _, e := range entries {
+		result[e.CName] = e.Weights
+	}
+	return result, nil
+}
+
+// writeCNameRoutes points the frontend of a weighted cname to a dedicated
+// backend holding the servers of every weighted backend, repeated according
+// to their weights. Cnames without weights are pointed back to the backend
+// owning them.
+func (r *vulcandRouter) writeCNameRoutes(entry router.WeightsEntry) error {
+	usedName, err := router.Retrieve(entry.Backend)
+	if err != nil {
+		return err
+	}
+	frontend, err := r.client.GetFrontend(engine.FrontendKey{Id: r.frontendName(entry.CName)})
+	if err != nil {
+		return &router.RouterError{Err: err, Op: "set-weights"}
+	}
+	weightedKey := engine.BackendKey{Id: r.weightedBackendName(entry.CName)}
+	if len(entry.Weights) == 0 {
+		frontend.BackendId = r.backendName(usedName)
+		err = r.client.UpsertFrontend(*frontend, engine.NoTTL)
+		if err != nil {
+			return &router.RouterError{Err: err, Op: "set-weights"}
+		}
+		err = r.client.DeleteBackend(weightedKey)
+		if err != nil {
+			if _, ok := err.(*engine.NotFoundError); !ok {
+				return &router.RouterError{Err: err, Op: "set-weights"}
+			}
+		}
+		return nil
+	}
+	backend, err := engine.NewHTTPBackend(weightedKey.Id, engine.HTTPBackendSettings{})
+	if err != nil {
+		return &router.RouterError{Err: err, Op: "set-weights"}
+	}
+	err = r.client.UpsertBackend(*backend)
+	if err != nil {
+		return &router.RouterError{Err: err, Op: "set-weights"}
+	}
+	backendRoutes := make(map[string][]*url.URL, len(entry.Weights))
+	for _, w := range entry.Weights {
+		routes, err := r.Routes(w.Backend)
+		if err != nil && !isBackendNotFound(err) {
+			return err
+		}
+		backendRoutes[w.Backend] = routes
+	}
+	wanted := map[string]bool{}
+	for i, route := range router.WeightedRoutes(entry.Weights, backendRoutes) {
+		id := fmt.Sprintf("%s_%d", r.serverName(route.Host), i)
+		server, err := engine.NewServer(id, route.String())
+		if err != nil {
+			return &router.RouterError{Err: err, Op: "set-weights"}
+		}
+		err = r.client.UpsertServer(weightedKey, *server, engine.NoTTL)
+		if err != nil {
+			return &router.RouterError{Err: err, Op: "set-weights"}
+		}
+		wanted[id] = true
+	}
+	servers, err := r.client.GetServers(weightedKey)
+	if err != nil {
+		return &router.RouterError{Err: err, Op: "set-weights"}
+	}
+	for _, server := range servers {
+		if wanted[server.Id] {
+			continue
+		}
+		err = r.client.DeleteServer(engine.ServerKey{Id: server.Id, BackendKey: weightedKey})
+		if err != nil {
+			return &router.RouterError{Err: err, Op: "set-weights"}
+		}
+	}
+	if frontend.BackendId != weightedKey.Id {
+		frontend.BackendId = weightedKey.Id
+		err = r.client.UpsertFrontend(*frontend, engine.NoTTL)
+		if err != nil {
+			return &router.RouterError{Err: err, Op: "set-weights"}
+		}
+	}
+	return nil
+}
+
+func isBackendNotFound(err error) bool {
+	if err == router.ErrBackendNotFound {
+		return true
+	}
+	if routerErr, ok := err.(*router.RouterError); ok {
+		_, ok = routerErr.Err.(*engine.NotFoundError)
+		return ok
+	}
+	return false
+}
+
+func (r *vulcandRouter) updateWeightedCNames(name string) error {
+	entries, err := router.WeightsUsingBackend(name)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		err = r.writeCNameRoutes(e)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *vulcandRouter) removeWeights(name string) error {
+	entries, err := router.RetrieveWeights(name)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		err = r.UnsetCName(e.CName, name)
+		if err != nil && err != router.ErrCNameNotFound {
+			return err
+		}
+	}
+	return r.updateWeightedCNames(name)
+}
+
+// AddCertificate stores the certificate as the key pair of the vulcand host
+// named after the cname. vulcand serves host key pairs on its HTTPS
+// listeners using SNI, so when a TLS listen address is configured the router
+// also makes sure an HTTPS listener exists on it.
+func (r *vulcandRouter) AddCertificate(cname, cert, key string) error {
+	if r.tlsListenAddr != "" {
+		listener, err := engine.NewListener(r.tlsListenerName(), engine.HTTPS, engine.TCP, r.tlsListenAddr, "", nil)
+		if err != nil {
+			return &router.RouterError{Err: err, Op: "add-certificate"}
+		}
+		err = r.client.UpsertListener(*listener)
+		if err != nil {
+			return &router.RouterError{Err: err, Op: "add-certificate"}
+		}
+	}
+	keyPair, err := engine.NewKeyPair([]byte(cert), []byte(key))
+	if err != nil {
+		return &router.RouterError{Err: err, Op: "add-certificate"}
+	}
+	host, err := engine.NewHost(cname, engine.HostSettings{KeyPair: keyPair})
+	if err != nil {
+		return &router.RouterError{Err: err, Op: "add-certificate"}
+	}
+	err = r.client.UpsertHost(*host)
+	if err != nil {
+		return &router.RouterError{Err: err, Op: "add-certificate"}
+	}
+	return nil
+}
+
+func (r *vulcandRouter) RemoveCertificate(cname string) error {
+	err := r.client.DeleteHost(engine.HostKey{Name: cname})
+	if err != nil {
+		if _, ok := err.(*engine.NotFoundError); ok {
+			return router.ErrCertificateNotFound
+		}
+		return &router.RouterError{Err: err, Op: "remove-certificate"}
+	}
+	return nil
+}
+
+func (r *vulcandRouter) GetCertificate(cname string) (string, error) {
+	host, err := r.client.GetHost(engine.HostKey{Name: cname})
+	if err != nil {
+		if _, ok := err.(*engine.NotFoundError); ok {
+			return "", router.ErrCertificateNotFound
+		}
+		return "", &router.RouterError{Err: err, Op: "get-certificate"}
+	}
+	if host.Settings.KeyPair == nil {
+		return "", router.ErrCertificateNotFound
+	}
+	return string(host.Settings.KeyPair.Cert), nil
+}
+
+// AddACMEChallengeRoute adds a frontend matching only the ACME challenge path
+// of the cname, pointing to a backend with address as its single server.
+func (r *vulcandRouter) AddACMEChallengeRoute(cname string, address *url.URL) error {
+	name := r.acmeChallengeName(cname)
+	backend, err := engine.NewHTTPBackend(name, engine.HTTPBackendSettings{})
+	if err != nil {
+		return &router.RouterError{Err: err, Op: "add-acme-challenge-route"}
+	}
+	err = r.client.UpsertBackend(*backend)
+	if err != nil {
+		return &router.RouterError{Err: err, Op: "add-acme-challenge-route"}
+	}
+	server, err := engine.NewServer(r.serverName(address.Host), address.String())
+	if err != nil {
+		return &router.RouterError{Err: err, Op: "add-acme-challenge-route"}
+	}
+	err = r.client.UpsertServer(engine.BackendKey{Id: name}, *server, engine.NoTTL)
+	if err != nil {
+		return &router.RouterError{Err: err, Op: "add-acme-challenge-route"}
+	}
+	frontend, err := engine.NewHTTPFrontend(
+		route.NewMux(),
+		name,
+		backend.Id,
+		fmt.Sprintf(`Host(%q) && PathRegexp(%q)`, cname, acme.ChallengePath+".*"),
+		engine.HTTPFrontendSettings{},
+	)
+	if err != nil {
+		return &router.RouterError{Err: err, Op: "add-acme-challenge-route"}
+	}
+	err = r.client.UpsertFrontend(*frontend, engine.NoTTL)
+	if err != nil {
+		return &router.RouterError{Err: err, Op: "add-acme-challenge-route"}
+	}
+	return nil
+}
+
+func (r *vulcandRouter) RemoveACMEChallengeRoute(cname string) error {
+	name := r.acmeChallengeName(cname)
+	err := r.client.DeleteFrontend(engine.FrontendKey{Id: name})
+	if err != nil {
+		if _, ok := err.(*engine.NotFoundError); !ok {
+			return &router.RouterError{Err: err, Op: "remove-acme-challenge-route"}
+		}
+	}
+	err = r.client.DeleteBackend(engine.BackendKey{Id: name})
+	if err != nil {
+		if _, ok := err.(*engine.NotFoundError); !ok {
+			return &router.RouterError{Err: err, Op: "remove-acme-challenge-route"}
+		}
+	}
+	return nil
+}
+
 func (r *vulcandRouter) StartupMessage() (string, error) {
 	message := fmt.Sprintf("vulcand router %q with API at %q", r.domain, r.client.Addr)
 	return message, nil
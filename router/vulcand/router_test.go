@@ -8,6 +8,7 @@ package vulcand
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -417,6 +418,90 @@ func (s *S) TestSwap(c *check.C) {
 	c.Assert(servers2[0].URL, check.Equals, u1.String())
 }
 
+func (s *S) TestSetCNameWeights(c *check.C) {
+	vRouter, err := router.Get("vulcand")
+	c.Assert(err, check.IsNil)
+	err = vRouter.AddBackend("myapp1")
+	c.Assert(err, check.IsNil)
+	err = vRouter.AddBackend("myapp2")
+	c.Assert(err, check.IsNil)
+	u1, _ := url.Parse("http://1.1.1.1:111")
+	u2, _ := url.Parse("http://2.2.2.2:222")
+	err = vRouter.AddRoute("myapp1", u1)
+	c.Assert(err, check.IsNil)
+	err = vRouter.AddRoute("myapp2", u2)
+	c.Assert(err, check.IsNil)
+	err = vRouter.(router.CNameRouter).SetCName("myapp.cname.example.com", "myapp1")
+	c.Assert(err, check.IsNil)
+	wRouter, ok := vRouter.(router.WeightedRouter)
+	c.Assert(ok, check.Equals, true)
+	weights := []router.BackendWeight{{Backend: "myapp1", Weight: 75}, {Backend: "myapp2", Weight: 25}}
+	err = wRouter.SetCNameWeights("myapp1", "myapp.cname.example.com", weights)
+	c.Assert(err, check.IsNil)
+	cnameFrontend, err := s.engine.GetFrontend(engine.FrontendKey{Id: "tsuru_myapp.cname.example.com"})
+	c.Assert(err, check.IsNil)
+	c.Assert(cnameFrontend.BackendId, check.Equals, "tsuru_weighted_myapp.cname.example.com")
+	servers, err := s.engine.GetServers(engine.BackendKey{Id: "tsuru_weighted_myapp.cname.example.com"})
+	c.Assert(err, check.IsNil)
+	c.Assert(servers, check.HasLen, 4)
+	count := map[string]int{}
+	for _, server := range servers {
+		count[server.URL]++
+	}
+	c.Assert(count, check.DeepEquals, map[string]int{u1.String(): 3, u2.String(): 1})
+	cnames, err := vRouter.(router.CNameRouter).CNames("myapp1")
+	c.Assert(err, check.IsNil)
+	c.Assert(cnames, check.DeepEquals, []*url.URL{{Host: "myapp.cname.example.com"}})
+	result, err := wRouter.CNamesWeights("myapp1")
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, map[string][]router.BackendWeight{"myapp.cname.example.com": weights})
+	err = wRouter.SetCNameWeights("myapp1", "myapp.cname.example.com", nil)
+	c.Assert(err, check.IsNil)
+	cnameFrontend, err = s.engine.GetFrontend(engine.FrontendKey{Id: "tsuru_myapp.cname.example.com"})
+	c.Assert(err, check.IsNil)
+	c.Assert(cnameFrontend.BackendId, check.Equals, "tsuru_myapp1")
+	backends, err := s.engine.GetBackends()
+	c.Assert(err, check.IsNil)
+	c.Assert(backends, check.HasLen, 2)
+}
+
+func (s *S) TestSetCNameWeightsUpdatedOnAddRoute(c *check.C) {
+	vRouter, err := router.Get("vulcand")
+	c.Assert(err, check.IsNil)
+	err = vRouter.AddBackend("myapp1")
+	c.Assert(err, check.IsNil)
+	err = vRouter.AddBackend("myapp2")
+	c.Assert(err, check.IsNil)
+	u1, _ := url.Parse("http://1.1.1.1:111")
+	err = vRouter.AddRoute("myapp1", u1)
+	c.Assert(err, check.IsNil)
+	err = vRouter.(router.CNameRouter).SetCName("myapp.cname.example.com", "myapp1")
+	c.Assert(err, check.IsNil)
+	weights := []router.BackendWeight{{Backend: "myapp1", Weight: 50}, {Backend: "myapp2", Weight: 50}}
+	err = vRouter.(router.WeightedRouter).SetCNameWeights("myapp1", "myapp.cname.example.com", weights)
+	c.Assert(err, check.IsNil)
+	servers, err := s.engine.GetServers(engine.BackendKey{Id: "tsuru_weighted_myapp.cname.example.com"})
+	c.Assert(err, check.IsNil)
+	c.Assert(servers, check.HasLen, 1)
+	u2, _ := url.Parse("http://2.2.2.2:222")
+	err = vRouter.AddRoute("myapp2", u2)
+	c.Assert(err, check.IsNil)
+	servers, err = s.engine.GetServers(engine.BackendKey{Id: "tsuru_weighted_myapp.cname.example.com"})
+	c.Assert(err, check.IsNil)
+	c.Assert(servers, check.HasLen, 2)
+	c.Assert(servers[0].URL, check.Equals, u1.String())
+	c.Assert(servers[1].URL, check.Equals, u2.String())
+}
+
+func (s *S) TestSetCNameWeightsCNameNotFound(c *check.C) {
+	vRouter, err := router.Get("vulcand")
+	c.Assert(err, check.IsNil)
+	err = vRouter.AddBackend("myapp")
+	c.Assert(err, check.IsNil)
+	err = vRouter.(router.WeightedRouter).SetCNameWeights("myapp", "myapp.cname.example.com", nil)
+	c.Assert(err, check.Equals, router.ErrCNameNotFound)
+}
+
 func (s *S) TestRoutes(c *check.C) {
 	vRouter, err := router.Get("vulcand")
 	c.Assert(err, check.IsNil)
@@ -433,6 +518,107 @@ func (s *S) TestRoutes(c *check.C) {
 	c.Assert(routes, check.DeepEquals, []*url.URL{u1, u2})
 }
 
+func (s *S) TestAddCertificate(c *check.C) {
+	cert, err := ioutil.ReadFile("testdata/certificate.crt")
+	c.Assert(err, check.IsNil)
+	key, err := ioutil.ReadFile("testdata/private.key")
+	c.Assert(err, check.IsNil)
+	vRouter, err := router.Get("vulcand")
+	c.Assert(err, check.IsNil)
+	tlsRouter, ok := vRouter.(router.TLSRouter)
+	c.Assert(ok, check.Equals, true)
+	err = tlsRouter.AddCertificate("myapp.io", string(cert), string(key))
+	c.Assert(err, check.IsNil)
+	host, err := s.engine.GetHost(engine.HostKey{Name: "myapp.io"})
+	c.Assert(err, check.IsNil)
+	c.Assert(string(host.Settings.KeyPair.Cert), check.Equals, string(cert))
+	c.Assert(string(host.Settings.KeyPair.Key), check.Equals, string(key))
+	listeners, err := s.engine.GetListeners()
+	c.Assert(err, check.IsNil)
+	c.Assert(listeners, check.HasLen, 0)
+	storedCert, err := tlsRouter.GetCertificate("myapp.io")
+	c.Assert(err, check.IsNil)
+	c.Assert(storedCert, check.Equals, string(cert))
+}
+
+func (s *S) TestAddCertificateWithTLSListener(c *check.C) {
+	config.Set("routers:vulcand:tls-listen-address", "0.0.0.0:8443")
+	defer config.Unset("routers:vulcand:tls-listen-address")
+	cert, err := ioutil.ReadFile("testdata/certificate.crt")
+	c.Assert(err, check.IsNil)
+	key, err := ioutil.ReadFile("testdata/private.key")
+	c.Assert(err, check.IsNil)
+	vRouter, err := createRouter("vulcand", "routers:vulcand")
+	c.Assert(err, check.IsNil)
+	err = vRouter.(router.TLSRouter).AddCertificate("myapp.io", string(cert), string(key))
+	c.Assert(err, check.IsNil)
+	listener, err := s.engine.GetListener(engine.ListenerKey{Id: "tsuru_https"})
+	c.Assert(err, check.IsNil)
+	c.Assert(listener.Protocol, check.Equals, engine.HTTPS)
+	c.Assert(listener.Address, check.DeepEquals, engine.Address{Network: engine.TCP, Address: "0.0.0.0:8443"})
+}
+
+func (s *S) TestAddCertificateInvalid(c *check.C) {
+	vRouter, err := router.Get("vulcand")
+	c.Assert(err, check.IsNil)
+	err = vRouter.(router.TLSRouter).AddCertificate("myapp.io", "cert", "key")
+	c.Assert(err, check.NotNil)
+	_, err = s.engine.GetHost(engine.HostKey{Name: "myapp.io"})
+	c.Assert(err, check.FitsTypeOf, &engine.NotFoundError{})
+}
+
+func (s *S) TestRemoveCertificate(c *check.C) {
+	cert, err := ioutil.ReadFile("testdata/certificate.crt")
+	c.Assert(err, check.IsNil)
+	key, err := ioutil.ReadFile("testdata/private.key")
+	c.Assert(err, check.IsNil)
+	vRouter, err := router.Get("vulcand")
+	c.Assert(err, check.IsNil)
+	tlsRouter := vRouter.(router.TLSRouter)
+	err = tlsRouter.AddCertificate("myapp.io", string(cert), string(key))
+	c.Assert(err, check.IsNil)
+	err = tlsRouter.RemoveCertificate("myapp.io")
+	c.Assert(err, check.IsNil)
+	_, err = s.engine.GetHost(engine.HostKey{Name: "myapp.io"})
+	c.Assert(err, check.FitsTypeOf, &engine.NotFoundError{})
+	err = tlsRouter.RemoveCertificate("myapp.io")
+	c.Assert(err, check.Equals, router.ErrCertificateNotFound)
+}
+
+func (s *S) TestGetCertificateNotFound(c *check.C) {
+	vRouter, err := router.Get("vulcand")
+	c.Assert(err, check.IsNil)
+	cert, err := vRouter.(router.TLSRouter).GetCertificate("myapp.io")
+	c.Assert(err, check.Equals, router.ErrCertificateNotFound)
+	c.Assert(cert, check.Equals, "")
+}
+
+func (s *S) TestAddACMEChallengeRoute(c *check.C) {
+	vRouter, err := router.Get("vulcand")
+	c.Assert(err, check.IsNil)
+	challengeRouter, ok := vRouter.(router.ACMEChallengeRouter)
+	c.Assert(ok, check.Equals, true)
+	addr, _ := url.Parse("http://tsuru.example.com:8080")
+	err = challengeRouter.AddACMEChallengeRoute("myapp.io", addr)
+	c.Assert(err, check.IsNil)
+	frontend, err := s.engine.GetFrontend(engine.FrontendKey{Id: "tsuru_acme_myapp.io"})
+	c.Assert(err, check.IsNil)
+	c.Assert(frontend.BackendId, check.Equals, "tsuru_acme_myapp.io")
+	c.Assert(frontend.Route, check.Equals, `Host("myapp.io") && PathRegexp("/.well-known/acme-challenge/.*")`)
+	servers, err := s.engine.GetServers(engine.BackendKey{Id: "tsuru_acme_myapp.io"})
+	c.Assert(err, check.IsNil)
+	c.Assert(servers, check.HasLen, 1)
+	c.Assert(servers[0].URL, check.Equals, "http://tsuru.example.com:8080")
+	err = challengeRouter.RemoveACMEChallengeRoute("myapp.io")
+	c.Assert(err, check.IsNil)
+	_, err = s.engine.GetFrontend(engine.FrontendKey{Id: "tsuru_acme_myapp.io"})
+	c.Assert(err, check.FitsTypeOf, &engine.NotFoundError{})
+	_, err = s.engine.GetBackend(engine.BackendKey{Id: "tsuru_acme_myapp.io"})
+	c.Assert(err, check.FitsTypeOf, &engine.NotFoundError{})
+	err = challengeRouter.RemoveACMEChallengeRoute("myapp.io")
+	c.Assert(err, check.IsNil)
+}
+
 func (s *S) TestStartupMessage(c *check.C) {
 	got, err := router.Get("vulcand")
 	c.Assert(err, check.IsNil)
@@ -0,0 +1,179 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"net/url"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/db/storage"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var ErrInvalidWeights = errors.New("weights must be between 0 and 100 and their sum must be 100")
+
+// BackendWeight is the percentage of the traffic sent to a backend.
+type BackendWeight struct {
+	Backend string
+	Weight  int
+}
+
+// WeightedRouter is a router able to split the traffic sent to a cname of a
+// backend among multiple backends, allowing A/B tests and gradual migrations
+// between apps.
+type WeightedRouter interface {
+	// SetCNameWeights sets the weights of the backends receiving the traffic
+	// of a cname of the backend identified by name. Empty weights route the
+	// cname back to the backend only.
+	SetCNameWeights(name, cname string, weights []BackendWeight) error
+
+	// CNamesWeights returns the weights of the weighted cnames of a backend.
+	CNamesWeights(name string) (map[string][]BackendWeight, error)
+}
+
+// WeightsEntry holds the weights set for a cname owned by a backend.
+type WeightsEntry struct {
+	CName   string `bson:"_id"`
+	Backend string
+	Weights []BackendWeight
+}
+
+func weightsCollection() (*storage.Collection, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	coll := conn.Collection("routers_weights")
+	err = coll.EnsureIndex(mgo.Index{Key: []string{"weights.backend"}})
+	if err != nil {
+		return nil, err
+	}
+	return coll, nil
+}
+
+// ValidateWeights checks that weights are valid percentages, don't repeat
+// backends and sum to 100.
+func ValidateWeights(weights []BackendWeight) error {
+	var sum int
+	seen := map[string]bool{}
+	for _, w := range weights {
+		if w.Backend == "" {
+			return errors.New("weight backend is required")
+		}
+		if seen[w.Backend] {
+			return errors.Errorf("duplicated weight for backend %q", w.Backend)
+		}
+		seen[w.Backend] = true
+		if w.Weight < 0 || w.Weight > 100 {
+			return ErrInvalidWeights
+		}
+		sum += w.Weight
+	}
+	if sum != 100 {
+		return ErrInvalidWeights
+	}
+	return nil
+}
+
+// StoreWeights stores the weights of a cname owned by backend. Empty weights
+// remove the stored entry.
+func StoreWeights(cname, backend string, weights []BackendWeight) error {
+	coll, err := weightsCollection()
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	if len(weights) == 0 {
+		err = coll.RemoveId(cname)
+		if err == mgo.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	_, err = coll.UpsertId(cname, WeightsEntry{CName: cname, Backend: backend, Weights: weights})
+	return err
+}
+
+// RetrieveWeights returns the weights of the cnames owned by backend.
+func RetrieveWeights(backend string) ([]WeightsEntry, error) {
+	return listWeights(bson.M{"backend": backend})
+}
+
+// WeightsUsingBackend returns the weights entries of cnames owned by backend
+// or sending part of their traffic to it.
+func WeightsUsingBackend(backend string) ([]WeightsEntry, error) {
+	return listWeights(bson.M{"$or": []bson.M{{"backend": backend}, {"weights.backend": backend}}})
+}
+
+func listWeights(query bson.M) ([]WeightsEntry, error) {
+	coll, err := weightsCollection()
+	if err != nil {
+		return nil, err
+	}
+	defer coll.Close()
+	var entries []WeightsEntry
+	err = coll.Find(query).Sort("_id").All(&entries)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// WeightedRoutes returns the routes of each backend repeated so that the
+// share of the routes of each backend in the returned list matches its
+// weight. It's meant for routers that pick a random route from a list. Every
+// route shows up at least once and the list is kept as short as possible,
+// growing linearly with the number of routes of the backends.
+func WeightedRoutes(weights []BackendWeight, routes map[string][]*url.URL) []*url.URL {
+	var divisor int
+	for _, w := range weights {
+		if w.Weight > 0 && len(routes[w.Backend]) > 0 {
+			divisor = gcd(divisor, w.Weight)
+		}
+	}
+	if divisor == 0 {
+		return nil
+	}
+	multiple := 1
+	for _, w := range weights {
+		n := len(routes[w.Backend])
+		if w.Weight == 0 || n == 0 {
+			continue
+		}
+		weight := w.Weight / divisor
+		if m := (n + weight - 1) / weight; m > multiple {
+			multiple = m
+		}
+	}
+	slots := map[string]int{}
+	for _, w := range weights {
+		if w.Weight > 0 && len(routes[w.Backend]) > 0 {
+			slots[w.Backend] = w.Weight / divisor * multiple
+		}
+	}
+	backends := make([]string, 0, len(slots))
+	for b := range slots {
+		backends = append(backends, b)
+	}
+	sort.Strings(backends)
+	var result []*url.URL
+	for _, b := range backends {
+		backendRoutes := routes[b]
+		for i := 0; i < slots[b]; i++ {
+			result = append(result, backendRoutes[i%len(backendRoutes)])
+		}
+	}
+	return result
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
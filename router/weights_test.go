@@ -0,0 +1,106 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"fmt"
+	"net/url"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestValidateWeights(c *check.C) {
+	tests := []struct {
+		weights []BackendWeight
+		err     string
+	}{
+		{weights: []BackendWeight{{Backend: "a", Weight: 80}, {Backend: "b", Weight: 20}}},
+		{weights: []BackendWeight{{Backend: "a", Weight: 100}, {Backend: "b", Weight: 0}}},
+		{weights: []BackendWeight{{Backend: "a", Weight: 80}, {Backend: "b", Weight: 10}}, err: ErrInvalidWeights.Error()},
+		{weights: []BackendWeight{{Backend: "a", Weight: 120}, {Backend: "b", Weight: -20}}, err: ErrInvalidWeights.Error()},
+		{weights: []BackendWeight{{Backend: "a", Weight: 50}, {Backend: "a", Weight: 50}}, err: `duplicated weight for backend "a"`},
+		{weights: []BackendWeight{{Weight: 100}}, err: "weight backend is required"},
+		{weights: nil, err: ErrInvalidWeights.Error()},
+	}
+	for _, tt := range tests {
+		err := ValidateWeights(tt.weights)
+		if tt.err == "" {
+			c.Check(err, check.IsNil)
+		} else {
+			c.Check(err, check.ErrorMatches, tt.err)
+		}
+	}
+}
+
+func (s *S) TestWeightedRoutes(c *check.C) {
+	a1, _ := url.Parse("http://a1:80")
+	a2, _ := url.Parse("http://a2:80")
+	b1, _ := url.Parse("http://b1:80")
+	b2, _ := url.Parse("http://b2:80")
+	b3, _ := url.Parse("http://b3:80")
+	routes := map[string][]*url.URL{
+		"a": {a1, a2},
+		"b": {b1, b2, b3},
+		"c": nil,
+	}
+	result := WeightedRoutes([]BackendWeight{{Backend: "a", Weight: 50}, {Backend: "b", Weight: 50}}, routes)
+	c.Assert(result, check.DeepEquals, []*url.URL{a1, a2, a1, b1, b2, b3})
+	result = WeightedRoutes([]BackendWeight{{Backend: "a", Weight: 90}, {Backend: "b", Weight: 10}}, routes)
+	count := map[string]int{}
+	for _, r := range result {
+		count[r.Host[:1]]++
+	}
+	c.Assert(count["a"]*10, check.Equals, len(result)*9)
+	c.Assert(count["b"]*10, check.Equals, len(result))
+	result = WeightedRoutes([]BackendWeight{{Backend: "a", Weight: 100}, {Backend: "b", Weight: 0}}, routes)
+	c.Assert(result, check.DeepEquals, []*url.URL{a1, a2})
+	result = WeightedRoutes([]BackendWeight{{Backend: "b", Weight: 30}, {Backend: "c", Weight: 70}}, routes)
+	c.Assert(result, check.DeepEquals, []*url.URL{b1, b2, b3})
+	result = WeightedRoutes([]BackendWeight{{Backend: "c", Weight: 100}}, routes)
+	c.Assert(result, check.HasLen, 0)
+}
+
+func (s *S) TestWeightedRoutesSize(c *check.C) {
+	routes := map[string][]*url.URL{}
+	for i := 0; i < 49; i++ {
+		routes["a"] = append(routes["a"], &url.URL{Scheme: "http", Host: fmt.Sprintf("a%d:80", i)})
+	}
+	for i := 0; i < 50; i++ {
+		routes["b"] = append(routes["b"], &url.URL{Scheme: "http", Host: fmt.Sprintf("b%d:80", i)})
+	}
+	result := WeightedRoutes([]BackendWeight{{Backend: "a", Weight: 50}, {Backend: "b", Weight: 50}}, routes)
+	c.Assert(result, check.HasLen, 100)
+	result = WeightedRoutes([]BackendWeight{{Backend: "a", Weight: 99}, {Backend: "b", Weight: 1}}, routes)
+	c.Assert(result, check.HasLen, 5000)
+	count := map[string]int{}
+	for _, r := range result {
+		count[r.Host]++
+	}
+	c.Assert(count, check.HasLen, 99)
+	c.Assert(count["b0:80"], check.Equals, 1)
+}
+
+func (s *S) TestStoreWeights(c *check.C) {
+	weights := []BackendWeight{{Backend: "app1", Weight: 70}, {Backend: "app2", Weight: 30}}
+	err := StoreWeights("www.example.com", "app1", weights)
+	c.Assert(err, check.IsNil)
+	err = StoreWeights("other.example.com", "app2", []BackendWeight{{Backend: "app2", Weight: 100}})
+	c.Assert(err, check.IsNil)
+	entries, err := RetrieveWeights("app1")
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.DeepEquals, []WeightsEntry{
+		{CName: "www.example.com", Backend: "app1", Weights: weights},
+	})
+	entries, err = WeightsUsingBackend("app2")
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.HasLen, 2)
+	err = StoreWeights("www.example.com", "app1", nil)
+	c.Assert(err, check.IsNil)
+	entries, err = RetrieveWeights("app1")
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.HasLen, 0)
+	err = StoreWeights("www.example.com", "app1", nil)
+	c.Assert(err, check.IsNil)
+}
@@ -5,10 +5,13 @@
 package service
 
 import (
+	"fmt"
 	"io"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
+	"github.com/tsuru/config"
 	"github.com/tsuru/tsuru/action"
 	"github.com/tsuru/tsuru/app/bind"
 	"github.com/tsuru/tsuru/db"
@@ -29,7 +32,7 @@ var createServiceInstance = action.Action{
 		if !ok {
 			return nil, errors.New("First parameter must be a Service.")
 		}
-		endpoint, err := service.getClient("production")
+		endpoint, err := service.getServiceClient("production")
 		if err != nil {
 			return nil, err
 		}
@@ -56,7 +59,7 @@ var createServiceInstance = action.Action{
 		if !ok {
 			return
 		}
-		endpoint, err := service.getClient("production")
+		endpoint, err := service.getServiceClient("production")
 		if err != nil {
 			return
 		}
@@ -79,7 +82,10 @@ var createServiceInstance = action.Action{
 var insertServiceInstance = action.Action{
 	Name: "insert-service-instance",
 	Forward: func(ctx action.FWContext) (action.Result, error) {
-		instance, ok := ctx.Params[1].(ServiceInstance)
+		instance, ok := ctx.Previous.(ServiceInstance)
+		if !ok {
+			instance, ok = ctx.Params[1].(ServiceInstance)
+		}
 		if !ok {
 			return nil, errors.New("Second parameter must be a ServiceInstance.")
 		}
@@ -88,7 +94,11 @@ var insertServiceInstance = action.Action{
 			return nil, err
 		}
 		defer conn.Close()
-		return nil, conn.ServiceInstances().Insert(&instance)
+		err = conn.ServiceInstances().Insert(&instance)
+		if err != nil {
+			return nil, err
+		}
+		return instance, nil
 	},
 	Backward: func(ctx action.BWContext) {
 		instance, ok := ctx.Params[1].(ServiceInstance)
@@ -105,13 +115,53 @@ var insertServiceInstance = action.Action{
 	MinParams: 2,
 }
 
+// enqueueProvisionCheck is an action that enqueues a task following the
+// provisioning of instances the service API creates asynchronously.
+var enqueueProvisionCheck = action.Action{
+	Name: "enqueue-service-instance-provision-check",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		instance, ok := ctx.Previous.(ServiceInstance)
+		if !ok {
+			return nil, errors.New("Previous result must be a ServiceInstance.")
+		}
+		if instance.GetState() != InstanceStatePending {
+			return instance, nil
+		}
+		err := enqueueProvisionCheckTask(&instance)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to enqueue provisioning check")
+		}
+		return instance, nil
+	},
+}
+
 type bindPipelineArgs struct {
 	app             bind.App
+	params          map[string]string
 	writer          io.Writer
 	serviceInstance *ServiceInstance
 	shouldRestart   bool
 }
 
+var waitInstanceReadyAction = &action.Action{
+	Name: "wait-instance-ready",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		args, _ := ctx.Params[0].(*bindPipelineArgs)
+		if args == nil {
+			return nil, errors.New("invalid arguments for pipeline, expected *bindPipelineArgs")
+		}
+		si := args.serviceInstance
+		if si.GetState() == InstanceStatePending && args.writer != nil {
+			fmt.Fprintf(args.writer, "---- Waiting for service instance %q to be ready ----\n", si.Name)
+		}
+		timeout, _ := config.GetInt("services:bind-wait-timeout")
+		if timeout <= 0 {
+			timeout = 300
+		}
+		return nil, si.waitReady(time.Duration(timeout) * time.Second)
+	},
+}
+
 var bindAppDBAction = &action.Action{
 	Name: "bind-app-db",
 	Forward: func(ctx action.FWContext) (action.Result, error) {
@@ -126,6 +176,9 @@ var bindAppDBAction = &action.Action{
 		defer conn.Close()
 		si := args.serviceInstance
 		updateOp := bson.M{"$addToSet": bson.M{"apps": args.app.GetName()}}
+		if len(args.params) > 0 {
+			updateOp["$set"] = bson.M{"bind_parameters." + args.app.GetName(): args.params}
+		}
 		err = conn.ServiceInstances().Update(bson.M{"name": si.Name, "service_name": si.ServiceName, "apps": bson.M{"$ne": args.app.GetName()}}, updateOp)
 		if err != nil {
 			if err == mgo.ErrNotFound {
@@ -137,7 +190,11 @@ var bindAppDBAction = &action.Action{
 	},
 	Backward: func(ctx action.BWContext) {
 		args, _ := ctx.Params[0].(*bindPipelineArgs)
-		if err := args.serviceInstance.update(bson.M{"$pull": bson.M{"apps": args.app.GetName()}}); err != nil {
+		updateOp := bson.M{
+			"$pull":  bson.M{"apps": args.app.GetName()},
+			"$unset": bson.M{"bind_parameters." + args.app.GetName(): ""},
+		}
+		if err := args.serviceInstance.update(updateOp); err != nil {
 			log.Errorf("[bind-app-db backward] could not remove app from service instance: %s", err)
 		}
 	},
@@ -151,15 +208,15 @@ var bindAppEndpointAction = &action.Action{
 		if args == nil {
 			return nil, errors.New("invalid arguments for pipeline, expected *bindPipelineArgs")
 		}
-		endpoint, err := args.serviceInstance.Service().getClient("production")
+		endpoint, err := args.serviceInstance.Service().getServiceClient("production")
 		if err != nil {
 			return nil, err
 		}
-		return endpoint.BindApp(args.serviceInstance, args.app)
+		return endpoint.BindApp(args.serviceInstance, args.app, args.params)
 	},
 	Backward: func(ctx action.BWContext) {
 		args, _ := ctx.Params[0].(*bindPipelineArgs)
-		endpoint, err := args.serviceInstance.Service().getClient("production")
+		endpoint, err := args.serviceInstance.Service().getServiceClient("production")
 		if err != nil {
 			log.Errorf("[bind-app-endpoint backward] could not get endpoint: %s", err)
 			return
@@ -316,11 +373,18 @@ var unbindAppDB = action.Action{
 		if args == nil {
 			return nil, errors.New("invalid arguments for pipeline, expected *bindPipelineArgs")
 		}
-		return nil, args.serviceInstance.update(bson.M{"$pull": bson.M{"apps": args.app.GetName()}})
+		return nil, args.serviceInstance.update(bson.M{
+			"$pull":  bson.M{"apps": args.app.GetName()},
+			"$unset": bson.M{"bind_parameters." + args.app.GetName(): ""},
+		})
 	},
 	Backward: func(ctx action.BWContext) {
 		args, _ := ctx.Params[0].(*bindPipelineArgs)
-		err := args.serviceInstance.update(bson.M{"$addToSet": bson.M{"apps": args.app.GetName()}})
+		updateOp := bson.M{"$addToSet": bson.M{"apps": args.app.GetName()}}
+		if params := args.serviceInstance.BindParameters[args.app.GetName()]; len(params) > 0 {
+			updateOp["$set"] = bson.M{"bind_parameters." + args.app.GetName(): params}
+		}
+		err := args.serviceInstance.update(updateOp)
 		if err != nil {
 			log.Errorf("[unbind-app-db backward] failed to rebind app in db: %s", err)
 		}
@@ -335,7 +399,7 @@ var unbindAppEndpoint = action.Action{
 		if args == nil {
 			return nil, errors.New("invalid arguments for pipeline, expected *bindPipelineArgs")
 		}
-		if endpoint, err := args.serviceInstance.Service().getClient("production"); err == nil {
+		if endpoint, err := args.serviceInstance.Service().getServiceClient("production"); err == nil {
 			err := endpoint.UnbindApp(args.serviceInstance, args.app)
 			if err != nil && err != ErrInstanceNotFoundInAPI {
 				return nil, err
@@ -345,8 +409,8 @@ var unbindAppEndpoint = action.Action{
 	},
 	Backward: func(ctx action.BWContext) {
 		args, _ := ctx.Params[0].(*bindPipelineArgs)
-		if endpoint, err := args.serviceInstance.Service().getClient("production"); err == nil {
-			_, err := endpoint.BindApp(args.serviceInstance, args.app)
+		if endpoint, err := args.serviceInstance.Service().getServiceClient("production"); err == nil {
+			_, err := endpoint.BindApp(args.serviceInstance, args.app, args.serviceInstance.BindParameters[args.app.GetName()])
 			if err != nil {
 				log.Errorf("[unbind-app-endpoint backward] failed to rebind app in endpoint: %s", err)
 			}
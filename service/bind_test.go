@@ -88,7 +88,7 @@ func (s *BindSuite) TestBindAppFailsWhenEndpointIsDown(c *check.C) {
 	defer s.conn.ServiceInstances().Remove(bson.M{"name": "my-mysql"})
 	app := provisiontest.NewFakeApp("painkiller", "python", 1)
 	c.Assert(err, check.IsNil)
-	err = instance.BindApp(app, true, nil)
+	err = instance.BindApp(app, nil, true, nil)
 	c.Assert(err, check.NotNil)
 }
 
@@ -105,7 +105,7 @@ func (s *BindSuite) TestBindAddsAppToTheServiceInstance(c *check.C) {
 	instance.Create()
 	defer s.conn.ServiceInstances().Remove(bson.M{"name": "my-mysql"})
 	app := provisiontest.NewFakeApp("painkiller", "python", 1)
-	err = instance.BindApp(app, true, nil)
+	err = instance.BindApp(app, nil, true, nil)
 	c.Assert(err, check.IsNil)
 	s.conn.ServiceInstances().Find(bson.M{"name": instance.Name}).One(&instance)
 	c.Assert(instance.Apps, check.DeepEquals, []string{app.GetName()})
@@ -131,7 +131,7 @@ func (s *BindSuite) TestBindAppMultiUnits(c *check.C) {
 	c.Assert(err, check.IsNil)
 	defer s.conn.ServiceInstances().Remove(bson.M{"name": "my-mysql"})
 	app := provisiontest.NewFakeApp("painkiller", "python", 2)
-	err = instance.BindApp(app, true, nil)
+	err = instance.BindApp(app, nil, true, nil)
 	c.Assert(err, check.IsNil)
 	err = tsurutest.WaitCondition(2e9, func() bool {
 		return atomic.LoadInt32(&calls) == 3
@@ -154,7 +154,7 @@ func (s *BindSuite) TestBindReturnConflictIfTheAppIsAlreadyBound(c *check.C) {
 	c.Assert(err, check.IsNil)
 	defer s.conn.ServiceInstances().Remove(bson.M{"name": "my-mysql"})
 	app := provisiontest.NewFakeApp("painkiller", "python", 1)
-	err = instance.BindApp(app, true, nil)
+	err = instance.BindApp(app, nil, true, nil)
 	c.Assert(err, check.Equals, ErrAppAlreadyBound)
 }
 
@@ -172,7 +172,7 @@ func (s *BindSuite) TestBindAppWithNoUnits(c *check.C) {
 	c.Assert(err, check.IsNil)
 	defer s.conn.ServiceInstances().Remove(bson.M{"name": "my-mysql"})
 	app := provisiontest.NewFakeApp("painkiller", "python", 0)
-	err = instance.BindApp(app, true, nil)
+	err = instance.BindApp(app, nil, true, nil)
 	c.Assert(err, check.IsNil)
 	expectedInstances := []bind.ServiceInstance{
 		{
@@ -186,6 +186,105 @@ func (s *BindSuite) TestBindAppWithNoUnits(c *check.C) {
 	c.Assert(app.GetInstances("mysql"), check.DeepEquals, expectedInstances)
 }
 
+func (s *BindSuite) TestBindAppWithParameters(c *check.C) {
+	var params map[string][]string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/resources/my-mysql/bind-app" {
+			r.ParseForm()
+			params = r.Form
+		}
+		w.Write([]byte(`{"DATABASE_USER":"reader","DATABASE_PASSWORD":"s3cr3t"}`))
+	}))
+	defer ts.Close()
+	srvc := Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := srvc.Create()
+	c.Assert(err, check.IsNil)
+	defer s.conn.Services().Remove(bson.M{"_id": "mysql"})
+	instance := ServiceInstance{Name: "my-mysql", ServiceName: "mysql", Teams: []string{s.team.Name}}
+	err = instance.Create()
+	c.Assert(err, check.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": "my-mysql"})
+	app := provisiontest.NewFakeApp("painkiller", "python", 0)
+	err = instance.BindApp(app, map[string]string{"role": "readonly"}, true, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(params["parameters.role"], check.DeepEquals, []string{"readonly"})
+	c.Assert(params["app-host"], check.DeepEquals, []string{app.GetIp()})
+	err = s.conn.ServiceInstances().Find(bson.M{"name": instance.Name}).One(&instance)
+	c.Assert(err, check.IsNil)
+	c.Assert(instance.Apps, check.DeepEquals, []string{app.GetName()})
+	c.Assert(instance.BindParameters, check.DeepEquals, map[string]map[string]string{
+		"painkiller": {"role": "readonly"},
+	})
+}
+
+func (s *BindSuite) TestRebindApp(c *check.C) {
+	var calls []string
+	var params map[string][]string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		if r.Method == "POST" {
+			r.ParseForm()
+			params = r.Form
+			w.Write([]byte(`{"DATABASE_USER":"reader","DATABASE_PASSWORD":"n3wp4ss"}`))
+		}
+	}))
+	defer ts.Close()
+	srvc := Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := srvc.Create()
+	c.Assert(err, check.IsNil)
+	defer s.conn.Services().Remove(bson.M{"_id": "mysql"})
+	instance := ServiceInstance{
+		Name:           "my-mysql",
+		ServiceName:    "mysql",
+		Teams:          []string{s.team.Name},
+		Apps:           []string{"painkiller"},
+		BindParameters: map[string]map[string]string{"painkiller": {"role": "readonly"}},
+	}
+	err = instance.Create()
+	c.Assert(err, check.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": "my-mysql"})
+	app := provisiontest.NewFakeApp("painkiller", "python", 0)
+	err = app.AddInstance(bind.InstanceApp{
+		ServiceName: "mysql",
+		Instance: bind.ServiceInstance{
+			Name: "my-mysql",
+			Envs: map[string]string{"DATABASE_USER": "reader", "DATABASE_PASSWORD": "s3cr3t"},
+		},
+	}, nil)
+	c.Assert(err, check.IsNil)
+	err = instance.RebindApp(app, true, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(calls, check.DeepEquals, []string{
+		"DELETE /resources/my-mysql/bind-app",
+		"POST /resources/my-mysql/bind-app",
+	})
+	c.Assert(params["parameters.role"], check.DeepEquals, []string{"readonly"})
+	expectedInstances := []bind.ServiceInstance{
+		{
+			Name: "my-mysql",
+			Envs: map[string]string{
+				"DATABASE_USER":     "reader",
+				"DATABASE_PASSWORD": "n3wp4ss",
+			},
+		},
+	}
+	c.Assert(app.GetInstances("mysql"), check.DeepEquals, expectedInstances)
+}
+
+func (s *BindSuite) TestRebindAppNotBound(c *check.C) {
+	srvc := Service{Name: "mysql"}
+	err := srvc.Create()
+	c.Assert(err, check.IsNil)
+	defer s.conn.Services().Remove(bson.M{"_id": "mysql"})
+	instance := ServiceInstance{Name: "my-mysql", ServiceName: "mysql", Teams: []string{s.team.Name}}
+	err = instance.Create()
+	c.Assert(err, check.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": "my-mysql"})
+	app := provisiontest.NewFakeApp("painkiller", "python", 0)
+	err = instance.RebindApp(app, true, nil)
+	c.Assert(err, check.Equals, ErrAppNotBound)
+}
+
 func (s *BindSuite) TestUnbindUnit(c *check.C) {
 	called := false
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
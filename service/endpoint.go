@@ -112,6 +112,10 @@ func (c *Client) jsonFromResponse(resp *http.Response, v interface{}) error {
 	return json.Unmarshal(body, &v)
 }
 
+// Create asks the service API to create the instance. Services provisioning
+// instances asynchronously answer with 202 (Accepted), in which case the
+// state of the instance is set to pending and the provisioning must be
+// followed through the instance status.
 func (c *Client) Create(instance *ServiceInstance, user, requestID string) error {
 	var err error
 	var resp *http.Response
@@ -131,7 +135,12 @@ func (c *Client) Create(instance *ServiceInstance, user, requestID string) error
 	resp, err = c.issueRequest("/resources", "POST", params)
 	if err == nil {
 		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusAccepted {
+			instance.State = InstanceStatePending
+			return nil
+		}
 		if resp.StatusCode < 300 {
+			instance.State = InstanceStateReady
 			return nil
 		}
 		if resp.StatusCode == http.StatusConflict {
@@ -142,6 +151,37 @@ func (c *Client) Create(instance *ServiceInstance, user, requestID string) error
 	return log.WrapError(err)
 }
 
+// Update forwards the description, plan and parameters of the instance to
+// the service API.
+func (c *Client) Update(instance *ServiceInstance, requestID string) error {
+	log.Debugf("Attempting to call update of service instance %q at %q api", instance.Name, instance.ServiceName)
+	params := map[string][]string{
+		"team":      {instance.TeamOwner},
+		"requestID": {requestID},
+	}
+	if instance.PlanName != "" {
+		params["plan"] = []string{instance.PlanName}
+	}
+	if instance.Description != "" {
+		params["description"] = []string{instance.Description}
+	}
+	for k, v := range instance.Parameters {
+		params["parameters."+k] = []string{v}
+	}
+	resp, err := c.issueRequest("/resources/"+instance.GetIdentifier(), "PUT", params)
+	if err == nil {
+		defer resp.Body.Close()
+		if resp.StatusCode < 300 {
+			return nil
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			return ErrInstanceNotFoundInAPI
+		}
+	}
+	err = errors.Wrapf(c.buildErrorMessage(err, resp), "Failed to update the instance %s", instance.Name)
+	return log.WrapError(err)
+}
+
 func (c *Client) Destroy(instance *ServiceInstance, requestID string) error {
 	log.Debugf("Attempting to call destroy of service instance %q at %q api", instance.Name, instance.ServiceName)
 	params := map[string][]string{
@@ -161,13 +201,16 @@ func (c *Client) Destroy(instance *ServiceInstance, requestID string) error {
 	return err
 }
 
-func (c *Client) BindApp(instance *ServiceInstance, app bind.App) (map[string]string, error) {
+func (c *Client) BindApp(instance *ServiceInstance, app bind.App, bindParams map[string]string) (map[string]string, error) {
 	log.Debugf("Calling bind of instance %q and %q app at %q API",
 		instance.Name, app.GetName(), instance.ServiceName)
 	var resp *http.Response
 	params := map[string][]string{
 		"app-host": {app.GetIp()},
 	}
+	for k, v := range bindParams {
+		params["parameters."+k] = []string{v}
+	}
 	resp, err := c.issueRequest("/resources/"+instance.GetIdentifier()+"/bind-app", "POST", params)
 	if err != nil {
 		return nil, log.WrapError(errors.Wrapf(err, `Failed to bind app %q to service instance "%s/%s"`, app.GetName(), instance.ServiceName, instance.Name))
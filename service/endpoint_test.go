@@ -77,6 +77,7 @@ func (s *S) TestEndpointCreate(c *check.C) {
 	client := &Client{endpoint: ts.URL, username: "user", password: "abcde"}
 	err := client.Create(&instance, "my@user", "Request-ID")
 	c.Assert(err, check.IsNil)
+	c.Assert(instance.State, check.Equals, InstanceStateReady)
 	expectedURL := "/resources"
 	h.Lock()
 	defer h.Unlock()
@@ -173,6 +174,18 @@ func (s *S) TestCreateDuplicate(c *check.C) {
 	c.Assert(err, check.Equals, ErrInstanceAlreadyExistsInAPI)
 }
 
+func (s *S) TestCreateAccepted(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+	instance := ServiceInstance{Name: "his-redis", ServiceName: "redis"}
+	client := &Client{endpoint: ts.URL, username: "user", password: "abcde"}
+	err := client.Create(&instance, "my@user", "")
+	c.Assert(err, check.IsNil)
+	c.Assert(instance.State, check.Equals, InstanceStatePending)
+}
+
 func (s *S) TestCreateShouldReturnErrorIfTheRequestFail(c *check.C) {
 	ts := httptest.NewServer(http.HandlerFunc(failHandler))
 	defer ts.Close()
@@ -183,6 +196,54 @@ func (s *S) TestCreateShouldReturnErrorIfTheRequestFail(c *check.C) {
 	c.Assert(err, check.ErrorMatches, "^Failed to create the instance "+instance.Name+": invalid response: Server failed to do its job.$")
 }
 
+func (s *S) TestEndpointUpdate(c *check.C) {
+	h := TestHandler{}
+	ts := httptest.NewServer(&h)
+	defer ts.Close()
+	instance := ServiceInstance{
+		Name:        "my-redis",
+		ServiceName: "redis",
+		PlanName:    "big",
+		TeamOwner:   "myteam",
+		Description: "xyz",
+		Parameters:  map[string]string{"size": "10"},
+	}
+	client := &Client{endpoint: ts.URL, username: "user", password: "abcde"}
+	err := client.Update(&instance, "")
+	c.Assert(err, check.IsNil)
+	h.Lock()
+	defer h.Unlock()
+	c.Assert(h.url, check.Equals, "/resources/my-redis")
+	c.Assert(h.method, check.Equals, "PUT")
+	v, err := url.ParseQuery(string(h.body))
+	c.Assert(err, check.IsNil)
+	c.Assert(map[string][]string(v), check.DeepEquals, map[string][]string{
+		"plan":            {"big"},
+		"team":            {"myteam"},
+		"description":     {"xyz"},
+		"parameters.size": {"10"},
+	})
+	c.Assert("Basic dXNlcjphYmNkZQ==", check.Equals, h.request.Header.Get("Authorization"))
+}
+
+func (s *S) TestEndpointUpdateNotFound(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(notFoundHandler))
+	defer ts.Close()
+	instance := ServiceInstance{Name: "his-redis", ServiceName: "redis", PlanName: "big"}
+	client := &Client{endpoint: ts.URL, username: "user", password: "abcde"}
+	err := client.Update(&instance, "")
+	c.Assert(err, check.Equals, ErrInstanceNotFoundInAPI)
+}
+
+func (s *S) TestEndpointUpdateFailure(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(failHandler))
+	defer ts.Close()
+	instance := ServiceInstance{Name: "his-redis", ServiceName: "redis", PlanName: "big"}
+	client := &Client{endpoint: ts.URL, username: "user", password: "abcde"}
+	err := client.Update(&instance, "")
+	c.Assert(err, check.ErrorMatches, "^Failed to update the instance his-redis: invalid response: Server failed to do its job.$")
+}
+
 func (s *S) TestDestroyShouldSendADELETERequestToTheResourceURL(c *check.C) {
 	h := TestHandler{}
 	ts := httptest.NewServer(&h)
@@ -222,7 +283,7 @@ func (s *S) TestBindAppEndpointDown(c *check.C) {
 	instance := ServiceInstance{Name: "her-redis", ServiceName: "redis"}
 	a := provisiontest.NewFakeApp("her-app", "python", 1)
 	client := &Client{endpoint: "http://localhost:1234", username: "user", password: "abcde"}
-	_, err := client.BindApp(&instance, a)
+	_, err := client.BindApp(&instance, a, nil)
 	c.Assert(err, check.NotNil)
 	c.Assert(err, check.ErrorMatches, `Failed to bind app "her-app" to service instance "redis/her-redis": Post http://localhost:1234/resources/her-redis/bind-app:.*connection refused`)
 }
@@ -234,7 +295,7 @@ func (s *S) TestBindAppShouldSendAPOSTToTheResourceURL(c *check.C) {
 	instance := ServiceInstance{Name: "her-redis", ServiceName: "redis"}
 	a := provisiontest.NewFakeApp("her-app", "python", 1)
 	client := &Client{endpoint: ts.URL, username: "user", password: "abcde"}
-	_, err := client.BindApp(&instance, a)
+	_, err := client.BindApp(&instance, a, nil)
 	h.Lock()
 	defer h.Unlock()
 	c.Assert(err, check.IsNil)
@@ -267,7 +328,7 @@ func (s *S) TestBindAppBackwardCompatible(c *check.C) {
 	instance := ServiceInstance{Name: "her-redis", ServiceName: "redis"}
 	a := provisiontest.NewFakeApp("her-app", "python", 1)
 	client := &Client{endpoint: ts.URL, username: "user", password: "abcde"}
-	env, err := client.BindApp(&instance, a)
+	env, err := client.BindApp(&instance, a, nil)
 	c.Assert(err, check.IsNil)
 	c.Assert(env, check.DeepEquals, expected)
 	c.Assert(atomic.LoadInt32(&calls), check.Equals, int32(2))
@@ -285,7 +346,7 @@ func (s *S) TestBindAppShouldReturnMapWithTheEnvironmentVariable(c *check.C) {
 	instance := ServiceInstance{Name: "her-redis", ServiceName: "redis"}
 	a := provisiontest.NewFakeApp("her-app", "python", 1)
 	client := &Client{endpoint: ts.URL, username: "user", password: "abcde"}
-	env, err := client.BindApp(&instance, a)
+	env, err := client.BindApp(&instance, a, nil)
 	c.Assert(err, check.IsNil)
 	c.Assert(env, check.DeepEquals, expected)
 }
@@ -296,7 +357,7 @@ func (s *S) TestBindAppShouldReturnErrorIfTheRequestFail(c *check.C) {
 	instance := ServiceInstance{Name: "her-redis", ServiceName: "redis"}
 	a := provisiontest.NewFakeApp("her-app", "python", 1)
 	client := &Client{endpoint: ts.URL, username: "user", password: "abcde"}
-	_, err := client.BindApp(&instance, a)
+	_, err := client.BindApp(&instance, a, nil)
 	c.Assert(err, check.NotNil)
 	c.Assert(err, check.ErrorMatches, `^Failed to bind the instance "redis/her-redis" to the app "her-app": invalid response: Server failed to do its job.$`)
 }
@@ -310,7 +371,7 @@ func (s *S) TestBindAppInstanceNotReady(c *check.C) {
 	instance := ServiceInstance{Name: "her-redis", ServiceName: "redis"}
 	a := provisiontest.NewFakeApp("her-app", "python", 1)
 	client := &Client{endpoint: ts.URL, username: "user", password: "abcde"}
-	_, err := client.BindApp(&instance, a)
+	_, err := client.BindApp(&instance, a, nil)
 	c.Assert(err, check.Equals, ErrInstanceNotReady)
 }
 
@@ -323,7 +384,7 @@ func (s *S) TestBindAppInstanceNotFound(c *check.C) {
 	instance := ServiceInstance{Name: "her-redis", ServiceName: "redis"}
 	a := provisiontest.NewFakeApp("her-app", "python", 1)
 	client := &Client{endpoint: ts.URL, username: "user", password: "abcde"}
-	_, err := client.BindApp(&instance, a)
+	_, err := client.BindApp(&instance, a, nil)
 	c.Assert(err, check.Equals, ErrInstanceNotFoundInAPI)
 }
 
@@ -0,0 +1,431 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/tsuru/app/bind"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/net"
+)
+
+const osbAPIVersion = "2.13"
+
+var errOSBProxyNotSupported = errors.New("proxy is not supported by Open Service Broker services")
+
+// osbClient is a client for services implementing the Open Service Broker
+// API v2. Instances and bindings are identified in the broker by the name of
+// the instance and by the names of the instance and the app, respectively.
+type osbClient struct {
+	serviceName string
+	endpoint    string
+	username    string
+	password    string
+}
+
+type osbPlan struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type osbService struct {
+	ID    string    `json:"id"`
+	Name  string    `json:"name"`
+	Plans []osbPlan `json:"plans"`
+}
+
+type osbCatalog struct {
+	Services []osbService `json:"services"`
+}
+
+type osbOperation struct {
+	Operation   string `json:"operation"`
+	State       string `json:"state"`
+	Description string `json:"description"`
+}
+
+type osbBinding struct {
+	Credentials map[string]interface{} `json:"credentials"`
+}
+
+func (c *osbClient) request(method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	u := strings.TrimRight(c.endpoint, "/") + "/v2/" + strings.Trim(path, "/")
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Broker-API-Version", osbAPIVersion)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.SetBasicAuth(c.username, c.password)
+	req.Close = true
+	t0 := time.Now()
+	resp, err := net.Dial5Full300ClientNoKeepAlive.Do(req)
+	requestLatencies.WithLabelValues(c.serviceName).Observe(time.Since(t0).Seconds())
+	if err != nil {
+		requestErrors.WithLabelValues(c.serviceName).Inc()
+	}
+	return resp, err
+}
+
+func (c *osbClient) buildError(resp *http.Response, format string, args ...interface{}) error {
+	data, _ := ioutil.ReadAll(resp.Body)
+	var brokerErr struct {
+		Error       string `json:"error"`
+		Description string `json:"description"`
+	}
+	msg := string(data)
+	if json.Unmarshal(data, &brokerErr) == nil && (brokerErr.Error != "" || brokerErr.Description != "") {
+		msg = strings.TrimPrefix(brokerErr.Error+": "+brokerErr.Description, ": ")
+	}
+	err := errors.Wrapf(errors.Errorf("invalid response from broker (code %d): %s", resp.StatusCode, msg), format, args...)
+	return log.WrapError(err)
+}
+
+func (c *osbClient) decode(resp *http.Response, v interface{}) error {
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+// catalogService returns the service in the broker catalog matching the
+// name of the tsuru service. Brokers with a single service in the catalog
+// may name it differently.
+func (c *osbClient) catalogService() (*osbService, error) {
+	resp, err := c.request("GET", "catalog", nil, nil)
+	if err != nil {
+		return nil, log.WrapError(errors.Wrap(err, "Failed to get the broker catalog"))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.buildError(resp, "Failed to get the broker catalog")
+	}
+	var catalog osbCatalog
+	err = c.decode(resp, &catalog)
+	if err != nil {
+		return nil, err
+	}
+	for i := range catalog.Services {
+		if catalog.Services[i].Name == c.serviceName {
+			return &catalog.Services[i], nil
+		}
+	}
+	if len(catalog.Services) == 1 {
+		return &catalog.Services[0], nil
+	}
+	return nil, errors.Errorf("service %q not found in the broker catalog", c.serviceName)
+}
+
+// planID returns the id of the named plan, defaulting to the first plan of
+// the service when the name is empty.
+func (s *osbService) planID(name string) (string, error) {
+	for _, p := range s.Plans {
+		if p.Name == name || name == "" {
+			return p.ID, nil
+		}
+	}
+	return "", errors.Errorf("plan %q not found in service %q", name, s.Name)
+}
+
+// catalogIDs returns the ids of the service and of the plan of the instance.
+func (c *osbClient) catalogIDs(instance *ServiceInstance) (string, string, error) {
+	svc, err := c.catalogService()
+	if err != nil {
+		return "", "", err
+	}
+	planID, err := svc.planID(instance.PlanName)
+	if err != nil {
+		return "", "", err
+	}
+	return svc.ID, planID, nil
+}
+
+func (c *osbClient) instancePath(instance *ServiceInstance) string {
+	return "service_instances/" + instance.GetIdentifier()
+}
+
+func (c *osbClient) bindingPath(instance *ServiceInstance, app bind.App) string {
+	return c.instancePath(instance) + "/service_bindings/" + instance.GetIdentifier() + "-" + app.GetName()
+}
+
+// setParameters adds the given parameters, if any, to the body of a request.
+func setParameters(body map[string]interface{}, params map[string]string) {
+	if len(params) > 0 {
+		body["parameters"] = params
+	}
+}
+
+func (c *osbClient) Create(instance *ServiceInstance, user, requestID string) error {
+	log.Debugf("Attempting to provision service instance %q at %q broker", instance.Name, instance.ServiceName)
+	serviceID, planID, err := c.catalogIDs(instance)
+	if err != nil {
+		return err
+	}
+	body := map[string]interface{}{
+		"service_id":        serviceID,
+		"plan_id":           planID,
+		"organization_guid": instance.TeamOwner,
+		"space_guid":        instance.TeamOwner,
+		"context": map[string]string{
+			"platform": "tsuru",
+			"team":     instance.TeamOwner,
+			"user":     user,
+		},
+	}
+	setParameters(body, instance.Parameters)
+	query := url.Values{"accepts_incomplete": {"true"}}
+	resp, err := c.request("PUT", c.instancePath(instance), query, body)
+	if err != nil {
+		return log.WrapError(errors.Wrapf(err, "Failed to create the instance %s", instance.Name))
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		instance.State = InstanceStateReady
+		return nil
+	case http.StatusAccepted:
+		var op osbOperation
+		c.decode(resp, &op)
+		instance.State = InstanceStatePending
+		instance.BrokerOperation = op.Operation
+		return nil
+	case http.StatusConflict:
+		return ErrInstanceAlreadyExistsInAPI
+	}
+	return c.buildError(resp, "Failed to create the instance %s", instance.Name)
+}
+
+func (c *osbClient) Update(instance *ServiceInstance, requestID string) error {
+	log.Debugf("Attempting to update service instance %q at %q broker", instance.Name, instance.ServiceName)
+	serviceID, planID, err := c.catalogIDs(instance)
+	if err != nil {
+		return err
+	}
+	body := map[string]interface{}{
+		"service_id": serviceID,
+		"plan_id":    planID,
+	}
+	setParameters(body, instance.Parameters)
+	query := url.Values{"accepts_incomplete": {"true"}}
+	resp, err := c.request("PATCH", c.instancePath(instance), query, body)
+	if err != nil {
+		return log.WrapError(errors.Wrapf(err, "Failed to update the instance %s", instance.Name))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusAccepted {
+		return nil
+	}
+	return c.buildError(resp, "Failed to update the instance %s", instance.Name)
+}
+
+func (c *osbClient) Destroy(instance *ServiceInstance, requestID string) error {
+	log.Debugf("Attempting to deprovision service instance %q at %q broker", instance.Name, instance.ServiceName)
+	serviceID, planID, err := c.catalogIDs(instance)
+	if err != nil {
+		return err
+	}
+	query := url.Values{
+		"service_id":         {serviceID},
+		"plan_id":            {planID},
+		"accepts_incomplete": {"true"},
+	}
+	resp, err := c.request("DELETE", c.instancePath(instance), query, nil)
+	if err != nil {
+		return log.WrapError(errors.Wrapf(err, "Failed to destroy the instance %s", instance.Name))
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusAccepted:
+		return nil
+	case http.StatusGone:
+		return ErrInstanceNotFoundInAPI
+	}
+	return c.buildError(resp, "Failed to destroy the instance %s", instance.Name)
+}
+
+// BindApp creates a binding for the app in the broker, returning the
+// credentials of the binding as environment variables.
+func (c *osbClient) BindApp(instance *ServiceInstance, app bind.App, params map[string]string) (map[string]string, error) {
+	log.Debugf("Calling bind of instance %q and %q app at %q broker", instance.Name, app.GetName(), instance.ServiceName)
+	serviceID, planID, err := c.catalogIDs(instance)
+	if err != nil {
+		return nil, err
+	}
+	body := map[string]interface{}{
+		"service_id":    serviceID,
+		"plan_id":       planID,
+		"app_guid":      app.GetName(),
+		"bind_resource": map[string]string{"app_guid": app.GetName()},
+	}
+	setParameters(body, params)
+	resp, err := c.request("PUT", c.bindingPath(instance, app), nil, body)
+	if err != nil {
+		return nil, log.WrapError(errors.Wrapf(err, `Failed to bind app %q to service instance "%s/%s"`, app.GetName(), instance.ServiceName, instance.Name))
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		var binding osbBinding
+		err = c.decode(resp, &binding)
+		if err != nil {
+			return nil, err
+		}
+		return credentialsToEnvs(binding.Credentials), nil
+	case http.StatusNotFound:
+		return nil, ErrInstanceNotFoundInAPI
+	case http.StatusUnprocessableEntity:
+		return nil, ErrInstanceNotReady
+	}
+	return nil, c.buildError(resp, `Failed to bind the instance "%s/%s" to the app %q`, instance.ServiceName, instance.Name, app.GetName())
+}
+
+// BindUnit is a no-op, brokers know nothing about units.
+func (c *osbClient) BindUnit(instance *ServiceInstance, app bind.App, unit bind.Unit) error {
+	return nil
+}
+
+func (c *osbClient) UnbindApp(instance *ServiceInstance, app bind.App) error {
+	log.Debugf("Calling unbind of service instance %q and app %q at %q broker", instance.Name, app.GetName(), instance.ServiceName)
+	serviceID, planID, err := c.catalogIDs(instance)
+	if err != nil {
+		return err
+	}
+	query := url.Values{"service_id": {serviceID}, "plan_id": {planID}}
+	resp, err := c.request("DELETE", c.bindingPath(instance, app), query, nil)
+	if err != nil {
+		return log.WrapError(errors.Wrapf(err, "Failed to unbind app %q", app.GetName()))
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusAccepted:
+		return nil
+	case http.StatusGone:
+		return ErrInstanceNotFoundInAPI
+	}
+	return c.buildError(resp, "Failed to unbind app %q", app.GetName())
+}
+
+// UnbindUnit is a no-op, brokers know nothing about units.
+func (c *osbClient) UnbindUnit(instance *ServiceInstance, app bind.App, unit bind.Unit) error {
+	return nil
+}
+
+// Status maps the last operation of pending instances to the statuses of
+// the tsuru service API. Brokers have no health information about instances,
+// so the status of other instances comes from their provisioning state.
+func (c *osbClient) Status(instance *ServiceInstance, requestID string) (string, error) {
+	switch instance.GetState() {
+	case InstanceStateReady:
+		return "up", nil
+	case InstanceStateFailed:
+		return "down", nil
+	}
+	serviceID, planID, err := c.catalogIDs(instance)
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{"service_id": {serviceID}, "plan_id": {planID}}
+	if instance.BrokerOperation != "" {
+		query.Set("operation", instance.BrokerOperation)
+	}
+	resp, err := c.request("GET", c.instancePath(instance)+"/last_operation", query, nil)
+	if err != nil {
+		return "", log.WrapError(errors.Wrapf(err, "Failed to get status of instance %s", instance.Name))
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var op osbOperation
+		err = c.decode(resp, &op)
+		if err != nil {
+			return "", err
+		}
+		switch op.State {
+		case "in progress":
+			return "pending", nil
+		case "failed":
+			return "down", nil
+		}
+		return "up", nil
+	case http.StatusGone:
+		return "down", nil
+	}
+	return "", c.buildError(resp, "Failed to get status of instance %s", instance.Name)
+}
+
+// Info returns no additional info, brokers don't provide it.
+func (c *osbClient) Info(instance *ServiceInstance, requestID string) ([]map[string]string, error) {
+	return nil, nil
+}
+
+// Plans returns the plans of the service in the broker catalog.
+func (c *osbClient) Plans(requestID string) ([]Plan, error) {
+	svc, err := c.catalogService()
+	if err != nil {
+		return nil, err
+	}
+	plans := make([]Plan, len(svc.Plans))
+	for i, p := range svc.Plans {
+		plans[i] = Plan{Name: p.Name, Description: p.Description}
+	}
+	return plans, nil
+}
+
+func (c *osbClient) Proxy(path string, w http.ResponseWriter, r *http.Request) error {
+	return errOSBProxyNotSupported
+}
+
+// credentialsToEnvs converts the credentials of a binding to environment
+// variables, upper casing their names. Values other than strings are
+// encoded as JSON.
+func credentialsToEnvs(credentials map[string]interface{}) map[string]string {
+	envs := make(map[string]string, len(credentials))
+	for k, v := range credentials {
+		name := strings.Map(func(r rune) rune {
+			if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+				return r
+			}
+			return '_'
+		}, strings.ToUpper(k))
+		if str, ok := v.(string); ok {
+			envs[name] = str
+			continue
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			continue
+		}
+		envs[name] = string(data)
+	}
+	return envs
+}
@@ -0,0 +1,305 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"bytes"
+
+	"github.com/tsuru/tsuru/app/bind"
+	"github.com/tsuru/tsuru/provision/provisiontest"
+	"github.com/tsuru/tsuru/service/osbtest"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) osbClient(broker *osbtest.Broker) *osbClient {
+	srv := Service{
+		Name:     "mysql",
+		Username: osbtest.Username,
+		Password: osbtest.Password,
+		Endpoint: map[string]string{"production": broker.URL()},
+		Type:     ServiceTypeOSB,
+	}
+	cli, err := srv.getServiceClient("production")
+	if err != nil {
+		panic(err)
+	}
+	return cli.(*osbClient)
+}
+
+func (s *S) TestGetServiceClient(c *check.C) {
+	srv := Service{Name: "mysql", Endpoint: map[string]string{"production": "http://mysql.api.com"}}
+	cli, err := srv.getServiceClient("production")
+	c.Assert(err, check.IsNil)
+	c.Assert(cli, check.FitsTypeOf, &Client{})
+	srv.Type = ServiceTypeOSB
+	cli, err = srv.getServiceClient("production")
+	c.Assert(err, check.IsNil)
+	c.Assert(cli, check.DeepEquals, &osbClient{
+		serviceName: "mysql",
+		endpoint:    "http://mysql.api.com",
+		username:    "mysql",
+	})
+	_, err = srv.getServiceClient("staging")
+	c.Assert(err, check.ErrorMatches, "^Unknown endpoint: staging$")
+}
+
+func (s *S) TestServiceValidType(c *check.C) {
+	for _, t := range []string{"", ServiceTypeTsuru, ServiceTypeOSB} {
+		srv := Service{Type: t}
+		c.Check(srv.ValidType(), check.Equals, true)
+	}
+	srv := Service{Type: "soap"}
+	c.Assert(srv.ValidType(), check.Equals, false)
+}
+
+func (s *S) TestOSBPlans(c *check.C) {
+	broker := osbtest.NewBroker("mysql")
+	defer broker.Close()
+	plans, err := s.osbClient(broker).Plans("")
+	c.Assert(err, check.IsNil)
+	c.Assert(plans, check.DeepEquals, []Plan{
+		{Name: "small", Description: "small plan"},
+		{Name: "big", Description: "big plan"},
+	})
+}
+
+func (s *S) TestOSBPlansServiceNotInCatalog(c *check.C) {
+	broker := osbtest.NewBroker("mysql")
+	defer broker.Close()
+	cli := s.osbClient(broker)
+	cli.serviceName = "redis"
+	plans, err := cli.Plans("")
+	c.Assert(err, check.IsNil)
+	c.Assert(plans, check.HasLen, 2)
+}
+
+func (s *S) TestOSBInvalidCredentials(c *check.C) {
+	broker := osbtest.NewBroker("mysql")
+	defer broker.Close()
+	cli := s.osbClient(broker)
+	cli.password = "wrong"
+	_, err := cli.Plans("")
+	c.Assert(err, check.ErrorMatches, `Failed to get the broker catalog: invalid response from broker \(code 401\): invalid credentials`)
+}
+
+func (s *S) TestOSBCreate(c *check.C) {
+	broker := osbtest.NewBroker("mysql")
+	defer broker.Close()
+	instance := ServiceInstance{
+		Name:        "my-mysql",
+		ServiceName: "mysql",
+		PlanName:    "big",
+		TeamOwner:   "myteam",
+		Parameters:  map[string]string{"size": "10"},
+	}
+	err := s.osbClient(broker).Create(&instance, "me@tsuru.io", "")
+	c.Assert(err, check.IsNil)
+	c.Assert(instance.State, check.Equals, InstanceStateReady)
+	brokerInstance := broker.Instance("my-mysql")
+	c.Assert(brokerInstance, check.NotNil)
+	c.Assert(brokerInstance.ServiceID, check.Equals, "mysql-id")
+	c.Assert(brokerInstance.PlanID, check.Equals, "big-id")
+	c.Assert(brokerInstance.OrganizationGUID, check.Equals, "myteam")
+	c.Assert(brokerInstance.Parameters, check.DeepEquals, map[string]interface{}{"size": "10"})
+	c.Assert(brokerInstance.Context["platform"], check.Equals, "tsuru")
+	c.Assert(brokerInstance.Context["user"], check.Equals, "me@tsuru.io")
+}
+
+func (s *S) TestOSBCreateDefaultPlan(c *check.C) {
+	broker := osbtest.NewBroker("mysql")
+	defer broker.Close()
+	instance := ServiceInstance{Name: "my-mysql", ServiceName: "mysql", TeamOwner: "myteam"}
+	err := s.osbClient(broker).Create(&instance, "me@tsuru.io", "")
+	c.Assert(err, check.IsNil)
+	c.Assert(broker.Instance("my-mysql").PlanID, check.Equals, "small-id")
+}
+
+func (s *S) TestOSBCreateUnknownPlan(c *check.C) {
+	broker := osbtest.NewBroker("mysql")
+	defer broker.Close()
+	instance := ServiceInstance{Name: "my-mysql", ServiceName: "mysql", PlanName: "huge"}
+	err := s.osbClient(broker).Create(&instance, "me@tsuru.io", "")
+	c.Assert(err, check.ErrorMatches, `plan "huge" not found in service "mysql"`)
+	c.Assert(broker.Instance("my-mysql"), check.IsNil)
+}
+
+func (s *S) TestOSBCreateConflict(c *check.C) {
+	broker := osbtest.NewBroker("mysql")
+	defer broker.Close()
+	cli := s.osbClient(broker)
+	instance := ServiceInstance{Name: "my-mysql", ServiceName: "mysql"}
+	err := cli.Create(&instance, "me@tsuru.io", "")
+	c.Assert(err, check.IsNil)
+	err = cli.Create(&instance, "me@tsuru.io", "")
+	c.Assert(err, check.Equals, ErrInstanceAlreadyExistsInAPI)
+}
+
+func (s *S) TestOSBCreateAsync(c *check.C) {
+	broker := osbtest.NewBroker("mysql")
+	broker.Async = true
+	defer broker.Close()
+	cli := s.osbClient(broker)
+	instance := ServiceInstance{Name: "my-mysql", ServiceName: "mysql"}
+	err := cli.Create(&instance, "me@tsuru.io", "")
+	c.Assert(err, check.IsNil)
+	c.Assert(instance.State, check.Equals, InstanceStatePending)
+	c.Assert(instance.BrokerOperation, check.Equals, "provision-my-mysql")
+	status, err := cli.Status(&instance, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(status, check.Equals, "pending")
+	broker.FinishOperations("succeeded")
+	status, err = cli.Status(&instance, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(status, check.Equals, "up")
+}
+
+func (s *S) TestOSBStatusFailedOperation(c *check.C) {
+	broker := osbtest.NewBroker("mysql")
+	broker.Async = true
+	defer broker.Close()
+	cli := s.osbClient(broker)
+	instance := ServiceInstance{Name: "my-mysql", ServiceName: "mysql"}
+	err := cli.Create(&instance, "me@tsuru.io", "")
+	c.Assert(err, check.IsNil)
+	broker.FinishOperations("failed")
+	status, err := cli.Status(&instance, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(status, check.Equals, "down")
+}
+
+func (s *S) TestOSBStatusReadyInstance(c *check.C) {
+	broker := osbtest.NewBroker("mysql")
+	broker.Close()
+	instance := ServiceInstance{Name: "my-mysql", ServiceName: "mysql", State: InstanceStateReady}
+	status, err := s.osbClient(broker).Status(&instance, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(status, check.Equals, "up")
+}
+
+func (s *S) TestOSBUpdate(c *check.C) {
+	broker := osbtest.NewBroker("mysql")
+	defer broker.Close()
+	cli := s.osbClient(broker)
+	instance := ServiceInstance{Name: "my-mysql", ServiceName: "mysql", PlanName: "small"}
+	err := cli.Create(&instance, "me@tsuru.io", "")
+	c.Assert(err, check.IsNil)
+	instance.PlanName = "big"
+	instance.Parameters = map[string]string{"size": "20"}
+	err = cli.Update(&instance, "")
+	c.Assert(err, check.IsNil)
+	brokerInstance := broker.Instance("my-mysql")
+	c.Assert(brokerInstance.PlanID, check.Equals, "big-id")
+	c.Assert(brokerInstance.Parameters, check.DeepEquals, map[string]interface{}{"size": "20"})
+}
+
+func (s *S) TestOSBDestroy(c *check.C) {
+	broker := osbtest.NewBroker("mysql")
+	defer broker.Close()
+	cli := s.osbClient(broker)
+	instance := ServiceInstance{Name: "my-mysql", ServiceName: "mysql"}
+	err := cli.Create(&instance, "me@tsuru.io", "")
+	c.Assert(err, check.IsNil)
+	err = cli.Destroy(&instance, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(broker.Instance("my-mysql"), check.IsNil)
+	err = cli.Destroy(&instance, "")
+	c.Assert(err, check.Equals, ErrInstanceNotFoundInAPI)
+}
+
+func (s *S) TestOSBBindAndUnbindApp(c *check.C) {
+	broker := osbtest.NewBroker("mysql")
+	broker.Credentials = map[string]interface{}{
+		"uri":     "mysql://user:pass@db:3306/app",
+		"port":    3306,
+		"db-name": "app",
+	}
+	defer broker.Close()
+	cli := s.osbClient(broker)
+	instance := ServiceInstance{Name: "my-mysql", ServiceName: "mysql"}
+	err := cli.Create(&instance, "me@tsuru.io", "")
+	c.Assert(err, check.IsNil)
+	a := provisiontest.NewFakeApp("myapp", "static", 1)
+	envs, err := cli.BindApp(&instance, a, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(envs, check.DeepEquals, map[string]string{
+		"URI":     "mysql://user:pass@db:3306/app",
+		"PORT":    "3306",
+		"DB_NAME": "app",
+	})
+	binding := broker.Binding("my-mysql-myapp")
+	c.Assert(binding, check.NotNil)
+	c.Assert(binding.AppGUID, check.Equals, "myapp")
+	c.Assert(binding.PlanID, check.Equals, "small-id")
+	err = cli.UnbindApp(&instance, a)
+	c.Assert(err, check.IsNil)
+	c.Assert(broker.Binding("my-mysql-myapp"), check.IsNil)
+	err = cli.UnbindApp(&instance, a)
+	c.Assert(err, check.Equals, ErrInstanceNotFoundInAPI)
+}
+
+func (s *S) TestOSBBindAppWithParameters(c *check.C) {
+	broker := osbtest.NewBroker("mysql")
+	defer broker.Close()
+	cli := s.osbClient(broker)
+	instance := ServiceInstance{Name: "my-mysql", ServiceName: "mysql"}
+	err := cli.Create(&instance, "me@tsuru.io", "")
+	c.Assert(err, check.IsNil)
+	a := provisiontest.NewFakeApp("myapp", "static", 1)
+	_, err = cli.BindApp(&instance, a, map[string]string{"role": "readonly"})
+	c.Assert(err, check.IsNil)
+	binding := broker.Binding("my-mysql-myapp")
+	c.Assert(binding, check.NotNil)
+	c.Assert(binding.Parameters, check.DeepEquals, map[string]interface{}{"role": "readonly"})
+}
+
+func (s *S) TestOSBBindAppInstanceNotFound(c *check.C) {
+	broker := osbtest.NewBroker("mysql")
+	defer broker.Close()
+	instance := ServiceInstance{Name: "my-mysql", ServiceName: "mysql"}
+	a := provisiontest.NewFakeApp("myapp", "static", 1)
+	_, err := s.osbClient(broker).BindApp(&instance, a, nil)
+	c.Assert(err, check.Equals, ErrInstanceNotFoundInAPI)
+}
+
+func (s *S) TestOSBProxyNotSupported(c *check.C) {
+	broker := osbtest.NewBroker("mysql")
+	defer broker.Close()
+	err := s.osbClient(broker).Proxy("/", nil, nil)
+	c.Assert(err, check.Equals, errOSBProxyNotSupported)
+}
+
+func (s *S) TestOSBServiceInstanceLifecycle(c *check.C) {
+	broker := osbtest.NewBroker("mysql")
+	broker.Credentials = map[string]interface{}{"host": "db.tsuru.io"}
+	defer broker.Close()
+	srv := Service{
+		Name:     "mysql",
+		Username: osbtest.Username,
+		Password: osbtest.Password,
+		Endpoint: map[string]string{"production": broker.URL()},
+		Type:     ServiceTypeOSB,
+	}
+	err := s.conn.Services().Insert(&srv)
+	c.Assert(err, check.IsNil)
+	err = CreateServiceInstance(ServiceInstance{Name: "my-mysql", PlanName: "big", TeamOwner: s.team.Name}, &srv, s.user, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(broker.Instance("my-mysql").PlanID, check.Equals, "big-id")
+	si, err := GetServiceInstance("mysql", "my-mysql")
+	c.Assert(err, check.IsNil)
+	c.Assert(si.State, check.Equals, InstanceStateReady)
+	a := provisiontest.NewFakeApp("myapp", "static", 1)
+	var buf bytes.Buffer
+	err = si.BindApp(a, nil, false, &buf)
+	c.Assert(err, check.IsNil)
+	c.Assert(a.GetInstances("mysql"), check.DeepEquals, []bind.ServiceInstance{
+		{Name: "my-mysql", Envs: map[string]string{"HOST": "db.tsuru.io"}},
+	})
+	err = si.UnbindApp(a, false, &buf)
+	c.Assert(err, check.IsNil)
+	c.Assert(broker.Binding("my-mysql-myapp"), check.IsNil)
+	err = DeleteInstance(si, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(broker.Instance("my-mysql"), check.IsNil)
+}
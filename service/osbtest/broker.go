@@ -0,0 +1,287 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package osbtest provides a fake Open Service Broker, running in process,
+// for use in tests.
+//
+// The broker exposes a catalog with a single service and keeps the instances
+// and bindings created by the platform in memory, allowing users of the
+// package to inspect them.
+package osbtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+const (
+	Username = "broker"
+	Password = "s3cr3t"
+)
+
+type Plan struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type Service struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Bindable    bool   `json:"bindable"`
+	Plans       []Plan `json:"plans"`
+}
+
+// Instance is a service instance provisioned in the broker.
+type Instance struct {
+	ServiceID        string                 `json:"service_id"`
+	PlanID           string                 `json:"plan_id"`
+	OrganizationGUID string                 `json:"organization_guid"`
+	SpaceGUID        string                 `json:"space_guid"`
+	Parameters       map[string]interface{} `json:"parameters"`
+	Context          map[string]interface{} `json:"context"`
+
+	// State is the state of the last operation on the instance: "in
+	// progress", "succeeded" or "failed".
+	State string `json:"-"`
+}
+
+// Binding is a service binding created in the broker.
+type Binding struct {
+	ServiceID  string                 `json:"service_id"`
+	PlanID     string                 `json:"plan_id"`
+	AppGUID    string                 `json:"app_guid"`
+	Parameters map[string]interface{} `json:"parameters"`
+}
+
+// Broker is a fake Open Service Broker.
+type Broker struct {
+	Service Service
+
+	// Async makes the broker provision instances asynchronously, the
+	// operations being kept in progress until FinishOperations is called.
+	Async bool
+
+	// Credentials are returned in every new binding.
+	Credentials map[string]interface{}
+
+	mu        sync.Mutex
+	server    *httptest.Server
+	instances map[string]*Instance
+	bindings  map[string]*Binding
+}
+
+// NewBroker starts a new fake broker, whose catalog has one service named
+// serviceName with the plans "small" and "big".
+func NewBroker(serviceName string) *Broker {
+	b := &Broker{
+		Service: Service{
+			ID:          serviceName + "-id",
+			Name:        serviceName,
+			Description: "fake service " + serviceName,
+			Bindable:    true,
+			Plans: []Plan{
+				{ID: "small-id", Name: "small", Description: "small plan"},
+				{ID: "big-id", Name: "big", Description: "big plan"},
+			},
+		},
+		instances: map[string]*Instance{},
+		bindings:  map[string]*Binding{},
+	}
+	b.server = httptest.NewServer(http.HandlerFunc(b.serveHTTP))
+	return b
+}
+
+// URL returns the endpoint of the broker.
+func (b *Broker) URL() string {
+	return b.server.URL
+}
+
+// Close stops the broker.
+func (b *Broker) Close() {
+	b.server.Close()
+}
+
+// Instance returns a copy of the instance with the given id, or nil if it's
+// not provisioned.
+func (b *Broker) Instance(id string) *Instance {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	instance, ok := b.instances[id]
+	if !ok {
+		return nil
+	}
+	copy := *instance
+	return &copy
+}
+
+// Binding returns a copy of the binding with the given id, or nil if it
+// doesn't exist.
+func (b *Broker) Binding(id string) *Binding {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	binding, ok := b.bindings[id]
+	if !ok {
+		return nil
+	}
+	copy := *binding
+	return &copy
+}
+
+// FinishOperations marks every operation in progress with the given state,
+// "succeeded" or "failed".
+func (b *Broker) FinishOperations(state string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, instance := range b.instances {
+		if instance.State == "in progress" {
+			instance.State = state
+		}
+	}
+}
+
+func (b *Broker) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Broker-API-Version") == "" {
+		writeError(w, http.StatusPreconditionFailed, "missing X-Broker-API-Version header")
+		return
+	}
+	if user, pass, ok := r.BasicAuth(); !ok || user != Username || pass != Password {
+		writeError(w, http.StatusUnauthorized, "invalid credentials")
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 2 && parts[1] == "catalog" && r.Method == "GET":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"services": []Service{b.Service}})
+	case len(parts) == 3 && parts[1] == "service_instances":
+		b.handleInstance(w, r, parts[2])
+	case len(parts) == 4 && parts[1] == "service_instances" && parts[3] == "last_operation" && r.Method == "GET":
+		instance, ok := b.instances[parts[2]]
+		if !ok {
+			writeError(w, http.StatusGone, "instance not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"state": instance.State})
+	case len(parts) == 5 && parts[1] == "service_instances" && parts[3] == "service_bindings":
+		b.handleBinding(w, r, parts[2], parts[4])
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (b *Broker) handleInstance(w http.ResponseWriter, r *http.Request, id string) {
+	switch r.Method {
+	case "PUT":
+		if _, ok := b.instances[id]; ok {
+			writeError(w, http.StatusConflict, "instance already exists")
+			return
+		}
+		var instance Instance
+		if err := json.NewDecoder(r.Body).Decode(&instance); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !b.validPlan(instance.ServiceID, instance.PlanID) {
+			writeError(w, http.StatusBadRequest, "invalid service or plan")
+			return
+		}
+		b.instances[id] = &instance
+		if b.Async && r.URL.Query().Get("accepts_incomplete") == "true" {
+			instance.State = "in progress"
+			writeJSON(w, http.StatusAccepted, map[string]string{"operation": "provision-" + id})
+			return
+		}
+		instance.State = "succeeded"
+		writeJSON(w, http.StatusCreated, map[string]string{})
+	case "PATCH":
+		instance, ok := b.instances[id]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "instance not found")
+			return
+		}
+		var update Instance
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if update.PlanID != "" {
+			if !b.validPlan(instance.ServiceID, update.PlanID) {
+				writeError(w, http.StatusBadRequest, "invalid plan")
+				return
+			}
+			instance.PlanID = update.PlanID
+		}
+		if update.Parameters != nil {
+			instance.Parameters = update.Parameters
+		}
+		writeJSON(w, http.StatusOK, map[string]string{})
+	case "DELETE":
+		if _, ok := b.instances[id]; !ok {
+			writeError(w, http.StatusGone, "instance not found")
+			return
+		}
+		delete(b.instances, id)
+		writeJSON(w, http.StatusOK, map[string]string{})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (b *Broker) handleBinding(w http.ResponseWriter, r *http.Request, instanceID, id string) {
+	if _, ok := b.instances[instanceID]; !ok {
+		writeError(w, http.StatusNotFound, "instance not found")
+		return
+	}
+	switch r.Method {
+	case "PUT":
+		var binding Binding
+		if err := json.NewDecoder(r.Body).Decode(&binding); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		status := http.StatusCreated
+		if _, ok := b.bindings[id]; ok {
+			status = http.StatusOK
+		}
+		b.bindings[id] = &binding
+		writeJSON(w, status, map[string]interface{}{"credentials": b.Credentials})
+	case "DELETE":
+		if _, ok := b.bindings[id]; !ok {
+			writeError(w, http.StatusGone, "binding not found")
+			return
+		}
+		delete(b.bindings, id)
+		writeJSON(w, http.StatusOK, map[string]string{})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (b *Broker) validPlan(serviceID, planID string) bool {
+	if serviceID != b.Service.ID {
+		return false
+	}
+	for _, plan := range b.Service.Plans {
+		if plan.ID == planID {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+func writeError(w http.ResponseWriter, status int, description string) {
+	writeJSON(w, status, map[string]string{"description": description})
+}
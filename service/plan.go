@@ -16,7 +16,7 @@ func GetPlansByServiceName(serviceName, requestID string) ([]Plan, error) {
 	if err != nil {
 		return nil, err
 	}
-	endpoint, err := s.getClient("production")
+	endpoint, err := s.getServiceClient("production")
 	if err != nil {
 		return []Plan{}, nil
 	}
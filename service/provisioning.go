@@ -0,0 +1,324 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+	"github.com/tsuru/monsterqueue"
+	"github.com/tsuru/tsuru/api/shutdown"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/queue"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const provisionCheckTaskName = "service-instance-provision-check"
+
+// Pending instances are checked in the service API with an interval that
+// starts at provisionCheckInterval and doubles on each check, up to
+// provisionCheckMaxInterval. The schedule is stored in the instance, so
+// checks survive restarts of the API and lost jobs: the scheduler enqueues
+// a check for every pending instance whose next check is due, claiming it
+// for provisionCheckLease so that it's enqueued again if the job is lost.
+var (
+	provisionCheckInterval     = 5 * time.Second
+	provisionCheckMaxInterval  = 5 * time.Minute
+	provisionCheckLease        = 5 * time.Minute
+	provisionSchedulerInterval = 5 * time.Second
+	bindWaitInterval           = 5 * time.Second
+)
+
+// ProvisionCheck is the schedule of checks of a pending instance.
+type ProvisionCheck struct {
+	Deadline time.Time
+	Next     time.Time
+	Interval time.Duration
+}
+
+type provisionCheckTask struct{}
+
+func (t *provisionCheckTask) Name() string {
+	return provisionCheckTaskName
+}
+
+// Run checks the status of a pending instance once. The next check is
+// scheduled in the instance before the job is acknowledged, instead of
+// holding the queue worker.
+func (t *provisionCheckTask) Run(job monsterqueue.Job) {
+	params := job.Parameters()
+	serviceName, _ := params["serviceName"].(string)
+	instanceName, _ := params["instanceName"].(string)
+	if serviceName == "" || instanceName == "" {
+		job.Error(errors.New("invalid parameters, expected serviceName and instanceName"))
+		return
+	}
+	err := checkInstanceProvision(serviceName, instanceName)
+	if err != nil {
+		log.Errorf("[service-instance-provision-check] error checking %s/%s: %s", serviceName, instanceName, err)
+		job.Error(err)
+		return
+	}
+	job.Success(nil)
+}
+
+// RegisterTask registers the task following the provisioning of instances
+// created asynchronously by service APIs, enqueueing the checks of pending
+// instances left behind by a previous run of the API.
+func RegisterTask() error {
+	q, err := queue.Queue()
+	if err != nil {
+		return err
+	}
+	err = q.RegisterTask(&provisionCheckTask{})
+	if err != nil {
+		return err
+	}
+	return enqueueDueProvisionChecks(q)
+}
+
+func enqueueProvisionCheckTask(si *ServiceInstance) error {
+	q, err := queue.Queue()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	si.ProvisionCheck = &ProvisionCheck{
+		Deadline: now.Add(provisionTimeout()),
+		Next:     now,
+		Interval: provisionCheckInterval,
+	}
+	err = si.update(bson.M{"$set": bson.M{"provision_check": si.ProvisionCheck}})
+	if err != nil {
+		return err
+	}
+	return claimProvisionCheck(q, si, now)
+}
+
+// enqueueDueProvisionChecks enqueues the checks of the pending instances
+// whose next check is due. Instances without a schedule are checked right
+// away.
+func enqueueDueProvisionChecks(q monsterqueue.Queue) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	var instances []ServiceInstance
+	now := time.Now()
+	err = conn.ServiceInstances().Find(dueProvisionCheckQuery(now)).All(&instances)
+	conn.Close()
+	if err != nil {
+		return errors.Wrap(err, "error listing pending service instances")
+	}
+	for i := range instances {
+		err = claimProvisionCheck(q, &instances[i], now)
+		if err != nil {
+			log.Errorf("[service-instance-provision-check] unable to enqueue check of %s/%s: %s", instances[i].ServiceName, instances[i].Name, err)
+		}
+	}
+	return nil
+}
+
+func dueProvisionCheckQuery(now time.Time) bson.M {
+	return bson.M{
+		"state": InstanceStatePending,
+		"$or": []bson.M{
+			{"provision_check.next": bson.M{"$exists": false}},
+			{"provision_check.next": bson.M{"$lte": now}},
+		},
+	}
+}
+
+// claimProvisionCheck postpones the next check of the instance by the lease
+// and enqueues the check. The update only matches while the check is due,
+// so each check is enqueued once even with many API instances scanning
+// pending instances.
+func claimProvisionCheck(q monsterqueue.Queue, si *ServiceInstance, now time.Time) error {
+	check := si.ProvisionCheck
+	if check == nil {
+		check = &ProvisionCheck{Deadline: now.Add(provisionTimeout()), Interval: provisionCheckInterval}
+	}
+	claimed := *check
+	claimed.Next = now.Add(provisionCheckLease)
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	query := dueProvisionCheckQuery(now)
+	query["name"] = si.Name
+	query["service_name"] = si.ServiceName
+	err = conn.ServiceInstances().Update(query, bson.M{"$set": bson.M{"provision_check": claimed}})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	si.ProvisionCheck = &claimed
+	_, err = q.Enqueue(provisionCheckTaskName, monsterqueue.JobParams{
+		"serviceName":  si.ServiceName,
+		"instanceName": si.Name,
+	})
+	return err
+}
+
+func provisionTimeout() time.Duration {
+	timeout, _ := config.GetInt("services:provision-timeout")
+	if timeout <= 0 {
+		timeout = 3600
+	}
+	return time.Duration(timeout) * time.Second
+}
+
+// checkInstanceProvision checks the status of a pending instance in the
+// service API. The instance is marked as ready once the service API reports
+// it as up, any other status keeps it pending until the deadline, when it's
+// marked as failed. While pending, the next check is scheduled in the
+// instance, doubling the interval.
+func checkInstanceProvision(serviceName, instanceName string) error {
+	si, err := GetServiceInstance(serviceName, instanceName)
+	if err == ErrServiceInstanceNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if si.GetState() != InstanceStatePending {
+		return nil
+	}
+	now := time.Now()
+	check := si.ProvisionCheck
+	if check == nil {
+		check = &ProvisionCheck{Deadline: now.Add(provisionTimeout()), Interval: provisionCheckInterval}
+	}
+	status := si.checkProvision()
+	if status == "up" {
+		return si.finishProvision(InstanceStateReady, "")
+	}
+	if now.After(check.Deadline) {
+		msg := "provisioning not finished in time"
+		if status != "" {
+			msg = fmt.Sprintf("%s, last status reported by the service API: %s", msg, status)
+		}
+		return si.finishProvision(InstanceStateFailed, msg)
+	}
+	interval := check.Interval
+	if interval <= 0 {
+		interval = provisionCheckInterval
+	}
+	next := ProvisionCheck{Deadline: check.Deadline, Next: now.Add(interval), Interval: interval * 2}
+	if next.Interval > provisionCheckMaxInterval {
+		next.Interval = provisionCheckMaxInterval
+	}
+	si.ProvisionCheck = &next
+	return si.update(bson.M{"$set": bson.M{"provision_check": next}})
+}
+
+// checkProvision asks the service API for the status of the instance. Errors
+// talking to the service API are logged and reported as an empty status.
+func (si *ServiceInstance) checkProvision() string {
+	status, err := si.Status("")
+	if err != nil {
+		log.Errorf("[service-instance-provision-check] unable to get status of %s/%s: %s", si.ServiceName, si.Name, err)
+		return ""
+	}
+	return status
+}
+
+func (si *ServiceInstance) setState(state, message string) error {
+	si.State = state
+	si.StateMessage = message
+	return si.update(bson.M{"$set": bson.M{"state": state, "state_message": message}})
+}
+
+func (si *ServiceInstance) finishProvision(state, message string) error {
+	si.State = state
+	si.StateMessage = message
+	si.ProvisionCheck = nil
+	return si.update(bson.M{
+		"$set":   bson.M{"state": state, "state_message": message},
+		"$unset": bson.M{"provision_check": ""},
+	})
+}
+
+// waitReady waits for a pending instance to be ready, checking its state in
+// the database up to the given timeout.
+func (si *ServiceInstance) waitReady(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		switch si.GetState() {
+		case InstanceStateReady:
+			return nil
+		case InstanceStateFailed:
+			return errors.Errorf("service instance %q failed to provision: %s", si.Name, si.StateMessage)
+		}
+		if !time.Now().Before(deadline) {
+			return ErrInstanceNotReady
+		}
+		time.Sleep(bindWaitInterval)
+		current, err := GetServiceInstance(si.ServiceName, si.Name)
+		if err != nil {
+			return err
+		}
+		si.State = current.State
+		si.StateMessage = current.StateMessage
+	}
+}
+
+type provisionScheduler struct {
+	runInterval time.Duration
+	done        chan bool
+}
+
+// InitializeProvisionChecks starts the background worker that enqueues the
+// due checks of pending instances, resuming checks whose job was lost.
+func InitializeProvisionChecks() {
+	scheduler := &provisionScheduler{
+		runInterval: provisionSchedulerInterval,
+		done:        make(chan bool),
+	}
+	shutdown.Register(scheduler)
+	go scheduler.Run()
+}
+
+func (s *provisionScheduler) Run() {
+	for {
+		err := s.runOnce()
+		if err != nil {
+			log.Errorf("[service-instance-provision-check] %s", err)
+		}
+		select {
+		case <-s.done:
+			return
+		case <-time.After(s.runInterval):
+		}
+	}
+}
+
+func (s *provisionScheduler) Shutdown() {
+	s.done <- true
+}
+
+func (s *provisionScheduler) String() string {
+	return "service instances provision checks scheduler"
+}
+
+func (s *provisionScheduler) runOnce() (retErr error) {
+	defer func() {
+		if r := recover(); r != nil {
+			retErr = errors.Errorf("recovered panic, we can never stop! panic: %v", r)
+		}
+	}()
+	q, err := queue.Queue()
+	if err != nil {
+		return err
+	}
+	return enqueueDueProvisionChecks(q)
+}
@@ -0,0 +1,252 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	"github.com/tsuru/tsuru/queue"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) statusServer(statuses ...int) *httptest.Server {
+	var calls int32
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		idx := int(atomic.AddInt32(&calls, 1)) - 1
+		if idx >= len(statuses) {
+			idx = len(statuses) - 1
+		}
+		w.WriteHeader(statuses[idx])
+	}))
+}
+
+func (s *S) createPendingInstance(c *check.C, endpoint string) *ServiceInstance {
+	srv := Service{Name: "mysql", Endpoint: map[string]string{"production": endpoint}}
+	err := s.conn.Services().Insert(&srv)
+	c.Assert(err, check.IsNil)
+	si := ServiceInstance{
+		Name:        "my-mysql",
+		ServiceName: "mysql",
+		State:       InstanceStatePending,
+		ProvisionCheck: &ProvisionCheck{
+			Deadline: time.Now().Add(time.Minute),
+			Next:     time.Now(),
+			Interval: time.Second,
+		},
+	}
+	err = s.conn.ServiceInstances().Insert(&si)
+	c.Assert(err, check.IsNil)
+	return &si
+}
+
+func (s *S) expireProvision(c *check.C) {
+	err := s.conn.ServiceInstances().Update(
+		bson.M{"name": "my-mysql", "service_name": "mysql"},
+		bson.M{"$set": bson.M{"provision_check.deadline": time.Now().Add(-time.Second)}},
+	)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestCheckInstanceProvisionReady(c *check.C) {
+	ts := s.statusServer(http.StatusNoContent)
+	defer ts.Close()
+	s.createPendingInstance(c, ts.URL)
+	err := checkInstanceProvision("mysql", "my-mysql")
+	c.Assert(err, check.IsNil)
+	si, err := GetServiceInstance("mysql", "my-mysql")
+	c.Assert(err, check.IsNil)
+	c.Assert(si.State, check.Equals, InstanceStateReady)
+	c.Assert(si.StateMessage, check.Equals, "")
+	c.Assert(si.ProvisionCheck, check.IsNil)
+}
+
+func (s *S) TestCheckInstanceProvisionPending(c *check.C) {
+	tests := []int{http.StatusAccepted, http.StatusInternalServerError, http.StatusNotFound, http.StatusBadRequest}
+	for _, status := range tests {
+		ts := s.statusServer(status)
+		s.createPendingInstance(c, ts.URL)
+		before := time.Now()
+		err := checkInstanceProvision("mysql", "my-mysql")
+		c.Check(err, check.IsNil)
+		si, err := GetServiceInstance("mysql", "my-mysql")
+		c.Check(err, check.IsNil)
+		c.Check(si.State, check.Equals, InstanceStatePending)
+		if c.Check(si.ProvisionCheck, check.NotNil) {
+			c.Check(si.ProvisionCheck.Interval, check.Equals, 2*time.Second)
+			c.Check(si.ProvisionCheck.Next.After(before), check.Equals, true)
+		}
+		ts.Close()
+		s.conn.Services().RemoveId("mysql")
+		s.conn.ServiceInstances().Remove(map[string]string{"name": "my-mysql"})
+	}
+}
+
+func (s *S) TestCheckInstanceProvisionDownUntilDeadline(c *check.C) {
+	ts := s.statusServer(http.StatusInternalServerError)
+	defer ts.Close()
+	s.createPendingInstance(c, ts.URL)
+	s.expireProvision(c)
+	err := checkInstanceProvision("mysql", "my-mysql")
+	c.Assert(err, check.IsNil)
+	si, err := GetServiceInstance("mysql", "my-mysql")
+	c.Assert(err, check.IsNil)
+	c.Assert(si.State, check.Equals, InstanceStateFailed)
+	c.Assert(si.StateMessage, check.Equals, "provisioning not finished in time, last status reported by the service API: down")
+}
+
+func (s *S) TestCheckInstanceProvisionTimeout(c *check.C) {
+	ts := s.statusServer(http.StatusAccepted)
+	defer ts.Close()
+	s.createPendingInstance(c, ts.URL)
+	s.expireProvision(c)
+	err := checkInstanceProvision("mysql", "my-mysql")
+	c.Assert(err, check.IsNil)
+	si, err := GetServiceInstance("mysql", "my-mysql")
+	c.Assert(err, check.IsNil)
+	c.Assert(si.State, check.Equals, InstanceStateFailed)
+	c.Assert(si.StateMessage, check.Equals, "provisioning not finished in time, last status reported by the service API: pending")
+}
+
+func (s *S) TestCheckInstanceProvisionNotPending(c *check.C) {
+	ts := s.statusServer(http.StatusInternalServerError)
+	defer ts.Close()
+	si := s.createPendingInstance(c, ts.URL)
+	err := si.setState(InstanceStateReady, "")
+	c.Assert(err, check.IsNil)
+	s.expireProvision(c)
+	err = checkInstanceProvision("mysql", "my-mysql")
+	c.Assert(err, check.IsNil)
+	dbSi, err := GetServiceInstance("mysql", "my-mysql")
+	c.Assert(err, check.IsNil)
+	c.Assert(dbSi.State, check.Equals, InstanceStateReady)
+}
+
+func (s *S) TestProvisionCheckTaskSchedulesNextCheck(c *check.C) {
+	ts := s.statusServer(http.StatusAccepted)
+	defer ts.Close()
+	s.createPendingInstance(c, ts.URL)
+	q, err := queue.Queue()
+	c.Assert(err, check.IsNil)
+	err = enqueueDueProvisionChecks(q)
+	c.Assert(err, check.IsNil)
+	err = queue.TestingWaitQueueTasks(1, 10*time.Second)
+	c.Assert(err, check.IsNil)
+	si, err := GetServiceInstance("mysql", "my-mysql")
+	c.Assert(err, check.IsNil)
+	c.Assert(si.State, check.Equals, InstanceStatePending)
+	c.Assert(si.ProvisionCheck, check.NotNil)
+	c.Assert(si.ProvisionCheck.Interval, check.Equals, 2*time.Second)
+	c.Assert(si.ProvisionCheck.Next.After(time.Now()), check.Equals, true)
+	c.Assert(si.ProvisionCheck.Next.Before(time.Now().Add(provisionCheckLease)), check.Equals, true)
+}
+
+func (s *S) TestEnqueueDueProvisionChecks(c *check.C) {
+	ts := s.statusServer(http.StatusNoContent)
+	defer ts.Close()
+	s.createPendingInstance(c, ts.URL)
+	notDue := ServiceInstance{
+		Name:           "other-mysql",
+		ServiceName:    "mysql",
+		State:          InstanceStatePending,
+		ProvisionCheck: &ProvisionCheck{Deadline: time.Now().Add(time.Hour), Next: time.Now().Add(time.Hour)},
+	}
+	err := s.conn.ServiceInstances().Insert(&notDue)
+	c.Assert(err, check.IsNil)
+	legacy := ServiceInstance{Name: "legacy-mysql", ServiceName: "mysql", State: InstanceStatePending}
+	err = s.conn.ServiceInstances().Insert(&legacy)
+	c.Assert(err, check.IsNil)
+	q, err := queue.Queue()
+	c.Assert(err, check.IsNil)
+	err = enqueueDueProvisionChecks(q)
+	c.Assert(err, check.IsNil)
+	err = enqueueDueProvisionChecks(q)
+	c.Assert(err, check.IsNil)
+	err = queue.TestingWaitQueueTasks(2, 10*time.Second)
+	c.Assert(err, check.IsNil)
+	for _, name := range []string{"my-mysql", "legacy-mysql"} {
+		si, err := GetServiceInstance("mysql", name)
+		c.Assert(err, check.IsNil)
+		c.Assert(si.State, check.Equals, InstanceStateReady)
+	}
+	si, err := GetServiceInstance("mysql", "other-mysql")
+	c.Assert(err, check.IsNil)
+	c.Assert(si.State, check.Equals, InstanceStatePending)
+}
+
+func (s *S) TestRegisterTaskEnqueuesPendingInstances(c *check.C) {
+	ts := s.statusServer(http.StatusNoContent)
+	defer ts.Close()
+	s.createPendingInstance(c, ts.URL)
+	queue.ResetQueue()
+	err := RegisterTask()
+	c.Assert(err, check.IsNil)
+	err = queue.TestingWaitQueueTasks(1, 10*time.Second)
+	c.Assert(err, check.IsNil)
+	si, err := GetServiceInstance("mysql", "my-mysql")
+	c.Assert(err, check.IsNil)
+	c.Assert(si.State, check.Equals, InstanceStateReady)
+}
+
+func (s *S) TestCreateServiceInstanceAsync(c *check.C) {
+	defer func(interval time.Duration) { provisionCheckInterval = interval }(provisionCheckInterval)
+	provisionCheckInterval = time.Millisecond
+	ts := s.statusServer(http.StatusAccepted, http.StatusNoContent)
+	defer ts.Close()
+	srv := Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := s.conn.Services().Insert(&srv)
+	c.Assert(err, check.IsNil)
+	instance := ServiceInstance{Name: "my-mysql", TeamOwner: s.team.Name}
+	err = CreateServiceInstance(instance, &srv, s.user, "")
+	c.Assert(err, check.IsNil)
+	err = queue.TestingWaitQueueTasks(1, 10*time.Second)
+	c.Assert(err, check.IsNil)
+	si, err := GetServiceInstance("mysql", "my-mysql")
+	c.Assert(err, check.IsNil)
+	c.Assert(si.State, check.Equals, InstanceStatePending)
+	time.Sleep(10 * time.Millisecond)
+	q, err := queue.Queue()
+	c.Assert(err, check.IsNil)
+	err = enqueueDueProvisionChecks(q)
+	c.Assert(err, check.IsNil)
+	err = queue.TestingWaitQueueTasks(1, 10*time.Second)
+	c.Assert(err, check.IsNil)
+	si, err = GetServiceInstance("mysql", "my-mysql")
+	c.Assert(err, check.IsNil)
+	c.Assert(si.State, check.Equals, InstanceStateReady)
+}
+
+func (s *S) TestWaitReady(c *check.C) {
+	ts := s.statusServer(http.StatusAccepted)
+	defer ts.Close()
+	si := s.createPendingInstance(c, ts.URL)
+	err := si.waitReady(0)
+	c.Assert(err, check.Equals, ErrInstanceNotReady)
+	si.State = InstanceStateReady
+	err = si.waitReady(0)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestWaitReadyFailed(c *check.C) {
+	defer func(interval time.Duration) { bindWaitInterval = interval }(bindWaitInterval)
+	bindWaitInterval = time.Millisecond
+	ts := s.statusServer(http.StatusAccepted)
+	defer ts.Close()
+	si := s.createPendingInstance(c, ts.URL)
+	err := s.conn.ServiceInstances().Update(
+		map[string]string{"name": "my-mysql"},
+		map[string]interface{}{"$set": map[string]string{"state": InstanceStateFailed, "state_message": "no space left"}},
+	)
+	c.Assert(err, check.IsNil)
+	err = si.waitReady(time.Second)
+	c.Assert(err, check.ErrorMatches, `service instance "my-mysql" failed to provision: no space left`)
+}
@@ -9,6 +9,7 @@ import (
 	"regexp"
 
 	"github.com/pkg/errors"
+	"github.com/tsuru/tsuru/app/bind"
 	"github.com/tsuru/tsuru/auth"
 	"github.com/tsuru/tsuru/db"
 	"gopkg.in/mgo.v2/bson"
@@ -23,12 +24,46 @@ type Service struct {
 	Teams        []string
 	Doc          string
 	IsRestricted bool `bson:"is_restricted"`
+	// Type is the dialect spoken by the service endpoint, either the tsuru
+	// service API (the default) or the Open Service Broker API.
+	Type string `bson:"type,omitempty"`
 }
 
+const (
+	ServiceTypeTsuru = "tsuru"
+	ServiceTypeOSB   = "osb"
+)
+
 var (
 	ErrServiceAlreadyExists = errors.New("Service already exists.")
+	ErrInvalidServiceType   = errors.New("Invalid service type, must be tsuru or osb.")
 )
 
+// ServiceClient is the client used by tsuru to manage instances of a
+// service in its endpoint.
+type ServiceClient interface {
+	Create(instance *ServiceInstance, user, requestID string) error
+	Update(instance *ServiceInstance, requestID string) error
+	Destroy(instance *ServiceInstance, requestID string) error
+	BindApp(instance *ServiceInstance, app bind.App, params map[string]string) (map[string]string, error)
+	BindUnit(instance *ServiceInstance, app bind.App, unit bind.Unit) error
+	UnbindApp(instance *ServiceInstance, app bind.App) error
+	UnbindUnit(instance *ServiceInstance, app bind.App, unit bind.Unit) error
+	Status(instance *ServiceInstance, requestID string) (string, error)
+	Info(instance *ServiceInstance, requestID string) ([]map[string]string, error)
+	Plans(requestID string) ([]Plan, error)
+	Proxy(path string, w http.ResponseWriter, r *http.Request) error
+}
+
+// ValidType returns whether the type of the service is known.
+func (s *Service) ValidType() bool {
+	switch s.Type {
+	case "", ServiceTypeTsuru, ServiceTypeOSB:
+		return true
+	}
+	return false
+}
+
 func (s *Service) Get() error {
 	conn, err := db.Conn()
 	if err != nil {
@@ -86,6 +121,24 @@ func (s *Service) getClient(endpoint string) (cli *Client, err error) {
 	return
 }
 
+// getServiceClient returns the client for the given endpoint, according to
+// the type of the service.
+func (s *Service) getServiceClient(endpoint string) (ServiceClient, error) {
+	cli, err := s.getClient(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if s.Type == ServiceTypeOSB {
+		return &osbClient{
+			serviceName: cli.serviceName,
+			endpoint:    cli.endpoint,
+			username:    cli.username,
+			password:    cli.password,
+		}, nil
+	}
+	return cli, nil
+}
+
 func (s *Service) GetUsername() string {
 	if s.Username != "" {
 		return s.Username
@@ -179,7 +232,7 @@ type ServiceModel struct {
 // Proxy is a proxy between tsuru and the service.
 // This method allow customized service methods.
 func Proxy(service *Service, path string, w http.ResponseWriter, r *http.Request) error {
-	endpoint, err := service.getClient("production")
+	endpoint, err := service.getServiceClient("production")
 	if err != nil {
 		return err
 	}
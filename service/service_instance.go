@@ -34,6 +34,12 @@ var (
 	instanceNameRegexp           = regexp.MustCompile(`^[A-Za-z][-a-zA-Z0-9_]+$`)
 )
 
+const (
+	InstanceStatePending = "pending"
+	InstanceStateFailed  = "failed"
+	InstanceStateReady   = "ready"
+)
+
 type ServiceInstance struct {
 	Name        string
 	Id          int
@@ -44,6 +50,25 @@ type ServiceInstance struct {
 	Teams       []string
 	TeamOwner   string
 	Description string
+	Parameters  map[string]string `bson:"parameters,omitempty"`
+
+	// State is the provisioning state of the instance, pending while the
+	// service API asynchronously provisions it. Instances created before
+	// asynchronous provisioning existed have no state and are ready.
+	State        string `bson:"state,omitempty"`
+	StateMessage string `bson:"state_message,omitempty"`
+
+	// ProvisionCheck is the schedule of the checks of the instance in the
+	// service API while it's pending.
+	ProvisionCheck *ProvisionCheck `bson:"provision_check,omitempty" json:"-"`
+
+	// BrokerOperation is the asynchronous operation informed by an Open
+	// Service Broker when provisioning or updating the instance.
+	BrokerOperation string `bson:"broker_operation,omitempty"`
+
+	// BindParameters are the parameters sent to the service API when
+	// binding each app, keyed by the name of the app.
+	BindParameters map[string]map[string]string `bson:"bind_parameters,omitempty"`
 }
 
 // DeleteInstance deletes the service instance from the database.
@@ -51,7 +76,7 @@ func DeleteInstance(si *ServiceInstance, requestID string) error {
 	if len(si.Apps) > 0 {
 		return ErrServiceInstanceBound
 	}
-	endpoint, err := si.Service().getClient("production")
+	endpoint, err := si.Service().getServiceClient("production")
 	if err == nil {
 		endpoint.Destroy(si, requestID)
 	}
@@ -63,6 +88,14 @@ func DeleteInstance(si *ServiceInstance, requestID string) error {
 	return conn.ServiceInstances().Remove(bson.M{"name": si.Name, "service_name": si.ServiceName})
 }
 
+// GetState returns the provisioning state of the instance.
+func (si *ServiceInstance) GetState() string {
+	if si.State == "" {
+		return InstanceStateReady
+	}
+	return si.State
+}
+
 func (si *ServiceInstance) GetIdentifier() string {
 	if si.Id != 0 {
 		return strconv.Itoa(si.Id)
@@ -85,12 +118,16 @@ func (si *ServiceInstance) MarshalJSON() ([]byte, error) {
 		"ServiceName": si.ServiceName,
 		"Info":        info,
 		"TeamOwner":   si.TeamOwner,
+		"State":       si.GetState(),
+	}
+	if si.StateMessage != "" {
+		data["StateMessage"] = si.StateMessage
 	}
 	return json.Marshal(&data)
 }
 
 func (si *ServiceInstance) Info(requestID string) (map[string]string, error) {
-	endpoint, err := si.Service().getClient("production")
+	endpoint, err := si.Service().getServiceClient("production")
 	if err != nil {
 		return nil, errors.New("endpoint does not exists")
 	}
@@ -146,15 +183,18 @@ func (si *ServiceInstance) update(update bson.M) error {
 	return conn.ServiceInstances().Update(bson.M{"name": si.Name, "service_name": si.ServiceName}, update)
 }
 
-// BindApp makes the bind between the service instance and an app.
-func (si *ServiceInstance) BindApp(app bind.App, shouldRestart bool, writer io.Writer) error {
+// BindApp makes the bind between the service instance and an app. The given
+// parameters are sent to the service API and stored in the binding.
+func (si *ServiceInstance) BindApp(app bind.App, params map[string]string, shouldRestart bool, writer io.Writer) error {
 	args := bindPipelineArgs{
 		serviceInstance: si,
 		app:             app,
+		params:          params,
 		writer:          writer,
 		shouldRestart:   shouldRestart,
 	}
 	actions := []*action.Action{
+		waitInstanceReadyAction,
 		bindAppDBAction,
 		bindAppEndpointAction,
 		setBoundEnvsAction,
@@ -166,7 +206,7 @@ func (si *ServiceInstance) BindApp(app bind.App, shouldRestart bool, writer io.W
 
 // BindUnit makes the bind between the binder and an unit.
 func (si *ServiceInstance) BindUnit(app bind.App, unit bind.Unit) error {
-	endpoint, err := si.Service().getClient("production")
+	endpoint, err := si.Service().getServiceClient("production")
 	if err != nil {
 		return err
 	}
@@ -217,7 +257,7 @@ func (si *ServiceInstance) UnbindApp(app bind.App, shouldRestart bool, writer io
 
 // UnbindUnit makes the unbind between the service instance and an unit.
 func (si *ServiceInstance) UnbindUnit(app bind.App, unit bind.Unit) error {
-	endpoint, err := si.Service().getClient("production")
+	endpoint, err := si.Service().getServiceClient("production")
 	if err != nil {
 		return err
 	}
@@ -247,7 +287,7 @@ func (si *ServiceInstance) UnbindUnit(app bind.App, unit bind.Unit) error {
 
 // Status returns the service instance status.
 func (si *ServiceInstance) Status(requestID string) (string, error) {
-	endpoint, err := si.Service().getClient("production")
+	endpoint, err := si.Service().getServiceClient("production")
 	if err != nil {
 		return "", err
 	}
@@ -315,7 +355,7 @@ func CreateServiceInstance(instance ServiceInstance, service *Service, user *aut
 		return ErrTeamMandatory
 	}
 	instance.Teams = []string{instance.TeamOwner}
-	actions := []*action.Action{&createServiceInstance, &insertServiceInstance}
+	actions := []*action.Action{&createServiceInstance, &insertServiceInstance, &enqueueProvisionCheck}
 	pipeline := action.NewPipeline(actions...)
 	return pipeline.Execute(*service, instance, user.Email, requestID)
 }
@@ -329,6 +369,118 @@ func UpdateService(si *ServiceInstance) error {
 	return conn.ServiceInstances().Update(bson.M{"name": si.Name, "service_name": si.ServiceName}, si)
 }
 
+// Update changes the description, plan and parameters of the instance, new
+// parameters being merged with the existing ones. Plan and parameter changes
+// are forwarded to the service API before being stored.
+func (si *ServiceInstance) Update(updateData ServiceInstance, requestID string) error {
+	updated := *si
+	if updateData.Description != "" {
+		updated.Description = updateData.Description
+	}
+	forward := false
+	if updateData.PlanName != "" && updateData.PlanName != si.PlanName {
+		updated.PlanName = updateData.PlanName
+		forward = true
+	}
+	if len(updateData.Parameters) > 0 {
+		updated.Parameters = make(map[string]string, len(si.Parameters)+len(updateData.Parameters))
+		for k, v := range si.Parameters {
+			updated.Parameters[k] = v
+		}
+		for k, v := range updateData.Parameters {
+			if si.Parameters[k] != v {
+				forward = true
+			}
+			updated.Parameters[k] = v
+		}
+	}
+	if forward {
+		endpoint, err := si.Service().getServiceClient("production")
+		if err != nil {
+			return err
+		}
+		err = endpoint.Update(&updated, requestID)
+		if err != nil {
+			return err
+		}
+	}
+	err := si.update(bson.M{"$set": bson.M{
+		"description": updated.Description,
+		"plan_name":   updated.PlanName,
+		"parameters":  updated.Parameters,
+	}})
+	if err != nil {
+		return err
+	}
+	*si = updated
+	return nil
+}
+
+// RebindApps asks the service API for the environment variables of each one
+// of the given apps again, updating the apps whose variables changed. It
+// returns the names of the updated apps.
+func (si *ServiceInstance) RebindApps(apps []bind.App, writer io.Writer) ([]string, error) {
+	endpoint, err := si.Service().getServiceClient("production")
+	if err != nil {
+		return nil, err
+	}
+	var updated []string
+	for _, a := range apps {
+		envs, err := endpoint.BindApp(si, a, si.BindParameters[a.GetName()])
+		if err != nil {
+			return updated, err
+		}
+		current := a.InstanceEnv(si.Name)
+		changed := len(current) != len(envs)
+		for name, value := range envs {
+			if env, ok := current[name]; !ok || env.Value != value {
+				changed = true
+				break
+			}
+		}
+		if !changed {
+			continue
+		}
+		err = a.AddInstance(bind.InstanceApp{
+			ServiceName:   si.ServiceName,
+			Instance:      bind.ServiceInstance{Name: si.Name, Envs: envs},
+			ShouldRestart: true,
+		}, writer)
+		if err != nil {
+			return updated, err
+		}
+		updated = append(updated, a.GetName())
+	}
+	return updated, nil
+}
+
+// RebindApp asks the service API for fresh credentials for an app bound to
+// the instance, unbinding and binding it again in the service API with the
+// parameters stored in the binding. The environment variables of the app are
+// swapped with a single restart.
+func (si *ServiceInstance) RebindApp(app bind.App, shouldRestart bool, writer io.Writer) error {
+	if si.FindApp(app.GetName()) == -1 {
+		return ErrAppNotBound
+	}
+	endpoint, err := si.Service().getServiceClient("production")
+	if err != nil {
+		return err
+	}
+	err = endpoint.UnbindApp(si, app)
+	if err != nil && err != ErrInstanceNotFoundInAPI {
+		return err
+	}
+	envs, err := endpoint.BindApp(si, app, si.BindParameters[app.GetName()])
+	if err != nil {
+		return err
+	}
+	return app.AddInstance(bind.InstanceApp{
+		ServiceName:   si.ServiceName,
+		Instance:      bind.ServiceInstance{Name: si.Name, Envs: envs},
+		ShouldRestart: shouldRestart,
+	}, writer)
+}
+
 func GetServiceInstancesByServices(services []Service) ([]ServiceInstance, error) {
 	var instances []ServiceInstance
 	conn, err := db.Conn()
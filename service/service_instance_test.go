@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"runtime"
 	"sort"
 	"strconv"
@@ -129,7 +130,7 @@ func (s *InstanceSuite) TestBindApp(c *check.C) {
 	var si ServiceInstance
 	a := provisiontest.NewFakeApp("myapp", "python", 1)
 	var buf bytes.Buffer
-	err := si.BindApp(a, true, &buf)
+	err := si.BindApp(a, nil, true, &buf)
 	c.Assert(err, check.IsNil)
 	expectedCalls := []string{
 		"bindAppDBAction", "bindAppEndpointAction",
@@ -265,6 +266,7 @@ func (s *InstanceSuite) TestMarshalJSON(c *check.C) {
 		"ServiceName": "mysql",
 		"Info":        map[string]interface{}{"key": "value"},
 		"TeamOwner":   "",
+		"State":       "ready",
 	}
 	c.Assert(result, check.DeepEquals, expected)
 }
@@ -289,6 +291,7 @@ func (s *InstanceSuite) TestMarshalJSONWithoutInfo(c *check.C) {
 		"ServiceName": "mysql",
 		"Info":        nil,
 		"TeamOwner":   "",
+		"State":       "ready",
 	}
 	c.Assert(result, check.DeepEquals, expected)
 }
@@ -313,6 +316,7 @@ func (s *InstanceSuite) TestMarshalJSONWithoutEndpoint(c *check.C) {
 		"ServiceName": "mysql",
 		"Info":        nil,
 		"TeamOwner":   "",
+		"State":       "ready",
 	}
 	c.Assert(result, check.DeepEquals, expected)
 }
@@ -540,6 +544,103 @@ func (s *InstanceSuite) TestUpdateService(c *check.C) {
 	c.Assert(si.Description, check.Equals, "desc")
 }
 
+func (s *InstanceSuite) TestServiceInstanceUpdate(c *check.C) {
+	var requests int32
+	var form url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		r.ParseForm()
+		form = r.Form
+	}))
+	defer ts.Close()
+	srv := Service{Name: "mongodb", Endpoint: map[string]string{"production": ts.URL}}
+	err := s.conn.Services().Insert(&srv)
+	c.Assert(err, check.IsNil)
+	defer s.conn.Services().RemoveId(srv.Name)
+	instance := ServiceInstance{
+		Name:        "instance",
+		ServiceName: "mongodb",
+		PlanName:    "small",
+		TeamOwner:   s.team.Name,
+		Parameters:  map[string]string{"replicas": "2"},
+	}
+	err = instance.Create()
+	c.Assert(err, check.IsNil)
+	err = instance.Update(ServiceInstance{PlanName: "big", Parameters: map[string]string{"size": "10"}}, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(atomic.LoadInt32(&requests), check.Equals, int32(1))
+	c.Assert(form.Get("plan"), check.Equals, "big")
+	c.Assert(form.Get("parameters.replicas"), check.Equals, "2")
+	c.Assert(form.Get("parameters.size"), check.Equals, "10")
+	si, err := GetServiceInstance("mongodb", "instance")
+	c.Assert(err, check.IsNil)
+	c.Assert(si.PlanName, check.Equals, "big")
+	c.Assert(si.Parameters, check.DeepEquals, map[string]string{"replicas": "2", "size": "10"})
+	c.Assert(instance.PlanName, check.Equals, "big")
+}
+
+func (s *InstanceSuite) TestServiceInstanceUpdateDescriptionOnly(c *check.C) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	defer ts.Close()
+	srv := Service{Name: "mongodb", Endpoint: map[string]string{"production": ts.URL}}
+	err := s.conn.Services().Insert(&srv)
+	c.Assert(err, check.IsNil)
+	defer s.conn.Services().RemoveId(srv.Name)
+	instance := ServiceInstance{Name: "instance", ServiceName: "mongodb", PlanName: "small"}
+	err = instance.Create()
+	c.Assert(err, check.IsNil)
+	err = instance.Update(ServiceInstance{Description: "desc", PlanName: "small"}, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(atomic.LoadInt32(&requests), check.Equals, int32(0))
+	si, err := GetServiceInstance("mongodb", "instance")
+	c.Assert(err, check.IsNil)
+	c.Assert(si.Description, check.Equals, "desc")
+	c.Assert(si.PlanName, check.Equals, "small")
+}
+
+func (s *InstanceSuite) TestServiceInstanceUpdateServiceAPIError(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("no such plan"))
+	}))
+	defer ts.Close()
+	srv := Service{Name: "mongodb", Endpoint: map[string]string{"production": ts.URL}}
+	err := s.conn.Services().Insert(&srv)
+	c.Assert(err, check.IsNil)
+	defer s.conn.Services().RemoveId(srv.Name)
+	instance := ServiceInstance{Name: "instance", ServiceName: "mongodb", PlanName: "small"}
+	err = instance.Create()
+	c.Assert(err, check.IsNil)
+	err = instance.Update(ServiceInstance{PlanName: "huge"}, "")
+	c.Assert(err, check.ErrorMatches, ".*no such plan.*")
+	si, err := GetServiceInstance("mongodb", "instance")
+	c.Assert(err, check.IsNil)
+	c.Assert(si.PlanName, check.Equals, "small")
+	c.Assert(instance.PlanName, check.Equals, "small")
+}
+
+func (s *InstanceSuite) TestServiceInstanceRebindApps(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"DATABASE_HOST":"newhost"}`))
+	}))
+	defer ts.Close()
+	srv := Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := s.conn.Services().Insert(&srv)
+	c.Assert(err, check.IsNil)
+	defer s.conn.Services().RemoveId(srv.Name)
+	instance := ServiceInstance{Name: "my-mysql", ServiceName: "mysql", Apps: []string{"myapp"}}
+	a := provisiontest.NewFakeApp("myapp", "static", 1)
+	updated, err := instance.RebindApps([]bind.App{a}, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(updated, check.DeepEquals, []string{"myapp"})
+	c.Assert(a.GetInstances("mysql"), check.DeepEquals, []bind.ServiceInstance{
+		{Name: "my-mysql", Envs: map[string]string{"DATABASE_HOST": "newhost"}},
+	})
+}
+
 func (s *InstanceSuite) TestStatus(c *check.C) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNoContent)
@@ -847,7 +948,7 @@ func (s *InstanceSuite) TestBindAppFullPipeline(c *check.C) {
 	c.Assert(err, check.IsNil)
 	a := provisiontest.NewFakeApp("myapp", "static", 2)
 	var buf bytes.Buffer
-	err = si.BindApp(a, true, &buf)
+	err = si.BindApp(a, nil, true, &buf)
 	c.Assert(err, check.IsNil)
 	c.Assert(buf.String(), check.Matches, "add instance")
 	c.Assert(reqs, check.HasLen, 3)
@@ -903,7 +1004,7 @@ func (s *InstanceSuite) TestBindAppMultipleApps(c *check.C) {
 		go func(app bind.App) {
 			defer wg.Done()
 			var buf bytes.Buffer
-			bindErr := si.BindApp(app, true, &buf)
+			bindErr := si.BindApp(app, nil, true, &buf)
 			c.Assert(bindErr, check.IsNil)
 		}(app)
 	}
@@ -945,7 +1046,7 @@ func (s *InstanceSuite) TestUnbindAppMultipleApps(c *check.C) {
 		app := provisiontest.NewFakeApp(name, "static", 2)
 		apps = append(apps, app)
 		var buf bytes.Buffer
-		err = si.BindApp(app, true, &buf)
+		err = si.BindApp(app, nil, true, &buf)
 		c.Assert(err, check.IsNil)
 	}
 	siDB, err := GetServiceInstance(si.ServiceName, si.Name)